@@ -0,0 +1,53 @@
+// Package chanutil holds the channel operations every pipeline stage needs:
+// sends and receives that give up when a context is cancelled, ones that do
+// not block at all, and closes that cannot happen twice.
+package chanutil
+
+import "context"
+
+// TrySend sends v on ch if that is possible without blocking and reports
+// whether it did.
+func TrySend[T any](ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	default:
+		return false
+	}
+}
+
+// TryRecv receives from ch if that is possible without blocking. It reports
+// false if nothing was ready or ch is closed.
+func TryRecv[T any](ch <-chan T) (T, bool) {
+	select {
+	case v, ok := <-ch:
+		return v, ok
+	default:
+		var zero T
+		return zero, false
+	}
+}
+
+// SendContext sends v on ch, or returns ctx.Err() if ctx is cancelled first.
+// If both are possible at once either may happen, as with a select.
+func SendContext[T any](ctx context.Context, ch chan<- T, v T) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case ch <- v:
+		return nil
+	}
+}
+
+// RecvContext receives from ch, reporting false if ch is closed, or returns
+// ctx.Err() if ctx is cancelled first. If both are possible at once either
+// may happen, as with a select.
+func RecvContext[T any](ctx context.Context, ch <-chan T) (T, bool, error) {
+	select {
+	case <-ctx.Done():
+		var zero T
+		return zero, false, ctx.Err()
+	case v, ok := <-ch:
+		return v, ok, nil
+	}
+}
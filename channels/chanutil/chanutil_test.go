@@ -0,0 +1,183 @@
+package chanutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"channelspractice/chanutil"
+	"channelspractice/pipelinetest"
+)
+
+// blockedFor is how long a blocked operation is given to return by mistake.
+const blockedFor = 20 * time.Millisecond
+
+// channelState is what a channel offers the operation under test.
+type channelState int
+
+const (
+	ready channelState = iota
+	blocked
+	closed
+)
+
+func (s channelState) String() string {
+	return [...]string{"ready", "blocked", "closed"}[s]
+}
+
+// recvChan returns a channel in state s for receiving: holding 1, empty or
+// closed.
+func recvChan(s channelState) chan int {
+	ch := make(chan int, 1)
+	switch s {
+	case ready:
+		ch <- 1
+	case closed:
+		close(ch)
+	}
+	return ch
+}
+
+// sendChan returns a channel in state s for sending: with room or full.
+func sendChan(s channelState) chan int {
+	ch := make(chan int, 1)
+	if s == blocked {
+		ch <- 0
+	}
+	return ch
+}
+
+func cancelledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestTrySend(t *testing.T) {
+	for _, s := range []channelState{ready, blocked} {
+		ch := sendChan(s)
+		if got := chanutil.TrySend(ch, 1); got != (s == ready) {
+			t.Errorf("%v: TrySend = %v", s, got)
+		}
+	}
+}
+
+func TestTryRecv(t *testing.T) {
+	for _, tc := range []struct {
+		state channelState
+		want  int
+		ok    bool
+	}{
+		{ready, 1, true},
+		{blocked, 0, false},
+		{closed, 0, false},
+	} {
+		if got, ok := chanutil.TryRecv(recvChan(tc.state)); got != tc.want || ok != tc.ok {
+			t.Errorf("%v: TryRecv = %d, %v, want %d, %v", tc.state, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestSendContext(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	t.Run("ready", func(t *testing.T) {
+		ch := sendChan(ready)
+		if err := chanutil.SendContext(context.Background(), ch, 1); err != nil || len(ch) != 1 {
+			t.Errorf("SendContext = %v, sent %d", err, len(ch))
+		}
+	})
+	t.Run("blocked then cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error, 1)
+		go func() { result <- chanutil.SendContext(ctx, sendChan(blocked), 1) }()
+		select {
+		case err := <-result:
+			t.Fatalf("SendContext = %v on a full channel", err)
+		case <-time.After(blockedFor):
+		}
+		cancel()
+		if err := <-result; !errors.Is(err, context.Canceled) {
+			t.Errorf("SendContext = %v, want context.Canceled", err)
+		}
+	})
+	t.Run("blocked then received", func(t *testing.T) {
+		ch := make(chan int)
+		result := make(chan error, 1)
+		go func() { result <- chanutil.SendContext(context.Background(), ch, 1) }()
+		if got := <-ch; got != 1 {
+			t.Errorf("received %d, want 1", got)
+		}
+		if err := <-result; err != nil {
+			t.Errorf("SendContext = %v", err)
+		}
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		if err := chanutil.SendContext(cancelledContext(), sendChan(blocked), 1); !errors.Is(err, context.Canceled) {
+			t.Errorf("SendContext = %v, want context.Canceled", err)
+		}
+	})
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), blockedFor)
+		defer cancel()
+		if err := chanutil.SendContext(ctx, sendChan(blocked), 1); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("SendContext = %v, want context.DeadlineExceeded", err)
+		}
+	})
+}
+
+func TestRecvContext(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	for _, tc := range []struct {
+		state channelState
+		want  int
+		ok    bool
+	}{
+		{ready, 1, true},
+		{closed, 0, false},
+	} {
+		got, ok, err := chanutil.RecvContext(context.Background(), recvChan(tc.state))
+		if got != tc.want || ok != tc.ok || err != nil {
+			t.Errorf("%v: RecvContext = %d, %v, %v, want %d, %v, nil", tc.state, got, ok, err, tc.want, tc.ok)
+		}
+	}
+	t.Run("blocked then cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error, 1)
+		go func() {
+			_, _, err := chanutil.RecvContext(ctx, recvChan(blocked))
+			result <- err
+		}()
+		select {
+		case err := <-result:
+			t.Fatalf("RecvContext = %v on an empty channel", err)
+		case <-time.After(blockedFor):
+		}
+		cancel()
+		if err := <-result; !errors.Is(err, context.Canceled) {
+			t.Errorf("RecvContext = %v, want context.Canceled", err)
+		}
+	})
+	t.Run("blocked then closed", func(t *testing.T) {
+		ch := make(chan int)
+		result := make(chan error, 1)
+		go func() {
+			_, ok, err := chanutil.RecvContext(context.Background(), ch)
+			if ok {
+				err = errors.New("received from a closed channel")
+			}
+			result <- err
+		}()
+		time.Sleep(blockedFor)
+		close(ch)
+		if err := <-result; err != nil {
+			t.Errorf("RecvContext = %v, want closed without an error", err)
+		}
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		// A closed channel and a cancelled context are told apart.
+		if _, ok, err := chanutil.RecvContext(cancelledContext(), recvChan(blocked)); ok || !errors.Is(err, context.Canceled) {
+			t.Errorf("RecvContext = %v, %v, want context.Canceled", ok, err)
+		}
+	})
+}
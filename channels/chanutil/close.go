@@ -0,0 +1,112 @@
+package chanutil
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// CloseOnce is a channel that can be closed any number of times, from any
+// goroutine: only the first Close closes C. It suits stages that finish along
+// several paths, each of which would otherwise have to know whether another
+// closed the channel already. Create one with NewCloseOnce; it must not be
+// copied.
+type CloseOnce[T any] struct {
+	// C is the channel. Sending on it after Close panics, as it would with
+	// a plain channel.
+	C chan T
+
+	once   sync.Once
+	closed atomic.Bool
+}
+
+// NewCloseOnce wraps ch, which must not be closed other than through the
+// returned CloseOnce.
+func NewCloseOnce[T any](ch chan T) *CloseOnce[T] {
+	return &CloseOnce[T]{C: ch}
+}
+
+// Close closes C unless it was closed already.
+func (c *CloseOnce[T]) Close() {
+	c.once.Do(func() {
+		close(c.C)
+		c.closed.Store(true)
+	})
+}
+
+// IsClosed reports whether Close was called. A receiver may see C closed a
+// moment before IsClosed reports it.
+func (c *CloseOnce[T]) IsClosed() bool {
+	return c.closed.Load()
+}
+
+// RefCloser is a channel closed once the last of its references is released,
+// for a channel with senders that come and go, such as the input of a stage
+// that failed items are fed back into: the source holds a reference until it
+// is done, and every item holds one from before it is sent until no one may
+// send it again. Create one with NewRefCloser; it must not be copied.
+type RefCloser[T any] struct {
+	// C is the channel. It must not be closed other than by the
+	// RefCloser.
+	C chan T
+
+	mu     sync.Mutex
+	refs   int
+	closed bool
+}
+
+// NewRefCloser wraps ch with refs references held, which must be at least 1.
+func NewRefCloser[T any](ch chan T, refs int) *RefCloser[T] {
+	if refs < 1 {
+		panic("chanutil: RefCloser needs a reference to start with")
+	}
+	return &RefCloser[T]{C: ch, refs: refs}
+}
+
+// Add takes n more references. It panics once C is closed, as nothing may be
+// sent on it any more.
+func (c *RefCloser[T]) Add(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		panic("chanutil: RefCloser.Add after the last reference was released")
+	}
+	c.refs += n
+}
+
+// IsClosed reports whether the last reference was released.
+func (c *RefCloser[T]) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Done releases a reference, closing C if it was the last one.
+func (c *RefCloser[T]) Done() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.refs--; {
+	case c.refs == 0:
+		c.closed = true
+		close(c.C)
+	case c.refs < 0:
+		panic("chanutil: RefCloser released more references than it held")
+	}
+}
+
+// DrainAndClose discards the items waiting in ch's buffer and closes it, so
+// that no receiver still gets them. It stops discarding once ctx is
+// cancelled, leaving what is left for the receivers, but closes ch either
+// way, and returns how many items it discarded. Whoever sends on ch must have
+// stopped, as with any close.
+func DrainAndClose[T any](ctx context.Context, ch chan T) int {
+	defer close(ch)
+	n := 0
+	for ctx.Err() == nil {
+		if _, ok := TryRecv(ch); !ok {
+			break
+		}
+		n++
+	}
+	return n
+}
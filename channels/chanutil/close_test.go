@@ -0,0 +1,138 @@
+package chanutil_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/chanutil"
+	"channelspractice/pipelinetest"
+)
+
+func TestCloseOnceTwice(t *testing.T) {
+	c := chanutil.NewCloseOnce(make(chan int, 1))
+	if c.IsClosed() {
+		t.Fatal("IsClosed before Close")
+	}
+	c.C <- 1
+	c.Close()
+	c.Close()
+
+	if !c.IsClosed() {
+		t.Error("IsClosed = false after Close")
+	}
+	if got := pipelinetest.CollectWithTimeout(t, c.C, time.Second); len(got) != 1 || got[0] != 1 {
+		t.Errorf("received %v, want the buffered [1]", got)
+	}
+}
+
+func TestCloseOnceConcurrent(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	c := chanutil.NewCloseOnce(make(chan struct{}))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			c.Close()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	pipelinetest.AssertClosed(t, c.C, time.Second)
+	if !c.IsClosed() {
+		t.Error("IsClosed = false after Close")
+	}
+}
+
+func TestDrainAndClose(t *testing.T) {
+	ch := make(chan int, 3)
+	ch <- 1
+	ch <- 2
+	if n := chanutil.DrainAndClose(context.Background(), ch); n != 2 {
+		t.Errorf("DrainAndClose discarded %d items, want 2", n)
+	}
+	if got := pipelinetest.CollectWithTimeout(t, ch, time.Second); len(got) != 0 {
+		t.Errorf("received %v after DrainAndClose, want nothing", got)
+	}
+}
+
+func TestDrainAndCloseCancelled(t *testing.T) {
+	ch := make(chan int, 3)
+	for i := range 3 {
+		ch <- i
+	}
+	// Cancelled, it leaves the items to the receivers but still closes.
+	if n := chanutil.DrainAndClose(cancelledContext(), ch); n != 0 {
+		t.Errorf("DrainAndClose discarded %d items after cancellation, want 0", n)
+	}
+	if got := pipelinetest.CollectWithTimeout(t, ch, time.Second); len(got) != 3 {
+		t.Errorf("received %v after DrainAndClose, want the 3 pending items", got)
+	}
+}
+
+func TestRefCloser(t *testing.T) {
+	c := chanutil.NewRefCloser(make(chan int, 2), 1)
+	c.Add(2)
+	c.C <- 1
+	c.Done()
+	c.C <- 2
+	c.Done()
+	// The source still holds its reference: C stays open.
+	if c.IsClosed() || len(c.C) != 2 {
+		t.Fatalf("closed with the source's reference held")
+	}
+	c.Done()
+	if got := pipelinetest.CollectWithTimeout(t, c.C, time.Second); len(got) != 2 || !c.IsClosed() {
+		t.Errorf("received %v, want both items before the close", got)
+	}
+}
+
+func TestRefCloserConcurrent(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	c := chanutil.NewRefCloser(make(chan int), 1)
+	var wg sync.WaitGroup
+	for i := range 10 {
+		c.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer c.Done()
+			c.C <- i
+		}()
+	}
+	c.Done()
+	if got := pipelinetest.CollectWithTimeout(t, c.C, time.Second); len(got) != 10 {
+		t.Errorf("received %v, want all 10 items", got)
+	}
+	wg.Wait()
+}
+
+func TestRefCloserMisuse(t *testing.T) {
+	for name, misuse := range map[string]func(){
+		"no reference": func() { chanutil.NewRefCloser(make(chan int), 0) },
+		"add after close": func() {
+			c := chanutil.NewRefCloser(make(chan int), 1)
+			c.Done()
+			c.Add(1)
+		},
+		"released twice": func() {
+			c := chanutil.NewRefCloser(make(chan int, 1), 1)
+			c.Done()
+			c.Done()
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic")
+				}
+			}()
+			misuse()
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package chanutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// WaitContext waits for wg like wg.Wait, or returns ctx.Err() if ctx is
+// cancelled first. Giving up does not stop the wait itself: a goroutine keeps
+// waiting for wg and exits only once the group is done, so a group that never
+// finishes leaks it.
+func WaitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// PanicError is a panic recovered from a goroutine of a Group.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("goroutine panicked: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Group is a sync.WaitGroup that starts the goroutines it waits for, can be
+// waited for with a context and turns their panics into errors. The zero
+// value is an empty group.
+type Group struct {
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	panics []error
+}
+
+// Go runs fn in a goroutine of the group. A panic in fn ends only that
+// goroutine and is reported by Wait.
+func (g *Group) Go(fn func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			if v := recover(); v != nil {
+				g.mu.Lock()
+				g.panics = append(g.panics, &PanicError{Value: v, Stack: debug.Stack()})
+				g.mu.Unlock()
+			}
+		}()
+		fn()
+	}()
+}
+
+// Wait waits for every goroutine started with Go, with the caveat of
+// WaitContext if ctx is cancelled first, in which case it returns ctx.Err().
+// Otherwise it returns the panics of the goroutines as *PanicError joined
+// with errors.Join, or nil if none panicked.
+func (g *Group) Wait(ctx context.Context) error {
+	if err := WaitContext(ctx, &g.wg); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return errors.Join(g.panics...)
+}
@@ -0,0 +1,86 @@
+package chanutil_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/chanutil"
+	"channelspractice/pipelinetest"
+)
+
+func TestWaitContextCompletes(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	time.AfterFunc(blockedFor, wg.Done)
+	if err := chanutil.WaitContext(context.Background(), &wg); err != nil {
+		t.Errorf("WaitContext = %v, want nil", err)
+	}
+}
+
+func TestWaitContextCancelled(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	// Let the watcher go once the test is done.
+	defer wg.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), blockedFor)
+	defer cancel()
+	if err := chanutil.WaitContext(ctx, &wg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitContext = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestGroupWaitsForAll(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var g chanutil.Group
+	var done atomic.Int32
+	for i := range 5 {
+		g.Go(func() {
+			time.Sleep(time.Duration(i) * time.Millisecond)
+			done.Add(1)
+		})
+	}
+	if err := g.Wait(context.Background()); err != nil {
+		t.Errorf("Wait = %v, want nil", err)
+	}
+	if n := done.Load(); n != 5 {
+		t.Errorf("%d goroutines done when Wait returned, want 5", n)
+	}
+}
+
+func TestGroupWaitCancelled(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var g chanutil.Group
+	release := make(chan struct{})
+	g.Go(func() { <-release })
+	defer close(release)
+	if err := g.Wait(cancelledContext()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait = %v, want context.Canceled", err)
+	}
+}
+
+func TestGroupPanic(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	errBoom := errors.New("boom")
+	var g chanutil.Group
+	var finished atomic.Bool
+	g.Go(func() { panic(errBoom) })
+	g.Go(func() {
+		time.Sleep(blockedFor)
+		finished.Store(true)
+	})
+
+	err := g.Wait(context.Background())
+	var panicErr *chanutil.PanicError
+	if !errors.As(err, &panicErr) || !errors.Is(err, errBoom) || len(panicErr.Stack) == 0 {
+		t.Fatalf("Wait = %v, want a *PanicError wrapping errBoom with its stack", err)
+	}
+	if !finished.Load() {
+		t.Error("Wait returned before the goroutine that did not panic")
+	}
+}
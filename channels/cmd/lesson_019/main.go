@@ -2,129 +2,101 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"os/signal"
-	"sync"
-	"syscall"
-	"time"
+	"io"
+	"os"
+	"strconv"
+
+	"channelspractice/internal/lessons/lesson019"
+	"channelspractice/pipeline"
 )
 
 func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
-	nums := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
-	genChan := generator(ctx, nums)
-	transChan, transErrChan := transform(ctx, genChan)
-	doneChan, saveErrChan := save(ctx, transChan)
-	mergedErrChan := mergeErrorChannels(ctx, transErrChan, saveErrChan)
-
-	for {
-		select {
-		case err, ok := <-mergedErrChan:
-			if !ok {
-				mergedErrChan = nil
-				continue
-			}
-			fmt.Printf("error: %v\n", err)
-			cancel()
-		case <-doneChan:
-			fmt.Printf("finished processing\n")
-			return
-		}
+	cfg, err := parseFlags(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	// Run has logged its error.
+	os.Exit(exitCode(lesson019.Run(context.Background(), os.Stdout, cfg)))
 }
 
-func mergeErrorChannels(ctx context.Context, errChans ...<-chan error) <-chan error {
-	merged := make(chan error)
-
-	var wg sync.WaitGroup
-	wg.Add(len(errChans))
-
-	for _, errChan := range errChans {
-		go func(e <-chan error) {
-			defer wg.Done()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case err, ok := <-e:
-					if !ok {
-						return
-					}
-					merged <- err
-					return
-				}
-			}
-		}(errChan)
+// exitCode is the exit status for the error of a run: 0 for a success or a
+// drain nobody signalled for, 130, as a shell reports an interrupt, for a
+// drain after SIGINT or SIGTERM, and 1 for a stage failure or anything else
+// that went wrong, such as items lost to the drain timeout.
+func exitCode(err error) int {
+	switch {
+	case err == nil, errors.Is(err, pipeline.ErrCancelled):
+		return 0
+	case errors.Is(err, pipeline.ErrSignalled):
+		return 130
 	}
-
-	go func() {
-		defer close(merged)
-		wg.Wait()
-	}()
-
-	return merged
+	return 1
 }
 
-func generator(ctx context.Context, nums []int) <-chan int {
-	outChan := make(chan int)
-	go func() {
-		defer close(outChan)
-		for _, num := range nums {
-			select {
-			case <-ctx.Done():
-				return
-			case outChan <- num:
-			}
-		}
-	}()
-	return outChan
-}
+func parseFlags(args []string, stderr io.Writer) (lesson019.Config, error) {
+	def := lesson019.DefaultConfig()
+	fs := flag.NewFlagSet("lesson_019", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	count := fs.Int("count", len(def.Nums), "generate the numbers 0 to count-1")
+	failOn := fs.String("fail-on", strconv.Itoa(*def.FailOn), "number that makes transform fail, empty for none")
+	workers := fs.Int("workers", def.Workers, "transform workers")
+	buffer := fs.Int("buffer", def.Buffer, "buffer size of the channels between stages")
+	seed := fs.Int64("seed", def.Seed, "shuffle the generated numbers with this seed, 0 keeps them in order")
+	shard := fs.String("shard", "", "process only shard index/count of the shuffled numbers, e.g. 2/4, empty for all")
+	configPath := fs.String("config", "", "JSON config file, re-read on SIGHUP")
+	drainTimeout := fs.Duration("drain-timeout", def.DrainTimeout, "time in-flight items get to drain after a signal")
+	metricsAddr := fs.String("metrics-addr", "", "serve stage metrics on /debug/vars at this address, e.g. :6060")
+	debug := fs.Bool("debug", false, "log every processed item")
+	showProgress := fs.Bool("progress", false, "print a progress line on stderr")
+	dot := fs.Bool("dot", false, "print the pipeline in Graphviz DOT and exit")
+	trace := fs.Int("trace", 0, "print the last n items of every stage on stderr after a failure")
+	report := fs.Bool("report", false, "print a report of every stage on stderr when the pipeline is done")
+	if err := fs.Parse(args); err != nil {
+		return lesson019.Config{}, err
+	}
 
-func transform(ctx context.Context, inChan <-chan int) (<-chan int, <-chan error) {
-	outChan := make(chan int)
-	errChan := make(chan error)
-	go func() {
-		defer close(errChan)
-		defer close(outChan)
-		for {
-			time.Sleep(100 * time.Millisecond)
-			select {
-			case <-ctx.Done():
-				return
-			case num, ok := <-inChan:
-				if !ok {
-					return
-				}
-				if num == 6 {
-					errChan <- fmt.Errorf("number %d is invalid", num)
-					return
-				}
-				outChan <- num * 2
-			}
+	if *count < 0 {
+		return lesson019.Config{}, fmt.Errorf("count must not be negative, got %d", *count)
+	}
+	cfg := lesson019.Config{
+		Nums:         make([]int, *count),
+		Workers:      *workers,
+		Buffer:       *buffer,
+		Seed:         *seed,
+		ConfigPath:   *configPath,
+		DrainTimeout: *drainTimeout,
+		MetricsAddr:  *metricsAddr,
+		Debug:        *debug,
+		Progress:     *showProgress,
+		DOT:          *dot,
+		Trace:        *trace,
+		Report:       *report,
+	}
+	for i := range cfg.Nums {
+		cfg.Nums[i] = i
+	}
+	if *shard != "" {
+		var err error
+		if cfg.Shard, err = pipeline.ParseShard(*shard); err != nil {
+			return lesson019.Config{}, err
 		}
-	}()
-	return outChan, errChan
-
-}
-
-func save(ctx context.Context, inChan <-chan int) (<-chan struct{}, <-chan error) {
-	doneChan := make(chan struct{})
-	errChan := make(chan error)
-	go func() {
-		defer close(errChan)
-		defer close(doneChan)
-		for {
-			time.Sleep(100 * time.Millisecond)
-			select {
-			case <-ctx.Done():
-				return
-			case num, ok := <-inChan:
-				if !ok {
-					return
-				}
-				fmt.Printf("saved %d\n", num)
-			}
+	}
+	if *failOn != "" {
+		n, err := strconv.Atoi(*failOn)
+		if err != nil {
+			return lesson019.Config{}, fmt.Errorf("fail-on must be a number or empty: %w", err)
 		}
-	}()
-	return doneChan, errChan
+		if n < 0 || n >= *count {
+			return lesson019.Config{}, fmt.Errorf("fail-on %d is not one of the %d generated numbers", n, *count)
+		}
+		cfg.FailOn = &n
+	}
+	return cfg, cfg.Validate()
 }
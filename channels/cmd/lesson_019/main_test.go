@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+
+	"channelspractice/internal/lessons/lesson019"
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestParseFlagsDefaults(t *testing.T) {
+	cfg, err := parseFlags(nil, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cfg.Nums, pipelinetest.Items(11)) || cfg.FailOn == nil || *cfg.FailOn != 6 || cfg.Workers != 1 || cfg.Buffer != 0 {
+		t.Errorf("defaults = %+v, want the original demo: 0 to 10 failing on 6 with one worker", cfg)
+	}
+}
+
+func TestParseFlagsInvalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"-workers=0"},
+		{"-buffer=-1"},
+		{"-count=-1"},
+		{"-fail-on=six"},
+		{"-count=5", "-fail-on=5"},
+		{"-trace=-1"},
+		{"-shard=4/4"},
+		{"-shard=0/0"},
+		{"-shard=two/4"},
+		{"-shard=2"},
+		{"-unknown"},
+	} {
+		if _, err := parseFlags(args, io.Discard); err == nil {
+			t.Errorf("parseFlags(%q) accepted invalid flags", args)
+		}
+	}
+}
+
+func TestParseFlagsShard(t *testing.T) {
+	cfg, err := parseFlags([]string{"-shard=2/4"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (pipeline.Shard{Index: 2, Count: 4}); cfg.Shard != want {
+		t.Errorf("-shard=2/4 gave %v, want %v", cfg.Shard, want)
+	}
+	if cfg, _ := parseFlags(nil, io.Discard); cfg.Shard != (pipeline.Shard{}) {
+		t.Errorf("default shard %v, want every number", cfg.Shard)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{fmt.Errorf("stopped: %w", pipeline.ErrCancelled), 0},
+		{fmt.Errorf("stopped: %w", pipeline.ErrSignalled), 130},
+		{fmt.Errorf("%w: %w", pipeline.ErrStageFailed, &pipeline.StageError{Stage: "transform", Err: errors.New("number 6 is invalid")}), 1},
+		{&pipeline.StageError{Stage: "transform", Err: pipeline.ErrThresholdExceeded}, 1},
+		{pipeline.ErrDrainTimeout, 1},
+		{errors.New("invalid configuration"), 1},
+	} {
+		if got := exitCode(tc.err); got != tc.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestExitCodeOfRun(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	cfg, err := parseFlags([]string{"-count=2", "-fail-on="}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := exitCode(lesson019.Run(context.Background(), io.Discard, cfg)); code != 0 {
+		t.Errorf("exit code of a clean run %d, want 0", code)
+	}
+
+	signalled, cancel := context.WithCancelCause(context.Background())
+	cancel(pipeline.ErrSignalled)
+	if code := exitCode(lesson019.Run(signalled, io.Discard, cfg)); code != 130 {
+		t.Errorf("exit code after a signal %d, want 130", code)
+	}
+}
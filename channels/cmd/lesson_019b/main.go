@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
@@ -11,72 +14,79 @@ import (
 )
 
 func main() {
-	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
-	g, ctx := errgroup.WithContext(signalCtx)
+	failOn := flag.Int("fail-on", 6, "number that makes transform fail, -1 to disable")
+	flag.Parse()
 
-	nums := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
-	generatorChan := generator(ctx, nums, g)
-	transformChan := transform(ctx, generatorChan, g)
-	save(ctx, transformChan, g)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	if err := g.Wait(); err != nil {
-		fmt.Printf("pipeline error: %v\n", err)
+	nums := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if err := run(ctx, nums, *failOn, os.Stdout); err != nil {
+		fmt.Printf("error: %v\n", err)
 		return
 	}
 
 	fmt.Printf("successfully finished processing\n")
 }
 
-func generator(ctx context.Context, nums []int, g *errgroup.Group) <-chan int {
-	outChan := make(chan int)
+func run(ctx context.Context, nums []int, failOn int, w io.Writer) error {
+	g, ctx := errgroup.WithContext(ctx)
+
+	genChan := make(chan int)
+	transChan := make(chan int)
+
 	g.Go(func() error {
-		defer close(outChan)
-		for _, num := range nums {
-			time.Sleep(100 * time.Millisecond)
-			select {
-			case <-ctx.Done():
-				return nil
-			case outChan <- num:
-			}
-		}
-		return nil
+		defer close(genChan)
+		return generator(ctx, nums, genChan)
+	})
+	g.Go(func() error {
+		defer close(transChan)
+		return transform(ctx, genChan, transChan, failOn)
+	})
+	g.Go(func() error {
+		return save(ctx, transChan, w)
 	})
-	return outChan
-}
 
-func transform(ctx context.Context, inChan <-chan int, g *errgroup.Group) <-chan int {
-	outChan := make(chan int)
+	return g.Wait()
+}
 
-	g.Go(func() error {
-		defer close(outChan)
-		for num := range inChan {
-			if num == 6 {
-				return fmt.Errorf("transform error: number %d is invalid", num)
-			}
-			time.Sleep(100 * time.Millisecond)
-			select {
-			case <-ctx.Done():
-				return nil
-			case outChan <- num * 2:
-			}
+func generator(ctx context.Context, nums []int, out chan<- int) error {
+	for _, num := range nums {
+		select {
+		case <-ctx.Done():
+			return nil
+		case out <- num:
 		}
-		return nil
-	})
+	}
+	return nil
+}
 
-	return outChan
+func transform(ctx context.Context, in <-chan int, out chan<- int, failOn int) error {
+	for num := range in {
+		time.Sleep(100 * time.Millisecond)
+		if num == failOn {
+			return fmt.Errorf("number %d is invalid", num)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case out <- num * 2:
+		}
+	}
+	return nil
 }
 
-func save(ctx context.Context, inChan <-chan int, g *errgroup.Group) {
-	g.Go(func() error {
-		for num := range inChan {
-			select {
-			case <-ctx.Done():
+func save(ctx context.Context, in <-chan int, w io.Writer) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case num, ok := <-in:
+			if !ok {
 				return nil
-			default:
-				fmt.Printf("saved: %d\n", num)
 			}
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprintf(w, "saved %d\n", num)
 		}
-		return nil
-	})
+	}
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunHappyPath(t *testing.T) {
+	var out bytes.Buffer
+	if err := run(context.Background(), []int{0, 1, 2, 3}, -1, &out); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if want := "saved 0\nsaved 2\nsaved 4\nsaved 6\n"; out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+}
+
+func TestRunFailurePath(t *testing.T) {
+	var out bytes.Buffer
+	err := run(context.Background(), []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 6, &out)
+	if err == nil || err.Error() != "number 6 is invalid" {
+		t.Fatalf("run = %v, want the failure of 6", err)
+	}
+	// The save of 10 races with the failure, nothing after it is saved.
+	if !strings.HasPrefix("saved 0\nsaved 2\nsaved 4\nsaved 6\nsaved 8\nsaved 10\n", out.String()) {
+		t.Errorf("output %q saved items past the failure", out.String())
+	}
+}
+
+func TestTransformStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int, 1)
+	in <- 1
+	cancel()
+	// Nobody reads out: only the cancelled context lets transform return.
+	if err := transform(ctx, in, make(chan int), -1); err != nil {
+		t.Errorf("transform = %v, want nil after cancellation", err)
+	}
+}
+
+func TestSaveWritesEveryItem(t *testing.T) {
+	in := make(chan int, 2)
+	in <- 4
+	in <- 8
+	close(in)
+	var out bytes.Buffer
+	if err := save(context.Background(), in, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if want := "saved 4\nsaved 8\n"; out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+}
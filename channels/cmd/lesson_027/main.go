@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"channelspractice/internal/lessons/lesson027"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := lesson027.Run(ctx, os.Stdout, lesson027.DefaultConfig()); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
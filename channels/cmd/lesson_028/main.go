@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"channelspractice/internal/lessons/lesson028"
+)
+
+// Pipes stdin through the pipeline, e.g.
+//
+//	cat file | go run ./channels/cmd/lesson_028
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := lesson028.Run(ctx, os.Stdout, lesson028.Config{In: os.Stdin}); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"channelspractice/internal/lessons/lesson029"
+)
+
+func main() {
+	cfg := lesson029.DefaultConfig()
+	flag.StringVar(&cfg.Root, "root", cfg.Root, "directory to walk")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// Run reports every error and carries on.
+	lesson029.Run(ctx, os.Stdout, cfg)
+}
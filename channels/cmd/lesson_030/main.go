@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"channelspractice/internal/lessons/lesson030"
+)
+
+// Fetches the URLs given as arguments, e.g.
+//
+//	go run ./channels/cmd/lesson_030 https://go.dev https://example.com
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: lesson_030 URL...")
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := lesson030.Run(ctx, os.Stdout, lesson030.DefaultConfig(os.Args[1:]...)); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"channelspractice/internal/lessons/lesson031"
+)
+
+// Doubles a column of a CSV read from stdin and writes the result to stdout,
+// e.g.
+//
+//	printf 'name,value\na,1\nb,2\n' | go run ./channels/cmd/lesson_031 -column 1
+func main() {
+	cfg := lesson031.Config{In: os.Stdin}
+	flag.IntVar(&cfg.Column, "column", 1, "zero-based index of the column to double")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// Run reports every error and carries on.
+	lesson031.Run(ctx, os.Stdout, cfg)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"channelspractice/internal/lessons/lesson037"
+)
+
+func main() {
+	cfg := lesson037.DefaultConfig()
+	flag.IntVar(&cfg.FailSave, "fail-save", cfg.FailSave, "saved number that makes the save sink fail, -1 to disable")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := lesson037.Run(ctx, os.Stdout, cfg); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"channelspractice/internal/lessons/lesson039"
+)
+
+func main() {
+	cfg := lesson039.DefaultConfig()
+	flag.BoolVar(&cfg.Bulkheads, "bulkheads", cfg.Bulkheads, "decouple the save and audit branches with bulkheads")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := lesson039.Run(ctx, os.Stdout, cfg); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"channelspractice/internal/lessons/lesson040"
+)
+
+// Hashes a file through the pipeline, e.g.
+//
+//	go run ./channels/cmd/lesson_040 -file go.sum
+func main() {
+	cfg := lesson040.DefaultConfig("")
+	flag.StringVar(&cfg.Path, "file", cfg.Path, "file to hash")
+	flag.IntVar(&cfg.ChunkSize, "chunk-size", cfg.ChunkSize, "bytes read per chunk")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := lesson040.Run(ctx, os.Stdout, cfg); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
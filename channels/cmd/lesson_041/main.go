@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"channelspractice/internal/lessons/lesson041"
+)
+
+// Compresses a file through the pipeline and decompresses it again, e.g.
+//
+//	go run ./channels/cmd/lesson_041 -file go.sum
+func main() {
+	var cfg lesson041.Config
+	flag.StringVar(&cfg.Path, "file", "", "file to compress into <file>.gz")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := lesson041.Run(ctx, os.Stdout, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"channelspractice/internal/lessons/lesson045"
+)
+
+// Saves the doubled numbers to a file, one per line, e.g.
+//
+//	go run ./channels/cmd/lesson_045 -out doubled.txt
+//
+// The file only appears once every number was written; interrupt the run
+// and it is not created at all.
+func main() {
+	cfg := lesson045.DefaultConfig()
+	flag.StringVar(&cfg.Path, "out", cfg.Path, "file to write the doubled numbers to")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := lesson045.Run(ctx, os.Stdout, cfg); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"channelspractice/internal/lessons/lesson047"
+)
+
+// Pause the generator with kill -USR1 <pid> and resume it with kill -USR2
+// <pid>; the numbers already generated are still transformed and saved.
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+	fmt.Printf("pid %d: kill -USR1 to pause, kill -USR2 to resume\n", os.Getpid())
+
+	if err := lesson047.Run(ctx, os.Stdout, lesson047.DefaultConfig(sigs)); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"channelspractice/internal/lessons/lesson049"
+)
+
+// Numbers are pushed into the running pipeline over HTTP:
+//
+//	curl -d 7 localhost:8080/push
+//	curl -X POST localhost:8080/close
+//
+// Closing the feeder lets the pushed numbers drain and ends the run.
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to serve /push and /close on")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "server: %v\n", err)
+		os.Exit(1)
+	}
+	if err := lesson049.Run(ctx, os.Stdout, lesson049.DefaultConfig(ln)); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
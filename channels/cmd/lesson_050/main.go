@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"channelspractice/internal/lessons/lesson050"
+)
+
+// The pipeline of lesson_019 runs one step at a time in an order only -seed
+// decides, so the run, including which stage sees the failure of 6 first,
+// comes out the same every time:
+//
+//	go run ./cmd/lesson_050 -seed 1
+//	go run ./cmd/lesson_050 -seed 2
+func main() {
+	cfg := lesson050.DefaultConfig()
+	flag.Int64Var(&cfg.Seed, "seed", cfg.Seed, "seed picking the order the stages run in")
+	flag.Parse()
+
+	if err := lesson050.Run(context.Background(), os.Stdout, cfg); err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"channelspractice/pipeline"
+)
+
+// The pipeline runs until told otherwise, taking its commands from stdin:
+//
+//	go run ./cmd/lesson_051
+//	push 21
+//	saved 42
+//	pause
+//	stats
+//	resume
+//	drain
+//
+// Closing stdin drains as well, and so does a first SIGINT or SIGTERM; a
+// second one exits right away.
+func main() {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	ctx, cancel := pipeline.ShutdownOnSignal(context.Background(), sigs, func() {
+		fmt.Fprintln(os.Stderr, "forced exit")
+		os.Exit(1)
+	})
+	defer cancel()
+	// repl has printed how the run ended.
+	os.Exit(exitCode(repl(ctx, os.Stdin, os.Stdout, &pipeline.MemorySink[int]{})))
+}
+
+// exitCode is the exit status for the error of repl: 0 for a drain or a quit,
+// 130 for a drain after SIGINT or SIGTERM, and 1 for a failed pipeline.
+func exitCode(err error) int {
+	switch {
+	case err == nil, errors.Is(err, pipeline.ErrCancelled):
+		return 0
+	case errors.Is(err, pipeline.ErrSignalled):
+		return 130
+	}
+	return 1
+}
+
+const help = `commands:
+  push <n>  feed n to the pipeline
+  pause     hold the pushes back
+  resume    let them through again
+  stats     print the stage stats
+  drain     save what was pushed and stop
+  quit      stop right away, dropping what is in flight`
+
+// maxQueued is how many pushes may wait for the generator to take them,
+// e.g. while paused, before push refuses more.
+const maxQueued = 64
+
+var (
+	errNegative = errors.New("negative numbers cannot be transformed")
+	errQuit     = errors.New("quit")
+)
+
+// command is a parsed line of input.
+type command struct {
+	name string
+	// arg is the number to push.
+	arg int
+}
+
+// parseCommand parses a line of input, which holds a command and, for push,
+// its number.
+func parseCommand(line string) (command, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return command{}, nil
+	}
+	cmd := command{name: fields[0]}
+	switch cmd.name {
+	case "push":
+		if len(fields) != 2 {
+			return command{}, errors.New("usage: push <number>")
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return command{}, fmt.Errorf("push: %q is not a number", fields[1])
+		}
+		cmd.arg = n
+	case "pause", "resume", "stats", "drain", "quit", "help":
+		if len(fields) != 1 {
+			return command{}, fmt.Errorf("usage: %s", cmd.name)
+		}
+	default:
+		return command{}, fmt.Errorf("unknown command %q, try help", cmd.name)
+	}
+	return cmd, nil
+}
+
+// repl runs the pipeline from reading the first command from in until it is
+// drained, by the drain command, the end of in or ctx being cancelled, or
+// aborted by quit. Everything it and the stages print goes through a single
+// writer to out. It returns the error of the pipeline, also after a quit,
+// which makes it wrap pipeline.ErrCancelled, or the cause of ctx after a
+// drain it started.
+func repl(ctx context.Context, in io.Reader, out io.Writer, sink pipeline.SinkWriter[int]) error {
+	term := newTerminal(out)
+	defer term.close()
+	s := newSession(term)
+
+	runCtx, quit := context.WithCancelCause(context.Background())
+	defer quit(nil)
+	result := make(chan error, 1)
+	go func() {
+		_, err := s.pipeline(sink).Run(runCtx)
+		// Nothing of the run may print once repl returned.
+		s.feeding.Wait()
+		result <- err
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	lines := readLines(in, done)
+	stop := ctx.Done()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				lines = nil
+				s.drain()
+				continue
+			}
+			if s.exec(line) {
+				quit(errQuit)
+			}
+		case <-stop:
+			stop = nil
+			term.printf("%v, draining", context.Cause(ctx))
+			s.drain()
+		case err := <-result:
+			switch {
+			case err == nil:
+				term.printf("drained")
+			case errors.Is(err, pipeline.ErrCancelled):
+				term.printf("aborted")
+			default:
+				term.printf("error: %v", err)
+			}
+			if err == nil && ctx.Err() != nil {
+				return context.Cause(ctx)
+			}
+			return err
+		}
+	}
+}
+
+// readLines sends the lines of r until it ends or done is closed. A read
+// blocked on r is not interrupted.
+func readLines(r io.Reader, done <-chan struct{}) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			select {
+			case lines <- sc.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// session is the state of the pipeline the commands act on.
+type session struct {
+	term       *terminal
+	controller *pipeline.Controller
+	registry   *pipeline.Registry
+	// pushes queues the pushed numbers for feed, in order, so that a push
+	// held by a pause does not hold up the commands.
+	pushes   chan int
+	draining bool
+	feeding  sync.WaitGroup
+}
+
+func newSession(term *terminal) *session {
+	return &session{
+		term:       term,
+		controller: pipeline.NewController(),
+		registry:   pipeline.NewRegistry(),
+		pushes:     make(chan int, maxQueued),
+	}
+}
+
+// options names a stage and has it report its stats to the registry.
+func (s *session) options(name string, opts ...pipeline.Option) []pipeline.Option {
+	return append([]pipeline.Option{pipeline.WithName(name), pipeline.WithRegistry(s.registry), pipeline.WithMetrics(&pipeline.Metrics{})}, opts...)
+}
+
+// pipeline returns the pipeline of the session: the pushed numbers are
+// doubled and saved to sink. A negative one fails transform, which is
+// reported and skipped.
+func (s *session) pipeline(sink pipeline.SinkWriter[int]) *pipeline.Pipeline {
+	generator := func(ctx context.Context) (<-chan int, <-chan error) {
+		feeder, nums := pipeline.DynamicSource[int](ctx, s.options("generator", pipeline.WithController(s.controller))...)
+		s.feeding.Add(1)
+		go func() {
+			defer s.feeding.Done()
+			s.feed(ctx, feeder)
+		}()
+		return nums, nil
+	}
+	return pipeline.From(generator).
+		Then(transform, s.options("transform", pipeline.WithErrorPolicy(pipeline.SkipAndReport))...).
+		SinkTo(echo{SinkWriter: sink, term: s.term}, s.options("save")...).
+		OnError(func(err error) error {
+			if !errors.Is(err, errNegative) {
+				return err
+			}
+			s.term.printf("error: %v", err)
+			return nil
+		})
+}
+
+// feed pushes the queued numbers to feeder until drain stops the queue, and
+// then closes the feeder, or until ctx is cancelled.
+func (s *session) feed(ctx context.Context, feeder *pipeline.Feeder[int]) {
+	defer feeder.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n, ok := <-s.pushes:
+			if !ok {
+				return
+			}
+			// Only a cancellation fails the push: nothing but feed
+			// closes the feeder.
+			if feeder.Push(ctx, n) != nil {
+				return
+			}
+		}
+	}
+}
+
+// exec runs the command on line and reports whether it was quit.
+func (s *session) exec(line string) (quit bool) {
+	cmd, err := parseCommand(line)
+	if err != nil {
+		s.term.printf("%v", err)
+		return false
+	}
+	switch cmd.name {
+	case "push":
+		if s.draining {
+			s.term.printf("push %d: draining", cmd.arg)
+			break
+		}
+		select {
+		case s.pushes <- cmd.arg:
+		default:
+			s.term.printf("push %d: %d pushes are waiting already", cmd.arg, maxQueued)
+		}
+	case "pause":
+		s.controller.Pause()
+		s.term.printf("%v", s.controller.State())
+	case "resume":
+		s.controller.Resume()
+		s.term.printf("%v", s.controller.State())
+	case "stats":
+		// One write, so that no saved line ends up inside the table.
+		var buf bytes.Buffer
+		s.registry.WriteStats(&buf)
+		s.term.Write(buf.Bytes())
+	case "drain":
+		s.term.printf("draining")
+		s.drain()
+	case "quit":
+		s.term.printf("aborting")
+		return true
+	case "help":
+		s.term.printf("%s", help)
+	}
+	return false
+}
+
+// drain stops taking pushes, so that the pipeline ends once the queued ones
+// are saved. It resumes a paused generator, which would hold them back
+// forever otherwise.
+func (s *session) drain() {
+	if s.draining {
+		return
+	}
+	s.draining = true
+	close(s.pushes)
+	s.controller.Resume()
+}
+
+func transform(_ context.Context, num int) (int, error) {
+	if num < 0 {
+		return 0, errNegative
+	}
+	return num * 2, nil
+}
+
+// echo is a SinkWriter printing every item it saved.
+type echo struct {
+	pipeline.SinkWriter[int]
+	term *terminal
+}
+
+func (e echo) Write(ctx context.Context, num int) error {
+	if err := e.SinkWriter.Write(ctx, num); err != nil {
+		return err
+	}
+	e.term.printf("saved %d", num)
+	return nil
+}
+
+// terminal is the only writer to the output. Every Write reaches it whole,
+// so the lines of the commands and of the stages never mix.
+type terminal struct {
+	writes chan []byte
+	done   chan struct{}
+}
+
+func newTerminal(w io.Writer) *terminal {
+	t := &terminal{writes: make(chan []byte), done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		for p := range t.writes {
+			w.Write(p)
+		}
+	}()
+	return t
+}
+
+// Write hands a copy of p to the writer.
+func (t *terminal) Write(p []byte) (int, error) {
+	t.writes <- bytes.Clone(p)
+	return len(p), nil
+}
+
+// printf writes a line.
+func (t *terminal) printf(format string, args ...any) {
+	fmt.Fprintf(t, format+"\n", args...)
+}
+
+// close waits for the writes so far to be written. Nothing may write after.
+func (t *terminal) close() {
+	close(t.writes)
+	<-t.done
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// console drives repl through a pipe for its input and another for its
+// output, which it reads line by line.
+type console struct {
+	t      *testing.T
+	in     *io.PipeWriter
+	lines  chan string
+	result chan error
+	sink   *pipeline.MemorySink[int]
+}
+
+func startConsole(t *testing.T, ctx context.Context) *console {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &console{t: t, in: inW, lines: make(chan string, 1000), result: make(chan error, 1), sink: &pipeline.MemorySink[int]{}}
+	go func() {
+		err := repl(ctx, inR, outW, c.sink)
+		outW.Close()
+		c.result <- err
+	}()
+	go func() {
+		defer close(c.lines)
+		sc := bufio.NewScanner(outR)
+		for sc.Scan() {
+			c.lines <- sc.Text()
+		}
+	}()
+	// Whatever a test did, repl must not outlive it.
+	t.Cleanup(func() {
+		inW.Close()
+		for range c.lines {
+		}
+	})
+	return c
+}
+
+func (c *console) send(lines ...string) {
+	c.t.Helper()
+	for _, line := range lines {
+		if _, err := io.WriteString(c.in, line+"\n"); err != nil {
+			c.t.Fatalf("sending %q: %v", line, err)
+		}
+	}
+}
+
+// expect reads the output until a line for which match is true, and returns
+// the lines before it.
+func (c *console) expect(what string, match func(line string) bool) []string {
+	c.t.Helper()
+	var skipped []string
+	timeout := time.After(pipelinetest.DefaultTimeout)
+	for {
+		select {
+		case line, ok := <-c.lines:
+			if !ok {
+				c.t.Fatalf("output ended without %s after %q", what, skipped)
+			}
+			if match(line) {
+				return skipped
+			}
+			skipped = append(skipped, line)
+		case <-timeout:
+			c.t.Fatalf("no %s after %q", what, skipped)
+		}
+	}
+}
+
+// expectLine reads the output until want and returns the lines before it.
+func (c *console) expectLine(want string) []string {
+	c.t.Helper()
+	return c.expect(fmt.Sprintf("%q", want), func(line string) bool { return line == want })
+}
+
+// end closes the input and returns the error of repl.
+func (c *console) end() error {
+	c.t.Helper()
+	c.in.Close()
+	select {
+	case err := <-c.result:
+		return err
+	case <-time.After(pipelinetest.DefaultTimeout):
+		c.t.Fatal("repl did not return")
+		return nil
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	for _, tt := range []struct {
+		line    string
+		want    command
+		wantErr string
+	}{
+		{line: "push 42", want: command{name: "push", arg: 42}},
+		{line: "  push   -3 ", want: command{name: "push", arg: -3}},
+		{line: "pause", want: command{name: "pause"}},
+		{line: "stats", want: command{name: "stats"}},
+		{line: "", want: command{}},
+		{line: "push", wantErr: "usage: push <number>"},
+		{line: "push 1 2", wantErr: "usage: push <number>"},
+		{line: "push x", wantErr: `push: "x" is not a number`},
+		{line: "drain now", wantErr: "usage: drain"},
+		{line: "frobnicate", wantErr: `unknown command "frobnicate", try help`},
+	} {
+		got, err := parseCommand(tt.line)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseCommand(%q) = %v, want the error %q", tt.line, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseCommand(%q) = %+v, %v, want %+v", tt.line, got, err, tt.want)
+		}
+	}
+}
+
+func TestPushAndDrain(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	c := startConsole(t, context.Background())
+	c.send("push 1", "push 2")
+	c.expectLine("saved 2")
+	c.expectLine("saved 4")
+	c.send("drain", "push 3")
+	c.expectLine("draining")
+	c.expectLine("push 3: draining")
+	c.expectLine("drained")
+
+	if err := c.end(); err != nil {
+		t.Fatalf("repl = %v, want nil after a drain", err)
+	}
+	if got := c.sink.Items(); !slices.Equal(got, []int{2, 4}) || !c.sink.Closed() {
+		t.Errorf("saved %v (closed %t), want [2 4] and the sink closed", got, c.sink.Closed())
+	}
+}
+
+func TestEndOfInputDrains(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	c := startConsole(t, context.Background())
+	c.send("push 1", "push 2", "push 3")
+	err := c.end()
+	c.expectLine("drained")
+	if err != nil {
+		t.Fatalf("repl = %v, want nil after the input ended", err)
+	}
+	// Every number pushed before the end is saved.
+	if got := c.sink.Items(); !slices.Equal(got, []int{2, 4, 6}) {
+		t.Errorf("saved %v, want [2 4 6]", got)
+	}
+}
+
+func TestPauseHoldsPushes(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	c := startConsole(t, context.Background())
+	c.send("pause", "push 5", "push 6")
+	c.expectLine("paused")
+	time.Sleep(50 * time.Millisecond)
+	if got := c.sink.Items(); len(got) != 0 {
+		t.Fatalf("saved %v while paused", got)
+	}
+
+	c.send("resume")
+	if skipped := c.expectLine("running"); len(skipped) != 0 {
+		t.Errorf("printed %q while paused", skipped)
+	}
+	c.expectLine("saved 10")
+	c.expectLine("saved 12")
+	c.send("drain")
+	c.expectLine("drained")
+	if err := c.end(); err != nil {
+		t.Fatalf("repl = %v", err)
+	}
+}
+
+func TestDrainWhilePaused(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	c := startConsole(t, context.Background())
+	c.send("pause", "push 7", "drain")
+	c.expectLine("saved 14")
+	c.expectLine("drained")
+	if err := c.end(); err != nil {
+		t.Fatalf("repl = %v", err)
+	}
+}
+
+// stats sends stats and returns the rows it printed, by stage.
+func (c *console) stats() map[string][]string {
+	c.t.Helper()
+	c.send("stats")
+	c.expect("the stats header", func(line string) bool { return strings.HasPrefix(strings.TrimSpace(line), "stage ") })
+	rows := make(map[string][]string)
+	for range 3 {
+		c.expect("a stats row", func(line string) bool {
+			fields := strings.Fields(line)
+			rows[fields[0]] = fields
+			return true
+		})
+	}
+	return rows
+}
+
+func TestStats(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	c := startConsole(t, context.Background())
+	c.send("push 1", "push 2")
+	c.expectLine("saved 4")
+
+	// A stage counts an item out only after its function returned, so the
+	// last one saved may show up in a later stats.
+	stages := []string{"generator", "transform", "save"}
+	settled := func(rows map[string][]string) bool {
+		for _, stage := range stages {
+			// The in and out columns of every stage.
+			if row := rows[stage]; len(row) < 3 || row[2] != "2" {
+				return false
+			}
+		}
+		return true
+	}
+	deadline := time.Now().Add(pipelinetest.DefaultTimeout)
+	rows := c.stats()
+	for !settled(rows) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		rows = c.stats()
+	}
+	if !settled(rows) {
+		t.Errorf("stats rows %q, want 2 items out of %s", rows, strings.Join(stages, ", "))
+	}
+	c.send("drain")
+	c.expectLine("drained")
+	if err := c.end(); err != nil {
+		t.Fatalf("repl = %v", err)
+	}
+}
+
+func TestBadInputKeepsRunning(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	c := startConsole(t, context.Background())
+	c.send("frobnicate", "push", "push -1", "push 3")
+	c.expectLine(`unknown command "frobnicate", try help`)
+	c.expectLine("usage: push <number>")
+	// 3 may be saved before the failure of -1 is reported.
+	failed, saved := false, false
+	c.expect("the failure of -1 and 3 saved", func(line string) bool {
+		failed = failed || strings.HasPrefix(line, "error:") && strings.Contains(line, errNegative.Error())
+		saved = saved || line == "saved 6"
+		return failed && saved
+	})
+	c.send("drain")
+	c.expectLine("drained")
+	if err := c.end(); err != nil {
+		t.Fatalf("repl = %v, want the failed item skipped", err)
+	}
+	if got := c.sink.Items(); !slices.Equal(got, []int{6}) {
+		t.Errorf("saved %v, want [6]", got)
+	}
+}
+
+func TestQuitAborts(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	c := startConsole(t, context.Background())
+	// The paused push is still waiting when quit drops it.
+	c.send("pause", "push 1", "quit")
+	c.expectLine("aborting")
+	c.expectLine("aborted")
+	err := c.end()
+	if !errors.Is(err, pipeline.ErrCancelled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("repl = %v, want ErrCancelled", err)
+	}
+	if got := c.sink.Items(); len(got) != 0 || !c.sink.Closed() {
+		t.Errorf("saved %v (closed %t), want nothing and the sink closed", got, c.sink.Closed())
+	}
+	if code := exitCode(err); code != 0 {
+		t.Errorf("exit code %d after quit, want 0", code)
+	}
+}
+
+func TestSignalDrains(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	c := startConsole(t, ctx)
+	// The second pause is only printed once the push is queued.
+	c.send("pause", "push 4", "pause")
+	c.expectLine("paused")
+	c.expectLine("paused")
+	cancel(pipeline.ErrSignalled)
+	c.expectLine(pipeline.ErrSignalled.Error() + ", draining")
+	c.expectLine("saved 8")
+	c.expectLine("drained")
+
+	err := c.end()
+	if !errors.Is(err, pipeline.ErrSignalled) {
+		t.Fatalf("repl = %v, want ErrSignalled", err)
+	}
+	if code := exitCode(err); code != 130 {
+		t.Errorf("exit code %d after a signal, want 130", code)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	failed := errors.New("sink down")
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{pipeline.ErrSignalled, 130},
+		{failed, 1},
+	} {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// record is what transform makes of a sequence number.
+type record struct {
+	Seq   int
+	Value int
+}
+
+func value(seq int) int { return seq * 2 }
+
+type itemState int
+
+const (
+	pending itemState = iota
+	saved
+	deadLettered
+)
+
+// entry is what the ledger knows about an item still in flight.
+type entry struct {
+	emitted  time.Time
+	attempts int
+	// succeeded is set when the last attempt of transform succeeded.
+	succeeded bool
+	state     itemState
+}
+
+// ledger follows every sequence number from the generator to the sink or
+// the dead letters and records every broken invariant: an item saved or
+// dead-lettered twice, or without the attempts that got it there, saved with
+// the wrong value, too long in flight, or never resolved at all. Once every
+// item below a number is resolved they are forgotten, so that the ledger of a
+// run of any length only holds the items in flight.
+type ledger struct {
+	maxAttempts int
+	maxBatch    int
+
+	mu sync.Mutex
+	// next is the sequence number the next emitted item must have, and
+	// every item below low is resolved.
+	next, low  int
+	entries    map[int]*entry
+	saved      int64
+	dead       int64
+	retries    int64
+	closed     bool
+	violations []string
+}
+
+func newLedger(maxAttempts, maxBatch int) *ledger {
+	return &ledger{maxAttempts: maxAttempts, maxBatch: maxBatch, entries: make(map[int]*entry)}
+}
+
+func (l *ledger) violate(format string, args ...any) {
+	l.violations = append(l.violations, fmt.Sprintf(format, args...))
+}
+
+// fail records an invariant the ledger does not check itself.
+func (l *ledger) fail(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.violate(format, args...)
+}
+
+// emit records seq leaving the generator, which numbers its items without
+// gaps.
+func (l *ledger) emit(seq int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if seq != l.next {
+		l.violate("emitted %d, want %d", seq, l.next)
+	}
+	l.entries[seq] = &entry{emitted: time.Now()}
+	l.next = max(l.next, seq+1)
+}
+
+// lookup returns the entry of seq, or nil after recording that seq is not in
+// flight, doing what.
+func (l *ledger) lookup(seq int, what string) *entry {
+	if e, ok := l.entries[seq]; ok && e.state == pending {
+		return e
+	}
+	if seq < l.low || l.entries[seq] != nil {
+		l.violate("%s %d, which was already resolved", what, seq)
+	} else {
+		l.violate("%s %d, which was never emitted", what, seq)
+	}
+	return nil
+}
+
+// attempt records transform trying seq, and whether it succeeded.
+func (l *ledger) attempt(seq int, succeeded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := l.lookup(seq, "attempted")
+	if e == nil {
+		return
+	}
+	if e.attempts++; e.attempts > 1 {
+		l.retries++
+	}
+	if e.attempts > l.maxAttempts {
+		l.violate("attempted %d %d times, want at most %d", seq, e.attempts, l.maxAttempts)
+	}
+	e.succeeded = succeeded
+}
+
+// save records a batch reaching the sink.
+func (l *ledger) save(batch []record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		l.violate("saved %d items after the sink was closed", len(batch))
+	}
+	if len(batch) == 0 || len(batch) > l.maxBatch {
+		l.violate("saved a batch of %d, want 1 to %d", len(batch), l.maxBatch)
+	}
+	for _, r := range batch {
+		e := l.lookup(r.Seq, "saved")
+		if e == nil {
+			continue
+		}
+		switch {
+		case !e.succeeded:
+			l.violate("saved %d after %d attempts, the last of which failed", r.Seq, e.attempts)
+		case r.Value != value(r.Seq):
+			l.violate("saved %d as %d, want %d", r.Seq, r.Value, value(r.Seq))
+		}
+		l.saved++
+		l.resolve(r.Seq, e, saved)
+	}
+}
+
+// deadLetter records transform giving up on seq.
+func (l *ledger) deadLetter(seq int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := l.lookup(seq, "dead-lettered")
+	if e == nil {
+		return
+	}
+	if e.attempts != l.maxAttempts || e.succeeded {
+		l.violate("dead-lettered %d after %d attempts (last succeeded %t), want %d failed ones", seq, e.attempts, e.succeeded, l.maxAttempts)
+	}
+	l.dead++
+	l.resolve(seq, e, deadLettered)
+}
+
+// resolve sets the state of seq and forgets every resolved item from low
+// up to the first one still in flight.
+func (l *ledger) resolve(seq int, e *entry, state itemState) {
+	e.state = state
+	for l.low < l.next {
+		if e := l.entries[l.low]; e == nil || e.state == pending {
+			return
+		}
+		delete(l.entries, l.low)
+		l.low++
+	}
+}
+
+// Close is called once the last batch was saved.
+func (l *ledger) Close(context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	return nil
+}
+
+// Write makes the ledger the SinkWriter the batches are saved to.
+func (l *ledger) Write(_ context.Context, batch []record) error {
+	l.save(batch)
+	return nil
+}
+
+// check records the items that have been in flight for longer than maxAge
+// and returns every violation so far.
+func (l *ledger) check(now time.Time, maxAge time.Duration) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, seq := range l.inFlight() {
+		if age := now.Sub(l.entries[seq].emitted); age > maxAge {
+			l.violate("%d in flight for %v, longer than %v", seq, age.Round(time.Millisecond), maxAge)
+			// Report it only once.
+			l.entries[seq].emitted = now
+		}
+	}
+	return slices.Clone(l.violations)
+}
+
+// finish records every item that was never resolved, once the run is over,
+// which leaves emitted items that are neither saved nor dead-lettered, and
+// returns every violation.
+func (l *ledger) finish() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, seq := range l.inFlight() {
+		l.violate("%d lost: emitted but neither saved nor dead-lettered", seq)
+	}
+	if !l.closed {
+		l.violate("sink not closed")
+	}
+	if resolved := l.saved + l.dead; int64(l.next) != resolved {
+		l.violate("emitted %d, but saved %d and dead-lettered %d", l.next, l.saved, l.dead)
+	}
+	return slices.Clone(l.violations)
+}
+
+// inFlight returns the sequence numbers still in flight in order.
+func (l *ledger) inFlight() []int {
+	var seqs []int
+	for seq, e := range l.entries {
+		if e.state == pending {
+			seqs = append(seqs, seq)
+		}
+	}
+	slices.Sort(seqs)
+	return seqs
+}
+
+// writeSummary writes the counts of the ledger and, with more, the oldest
+// items still in flight.
+func (l *ledger) writeSummary(w io.Writer, more bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inFlight := l.inFlight()
+	fmt.Fprintf(w, "emitted=%d saved=%d dead_lettered=%d retries=%d in_flight=%d", l.next, l.saved, l.dead, l.retries, len(inFlight))
+	if more && len(inFlight) > 0 {
+		fmt.Fprintf(w, " oldest_in_flight=%v", inFlight[:min(len(inFlight), 10)])
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resolved runs 0 to 3 through l: 0 and 1 saved, 2 saved after a retry and
+// 3 dead-lettered after three failed attempts.
+func resolved(l *ledger) {
+	for seq := range 4 {
+		l.emit(seq)
+	}
+	l.attempt(0, true)
+	l.attempt(1, true)
+	l.attempt(2, false)
+	l.attempt(2, true)
+	for range 3 {
+		l.attempt(3, false)
+	}
+	l.save([]record{{0, 0}, {2, 4}})
+	l.deadLetter(3)
+	l.save([]record{{1, 2}})
+}
+
+// assertViolation fails unless violations holds exactly one, containing want.
+func assertViolation(t *testing.T, violations []string, want string) {
+	t.Helper()
+	if len(violations) != 1 || !strings.Contains(violations[0], want) {
+		t.Errorf("violations %q, want one about %q", violations, want)
+	}
+}
+
+func TestLedgerAccountsForEveryItem(t *testing.T) {
+	l := newLedger(3, 2)
+	resolved(l)
+	l.Close(context.Background())
+	if violations := l.finish(); len(violations) > 0 {
+		t.Errorf("violations %q, want none", violations)
+	}
+	if l.saved != 3 || l.dead != 1 || l.retries != 3 {
+		t.Errorf("saved %d, dead-lettered %d, retried %d, want 3, 1 and 3", l.saved, l.dead, l.retries)
+	}
+	// Every item is resolved, so none is left in the ledger.
+	if len(l.entries) != 0 || l.low != 4 {
+		t.Errorf("ledger still holds %d items below %d", len(l.entries), l.low)
+	}
+}
+
+func TestLedgerViolations(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		after func(l *ledger)
+		want  string
+	}{
+		{"saved twice", func(l *ledger) { l.save([]record{{1, 2}}) }, "saved 1, which was already resolved"},
+		{"dead-lettered once saved", func(l *ledger) { l.deadLetter(0) }, "dead-lettered 0, which was already resolved"},
+		{"never emitted", func(l *ledger) { l.attempt(9, true) }, "attempted 9, which was never emitted"},
+		{"emitted out of order", func(l *ledger) { l.emit(5) }, "emitted 5, want 4"},
+		{"too many attempts", func(l *ledger) {
+			l.emit(4)
+			for range 4 {
+				l.attempt(4, false)
+			}
+		}, "attempted 4 4 times"},
+		{"saved after a failure", func(l *ledger) {
+			l.emit(4)
+			l.attempt(4, false)
+			l.save([]record{{4, 8}})
+		}, "the last of which failed"},
+		{"wrong value", func(l *ledger) {
+			l.emit(4)
+			l.attempt(4, true)
+			l.save([]record{{4, 5}})
+		}, "saved 4 as 5, want 8"},
+		{"dead-lettered too soon", func(l *ledger) {
+			l.emit(4)
+			l.attempt(4, false)
+			l.deadLetter(4)
+		}, "after 1 attempts"},
+		{"batch too big", func(l *ledger) {
+			for seq := 4; seq < 7; seq++ {
+				l.emit(seq)
+				l.attempt(seq, true)
+			}
+			l.save([]record{{4, 8}, {5, 10}, {6, 12}})
+		}, "batch of 3, want 1 to 2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newLedger(3, 2)
+			resolved(l)
+			tc.after(l)
+			assertViolation(t, l.check(time.Now(), time.Hour), tc.want)
+		})
+	}
+}
+
+func TestLedgerItemInFlightTooLong(t *testing.T) {
+	l := newLedger(3, 2)
+	resolved(l)
+	l.emit(4)
+	later := time.Now().Add(time.Minute)
+	assertViolation(t, l.check(later, time.Second), "4 in flight for")
+	// It is reported once.
+	assertViolation(t, l.check(later, time.Second), "4 in flight for")
+}
+
+func TestLedgerItemLost(t *testing.T) {
+	l := newLedger(3, 2)
+	resolved(l)
+	l.emit(4)
+	l.emit(5)
+	l.attempt(5, true)
+	l.save([]record{{5, 10}})
+	l.Close(context.Background())
+	// 5 is saved but 4 never was.
+	violations := l.finish()
+	if len(violations) != 2 || !strings.Contains(violations[0], "4 lost") || !strings.Contains(violations[1], "emitted 6, but saved 4 and dead-lettered 1") {
+		t.Errorf("violations %q, want 4 lost", violations)
+	}
+	if l.low != 4 || len(l.entries) != 2 {
+		t.Errorf("ledger holds %d items from %d, want 4 and 5", len(l.entries), l.low)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Runs a ticker generator through a pool of transform workers that fail at
+// random and retry, batches the results and saves them, for -duration, while
+// checking that no item is lost or saved twice, that goroutines do not pile
+// up and that memory stays bounded, e.g.
+//
+//	go run ./channels/cmd/soak -duration 10m
+//
+// It exits with 1 and a dump of what went wrong, goroutines included, once an
+// invariant breaks or the shutdown takes longer than -grace. A second signal
+// dumps the goroutines and exits right away.
+func main() {
+	cfg, err := parseFlags(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	ctx, cancel := pipeline.ShutdownOnSignal(context.Background(), sigs, func() {
+		fmt.Fprintln(os.Stderr, "soak: forced exit")
+		pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
+		os.Exit(1)
+	})
+	defer cancel()
+	// run has written the dump.
+	if err := run(ctx, cfg, os.Stdout, os.Stderr); err != nil {
+		os.Exit(1)
+	}
+}
+
+// config is what the flags of the soak test set.
+type config struct {
+	Duration time.Duration
+	// Interval is the time between two items of the generator.
+	Interval time.Duration
+	Workers  int
+	// FailRate is the chance of an attempt of transform to fail, and Work
+	// the most time it takes.
+	FailRate    float64
+	Work        time.Duration
+	MaxAttempts int
+	RetryDelay  time.Duration
+	BatchSize   int
+	BatchWait   time.Duration
+	// Grace bounds the shutdown once the duration is over.
+	Grace time.Duration
+	// Every CheckInterval every item must not have been in flight for
+	// longer than MaxAge, the pipeline not have started more than
+	// MaxGoroutineGrowth goroutines since the first check, and the process
+	// not hold more than MaxRSS bytes.
+	CheckInterval      time.Duration
+	MaxAge             time.Duration
+	MaxGoroutineGrowth int
+	MaxRSS             uint64
+	// StatusInterval is the time between two status lines, 0 for none.
+	StatusInterval time.Duration
+}
+
+func defaultConfig() config {
+	return config{
+		Duration:           time.Minute,
+		Interval:           200 * time.Microsecond,
+		Workers:            8,
+		FailRate:           0.2,
+		Work:               time.Millisecond,
+		MaxAttempts:        3,
+		RetryDelay:         time.Millisecond,
+		BatchSize:          50,
+		BatchWait:          10 * time.Millisecond,
+		Grace:              5 * time.Second,
+		CheckInterval:      100 * time.Millisecond,
+		MaxAge:             5 * time.Second,
+		MaxGoroutineGrowth: 20,
+		MaxRSS:             256 << 20,
+		StatusInterval:     5 * time.Second,
+	}
+}
+
+func parseFlags(args []string, stderr io.Writer) (config, error) {
+	cfg := defaultConfig()
+	fs := flag.NewFlagSet("soak", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.DurationVar(&cfg.Duration, "duration", cfg.Duration, "how long to run the pipeline")
+	fs.DurationVar(&cfg.Interval, "interval", cfg.Interval, "time between two generated items")
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "transform workers")
+	fs.Float64Var(&cfg.FailRate, "fail-rate", cfg.FailRate, "chance of an attempt of transform to fail, 0 to 1")
+	fs.DurationVar(&cfg.Work, "work", cfg.Work, "most time an attempt of transform takes")
+	fs.IntVar(&cfg.MaxAttempts, "attempts", cfg.MaxAttempts, "attempts of transform before an item is dead-lettered")
+	fs.IntVar(&cfg.BatchSize, "batch", cfg.BatchSize, "most items saved at once")
+	fs.DurationVar(&cfg.Grace, "grace", cfg.Grace, "time the shutdown may take once the duration is over")
+	fs.DurationVar(&cfg.MaxAge, "max-age", cfg.MaxAge, "time an item may be in flight")
+	maxRSS := fs.Uint64("max-rss", cfg.MaxRSS>>20, "memory the process may hold, in MiB")
+	fs.DurationVar(&cfg.StatusInterval, "status", cfg.StatusInterval, "time between two status lines, 0 for none")
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+	cfg.MaxRSS = *maxRSS << 20
+
+	switch {
+	case cfg.Duration <= 0 || cfg.Interval <= 0 || cfg.Grace <= 0 || cfg.MaxAge <= 0:
+		return config{}, errors.New("duration, interval, grace and max-age must be positive")
+	case cfg.Workers < 1 || cfg.MaxAttempts < 1 || cfg.BatchSize < 1:
+		return config{}, errors.New("workers, attempts and batch must be at least 1")
+	case cfg.FailRate < 0 || cfg.FailRate > 1:
+		return config{}, fmt.Errorf("fail-rate must be between 0 and 1, got %v", cfg.FailRate)
+	case cfg.Work < 0 || cfg.StatusInterval < 0:
+		return config{}, errors.New("work and status must not be negative")
+	}
+	return cfg, nil
+}
+
+// errInjected is what transform fails with at random.
+var errInjected = errors.New("injected failure")
+
+// errViolation is returned by run when an invariant broke.
+var errViolation = errors.New("invariant violated")
+
+// build returns the pipeline of the soak test, recording every item in l.
+func build(cfg config, l *ledger) *pipeline.Pipeline {
+	generated := pipeline.From(func(ctx context.Context) (<-chan int, <-chan error) {
+		return pipeline.GenerateFunc(ctx, cfg.Interval, func(i int) (int, bool) { return i, true }, pipeline.WithName("generator")), nil
+	})
+	emitted := generated.Then(func(_ context.Context, seq int) (int, error) {
+		l.emit(seq)
+		return seq, nil
+	}, pipeline.WithName("emit"))
+	transform := func(_ context.Context, seq int) (record, error) {
+		if cfg.Work > 0 {
+			time.Sleep(rand.N(cfg.Work))
+		}
+		if rand.Float64() < cfg.FailRate {
+			l.attempt(seq, false)
+			return record{}, errInjected
+		}
+		l.attempt(seq, true)
+		return record{Seq: seq, Value: value(seq)}, nil
+	}
+	retried := pipeline.Retry(transform, pipeline.RetryOptions{
+		MaxAttempts:  cfg.MaxAttempts,
+		InitialDelay: cfg.RetryDelay,
+		Multiplier:   2,
+		Jitter:       0.2,
+	})
+	transformed := pipeline.ViaPool(emitted, cfg.Workers, retried,
+		pipeline.WithName("transform"), pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	return pipeline.Batched(transformed, cfg.BatchSize, cfg.BatchWait, pipeline.WithName("batch")).
+		SinkTo(l, pipeline.WithName("save")).
+		OnError(func(err error) error {
+			var stageErr *pipeline.StageError
+			if errors.As(err, &stageErr) && errors.Is(err, errInjected) {
+				if seq, ok := stageErr.Item.(int); ok {
+					l.deadLetter(seq)
+					return nil
+				}
+			}
+			return err
+		})
+}
+
+// run runs the soak test for cfg.Duration, or until ctx is cancelled, and
+// then shuts it down. It writes status lines to stdout and, if an invariant
+// broke, a dump to stderr and returns errViolation.
+func run(ctx context.Context, cfg config, stdout, stderr io.Writer) error {
+	l := newLedger(cfg.MaxAttempts, cfg.BatchSize)
+	goroutines := runtime.NumGoroutine()
+
+	timed, stopTimer := context.WithTimeout(ctx, cfg.Duration)
+	defer stopTimer()
+	runCtx, cancel := context.WithCancelCause(timed)
+	defer cancel(nil)
+	stoppedAt := make(chan time.Time, 1)
+	go func() {
+		<-runCtx.Done()
+		stoppedAt <- time.Now()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	go func() {
+		defer wg.Done()
+		watch(watchCtx, cfg, l, stdout, func() { cancel(errViolation) })
+	}()
+
+	start := time.Now()
+	_, err := build(cfg, l).RunWithOptions(runCtx, pipeline.RunOptions{DrainTimeout: cfg.Grace})
+	stopWatch()
+	wg.Wait()
+	// A run that failed is only cancelled now, with nothing left to shut
+	// down.
+	cancel(nil)
+	shutdown := time.Since(<-stoppedAt)
+	switch {
+	case errors.Is(err, pipeline.ErrDrainTimeout):
+		l.fail("shutdown took longer than the grace period of %v", cfg.Grace)
+	case pipeline.IsFatal(err):
+		l.fail("pipeline failed: %v", err)
+	case shutdown > cfg.Grace:
+		l.fail("shutdown took %v, longer than the grace period of %v", shutdown.Round(time.Millisecond), cfg.Grace)
+	}
+	if n := settledGoroutines(goroutines, time.Second); n > goroutines {
+		l.fail("%d goroutines left behind", n-goroutines)
+	}
+
+	if violations := l.finish(); len(violations) > 0 {
+		writeDump(stderr, l, violations)
+		return fmt.Errorf("%w: %d times", errViolation, len(violations))
+	}
+	fmt.Fprintf(stdout, "soak: ok after %v: ", time.Since(start).Round(time.Millisecond))
+	l.writeSummary(stdout, false)
+	return nil
+}
+
+// watch checks the invariants every cfg.CheckInterval until ctx is
+// cancelled, calling broken once one of them broke, and writes a status line
+// every cfg.StatusInterval.
+func watch(ctx context.Context, cfg config, l *ledger, stdout io.Writer, broken func()) {
+	start := time.Now()
+	ticker := time.NewTicker(cfg.CheckInterval)
+	defer ticker.Stop()
+	baseline := -1
+	var lastStatus time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// The first check sees the pipeline with every stage started.
+			n := runtime.NumGoroutine()
+			if baseline < 0 {
+				baseline = n
+			}
+			if n > baseline+cfg.MaxGoroutineGrowth {
+				l.fail("%d goroutines, %d more than at the start", n, n-baseline)
+			}
+			if rss := residentBytes(); rss > cfg.MaxRSS {
+				l.fail("holding %d MiB, more than %d MiB", rss>>20, cfg.MaxRSS>>20)
+			}
+			if len(l.check(now, cfg.MaxAge)) > 0 {
+				broken()
+				return
+			}
+			if cfg.StatusInterval > 0 && now.Sub(lastStatus) >= cfg.StatusInterval {
+				lastStatus = now
+				fmt.Fprintf(stdout, "soak: %v goroutines=%d rss=%dMiB ", now.Sub(start).Round(time.Second), n, residentBytes()>>20)
+				l.writeSummary(stdout, false)
+			}
+		}
+	}
+}
+
+// settledGoroutines waits up to timeout for the goroutines of a run to exit
+// until no more than want are left, and returns how many there are.
+func settledGoroutines(want int, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= want || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// residentBytes returns the resident set size of the process, or the memory
+// the runtime obtained from the system where /proc is not available.
+func residentBytes() uint64 {
+	if statm, err := os.ReadFile("/proc/self/statm"); err == nil {
+		if fields := strings.Fields(string(statm)); len(fields) > 1 {
+			if pages, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.Sys
+}
+
+// writeDump writes what the soak test found broken, the state of the ledger
+// and the stacks of every goroutine to w.
+func writeDump(w io.Writer, l *ledger, violations []string) {
+	const shown = 50
+	fmt.Fprintf(w, "soak: %d invariant violations:\n", len(violations))
+	for _, v := range violations[:min(len(violations), shown)] {
+		fmt.Fprintf(w, "  %s\n", v)
+	}
+	if len(violations) > shown {
+		fmt.Fprintf(w, "  and %d more\n", len(violations)-shown)
+	}
+	fmt.Fprint(w, "ledger: ")
+	l.writeSummary(w, true)
+	fmt.Fprintf(w, "goroutines=%d rss=%dMiB\n", runtime.NumGoroutine(), residentBytes()>>20)
+	pprof.Lookup("goroutine").WriteTo(w, 1)
+}
@@ -0,0 +1,24 @@
+//go:build soak
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+// TestSoak runs the soak test for two seconds:
+//
+//	go test -tags soak ./cmd/soak
+func TestSoak(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Duration = 2 * time.Second
+	cfg.StatusInterval = 0
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), cfg, &stdout, &stderr); err != nil {
+		t.Fatalf("run = %v\n%s", err, stderr.String())
+	}
+	t.Log(stdout.String())
+}
@@ -0,0 +1,420 @@
+// Package lesson019 is the pipeline of lesson 19, generating numbers,
+// doubling them and saving them, with its error handling, reloading and
+// shutdown. cmd/lesson_019 runs it from the command line.
+package lesson019
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the pipeline is run with. The flags of cmd/lesson_019 set
+// it up and a config file, re-read on SIGHUP, may override the fields it
+// names.
+type Config struct {
+	Nums    []int `json:"nums"`
+	FailOn  *int  `json:"fail_on"`
+	Workers int   `json:"workers"`
+	Buffer  int   `json:"buffer"`
+	// Seed shuffles Nums when it is not 0.
+	Seed int64 `json:"seed"`
+	// Shard limits the run to one shard of the shuffled Nums, so that
+	// processes run with every index of the same count and the same Seed
+	// process each number once between them. The zero Shard processes all
+	// of them.
+	Shard pipeline.Shard `json:"-"`
+
+	ConfigPath   string        `json:"-"`
+	DrainTimeout time.Duration `json:"-"`
+	MetricsAddr  string        `json:"-"`
+	Debug        bool          `json:"-"`
+	Progress     bool          `json:"-"`
+	DOT          bool          `json:"-"`
+	// Trace is how many items each stage remembers for the trace printed
+	// after a failure, 0 for no trace.
+	Trace int `json:"-"`
+	// Report prints the report of every run of the pipeline.
+	Report bool `json:"-"`
+	// Stderr gets the progress line, the stats, the trace and the report;
+	// nil means os.Stderr.
+	Stderr io.Writer `json:"-"`
+}
+
+// Validate reports the first field of c out of range.
+func (c Config) Validate() error {
+	switch {
+	case c.Workers < 1:
+		return fmt.Errorf("workers must be at least 1, got %d", c.Workers)
+	case c.Buffer < 0:
+		return fmt.Errorf("buffer must not be negative, got %d", c.Buffer)
+	case c.Trace < 0:
+		return fmt.Errorf("trace must not be negative, got %d", c.Trace)
+	case c.Shard != pipeline.Shard{}:
+		return c.Shard.Validate()
+	}
+	return nil
+}
+
+// loadConfig returns base with the fields set in the file at path, if any.
+func loadConfig(path string, base Config) (Config, error) {
+	if path == "" {
+		return base, nil
+	}
+	cfg := base
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, cfg.Validate()
+}
+
+// DefaultConfig is the config of a run without flags: the numbers 0 to 10,
+// failing on 6, through one transform worker.
+func DefaultConfig() Config {
+	failOn := 6
+	return Config{
+		Nums:         []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		FailOn:       &failOn,
+		Workers:      1,
+		DrainTimeout: 5 * time.Second,
+	}
+}
+
+// Run runs the pipeline of cfg until it finishes or ctx is cancelled, writing
+// the log and the saved numbers to w. SIGTERM and SIGINT make it drain,
+// SIGHUP reloads the config file and SIGQUIT prints the stage stats. An error
+// it returns, other than an invalid cfg, has been logged to w.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	// The log and the sink both write to w.
+	w = &syncWriter{w: w}
+	a := newApp(cfg, w, func() pipeline.SinkWriter[int] {
+		return pipeline.WriterSink[int]{W: w, Format: savedFormat}
+	})
+	if cfg.DOT {
+		if err := a.build(cfg, slowSink{SinkWriter: a.newSink()}, a.reportFailed).WriteDOT(w); err != nil {
+			a.logger.Error("writing the graph failed", "error", err)
+			return err
+		}
+		return nil
+	}
+	if cfg.MetricsAddr != "" {
+		stop, err := a.serveMetrics(cfg.MetricsAddr)
+		if err != nil {
+			a.logger.Error("serving metrics failed", "error", err)
+			return err
+		}
+		defer stop()
+	}
+
+	// SIGQUIT prints the stage stats instead of a goroutine dump.
+	quits := make(chan os.Signal, 1)
+	signal.Notify(quits, syscall.SIGQUIT)
+	defer signal.Stop(quits)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-quits:
+				a.registry.WriteStats(a.stderr)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigs)
+	ctx, cancel := pipeline.ShutdownOnSignal(ctx, sigs, func() {
+		a.logger.Error("forced exit", "running", a.registry.Running())
+		os.Exit(1)
+	})
+	defer cancel()
+	return a.run(ctx, cfg)
+}
+
+// serveMetrics serves the stage metrics on /debug/vars at addr until stop is
+// called.
+func (a *app) serveMetrics(addr string) (stop func(), err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	a.metrics.transform.Publish("transform")
+	a.metrics.save.Publish("save")
+	srv := &http.Server{Handler: http.DefaultServeMux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.logger.Error("metrics server stopped", "error", err)
+		}
+	}()
+	return func() { srv.Close() }, nil
+}
+
+// syncWriter serializes the writes of several goroutines to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// savedFormat is how the sinks of the lesson print a saved number.
+const savedFormat = "saved %d\n"
+
+// newApp returns an app logging to out. newSink returns the sink of a run,
+// which it closes when done.
+func newApp(cfg Config, out io.Writer, newSink func() pipeline.SinkWriter[int]) *app {
+	stderr := cfg.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	level := slog.LevelInfo
+	if cfg.Debug {
+		level = slog.LevelDebug
+	}
+	return &app{
+		logger:       slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: level})),
+		registry:     pipeline.NewRegistry(),
+		drainTimeout: cfg.DrainTimeout,
+		progress:     cfg.Progress,
+		trace:        cfg.Trace,
+		printReport:  cfg.Report,
+		stderr:       stderr,
+		metrics:      stageMetrics{transform: &pipeline.Metrics{}, save: &pipeline.Metrics{}},
+		newSink:      newSink,
+	}
+}
+
+// run runs the pipeline until it finishes or ctx is cancelled, starting over
+// with the re-read config file on every SIGHUP.
+func (a *app) run(ctx context.Context, base Config) error {
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	defer signal.Stop(hups)
+
+	for {
+		cfg, err := loadConfig(base.ConfigPath, base)
+		if err != nil {
+			a.logger.Error("invalid configuration", "error", err)
+			return err
+		}
+
+		runCtx, stopRun := context.WithCancel(ctx)
+		reload := make(chan bool, 1)
+		go func() {
+			select {
+			case <-hups:
+				stopRun()
+				reload <- true
+			case <-runCtx.Done():
+				reload <- false
+			}
+		}()
+
+		err = a.runOnce(runCtx, cfg)
+		stopRun()
+		if <-reload {
+			a.logger.Info("reloading configuration")
+			continue
+		}
+
+		var stageErr *pipeline.StageError
+		switch {
+		case errors.Is(err, pipeline.ErrCancelled), errors.Is(err, pipeline.ErrSignalled):
+			a.logger.Info("stopped after draining in-flight items", "cause", pipeline.CauseOf(ctx))
+			return err
+		case pipeline.IsFatal(err) && errors.As(err, &stageErr):
+			a.logger.Error(fmt.Sprintf("pipeline error in %v", stageErr))
+			return err
+		case err != nil:
+			a.logger.Error("pipeline failed", "error", err)
+			return err
+		}
+		a.logger.Info("successfully finished processing")
+		return nil
+	}
+}
+
+type stageMetrics struct {
+	transform *pipeline.Metrics
+	save      *pipeline.Metrics
+}
+
+type app struct {
+	logger       *slog.Logger
+	registry     *pipeline.Registry
+	metrics      stageMetrics
+	drainTimeout time.Duration
+	progress     bool
+	trace        int
+	printReport  bool
+	stderr       io.Writer
+	newSink      func() pipeline.SinkWriter[int]
+	// deadLetters counts the items transform skipped.
+	deadLetters atomic.Int64
+	// report is the report of the last run.
+	report pipeline.Report
+}
+
+func (a *app) options(name string, extra ...pipeline.Option) []pipeline.Option {
+	opts := []pipeline.Option{pipeline.WithName(name), pipeline.WithRegistry(a.registry), pipeline.WithLogger(a.logger)}
+	if a.trace > 0 {
+		opts = append(opts, pipeline.WithTraceBuffer(a.trace))
+	}
+	return append(opts, extra...)
+}
+
+func (a *app) runOnce(ctx context.Context, cfg Config) (err error) {
+	save, reportFailed := slowSink{SinkWriter: a.newSink()}, a.reportFailed
+	if a.progress {
+		progressCtx, stopProgress := context.WithCancel(ctx)
+		tick, updates := pipeline.Progress(progressCtx, len(cfg.Nums))
+		printed := make(chan struct{})
+		go func() {
+			defer close(printed)
+			for u := range updates {
+				fmt.Fprintf(a.stderr, "\rprogress: %d/%d (%.1f items/s)", u.Done, u.Total, u.Rate)
+			}
+			fmt.Fprintln(a.stderr)
+		}()
+		defer func() {
+			// On success every item has ticked and the final update is
+			// still on its way; otherwise there will be no final update.
+			if err != nil {
+				stopProgress()
+			}
+			<-printed
+			stopProgress()
+		}()
+		save.tick = tick
+		reportFailed = func(err error) error {
+			if err = a.reportFailed(err); err == nil {
+				tick()
+			}
+			return err
+		}
+	}
+
+	// Every saved number is added up as well, until the sinks are done: a
+	// signal only makes the pipeline drain, which the sum must not stop.
+	saved := make(chan int)
+	save.saved = saved
+	sum, sumErrc := pipeline.SinkAggregate(context.WithoutCancel(ctx), saved, 0, func(sum, num int) int {
+		return sum + num
+	}, pipeline.WithName("sum"))
+
+	deadLetters := a.deadLetters.Load()
+	a.report, err = a.build(cfg, save, reportFailed).RunWithOptions(ctx, pipeline.RunOptions{DrainTimeout: a.drainTimeout})
+	close(saved)
+	if a.printReport {
+		fmt.Fprint(a.stderr, a.report)
+	}
+	if a.trace > 0 && (err != nil || a.deadLetters.Load() > deadLetters) {
+		if dumpErr := pipeline.DumpTrace(a.stderr); dumpErr != nil {
+			a.logger.Error("printing the trace failed", "error", dumpErr)
+		}
+	}
+	total, ok := <-sum
+	for sumErr := range sumErrc {
+		if err == nil {
+			err = sumErr
+		}
+	}
+	if err == nil && ok {
+		a.logger.Info(fmt.Sprintf("sum of doubled values: %d", total))
+	}
+	return err
+}
+
+// build returns the pipeline of a run saving to save.
+func (a *app) build(cfg Config, save slowSink, reportFailed func(error) error) *pipeline.Pipeline {
+	transform := func(_ context.Context, num int) (int, error) {
+		time.Sleep(100 * time.Millisecond)
+		if cfg.FailOn != nil && num == *cfg.FailOn {
+			return 0, fmt.Errorf("number %d is invalid", num)
+		}
+		return num * 2, nil
+	}
+
+	nums := cfg.Nums
+	if cfg.Seed != 0 {
+		nums = slices.Clone(nums)
+		r := rand.New(rand.NewPCG(uint64(cfg.Seed), 0))
+		r.Shuffle(len(nums), func(i, j int) { nums[i], nums[j] = nums[j], nums[i] })
+	}
+	if cfg.Shard != (pipeline.Shard{}) {
+		nums = pipeline.ShardItems(nums, cfg.Shard.Index, cfg.Shard.Count)
+	}
+	buffer := pipeline.WithBuffer(cfg.Buffer)
+
+	return pipeline.New(nums, a.options("generator", buffer)...).
+		ThenPool(cfg.Workers, transform,
+			a.options("transform", buffer, pipeline.WithMetrics(a.metrics.transform), pipeline.WithErrorPolicy(pipeline.SkipAndReport))...).
+		SinkTo(save, a.options("save", pipeline.WithMetrics(a.metrics.save))...).
+		OnError(reportFailed)
+}
+
+// slowSink is the save stage: it takes its time over every number before
+// writing it to its sink and handing it on to saved, if set, then ticks the
+// progress line if there is one.
+type slowSink struct {
+	pipeline.SinkWriter[int]
+	saved chan<- int
+	tick  func()
+}
+
+func (s slowSink) Write(ctx context.Context, num int) error {
+	if s.tick != nil {
+		defer s.tick()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := s.SinkWriter.Write(ctx, num); err != nil || s.saved == nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case s.saved <- num:
+		return nil
+	}
+}
+
+// reportFailed logs the items transform skipped. Any other error fails the
+// pipeline.
+func (a *app) reportFailed(err error) error {
+	var stageErr *pipeline.StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != "transform" || errors.Is(err, pipeline.ErrThresholdExceeded) {
+		return err
+	}
+	a.deadLetters.Add(1)
+	a.logger.Warn("dead letter", "item", stageErr.Item, "error", stageErr.Err)
+	return nil
+}
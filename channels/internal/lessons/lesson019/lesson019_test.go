@@ -0,0 +1,263 @@
+package lesson019
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// countConfig is DefaultConfig generating the numbers 0 to count-1 and
+// failing on failOn, if given.
+func countConfig(count int, failOn ...int) Config {
+	cfg := DefaultConfig()
+	cfg.Nums = pipelinetest.Items(count)
+	cfg.FailOn = nil
+	if len(failOn) > 0 {
+		cfg.FailOn = &failOn[0]
+	}
+	return cfg
+}
+
+// newTestApp returns an app saving into a new MemorySink per run, appended
+// to sinks.
+func newTestApp(sinks *[]*pipeline.MemorySink[int]) *app {
+	return &app{
+		newSink: func() pipeline.SinkWriter[int] {
+			sink := &pipeline.MemorySink[int]{}
+			*sinks = append(*sinks, sink)
+			return sink
+		},
+		logger:       slog.New(slog.DiscardHandler),
+		registry:     pipeline.NewRegistry(),
+		drainTimeout: time.Second,
+		metrics:      stageMetrics{transform: &pipeline.Metrics{}, save: &pipeline.Metrics{}},
+	}
+}
+
+// TestRunOnceTearsDown runs the pipeline three times in a row, as a SIGHUP
+// reload would, the second time cancelled halfway. Nothing of a run may
+// outlive it.
+func TestRunOnceTearsDown(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var sinks []*pipeline.MemorySink[int]
+	a := newTestApp(&sinks)
+	failOn := 2
+	for i, cfg := range []Config{
+		{Nums: []int{1, 2, 3}, FailOn: &failOn, Workers: 1},
+		{Nums: pipelinetest.Items(50), Workers: 4},
+		{Nums: []int{4, 5}, Workers: 2},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		if i == 1 {
+			time.AfterFunc(150*time.Millisecond, cancel)
+		}
+		err := a.runOnce(ctx, cfg)
+		cancel()
+		if i == 1 {
+			if err == nil {
+				t.Errorf("run %d = nil, want the cancellation", i)
+			}
+		} else if err != nil {
+			t.Errorf("run %d = %v, want nil", i, err)
+		}
+		if running := a.registry.Running(); len(running) != 0 {
+			t.Errorf("after run %d still running: %v", i, running)
+		}
+		if !sinks[i].Closed() {
+			t.Errorf("after run %d the sink is not closed", i)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	base := DefaultConfig()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"nums": [1, 2], "fail_on": null, "workers": 3}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path, base)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if len(cfg.Nums) != 2 || cfg.FailOn != nil || cfg.Workers != 3 {
+		t.Errorf("loadConfig = %+v, want nums [1 2], no fail_on and 3 workers", cfg)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"workers": 0}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(path, base); err == nil {
+		t.Error("loadConfig accepted 0 workers")
+	}
+}
+
+func TestRunFailurePath(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	cfg := countConfig(5, 2)
+	cfg.Buffer = 4
+	var log bytes.Buffer
+	sink := &pipeline.MemorySink[int]{}
+	a := newApp(cfg, &log, func() pipeline.SinkWriter[int] { return sink })
+	if err := a.run(context.Background(), cfg); err != nil {
+		t.Fatalf("run = %v, want the failed item skipped", err)
+	}
+
+	if want := []int{0, 2, 6, 8}; !slices.Equal(sink.Items(), want) || !sink.Closed() {
+		t.Errorf("saved %v (closed %t), want %v and the sink closed", sink.Items(), sink.Closed(), want)
+	}
+	if !strings.Contains(log.String(), "msg=\"dead letter\" item=2") {
+		t.Errorf("log does not report 2 as a dead letter:\n%s", log.String())
+	}
+}
+
+func TestRunFailureTrace(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	cfg := countConfig(8, 6)
+	cfg.Trace = 20
+	var trace bytes.Buffer
+	cfg.Stderr = &trace
+	a := newApp(cfg, io.Discard, func() pipeline.SinkWriter[int] { return &pipeline.MemorySink[int]{} })
+	if err := a.run(context.Background(), cfg); err != nil {
+		t.Fatalf("run = %v, want the failed item skipped", err)
+	}
+
+	// 6 entered transform and failed there, so its double never reached
+	// save while the items around it did.
+	var failed, saved []string
+	for _, line := range strings.Split(trace.String(), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) < 4:
+		case fields[1] == "transform" && strings.Contains(line, "failed after"):
+			failed = append(failed, fields[3])
+		case fields[1] == "save":
+			saved = append(saved, fields[3])
+		}
+	}
+	if !slices.Equal(failed, []string{"6"}) {
+		t.Errorf("failed in transform %v, want [6]:\n%s", failed, trace.String())
+	}
+	if want := []string{"0", "2", "4", "6", "8", "10", "14"}; !slices.Equal(saved, want) {
+		t.Errorf("saved %v, want %v without 12:\n%s", saved, want, trace.String())
+	}
+}
+
+// TestRunShards runs every shard of the shuffled numbers as a process of
+// its own would: between them they save each number once.
+func TestRunShards(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var saved []int
+	for index := range 3 {
+		cfg := countConfig(8)
+		cfg.Workers, cfg.Seed = 4, 42
+		cfg.Shard = pipeline.Shard{Index: index, Count: 3}
+		sink := &pipeline.MemorySink[int]{}
+		a := newApp(cfg, io.Discard, func() pipeline.SinkWriter[int] { return sink })
+		if err := a.run(context.Background(), cfg); err != nil {
+			t.Fatalf("shard %v: run = %v", cfg.Shard, err)
+		}
+		if n := len(sink.Items()); n < 2 || n > 3 {
+			t.Errorf("shard %v saved %v, want its third of the numbers", cfg.Shard, sink.Items())
+		}
+		saved = append(saved, sink.Items()...)
+	}
+	slices.Sort(saved)
+	if want := []int{0, 2, 4, 6, 8, 10, 12, 14}; !slices.Equal(saved, want) {
+		t.Errorf("shards saved %v, want %v", saved, want)
+	}
+}
+
+func TestRunWithoutFailure(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	cfg := countConfig(4)
+	cfg.Workers, cfg.Seed = 2, 42
+	var log bytes.Buffer
+	sink := &pipeline.MemorySink[int]{}
+	a := newApp(cfg, &log, func() pipeline.SinkWriter[int] { return sink })
+	if err := a.run(context.Background(), cfg); err != nil {
+		t.Fatalf("run = %v", err)
+	}
+
+	// The seed shuffles the numbers and the pool does not keep the order.
+	saved := slices.Sorted(slices.Values(sink.Items()))
+	if want := []int{0, 2, 4, 6}; !slices.Equal(saved, want) || !sink.Closed() {
+		t.Errorf("saved %v (closed %t), want %v and the sink closed", saved, sink.Closed(), want)
+	}
+	if strings.Contains(log.String(), "dead letter") || !strings.Contains(log.String(), "successfully finished processing") {
+		t.Errorf("log of a clean run:\n%s", log.String())
+	}
+}
+
+func TestRunPrintsSaved(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	cfg := countConfig(3)
+	var out bytes.Buffer
+	a := newApp(cfg, io.Discard, func() pipeline.SinkWriter[int] {
+		return pipeline.WriterSink[int]{W: &out, Format: savedFormat}
+	})
+	if err := a.run(context.Background(), cfg); err != nil {
+		t.Fatalf("run = %v", err)
+	}
+	for _, want := range []string{"saved 0\n", "saved 2\n", "saved 4\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunPrintsSum(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	cfg := countConfig(10)
+	cfg.Workers = 4
+	var out bytes.Buffer
+	a := newApp(cfg, &out, func() pipeline.SinkWriter[int] { return &pipeline.MemorySink[int]{} })
+	if err := a.run(context.Background(), cfg); err != nil {
+		t.Fatalf("run = %v", err)
+	}
+	if !strings.Contains(out.String(), `msg="sum of doubled values: 90"`) {
+		t.Errorf("log does not report the sum of 90:\n%s", out.String())
+	}
+}
+
+func TestRunReport(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		failOn []int
+		// transformOut and saved are what transform emitted and save
+		// wrote; both drain their input either way, as 6 is only skipped.
+		transformOut, saved int64
+		errors              int64
+	}{
+		{name: "failure", failOn: []int{6}, transformOut: 10, saved: 10, errors: 1},
+		{name: "success", transformOut: 11, saved: 11},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			cfg := countConfig(11, tt.failOn...)
+			a := newApp(cfg, io.Discard, func() pipeline.SinkWriter[int] { return &pipeline.MemorySink[int]{} })
+			if err := a.run(context.Background(), cfg); err != nil {
+				t.Fatalf("run = %v", err)
+			}
+
+			transform, ok := a.report.Stage("transform")
+			if !ok || transform.In != 11 || transform.Out != tt.transformOut || transform.Errors != tt.errors ||
+				transform.Termination != pipeline.TerminatedInputClosed {
+				t.Errorf("transform = %+v, want 11 in, %d out, %d errors and its input closed", transform, tt.transformOut, tt.errors)
+			}
+			save, ok := a.report.Stage("save")
+			if !ok || save.In != tt.saved || save.Out != tt.saved || save.Termination != pipeline.TerminatedInputClosed {
+				t.Errorf("save = %+v, want %d saved and its input closed", save, tt.saved)
+			}
+		})
+	}
+}
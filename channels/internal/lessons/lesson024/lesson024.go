@@ -0,0 +1,68 @@
+// Package lesson024 is the pipeline of lesson 24, bridging a stream of
+// channels into a single one. cmd/lesson_024 runs it from the command line.
+package lesson024
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Lengths are how many numbers each generator sends; generator i sends
+	// the numbers from i*10 on.
+	Lengths []int
+	// Delay is how long a generator takes for every number.
+	Delay time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: three generators of 3,
+// 1 and 5 numbers, taking 100ms for each.
+func DefaultConfig() Config {
+	return Config{Lengths: []int{3, 1, 5}, Delay: 100 * time.Millisecond}
+}
+
+// Run reads the numbers of every generator in turn until the last one is
+// done or ctx is cancelled, printing them to w.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chanStream := make(chan (<-chan int))
+	go func() {
+		defer close(chanStream)
+		for id, length := range cfg.Lengths {
+			select {
+			case <-ctx.Done():
+				return
+			case chanStream <- generator(ctx, id, length, cfg.Delay):
+			}
+		}
+	}()
+
+	for num := range pipeline.Bridge(ctx, chanStream) {
+		fmt.Fprintf(w, "received: %d\n", num)
+	}
+	fmt.Fprintf(w, "done\n")
+	return nil
+}
+
+func generator(ctx context.Context, id, length int, delay time.Duration) <-chan int {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for n := range length {
+			time.Sleep(delay)
+			select {
+			case <-ctx.Done():
+				return
+			case out <- id*10 + n:
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,73 @@
+// Package lesson025 is the pipeline of lesson 25, taking the first numbers
+// of a source and shutting the rest of it down. cmd/lesson_025 runs it from
+// the command line.
+package lesson025
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	Nums []int
+	// Take is how many of Nums are transformed and saved.
+	Take int
+}
+
+// DefaultConfig is the config of a run without flags: the first 5 of the
+// numbers 0 to 10.
+func DefaultConfig() Config {
+	return Config{Nums: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, Take: 5}
+}
+
+// Run saves the doubled numbers Take lets through, printing them to w, and
+// then how many goroutines of the pipeline are left once it is cancelled.
+// It returns the first error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	save := func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	}
+	genChan := pipeline.Source(ctx, cfg.Nums)
+	firstN := pipeline.Take(ctx, genChan, cfg.Take)
+	transChan, transErrChan := pipeline.Stage(ctx, firstN, transform)
+	doneChan, saveErrChan := pipeline.Sink(ctx, transChan, save)
+	errChan := pipeline.Merge(ctx, transErrChan, saveErrChan)
+
+	var firstErr error
+	for doneChan != nil {
+		select {
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			cancel()
+		case <-doneChan:
+			fmt.Fprintf(w, "finished after %d items\n", cfg.Take)
+			doneChan = nil
+		}
+	}
+
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	fmt.Fprintf(w, "goroutines of the pipeline left: %d\n", runtime.NumGoroutine()-before)
+	return firstErr
+}
+
+func transform(_ context.Context, num int) (int, error) {
+	return num * 2, nil
+}
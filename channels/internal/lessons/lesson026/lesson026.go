@@ -0,0 +1,67 @@
+// Package lesson026 is the pipeline of lesson 26, filtering out the odd
+// numbers before they are transformed. cmd/lesson_026 runs it from the
+// command line.
+package lesson026
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	Nums []int
+}
+
+// DefaultConfig is the config of a run without flags: the numbers 0 to 10.
+func DefaultConfig() Config {
+	return Config{Nums: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}
+}
+
+// Run saves the doubled even numbers, printing them to w, and then how many
+// odd ones were dropped. It returns the first error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	save := func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	}
+	genChan := pipeline.Source(ctx, cfg.Nums)
+	evenChan, dropped := pipeline.Filter(ctx, genChan, isEven)
+	transChan, transErrChan := pipeline.Stage(ctx, evenChan, transform)
+	doneChan, saveErrChan := pipeline.Sink(ctx, transChan, save)
+	errChan := pipeline.Merge(ctx, transErrChan, saveErrChan)
+
+	var firstErr error
+	for doneChan != nil {
+		select {
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			cancel()
+		case <-doneChan:
+			doneChan = nil
+		}
+	}
+
+	fmt.Fprintf(w, "dropped %d odd numbers\n", dropped())
+	return firstErr
+}
+
+func isEven(num int) bool {
+	return num%2 == 0
+}
+
+func transform(_ context.Context, num int) (int, error) {
+	return num * 2, nil
+}
@@ -0,0 +1,113 @@
+// Package lesson027 is the pipeline of lesson 27, whose save gets stuck and
+// is caught by a Watchdog on the heartbeats of the stages. cmd/lesson_027
+// runs it from the command line.
+package lesson027
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	Nums []int
+	// StuckOn is the saved number save gets stuck on until the pipeline is
+	// cancelled.
+	StuckOn int
+	// Heartbeat is how often the stages beat.
+	Heartbeat time.Duration
+	// StallAfter is how long the Watchdog waits for a heartbeat before it
+	// reports a stall.
+	StallAfter time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: the numbers 0 to 9,
+// getting stuck on saving 10, with a heartbeat every 100ms and a stall after
+// 500ms without one.
+func DefaultConfig() Config {
+	return Config{
+		Nums:       []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		StuckOn:    10,
+		Heartbeat:  100 * time.Millisecond,
+		StallAfter: 500 * time.Millisecond,
+	}
+}
+
+// Run runs the pipeline until the Watchdog reports a stalled stage, which
+// cancels it, printing what happened to w. It returns the first error of a
+// stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// save prints while Run does.
+	w = &syncWriter{w: w}
+
+	beats := make(chan pipeline.Heartbeat, 10)
+	heartbeat := pipeline.WithHeartbeat(cfg.Heartbeat, beats)
+
+	save := func(_ context.Context, num int) error {
+		if num == cfg.StuckOn {
+			fmt.Fprintf(w, "save is stuck on %d\n", num)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	}
+
+	genChan := pipeline.Source(ctx, cfg.Nums)
+	transChan, transErrChan := pipeline.Stage(ctx, genChan, transform, pipeline.WithName("transform"), heartbeat)
+	doneChan, saveErrChan := pipeline.Sink(ctx, transChan, save, pipeline.WithName("save"), heartbeat)
+	errChan := pipeline.Merge(ctx, transErrChan, saveErrChan)
+	stalls := pipeline.Watchdog(ctx, beats, cfg.StallAfter)
+
+	var firstErr error
+	for {
+		select {
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			cancel()
+		case stall, ok := <-stalls:
+			if !ok {
+				stalls = nil
+				continue
+			}
+			now := time.Now()
+			fmt.Fprintf(w, "stage %s stalled: no heartbeat for %v, %d items processed, the last one %v ago\n",
+				stall.Stage, now.Sub(stall.LastHeartbeat).Round(time.Millisecond), stall.Processed,
+				now.Sub(stall.LastItem).Round(time.Millisecond))
+			cancel()
+		case <-doneChan:
+			fmt.Fprintf(w, "pipeline shut down\n")
+			return firstErr
+		}
+	}
+}
+
+func transform(_ context.Context, num int) (int, error) {
+	time.Sleep(50 * time.Millisecond)
+	return num * 2, nil
+}
+
+// syncWriter serializes the writes of several goroutines to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
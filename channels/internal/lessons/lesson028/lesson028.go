@@ -0,0 +1,56 @@
+// Package lesson028 is the pipeline of lesson 28, upper-casing the lines of
+// a reader. cmd/lesson_028 runs it on stdin.
+package lesson028
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// In is read line by line.
+	In io.Reader
+}
+
+// Run writes the lines of cfg.In to w upper-cased until In ends or ctx is
+// cancelled. It returns the first error of a stage, after which it stops.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	save := func(_ context.Context, line string) error {
+		_, err := fmt.Fprintln(w, line)
+		return err
+	}
+	lineChan, readErrChan := pipeline.FromReader(ctx, cfg.In)
+	transChan, transErrChan := pipeline.Stage(ctx, lineChan, transform)
+	doneChan, saveErrChan := pipeline.Sink(ctx, transChan, save)
+	errChan := pipeline.Merge(ctx, readErrChan, transErrChan, saveErrChan)
+
+	var firstErr error
+	for doneChan != nil || errChan != nil {
+		select {
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			cancel()
+		case <-doneChan:
+			doneChan = nil
+		}
+	}
+	return firstErr
+}
+
+func transform(_ context.Context, line string) (string, error) {
+	return strings.ToUpper(line), nil
+}
@@ -0,0 +1,84 @@
+// Package lesson029 is the pipeline of lesson 29, summing up the sizes of
+// the files under a directory. cmd/lesson_029 runs it from the command line.
+package lesson029
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Root is the directory walked.
+	Root string
+	// Workers is how many files are looked up at once.
+	Workers int
+}
+
+// DefaultConfig is the config of a run without flags: the current directory,
+// looking up 4 files at once.
+func DefaultConfig() Config {
+	return Config{Root: ".", Workers: 4}
+}
+
+type fileSize struct {
+	path string
+	size int64
+}
+
+type summary struct {
+	files   int
+	bytes   int64
+	largest fileSize
+}
+
+// Run walks cfg.Root and prints to w how many files it holds, their size and
+// the largest one. A file that cannot be walked or looked up is reported to
+// w and skipped, so Run does not fail.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	var sum summary
+	save := func(_ context.Context, f fileSize) error {
+		sum.files++
+		sum.bytes += f.size
+		if f.size > sum.largest.size {
+			sum.largest = f
+		}
+		return nil
+	}
+
+	pathChan, walkErrChan := pipeline.WalkFiles(ctx, cfg.Root, nil, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	transChan, transErrChan := pipeline.StagePool(ctx, pathChan, cfg.Workers, transform)
+	doneChan, saveErrChan := pipeline.Sink(ctx, transChan, save)
+	errChan := pipeline.Merge(ctx, walkErrChan, transErrChan, saveErrChan)
+
+	for doneChan != nil || errChan != nil {
+		select {
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			fmt.Fprintf(w, "error: %v\n", err)
+		case <-doneChan:
+			doneChan = nil
+		}
+	}
+
+	fmt.Fprintf(w, "%d files, %d bytes\n", sum.files, sum.bytes)
+	if sum.files > 0 {
+		fmt.Fprintf(w, "largest: %s (%d bytes)\n", sum.largest.path, sum.largest.size)
+	}
+	return nil
+}
+
+func transform(_ context.Context, path string) (fileSize, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileSize{}, err
+	}
+	return fileSize{path: path, size: info.Size()}, nil
+}
@@ -0,0 +1,48 @@
+// Package lesson030 is the pipeline of lesson 30, fetching URLs a few at a
+// time. cmd/lesson_030 runs it on the URLs given as arguments.
+package lesson030
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	URLs []string
+	// Client makes the requests.
+	Client *http.Client
+	// Concurrency is how many requests are in flight at most.
+	Concurrency int
+}
+
+// DefaultConfig is the config of a run on urls: 3 requests at a time, each
+// given 10s.
+func DefaultConfig(urls ...string) Config {
+	return Config{URLs: urls, Client: &http.Client{Timeout: 10 * time.Second}, Concurrency: 3}
+}
+
+// Run fetches every URL and prints to w, in the order the requests complete,
+// its status and size or why it could not be fetched. It returns the error
+// of the sink.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	save := func(_ context.Context, result pipeline.FetchResult) error {
+		if result.Err != nil {
+			fmt.Fprintf(w, "%s: %v\n", result.URL, result.Err)
+			return nil
+		}
+		fmt.Fprintf(w, "%s: %d (%d bytes)\n", result.URL, result.Status, len(result.Body))
+		return nil
+	}
+	urlChan := pipeline.Source(ctx, cfg.URLs)
+	resultChan := pipeline.FetchURLs(ctx, urlChan, cfg.Client, cfg.Concurrency)
+	_, saveErrChan := pipeline.Sink(ctx, resultChan, save)
+
+	// The error channel is closed once the sink is done.
+	return <-saveErrChan
+}
@@ -0,0 +1,90 @@
+// Package lesson031 is the pipeline of lesson 31, doubling a column of a
+// CSV. cmd/lesson_031 runs it from stdin to stdout.
+package lesson031
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// In is the CSV read, whose first record is its header.
+	In io.Reader
+	// Column is the zero-based index of the column doubled.
+	Column int
+	// Stderr gets the records that cannot be doubled; nil means os.Stderr.
+	Stderr io.Writer
+}
+
+// Run writes the CSV of cfg.In to w with its Column doubled. A record that
+// is malformed or has no number in Column is reported to Stderr and skipped,
+// so Run does not fail.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	stderr := cfg.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	// Both may be the same writer, which SinkCSV writes to while Run reports
+	// the errors.
+	var mu sync.Mutex
+	w, stderr = &syncWriter{mu: &mu, w: w}, &syncWriter{mu: &mu, w: stderr}
+
+	transform := func(_ context.Context, record []string) ([]string, error) {
+		if cfg.Column >= len(record) {
+			return nil, fmt.Errorf("record %v has no column %d", record, cfg.Column)
+		}
+		num, err := strconv.Atoi(record[cfg.Column])
+		if err != nil {
+			return nil, err
+		}
+		out := append([]string(nil), record...)
+		out[cfg.Column] = strconv.Itoa(num * 2)
+		return out, nil
+	}
+
+	recordChan, readErrChan := pipeline.FromCSV(ctx, cfg.In, false, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	header, ok := <-recordChan
+	if !ok {
+		if err := <-readErrChan; err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+		}
+		return nil
+	}
+	transChan, transErrChan := pipeline.Stage(ctx, recordChan, transform, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	doneChan, saveErrChan := pipeline.SinkCSV(ctx, transChan, w, header)
+	errChan := pipeline.Merge(ctx, readErrChan, transErrChan, saveErrChan)
+
+	for doneChan != nil || errChan != nil {
+		select {
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			fmt.Fprintf(stderr, "error: %v\n", err)
+		case <-doneChan:
+			doneChan = nil
+		}
+	}
+	return nil
+}
+
+// syncWriter serializes the writes of several goroutines to w with mu, which
+// may be shared with the syncWriters of other writers.
+type syncWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
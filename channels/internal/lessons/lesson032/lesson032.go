@@ -0,0 +1,76 @@
+// Package lesson032 is the pipeline of lesson 32, generating its numbers at
+// a fixed interval. cmd/lesson_032 runs it from the command line.
+package lesson032
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Count is how many numbers are generated, from 0 on.
+	Count int
+	// Interval is how long the generator waits between two numbers.
+	Interval time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: the numbers 0 to 9,
+// one every 200ms.
+func DefaultConfig() Config {
+	return Config{Count: 10, Interval: 200 * time.Millisecond}
+}
+
+// Run saves the doubled numbers as they are generated, printing them to w,
+// and then how long it took. It returns the first error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	save := func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	}
+	start := time.Now()
+	genChan := pipeline.GenerateFunc(ctx, cfg.Interval, func(i int) (int, bool) {
+		return i, i < cfg.Count
+	})
+	transChan, transErrChan := pipeline.Stage(ctx, genChan, transform)
+	doneChan, saveErrChan := pipeline.Sink(ctx, transChan, save)
+	errChan := pipeline.Merge(ctx, transErrChan, saveErrChan)
+
+	var firstErr error
+	for doneChan != nil || errChan != nil {
+		select {
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			cancel()
+		case <-doneChan:
+			doneChan = nil
+		}
+	}
+
+	switch {
+	case firstErr != nil:
+		return firstErr
+	case ctx.Err() != nil:
+		fmt.Fprintf(w, "interrupted after %v\n", time.Since(start).Round(time.Millisecond))
+		return nil
+	}
+	fmt.Fprintf(w, "successfully finished processing in %v\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func transform(_ context.Context, num int) (int, error) {
+	return num * 2, nil
+}
@@ -0,0 +1,59 @@
+// Package lesson033 is the pipeline of lesson 33, built from iterators: its
+// source is a sequence and its sink a range loop. cmd/lesson_033 runs it from
+// the command line.
+package lesson033
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	Nums []int
+	// FailOn is the number that makes transform fail.
+	FailOn int
+	// Delay is how long transform and the sink take for every number.
+	Delay time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: the numbers 0 to 10,
+// failing on 6, taking 100ms in every stage.
+func DefaultConfig() Config {
+	return Config{Nums: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, FailOn: 6, Delay: 100 * time.Millisecond}
+}
+
+// Run saves the doubled numbers, printing them to w, until transform fails,
+// and returns its error.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	transform := func(_ context.Context, num int) (int, error) {
+		time.Sleep(cfg.Delay)
+		if num == cfg.FailOn {
+			return 0, fmt.Errorf("number %d is invalid", num)
+		}
+		return num * 2, nil
+	}
+
+	genChan := pipeline.FromSeq(ctx, slices.Values(cfg.Nums))
+	transChan, transErrChan := pipeline.Stage(ctx, genChan, transform)
+	// Merge keeps reading the error channel while the loop below runs, so a
+	// failing transform can close its output.
+	errChan := pipeline.Merge(ctx, transErrChan)
+
+	// The sink is a plain range loop over the stage output.
+	for num := range pipeline.ToSeq(ctx, transChan) {
+		time.Sleep(cfg.Delay)
+		fmt.Fprintf(w, "saved %d\n", num)
+	}
+
+	if err, ok := <-errChan; ok {
+		return err
+	}
+	fmt.Fprintf(w, "successfully finished processing\n")
+	return nil
+}
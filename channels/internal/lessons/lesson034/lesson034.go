@@ -0,0 +1,50 @@
+// Package lesson034 is the pipeline of lesson 34, counting the numbers
+// generated in every tumbling window. cmd/lesson_034 runs it from the command
+// line.
+package lesson034
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Count is how many numbers are generated.
+	Count int
+	// Interval is how long the generator waits between two numbers.
+	Interval time.Duration
+	// Window is the length of a window.
+	Window time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: 30 numbers, one every
+// 100ms, in windows of 500ms.
+func DefaultConfig() Config {
+	return Config{Count: 30, Interval: 100 * time.Millisecond, Window: 500 * time.Millisecond}
+}
+
+// Run prints to w how many numbers every window got, until the generator is
+// done, and returns the error of the sink.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	save := func(_ context.Context, n int) error {
+		fmt.Fprintf(w, "window of %v: %d items\n", cfg.Window, n)
+		return nil
+	}
+	genChan := pipeline.GenerateFunc(ctx, cfg.Interval, func(i int) (int, bool) {
+		return i, i < cfg.Count
+	})
+	countChan := pipeline.WindowTumbling(ctx, genChan, cfg.Window, count)
+	_, saveErrChan := pipeline.Sink(ctx, countChan, save)
+
+	// The error channel is closed once the sink is done.
+	return <-saveErrChan
+}
+
+func count(window []int) int {
+	return len(window)
+}
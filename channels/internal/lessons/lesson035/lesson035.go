@@ -0,0 +1,59 @@
+// Package lesson035 is the pipeline of lesson 35, passing its failures on
+// in Result envelopes rather than on an error channel. cmd/lesson_035 runs it
+// from the command line.
+package lesson035
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	Nums []int
+	// FailOn is the number that makes transform fail.
+	FailOn int
+	// Delay is how long transform takes for every number.
+	Delay time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: the numbers 0 to 10,
+// failing on 6, taking 100ms for each.
+func DefaultConfig() Config {
+	return Config{Nums: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, FailOn: 6, Delay: 100 * time.Millisecond}
+}
+
+// Run saves the doubled numbers and reports the failed ones in between,
+// printing both to w. It returns the error of the sink.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	transform := func(_ context.Context, num int) (int, error) {
+		time.Sleep(cfg.Delay)
+		if num == cfg.FailOn {
+			return 0, fmt.Errorf("number %d is invalid", num)
+		}
+		return num * 2, nil
+	}
+	save := func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	}
+	report := func(_ context.Context, err error) error {
+		fmt.Fprintf(w, "failed: %v\n", err)
+		return nil
+	}
+
+	genChan := pipeline.ToResults(ctx, pipeline.Source(ctx, cfg.Nums), nil)
+	transChan := pipeline.StageR(ctx, genChan, transform, pipeline.WithName("transform"))
+	_, saveErrChan := pipeline.SinkR(ctx, transChan, save, report)
+
+	// The error channel is closed once the sink is done.
+	if err := <-saveErrChan; err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "successfully finished processing\n")
+	return nil
+}
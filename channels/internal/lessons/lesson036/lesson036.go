@@ -0,0 +1,110 @@
+// Package lesson036 is the pipeline of lesson 36, printing the stats of its
+// stages while a slow sink fills their buffers. cmd/lesson_036 runs it from
+// the command line.
+package lesson036
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Count is how many numbers are generated, from 0 on.
+	Count int
+	// Workers is how many transform workers there are.
+	Workers int
+	// TransformDelay and SaveDelay are how long transform and save take for
+	// every number.
+	TransformDelay time.Duration
+	SaveDelay      time.Duration
+	// StatsEvery is how often the stats are printed while the pipeline is
+	// running. They are printed once more when it is done.
+	StatsEvery time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: 40 numbers through 4
+// workers taking 10ms and a save taking 100ms, with the stats every second.
+func DefaultConfig() Config {
+	return Config{
+		Count:          40,
+		Workers:        4,
+		TransformDelay: 10 * time.Millisecond,
+		SaveDelay:      100 * time.Millisecond,
+		StatsEvery:     time.Second,
+	}
+}
+
+// Run runs the pipeline, printing the stats of its stages to w as it goes
+// and when it is done. It returns the first error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	registry := pipeline.NewRegistry()
+	options := func(name string) []pipeline.Option {
+		return []pipeline.Option{
+			pipeline.WithName(name),
+			pipeline.WithRegistry(registry),
+			pipeline.WithMetrics(&pipeline.Metrics{}),
+			pipeline.WithBuffer(10),
+		}
+	}
+	transform := func(_ context.Context, num int) (int, error) {
+		time.Sleep(cfg.TransformDelay)
+		return num * 2, nil
+	}
+	save := func(_ context.Context, num int) error {
+		time.Sleep(cfg.SaveDelay)
+		return nil
+	}
+
+	nums := make([]int, cfg.Count)
+	for i := range nums {
+		nums[i] = i
+	}
+	genChan := pipeline.SourceItems(ctx, nums, nil, options("generator")...)
+	transChan, transErrChan := pipeline.StagePoolItems(ctx, genChan, cfg.Workers, transform, options("transform")...)
+	// The sink is deliberately slow, so the buffers before it fill up.
+	doneChan, saveErrChan := pipeline.SinkItems(ctx, transChan, save, options("save")...)
+	errChan := pipeline.Merge(ctx, transErrChan, saveErrChan)
+
+	ticker := time.NewTicker(cfg.StatsEvery)
+	defer ticker.Stop()
+	var firstErr error
+	for doneChan != nil || errChan != nil {
+		select {
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			cancel()
+		case <-doneChan:
+			doneChan = nil
+		case <-ticker.C:
+			printStats(w, registry.Snapshot())
+		}
+	}
+	printStats(w, registry.Snapshot())
+	return firstErr
+}
+
+func printStats(w io.Writer, stats []pipeline.StageStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "stage\tin\tout\terrors\tbuffer\tprocessing\tlatency\t")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.0f%%\t%v\t%v\t\n", s.Name, s.In, s.Out, s.Errors, 100*s.Occupancy,
+			s.AvgProcessing.Round(time.Millisecond), s.AvgLatency.Round(time.Millisecond))
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
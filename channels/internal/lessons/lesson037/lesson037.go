@@ -0,0 +1,104 @@
+// Package lesson037 is the pipeline of lesson 37, a Graph whose transform
+// feeds a save sink and an audit sink, which gets its errors as well.
+// cmd/lesson_037 runs it from the command line.
+package lesson037
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	Nums []int
+	// FailOn is the number that makes transform fail, which is skipped and
+	// audited.
+	FailOn int
+	// FailSave is the saved number that makes save fail, and with it the
+	// graph; -1 for none.
+	FailSave int
+	// Delay is how long transform takes for every number.
+	Delay time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: the numbers 0 to 10,
+// failing transform on 6 and nothing in save, taking 100ms for each.
+func DefaultConfig() Config {
+	return Config{
+		Nums:     []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		FailOn:   6,
+		FailSave: -1,
+		Delay:    100 * time.Millisecond,
+	}
+}
+
+// Run runs the graph, printing what save and audit get to w, and returns
+// its error.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	// save and audit print concurrently.
+	w = &syncWriter{w: w}
+	nums := make([]any, len(cfg.Nums))
+	for i, num := range cfg.Nums {
+		nums[i] = num
+	}
+	transform := func(_ context.Context, item any) (any, error) {
+		num := item.(int)
+		time.Sleep(cfg.Delay)
+		if num == cfg.FailOn {
+			return nil, fmt.Errorf("number %d is invalid", num)
+		}
+		return num * 2, nil
+	}
+	audit := func(_ context.Context, item any) error {
+		if err, ok := item.(error); ok {
+			fmt.Fprintf(w, "audit: failed: %v\n", err)
+			return nil
+		}
+		fmt.Fprintf(w, "audit: passed %v\n", item)
+		return nil
+	}
+
+	// nums -> transform -> save
+	//                   \-> audit <- transform errors
+	g := pipeline.NewGraph()
+	g.AddSource("nums", func(ctx context.Context) (<-chan any, <-chan error) {
+		return pipeline.Source(ctx, nums), nil
+	})
+	g.AddStage("transform", transform, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	g.AddSink("save", func(_ context.Context, item any) error {
+		num := item.(int)
+		if num == cfg.FailSave {
+			return fmt.Errorf("cannot save %d", num)
+		}
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	})
+	g.AddSink("audit", audit)
+	g.Connect("nums", "transform")
+	g.Connect("transform", "save")
+	g.Connect("transform", "audit")
+	g.ConnectErrors("transform", "audit")
+
+	if err := g.Run(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "successfully finished processing\n")
+	return nil
+}
+
+// syncWriter serializes the writes of several goroutines to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
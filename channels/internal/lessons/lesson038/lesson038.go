@@ -0,0 +1,101 @@
+// Package lesson038 is the pipeline of lesson 38, warning about a save so
+// slow that transform keeps waiting for it. cmd/lesson_038 runs it from the
+// command line.
+package lesson038
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	Nums []int
+	// TransformDelay and SaveDelay are how long transform and save take for
+	// every number.
+	TransformDelay time.Duration
+	SaveDelay      time.Duration
+	// SlowAfter is how long transform waits for save before a warning.
+	SlowAfter time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: the numbers 0 to 10,
+// taking 100ms in transform and 500ms in save, with a warning after 200ms.
+func DefaultConfig() Config {
+	return Config{
+		Nums:           []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		TransformDelay: 100 * time.Millisecond,
+		SaveDelay:      500 * time.Millisecond,
+		SlowAfter:      200 * time.Millisecond,
+	}
+}
+
+// Run saves the doubled numbers, printing them and the warnings about save to
+// w. It returns the first error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The warnings are printed while save is.
+	w = &syncWriter{w: w}
+
+	transform := func(_ context.Context, num int) (int, error) {
+		time.Sleep(cfg.TransformDelay)
+		return num * 2, nil
+	}
+	save := func(_ context.Context, num int) error {
+		time.Sleep(cfg.SaveDelay)
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	}
+
+	genChan := pipeline.SourceItems(ctx, cfg.Nums, nil, pipeline.WithName("generator"))
+	transChan, transErrChan := pipeline.StageItems(ctx, genChan, transform, pipeline.WithName("transform"))
+	// save takes far longer than transform, so transform spends most of its
+	// time waiting for save to accept the next item.
+	doneChan, saveErrChan := pipeline.SinkItems(ctx, transChan, save,
+		pipeline.WithName("save"),
+		pipeline.WithSlowWarning(cfg.SlowAfter, func(e pipeline.SlowEvent) {
+			fmt.Fprintf(w, "slow consumer: %s made %v wait %v (queue %d/%d)\n",
+				e.Stage, e.Item, e.Waited.Round(time.Millisecond), e.Queued, e.Capacity)
+		}))
+	errChan := pipeline.Merge(ctx, transErrChan, saveErrChan)
+
+	var firstErr error
+	for doneChan != nil || errChan != nil {
+		select {
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			cancel()
+		case <-doneChan:
+			doneChan = nil
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	fmt.Fprintf(w, "successfully finished processing\n")
+	return nil
+}
+
+// syncWriter serializes the writes of several goroutines to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
@@ -0,0 +1,98 @@
+// Package lesson039 is the pipeline of lesson 39, whose save and audit
+// branches are decoupled by bulkheads, so that a hung audit does not stall
+// save. cmd/lesson_039 runs it from the command line.
+package lesson039
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Count is how many numbers are generated, from 0 on.
+	Count int
+	// Bulkheads decouples the save and audit branches.
+	Bulkheads bool
+	// Delay is how long transform takes for every number.
+	Delay time.Duration
+	// Hang is how long audit hangs on its first number.
+	Hang time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: 20 numbers through
+// bulkheads, taking 50ms in transform, with audit hanging for 2s.
+func DefaultConfig() Config {
+	return Config{Count: 20, Bulkheads: true, Delay: 50 * time.Millisecond, Hang: 2 * time.Second}
+}
+
+// Run saves and audits the doubled numbers, printing them to w with the time
+// they were saved at, and then how many of them audit dropped. It returns the
+// first error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// save and audit print concurrently.
+	w = &syncWriter{w: w}
+
+	transform := func(_ context.Context, num int) (int, error) {
+		time.Sleep(cfg.Delay)
+		return num * 2, nil
+	}
+	// audit hangs on its first item, like a downstream service that stopped
+	// answering for a while.
+	audit := func(_ context.Context, num int) error {
+		if num == 0 {
+			time.Sleep(cfg.Hang)
+		}
+		fmt.Fprintf(w, "audited %d\n", num)
+		return nil
+	}
+
+	nums := make([]int, cfg.Count)
+	for i := range nums {
+		nums[i] = i
+	}
+	start := time.Now()
+	genChan := pipeline.Source(ctx, nums)
+	transChan, transErrChan := pipeline.Stage(ctx, genChan, transform)
+	saveChan, auditChan := pipeline.Tee(ctx, transChan)
+
+	auditStats := func() pipeline.DroppedStats { return pipeline.DroppedStats{} }
+	if cfg.Bulkheads {
+		// Without them the tee waits for the hung audit branch and save
+		// stalls with it.
+		saveChan, _ = pipeline.Bulkhead(ctx, saveChan, 5, pipeline.Block)
+		auditChan, auditStats = pipeline.Bulkhead(ctx, auditChan, 5, pipeline.DropNewest)
+	}
+
+	saveDone, saveErrChan := pipeline.Sink(ctx, saveChan, func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "%5v saved %d\n", time.Since(start).Round(time.Millisecond), num)
+		return nil
+	})
+	auditDone, auditErrChan := pipeline.Sink(ctx, auditChan, audit)
+	doneChan := pipeline.Merge(ctx, saveDone, auditDone)
+	err := pipeline.WaitAll(ctx, doneChan, transErrChan, saveErrChan, auditErrChan)
+	cancel()
+
+	stats := auditStats()
+	fmt.Fprintf(w, "audit dropped %d of %d items\n", stats.Dropped, stats.Received)
+	return err
+}
+
+// syncWriter serializes the writes of several goroutines to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
@@ -0,0 +1,98 @@
+// Package lesson040 is the pipeline of lesson 40, hashing a file read in
+// chunks. cmd/lesson_040 runs it from the command line.
+package lesson040
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Path is the file hashed.
+	Path string
+	// ChunkSize is how many bytes are read at a time.
+	ChunkSize int
+}
+
+// DefaultConfig is the config of a run on the file at path, read 64KiB at a
+// time.
+func DefaultConfig(path string) Config {
+	return Config{Path: path, ChunkSize: 64 << 10}
+}
+
+// Run prints the SHA-256 of the file to w the way sha256sum does, and checks
+// it against the digest of the whole file at once. It returns the first
+// error of either stage, or a mismatch.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	f, err := os.Open(cfg.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sum, err := hashReader(ctx, f, cfg.ChunkSize)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	fmt.Fprintf(w, "%x  %s\n", sum, cfg.Path)
+
+	// Hashing the whole file at once must give the same digest.
+	data, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		return err
+	}
+	if want := sha256.Sum256(data); !bytes.Equal(want[:], sum) {
+		return fmt.Errorf("digest mismatch, sha256.Sum256 gives %x", want)
+	}
+	return nil
+}
+
+// hashReader computes the SHA-256 of r by reading it in chunks through the
+// pipeline. It returns the first error of either stage.
+func hashReader(ctx context.Context, r io.Reader, chunkSize int) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	h := sha256.New()
+	chunkChan, readErrChan := pipeline.ReadChunks(ctx, r, chunkSize)
+	doneChan, hashErrChan := pipeline.Sink(ctx, chunkChan, hashInto(h))
+	errChan := pipeline.Merge(ctx, readErrChan, hashErrChan)
+
+	var firstErr error
+	for doneChan != nil || errChan != nil {
+		select {
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			cancel()
+		case <-doneChan:
+			doneChan = nil
+		}
+	}
+	return h.Sum(nil), firstErr
+}
+
+// hashInto returns a sink function that feeds every chunk to h in order, which
+// is why it must not run in a pool.
+func hashInto(h hash.Hash) func(context.Context, []byte) error {
+	return func(_ context.Context, chunk []byte) error {
+		_, err := h.Write(chunk)
+		return err
+	}
+}
@@ -0,0 +1,39 @@
+package lesson040
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashReaderMatchesSum256(t *testing.T) {
+	const chunkSize = 4 << 10
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, size := range []int{0, 1, chunkSize, 3 * chunkSize, 3*chunkSize + 17} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(rng.Uint32())
+		}
+		path := filepath.Join(t.TempDir(), "data")
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sum, err := hashReader(context.Background(), f, chunkSize)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if want := sha256.Sum256(data); !bytes.Equal(sum, want[:]) {
+			t.Errorf("%d bytes: digest %x, want %x", size, sum, want)
+		}
+	}
+}
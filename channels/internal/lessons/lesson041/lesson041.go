@@ -0,0 +1,105 @@
+// Package lesson041 is the pipeline of lesson 41, compressing a file with
+// gzip and decompressing it again, a chunk at a time. cmd/lesson_041 runs it
+// from the command line.
+package lesson041
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Path is the file compressed into Path.gz.
+	Path string
+	// Stderr gets the line saying where the file was compressed into; nil
+	// means os.Stderr.
+	Stderr io.Writer
+}
+
+// Run compresses the file into the one beside it with the .gz suffix, and
+// decompresses that to w. It returns the first error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	stderr := cfg.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	gzPath := cfg.Path + ".gz"
+	if err := compress(ctx, cfg.Path, gzPath); err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "compressed %s into %s\n", cfg.Path, gzPath)
+	return decompress(ctx, gzPath, w)
+}
+
+// compress runs ReadChunks -> GzipCompress -> file sink.
+func compress(ctx context.Context, src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chunkChan, readErrChan := pipeline.ReadChunks(ctx, in, 64<<10)
+	gzChan, gzErrChan := pipeline.GzipCompress(ctx, chunkChan, gzip.DefaultCompression)
+	_, saveErrChan := pipeline.Sink(ctx, gzChan, writeTo(out))
+	err = wait(cancel, pipeline.Merge(context.Background(), readErrChan, gzErrChan, saveErrChan))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// decompress runs the reverse, ReadChunks -> GzipDecompress -> w.
+func decompress(ctx context.Context, src string, w io.Writer) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chunkChan, readErrChan := pipeline.ReadChunks(ctx, in, 64<<10)
+	plainChan, gunzipErrChan := pipeline.GzipDecompress(ctx, chunkChan)
+	_, saveErrChan := pipeline.Sink(ctx, plainChan, writeTo(w))
+	if err := wait(cancel, pipeline.Merge(context.Background(), readErrChan, gunzipErrChan, saveErrChan)); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// wait returns the first error from errChan, cancelling the rest of the
+// pipeline, once every stage has closed its error channel. errChan must not
+// stop with ctx, or the sink could still be writing when wait returns.
+func wait(cancel context.CancelFunc, errChan <-chan error) error {
+	var first error
+	for err := range errChan {
+		if first == nil {
+			first = err
+			cancel()
+		}
+	}
+	return first
+}
+
+func writeTo(w io.Writer) func(context.Context, []byte) error {
+	return func(_ context.Context, chunk []byte) error {
+		_, err := w.Write(chunk)
+		return err
+	}
+}
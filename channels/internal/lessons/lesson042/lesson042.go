@@ -0,0 +1,61 @@
+// Package lesson042 is the pipeline of lesson 42, whose source repeats a few
+// numbers forever and is bounded by Take. cmd/lesson_042 runs it from the
+// command line.
+package lesson042
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Repeat are the numbers repeated.
+	Repeat []int
+	// Take is how many numbers are taken of them.
+	Take int
+	// Delay is how long transform takes for every number.
+	Delay time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: 10 numbers of 1, 2
+// and 3 repeated, taking 100ms for each.
+func DefaultConfig() Config {
+	return Config{Repeat: []int{1, 2, 3}, Take: 10, Delay: 100 * time.Millisecond}
+}
+
+// Run saves the doubled numbers, printing them to w, and returns the first
+// error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	// Repeat never ends on its own: Take bounds it, and the cancel deferred
+	// below stops the Repeat goroutine once Run returns.
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	transform := func(_ context.Context, num int) (int, error) {
+		time.Sleep(cfg.Delay)
+		return num * 2, nil
+	}
+	save := func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	}
+	genChan := pipeline.Take(runCtx, pipeline.Repeat(runCtx, cfg.Repeat...), cfg.Take)
+	transChan, transErrChan := pipeline.Stage(runCtx, genChan, transform)
+	_, saveErrChan := pipeline.Sink(runCtx, transChan, save)
+
+	// The errors are merged without ctx so the loop only ends once both
+	// stages are done.
+	for err := range pipeline.Merge(context.Background(), transErrChan, saveErrChan) {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	fmt.Fprintf(w, "successfully finished processing\n")
+	return nil
+}
@@ -0,0 +1,67 @@
+// Package lesson043 is the pipeline of lesson 43, partitioning the numbers
+// into the even and the odd ones, saved by sinks of their own.
+// cmd/lesson_043 runs it from the command line.
+package lesson043
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	Nums []int
+}
+
+// DefaultConfig is the config of a run without flags: the numbers 0 to 10.
+func DefaultConfig() Config {
+	return Config{Nums: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}
+}
+
+// Run saves the even and the odd numbers, printing them to w, and returns the
+// first error of a sink.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	// The sinks print concurrently.
+	w = &syncWriter{w: w}
+	save := func(kind string) func(context.Context, int) error {
+		return func(_ context.Context, num int) error {
+			fmt.Fprintf(w, "saved %s %d\n", kind, num)
+			return nil
+		}
+	}
+
+	genChan := pipeline.Source(ctx, cfg.Nums)
+	evenChan, oddChan := pipeline.Partition(ctx, genChan, isEven)
+	// Both sides are consumed, or the partition would stall on the other.
+	_, evenErrChan := pipeline.Sink(ctx, evenChan, save("even"))
+	_, oddErrChan := pipeline.Sink(ctx, oddChan, save("odd"))
+
+	for err := range pipeline.Merge(context.Background(), evenErrChan, oddErrChan) {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	fmt.Fprintf(w, "successfully finished processing\n")
+	return nil
+}
+
+func isEven(num int) bool {
+	return num%2 == 0
+}
+
+// syncWriter serializes the writes of several goroutines to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
@@ -0,0 +1,119 @@
+// Package lesson044 is the pipeline of lesson 44, retrying its failed
+// numbers after a delay through a DelayQueue. cmd/lesson_044 runs it from the
+// command line.
+package lesson044
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	Nums []int
+	// FailOn always fails transform, and FailOnce only on the first try.
+	FailOn   int
+	FailOnce int
+	// RetryDelays is how long a failed number waits before each retry. Once
+	// they are used up it becomes a dead letter.
+	RetryDelays []time.Duration
+	// Delay is how long transform takes for every number.
+	Delay time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: the numbers 0 to 10,
+// always failing on 6 and once on 3, retried after 1s and then 2s, taking
+// 100ms for each.
+func DefaultConfig() Config {
+	return Config{
+		Nums:        []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		FailOn:      6,
+		FailOnce:    3,
+		RetryDelays: []time.Duration{time.Second, 2 * time.Second},
+		Delay:       100 * time.Millisecond,
+	}
+}
+
+type job struct {
+	num     int
+	attempt int
+}
+
+// Run saves the doubled numbers and retries the failed ones, printing both to
+// w, until every number has been saved or given up on. It returns the first
+// error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	// The retry queue never closes on its own, so the pipeline is stopped
+	// once every number has been saved or given up on.
+	workCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	// save prints while the retries are.
+	w = &syncWriter{w: w}
+
+	var pending atomic.Int64
+	pending.Store(int64(len(cfg.Nums)))
+	finish := func() {
+		if pending.Add(-1) == 0 {
+			stop()
+		}
+	}
+	transform := func(_ context.Context, j job) (int, error) {
+		time.Sleep(cfg.Delay)
+		if j.num == cfg.FailOn || (j.num == cfg.FailOnce && j.attempt == 0) {
+			return 0, fmt.Errorf("number %d is invalid", j.num)
+		}
+		return j.num * 2, nil
+	}
+
+	jobs := make([]job, len(cfg.Nums))
+	for i, num := range cfg.Nums {
+		jobs[i] = job{num: num}
+	}
+	enqueue, retryChan := pipeline.DelayQueue[job](workCtx)
+	genChan := pipeline.Merge(workCtx, pipeline.Source(workCtx, jobs), retryChan)
+	transChan, deadChan, transErrChan := pipeline.StageWithDeadLetters(workCtx, genChan, transform,
+		pipeline.WithName("transform"), pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	_, saveErrChan := pipeline.Sink(workCtx, transChan, func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "saved %d\n", num)
+		finish()
+		return nil
+	})
+	errChan := pipeline.Merge(context.Background(), transErrChan, saveErrChan)
+
+	for dead := range deadChan {
+		j := dead.Item
+		if j.attempt < len(cfg.RetryDelays) {
+			fmt.Fprintf(w, "retrying %d in %v: %v\n", j.num, cfg.RetryDelays[j.attempt], dead.Err)
+			enqueue(job{num: j.num, attempt: j.attempt + 1}, cfg.RetryDelays[j.attempt])
+			continue
+		}
+		fmt.Fprintf(w, "dead letter %d: %v\n", j.num, dead.Err)
+		finish()
+	}
+	for err := range errChan {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	fmt.Fprintf(w, "successfully finished processing\n")
+	return nil
+}
+
+// syncWriter serializes the writes of several goroutines to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
@@ -0,0 +1,57 @@
+// Package lesson045 is the pipeline of lesson 45, saving its numbers to a
+// file that only appears once all of them are written. cmd/lesson_045 runs
+// it from the command line.
+package lesson045
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	Nums []int
+	// Path is the file the doubled numbers are written to, one per line.
+	Path string
+	// Delay is how long transform takes for every number.
+	Delay time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: the numbers 0 to 10,
+// taking 100ms for each, saved to doubled.txt.
+func DefaultConfig() Config {
+	return Config{Nums: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, Path: "doubled.txt", Delay: 100 * time.Millisecond}
+}
+
+// Run saves the doubled numbers to the file and prints to w where they went.
+// It returns the first error of a stage, in which case the file is not
+// created, and neither is it if ctx is cancelled.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	transform := func(_ context.Context, num int) (int, error) {
+		time.Sleep(cfg.Delay)
+		return num * 2, nil
+	}
+	genChan := pipeline.Source(ctx, cfg.Nums)
+	transChan, transErrChan := pipeline.Stage(ctx, genChan, transform)
+	lineChan := pipeline.Map(ctx, transChan, line)
+	_, saveErrChan := pipeline.FileSink(ctx, lineChan, cfg.Path, pipeline.FileSinkOptions{})
+
+	// The errors are merged without ctx so the loop only ends once the file
+	// has been renamed into place or removed.
+	for err := range pipeline.Merge(context.Background(), transErrChan, saveErrChan) {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	fmt.Fprintf(w, "saved the numbers to %s\n", cfg.Path)
+	return nil
+}
+
+func line(num int) []byte {
+	return fmt.Appendf(nil, "%d\n", num)
+}
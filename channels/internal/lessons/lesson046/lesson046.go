@@ -0,0 +1,78 @@
+// Package lesson046 is the pipeline of lesson 46, publishing its numbers to a
+// pubsub.Broker that a save and an audit sink subscribe to. cmd/lesson_046
+// runs it from the command line.
+package lesson046
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pubsub"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	Nums []int
+	// Delay is how long transform takes for every number.
+	Delay time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: the numbers 0 to 10,
+// taking 100ms for each.
+func DefaultConfig() Config {
+	return Config{Nums: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, Delay: 100 * time.Millisecond}
+}
+
+// Run saves and audits the doubled numbers, printing them to w, and returns
+// the first error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	// The subscribers print concurrently.
+	w = &syncWriter{w: w}
+	transform := func(_ context.Context, num int) (int, error) {
+		time.Sleep(cfg.Delay)
+		return num * 2, nil
+	}
+	save := func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	}
+	audit := func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "audited %d\n", num)
+		return nil
+	}
+
+	broker := pubsub.NewBroker[int]()
+	// Subscribers only get what is published after they subscribed, so the
+	// sinks subscribe before anything is published.
+	saveChan, _ := broker.Subscribe(ctx, 0)
+	auditChan, _ := broker.Subscribe(ctx, 0)
+	saveDone, saveErrChan := pipeline.Sink(ctx, saveChan, save)
+	auditDone, auditErrChan := pipeline.Sink(ctx, auditChan, audit)
+
+	genChan := pipeline.Source(ctx, cfg.Nums)
+	transChan, transErrChan := pipeline.Stage(ctx, genChan, transform)
+	go broker.PublishAll(ctx, transChan)
+
+	doneChan := pipeline.Merge(ctx, saveDone, auditDone)
+	if err := pipeline.WaitAll(ctx, doneChan, transErrChan, saveErrChan, auditErrChan); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "successfully finished processing\n")
+	return nil
+}
+
+// syncWriter serializes the writes of several goroutines to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
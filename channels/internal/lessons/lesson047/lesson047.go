@@ -0,0 +1,95 @@
+// Package lesson047 is the pipeline of lesson 47, whose generator is paused
+// and resumed by signals while the numbers it generated already are still
+// saved. cmd/lesson_047 runs it from the command line.
+package lesson047
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Count is how many numbers are generated, from 0 on.
+	Count int
+	// Delay is how long transform takes for every number.
+	Delay time.Duration
+	// Signals pause the generator on SIGUSR1 and resume it on any other
+	// signal.
+	Signals <-chan os.Signal
+}
+
+// DefaultConfig is the config of a run on sigs: 50 numbers, taking 200ms
+// for each.
+func DefaultConfig(sigs <-chan os.Signal) Config {
+	return Config{Count: 50, Delay: 200 * time.Millisecond, Signals: sigs}
+}
+
+// Run saves the doubled numbers, printing them and every pause and resume to
+// w, and returns the first error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	// The pauses are printed while save prints.
+	w = &syncWriter{w: w}
+	controller := pipeline.NewController()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-cfg.Signals:
+				if sig == syscall.SIGUSR1 {
+					controller.Pause()
+				} else {
+					controller.Resume()
+				}
+				fmt.Fprintf(w, "generator %v\n", controller.State())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	transform := func(_ context.Context, num int) (int, error) {
+		time.Sleep(cfg.Delay)
+		return num * 2, nil
+	}
+	save := func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	}
+	nums := make([]int, cfg.Count)
+	for i := range nums {
+		nums[i] = i
+	}
+	genChan := pipeline.Source(ctx, nums, pipeline.WithController(controller))
+	transChan, transErrChan := pipeline.Stage(ctx, genChan, transform, pipeline.WithBuffer(5))
+	_, saveErrChan := pipeline.Sink(ctx, transChan, save)
+
+	for err := range pipeline.Merge(context.Background(), transErrChan, saveErrChan) {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	fmt.Fprintf(w, "successfully finished processing\n")
+	return nil
+}
+
+// syncWriter serializes the writes of several goroutines to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
@@ -0,0 +1,118 @@
+// Package lesson048 is the pipeline of lesson 48, reading its numbers from a
+// paginated API a few pages ahead of transform. cmd/lesson_048 runs it from
+// the command line.
+package lesson048
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Pages and PerPage are how many pages the API serves, and how many
+	// numbers on each.
+	Pages   int
+	PerPage int
+	// Ahead is how many pages are fetched at once.
+	Ahead int
+	// FetchDelay is how long the API takes for a page, and Delay how long
+	// transform takes for every number.
+	FetchDelay time.Duration
+	Delay      time.Duration
+}
+
+// DefaultConfig is the config of a run without flags: 5 pages of 3 numbers,
+// fetching 3 at once and taking 300ms each, with 100ms in transform for
+// every number.
+func DefaultConfig() Config {
+	return Config{
+		Pages:      5,
+		PerPage:    3,
+		Ahead:      3,
+		FetchDelay: 300 * time.Millisecond,
+		Delay:      100 * time.Millisecond,
+	}
+}
+
+// fakeAPI serves numbers the way a paginated HTTP API serves records: a page
+// at a time, each pointing at the next.
+type fakeAPI struct {
+	pages   int
+	perPage int
+	delay   time.Duration
+	w       io.Writer
+}
+
+// listNumbers returns the numbers of page cursor, the cursor of the next page
+// and whether this was the last one.
+func (a fakeAPI) listNumbers(ctx context.Context, cursor int) ([]int, int, bool, error) {
+	select {
+	case <-ctx.Done():
+		return nil, 0, false, ctx.Err()
+	case <-time.After(a.delay):
+	}
+	if cursor >= a.pages {
+		// Fetching ahead asks for pages past the last one; the API answers
+		// them as empty, and Paginate drops them.
+		return nil, cursor, true, nil
+	}
+	fmt.Fprintf(a.w, "fetched page %d\n", cursor)
+	nums := make([]int, a.perPage)
+	for i := range nums {
+		nums[i] = cursor*a.perPage + i
+	}
+	return nums, cursor + 1, cursor == a.pages-1, nil
+}
+
+// Run saves the doubled numbers of every page, printing them and the pages
+// fetched to w, and returns the first error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	// The pages are fetched while save prints.
+	w = &syncWriter{w: w}
+	transform := func(_ context.Context, num int) (int, error) {
+		time.Sleep(cfg.Delay)
+		return num * 2, nil
+	}
+	save := func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	}
+
+	api := fakeAPI{pages: cfg.Pages, perPage: cfg.PerPage, delay: cfg.FetchDelay, w: w}
+	// The pages are numbered, so the next ones are fetched while the
+	// numbers of the current one are transformed.
+	genChan, genErrChan := pipeline.Paginate(ctx, api.listNumbers, pipeline.WithPageConcurrency(cfg.Ahead))
+	transChan, transErrChan := pipeline.Stage(ctx, genChan, transform)
+	_, saveErrChan := pipeline.Sink(ctx, transChan, save)
+
+	for err := range pipeline.Merge(context.Background(), genErrChan, transErrChan, saveErrChan) {
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	fmt.Fprintf(w, "successfully finished processing\n")
+	return nil
+}
+
+// syncWriter serializes the writes of several goroutines to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
@@ -0,0 +1,110 @@
+// Package lesson049 is the pipeline of lesson 49, whose numbers are pushed
+// into it over HTTP while it runs. cmd/lesson_049 runs it from the command
+// line.
+package lesson049
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Listener gets the requests to POST /push, with a number as the body,
+	// and to POST /close. Run closes it.
+	Listener net.Listener
+	// Delay is how long transform takes for every number.
+	Delay time.Duration
+}
+
+// DefaultConfig is the config of a run on ln, taking 100ms for every number.
+func DefaultConfig(ln net.Listener) Config {
+	return Config{Listener: ln, Delay: 100 * time.Millisecond}
+}
+
+// Run saves the doubled numbers pushed, printing them to w, until /close lets
+// the pushed ones drain. It returns the first error of a stage.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	transform := func(_ context.Context, num int) (int, error) {
+		time.Sleep(cfg.Delay)
+		return num * 2, nil
+	}
+	save := func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	}
+	feeder, genChan := pipeline.DynamicSource[int](ctx, pipeline.WithBuffer(16))
+	transChan, transErrChan := pipeline.Stage(ctx, genChan, transform)
+	done, saveErrChan := pipeline.Sink(ctx, transChan, save)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /push", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		num, err := strconv.Atoi(strings.TrimSpace(string(body)))
+		if err != nil {
+			http.Error(w, "the body must be a number", http.StatusBadRequest)
+			return
+		}
+		if err := feeder.Push(r.Context(), num); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "pushed %d\n", num)
+	})
+	mux.HandleFunc("POST /close", func(w http.ResponseWriter, r *http.Request) {
+		feeder.Close()
+		fmt.Fprintln(w, "closed")
+	})
+	srv := &http.Server{Handler: mux}
+	// serveErr gets why the server stopped, unless Shutdown stopped it.
+	serveErr := make(chan error, 1)
+	go func() {
+		err := srv.Serve(cfg.Listener)
+		if !errors.Is(err, http.ErrServerClosed) {
+			cancel()
+		}
+		serveErr <- err
+	}()
+	defer func() {
+		shutdownCtx, stop := context.WithTimeout(context.Background(), time.Second)
+		defer stop()
+		srv.Shutdown(shutdownCtx)
+		<-serveErr
+	}()
+	fmt.Fprintf(w, "push numbers with: curl -d 7 %s/push\n", cfg.Listener.Addr())
+
+	for err := range pipeline.Merge(context.Background(), transErrChan, saveErrChan) {
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+	<-done
+	select {
+	case err := <-serveErr:
+		serveErr <- err
+		return fmt.Errorf("server: %w", err)
+	default:
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	fmt.Fprintf(w, "successfully finished processing\n")
+	return nil
+}
@@ -0,0 +1,56 @@
+// Package lesson050 is the pipeline of lesson 19 run one step at a time in
+// an order only a seed decides, so that every run with the same seed comes
+// out the same. cmd/lesson_050 runs it from the command line.
+package lesson050
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"channelspractice/pipeline"
+)
+
+// Config holds what the lesson is run with.
+type Config struct {
+	// Seed picks the order the stages run in.
+	Seed int64
+}
+
+// DefaultConfig is the config of a run without flags: seed 1.
+func DefaultConfig() Config {
+	return Config{Seed: 1}
+}
+
+// Run runs the pipeline with cfg.Seed, printing the saved numbers and then
+// the schedule of the run to w, and returns its error, which is the failure
+// of 6 unless the run was cancelled.
+func Run(ctx context.Context, w io.Writer, cfg Config) error {
+	save := func(_ context.Context, num int) error {
+		fmt.Fprintf(w, "saved %d\n", num)
+		return nil
+	}
+	nums := make([]int, 11)
+	for i := range nums {
+		nums[i] = i
+	}
+	p := pipeline.New(nums, pipeline.WithName("generator")).
+		ThenPool(2, transform, pipeline.WithName("transform"), pipeline.WithBuffer(2)).
+		Sink(save, pipeline.WithName("save"))
+
+	schedule, err := pipeline.RunDeterministic(ctx, cfg.Seed, p)
+	fmt.Fprint(w, schedule)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "successfully finished processing\n")
+	return nil
+}
+
+func transform(_ context.Context, num int) (int, error) {
+	if num == 6 {
+		return 0, errors.New("number is invalid")
+	}
+	return num * 2, nil
+}
@@ -0,0 +1,508 @@
+// Package lessons is the registry of the lessons that can be run from code,
+// each under the name of its command in cmd.
+//
+// A lesson is ported by moving its logic from cmd/lesson_0NN/main.go into
+// internal/lessons/lesson0NN, behind a Run taking the writer to print to and
+// its config, and leaving only the flag parsing in main. All then lists its
+// configs worth running, and TestLessons compares their output with the
+// golden files in testdata, which go test -update writes.
+package lessons
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"channelspractice/internal/lessons/lesson019"
+	"channelspractice/internal/lessons/lesson024"
+	"channelspractice/internal/lessons/lesson025"
+	"channelspractice/internal/lessons/lesson026"
+	"channelspractice/internal/lessons/lesson027"
+	"channelspractice/internal/lessons/lesson028"
+	"channelspractice/internal/lessons/lesson029"
+	"channelspractice/internal/lessons/lesson030"
+	"channelspractice/internal/lessons/lesson031"
+	"channelspractice/internal/lessons/lesson032"
+	"channelspractice/internal/lessons/lesson033"
+	"channelspractice/internal/lessons/lesson034"
+	"channelspractice/internal/lessons/lesson035"
+	"channelspractice/internal/lessons/lesson036"
+	"channelspractice/internal/lessons/lesson037"
+	"channelspractice/internal/lessons/lesson038"
+	"channelspractice/internal/lessons/lesson039"
+	"channelspractice/internal/lessons/lesson040"
+	"channelspractice/internal/lessons/lesson041"
+	"channelspractice/internal/lessons/lesson042"
+	"channelspractice/internal/lessons/lesson043"
+	"channelspractice/internal/lessons/lesson044"
+	"channelspractice/internal/lessons/lesson045"
+	"channelspractice/internal/lessons/lesson046"
+	"channelspractice/internal/lessons/lesson047"
+	"channelspractice/internal/lessons/lesson048"
+	"channelspractice/internal/lessons/lesson049"
+	"channelspractice/internal/lessons/lesson050"
+)
+
+// Lesson is a lesson with its config set.
+type Lesson struct {
+	// Doc tells what the run shows.
+	Doc string
+	// Run runs the lesson, printing to w, until it is done or ctx is
+	// cancelled.
+	Run func(ctx context.Context, w io.Writer) error
+	// Stream names the stream a line of the output belongs to, if the
+	// lesson prints several of them concurrently. The lines of a stream
+	// come out in order, those of different streams in any order. Nil
+	// means a single stream.
+	Stream func(line string) string
+}
+
+// byPrefix returns a Stream putting every line in the stream of the first of
+// prefixes it starts with, if any, and the rest in a stream of their own.
+func byPrefix(prefixes ...string) func(line string) string {
+	return byMatch(func(line, prefix string) bool { return strings.HasPrefix(line, prefix) }, prefixes...)
+}
+
+// byContent is byPrefix for lines that hold their stream anywhere.
+func byContent(streams ...string) func(line string) string {
+	return byMatch(strings.Contains, streams...)
+}
+
+func byMatch(match func(line, stream string) bool, streams ...string) func(line string) string {
+	return func(line string) string {
+		for _, stream := range streams {
+			if match(line, stream) {
+				return stream
+			}
+		}
+		return ""
+	}
+}
+
+// stageLog matches the log lines of the stages starting and stopping.
+var stageLog = regexp.MustCompile(`msg="stage \w+" stage=(\S+)`)
+
+// stageStream puts the start and the stop of every stage in a stream of
+// their own, and the other lines in the stream of sameStream.
+func stageStream(sameStream func(line string) string) func(line string) string {
+	return func(line string) string {
+		if m := stageLog.FindStringSubmatch(line); m != nil {
+			return "stage " + m[1]
+		}
+		return sameStream(line)
+	}
+}
+
+// All returns the lessons by name. Where it would not change what a lesson
+// prints, they run with shorter delays than on the command line.
+func All() map[string]Lesson {
+	success := lesson019.DefaultConfig()
+	success.FailOn = nil
+	return map[string]Lesson{
+		"lesson_019": {
+			Doc: "the number 6 fails transform and is reported as a dead letter while the rest is saved",
+			Run: func(ctx context.Context, w io.Writer) error {
+				return lesson019.Run(ctx, w, lesson019.DefaultConfig())
+			},
+			Stream: stageStream(byPrefix("saved ")),
+		},
+		"lesson_019_success": {
+			Doc: "every number is doubled and saved",
+			Run: func(ctx context.Context, w io.Writer) error {
+				return lesson019.Run(ctx, w, success)
+			},
+			Stream: stageStream(byPrefix("saved ")),
+		},
+		"lesson_024": {
+			Doc: "the numbers of every generator are received in turn",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson024.DefaultConfig()
+				cfg.Delay = 10 * time.Millisecond
+				return lesson024.Run(ctx, w, cfg)
+			},
+		},
+		"lesson_025": {
+			Doc: "the first 5 numbers are saved and the rest of the source shuts down",
+			Run: func(ctx context.Context, w io.Writer) error {
+				return lesson025.Run(ctx, w, lesson025.DefaultConfig())
+			},
+		},
+		"lesson_026": {
+			Doc: "the even numbers are saved and the odd ones counted",
+			Run: func(ctx context.Context, w io.Writer) error {
+				return lesson026.Run(ctx, w, lesson026.DefaultConfig())
+			},
+		},
+		"lesson_027": {
+			Doc: "save gets stuck on 10 and the watchdog reports it",
+			Run: func(ctx context.Context, w io.Writer) error {
+				return lesson027.Run(ctx, w, lesson027.DefaultConfig())
+			},
+		},
+		"lesson_028": {
+			Doc: "every line is upper-cased",
+			Run: func(ctx context.Context, w io.Writer) error {
+				return lesson028.Run(ctx, w, lesson028.Config{In: strings.NewReader("a line\nand Another\n\nlast\n")})
+			},
+		},
+		"lesson_029": {
+			Doc: "the files of a directory are counted and sized",
+			Run: func(ctx context.Context, w io.Writer) error {
+				files := map[string]string{"a.txt": "small", "sub/b.txt": "the largest", "sub/c.txt": "medium"}
+				return inTempDir(w, files, func(dir string, w io.Writer) error {
+					cfg := lesson029.DefaultConfig()
+					cfg.Root = dir
+					return lesson029.Run(ctx, w, cfg)
+				})
+			},
+		},
+		"lesson_030": {
+			Doc: "every URL is fetched, or reported as failing",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson030.DefaultConfig(site.url("/"), site.url("/missing"), site.url("/about"), "http://down.test/")
+				cfg.Client = &http.Client{Transport: site}
+				return lesson030.Run(ctx, w, cfg)
+			},
+			// The requests complete in any order.
+			Stream: func(line string) string { return line },
+		},
+		"lesson_031": {
+			Doc: "the value column is doubled, skipping the records without a number in it",
+			Run: func(ctx context.Context, w io.Writer) error {
+				in := "name,value\na,1\nb,x\nc,3\nd\ne,5\n"
+				return lesson031.Run(ctx, w, lesson031.Config{In: strings.NewReader(in), Column: 1, Stderr: w})
+			},
+			Stream: byPrefix("error: "),
+		},
+		"lesson_032": {
+			Doc: "the numbers are saved as they are generated",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson032.DefaultConfig()
+				cfg.Interval = 10 * time.Millisecond
+				return lesson032.Run(ctx, w, cfg)
+			},
+		},
+		"lesson_033": {
+			Doc: "the numbers before 6 are saved by a range loop, then transform fails",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson033.DefaultConfig()
+				cfg.Delay = 10 * time.Millisecond
+				return lesson033.Run(ctx, w, cfg)
+			},
+		},
+		"lesson_034": {
+			Doc: "the numbers are counted in a window that takes all of them",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson034.DefaultConfig()
+				// How many numbers smaller windows get depends on the
+				// timing.
+				cfg.Interval, cfg.Window = time.Millisecond, time.Hour
+				return lesson034.Run(ctx, w, cfg)
+			},
+		},
+		"lesson_035": {
+			Doc: "the failure of 6 is reported in its place among the saved numbers",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson035.DefaultConfig()
+				cfg.Delay = 10 * time.Millisecond
+				return lesson035.Run(ctx, w, cfg)
+			},
+		},
+		"lesson_036": {
+			Doc: "the stats count every number in and out of every stage",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson036.DefaultConfig()
+				// What the stats say while the run goes on depends on
+				// the timing.
+				cfg.TransformDelay, cfg.SaveDelay, cfg.StatsEvery = time.Millisecond, 5*time.Millisecond, time.Hour
+				return lesson036.Run(ctx, w, cfg)
+			},
+		},
+		"lesson_037": {
+			Doc: "save gets the doubled numbers, audit those and the failure of 6",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson037.DefaultConfig()
+				cfg.Delay = 10 * time.Millisecond
+				return lesson037.Run(ctx, w, cfg)
+			},
+			Stream: byPrefix("saved ", "audit: passed ", "audit: failed: "),
+		},
+		"lesson_038": {
+			Doc: "save keeps transform waiting for every number after the first",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson038.DefaultConfig()
+				cfg.TransformDelay, cfg.SaveDelay, cfg.SlowAfter = 10*time.Millisecond, 100*time.Millisecond, 40*time.Millisecond
+				return lesson038.Run(ctx, w, cfg)
+			},
+			Stream: byPrefix("saved ", "slow consumer: "),
+		},
+		"lesson_039": {
+			Doc: "save keeps going while audit hangs, which drops what its bulkhead cannot hold",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson039.DefaultConfig()
+				cfg.Delay, cfg.Hang = 10*time.Millisecond, time.Second
+				return lesson039.Run(ctx, w, cfg)
+			},
+			Stream: byContent(" saved ", "audited "),
+		},
+		"lesson_039_no_bulkheads": {
+			Doc: "save stalls with the hung audit, which then gets every number",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson039.DefaultConfig()
+				cfg.Bulkheads, cfg.Delay, cfg.Hang = false, 10*time.Millisecond, 100*time.Millisecond
+				return lesson039.Run(ctx, w, cfg)
+			},
+			Stream: byContent(" saved ", "audited "),
+		},
+		"lesson_040": {
+			Doc: "the file hashed a chunk at a time has its SHA-256",
+			Run: func(ctx context.Context, w io.Writer) error {
+				return inTempDir(w, map[string]string{"input.txt": "hashed a few bytes at a time\n"}, func(dir string, w io.Writer) error {
+					cfg := lesson040.DefaultConfig(filepath.Join(dir, "input.txt"))
+					cfg.ChunkSize = 4
+					return lesson040.Run(ctx, w, cfg)
+				})
+			},
+		},
+		"lesson_041": {
+			Doc: "the file compressed and decompressed again is the same",
+			Run: func(ctx context.Context, w io.Writer) error {
+				return inTempDir(w, map[string]string{"input.txt": strings.Repeat("compressed well\n", 3)}, func(dir string, w io.Writer) error {
+					return lesson041.Run(ctx, w, lesson041.Config{Path: filepath.Join(dir, "input.txt"), Stderr: w})
+				})
+			},
+		},
+		"lesson_042": {
+			Doc: "Take bounds the repeated numbers to 10",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson042.DefaultConfig()
+				cfg.Delay = 10 * time.Millisecond
+				return lesson042.Run(ctx, w, cfg)
+			},
+		},
+		"lesson_043": {
+			Doc: "the even and the odd numbers are saved by sinks of their own",
+			Run: func(ctx context.Context, w io.Writer) error {
+				return lesson043.Run(ctx, w, lesson043.DefaultConfig())
+			},
+			Stream: byPrefix("saved even ", "saved odd "),
+		},
+		"lesson_044": {
+			Doc: "3 is saved on its retry, after the other numbers, and 6 becomes a dead letter",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson044.DefaultConfig()
+				cfg.Delay, cfg.RetryDelays = 10*time.Millisecond, []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}
+				return lesson044.Run(ctx, w, cfg)
+			},
+			Stream: byPrefix("saved "),
+		},
+		"lesson_045": {
+			Doc: "the file holds every doubled number",
+			Run: func(ctx context.Context, w io.Writer) error {
+				return inTempDir(w, nil, func(dir string, w io.Writer) error {
+					cfg := lesson045.DefaultConfig()
+					cfg.Path, cfg.Delay = filepath.Join(dir, "doubled.txt"), 10*time.Millisecond
+					if err := lesson045.Run(ctx, w, cfg); err != nil {
+						return err
+					}
+					data, err := os.ReadFile(cfg.Path)
+					fmt.Fprintf(w, "%s holds:\n%s", cfg.Path, data)
+					return err
+				})
+			},
+		},
+		"lesson_046": {
+			Doc: "both subscribers get every number",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson046.DefaultConfig()
+				cfg.Delay = 10 * time.Millisecond
+				return lesson046.Run(ctx, w, cfg)
+			},
+			Stream: byPrefix("saved ", "audited "),
+		},
+		"lesson_047": {
+			Doc: "the generator is paused and resumed and every number still saved",
+			Run: func(ctx context.Context, w io.Writer) error {
+				sigs := make(chan os.Signal)
+				done := make(chan struct{})
+				defer close(done)
+				go func() {
+					for _, sig := range []os.Signal{syscall.SIGUSR1, syscall.SIGUSR2} {
+						select {
+						case sigs <- sig:
+						case <-done:
+							return
+						}
+						time.Sleep(50 * time.Millisecond)
+					}
+				}()
+				cfg := lesson047.DefaultConfig(sigs)
+				cfg.Count, cfg.Delay = 10, 10*time.Millisecond
+				return lesson047.Run(ctx, w, cfg)
+			},
+			Stream: byPrefix("saved ", "generator "),
+		},
+		"lesson_048": {
+			Doc: "the pages are fetched ahead and their numbers saved in order",
+			Run: func(ctx context.Context, w io.Writer) error {
+				cfg := lesson048.DefaultConfig()
+				cfg.FetchDelay, cfg.Delay = 30*time.Millisecond, 10*time.Millisecond
+				return lesson048.Run(ctx, w, cfg)
+			},
+			// The pages fetched at once print in any order.
+			Stream: func(line string) string {
+				if strings.HasPrefix(line, "fetched page ") {
+					return line
+				}
+				return byPrefix("saved ")(line)
+			},
+		},
+		"lesson_049": {
+			Doc: "the numbers pushed over HTTP are saved until the feeder is closed",
+			Run: func(ctx context.Context, w io.Writer) error {
+				ln, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					return err
+				}
+				addr := ln.Addr().String()
+				return masking(w, func(w io.Writer) error {
+					result := make(chan error, 1)
+					cfg := lesson049.DefaultConfig(ln)
+					cfg.Delay = 10 * time.Millisecond
+					go func() { result <- lesson049.Run(ctx, w, cfg) }()
+					if err := post(addr, "push", "1", "push", "2", "push", "3", "close", ""); err != nil {
+						<-result
+						return err
+					}
+					return <-result
+				}, addr, "$ADDR")
+			},
+		},
+		"lesson_050": {
+			Doc: "seed 1 gives the same schedule, and failure of 6, every run",
+			Run: func(ctx context.Context, w io.Writer) error {
+				return lesson050.Run(ctx, w, lesson050.DefaultConfig())
+			},
+		},
+		"lesson_050_seed_2": {
+			Doc: "seed 2 gives a schedule of its own, the same every run",
+			Run: func(ctx context.Context, w io.Writer) error {
+				return lesson050.Run(ctx, w, lesson050.Config{Seed: 2})
+			},
+		},
+	}
+}
+
+// inTempDir runs run in a new directory holding files, by their path in it,
+// and removes the directory after. The directory shows as $DIR in what run
+// prints, so that it is the same on every run.
+func inTempDir(w io.Writer, files map[string]string, run func(dir string, w io.Writer) error) error {
+	dir, err := os.MkdirTemp("", "lesson")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			return err
+		}
+	}
+	return masking(w, func(w io.Writer) error { return run(dir, w) }, dir, "$DIR")
+}
+
+// masking runs run and writes what it printed to w, and returns its error,
+// with every old string of oldnew replaced by the new one after it.
+func masking(w io.Writer, run func(w io.Writer) error, oldnew ...string) error {
+	var out syncBuffer
+	err := run(&out)
+	r := strings.NewReplacer(oldnew...)
+	if _, writeErr := io.WriteString(w, r.Replace(out.String())); writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return errors.New(r.Replace(err.Error()))
+	}
+	return nil
+}
+
+// syncBuffer is a bytes.Buffer several goroutines may write to.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// post makes a POST to addr for every pair of path and body in pathBody, and
+// fails unless every one gets a 200.
+func post(addr string, pathBody ...string) error {
+	// A kept-alive connection would outlive the run.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	for i := 0; i < len(pathBody); i += 2 {
+		resp, err := client.Post("http://"+addr+"/"+pathBody[i], "text/plain", strings.NewReader(pathBody[i+1]))
+		if err != nil {
+			return err
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("POST /%s: %s: %s", pathBody[i], resp.Status, body)
+		}
+	}
+	return nil
+}
+
+// site is the transport of lesson_030, serving a few pages of example.test
+// from memory. Every other host is down.
+var site = fakeSite{
+	"/":      "<h1>Example</h1>",
+	"/about": "<p>An example site for the lessons.</p>",
+}
+
+// fakeSite is an http.RoundTripper serving its pages, by path, as
+// example.test.
+type fakeSite map[string]string
+
+func (fakeSite) url(path string) string {
+	return "http://example.test" + path
+}
+
+func (s fakeSite) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "example.test" {
+		return nil, errors.New("connection refused")
+	}
+	resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Request: req}
+	page, ok := s[req.URL.Path]
+	if !ok {
+		resp.StatusCode, page = http.StatusNotFound, "not found"
+	}
+	resp.Status = http.StatusText(resp.StatusCode)
+	resp.Body = io.NopCloser(strings.NewReader(page))
+	resp.ContentLength = int64(len(page))
+	return resp, nil
+}
@@ -0,0 +1,81 @@
+package lessons_test
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"channelspractice/internal/lessons"
+	"channelspractice/pipelinetest"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+var (
+	// timestamp matches the time of a log line.
+	timestamp = regexp.MustCompile(`time=\S+ `)
+	// duration matches a time.Duration as it prints.
+	duration = regexp.MustCompile(`\b(\d+(\.\d+)?(ns|µs|ms|s|m|h))+\b`)
+	// spaces matches the columns of a table, which are as wide as its
+	// durations were.
+	spaces = regexp.MustCompile(` {2,}`)
+)
+
+// normalize drops the times and durations from the output, which differ from
+// run to run. If stream is set, it then groups the lines by stream, but keeps
+// their order within every stream: only the lines of different streams are
+// printed concurrently.
+func normalize(out string, stream func(line string) string) string {
+	out = timestamp.ReplaceAllString(out, "")
+	out = duration.ReplaceAllString(out, "<duration>")
+	out = spaces.ReplaceAllString(out, " ")
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	if stream != nil {
+		slices.SortStableFunc(lines, func(a, b string) int {
+			return strings.Compare(stream(a), stream(b))
+		})
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestLessons(t *testing.T) {
+	for name, lesson := range lessons.All() {
+		t.Run(name, func(t *testing.T) {
+			if testing.Short() {
+				t.Skip("runs the lesson at its own pace")
+			}
+			pipelinetest.VerifyNoLeaks(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			var out bytes.Buffer
+			if err := lesson.Run(ctx, &out); err != nil {
+				out.WriteString("error: " + err.Error() + "\n")
+			}
+			got := normalize(out.String(), lesson.Stream)
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("%s: output differs from %s:\n%s\nwant:\n%s", lesson.Doc, golden, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Ack reports that the sink is done with the item at Seq, the offset its
+// source gave the envelope. Err is what the sink function returned for it;
+// an item is only saved if Err is nil.
+type Ack struct {
+	Seq int64
+	Err error
+}
+
+// WithAcks makes SinkItems send an Ack on acks for every item once its
+// function returned. Unlike heartbeats acks are never dropped; the sink waits
+// for room on acks, so it must be read, e.g. by an AckTracker. Several sinks
+// can share acks. It is not closed by the sink.
+func WithAcks(acks chan<- Ack) Option {
+	return func(o *options) {
+		o.acks = acks
+	}
+}
+
+func (o options) ack(ctx context.Context, seq int64, err error) {
+	o.flow.release(seq)
+	if o.acks == nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case o.acks <- Ack{Seq: seq, Err: err}:
+	}
+}
+
+// Gap is a run of items without a successful ack, from From to To inclusive,
+// while later items have been acked.
+type Gap struct {
+	From, To int64
+	// Since is when the tracker first saw the gap.
+	Since time.Time
+}
+
+// AckTracker follows the acks of a pipeline to tell up to which item
+// everything has been saved. An item acked with an error counts as not saved,
+// so it holds the tracker back like one whose ack never arrived.
+type AckTracker struct {
+	clock      Clock
+	gapTimeout time.Duration
+	onGap      func(Gap)
+
+	mu       sync.Mutex
+	next     int64
+	acked    map[int64]bool
+	gapOpen  bool
+	gapSince time.Time
+	reported bool
+}
+
+// NewAckTracker returns a tracker expecting the first ack for start. onGap is
+// called once for every gap that stays open for gapTimeout, measured on the
+// clock set with WithClock, as its items are presumed lost. A nil onGap or a
+// gapTimeout of 0 turns gap detection off.
+func NewAckTracker(start int64, gapTimeout time.Duration, onGap func(Gap), opts ...Option) *AckTracker {
+	o := newOptions(opts)
+	return &AckTracker{clock: o.clock, gapTimeout: gapTimeout, onGap: onGap, next: start, acked: make(map[int64]bool)}
+}
+
+// Run consumes acks until it is closed or ctx is cancelled.
+func (t *AckTracker) Run(ctx context.Context, acks <-chan Ack) {
+	var check <-chan time.Time
+	if t.onGap != nil && t.gapTimeout > 0 {
+		// Gaps are looked at twice per timeout, so one is reported at most
+		// half a timeout late.
+		ticker := t.clock.NewTicker(max(t.gapTimeout/2, time.Nanosecond))
+		defer ticker.Stop()
+		check = ticker.C()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ack, ok := <-acks:
+			if !ok {
+				return
+			}
+			t.add(ack)
+		case <-check:
+			if gap, ok := t.overdue(); ok {
+				t.onGap(gap)
+			}
+		}
+	}
+}
+
+// HighestContiguous returns the highest seq up to which every item has been
+// acked without an error, or one less than start if there is none yet.
+func (t *AckTracker) HighestContiguous() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.next - 1
+}
+
+func (t *AckTracker) add(ack Ack) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ack.Err != nil || ack.Seq < t.next {
+		return
+	}
+	t.acked[ack.Seq] = true
+	moved := false
+	for t.acked[t.next] {
+		delete(t.acked, t.next)
+		t.next++
+		moved = true
+	}
+	switch {
+	case len(t.acked) == 0:
+		t.gapOpen = false
+	case moved || !t.gapOpen:
+		// A new gap opens at next.
+		t.gapOpen, t.gapSince, t.reported = true, t.clock.Now(), false
+	}
+}
+
+// overdue returns the current gap if it has been open for gapTimeout and was
+// not reported yet.
+func (t *AckTracker) overdue() (Gap, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.gapOpen || t.reported || t.clock.Now().Sub(t.gapSince) < t.gapTimeout {
+		return Gap{}, false
+	}
+	t.reported = true
+	to := int64(-1)
+	for seq := range t.acked {
+		if to == -1 || seq < to {
+			to = seq
+		}
+	}
+	return Gap{From: t.next, To: to - 1, Since: t.gapSince}, true
+}
@@ -0,0 +1,145 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestAckTrackerOutOfOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	tracker := pipeline.NewAckTracker(0, 0, nil)
+	acks := make(chan pipeline.Ack)
+	tracked := make(chan struct{})
+	go func() {
+		defer close(tracked)
+		tracker.Run(context.Background(), acks)
+	}()
+
+	for _, step := range []struct {
+		seq  int64
+		want int64
+	}{{2, -1}, {0, 0}, {3, 0}, {1, 3}, {4, 4}} {
+		acks <- pipeline.Ack{Seq: step.seq}
+		// A failed ack changes nothing, and once Run took it, it is done
+		// with the one before.
+		acks <- pipeline.Ack{Seq: 100, Err: errors.New("not saved")}
+		if got := tracker.HighestContiguous(); got != step.want {
+			t.Errorf("after ack %d HighestContiguous = %d, want %d", step.seq, got, step.want)
+		}
+	}
+	close(acks)
+	<-tracked
+}
+
+// TestAckTrackerPooledSinks acks every item from four sinks that finish
+// their items in random order.
+func TestAckTrackerPooledSinks(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	acks := make(chan pipeline.Ack)
+	tracker := pipeline.NewAckTracker(0, time.Hour, func(g pipeline.Gap) {
+		t.Errorf("gap %+v reported although every item was acked", g)
+	})
+	tracked := make(chan struct{})
+	go func() {
+		defer close(tracked)
+		tracker.Run(ctx, acks)
+	}()
+
+	src := pipeline.SourceItems(ctx, pipelinetest.Items(200), nil)
+	var wg sync.WaitGroup
+	for range 4 {
+		done, errc := pipeline.SinkItems(ctx, src, func(context.Context, int) error {
+			time.Sleep(time.Duration(rand.IntN(100)) * time.Microsecond)
+			return nil
+		}, pipeline.WithAcks(acks))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pipelinetest.CollectWithTimeout(t, errc, time.Second)
+			<-done
+		}()
+	}
+	// Every ack is delivered before its sink is done, so acks can be
+	// closed right after.
+	wg.Wait()
+	close(acks)
+	<-tracked
+
+	if got := tracker.HighestContiguous(); got != 199 {
+		t.Errorf("HighestContiguous = %d, want 199", got)
+	}
+}
+
+func TestAckTrackerReportsGap(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+
+	gaps := make(chan pipeline.Gap, 10)
+	tracker := pipeline.NewAckTracker(0, time.Second, func(g pipeline.Gap) { gaps <- g }, pipeline.WithClock(clock))
+	acks := make(chan pipeline.Ack)
+	tracked := make(chan struct{})
+	go func() {
+		defer close(tracked)
+		tracker.Run(ctx, acks)
+	}()
+
+	// 2 is never acked and 3 fails.
+	for _, ack := range []pipeline.Ack{{Seq: 0}, {Seq: 1}, {Seq: 3, Err: errors.New("disk full")}, {Seq: 4}, {Seq: 5}} {
+		acks <- ack
+	}
+	gap := advanceUntilItem(t, clock, gaps, 500*time.Millisecond)
+	if gap.From != 2 || gap.To != 3 {
+		t.Errorf("gap = %+v, want 2 to 3", gap)
+	}
+	if tracker.HighestContiguous() != 1 {
+		t.Errorf("HighestContiguous = %d, want 1 before the gap", tracker.HighestContiguous())
+	}
+
+	// The same gap is only reported once.
+	clock.Advance(10 * time.Second)
+	assertNoItem(t, gaps)
+
+	acks <- pipeline.Ack{Seq: 2}
+	acks <- pipeline.Ack{Seq: 3}
+	close(acks)
+	<-tracked
+	if got := tracker.HighestContiguous(); got != 5 {
+		t.Errorf("HighestContiguous = %d once the gap was filled, want 5", got)
+	}
+}
+
+func TestSinkItemsAcksFailure(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	acks := make(chan pipeline.Ack, 10)
+	_, errc := pipeline.SinkItems(ctx, pipeline.SourceItems(ctx, pipelinetest.Items(10), nil), func(ctx context.Context, n int) error {
+		_, err := failOnSix(ctx, n)
+		return err
+	}, pipeline.WithAcks(acks))
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	close(acks)
+
+	got := pipelinetest.CollectWithTimeout(t, acks, time.Second)
+	if len(got) != 7 || got[6].Seq != 6 || got[6].Err == nil {
+		t.Fatalf("acks = %v, want 0 to 5 and the failed 6", got)
+	}
+	for _, ack := range got[:6] {
+		if ack.Err != nil {
+			t.Errorf("ack %d has error %v", ack.Seq, ack.Err)
+		}
+	}
+}
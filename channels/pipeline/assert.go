@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// AssertionError is the error Assert reports for an item breaking its
+// invariant. Index is the position of the item in the stream, counted from
+// 0; Prev is the item before it, nil for the first item.
+type AssertionError struct {
+	Index int
+	Prev  any
+	Cur   any
+	Err   error
+}
+
+func (e *AssertionError) Error() string {
+	if e.Index == 0 {
+		return fmt.Sprintf("assertion failed on item 0 (%v): %v", e.Cur, e.Err)
+	}
+	return fmt.Sprintf("assertion failed on item %d (%v after %v): %v", e.Index, e.Cur, e.Prev, e.Err)
+}
+
+func (e *AssertionError) Unwrap() error {
+	return e.Err
+}
+
+// Assert passes the items from in on unchanged and in order while it checks
+// invariant on every one of them, with the item before it and its index. The
+// first item has index 0 and the zero value as prev. An item whose check
+// fails is a failed item like any other: it is sent on the error channel as
+// a *StageError wrapping an *AssertionError, and it stops the stage or, under
+// SkipAndReport, is dropped, so the next item is checked against the last one
+// that passed. The stage is named "assert" by default.
+func Assert[T any](ctx context.Context, in <-chan T, invariant func(prev, cur T, index int) error, opts ...Option) (<-chan T, <-chan error) {
+	name := newOptions(opts).stageName("assert")
+	var prev T
+	index := 0
+	check := func(_ context.Context, cur T) (T, error) {
+		i := index
+		index++
+		if err := invariant(prev, cur, i); err != nil {
+			assertion := &AssertionError{Index: i, Cur: cur, Err: err}
+			if i > 0 {
+				assertion.Prev = prev
+			}
+			return cur, assertion
+		}
+		prev = cur
+		return cur, nil
+	}
+	return Stage(ctx, in, check, append(opts, WithName(name))...)
+}
+
+// Monotonic is an Assert invariant holding while no item is less than the
+// one before it.
+func Monotonic[T any](less func(a, b T) bool) func(prev, cur T, index int) error {
+	return func(prev, cur T, index int) error {
+		if index > 0 && less(cur, prev) {
+			return ErrOutOfOrder
+		}
+		return nil
+	}
+}
+
+// NonZero is an Assert invariant holding while no item is the zero value.
+func NonZero[T comparable](_, cur T, _ int) error {
+	var zero T
+	if cur == zero {
+		return ErrZeroValue
+	}
+	return nil
+}
+
+// MaxGap is an Assert invariant for envelopes holding while no item was
+// emitted more than d after the one before it.
+func MaxGap[T any](d time.Duration) func(prev, cur Item[T], index int) error {
+	return func(prev, cur Item[T], index int) error {
+		if gap := cur.Emitted.Sub(prev.Emitted); index > 0 && gap > d {
+			return fmt.Errorf("emitted %v after the item before, more than %v", gap, d)
+		}
+		return nil
+	}
+}
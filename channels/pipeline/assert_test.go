@@ -0,0 +1,130 @@
+package pipeline_test
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// assertStage is Assert over ints as a pipelinetest stage.
+func assertStage(invariant func(prev, cur, index int) error, opts ...pipeline.Option) func(context.Context, <-chan int) (<-chan int, <-chan error) {
+	return pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Assert(ctx, in, invariant, opts...)
+	})
+}
+
+// assertionOf returns the *AssertionError err wraps, failing the test if
+// there is none.
+func assertionOf(t *testing.T, err error) *pipeline.AssertionError {
+	t.Helper()
+	var assertion *pipeline.AssertionError
+	var stageErr *pipeline.StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != "assert" || !errors.As(err, &assertion) {
+		t.Fatalf("error %v, want an assertion of the assert stage", err)
+	}
+	return assertion
+}
+
+func TestAssertPassesItemsThrough(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	out, errs := pipelinetest.Run(t, assertStage(pipeline.Monotonic(cmp.Less[int])), pipelinetest.Items(100))
+	if len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	if !slices.Equal(out, pipelinetest.Items(100)) {
+		t.Errorf("got %v, want the items unchanged and in order", out)
+	}
+}
+
+func TestAssertFirstItem(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	type call struct{ prev, cur, index int }
+	var calls []call
+	record := func(prev, cur, index int) error {
+		calls = append(calls, call{prev, cur, index})
+		return nil
+	}
+	pipelinetest.Run(t, assertStage(record), []int{4, 5, 6})
+	if want := []call{{0, 4, 0}, {4, 5, 1}, {5, 6, 2}}; !slices.Equal(calls, want) {
+		t.Errorf("invariant called with %v, want %v", calls, want)
+	}
+
+	// The first item has nothing before it to report.
+	out, errs := pipelinetest.Run(t, assertStage(pipeline.NonZero[int]), []int{0, 1})
+	if len(out) != 0 || len(errs) != 1 {
+		t.Fatalf("got %v and errors %v, want the zero value to fail", out, errs)
+	}
+	assertion := assertionOf(t, errs[0])
+	if assertion.Index != 0 || assertion.Prev != nil || assertion.Cur != 0 || !errors.Is(errs[0], pipeline.ErrZeroValue) {
+		t.Errorf("assertion %+v, want the zero value at index 0 without a prev", assertion)
+	}
+	if want := "assertion failed on item 0 (0): zero value"; assertion.Error() != want {
+		t.Errorf("Error() = %q, want %q", assertion.Error(), want)
+	}
+}
+
+func TestAssertViolationFailFast(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	out, errs := pipelinetest.Run(t, assertStage(pipeline.Monotonic(cmp.Less[int])), []int{1, 2, 2, 5, 3, 6})
+	if !slices.Equal(out, []int{1, 2, 2, 5}) {
+		t.Errorf("got %v, want the items before the violation", out)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("errors = %v, want the violation alone", errs)
+	}
+	assertion := assertionOf(t, errs[0])
+	if assertion.Index != 4 || assertion.Prev != 5 || assertion.Cur != 3 || !errors.Is(errs[0], pipeline.ErrOutOfOrder) {
+		t.Errorf("assertion %+v, want 3 after 5 at index 4", assertion)
+	}
+	if want := "assertion failed on item 4 (3 after 5): out of order"; assertion.Error() != want {
+		t.Errorf("Error() = %q, want %q", assertion.Error(), want)
+	}
+}
+
+func TestAssertViolationSkipAndReport(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	out, errs := pipelinetest.Run(t, assertStage(pipeline.Monotonic(cmp.Less[int]), pipeline.WithErrorPolicy(pipeline.SkipAndReport)),
+		[]int{1, 5, 3, 4, 6, 2})
+	if !slices.Equal(out, []int{1, 5, 6}) {
+		t.Errorf("got %v, want the items keeping the order", out)
+	}
+	// Dropped items are not compared against: 4 still comes after 5.
+	var violations [][3]any
+	for _, err := range errs {
+		a := assertionOf(t, err)
+		violations = append(violations, [3]any{a.Index, a.Prev, a.Cur})
+	}
+	if want := [][3]any{{2, 5, 3}, {3, 5, 4}, {5, 6, 2}}; !slices.Equal(violations, want) {
+		t.Errorf("violations %v, want %v", violations, want)
+	}
+}
+
+func TestAssertMaxGap(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	start := time.Unix(0, 0)
+	var items []pipeline.Item[string]
+	for i, at := range []time.Duration{0, time.Second, 3 * time.Second, 7 * time.Second, 8 * time.Second} {
+		items = append(items, pipeline.Item[string]{Value: string(rune('a' + i)), Emitted: start.Add(at)})
+	}
+	gaps := pipelinetest.StageOf(func(ctx context.Context, in <-chan pipeline.Item[string]) (<-chan pipeline.Item[string], <-chan error) {
+		return pipeline.Assert(ctx, in, pipeline.MaxGap[string](2*time.Second), pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	})
+	out, errs := pipelinetest.Run(t, gaps, items)
+	var values []string
+	for _, item := range out {
+		values = append(values, item.Value)
+	}
+	if !slices.Equal(values, []string{"a", "b", "c"}) {
+		t.Errorf("got %v, want the items up to the gap", values)
+	}
+	// Once d is dropped e is 5s after c as well.
+	if len(errs) != 2 || assertionOf(t, errs[0]).Index != 3 || assertionOf(t, errs[1]).Index != 4 {
+		t.Errorf("errors %v, want d and e too late", errs)
+	}
+}
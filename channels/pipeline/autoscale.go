@@ -0,0 +1,128 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"channelspractice/chanutil"
+)
+
+// AutoscaleOptions controls how StagePoolAuto sizes its pool.
+type AutoscaleOptions struct {
+	// Min and Max bound the number of workers. Min is at least 1 and Max at
+	// least Min.
+	Min, Max int
+	// ScaleUpAt and ScaleDownAt are queue occupancies between 0 and 1. A
+	// worker is added when the queue is at least ScaleUpAt full and one is
+	// stopped when it is at most ScaleDownAt full.
+	ScaleUpAt, ScaleDownAt float64
+	// Interval is how often the queue is checked. It defaults to 100ms.
+	Interval time.Duration
+	// QueueSize is the capacity of the queue the pool reads in into. It
+	// defaults to 2*Max.
+	QueueSize int
+	// OnScale, if set, is called with the new number of workers every time
+	// it changes.
+	OnScale func(workers int)
+}
+
+// StagePoolAuto is StagePool with a number of workers that follows the load.
+// It reads in into a queue of its own and adds or stops one worker per
+// Interval depending on how full the queue is, staying within [Min, Max]. A
+// worker that is stopped finishes its current item first, so no item is
+// dropped. Errors and options are handled as in StagePool, except that hooks
+// run for each worker as it starts, numbered in that order, with Max as the
+// number of workers.
+func StagePoolAuto[T, U any](ctx context.Context, in <-chan T, fn func(context.Context, T) (U, error), ao AutoscaleOptions, opts ...Option) (<-chan U, <-chan error) {
+	o := newOptions(opts)
+	ao.Min = max(ao.Min, 1)
+	ao.Max = max(ao.Max, ao.Min)
+	if ao.Interval <= 0 {
+		ao.Interval = 100 * time.Millisecond
+	}
+	if ao.QueueSize <= 0 {
+		ao.QueueSize = 2 * ao.Max
+	}
+
+	p := newWorkerPool(ctx, fn, o, false)
+	unregister := o.register("stage")
+	o.logStart(ctx, p.name)
+
+	queue := make(chan T, ao.QueueSize)
+	go func() {
+		defer close(queue)
+		for item := range OrDone(p.poolCtx, in) {
+			if chanutil.SendContext(p.poolCtx, queue, item) != nil {
+				return
+			}
+		}
+	}()
+
+	// exited receives true from a worker that was stopped by scaling down.
+	exited := make(chan bool)
+	worker := func(id int, stop <-chan struct{}) {
+		stopped := false
+		defer func() { exited <- stopped }()
+		info := StageInfo{Stage: p.name, Worker: id, Workers: ao.Max}
+		defer o.stopHook(info, p.terminal)
+		if err := o.startHook(p.poolCtx, info); err != nil {
+			p.fail(err)
+			return
+		}
+		stopped = p.work(info, p.fn, queue, queue, stop)
+	}
+
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, p.name)
+		defer p.close()
+		defer p.cancel()
+
+		var stops []chan struct{}
+		running, started := 0, 0
+		start := func() {
+			stop := make(chan struct{})
+			stops = append(stops, stop)
+			running++
+			go worker(started, stop)
+			started++
+		}
+		for range ao.Min {
+			start()
+		}
+
+		ticker := time.NewTicker(ao.Interval)
+		defer ticker.Stop()
+		// Workers only exit on their own once the queue is closed and empty
+		// or the pool is cancelled, after which no worker is started.
+		draining := false
+		for running > 0 {
+			select {
+			case stopped := <-exited:
+				running--
+				if !stopped {
+					draining = true
+				}
+			case <-ticker.C:
+				if draining {
+					continue
+				}
+				occupancy := float64(len(queue)) / float64(cap(queue))
+				switch {
+				case occupancy >= ao.ScaleUpAt && len(stops) < ao.Max:
+					start()
+				case occupancy <= ao.ScaleDownAt && len(stops) > ao.Min:
+					close(stops[len(stops)-1])
+					stops = stops[:len(stops)-1]
+				default:
+					continue
+				}
+				if ao.OnScale != nil {
+					ao.OnScale(len(stops))
+				}
+			}
+		}
+	}()
+	out, _, errc := p.outputs()
+	return out, errc
+}
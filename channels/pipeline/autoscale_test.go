@@ -0,0 +1,161 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+var autoscaleOptions = pipeline.AutoscaleOptions{
+	Min:         1,
+	Max:         4,
+	ScaleUpAt:   0.5,
+	ScaleDownAt: 0.1,
+	Interval:    5 * time.Millisecond,
+	QueueSize:   8,
+}
+
+func TestStagePoolAutoScalesUpAndDown(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var mu sync.Mutex
+	var counts []int
+	ao := autoscaleOptions
+	ao.OnScale = func(workers int) {
+		mu.Lock()
+		defer mu.Unlock()
+		counts = append(counts, workers)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out, errc := pipeline.StagePoolAuto(ctx, in, pipelinetest.IOWork(5*time.Millisecond), ao)
+	seen := make(chan []int)
+	go func() { seen <- pipelinetest.CollectWithTimeout(t, out, 5*time.Second) }()
+
+	// A burst, then enough idle time to scale back down, then the input ends.
+	for i := range 100 {
+		in <- i
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(in)
+	got := <-seen
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+
+	slices.Sort(got)
+	if !slices.Equal(got, pipelinetest.Items(100)) {
+		t.Errorf("got %d items, want each of the 100 exactly once", len(got))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(counts) == 0 || slices.Max(counts) < 2 {
+		t.Fatalf("worker counts %v, want a scale up", counts)
+	}
+	if counts[len(counts)-1] != ao.Min {
+		t.Errorf("worker counts %v, want back at %d once idle", counts, ao.Min)
+	}
+}
+
+func TestStagePoolAutoMaxInFlight(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var inFlight, highWater atomic.Int64
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePoolAuto(ctx, in, func(ctx context.Context, n int) (int, error) {
+			cur := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				hw := highWater.Load()
+				if cur <= hw || highWater.CompareAndSwap(hw, cur) {
+					break
+				}
+			}
+			time.Sleep(2 * time.Millisecond)
+			return n, nil
+		}, autoscaleOptions, pipeline.WithMaxInFlight(2))
+	})
+	out, errs := pipelinetest.Run(t, stage, pipelinetest.Items(50))
+	if len(errs) > 0 || len(out) != 50 {
+		t.Fatalf("got %d items and errors %v", len(out), errs)
+	}
+	if hw := highWater.Load(); hw > 2 {
+		t.Errorf("%d items in flight at once, limit is 2", hw)
+	}
+}
+
+func TestStagePoolAutoHeartbeats(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	beats := make(chan pipeline.Heartbeat, 1000)
+	ao := autoscaleOptions
+	ao.Max = 2
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePoolAuto(ctx, in, double, ao,
+			pipeline.WithName("resize"), pipeline.WithHeartbeat(time.Millisecond, beats))
+	})
+	if _, errs := pipelinetest.Run(t, stage, pipelinetest.Items(10)); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	close(beats)
+
+	stopped := 0
+	for beat := range beats {
+		if !strings.HasPrefix(beat.Stage, "resize[") {
+			t.Fatalf("beat from %q, want a resize worker", beat.Stage)
+		}
+		if beat.Stopped {
+			stopped++
+		}
+	}
+	if stopped == 0 {
+		t.Error("no worker sent its final heartbeat")
+	}
+}
+
+func TestStagePoolAutoSlowWarning(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var slow atomic.Int64
+	ao := autoscaleOptions
+	ao.Max = 1
+	in := pipeline.SourceItems(ctx, pipelinetest.Items(10), nil)
+	out, errc := pipeline.StagePoolAuto(ctx, in, func(_ context.Context, item pipeline.Item[int]) (int, error) {
+		time.Sleep(5 * time.Millisecond)
+		return item.Value, nil
+	}, ao, pipeline.WithSlowWarning(time.Millisecond, func(pipeline.SlowEvent) { slow.Add(1) }))
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 10 {
+		t.Fatalf("got %d items, want 10", len(got))
+	}
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if slow.Load() == 0 {
+		t.Error("no slow warning for items queued behind a single slow worker")
+	}
+}
+
+func TestStagePoolAutoError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	boom := errors.New("boom")
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePoolAuto(ctx, in, func(_ context.Context, n int) (int, error) {
+			if n == 5 {
+				return 0, boom
+			}
+			return n, nil
+		}, autoscaleOptions, pipeline.WithName("resize"))
+	})
+	_, errs := pipelinetest.Run(t, stage, pipelinetest.Items(20))
+
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "resize" || !errors.Is(errs[0], boom) {
+		t.Errorf("errors = %v, want one *StageError of resize", errs)
+	}
+}
@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// Batch groups items from in into slices of at most maxSize items. A batch is
+// emitted when it is full or when maxWait has passed since its first item on
+// the clock set with WithClock, whichever comes first. The partial batch is
+// flushed when in is closed. On cancellation the partial batch is handed over
+// only if the consumer (or the output buffer) can take it without blocking.
+func Batch[T any](ctx context.Context, in <-chan T, maxSize int, maxWait time.Duration, opts ...Option) <-chan []T {
+	return batch(ctx, in, fixedSize(maxSize), maxWait, newOptions(opts), nil, nil)
+}
+
+// fixedSize is the size of batches that are always maxSize items.
+func fixedSize(maxSize int) func() int {
+	return func() int { return maxSize }
+}
+
+// batch implements Batch, asking maxSize for the size of every batch as it is
+// filled. onAdd is called for every item added to the current batch and
+// onFlush for every batch handed to the consumer.
+func batch[T any](ctx context.Context, in <-chan T, maxSize func() int, maxWait time.Duration, o options, onAdd func(T), onFlush func([]T)) <-chan []T {
+	out := make(chan []T, o.buffer)
+	stop := make(chan struct{})
+	sampleOccupancy(o.metrics, out, stop)
+	go func() {
+		defer close(out)
+		defer close(stop)
+
+		var batch []T
+		timer := o.clock.NewTimer(maxWait)
+		timer.Stop()
+
+		flush := func() bool {
+			timer.Stop()
+			if len(batch) == 0 {
+				return true
+			}
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- batch:
+				if onFlush != nil {
+					onFlush(batch)
+				}
+				batch = nil
+				return true
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				if len(batch) > 0 {
+					select {
+					case out <- batch:
+						if onFlush != nil {
+							onFlush(batch)
+						}
+					default:
+					}
+				}
+				return
+			case item, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				if len(batch) == 0 {
+					timer.Reset(maxWait)
+				}
+				if onAdd != nil {
+					onAdd(item)
+				}
+				batch = append(batch, item)
+				if len(batch) >= maxSize() && !flush() {
+					return
+				}
+			case <-timer.C():
+				if !flush() {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
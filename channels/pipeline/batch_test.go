@@ -0,0 +1,77 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestBatchSizePath(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batches := pipeline.Batch(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), 4, time.Hour)
+
+	got := pipelinetest.CollectWithTimeout(t, batches, time.Second)
+	// The last batch is the partial flush when in is closed.
+	want := [][]int{{0, 1, 2, 3}, {4, 5, 6, 7}, {8, 9}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBatchTimerPath(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	in := make(chan int)
+	defer close(in)
+	batches := pipeline.Batch(ctx, in, 100, 20*time.Millisecond, pipeline.WithClock(clock))
+
+	in <- 1
+	in <- 2
+	// The first item armed the timer.
+	clock.BlockUntil(t, 1)
+	clock.Advance(19 * time.Millisecond)
+	assertNoItem(t, batches)
+	clock.Advance(time.Millisecond)
+	select {
+	case got := <-batches:
+		if !slices.Equal(got, []int{1, 2}) {
+			t.Errorf("got %v, want [1 2]", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("partial batch not flushed after maxWait")
+	}
+}
+
+func TestBatchFlushesOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	// The buffer takes the partial batch once nobody is reading.
+	batches := pipeline.Batch(ctx, in, 100, time.Hour, pipeline.WithBuffer(1))
+	for i := range 3 {
+		in <- i
+	}
+	cancel()
+
+	got := pipelinetest.CollectWithTimeout(t, batches, time.Second)
+	if len(got) != 1 || !slices.Equal(got[0], []int{0, 1, 2}) {
+		t.Errorf("got %v, want the partial batch [[0 1 2]]", got)
+	}
+}
+
+func TestBatchEmptyInput(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	close(in)
+	pipelinetest.AssertClosed(t, pipeline.Batch(ctx, in, 4, time.Millisecond), time.Second)
+}
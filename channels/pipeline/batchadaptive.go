@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// AdaptiveBatchOptions controls the batches of BatchAdaptive.
+type AdaptiveBatchOptions struct {
+	// MinSize and MaxSize bound the batch size. MinSize values below 1 are
+	// treated as 1 and MaxSize values below MinSize as MinSize.
+	MinSize, MaxSize int
+	// TargetLatency is the flush time the batch size is tuned to, and the
+	// longest an item waits for its batch to fill. Values below a
+	// millisecond are treated as a millisecond.
+	TargetLatency time.Duration
+}
+
+// BatchAdaptive is Batch with a batch size that follows how long the consumer
+// takes to flush a batch. The consumer reports that with the returned
+// function after every batch, passing the batch's length and the time it
+// took. While full batches flush in less than three quarters of
+// TargetLatency the size grows by a quarter, when a batch takes longer than
+// TargetLatency it is halved, and in between it holds, so the size settles
+// where flushing takes about as long as the target allows. Batches start at
+// MinSize. Like Batch's maxWait a batch is emitted TargetLatency after its
+// first item at the latest, timed by the clock set with WithClock, so no item
+// waits longer than that for its batch; the partial batch is flushed when in
+// is closed and handed over on cancellation as Batch does.
+func BatchAdaptive[T any](ctx context.Context, in <-chan T, opts AdaptiveBatchOptions, pipelineOpts ...Option) (<-chan []T, func(size int, took time.Duration)) {
+	c := newBatchController(opts)
+	out := batch(ctx, in, c.current, c.target, newOptions(pipelineOpts), nil, nil)
+	return out, c.flushed
+}
+
+// batchController tunes the size of BatchAdaptive's batches.
+type batchController struct {
+	min, max int
+	target   time.Duration
+
+	mu   sync.Mutex
+	size int
+}
+
+func newBatchController(opts AdaptiveBatchOptions) *batchController {
+	lo := max(opts.MinSize, 1)
+	return &batchController{
+		min:    lo,
+		max:    max(opts.MaxSize, lo),
+		target: max(opts.TargetLatency, time.Millisecond),
+		size:   lo,
+	}
+}
+
+// current is the size of the batch being filled.
+func (c *batchController) current() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// flushed adjusts the size after a batch of size items took took to flush.
+// Only full batches let it grow: a partial one the timer flushed says nothing
+// about the size it fell short of.
+func (c *batchController) flushed(size int, took time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case took > c.target:
+		c.size = max(min(size, c.size)/2, c.min)
+	case took < c.target*3/4 && size >= c.size:
+		c.size = min(c.size+max(c.size/4, 1), c.max)
+	}
+}
@@ -0,0 +1,132 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// flushAll drains batches like a sink whose flush time is perItem(i) for
+// every item of the i-th batch, reporting it to flushed, and returns the
+// batch sizes.
+func flushAll(t *testing.T, batches <-chan []int, flushed func(int, time.Duration), perItem func(i int) time.Duration) []int {
+	t.Helper()
+	var sizes []int
+	timeout := time.After(pipelinetest.DefaultTimeout)
+	for {
+		select {
+		case batch, ok := <-batches:
+			if !ok {
+				return sizes
+			}
+			flushed(len(batch), time.Duration(len(batch))*perItem(len(sizes)))
+			sizes = append(sizes, len(batch))
+		case <-timeout:
+			t.Fatalf("batches not closed after %d batches", len(sizes))
+		}
+	}
+}
+
+// settledAt fails unless the batches before the last, partial one end in a
+// run of at least n batches of size want.
+func settledAt(t *testing.T, sizes []int, n, want int) {
+	t.Helper()
+	full := sizes[:len(sizes)-1]
+	if len(full) < n {
+		t.Fatalf("only %d full batches: %v", len(full), sizes)
+	}
+	for _, size := range full[len(full)-n:] {
+		if size != want {
+			t.Fatalf("sizes %v do not settle at %d", sizes, want)
+		}
+	}
+}
+
+func TestBatchAdaptiveConverges(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The clock never moves, so batches are only emitted when full.
+	clock := pipelinetest.NewClock(time.Time{})
+	opts := pipeline.AdaptiveBatchOptions{MinSize: 1, MaxSize: 1000, TargetLatency: 100 * time.Millisecond}
+	batches, flushed := pipeline.BatchAdaptive(ctx, pipeline.Source(ctx, pipelinetest.Items(2000)), opts, pipeline.WithClock(clock))
+
+	sizes := flushAll(t, batches, flushed, func(int) time.Duration { return 2 * time.Millisecond })
+	if sizes[0] != 1 {
+		t.Errorf("first batch has %d items, want MinSize", sizes[0])
+	}
+	// 41 items take 82ms, within a quarter of the target.
+	settledAt(t, sizes, 20, 41)
+}
+
+func TestBatchAdaptiveShrinksWhenFlushSlowsDown(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	opts := pipeline.AdaptiveBatchOptions{MinSize: 1, MaxSize: 1000, TargetLatency: 100 * time.Millisecond}
+	batches, flushed := pipeline.BatchAdaptive(ctx, pipeline.Source(ctx, pipelinetest.Items(6000)), opts, pipeline.WithClock(clock))
+
+	sizes := flushAll(t, batches, flushed, func(i int) time.Duration {
+		if i < 100 {
+			return 2 * time.Millisecond
+		}
+		return 4 * time.Millisecond
+	})
+	settledAt(t, sizes[:101], 20, 41)
+	// 41 items now take 164ms; halved to 20 they take 80ms.
+	settledAt(t, sizes, 20, 20)
+}
+
+func TestBatchAdaptiveMaxSize(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	opts := pipeline.AdaptiveBatchOptions{MinSize: 4, MaxSize: 10, TargetLatency: 100 * time.Millisecond}
+	batches, flushed := pipeline.BatchAdaptive(ctx, pipeline.Source(ctx, pipelinetest.Items(200)), opts, pipeline.WithClock(clock))
+
+	sizes := flushAll(t, batches, flushed, func(int) time.Duration { return time.Millisecond })
+	if sizes[0] != 4 {
+		t.Errorf("first batch has %d items, want MinSize", sizes[0])
+	}
+	if slices.Max(sizes) != 10 {
+		t.Errorf("largest batch has %d items, want MaxSize", slices.Max(sizes))
+	}
+	settledAt(t, sizes, 10, 10)
+}
+
+func TestBatchAdaptiveLatencyBound(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	in := make(chan int)
+	defer close(in)
+	opts := pipeline.AdaptiveBatchOptions{MinSize: 1, MaxSize: 1000, TargetLatency: 100 * time.Millisecond}
+	batches, flushed := pipeline.BatchAdaptive(ctx, in, opts, pipeline.WithClock(clock))
+
+	// Fast full flushes grow the size to MaxSize, far more than is sent.
+	for range 30 {
+		flushed(opts.MaxSize, time.Millisecond)
+	}
+
+	in <- 1
+	in <- 2
+	clock.BlockUntil(t, 1)
+	clock.Advance(99 * time.Millisecond)
+	assertNoItem(t, batches)
+	clock.Advance(time.Millisecond)
+	select {
+	case got := <-batches:
+		if !slices.Equal(got, []int{1, 2}) {
+			t.Errorf("got %v, want [1 2]", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("partial batch held longer than TargetLatency")
+	}
+}
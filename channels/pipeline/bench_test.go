@@ -0,0 +1,129 @@
+package pipeline_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// itemsPerOp is how many items each benchmark iteration pushes through, so
+// that the setup of the stages is amortised.
+const itemsPerOp = 1000
+
+func identity(_ context.Context, item int) (int, error) {
+	return item, nil
+}
+
+// benchItems runs fn once per iteration and reports the throughput.
+func benchItems(b *testing.B, fn func(ctx context.Context, items []int)) {
+	items := pipelinetest.Items(itemsPerOp)
+	start := time.Now()
+	for b.Loop() {
+		ctx, cancel := context.WithCancel(context.Background())
+		fn(ctx, items)
+		cancel()
+	}
+	b.ReportMetric(float64(b.N*itemsPerOp)/time.Since(start).Seconds(), "items/s")
+}
+
+func drain[T any](out <-chan T, errc <-chan error) {
+	for range out {
+	}
+	for range errc {
+	}
+}
+
+// BenchmarkStageBuffer passes items through a trivial transform, where the
+// cost of each handoff dominates.
+func BenchmarkStageBuffer(b *testing.B) {
+	for _, buffer := range []int{0, 1, 64, 1024} {
+		b.Run(fmt.Sprintf("buffer=%d", buffer), func(b *testing.B) {
+			opt := pipeline.WithBuffer(buffer)
+			benchItems(b, func(ctx context.Context, items []int) {
+				drain(pipeline.Stage(ctx, pipeline.Source(ctx, items, opt), identity, opt))
+			})
+		})
+	}
+}
+
+// BenchmarkStagePool compares pool sizes for work that needs the CPU and for
+// work that waits, as a network call would.
+func BenchmarkStagePool(b *testing.B) {
+	for _, work := range []struct {
+		name string
+		fn   func(context.Context, int) (int, error)
+	}{
+		{"cpu", pipelinetest.CPUWork(10_000)},
+		{"io", pipelinetest.IOWork(50 * time.Microsecond)},
+	} {
+		for _, workers := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("%s/workers=%d", work.name, workers), func(b *testing.B) {
+				benchItems(b, func(ctx context.Context, items []int) {
+					drain(pipeline.StagePool(ctx, pipeline.Source(ctx, items), workers, work.fn))
+				})
+			})
+		}
+	}
+}
+
+// BenchmarkSink compares handing every item to the sink on its own with
+// handing it batches of 64.
+func BenchmarkSink(b *testing.B) {
+	b.Run("per-item", func(b *testing.B) {
+		benchItems(b, func(ctx context.Context, items []int) {
+			drain(pipeline.Sink(ctx, pipeline.Source(ctx, items), func(context.Context, int) error { return nil }))
+		})
+	})
+	b.Run("batch=64", func(b *testing.B) {
+		benchItems(b, func(ctx context.Context, items []int) {
+			batches := pipeline.Batch(ctx, pipeline.Source(ctx, items), 64, time.Second)
+			drain(pipeline.Sink(ctx, batches, func(context.Context, []int) error { return nil }))
+		})
+	})
+}
+
+// BenchmarkPrefetch reads from a source taking 10ms per item in bursts of 50
+// items every 500ms. Without prefetching each burst waits for the source item
+// by item; with it the source keeps going while the consumer is away.
+func BenchmarkPrefetch(b *testing.B) {
+	const burst = 50
+	for _, prefetch := range []int{0, burst} {
+		b.Run(fmt.Sprintf("prefetch=%d", prefetch), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			in := pipeline.GenerateFunc(ctx, 10*time.Millisecond, func(i int) (int, bool) { return i, true })
+			if prefetch > 0 {
+				in = pipeline.Prefetch(ctx, in, prefetch)
+			}
+			start := time.Now()
+			for b.Loop() {
+				time.Sleep(500 * time.Millisecond)
+				for range burst {
+					<-in
+				}
+			}
+			b.ReportMetric(float64(b.N*burst)/time.Since(start).Seconds(), "items/s")
+		})
+	}
+}
+
+// BenchmarkCompose compares two trivial transforms fused with Compose2 into
+// one stage with the same transforms as two stages.
+func BenchmarkCompose(b *testing.B) {
+	b.Run("fused", func(b *testing.B) {
+		benchItems(b, func(ctx context.Context, items []int) {
+			drain(pipeline.Stage(ctx, pipeline.Source(ctx, items), pipeline.Compose2(identity, identity)))
+		})
+	})
+	b.Run("two-stage", func(b *testing.B) {
+		benchItems(b, func(ctx context.Context, items []int) {
+			first, firstErrc := pipeline.Stage(ctx, pipeline.Source(ctx, items), identity)
+			out, errc := pipeline.Stage(ctx, first, identity)
+			drain(out, pipeline.Merge(ctx, firstErrc, errc))
+		})
+	})
+}
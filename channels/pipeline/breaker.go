@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BreakerOptions controls when a CircuitBreaker opens and for how long.
+type BreakerOptions struct {
+	// Failures is the number of failed calls that opens the breaker. Values
+	// below 1 are treated as 1.
+	Failures int
+	// Window, when positive, opens the breaker once Failures of the last
+	// Window calls failed. Zero counts consecutive failures instead.
+	Window int
+	// Cooldown is how long the breaker stays open before letting a probe
+	// call through.
+	Cooldown time.Duration
+	// Now returns the current time. A nil Now uses time.Now.
+	Now func() time.Time
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is the state shared by every call of one CircuitBreaker, which may
+// come from several workers at once.
+type breaker struct {
+	opts BreakerOptions
+
+	mu       sync.Mutex
+	state    breakerState
+	openedAt time.Time
+	probing  bool
+	// outcomes holds the last Window results, true for a failure, with next
+	// the slot to overwrite. Without a Window only failed counts.
+	outcomes []bool
+	next     int
+	failed   int
+}
+
+// allow reports whether a call may go ahead and whether it is the probe of a
+// half-open breaker.
+func (b *breaker) allow() (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.opts.Now().Sub(b.openedAt) < b.opts.Cooldown {
+			return false, false
+		}
+		b.state = breakerHalfOpen
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// record feeds the outcome of an allowed call back into the breaker.
+func (b *breaker) record(probe, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+		if failed {
+			b.trip()
+		} else {
+			b.reset()
+		}
+		return
+	}
+	if b.state != breakerClosed {
+		// A call let through before the breaker opened; the probe decides.
+		return
+	}
+
+	if b.opts.Window > 0 {
+		if b.outcomes[b.next] {
+			b.failed--
+		}
+		b.outcomes[b.next] = failed
+		b.next = (b.next + 1) % b.opts.Window
+	} else if !failed {
+		b.failed = 0
+	}
+	if failed {
+		b.failed++
+	}
+	if b.failed >= b.opts.Failures {
+		b.trip()
+	}
+}
+
+func (b *breaker) trip() {
+	b.state = breakerOpen
+	b.openedAt = b.opts.Now()
+}
+
+func (b *breaker) reset() {
+	b.state = breakerClosed
+	b.failed = 0
+	b.next = 0
+	clear(b.outcomes)
+}
+
+// CircuitBreaker wraps fn so that a downstream that keeps failing is left
+// alone for a while. Once opts.Failures calls have failed the breaker opens
+// and fails every item with ErrCircuitOpen without calling fn. After
+// opts.Cooldown it lets a single probe call through: if that succeeds the
+// breaker closes again, otherwise it stays open for another cooldown. Calls
+// cut short by the stage shutting down are not counted. The wrapped function
+// is safe to use from the workers of a StagePool.
+func CircuitBreaker[T, U any](fn func(context.Context, T) (U, error), opts BreakerOptions) func(context.Context, T) (U, error) {
+	opts.Failures = max(opts.Failures, 1)
+	if opts.Window > 0 {
+		opts.Window = max(opts.Window, opts.Failures)
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	b := &breaker{opts: opts, outcomes: make([]bool, max(opts.Window, 0))}
+
+	return func(ctx context.Context, item T) (U, error) {
+		ok, probe := b.allow()
+		if !ok {
+			var zero U
+			return zero, ErrCircuitOpen
+		}
+		result, err := fn(ctx, item)
+		if err != nil && ctx.Err() != nil {
+			if probe {
+				b.mu.Lock()
+				b.probing = false
+				b.mu.Unlock()
+			}
+			return result, err
+		}
+		b.record(probe, err != nil)
+		return result, err
+	}
+}
@@ -0,0 +1,162 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+var errDown = errors.New("service down")
+
+// downstream fails while down is set and counts its calls.
+type downstream struct {
+	down  atomic.Bool
+	calls atomic.Int64
+}
+
+func (d *downstream) call(_ context.Context, n int) (int, error) {
+	d.calls.Add(1)
+	if d.down.Load() {
+		return 0, errDown
+	}
+	return n, nil
+}
+
+func newBreaker(d *downstream, clock *pipelinetest.Clock, opts pipeline.BreakerOptions) func(context.Context, int) (int, error) {
+	opts.Now = clock.Now
+	return pipeline.CircuitBreaker(d.call, opts)
+}
+
+func TestBreakerTripsAndFailsFast(t *testing.T) {
+	ctx := context.Background()
+	clock := pipelinetest.NewClock(time.Unix(0, 0))
+	d := &downstream{}
+	call := newBreaker(d, clock, pipeline.BreakerOptions{Failures: 3, Cooldown: time.Minute})
+
+	d.down.Store(true)
+	for range 3 {
+		if _, err := call(ctx, 1); !errors.Is(err, errDown) {
+			t.Fatalf("call = %v while closed, want the downstream error", err)
+		}
+	}
+	for range 10 {
+		if _, err := call(ctx, 1); !errors.Is(err, pipeline.ErrCircuitOpen) {
+			t.Fatalf("call = %v after 3 failures, want ErrCircuitOpen", err)
+		}
+	}
+	if n := d.calls.Load(); n != 3 {
+		t.Errorf("downstream called %d times, want 3 before the breaker opened", n)
+	}
+
+	// Still open until the cooldown is over.
+	clock.Advance(time.Minute - time.Second)
+	if _, err := call(ctx, 1); !errors.Is(err, pipeline.ErrCircuitOpen) {
+		t.Errorf("call = %v before the cooldown ended, want ErrCircuitOpen", err)
+	}
+}
+
+func TestBreakerSuccessResetsConsecutiveCount(t *testing.T) {
+	ctx := context.Background()
+	clock := pipelinetest.NewClock(time.Unix(0, 0))
+	d := &downstream{}
+	call := newBreaker(d, clock, pipeline.BreakerOptions{Failures: 3, Cooldown: time.Minute})
+
+	for i := range 10 {
+		d.down.Store(i%3 != 2)
+		if _, err := call(ctx, i); errors.Is(err, pipeline.ErrCircuitOpen) {
+			t.Fatalf("call %d opened the breaker, but no 3 failures were consecutive", i)
+		}
+	}
+}
+
+func TestBreakerProbeClosesIt(t *testing.T) {
+	ctx := context.Background()
+	clock := pipelinetest.NewClock(time.Unix(0, 0))
+	d := &downstream{}
+	call := newBreaker(d, clock, pipeline.BreakerOptions{Failures: 1, Cooldown: time.Minute})
+
+	d.down.Store(true)
+	call(ctx, 1)
+	d.down.Store(false)
+	clock.Advance(time.Minute)
+	if got, err := call(ctx, 7); err != nil || got != 7 {
+		t.Fatalf("probe = %d, %v, want it to reach the recovered downstream", got, err)
+	}
+	for i := range 5 {
+		if _, err := call(ctx, i); err != nil {
+			t.Errorf("call = %v after a successful probe, want the breaker closed", err)
+		}
+	}
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	ctx := context.Background()
+	clock := pipelinetest.NewClock(time.Unix(0, 0))
+	d := &downstream{}
+	call := newBreaker(d, clock, pipeline.BreakerOptions{Failures: 1, Cooldown: time.Minute})
+
+	d.down.Store(true)
+	call(ctx, 1)
+	clock.Advance(time.Minute)
+	if _, err := call(ctx, 1); !errors.Is(err, errDown) {
+		t.Fatalf("probe = %v, want the downstream error", err)
+	}
+	// A fresh cooldown starts with the failed probe.
+	clock.Advance(time.Minute - time.Second)
+	if _, err := call(ctx, 1); !errors.Is(err, pipeline.ErrCircuitOpen) {
+		t.Errorf("call = %v after a failed probe, want ErrCircuitOpen", err)
+	}
+	clock.Advance(time.Second)
+	d.down.Store(false)
+	if _, err := call(ctx, 1); err != nil {
+		t.Errorf("second probe = %v, want it let through", err)
+	}
+}
+
+func TestBreakerWindow(t *testing.T) {
+	ctx := context.Background()
+	clock := pipelinetest.NewClock(time.Unix(0, 0))
+	d := &downstream{}
+	call := newBreaker(d, clock, pipeline.BreakerOptions{Failures: 3, Window: 5, Cooldown: time.Minute})
+
+	// Failures 0, 2 and 4 are 3 of the last 5 calls, none consecutive.
+	for i := range 5 {
+		d.down.Store(i%2 == 0)
+		call(ctx, i)
+	}
+	if _, err := call(ctx, 5); !errors.Is(err, pipeline.ErrCircuitOpen) {
+		t.Errorf("call = %v after 3 failures in a window of 5, want ErrCircuitOpen", err)
+	}
+}
+
+func TestBreakerInPoolDeadLettersOpenCircuit(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Unix(0, 0))
+	d := &downstream{}
+	d.down.Store(true)
+	call := newBreaker(d, clock, pipeline.BreakerOptions{Failures: 5, Cooldown: time.Minute})
+
+	out, dead, errc := pipeline.StagePoolWithDeadLetters(ctx, pipeline.Source(ctx, pipelinetest.Items(200)), 8, call, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	got, dlq, errs := collectAll(t, out, dead, errc)
+	if len(got) != 0 || len(dlq) != 200 || len(errs) != 0 {
+		t.Fatalf("got %d items, %d dead letters and errors %v, want every item dead-lettered", len(got), len(dlq), errs)
+	}
+	var open int
+	for _, letter := range dlq {
+		if errors.Is(letter.Err, pipeline.ErrCircuitOpen) {
+			open++
+		}
+	}
+	// Workers that passed the breaker before it opened still call the
+	// downstream, but no more than one each.
+	if calls := d.calls.Load(); calls < 5 || calls > 5+8 || open != 200-int(calls) {
+		t.Errorf("downstream called %d times and %d items refused, want the breaker to open after 5 failures", calls, open)
+	}
+}
@@ -0,0 +1,30 @@
+package pipeline
+
+import (
+	"context"
+
+	"channelspractice/chanutil"
+)
+
+// Bridge flattens a channel of channels into a single stream. Inner channels
+// are consumed one after the other in the order they arrive, moving on to the
+// next one when the current one is closed.
+func Bridge[T any](ctx context.Context, chanStream <-chan (<-chan T), opts ...Option) <-chan T {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	go func() {
+		defer close(out)
+		for {
+			stream, ok, err := chanutil.RecvContext(ctx, chanStream)
+			if err != nil || !ok {
+				return
+			}
+			for item := range OrDone(ctx, stream) {
+				if chanutil.SendContext(ctx, out, item) != nil {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
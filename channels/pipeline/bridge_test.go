@@ -0,0 +1,51 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestBridgeFlattensInOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	streams := []<-chan int{
+		pipeline.Source(ctx, []int{1, 2}),
+		pipeline.Source(ctx, []int{}),
+		pipeline.Source(ctx, []int{3, 4, 5}),
+	}
+	got := pipelinetest.CollectWithTimeout(t, pipeline.Bridge(ctx, pipeline.Source(ctx, streams)), time.Second)
+	if !slices.Equal(got, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("got %v, want [1 2 3 4 5]", got)
+	}
+}
+
+func TestBridgeInnerNeverCloses(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	inner := make(chan int)
+	streams := make(chan (<-chan int), 1)
+	streams <- inner
+	out := pipeline.Bridge(ctx, streams)
+
+	inner <- 1
+	if v := <-out; v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	// Neither inner nor streams is ever closed.
+	cancel()
+	pipelinetest.AssertClosed(t, out, time.Second)
+}
+
+func TestBridgeWaitingForNextStream(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := pipeline.Bridge(ctx, make(chan (<-chan int)))
+	cancel()
+	pipelinetest.AssertClosed(t, out, time.Second)
+}
@@ -0,0 +1,143 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CheckpointStore persists how far a pipeline got. The offset is the number of
+// leading source items that have been completed, which is also the offset to
+// resume from.
+type CheckpointStore interface {
+	// Load returns the saved offset, or 0 if nothing was saved yet.
+	Load() (int64, error)
+	Save(offset int64) error
+}
+
+// WithStartOffset makes Source and SourceItems skip the first n items, e.g.
+// the offset loaded from a CheckpointStore.
+func WithStartOffset(n int64) Option {
+	return func(o *options) {
+		o.startOffset = n
+	}
+}
+
+// WithCheckpoint makes SinkItems save the highest offset up to which every
+// item has been completed to store, after every n completed items and when the
+// sink stops. Items finishing out of order, e.g. behind a StagePoolItems, only
+// move the checkpoint once every item before them has been completed, so an
+// item that never reaches the sink holds it back.
+func WithCheckpoint(store CheckpointStore, every int) Option {
+	return func(o *options) {
+		o.checkpoint = store
+		o.checkpointEvery = every
+	}
+}
+
+// checkpointer tracks the completed offsets of a single sink goroutine.
+type checkpointer struct {
+	store     CheckpointStore
+	every     int
+	next      int64
+	saved     int64
+	completes int
+	done      map[int64]bool
+}
+
+func newCheckpointer(store CheckpointStore, every int) (*checkpointer, error) {
+	start, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load checkpoint: %w", err)
+	}
+	return &checkpointer{store: store, every: max(every, 1), next: start, saved: start, done: make(map[int64]bool)}, nil
+}
+
+func (c *checkpointer) completed(offset int64) error {
+	if offset < c.next {
+		return nil
+	}
+	c.done[offset] = true
+	for c.done[c.next] {
+		delete(c.done, c.next)
+		c.next++
+	}
+	c.completes++
+	if c.completes%c.every != 0 {
+		return nil
+	}
+	return c.save()
+}
+
+func (c *checkpointer) save() error {
+	if c.next == c.saved {
+		return nil
+	}
+	if err := c.store.Save(c.next); err != nil {
+		return fmt.Errorf("save checkpoint %d: %w", c.next, err)
+	}
+	c.saved = c.next
+	return nil
+}
+
+// FileCheckpoint is a CheckpointStore keeping the offset in a file.
+type FileCheckpoint struct {
+	Path string
+	// Codec encodes the offset, as a line of decimal text if nil.
+	Codec Codec[int64]
+}
+
+// offsetText is the Codec of a FileCheckpoint without one.
+type offsetText struct{}
+
+func (offsetText) Encode(offset int64) ([]byte, error) {
+	return fmt.Appendf(nil, "%d\n", offset), nil
+}
+
+func (offsetText) Decode(data []byte) (int64, error) {
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func (f FileCheckpoint) codec() Codec[int64] {
+	if f.Codec == nil {
+		return offsetText{}
+	}
+	return f.Codec
+}
+
+// Load reads the offset from the file, returning 0 if it does not exist.
+func (f FileCheckpoint) Load() (int64, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return f.codec().Decode(data)
+}
+
+// Save replaces the file with one holding offset. It writes a temporary file
+// first, so a crash never leaves a partly written checkpoint behind.
+func (f FileCheckpoint) Save(offset int64) error {
+	data, err := f.codec().Encode(offset)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
@@ -0,0 +1,156 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// runCheckpointed runs SourceItems into a checkpointed SinkItems over 1..10,
+// resuming from store, and returns the items the sink processed. The sink
+// cancels the run once it has processed stopAt; 0 runs to the end.
+func runCheckpointed(t *testing.T, store pipeline.CheckpointStore, stopAt int) []int {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := make([]int, 10)
+	for i := range items {
+		items[i] = i + 1
+	}
+
+	var processed []int
+	src := pipeline.SourceItems(ctx, items, nil, pipeline.WithStartOffset(start))
+	done, errc := pipeline.SinkItems(ctx, src, func(_ context.Context, n int) error {
+		processed = append(processed, n)
+		if n == stopAt {
+			cancel()
+		}
+		return nil
+	}, pipeline.WithCheckpoint(store, 2))
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Fatalf("sink errors: %v", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+	return processed
+}
+
+func TestCheckpointResumeProcessesEachItemOnce(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	store := pipeline.FileCheckpoint{Path: filepath.Join(t.TempDir(), "offset")}
+
+	first := runCheckpointed(t, store, 5)
+	if len(first) == 10 {
+		t.Fatal("the first run was not stopped by the cancellation")
+	}
+	if offset, err := store.Load(); err != nil || offset != int64(len(first)) {
+		t.Fatalf("checkpoint = %d, %v after %d items, want %d", offset, err, len(first), len(first))
+	}
+	second := runCheckpointed(t, store, 0)
+
+	// The first run stops at 5, or just after it if the sink already held
+	// the next item; either way the two runs split 1..10 between them.
+	counts := make(map[int]int)
+	for _, n := range append(first, second...) {
+		counts[n]++
+	}
+	for n := 1; n <= 10; n++ {
+		if counts[n] != 1 {
+			t.Errorf("item %d processed %d times, want once (runs %v and %v)", n, counts[n], first, second)
+		}
+	}
+	if offset, _ := store.Load(); offset != 10 {
+		t.Errorf("final checkpoint = %d, want 10", offset)
+	}
+}
+
+// memCheckpoint is a CheckpointStore recording every saved offset.
+type memCheckpoint struct {
+	mu      sync.Mutex
+	offsets []int64
+	loadErr error
+}
+
+func (m *memCheckpoint) Load() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.offsets) == 0 {
+		return 0, m.loadErr
+	}
+	return m.offsets[len(m.offsets)-1], m.loadErr
+}
+
+func (m *memCheckpoint) Save(offset int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.offsets = append(m.offsets, offset)
+	return nil
+}
+
+func TestCheckpointIsHighestContiguousOffset(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Offset 2 finishes last, as it might behind a pool.
+	in := make(chan pipeline.Item[int])
+	go func() {
+		defer close(in)
+		for _, offset := range []int64{0, 1, 3, 4, 5} {
+			in <- pipeline.Item[int]{Ctx: ctx, Offset: offset}
+		}
+	}()
+	store := &memCheckpoint{}
+	done, errc := pipeline.SinkItems(ctx, in, func(context.Context, int) error { return nil }, pipeline.WithCheckpoint(store, 1))
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if offset, _ := store.Load(); offset != 2 {
+		t.Errorf("checkpoint = %d (saved %v), want 2 while offset 2 is missing", offset, store.offsets)
+	}
+}
+
+func TestCheckpointLoadError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCorrupt := errors.New("corrupt")
+	store := &memCheckpoint{loadErr: errCorrupt}
+	src := pipeline.SourceItems(ctx, pipelinetest.Items(10), nil)
+	done, errc := pipeline.SinkItems(ctx, src, func(context.Context, int) error {
+		t.Error("sink processed an item without a checkpoint")
+		return nil
+	}, pipeline.WithCheckpoint(store, 1))
+
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+	if len(errs) != 1 || !errors.Is(errs[0], errCorrupt) {
+		t.Errorf("errors = %v, want the load error", errs)
+	}
+}
+
+func TestFileCheckpointMissingFile(t *testing.T) {
+	store := pipeline.FileCheckpoint{Path: filepath.Join(t.TempDir(), "offset")}
+	if offset, err := store.Load(); offset != 0 || err != nil {
+		t.Fatalf("Load = %d, %v without a file, want 0, nil", offset, err)
+	}
+	if err := store.Save(42); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := store.Load(); offset != 42 || err != nil {
+		t.Errorf("Load = %d, %v after Save(42)", offset, err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(store.Path))
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the checkpoint", len(entries))
+	}
+}
@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"channelspractice/chanutil"
+)
+
+// ReadChunks emits the content of r in chunks of chunkSize bytes; only the
+// last one may be shorter. Both channels close on EOF, on the first read
+// error, which is sent on the error channel, or when ctx is cancelled.
+//
+// Every chunk is a fresh slice that the consumer owns and may keep. Reusing
+// buffers through a sync.Pool would need the consumer to hand every chunk
+// back. BenchmarkReadChunks, which hashes 64 MiB in 64 KiB chunks, runs about
+// 15% faster that way and with almost no garbage, which is still not worth
+// chunks that can be overwritten under a consumer that forgot the rule.
+//
+// As with FromReader, a Read that blocks cannot be interrupted: on
+// cancellation the channels close right away but the goroutine reading r
+// only returns once that Read does. Close r to release it.
+func ReadChunks(ctx context.Context, r io.Reader, chunkSize int, opts ...Option) (<-chan []byte, <-chan error) {
+	o := newOptions(opts)
+	out := make(chan []byte, o.buffer)
+	errc := make(chan error, 1)
+	chunkSize = max(chunkSize, 1)
+	unregister := o.register("source")
+	name := o.stageName("source")
+	o.logStart(ctx, name)
+
+	type read struct {
+		chunk []byte
+		err   error
+	}
+	reads := make(chan read)
+	go func() {
+		defer close(reads)
+		for {
+			chunk := make([]byte, chunkSize)
+			n, err := io.ReadFull(r, chunk)
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if n > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case reads <- read{chunk: chunk[:n]}:
+				}
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return
+			}
+			if err != nil {
+				select {
+				case <-ctx.Done():
+				case reads <- read{err: err}:
+				}
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(out)
+		for {
+			var rd read
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case rd, ok = <-reads:
+			}
+			if !ok {
+				return
+			}
+			if rd.err != nil {
+				errc <- &StageError{Stage: name, Err: fmt.Errorf("read chunks: %w", rd.err)}
+				return
+			}
+			if chanutil.SendContext(ctx, out, rd.chunk) != nil {
+				return
+			}
+		}
+	}()
+	return out, errc
+}
@@ -0,0 +1,174 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func readAllChunks(t *testing.T, r io.Reader, chunkSize int) ([][]byte, []error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, errc := pipeline.ReadChunks(ctx, r, chunkSize)
+	chunks := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	return chunks, pipelinetest.CollectWithTimeout(t, errc, time.Second)
+}
+
+func TestReadChunksSizes(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{name: "empty", input: "", want: nil},
+		{name: "divisible", input: "abcdef", want: []string{"abc", "def"}},
+		{name: "short last", input: "abcdefg", want: []string{"abc", "def", "g"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			chunks, errs := readAllChunks(t, strings.NewReader(tc.input), 3)
+			if len(errs) > 0 {
+				t.Fatalf("errors: %v", errs)
+			}
+			got := make([]string, len(chunks))
+			for i, c := range chunks {
+				got[i] = string(c)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("chunks = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadChunksConsumerOwnsChunks(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	chunks, _ := readAllChunks(t, strings.NewReader("aabbcc"), 2)
+	// Scribbling over one chunk must not change the others.
+	for _, c := range chunks {
+		c[0] = 'x'
+	}
+	if got := string(bytes.Join(chunks, nil)); got != "xaxbxc" {
+		t.Errorf("chunks = %q, want each chunk in its own buffer", got)
+	}
+}
+
+type errReader struct{}
+
+var errBadSector = errors.New("bad sector")
+
+func (errReader) Read([]byte) (int, error) { return 0, errBadSector }
+
+func TestReadChunksReadError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	chunks, errs := readAllChunks(t, io.MultiReader(strings.NewReader("abcd"), errReader{}), 3)
+
+	if len(chunks) != 2 || string(chunks[0]) != "abc" || string(chunks[1]) != "d" {
+		t.Errorf("chunks = %q, want what was read before the error", chunks)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], errBadSector) {
+		t.Errorf("errors = %v, want %v", errs, errBadSector)
+	}
+}
+
+func TestReadChunksCancelMidRead(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	pr, pw := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	out, errc := pipeline.ReadChunks(ctx, pr, 4)
+	go pw.Write([]byte("abcd"))
+	if chunk := <-out; string(chunk) != "abcd" {
+		t.Fatalf("first chunk = %q, want abcd", chunk)
+	}
+
+	// The reader now blocks in Read with nothing to give.
+	start := time.Now()
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("channels closed %v after cancel, want right away", elapsed)
+	}
+	// Closing the pipe releases the blocked Read.
+	pw.Close()
+}
+
+const (
+	benchFileSize  = 64 << 20
+	benchChunkSize = 64 << 10
+)
+
+// BenchmarkReadChunks backs the ReadChunks doc: hashing a file in fresh
+// chunks against recycling them through a sync.Pool, both handed over the
+// same way, next to ReadChunks itself.
+func BenchmarkReadChunks(b *testing.B) {
+	data := make([]byte, benchFileSize)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	b.Run("ReadChunks", func(b *testing.B) {
+		b.SetBytes(benchFileSize)
+		for b.Loop() {
+			h := sha256.New()
+			out, errc := pipeline.ReadChunks(context.Background(), bytes.NewReader(data), benchChunkSize)
+			for chunk := range out {
+				h.Write(chunk)
+			}
+			for err := range errc {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("fresh", func(b *testing.B) {
+		b.SetBytes(benchFileSize)
+		for b.Loop() {
+			hashChunks(data, func() []byte { return make([]byte, benchChunkSize) }, func([]byte) {})
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		pool := sync.Pool{New: func() any { return make([]byte, benchChunkSize) }}
+		b.SetBytes(benchFileSize)
+		for b.Loop() {
+			hashChunks(data, func() []byte { return pool.Get().([]byte) }, func(chunk []byte) {
+				pool.Put(chunk[:cap(chunk)])
+			})
+		}
+	})
+}
+
+// hashChunks hashes data read in chunks from get, handing put every chunk once
+// it has been hashed.
+func hashChunks(data []byte, get func() []byte, put func([]byte)) {
+	h := sha256.New()
+	out := make(chan []byte)
+	r := bytes.NewReader(data)
+	go func() {
+		defer close(out)
+		for {
+			chunk := get()
+			n, err := io.ReadFull(r, chunk)
+			if n > 0 {
+				out <- chunk[:n]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for chunk := range out {
+		h.Write(chunk)
+		put(chunk)
+	}
+}
@@ -0,0 +1,56 @@
+package pipeline
+
+import "time"
+
+// Clock is the source of time for the stages that wait on it: Debounce,
+// Batch, BatchAdaptive, Throttle, DelayQueue, Poll, Hedge, the windows,
+// JoinStreaming, acknowledgements and deadlines, as well as Retry through
+// RetryOptions.Clock. A CircuitBreaker reads the time from BreakerOptions.Now.
+// The default is the real clock; tests hand a manual one, such as
+// pipelinetest.Clock, to WithClock so that time only moves when they say so.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	// After is NewTimer(d).C() for a timer that is never stopped.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Timer is the part of time.Timer a Clock has to provide. As with time.Timer
+// since Go 1.23, Stop and Reset discard a value not yet received from C.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is the part of time.Ticker a Clock has to provide.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// WithClock makes a time-based stage read the time and wait for it from c.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
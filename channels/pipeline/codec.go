@@ -0,0 +1,179 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Codec turns items into bytes and back, for stages that keep items outside
+// memory: DiskBuffer, Record and Replay, Frames for a FileSink, and
+// FileCheckpoint.
+type Codec[T any] interface {
+	Encode(item T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// GobCodec is a Codec that encodes every item on its own with encoding/gob.
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(item T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(item); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var item T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&item)
+	return item, err
+}
+
+// JSONCodec is a Codec that encodes every item with encoding/json.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(item T) ([]byte, error) {
+	return json.Marshal(item)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var item T
+	err := json.Unmarshal(data, &item)
+	return item, err
+}
+
+// RawCodec is the Codec of items that already are bytes. It neither copies
+// nor checks them.
+type RawCodec struct{}
+
+func (RawCodec) Encode(item []byte) ([]byte, error) { return item, nil }
+
+func (RawCodec) Decode(data []byte) ([]byte, error) { return data, nil }
+
+// DefaultMaxFrameSize is the largest frame a stage writes or reads unless set
+// with WithMaxFrameSize.
+const DefaultMaxFrameSize = 64 << 20
+
+// WithMaxFrameSize sets the largest frame DiskBuffer, Record, Replay and
+// Frames write or read. An item encoding to more fails, and so does reading a
+// frame whose length prefix says more, as a corrupt one would.
+func WithMaxFrameSize(n int) Option {
+	return func(o *options) {
+		o.maxFrame = n
+	}
+}
+
+// WithCodec makes Record and Replay encode their items with codec instead of
+// GobCodec. Its item type must be the one of the stage, which panics
+// otherwise.
+func WithCodec[T any](codec Codec[T]) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// codecOf returns the codec set with WithCodec, or GobCodec.
+func codecOf[T any](o options) Codec[T] {
+	if o.codec == nil {
+		return GobCodec[T]{}
+	}
+	codec, ok := o.codec.(Codec[T])
+	if !ok {
+		panic(fmt.Sprintf("pipeline: WithCodec got a %T for items of type %v", o.codec, reflect.TypeFor[T]()))
+	}
+	return codec
+}
+
+func (o options) frameLimit() int {
+	if o.maxFrame <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return o.maxFrame
+}
+
+// WriteFrame writes data to w as one frame: its length as a uvarint followed
+// by the bytes themselves, in a single Write, so that a failing writer leaves
+// at most the last frame cut short.
+func WriteFrame(w io.Writer, data []byte) error {
+	_, err := w.Write(appendFrame(nil, data))
+	return err
+}
+
+// appendFrame appends data as a frame to dst.
+func appendFrame(dst, data []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(data)))
+	return append(dst, data...)
+}
+
+// ReadFrame reads the next frame WriteFrame wrote to r and returns its bytes.
+// It returns io.EOF only if r ends before the frame, io.ErrUnexpectedEOF if it
+// ends in the middle of one, and an error wrapping ErrFrameTooLarge, without
+// reading on, if the length prefix is above maxSize. Short reads are read on
+// until the frame is complete. Unless r is an io.ByteReader, such as a
+// bufio.Reader, the prefix is read from it a byte at a time.
+func ReadFrame(r io.Reader, maxSize int) ([]byte, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = byteReader{r}
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(maxSize) {
+		return nil, fmt.Errorf("frame of %d bytes, limit %d: %w", n, maxSize, ErrFrameTooLarge)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// byteReader reads a byte at a time from a reader that cannot do it itself.
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
+
+// encodeFrame encodes item with codec into a frame of at most maxSize bytes
+// of data.
+func encodeFrame[T any](codec Codec[T], item T, maxSize int) ([]byte, error) {
+	data, err := codec.Encode(item)
+	if err != nil {
+		return nil, fmt.Errorf("encode item %v: %w", item, err)
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("item %v encodes to %d bytes, limit %d: %w", item, len(data), maxSize, ErrFrameTooLarge)
+	}
+	return appendFrame(nil, data), nil
+}
+
+// Frames encodes every item from in with codec and emits it as a frame, so
+// that the chunks written to a FileSink make a file Replay with the same
+// codec reads back. An item that fails to encode is handled like any stage
+// error.
+func Frames[T any](ctx context.Context, in <-chan T, codec Codec[T], opts ...Option) (<-chan []byte, <-chan error) {
+	o := newOptions(opts)
+	limit := o.frameLimit()
+	frame := func(_ context.Context, item T) ([]byte, error) {
+		return encodeFrame(codec, item, limit)
+	}
+	return Stage(ctx, in, frame, append(opts, WithName(o.stageName("frames")))...)
+}
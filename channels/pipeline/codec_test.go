@@ -0,0 +1,305 @@
+package pipeline_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestCodecsRoundTrip(t *testing.T) {
+	want := readings(20)
+	for name, codec := range map[string]pipeline.Codec[reading]{
+		"gob":  pipeline.GobCodec[reading]{},
+		"json": pipeline.JSONCodec[reading]{},
+	} {
+		for _, r := range want {
+			data, err := codec.Encode(r)
+			if err != nil {
+				t.Fatalf("%s: Encode(%v) = %v", name, r, err)
+			}
+			got, err := codec.Decode(data)
+			if err != nil || !readingEqual(got, r) {
+				t.Errorf("%s: Decode = %v, %v, want %v", name, got, err, r)
+			}
+		}
+	}
+	raw := pipeline.RawCodec{}
+	for _, chunk := range [][]byte{nil, {}, []byte("frame"), {0, 255}} {
+		data, _ := raw.Encode(chunk)
+		if got, err := raw.Decode(data); err != nil || !bytes.Equal(got, chunk) {
+			t.Errorf("raw: Decode = %q, %v, want %q", got, err, chunk)
+		}
+	}
+}
+
+func TestJSONCodecDecodeError(t *testing.T) {
+	if _, err := (pipeline.JSONCodec[reading]{}).Decode([]byte(`{"Sensor":`)); err == nil {
+		t.Error("Decode of cut JSON = nil, want an error")
+	}
+}
+
+func TestFramesReadBackDespiteShortReads(t *testing.T) {
+	want := [][]byte{[]byte("one"), {}, bytes.Repeat([]byte{7}, 300), []byte("last")}
+	var buf bytes.Buffer
+	for _, data := range want {
+		if err := pipeline.WriteFrame(&buf, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// OneByteReader is neither an io.ByteReader nor returns a frame in a
+	// single read.
+	r := iotest.OneByteReader(bytes.NewReader(buf.Bytes()))
+	for i, data := range want {
+		got, err := pipeline.ReadFrame(r, 1<<10)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("frame %d = %q, %v, want %q", i, got, err, data)
+		}
+	}
+	if _, err := pipeline.ReadFrame(r, 1<<10); err != io.EOF {
+		t.Errorf("ReadFrame after the last frame = %v, want io.EOF", err)
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	pipeline.WriteFrame(&buf, bytes.Repeat([]byte{1}, 200))
+	// Cut into the data, and into the two bytes of the length prefix.
+	for _, n := range []int{buf.Len() - 1, 2, 1} {
+		_, err := pipeline.ReadFrame(bytes.NewReader(buf.Bytes()[:n]), 1<<10)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("ReadFrame of %d bytes = %v, want io.ErrUnexpectedEOF", n, err)
+		}
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	// A length prefix of 1<<40 and nothing after it.
+	prefix := binary.AppendUvarint(nil, 1<<40)
+	_, err := pipeline.ReadFrame(bytes.NewReader(prefix), pipeline.DefaultMaxFrameSize)
+	if !errors.Is(err, pipeline.ErrFrameTooLarge) {
+		t.Errorf("ReadFrame = %v, want ErrFrameTooLarge", err)
+	}
+}
+
+// FuzzReadFrame compares ReadFrame with a model decoding the frames from a
+// byte slice with binary.Uvarint.
+func FuzzReadFrame(f *testing.F) {
+	const limit = 64
+	var frames bytes.Buffer
+	for _, n := range []int{0, 1, 63, 64} {
+		pipeline.WriteFrame(&frames, bytes.Repeat([]byte{byte(n)}, n))
+	}
+	valid := frames.Bytes()
+	for _, data := range [][]byte{
+		{},
+		valid,
+		valid[:len(valid)-1],
+		append(slices.Clone(valid), 0x80),
+		binary.AppendUvarint(nil, limit+1),
+		binary.AppendUvarint(nil, 1<<63),
+		bytes.Repeat([]byte{0xff}, binary.MaxVarintLen64),
+		bytes.Repeat([]byte{0xff}, binary.MaxVarintLen64+1),
+	} {
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := iotest.HalfReader(bytes.NewReader(data))
+		rest := data
+		for {
+			got, err := pipeline.ReadFrame(r, limit)
+
+			n, k := binary.Uvarint(rest)
+			// A reader gives up on a prefix after MaxVarintLen64 bytes
+			// that all say more follow, where Uvarint asks for more.
+			overflow := k < 0 || k == 0 && len(rest) >= binary.MaxVarintLen64
+			switch {
+			case len(rest) == 0:
+				if err != io.EOF {
+					t.Fatalf("ReadFrame at the end = %q, %v, want io.EOF", got, err)
+				}
+				return
+			case overflow:
+				if err == nil || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, pipeline.ErrFrameTooLarge) {
+					t.Fatalf("ReadFrame of an overflowing prefix = %q, %v, want the overflow", got, err)
+				}
+				return
+			case k == 0 || n <= limit && uint64(len(rest)-k) < n:
+				if !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Fatalf("ReadFrame of a cut frame = %q, %v, want io.ErrUnexpectedEOF", got, err)
+				}
+				return
+			case n > limit:
+				if !errors.Is(err, pipeline.ErrFrameTooLarge) {
+					t.Fatalf("ReadFrame of a %d byte frame = %v, want ErrFrameTooLarge", n, err)
+				}
+				return
+			}
+			want := rest[k : k+int(n)]
+			if err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("ReadFrame = %q, %v, want %q", got, err, want)
+			}
+			rest = rest[k+int(n):]
+		}
+	})
+}
+
+func TestRecordWithJSONCodec(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	want := readings(10)
+	codec := pipeline.WithCodec[reading](pipeline.JSONCodec[reading]{})
+	var buf bytes.Buffer
+	out, errc := pipeline.Record(ctx, pipeline.Source(ctx, want), &buf, codec)
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Fatalf("record errors: %v", errs)
+	}
+
+	first, err := pipeline.ReadFrame(bytes.NewReader(buf.Bytes()), pipeline.DefaultMaxFrameSize)
+	if err != nil || !bytes.HasPrefix(first, []byte(`{"Sensor":"a"`)) {
+		t.Errorf("first frame = %s, %v, want the JSON of the first reading", first, err)
+	}
+	replayed, replayErrs := pipeline.Replay[reading](ctx, &buf, codec)
+	got := pipelinetest.CollectWithTimeout(t, replayed, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, replayErrs, time.Second); len(errs) != 0 {
+		t.Fatalf("replay errors: %v", errs)
+	}
+	if !slices.EqualFunc(got, want, readingEqual) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestWithCodecOfAnotherType(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Record of ints with a codec of readings did not panic")
+		}
+	}()
+	pipeline.Record(context.Background(), make(chan int), io.Discard, pipeline.WithCodec[reading](pipeline.JSONCodec[reading]{}))
+}
+
+func TestRecordItemAboveFrameLimit(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var buf bytes.Buffer
+	// Every reading takes more than 16 bytes as JSON.
+	out, errc := pipeline.Record(ctx, pipeline.Source(ctx, readings(3)), &buf,
+		pipeline.WithCodec[reading](pipeline.JSONCodec[reading]{}), pipeline.WithMaxFrameSize(16))
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if len(got) != 0 || buf.Len() != 0 {
+		t.Errorf("forwarded %d items and wrote %d bytes, want neither", len(got), buf.Len())
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], pipeline.ErrFrameTooLarge) {
+		t.Errorf("errors = %v, want ErrFrameTooLarge", errs)
+	}
+}
+
+func TestReplayCorruptLengthPrefix(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var buf bytes.Buffer
+	one, _ := pipeline.GobCodec[int]{}.Encode(1)
+	pipeline.WriteFrame(&buf, one)
+	buf.Write(binary.AppendUvarint(nil, 1<<40))
+	got, errs := replayAll[int](t, &buf)
+	if !slices.Equal(got, []int{1}) || len(errs) != 1 || !errors.Is(errs[0], pipeline.ErrFrameTooLarge) {
+		t.Errorf("got %v and errors %v, want 1, then ErrFrameTooLarge", got, errs)
+	}
+}
+
+// TestRecordingReadAsSegment reads a recording the way DiskBuffer reads its
+// segments back: frame by frame off a bufio.Reader, decoding each with the
+// codec.
+func TestRecordingReadAsSegment(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "recording")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := readings(50)
+	out, errc := pipeline.Record(ctx, pipeline.Source(ctx, want), f)
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Fatalf("record errors: %v", errs)
+	}
+	f.Close()
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var got []reading
+	for {
+		data, err := pipeline.ReadFrame(br, pipeline.DefaultMaxFrameSize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := pipeline.GobCodec[reading]{}.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	if !slices.EqualFunc(got, want, readingEqual) {
+		t.Errorf("read %d readings, want the %d recorded", len(got), len(want))
+	}
+}
+
+func TestFramesToFileSinkReplay(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "readings")
+	want := readings(30)
+	frames, frameErrs := pipeline.Frames(ctx, pipeline.Source(ctx, want), pipeline.GobCodec[reading]{})
+	done, sinkErrs := pipeline.FileSink(ctx, frames, path, pipeline.FileSinkOptions{})
+	if err := pipeline.WaitAll(ctx, done, frameErrs, sinkErrs); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, errs := replayAll[reading](t, f)
+	if len(errs) != 0 || !slices.EqualFunc(got, want, readingEqual) {
+		t.Errorf("replayed %d readings with errors %v, want the %d written", len(got), errs, len(want))
+	}
+}
+
+func TestFileCheckpointCodec(t *testing.T) {
+	store := pipeline.FileCheckpoint{Path: filepath.Join(t.TempDir(), "offset"), Codec: pipeline.JSONCodec[int64]{}}
+	if err := store.Save(42); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(store.Path); string(data) != "42" {
+		t.Errorf("checkpoint file holds %q, want the JSON 42", data)
+	}
+	if offset, err := store.Load(); offset != 42 || err != nil {
+		t.Errorf("Load = %d, %v, want 42", offset, err)
+	}
+}
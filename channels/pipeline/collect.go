@@ -0,0 +1,70 @@
+package pipeline
+
+import "context"
+
+// Collect reads in until it is closed and returns its items in arrival order.
+// If ctx is cancelled first it returns the items read so far together with
+// ctx.Err().
+func Collect[T any](ctx context.Context, in <-chan T) ([]T, error) {
+	var items []T
+	for {
+		select {
+		case <-ctx.Done():
+			return items, ctx.Err()
+		case item, ok := <-in:
+			if !ok {
+				return items, nil
+			}
+			items = append(items, item)
+		}
+	}
+}
+
+// collectNPrealloc bounds the items CollectN allocates room for upfront, so
+// that a large n costs memory only as the items arrive.
+const collectNPrealloc = 1024
+
+// CollectN is Collect that stops after n items. Stopping early would strand
+// whatever is blocked sending on in, so CollectN then calls stop, which must
+// cancel the context of the stages feeding in, and discards items until in
+// is closed. It returns only once the upstream has shut down. For an n of 0
+// or less it reads no items at all.
+func CollectN[T any](ctx context.Context, in <-chan T, n int, stop context.CancelFunc) ([]T, error) {
+	defer func() {
+		stop()
+		for range in {
+		}
+	}()
+	if n <= 0 {
+		return nil, nil
+	}
+	items := make([]T, 0, min(n, collectNPrealloc))
+	for len(items) < n {
+		select {
+		case <-ctx.Done():
+			return items, ctx.Err()
+		case item, ok := <-in:
+			if !ok {
+				return items, nil
+			}
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// Drain discards items from in until it is closed, e.g. to run a pipeline
+// only for the side effects of its stages. It returns ctx.Err() if ctx is
+// cancelled first.
+func Drain[T any](ctx context.Context, in <-chan T) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-in:
+			if !ok {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestCollect(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got, err := pipeline.Collect(ctx, pipeline.Source(ctx, pipelinetest.Items(100)))
+	if err != nil || !slices.Equal(got, pipelinetest.Items(100)) {
+		t.Errorf("Collect = %d items, %v, want all 100 in order", len(got), err)
+	}
+}
+
+func TestCollectCancelledKeepsPartialResults(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// in is never closed, so only the cancellation ends Collect.
+	in := make(chan int, 5)
+	for n := range 5 {
+		in <- n
+	}
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	got, err := pipeline.Collect(ctx, in)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Collect error = %v, want context.Canceled", err)
+	}
+	if !slices.Equal(got, pipelinetest.Items(5)) {
+		t.Errorf("Collect = %v, want the 5 items read before the cancellation", got)
+	}
+}
+
+func TestCollectNStopsInfiniteGenerator(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	genCtx, stop := context.WithCancel(ctx)
+	ticks := pipeline.GenerateFunc(genCtx, time.Millisecond, func(i int) (int, bool) { return i, true })
+
+	got, err := pipeline.CollectN(ctx, ticks, 3, stop)
+	if err != nil || !slices.Equal(got, []int{0, 1, 2}) {
+		t.Errorf("CollectN = %v, %v, want [0 1 2]", got, err)
+	}
+	// CollectN returns only once the generator has closed its channel.
+	pipelinetest.AssertClosed(t, ticks, time.Second)
+}
+
+func TestCollectNShortInput(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got, err := pipeline.CollectN(ctx, pipeline.Source(ctx, pipelinetest.Items(2)), 5, cancel)
+	if err != nil || !slices.Equal(got, []int{0, 1}) {
+		t.Errorf("CollectN = %v, %v, want both items", got, err)
+	}
+}
+
+func TestCollectNNoItems(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	for _, n := range []int{0, -1} {
+		ctx, cancel := context.WithCancel(context.Background())
+		in := pipeline.Source(ctx, pipelinetest.Items(5))
+		if got, err := pipeline.CollectN(ctx, in, n, cancel); err != nil || len(got) != 0 {
+			t.Errorf("CollectN(%d) = %v, %v, want no items", n, got, err)
+		}
+		pipelinetest.AssertClosed(t, in, time.Second)
+		cancel()
+	}
+}
+
+func TestCollectNLargeN(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Only the items that arrive take up memory.
+	got, err := pipeline.CollectN(ctx, pipeline.Source(ctx, pipelinetest.Items(3000)), math.MaxInt, cancel)
+	if err != nil || len(got) != 3000 || got[2999] != 2999 {
+		t.Errorf("CollectN = %d items, %v, want all 3000", len(got), err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := pipeline.Drain(ctx, pipeline.Source(ctx, pipelinetest.Items(100))); err != nil {
+		t.Errorf("Drain = %v", err)
+	}
+
+	cancel()
+	if err := pipeline.Drain(ctx, make(chan int)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Drain of a cancelled context = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,28 @@
+package pipeline
+
+import "context"
+
+// Compose2 fuses f and g into a single stage function that runs g on the
+// result of f, so that Stage(ctx, in, Compose2(f, g)) does in one goroutine
+// and one channel hop what two stages would. An error from f is returned as
+// is and g is not called.
+func Compose2[A, B, C any](f func(context.Context, A) (B, error), g func(context.Context, B) (C, error)) func(context.Context, A) (C, error) {
+	return func(ctx context.Context, a A) (C, error) {
+		b, err := f(ctx, a)
+		if err != nil {
+			var zero C
+			return zero, err
+		}
+		return g(ctx, b)
+	}
+}
+
+// Compose3 is Compose2 for three functions, returning the first error.
+func Compose3[A, B, C, D any](f func(context.Context, A) (B, error), g func(context.Context, B) (C, error), h func(context.Context, C) (D, error)) func(context.Context, A) (D, error) {
+	return Compose2(Compose2(f, g), h)
+}
+
+// Compose4 is Compose2 for four functions, returning the first error.
+func Compose4[A, B, C, D, E any](f func(context.Context, A) (B, error), g func(context.Context, B) (C, error), h func(context.Context, C) (D, error), k func(context.Context, D) (E, error)) func(context.Context, A) (E, error) {
+	return Compose2(Compose3(f, g, h), k)
+}
@@ -0,0 +1,63 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestComposeStage(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	format := func(_ context.Context, n int) (string, error) {
+		return strconv.Itoa(n), nil
+	}
+
+	out, errc := pipeline.Stage(ctx, pipeline.Source(ctx, []int{1, 2, 3}), pipeline.Compose3(double, double, format))
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []string{"4", "8", "12"}) {
+		t.Errorf("got %v, want [4 8 12]", got)
+	}
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestComposeShortCircuits(t *testing.T) {
+	for failing := range 3 {
+		t.Run(fmt.Sprintf("step %d", failing), func(t *testing.T) {
+			var calls []int
+			step := func(i int) func(context.Context, int) (int, error) {
+				return func(_ context.Context, n int) (int, error) {
+					calls = append(calls, i)
+					if i == failing {
+						return 0, errBoom
+					}
+					return n + 1, nil
+				}
+			}
+
+			got, err := pipeline.Compose3(step(0), step(1), step(2))(context.Background(), 10)
+			if !errors.Is(err, errBoom) || got != 0 {
+				t.Errorf("composed = %d, %v, want 0, %v", got, err, errBoom)
+			}
+			if want := []int{0, 1, 2}[:failing+1]; !slices.Equal(calls, want) {
+				t.Errorf("called steps %v, want %v", calls, want)
+			}
+		})
+	}
+}
+
+func TestCompose4(t *testing.T) {
+	got, err := pipeline.Compose4(double, double, double, double)(context.Background(), 1)
+	if err != nil || got != 16 {
+		t.Errorf("composed = %d, %v, want 16, nil", got, err)
+	}
+}
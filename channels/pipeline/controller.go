@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+
+	"channelspractice/chanutil"
+)
+
+// State is whether a Controller lets its sources emit.
+type State int
+
+const (
+	// Running sources emit as usual.
+	Running State = iota
+	// Paused sources hold on to their next item until resumed.
+	Paused
+)
+
+func (s State) String() string {
+	if s == Paused {
+		return "paused"
+	}
+	return "running"
+}
+
+// Controller pauses and resumes the sources it is given to with
+// WithController, e.g. from a signal handler, without tearing the pipeline
+// down. A paused source emits nothing, not even the item it was offering at
+// the time, and carries on where it stopped once resumed; the items already
+// past it keep draining through the stages. Cancellation stops a paused
+// source as usual. A Controller is safe for concurrent use.
+type Controller struct {
+	mu     sync.Mutex
+	state  State
+	paused chan struct{} // closed while paused
+	resume chan struct{} // closed while running
+}
+
+// NewController returns a running controller.
+func NewController() *Controller {
+	resume := make(chan struct{})
+	close(resume)
+	return &Controller{paused: make(chan struct{}), resume: resume}
+}
+
+// Pause stops the sources from emitting. Pausing a paused controller does
+// nothing.
+func (c *Controller) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == Paused {
+		return
+	}
+	c.state = Paused
+	close(c.paused)
+	c.resume = make(chan struct{})
+}
+
+// Resume lets the sources emit again. Resuming a running controller does
+// nothing.
+func (c *Controller) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == Running {
+		return
+	}
+	c.state = Running
+	close(c.resume)
+	c.paused = make(chan struct{})
+}
+
+// State reports whether the controller is running or paused.
+func (c *Controller) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+func (c *Controller) channels() (paused, resume <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused, c.resume
+}
+
+// WithController makes a Source or SourceItems check with c before emitting
+// every item, and a DynamicSource before taking every push.
+func WithController(c *Controller) Option {
+	return func(o *options) {
+		o.controller = c
+	}
+}
+
+// sendUnlessPaused sends item on out like chanutil.SendContext, but while c is
+// paused it only waits for it to be resumed. A nil c never pauses.
+func sendUnlessPaused[T any](ctx context.Context, c *Controller, out chan<- T, item T) bool {
+	if c == nil {
+		return chanutil.SendContext(ctx, out, item) == nil
+	}
+	for {
+		paused, resume := c.channels()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-resume:
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-paused:
+			// Offer item again once resumed.
+		case out <- item:
+			return true
+		}
+	}
+}
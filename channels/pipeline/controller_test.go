@@ -0,0 +1,95 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// assertNothingFor fails if out yields anything within d.
+func assertNothingFor[T any](t *testing.T, out <-chan T, d time.Duration) {
+	t.Helper()
+	select {
+	case item, ok := <-out:
+		t.Fatalf("got %v (open %t) while paused", item, ok)
+	case <-time.After(d):
+	}
+}
+
+func TestControllerPauseResume(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := pipeline.NewController()
+
+	out := pipeline.Source(ctx, pipelinetest.Items(20), pipeline.WithController(c))
+	var got []int
+	for range 5 {
+		got = append(got, <-out)
+	}
+	c.Pause()
+	if c.State() != pipeline.Paused {
+		t.Fatalf("state %v after Pause", c.State())
+	}
+	// The source was offering 5 already, and does not any more.
+	assertNothingFor(t, out, 50*time.Millisecond)
+
+	c.Resume()
+	if c.State() != pipeline.Running {
+		t.Fatalf("state %v after Resume", c.State())
+	}
+	got = append(got, pipelinetest.CollectWithTimeout(t, out, time.Second)...)
+	if !slices.Equal(got, pipelinetest.Items(20)) {
+		t.Errorf("got %v, want every item once in order", got)
+	}
+}
+
+func TestControllerManyTransitions(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := pipeline.NewController()
+
+	src := pipeline.Source(ctx, pipelinetest.Items(1000), pipeline.WithController(c))
+	out, errc := pipeline.Stage(ctx, src, double, pipeline.WithBuffer(4))
+	toggled := make(chan struct{})
+	go func() {
+		defer close(toggled)
+		for range 200 {
+			c.Pause()
+			c.Pause()
+			time.Sleep(10 * time.Microsecond)
+			c.Resume()
+		}
+	}()
+
+	got := pipelinetest.CollectWithTimeout(t, out, 5*time.Second)
+	<-toggled
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	var want []int
+	for n := range 1000 {
+		want = append(want, 2*n)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %d items, want every one of the 1000 once in order", len(got))
+	}
+}
+
+func TestControllerCancelWhilePaused(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := pipeline.NewController()
+	c.Pause()
+
+	out := pipeline.SourceItems(ctx, pipelinetest.Items(3), nil, pipeline.WithController(c))
+	assertNothingFor(t, out, 20*time.Millisecond)
+	cancel()
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 0 {
+		t.Errorf("got %v from a source cancelled while paused", got)
+	}
+}
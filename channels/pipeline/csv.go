@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+
+	"channelspractice/chanutil"
+)
+
+// FromCSV emits the records read from r with encoding/csv, skipping the first
+// one when hasHeader is set. A malformed row stops the source and its
+// csv.ParseError, which names the line, is sent on the error channel. Under
+// the SkipAndReport policy the error is sent and reading carries on with the
+// next row, up to the limit set with WithMaxErrors. Both channels are closed
+// on EOF, on a read error or when ctx is cancelled; cancellation is noticed
+// between rows.
+func FromCSV(ctx context.Context, r io.Reader, hasHeader bool, opts ...Option) (<-chan []string, <-chan error) {
+	o := newOptions(opts)
+	out := make(chan []string, o.buffer)
+	errc := o.newErrc()
+	unregister := o.register("source")
+	name := o.stageName("source")
+	o.logStart(ctx, name)
+
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(out)
+
+		sendErr := func(err error) {
+			select {
+			case <-ctx.Done():
+			case errc <- &StageError{Stage: name, Err: err}:
+			}
+		}
+
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		var errCount int64
+		for first := true; ; first = false {
+			if ctx.Err() != nil {
+				return
+			}
+			record, err := cr.Read()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) && o.errorPolicy == SkipAndReport {
+				errCount++
+				if limitErr := o.checkErrorCount(errCount, err); limitErr != nil {
+					sendErr(limitErr)
+					return
+				}
+				if !o.reportErr(ctx, errc, &StageError{Stage: name, Err: err}) {
+					return
+				}
+				continue
+			}
+			if err != nil {
+				sendErr(fmt.Errorf("read csv: %w", err))
+				return
+			}
+			if first && hasHeader {
+				continue
+			}
+			if chanutil.SendContext(ctx, out, record) != nil {
+				return
+			}
+		}
+	}()
+	return out, errc
+}
+
+// SinkCSV writes header, unless it is empty, followed by every record read
+// from in to w. Like SinkJSONL, writes are buffered and always flushed, even
+// after a failure, and the done channel is closed only after that final flush,
+// with the first write or flush error sent on the error channel before it.
+func SinkCSV(ctx context.Context, in <-chan []string, w io.Writer, header []string, opts ...Option) (<-chan struct{}, <-chan error) {
+	o := newOptions(opts)
+	cw := csv.NewWriter(w)
+	if len(header) > 0 {
+		// Only buffered for now, an error surfaces with the first flush.
+		_ = cw.Write(header)
+	}
+	write := func(_ context.Context, record []string) error {
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("write record %v: %w", record, err)
+		}
+		return nil
+	}
+	_, sinkErrc := Sink(ctx, in, write, append(opts, WithName(o.stageName("csv")))...)
+
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(done)
+		var err error
+		for sinkErr := range sinkErrc {
+			if err == nil {
+				err = sinkErr
+			}
+		}
+		cw.Flush()
+		if flushErr := cw.Error(); flushErr != nil && err == nil {
+			err = &StageError{Stage: o.stageName("csv"), Err: fmt.Errorf("flush: %w", flushErr)}
+		}
+		if err != nil {
+			errc <- err
+		}
+	}()
+	return done, errc
+}
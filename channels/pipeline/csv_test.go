@@ -0,0 +1,124 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func readCSV(t *testing.T, input string, hasHeader bool, opts ...pipeline.Option) ([][]string, []error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, errc := pipeline.FromCSV(ctx, strings.NewReader(input), hasHeader, opts...)
+	var errs []error
+	errsDone := make(chan struct{})
+	go func() {
+		defer close(errsDone)
+		for err := range errc {
+			errs = append(errs, err)
+		}
+	}()
+	records := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	<-errsDone
+	return records, errs
+}
+
+func TestFromCSVQuotedFields(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	records, errs := readCSV(t, "name,note\n\"Smith, J\",\"said \"\"hi\"\"\"\n\"multi\nline\",x\n", true)
+	if len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	want := [][]string{{"Smith, J", `said "hi"`}, {"multi\nline", "x"}}
+	if !slices.EqualFunc(records, want, slices.Equal) {
+		t.Errorf("records = %q, want %q", records, want)
+	}
+}
+
+const malformedCSV = "n\n1\n2\"x\n3\n"
+
+func TestFromCSVMalformedRowStops(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	records, errs := readCSV(t, malformedCSV, true)
+
+	if !slices.EqualFunc(records, [][]string{{"1"}}, slices.Equal) {
+		t.Errorf("records = %q, want only the row before the bad one", records)
+	}
+	var parseErr *csv.ParseError
+	if len(errs) != 1 || !errors.As(errs[0], &parseErr) || parseErr.Line != 3 {
+		t.Errorf("errors = %v, want one csv.ParseError on line 3", errs)
+	}
+}
+
+func TestFromCSVSkipAndReport(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	records, errs := readCSV(t, malformedCSV, true, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+
+	if !slices.EqualFunc(records, [][]string{{"1"}, {"3"}}, slices.Equal) {
+		t.Errorf("records = %q, want the rows around the bad one", records)
+	}
+	var parseErr *csv.ParseError
+	if len(errs) != 1 || !errors.As(errs[0], &parseErr) {
+		t.Errorf("errors = %v, want one csv.ParseError", errs)
+	}
+}
+
+func TestCSVHeaderRoundTrip(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	input := "id,value\n1,\"a,b\"\n2,c\n"
+	records, errc := pipeline.FromCSV(ctx, strings.NewReader(input), true)
+	var buf bytes.Buffer
+	done, sinkErrc := pipeline.SinkCSV(ctx, records, &buf, []string{"id", "value"})
+	if errs := pipelinetest.CollectWithTimeout(t, pipeline.Merge(ctx, errc, sinkErrc), time.Second); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if buf.String() != input {
+		t.Errorf("wrote %q, want %q", buf.String(), input)
+	}
+}
+
+func TestSinkCSVFlushesOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan []string)
+	var buf bytes.Buffer
+	done, errc := pipeline.SinkCSV(ctx, in, &buf, []string{"n"})
+	in <- []string{"1"}
+	cancel()
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if buf.String() != "n\n1\n" {
+		t.Errorf("wrote %q, want the header and the record", buf.String())
+	}
+}
+
+func TestSinkCSVWriterErrorReportedOnce(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records := make([][]string, 200)
+	for i := range records {
+		records[i] = []string{strings.Repeat("x", 100)}
+	}
+	done, errc := pipeline.SinkCSV(ctx, pipeline.Source(ctx, records), &failingWriter{limit: 5000}, nil)
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if len(errs) != 1 || !errors.Is(errs[0], errDiskFull) {
+		t.Errorf("errors = %v, want exactly one wrapping %v", errs, errDiskFull)
+	}
+}
@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDeadlineExceeded is wrapped by the DeadlineError a stage reports for an
+// item that was past its WithItemDeadline deadline when the stage got it.
+var ErrDeadlineExceeded = errors.New("item deadline exceeded")
+
+// DeadlineError reports an item that a stage skipped because it was too old.
+// Seq is the item's offset, Stage the stage that refused it and Age how long
+// before that the source emitted it.
+type DeadlineError struct {
+	Seq   int64
+	Stage string
+	Age   time.Duration
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("item %d at stage %s after %v: %v", e.Seq, e.Stage, e.Age, ErrDeadlineExceeded)
+}
+
+func (e *DeadlineError) Unwrap() error {
+	return ErrDeadlineExceeded
+}
+
+// WithItemDeadline makes SourceItems give every item a deadline of d after it
+// emitted it. A Stage, StagePool or Sink, including their envelope variants,
+// that receives an item past its deadline skips it and reports a
+// DeadlineError, which goes to the dead-letter channel if the stage has one
+// and to its error channel otherwise, whatever its error policy. The late
+// item does not stop the stage. Both the deadline and the checks go by the
+// clock set with WithClock.
+func WithItemDeadline(d time.Duration) Option {
+	return func(o *options) {
+		o.itemDeadline = d
+	}
+}
+
+// deadliner is implemented by envelopes, which may carry a deadline set with
+// budget, a budget of 0 meaning none.
+type deadliner interface {
+	deadline() (seq int64, at time.Time, budget time.Duration)
+}
+
+// checkDeadline returns the DeadlineError for item if it is an envelope past
+// its deadline.
+func checkDeadline[T any](o options, stage string, item T) error {
+	// Only envelopes are boxed to look at their deadline; a nil pointer is
+	// enough to tell whether T is one.
+	if _, ok := any((*T)(nil)).(deadliner); !ok {
+		return nil
+	}
+	seq, at, budget := any(item).(deadliner).deadline()
+	if budget == 0 {
+		return nil
+	}
+	now := o.clock.Now()
+	if !now.After(at) {
+		return nil
+	}
+	return &DeadlineError{Seq: seq, Stage: stage, Age: budget + now.Sub(at)}
+}
@@ -0,0 +1,114 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// stampedItems returns n envelopes that all got a deadline of budget at the
+// current time of clock.
+func stampedItems(t *testing.T, n int, budget time.Duration, clock *pipelinetest.Clock) []pipeline.Item[int] {
+	t.Helper()
+	ctx := context.Background()
+	return pipelinetest.CollectWithTimeout(t, pipeline.SourceItems(ctx, pipelinetest.Items(n), nil,
+		pipeline.WithItemDeadline(budget), pipeline.WithClock(clock)), time.Second)
+}
+
+func TestItemDeadlineDropsLateItems(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	var saved pipeline.MemorySink[int]
+
+	items := stampedItems(t, 5, time.Second, clock)
+	// Once 1 is saved, transform takes 2s for item 2, so it reaches save too
+	// late and the items behind it reach transform too late.
+	savedOne := make(chan struct{})
+	out, stageErrc := pipeline.StageItems(ctx, pipeline.Source(ctx, items), func(_ context.Context, n int) (int, error) {
+		if n == 2 {
+			<-savedOne
+			clock.Advance(2 * time.Second)
+		}
+		return n, nil
+	}, pipeline.WithName("transform"), pipeline.WithClock(clock))
+	done, sinkErrc := pipeline.SinkItems(ctx, out, func(ctx context.Context, n int) error {
+		if n == 1 {
+			defer close(savedOne)
+		}
+		return saved.Write(ctx, n)
+	}, pipeline.WithName("save"), pipeline.WithClock(clock))
+
+	errs := pipelinetest.CollectWithTimeout(t, pipeline.Merge(ctx, stageErrc, sinkErrc), time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+	if !slices.Equal(saved.Items(), []int{0, 1}) {
+		t.Errorf("saved %v, want only the items in time", saved.Items())
+	}
+
+	dropped := make(map[int64]string)
+	for _, err := range errs {
+		var deadlineErr *pipeline.DeadlineError
+		if !errors.As(err, &deadlineErr) || !errors.Is(err, pipeline.ErrDeadlineExceeded) {
+			t.Fatalf("error %v, want a DeadlineError", err)
+		}
+		if deadlineErr.Age != 2*time.Second {
+			t.Errorf("item %d dropped at age %v, want 2s", deadlineErr.Seq, deadlineErr.Age)
+		}
+		dropped[deadlineErr.Seq] = deadlineErr.Stage
+	}
+	if want := map[int64]string{2: "save", 3: "transform", 4: "transform"}; !maps.Equal(dropped, want) {
+		t.Errorf("dropped %v, want %v", dropped, want)
+	}
+}
+
+func TestItemDeadlineToDeadLetters(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+
+	items := stampedItems(t, 3, time.Second, clock)
+	clock.Advance(time.Second + time.Millisecond)
+	out, deadLetters, errc := pipeline.StageWithDeadLetters(ctx, pipeline.Source(ctx, items), func(_ context.Context, it pipeline.Item[int]) (int, error) {
+		t.Errorf("processed item %d past its deadline", it.Value)
+		return it.Value, nil
+	}, pipeline.WithClock(clock))
+
+	letters := pipelinetest.CollectWithTimeout(t, deadLetters, time.Second)
+	pipelinetest.AssertClosed(t, out, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v, want the late items only as dead letters", errs)
+	}
+	if len(letters) != 3 {
+		t.Fatalf("got %d dead letters, want all 3 items", len(letters))
+	}
+	for _, l := range letters {
+		if !errors.Is(l.Err, pipeline.ErrDeadlineExceeded) {
+			t.Errorf("dead letter %v, want ErrDeadlineExceeded", l)
+		}
+	}
+}
+
+func TestItemDeadlineOffByDefault(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+
+	src := pipeline.SourceItems(ctx, pipelinetest.Items(3), nil)
+	done, errc := pipeline.SinkItems(ctx, src, func(context.Context, int) error {
+		clock.Advance(time.Hour)
+		return nil
+	}, pipeline.WithClock(clock))
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v, want none without a deadline", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+}
@@ -0,0 +1,117 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// Debounce emits the latest item from in once no new item has arrived for the
+// quiet period. A pending item is flushed when in is closed. The quiet period
+// is measured on the clock set with WithClock.
+func Debounce[T any](ctx context.Context, in <-chan T, quiet time.Duration, opts ...Option) <-chan T {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	go func() {
+		defer close(out)
+
+		var pending T
+		var hasPending bool
+		timer := o.clock.NewTimer(quiet)
+		timer.Stop()
+
+		emit := func() bool {
+			timer.Stop()
+			if !hasPending {
+				return true
+			}
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- pending:
+				var zero T
+				pending, hasPending = zero, false
+				return true
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-in:
+				if !ok {
+					emit()
+					return
+				}
+				pending, hasPending = item, true
+				timer.Reset(quiet)
+			case <-timer.C():
+				if !emit() {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// ThrottleLatest emits at most one item per interval. An item arriving after a
+// quiet interval is emitted right away; items arriving within the interval
+// replace each other and only the latest is emitted once it ends. A pending
+// item is flushed when in is closed. Like Debounce it honours WithClock.
+func ThrottleLatest[T any](ctx context.Context, in <-chan T, every time.Duration, opts ...Option) <-chan T {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	go func() {
+		defer close(out)
+
+		var pending T
+		var hasPending, throttling bool
+		timer := o.clock.NewTimer(every)
+		timer.Stop()
+
+		emit := func(item T) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- item:
+				throttling = true
+				timer.Reset(every)
+				return true
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-in:
+				if !ok {
+					if hasPending {
+						emit(pending)
+					}
+					return
+				}
+				if throttling {
+					pending, hasPending = item, true
+					continue
+				}
+				if !emit(item) {
+					return
+				}
+			case <-timer.C():
+				throttling = false
+				if !hasPending {
+					continue
+				}
+				item := pending
+				var zero T
+				pending, hasPending = zero, false
+				if !emit(item) {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,172 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+const quiet = time.Second
+
+// advanceUntilItem advances clock by step until out delivers an item. It
+// covers the moment between a stage receiving an item and re-arming its
+// timer, which the test cannot observe.
+func advanceUntilItem[T any](t *testing.T, clock *pipelinetest.Clock, out <-chan T, step time.Duration) T {
+	t.Helper()
+	deadline := time.Now().Add(pipelinetest.DefaultTimeout)
+	for time.Now().Before(deadline) {
+		clock.Advance(step)
+		select {
+		case item := <-out:
+			return item
+		case <-time.After(time.Millisecond):
+		}
+	}
+	t.Fatal("no item emitted")
+	var zero T
+	return zero
+}
+
+func assertNoItem[T any](t *testing.T, out <-chan T) {
+	t.Helper()
+	select {
+	case item, ok := <-out:
+		if ok {
+			t.Fatalf("unexpected item %v", item)
+		}
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestDebounceBurstEmitsLatest(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	in := make(chan int)
+	out := pipeline.Debounce(ctx, in, quiet, pipeline.WithClock(clock))
+
+	for i := 1; i <= 5; i++ {
+		in <- i
+		clock.Advance(quiet / 2)
+	}
+	assertNoItem(t, out)
+	if got := advanceUntilItem(t, clock, out, quiet/4); got != 5 {
+		t.Errorf("emitted %d, want the latest item 5", got)
+	}
+	close(in)
+	if rest := pipelinetest.CollectWithTimeout(t, out, time.Second); len(rest) != 0 {
+		t.Errorf("emitted %v after the burst was flushed", rest)
+	}
+}
+
+func TestDebounceSteadyInput(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	in := make(chan int)
+	out := pipeline.Debounce(ctx, in, quiet, pipeline.WithClock(clock))
+
+	for i := 1; i <= 3; i++ {
+		in <- i
+		clock.BlockUntil(t, 1)
+		clock.Advance(quiet)
+		if got := <-out; got != i {
+			t.Fatalf("emitted %d, want %d", got, i)
+		}
+	}
+	close(in)
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+}
+
+func TestDebounceFlushesOnClose(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	out := pipeline.Debounce(ctx, pipeline.Source(ctx, []int{1, 2, 3}), quiet, pipeline.WithClock(clock))
+
+	// The clock never moves: only closing the input emits.
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{3}) {
+		t.Errorf("emitted %v, want [3]", got)
+	}
+}
+
+func TestDebounceCancelExitsPromptly(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	out := pipeline.Debounce(ctx, in, time.Hour)
+	in <- 1
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+}
+
+func TestThrottleLatestBurst(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	in := make(chan int)
+	out := pipeline.ThrottleLatest(ctx, in, quiet, pipeline.WithClock(clock))
+
+	// The first item goes straight through, the rest of the burst is
+	// collapsed into its latest item at the end of the interval.
+	in <- 1
+	if got := <-out; got != 1 {
+		t.Fatalf("emitted %d first, want 1", got)
+	}
+	clock.BlockUntil(t, 1)
+	for i := 2; i <= 4; i++ {
+		in <- i
+	}
+	assertNoItem(t, out)
+	clock.Advance(quiet)
+	if got := <-out; got != 4 {
+		t.Errorf("emitted %d at the end of the interval, want 4", got)
+	}
+
+	close(in)
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+}
+
+func TestThrottleLatestSteadyInput(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	in := make(chan int)
+	out := pipeline.ThrottleLatest(ctx, in, quiet, pipeline.WithClock(clock))
+
+	// One item per interval: the first goes straight through, each later one
+	// waits for the interval its predecessor started to end.
+	in <- 0
+	<-out
+	for i := 1; i <= 3; i++ {
+		clock.BlockUntil(t, 1)
+		in <- i
+		clock.Advance(quiet)
+		if got := <-out; got != i {
+			t.Fatalf("emitted %d, want %d", got, i)
+		}
+	}
+	close(in)
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+}
+
+func TestThrottleLatestFlushesOnClose(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	out := pipeline.ThrottleLatest(ctx, pipeline.Source(ctx, []int{1, 2, 3}), quiet, pipeline.WithClock(clock))
+
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{1, 3}) {
+		t.Errorf("emitted %v, want [1 3]", got)
+	}
+}
@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"container/list"
+	"context"
+
+	"channelspractice/chanutil"
+)
+
+// Dedup forwards only the first occurrence of every item from in. It remembers
+// every item it has seen, so use DedupLRU on long streams.
+func Dedup[T comparable](ctx context.Context, in <-chan T, opts ...Option) <-chan T {
+	return dedup(ctx, in, func(item T) T { return item }, 0, newOptions(opts))
+}
+
+// DedupLRU is Dedup remembering at most maxKeys items, forgetting the least
+// recently seen first. A duplicate arriving after its original was forgotten
+// is forwarded again.
+func DedupLRU[T comparable](ctx context.Context, in <-chan T, maxKeys int, opts ...Option) <-chan T {
+	return dedup(ctx, in, func(item T) T { return item }, max(maxKeys, 1), newOptions(opts))
+}
+
+// DedupBy is Dedup for items that are not comparable themselves: two items are
+// duplicates when keyFn returns the same key for them.
+func DedupBy[T any, K comparable](ctx context.Context, in <-chan T, keyFn func(T) K, opts ...Option) <-chan T {
+	return dedup(ctx, in, keyFn, 0, newOptions(opts))
+}
+
+// dedup implements the Dedup stages. A maxKeys of 0 means no limit.
+func dedup[T any, K comparable](ctx context.Context, in <-chan T, keyFn func(T) K, maxKeys int, o options) <-chan T {
+	out := make(chan T, o.buffer)
+	go func() {
+		defer close(out)
+		seen := make(map[K]*list.Element)
+		lru := list.New()
+		for item := range OrDone(ctx, in) {
+			key := keyFn(item)
+			if e, ok := seen[key]; ok {
+				lru.MoveToFront(e)
+				continue
+			}
+			seen[key] = lru.PushFront(key)
+			if maxKeys > 0 && lru.Len() > maxKeys {
+				delete(seen, lru.Remove(lru.Back()).(K))
+			}
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,69 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestDedup(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := pipeline.Dedup(ctx, pipeline.Source(ctx, []int{3, 1, 3, 2, 1, 3, 4}))
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{3, 1, 2, 4}) {
+		t.Errorf("got %v, want [3 1 2 4]", got)
+	}
+}
+
+func TestDedupLRUEviction(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// With two keys remembered, 1 is forgotten once 2 and 3 arrive, so its
+	// late duplicate passes. The second 3 is still remembered.
+	out := pipeline.DedupLRU(ctx, pipeline.Source(ctx, []int{1, 2, 3, 1, 3}), 2)
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{1, 2, 3, 1}) {
+		t.Errorf("got %v, want [1 2 3 1]", got)
+	}
+}
+
+func TestDedupLRUSeenKeepsKeyAlive(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Seeing 1 again makes 2 the least recently seen, so 3 evicts 2.
+	out := pipeline.DedupLRU(ctx, pipeline.Source(ctx, []int{1, 2, 1, 3, 1, 2}), 2)
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{1, 2, 3, 2}) {
+		t.Errorf("got %v, want [1 2 3 2]", got)
+	}
+}
+
+func TestDedupBy(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type user struct {
+		ID   int
+		Tags []string
+	}
+	users := []user{{1, []string{"a"}}, {2, nil}, {1, []string{"b"}}}
+	out := pipeline.DedupBy(ctx, pipeline.Source(ctx, users), func(u user) int { return u.ID })
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if len(got) != 2 || got[0].Tags[0] != "a" || got[1].ID != 2 {
+		t.Errorf("got %v, want the first user 1 and user 2", got)
+	}
+}
+
+func TestDedupCancelMidStream(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := pipeline.Dedup(ctx, pipeline.Source(ctx, pipelinetest.Items(1000)))
+	<-out
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+}
@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// WithDrainOnCancel makes a DelayQueue deliver the items still waiting when
+// its context is cancelled right away, in deadline order, instead of dropping
+// them. The consumer must then read out until it is closed.
+func WithDrainOnCancel() Option {
+	return func(o *options) {
+		o.drainOnCancel = true
+	}
+}
+
+// DelayQueue delivers every item passed to enqueue on out once its delay has
+// passed, measured on the clock set with WithClock. Items come out in deadline
+// order, those with the same deadline in the order they were enqueued, and an
+// item with no delay is delivered as soon as out is read. enqueue is safe for
+// concurrent use and never blocks.
+//
+// out is closed once ctx is cancelled. Items still waiting are dropped unless
+// WithDrainOnCancel is given, and items enqueued after that are dropped in
+// either case.
+func DelayQueue[T any](ctx context.Context, opts ...Option) (enqueue func(item T, after time.Duration), out <-chan T) {
+	o := newOptions(opts)
+	q := &delayQueue[T]{wake: make(chan struct{}, 1)}
+	ch := make(chan T, o.buffer)
+
+	enqueue = func(item T, after time.Duration) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if q.closed {
+			return
+		}
+		heap.Push(&q.heap, delayed[T]{item: item, at: o.clock.Now().Add(after), seq: q.seq})
+		q.seq++
+		// The new item may be due before the one the timer is set for.
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		defer close(ch)
+		timer := o.clock.NewTimer(0)
+		timer.Stop()
+		defer timer.Stop()
+
+		for {
+			item, wait, ok := q.next(o.clock.Now())
+			if ok {
+				select {
+				case <-ctx.Done():
+					q.push(item)
+					q.drain(ch, o.drainOnCancel)
+					return
+				case ch <- item.item:
+				}
+				continue
+			}
+
+			var due <-chan time.Time
+			if wait > 0 {
+				timer.Reset(wait)
+				due = timer.C()
+			}
+			select {
+			case <-ctx.Done():
+				q.drain(ch, o.drainOnCancel)
+				return
+			case <-q.wake:
+				timer.Stop()
+			case <-due:
+			}
+		}
+	}()
+	return enqueue, ch
+}
+
+// delayed is an item waiting in a DelayQueue.
+type delayed[T any] struct {
+	item T
+	at   time.Time
+	seq  uint64
+}
+
+type delayQueue[T any] struct {
+	mu     sync.Mutex
+	heap   delayHeap[T]
+	seq    uint64
+	closed bool
+	wake   chan struct{}
+}
+
+// next pops the earliest item if it is due at now. Otherwise it reports how
+// long until it is, or 0 if the queue is empty.
+func (q *delayQueue[T]) next(now time.Time) (delayed[T], time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.heap) == 0 {
+		return delayed[T]{}, 0, false
+	}
+	if wait := q.heap[0].at.Sub(now); wait > 0 {
+		return delayed[T]{}, wait, false
+	}
+	return heap.Pop(&q.heap).(delayed[T]), 0, true
+}
+
+func (q *delayQueue[T]) push(item delayed[T]) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	heap.Push(&q.heap, item)
+}
+
+// drain closes the queue to new items and, if deliver is set, sends the
+// waiting ones on out in deadline order.
+func (q *delayQueue[T]) drain(out chan<- T, deliver bool) {
+	q.mu.Lock()
+	q.closed = true
+	var items []T
+	for deliver && len(q.heap) > 0 {
+		items = append(items, heap.Pop(&q.heap).(delayed[T]).item)
+	}
+	q.heap = nil
+	q.mu.Unlock()
+	for _, item := range items {
+		out <- item
+	}
+}
+
+// delayHeap is a heap.Interface ordering items by deadline, then by arrival.
+type delayHeap[T any] []delayed[T]
+
+func (h delayHeap[T]) Len() int { return len(h) }
+
+func (h delayHeap[T]) Less(i, j int) bool {
+	if !h[i].at.Equal(h[j].at) {
+		return h[i].at.Before(h[j].at)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h delayHeap[T]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *delayHeap[T]) Push(x any)   { *h = append(*h, x.(delayed[T])) }
+
+func (h *delayHeap[T]) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
@@ -0,0 +1,125 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestDelayQueueDeadlineOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	enqueue, out := pipeline.DelayQueue[string](ctx, pipeline.WithClock(clock))
+
+	enqueue("c", 3*time.Second)
+	enqueue("a", time.Second)
+	enqueue("b1", 2*time.Second)
+	enqueue("b2", 2*time.Second)
+	enqueue("now", 0)
+
+	// The item without a delay needs no time to pass.
+	if got := <-out; got != "now" {
+		t.Fatalf("got %q first, want the zero-delay item", got)
+	}
+	var got []string
+	for range 4 {
+		got = append(got, advanceUntilItem(t, clock, out, 100*time.Millisecond))
+	}
+	if want := []string{"a", "b1", "b2", "c"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if elapsed := clock.Now().Sub(time.Time{}); elapsed < 3*time.Second {
+		t.Errorf("c delivered after %v, want 3s", elapsed)
+	}
+}
+
+func TestDelayQueueEarlierItemReschedules(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	enqueue, out := pipeline.DelayQueue[string](ctx, pipeline.WithClock(clock))
+
+	enqueue("late", 10*time.Second)
+	clock.BlockUntil(t, 1) // armed for late
+	enqueue("early", time.Second)
+
+	if got := advanceUntilItem(t, clock, out, 100*time.Millisecond); got != "early" {
+		t.Fatalf("got %q, want early first", got)
+	}
+	if elapsed := clock.Now().Sub(time.Time{}); elapsed >= 10*time.Second {
+		t.Errorf("early delivered after %v, want the timer moved up to 1s", elapsed)
+	}
+	if got := advanceUntilItem(t, clock, out, time.Second); got != "late" {
+		t.Errorf("got %q, want late", got)
+	}
+}
+
+func TestDelayQueueDropsOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := pipelinetest.NewClock(time.Time{})
+	enqueue, out := pipeline.DelayQueue[int](ctx, pipeline.WithClock(clock))
+
+	enqueue(1, time.Second)
+	enqueue(2, time.Minute)
+	cancel()
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 0 {
+		t.Errorf("got %v after the cancellation, want the waiting items dropped", got)
+	}
+	// Too late: nobody delivers this any more, and it must not block.
+	enqueue(3, 0)
+}
+
+func TestDelayQueueDrainOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := pipelinetest.NewClock(time.Time{})
+	enqueue, out := pipeline.DelayQueue[int](ctx, pipeline.WithClock(clock), pipeline.WithDrainOnCancel())
+
+	enqueue(3, 3*time.Second)
+	enqueue(1, time.Second)
+	enqueue(2, 2*time.Second)
+	cancel()
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("got %v, want the waiting items in deadline order", got)
+	}
+}
+
+func TestDelayQueueConcurrentEnqueue(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	enqueue, out := pipeline.DelayQueue[int](ctx)
+
+	var wg sync.WaitGroup
+	for g := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				enqueue(g*100+i, time.Duration(i%3)*time.Millisecond)
+			}
+		}()
+	}
+	var got []int
+	for range 1000 {
+		select {
+		case n := <-out:
+			got = append(got, n)
+		case <-time.After(time.Second):
+			t.Fatalf("got %d of 1000 items", len(got))
+		}
+	}
+	wg.Wait()
+	if slices.Sort(got); !slices.Equal(got, pipelinetest.Items(1000)) {
+		t.Error("not every enqueued item was delivered exactly once")
+	}
+}
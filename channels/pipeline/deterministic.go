@@ -0,0 +1,361 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+// ScheduleOp is what a stage did in a step of RunDeterministic.
+type ScheduleOp int
+
+const (
+	// OpSend is a source or stage putting an item on its output.
+	OpSend ScheduleOp = iota + 1
+	// OpRecv is a stage or sink taking an item from its input and running
+	// its function on it.
+	OpRecv
+	// OpFail is the function of a stage or sink failing on an item.
+	OpFail
+	// OpClose is a source that ran out of items, or a worker that found its
+	// input drained, stopping.
+	OpClose
+	// OpCancel is a worker seeing the pipeline cancelled and stopping.
+	OpCancel
+)
+
+func (op ScheduleOp) String() string {
+	switch op {
+	case OpSend:
+		return "send"
+	case OpRecv:
+		return "recv"
+	case OpFail:
+		return "fail"
+	case OpClose:
+		return "close"
+	case OpCancel:
+		return "cancel"
+	}
+	return "unknown"
+}
+
+// ScheduleEvent is a step of a run of RunDeterministic: what one worker of a
+// stage did, and with which item.
+type ScheduleEvent struct {
+	Step   int
+	Stage  string
+	Worker int
+	Op     ScheduleOp
+	// Item is the item sent, received or failed on.
+	Item any
+	// Err is the error of OpFail and the cause of OpCancel.
+	Err error
+}
+
+func (e ScheduleEvent) String() string {
+	s := fmt.Sprintf("%d %s/%d %s", e.Step, e.Stage, e.Worker, e.Op)
+	switch e.Op {
+	case OpSend, OpRecv:
+		s += fmt.Sprintf(" %v", e.Item)
+	case OpFail:
+		s += fmt.Sprintf(" %v: %v", e.Item, e.Err)
+	case OpCancel:
+		s += fmt.Sprintf(": %v", e.Err)
+	}
+	return s
+}
+
+// Schedule is the steps of a run of RunDeterministic in the order they were
+// taken.
+type Schedule []ScheduleEvent
+
+// String returns the steps one per line.
+func (s Schedule) String() string {
+	var b strings.Builder
+	for _, e := range s {
+		b.WriteString(e.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// RunDeterministic runs p like Pipeline.Run, but with an interleaving of its
+// stages that only depends on seed, and returns the steps it took with the
+// error Run would return. Rather than running every worker on a goroutine
+// of its own it runs them one step at a time on the calling goroutine: in
+// each step it picks one of the workers that can go on, as told by a
+// pseudo-random source seeded with seed, and lets it send an item, receive
+// one and run the stage function on it, or stop. Once the pipeline fails, or
+// ctx is cancelled, the remaining workers see the cancellation one per step
+// in an order picked the same way. The same seed therefore gives the same
+// schedule on every run, which makes a race between stages or the order in
+// which they see a cancellation reproducible, e.g. in a test or a lesson.
+//
+// Channels hold as many items as their buffer, and unbuffered ones one.
+// Stage functions run one at a time on the calling goroutine, so one that
+// blocks, waiting for another stage or for its ctx, blocks the whole run. The
+// ctx of a worker is cancelled in the step it sees the cancellation.
+// Pipelines started with From or using Batched are not supported:
+// RunDeterministic returns ErrNotDeterministic for them.
+func RunDeterministic(ctx context.Context, seed int64, p *Pipeline) (Schedule, error) {
+	for _, s := range p.sched {
+		if s.unsupported != "" {
+			return nil, fmt.Errorf("%w: %s", ErrNotDeterministic, s.unsupported)
+		}
+	}
+	r := newScheduler(ctx, seed, p)
+	defer r.stop(context.Canceled)
+	return r.run(ctx)
+}
+
+// schedStage describes a stage of a flow for RunDeterministic.
+type schedStage struct {
+	name    string
+	kind    nodeKind
+	workers int
+	opts    options
+	// items are the items of a source.
+	items []any
+	// fn is the function of a stage, or of a sink returning nil items.
+	fn func(context.Context, any) (any, error)
+	// close closes the writer of a SinkTo, if not nil.
+	close func(context.Context) error
+	// unsupported says why RunDeterministic cannot run the stage, if it
+	// cannot.
+	unsupported string
+}
+
+// thenSched returns the stages of f followed by s.
+func thenSched[T any](f *Flow[T], s schedStage) []schedStage {
+	return append(append([]schedStage(nil), f.sched...), s)
+}
+
+// schedQueue is a channel between two stages of RunDeterministic.
+type schedQueue struct {
+	items  []any
+	cap    int
+	closed bool
+}
+
+func (q *schedQueue) room() bool { return len(q.items) < q.cap }
+
+// schedWorker is a source, or a worker of a stage or sink, that
+// RunDeterministic steps.
+type schedWorker struct {
+	stage  *schedRun
+	id     int
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	// held is the result a stage worker waits to send, if holding.
+	held    any
+	holding bool
+	stopped bool
+}
+
+// schedRun is a stage in a run of RunDeterministic.
+type schedRun struct {
+	schedStage
+	label   string
+	in, out *schedQueue
+	workers []*schedWorker
+	// next is the index of the next item of a source.
+	next    int
+	running int
+	errors  int64
+}
+
+type scheduler struct {
+	p       *Pipeline
+	rng     *rand.Rand
+	workers []*schedWorker
+	steps   Schedule
+	// cause is set once the run is cancelled, and err to what it returns.
+	cause error
+	err   error
+}
+
+func newScheduler(ctx context.Context, seed int64, p *Pipeline) *scheduler {
+	r := &scheduler{p: p, rng: rand.New(rand.NewPCG(uint64(seed), 0))}
+	labels := newStageErrors(nil)
+	var in *schedQueue
+	for _, s := range p.sched {
+		st := &schedRun{schedStage: s, label: labels.label(s.name), in: in}
+		if s.kind != sinkNode {
+			st.out = &schedQueue{cap: max(s.opts.buffer, 1)}
+			in = st.out
+		}
+		for id := range max(s.workers, 1) {
+			wctx, cancel := context.WithCancelCause(ctx)
+			w := &schedWorker{stage: st, id: id, ctx: wctx, cancel: cancel}
+			st.workers = append(st.workers, w)
+			r.workers = append(r.workers, w)
+		}
+		st.running = len(st.workers)
+	}
+	return r
+}
+
+func (r *scheduler) run(ctx context.Context) (Schedule, error) {
+	for {
+		if r.cause == nil && ctx.Err() != nil {
+			r.abort(context.Cause(ctx), stopped(ctx))
+		}
+		if r.cause != nil {
+			live := r.live()
+			if len(live) == 0 {
+				return r.steps, r.err
+			}
+			w := live[r.rng.IntN(len(live))]
+			r.record(w, OpCancel, nil, r.cause)
+			w.cancel(r.cause)
+			r.exit(w)
+			continue
+		}
+		ready := r.ready()
+		if len(ready) == 0 {
+			// Once the sinks are done the stages still blocked on an output
+			// no one reads are cancelled, as Run does.
+			r.abort(context.Canceled, nil)
+			continue
+		}
+		r.step(ready[r.rng.IntN(len(ready))])
+	}
+}
+
+// stop cancels every worker, so that no context outlives the run.
+func (r *scheduler) stop(cause error) {
+	for _, w := range r.workers {
+		w.cancel(cause)
+	}
+}
+
+func (r *scheduler) abort(cause, err error) {
+	r.cause, r.err = cause, err
+}
+
+func (r *scheduler) live() []*schedWorker {
+	var live []*schedWorker
+	for _, w := range r.workers {
+		if !w.stopped {
+			live = append(live, w)
+		}
+	}
+	return live
+}
+
+// ready returns the workers that can take a step.
+func (r *scheduler) ready() []*schedWorker {
+	var ready []*schedWorker
+	for _, w := range r.workers {
+		if !w.stopped && r.canStep(w) {
+			ready = append(ready, w)
+		}
+	}
+	return ready
+}
+
+func (r *scheduler) canStep(w *schedWorker) bool {
+	st := w.stage
+	switch {
+	case st.kind == sourceNode:
+		return st.next == len(st.items) || st.out.room()
+	case w.holding:
+		return st.out.room()
+	default:
+		return len(st.in.items) > 0 || st.in.closed
+	}
+}
+
+// step lets w take its next step.
+func (r *scheduler) step(w *schedWorker) {
+	st := w.stage
+	switch {
+	case st.kind == sourceNode:
+		if st.next == len(st.items) {
+			r.record(w, OpClose, nil, nil)
+			r.exit(w)
+			return
+		}
+		item := st.items[st.next]
+		st.next++
+		st.out.items = append(st.out.items, item)
+		r.record(w, OpSend, item, nil)
+	case w.holding:
+		st.out.items = append(st.out.items, w.held)
+		r.record(w, OpSend, w.held, nil)
+		w.held, w.holding = nil, false
+	case len(st.in.items) == 0:
+		r.record(w, OpClose, nil, nil)
+		r.exit(w)
+	default:
+		item := st.in.items[0]
+		st.in.items = st.in.items[1:]
+		r.record(w, OpRecv, item, nil)
+		result, err := call(w.ctx, st.opts, st.label, st.fn, item)
+		if err != nil {
+			r.fail(w, item, err)
+			return
+		}
+		if st.kind == stageNode {
+			w.held, w.holding = result, true
+		}
+	}
+}
+
+// fail handles the failure of w on item as its stage and the pipeline would.
+func (r *scheduler) fail(w *schedWorker, item any, err error) {
+	st := w.stage
+	r.record(w, OpFail, item, err)
+	failure := err
+	if !isPanic(err) {
+		failure = stageFailure(st.label, item, err)
+	}
+	stops := isPanic(err) || st.opts.errorPolicy == FailFast
+	if !stops {
+		st.errors++
+		if limitErr := st.opts.checkErrorCount(st.errors, err); limitErr != nil {
+			failure = &StageError{Stage: st.label, Err: limitErr}
+			stops = true
+		}
+	}
+	if stops {
+		for _, other := range st.workers {
+			if !other.stopped {
+				other.held, other.holding = nil, false
+				r.exit(other)
+			}
+		}
+	}
+	if err := r.p.filter(failure); err != nil {
+		r.abort(err, failed(err))
+	}
+}
+
+// exit stops w, closing the output of its stage once its last worker
+// stopped, and the writer of a SinkTo.
+func (r *scheduler) exit(w *schedWorker) {
+	w.stopped = true
+	st := w.stage
+	if st.running--; st.running > 0 {
+		return
+	}
+	if st.out != nil {
+		st.out.closed = true
+	}
+	if st.close != nil {
+		if err := st.close(context.WithoutCancel(w.ctx)); err != nil && r.cause == nil {
+			err = &StageError{Stage: st.label, Err: fmt.Errorf("close: %w", err)}
+			if err := r.p.filter(err); err != nil {
+				r.abort(err, failed(err))
+			}
+		}
+	}
+}
+
+func (r *scheduler) record(w *schedWorker, op ScheduleOp, item any, err error) {
+	r.steps = append(r.steps, ScheduleEvent{
+		Step: len(r.steps) + 1, Stage: w.stage.label, Worker: w.id, Op: op, Item: item, Err: err,
+	})
+}
@@ -0,0 +1,167 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// poolPipeline doubles 0 to 19 with three workers, failing on failOn unless
+// it is negative, and saves them into sink.
+func poolPipeline(failOn int, sink *pipeline.MemorySink[int]) *pipeline.Pipeline {
+	transform := func(_ context.Context, n int) (int, error) {
+		if n == failOn {
+			return 0, errBadRecord
+		}
+		return n * 2, nil
+	}
+	return pipeline.New(pipelinetest.Items(20), pipeline.WithName("generator")).
+		ThenPool(3, transform, pipeline.WithName("transform"), pipeline.WithBuffer(2)).
+		SinkTo(sink, pipeline.WithName("save"))
+}
+
+// cancellations returns the stages in the order they saw the cancellation.
+func cancellations(s pipeline.Schedule) []string {
+	var stages []string
+	for _, e := range s {
+		if e.Op == pipeline.OpCancel {
+			stages = append(stages, e.Stage)
+		}
+	}
+	return stages
+}
+
+func TestRunDeterministicReplays(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	for _, failOn := range []int{-1, 7} {
+		first, firstErr := pipeline.RunDeterministic(context.Background(), 42, poolPipeline(failOn, &pipeline.MemorySink[int]{}))
+		for range 5 {
+			again, err := pipeline.RunDeterministic(context.Background(), 42, poolPipeline(failOn, &pipeline.MemorySink[int]{}))
+			if again.String() != first.String() {
+				t.Fatalf("failOn %d: schedules differ with the same seed:\n%s\nand\n%s", failOn, first, again)
+			}
+			if (err == nil) != (firstErr == nil) || err != nil && err.Error() != firstErr.Error() {
+				t.Fatalf("failOn %d: errors differ with the same seed: %v and %v", failOn, firstErr, err)
+			}
+		}
+	}
+}
+
+func TestRunDeterministicSeedsDiffer(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	schedules := make(map[string]bool)
+	cancelOrders := make(map[string]bool)
+	for seed := range int64(20) {
+		s, _ := pipeline.RunDeterministic(context.Background(), seed, poolPipeline(7, &pipeline.MemorySink[int]{}))
+		schedules[s.String()] = true
+		cancelOrders[strings.Join(cancellations(s), " ")] = true
+	}
+	if len(schedules) < 2 {
+		t.Errorf("20 seeds gave %d schedules, want different ones", len(schedules))
+	}
+	if len(cancelOrders) < 2 {
+		t.Errorf("every seed cancelled the workers in the order %v", cancelOrders)
+	}
+}
+
+func TestRunDeterministicSavesEveryItem(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	sink := &pipeline.MemorySink[int]{}
+	s, err := pipeline.RunDeterministic(context.Background(), 1, poolPipeline(-1, sink))
+	if err != nil {
+		t.Fatalf("RunDeterministic = %v, want nil", err)
+	}
+	got := slices.Sorted(slices.Values(sink.Items()))
+	want := make([]int, 20)
+	for i := range want {
+		want[i] = i * 2
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("saved %v, want %v", got, want)
+	}
+	if !sink.Closed() {
+		t.Error("sink writer not closed")
+	}
+	if cancelled := cancellations(s); len(cancelled) > 0 {
+		t.Errorf("%v saw a cancellation in a run without errors", cancelled)
+	}
+}
+
+func TestRunDeterministicFailure(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	s, err := pipeline.RunDeterministic(context.Background(), 3, poolPipeline(7, &pipeline.MemorySink[int]{}))
+	var stageErr *pipeline.StageError
+	if !errors.Is(err, errBadRecord) || !errors.As(err, &stageErr) || stageErr.Stage != "transform" || stageErr.Item != 7 {
+		t.Fatalf("RunDeterministic = %v, want the failure of transform on 7", err)
+	}
+	fail := slices.IndexFunc(s, func(e pipeline.ScheduleEvent) bool { return e.Op == pipeline.OpFail })
+	if fail < 0 {
+		t.Fatalf("no failure in:\n%s", s)
+	}
+	// Every step after the failure is a worker seeing the cancellation.
+	for _, e := range s[fail+1:] {
+		if e.Op != pipeline.OpCancel || !errors.Is(e.Err, errBadRecord) {
+			t.Errorf("step %v after the failure, want a cancellation", e)
+		}
+	}
+}
+
+func TestRunDeterministicSkipAndReport(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var reported []error
+	sink := &pipeline.MemorySink[int]{}
+	p := pipeline.New(pipelinetest.Items(8)).
+		Then(failBySeverity(-1), pipeline.WithErrorPolicy(pipeline.SkipAndReport), pipeline.WithName("check")).
+		SinkTo(sink).
+		OnError(func(err error) error {
+			reported = append(reported, err)
+			return nil
+		})
+	if _, err := pipeline.RunDeterministic(context.Background(), 1, p); err != nil {
+		t.Fatalf("RunDeterministic = %v, want the errors reported", err)
+	}
+	if len(reported) != 2 || !errors.Is(reported[0], errInvalid) || !errors.Is(reported[1], errIO) {
+		t.Errorf("reported %v, want the failures of 2 and 4", reported)
+	}
+	if got := sink.Items(); !slices.Equal(got, []int{0, 1, 3, 5, 6, 7}) {
+		t.Errorf("saved %v, want every item that did not fail", got)
+	}
+}
+
+func TestRunDeterministicCancellationFromStage(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	run := func() (pipeline.Schedule, error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stop := func(_ context.Context, n int) (int, error) {
+			if n == 5 {
+				cancel()
+			}
+			return n, nil
+		}
+		p := pipeline.New(pipelinetest.Items(20)).Then(stop).Sink(saveAll)
+		return pipeline.RunDeterministic(ctx, 9, p)
+	}
+	first, err := run()
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunDeterministic = %v, want context.Canceled", err)
+	}
+	again, _ := run()
+	if !slices.Equal(cancellations(first), cancellations(again)) || len(cancellations(first)) == 0 {
+		t.Errorf("stages saw the cancellation in the order %v, then %v", cancellations(first), cancellations(again))
+	}
+}
+
+func TestRunDeterministicNotSupported(t *testing.T) {
+	p := pipeline.From(func(ctx context.Context) (<-chan int, <-chan error) {
+		return pipeline.Source(ctx, pipelinetest.Items(3)), nil
+	}).Sink(saveAll)
+	if _, err := pipeline.RunDeterministic(context.Background(), 1, p); !errors.Is(err, pipeline.ErrNotDeterministic) {
+		t.Errorf("RunDeterministic = %v, want ErrNotDeterministic", err)
+	}
+}
@@ -0,0 +1,258 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// defaultSegmentSize is the size of DiskBuffer segments unless set with
+// WithSegmentSize.
+const defaultSegmentSize = 4 << 20
+
+// WithSegmentSize makes a DiskBuffer start a new segment file once the
+// current one holds n bytes.
+func WithSegmentSize(n int64) Option {
+	return func(o *options) {
+		o.segmentSize = n
+	}
+}
+
+// DiskBuffer reads from in as fast as in delivers and hands the items on in
+// order, however far behind its consumer falls. It keeps up to memLimit items
+// in memory; once those are taken, further items are encoded with codec and
+// appended as frames, see WriteFrame, to segment files in a directory it
+// creates under dir, and read back as the consumer catches up. An item
+// encoding to more than WithMaxFrameSize allows is a write error. A segment
+// is deleted as soon as its last item is read back, and the directory once
+// the buffer stops.
+//
+// Once in is closed every item is handed on, exactly once, before the output
+// is closed. If ctx is cancelled, or a segment cannot be written or read, the
+// items still held are dropped, and their number is reported on the error
+// channel as a *StageError wrapping ErrBufferDropped, after the I/O error if
+// there was one. Segments are not synced, so a crash loses what they hold;
+// the directory is left behind in dir and is not read by later buffers.
+func DiskBuffer[T any](ctx context.Context, in <-chan T, dir string, codec Codec[T], memLimit int, opts ...Option) (<-chan T, <-chan error) {
+	o := newOptions(opts)
+	name := o.stageName("diskbuffer")
+	memLimit = max(memLimit, 1)
+	size := o.segmentSize
+	if size <= 0 {
+		size = defaultSegmentSize
+	}
+	out := make(chan T)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(out)
+		disk := &spill[T]{parent: dir, codec: codec, size: size, maxFrame: o.frameLimit()}
+		defer disk.remove()
+		var mem []T
+		drop := func(err error) {
+			dropped := fmt.Errorf("%d items: %w", len(mem)+disk.held, ErrBufferDropped)
+			if err != nil {
+				dropped = fmt.Errorf("%w, %w", err, dropped)
+			}
+			errc <- &StageError{Stage: name, Err: dropped}
+		}
+		// Items only go to memory while none wait on disk, which keeps them
+		// in order, and whenever some do memory is topped up from disk, so
+		// memory holds items whenever the disk does.
+		for in != nil || len(mem) > 0 {
+			send := out
+			var next T
+			if len(mem) == 0 {
+				send = nil
+			} else {
+				next = mem[0]
+			}
+			select {
+			case <-ctx.Done():
+				if len(mem)+disk.held > 0 {
+					drop(nil)
+				}
+				return
+			case item, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				if disk.held == 0 && len(mem) < memLimit {
+					mem = append(mem, item)
+					continue
+				}
+				if err := disk.push(item); err != nil {
+					// The item was not stored either.
+					mem = append(mem, item)
+					drop(err)
+					return
+				}
+			case send <- next:
+				var zero T
+				mem[0] = zero
+				mem = mem[1:]
+				for disk.held > 0 && len(mem) < memLimit {
+					item, err := disk.pop()
+					if err != nil {
+						drop(err)
+						return
+					}
+					mem = append(mem, item)
+					if err := disk.release(); err != nil {
+						drop(err)
+						return
+					}
+				}
+			}
+		}
+	}()
+	return out, errc
+}
+
+// spill is the on-disk part of a DiskBuffer: a queue of segment files of
+// frames, written at the last and read from the first. Its directory is only
+// created with the first segment.
+type spill[T any] struct {
+	parent   string
+	codec    Codec[T]
+	size     int64
+	maxFrame int
+
+	dir  string
+	segs []*segment
+	next int
+	// held is the number of records written and not yet read back.
+	held int
+}
+
+type segment struct {
+	path string
+	w    *os.File
+	bw   *bufio.Writer
+	r    *os.File
+	br   *bufio.Reader
+
+	bytes         int64
+	written, read int
+}
+
+func (s *spill[T]) push(item T) error {
+	record, err := encodeFrame(s.codec, item, s.maxFrame)
+	if err != nil {
+		return err
+	}
+	last, err := s.writable()
+	if err != nil {
+		return err
+	}
+	if _, err := last.bw.Write(record); err != nil {
+		return fmt.Errorf("write %s: %w", last.path, err)
+	}
+	last.bytes += int64(len(record))
+	last.written++
+	s.held++
+	return nil
+}
+
+// writable returns the segment to append to, starting a new one if the last
+// is full.
+func (s *spill[T]) writable() (*segment, error) {
+	if n := len(s.segs); n > 0 && s.segs[n-1].bytes < s.size {
+		return s.segs[n-1], nil
+	}
+	if s.dir == "" {
+		dir, err := os.MkdirTemp(s.parent, "diskbuffer-")
+		if err != nil {
+			return nil, err
+		}
+		s.dir = dir
+	}
+	if n := len(s.segs); n > 0 {
+		if err := s.segs[n-1].closeWriter(); err != nil {
+			return nil, err
+		}
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%08d.seg", s.next))
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s.next++
+	seg := &segment{path: path, w: f, bw: bufio.NewWriter(f)}
+	s.segs = append(s.segs, seg)
+	return seg, nil
+}
+
+func (s *spill[T]) pop() (T, error) {
+	var zero T
+	first := s.segs[0]
+	if first.bw != nil {
+		// Still being written: what is buffered must reach the file first.
+		if err := first.bw.Flush(); err != nil {
+			return zero, fmt.Errorf("write %s: %w", first.path, err)
+		}
+	}
+	if first.r == nil {
+		f, err := os.Open(first.path)
+		if err != nil {
+			return zero, err
+		}
+		first.r, first.br = f, bufio.NewReader(f)
+	}
+	data, err := ReadFrame(first.br, s.maxFrame)
+	if err != nil {
+		return zero, fmt.Errorf("read %s: %w", first.path, err)
+	}
+	item, err := s.codec.Decode(data)
+	if err != nil {
+		return zero, fmt.Errorf("decode record %d of %s: %w", first.read, first.path, err)
+	}
+	first.read++
+	s.held--
+	return item, nil
+}
+
+// release deletes the first segment if all of it was read back.
+func (s *spill[T]) release() error {
+	first := s.segs[0]
+	if first.read < first.written {
+		return nil
+	}
+	s.segs = s.segs[1:]
+	return first.remove()
+}
+
+// remove deletes the segments left and the directory.
+func (s *spill[T]) remove() {
+	for _, seg := range s.segs {
+		_ = seg.remove()
+	}
+	if s.dir != "" {
+		_ = os.RemoveAll(s.dir)
+	}
+}
+
+func (seg *segment) closeWriter() error {
+	err := seg.bw.Flush()
+	if closeErr := seg.w.Close(); err == nil {
+		err = closeErr
+	}
+	seg.w, seg.bw = nil, nil
+	if err != nil {
+		return fmt.Errorf("write %s: %w", seg.path, err)
+	}
+	return nil
+}
+
+func (seg *segment) remove() error {
+	if seg.w != nil {
+		seg.w.Close()
+	}
+	if seg.r != nil {
+		seg.r.Close()
+	}
+	return os.Remove(seg.path)
+}
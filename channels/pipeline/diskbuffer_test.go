@@ -0,0 +1,219 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// feedStalled sends items to in with nobody reading the buffer's output, failing
+// the test if the buffer stops taking them.
+func feedStalled(t *testing.T, in chan<- int, items []int) {
+	t.Helper()
+	for _, item := range items {
+		select {
+		case in <- item:
+		case <-time.After(pipelinetest.DefaultTimeout):
+			t.Fatalf("buffer stopped reading at item %d with its consumer stalled", item)
+		}
+	}
+}
+
+// segments returns the segment files found under dir.
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*.seg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func assertEmptyDir(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) > 0 {
+		t.Errorf("%s holds %d entries, want the buffer to have removed them", dir, len(entries))
+	}
+}
+
+func TestDiskBufferSpillsAndReplaysInOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	in := make(chan int)
+	out, errc := pipeline.DiskBuffer(ctx, in, dir, pipeline.GobCodec[int]{}, 10, pipeline.WithSegmentSize(256))
+
+	items := pipelinetest.Items(1000)
+	feedStalled(t, in, items)
+	close(in)
+	spilled := segments(t, dir)
+	if len(spilled) < 2 {
+		t.Fatalf("%d segments after spilling 990 items, want several", len(spilled))
+	}
+
+	got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	if !slices.Equal(got, items) {
+		t.Errorf("got %d items, want all 1000 exactly once and in order", len(got))
+	}
+	assertEmptyDir(t, dir)
+}
+
+func TestDiskBufferDeletesConsumedSegments(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	in := make(chan int)
+	out, errc := pipeline.DiskBuffer(ctx, in, dir, pipeline.GobCodec[int]{}, 10, pipeline.WithSegmentSize(256))
+
+	feedStalled(t, in, pipelinetest.Items(500))
+	before := segments(t, dir)
+	for i := range 250 {
+		if got := <-out; got != i {
+			t.Fatalf("item %d = %d", i, got)
+		}
+	}
+	after := segments(t, dir)
+	if len(after) >= len(before) || slices.Contains(after, before[0]) {
+		t.Errorf("segments %v after consuming half of %v, want the first ones gone", after, before)
+	}
+
+	// While the consumer keeps up, later items are handed on in order after
+	// the spilled ones.
+	go func() {
+		defer close(in)
+		for i := 500; i < 600; i++ {
+			in <- i
+		}
+	}()
+	got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	want := pipelinetest.Items(600)[250:]
+	if !slices.Equal(got, want) {
+		t.Errorf("got %d items after resuming, want items 250 to 599 in order", len(got))
+	}
+	assertEmptyDir(t, dir)
+}
+
+func TestDiskBufferWithinMemLimit(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	in := make(chan int)
+	out, errc := pipeline.DiskBuffer(ctx, in, dir, pipeline.GobCodec[int]{}, 10)
+
+	feedStalled(t, in, pipelinetest.Items(10))
+	close(in)
+	// Nothing touches the disk unless memory is full.
+	assertEmptyDir(t, dir)
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) > 0 || !slices.Equal(got, pipelinetest.Items(10)) {
+		t.Errorf("got %v and errors %v, want the 10 items", got, errs)
+	}
+}
+
+func TestDiskBufferCancelReportsDropped(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	dir := t.TempDir()
+	in := make(chan int)
+	out, errc := pipeline.DiskBuffer(ctx, in, dir, pipeline.GobCodec[int]{}, 10)
+
+	feedStalled(t, in, pipelinetest.Items(100))
+	<-out
+	cancel()
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if len(errs) != 1 || !errors.Is(errs[0], pipeline.ErrBufferDropped) {
+		t.Fatalf("errors = %v, want ErrBufferDropped", errs)
+	}
+	if want := "stage diskbuffer: 99 items: buffered items dropped"; errs[0].Error() != want {
+		t.Errorf("err = %q, want %q", errs[0], want)
+	}
+	assertEmptyDir(t, dir)
+}
+
+// failingCodec fails to encode the number 42.
+type failingCodec struct {
+	pipeline.GobCodec[int]
+}
+
+func (c failingCodec) Encode(n int) ([]byte, error) {
+	if n == 42 {
+		return nil, errors.New("unencodable")
+	}
+	return c.GobCodec.Encode(n)
+}
+
+func TestDiskBufferEncodeError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	in := make(chan int)
+	out, errc := pipeline.DiskBuffer(ctx, in, dir, failingCodec{}, 10)
+
+	go func() {
+		for _, item := range pipelinetest.Items(50) {
+			select {
+			case <-ctx.Done():
+				return
+			case in <- item:
+			}
+		}
+	}()
+	errs := pipelinetest.CollectWithTimeout(t, errc, pipelinetest.DefaultTimeout)
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || !errors.Is(errs[0], pipeline.ErrBufferDropped) {
+		t.Fatalf("errors = %v, want the encode error and the items dropped", errs)
+	}
+	if want := "stage diskbuffer: encode item 42: unencodable, 43 items: buffered items dropped"; errs[0].Error() != want {
+		t.Errorf("err = %q, want %q", errs[0], want)
+	}
+	assertEmptyDir(t, dir)
+}
+
+func TestDiskBufferItemAboveFrameLimit(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	in := make(chan []byte)
+	out, errc := pipeline.DiskBuffer(ctx, in, dir, pipeline.RawCodec{}, 1, pipeline.WithMaxFrameSize(4))
+
+	// The first goes to memory, the second to disk, where it does not fit.
+	go func() {
+		for _, chunk := range [][]byte{[]byte("in memory"), []byte("too large")} {
+			select {
+			case <-ctx.Done():
+				return
+			case in <- chunk:
+			}
+		}
+	}()
+	errs := pipelinetest.CollectWithTimeout(t, errc, pipelinetest.DefaultTimeout)
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if len(errs) != 1 || !errors.Is(errs[0], pipeline.ErrFrameTooLarge) || !errors.Is(errs[0], pipeline.ErrBufferDropped) {
+		t.Fatalf("errors = %v, want the frame too large and the items dropped", errs)
+	}
+	assertEmptyDir(t, dir)
+}
@@ -0,0 +1,154 @@
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// errorsNode is the DOT node the error channels a pipeline reports lead to.
+const errorsNode = "(errors)"
+
+// dotNode is a node of a pipeline rendered as DOT.
+type dotNode struct {
+	id      string
+	kind    string
+	workers int
+	o       options
+	// errors is set for nodes reporting errors to the pipeline.
+	errors bool
+}
+
+// newDotNode describes a node of the given kind built with opts. Sources have
+// no workers and sinks no buffer.
+func newDotNode(id, kind string, workers int, opts []Option, errors bool) dotNode {
+	return dotNode{id: id, kind: kind, workers: workers, o: newOptions(opts), errors: errors}
+}
+
+func (n dotNode) label() string {
+	var details []string
+	details = append(details, n.kind)
+	if n.workers == 1 {
+		details = append(details, "1 worker")
+	} else if n.workers > 1 {
+		details = append(details, fmt.Sprintf("%d workers", n.workers))
+	}
+	if n.kind != "sink" {
+		details = append(details, fmt.Sprintf("buffer %d", n.o.buffer))
+	}
+	return n.o.stageName(n.kind) + "\n" + strings.Join(details, ", ")
+}
+
+// dotEdge is a data channel between two nodes, or an error channel if errors
+// is set. from is the node sending on it.
+type dotEdge struct {
+	from, to string
+	errors   bool
+}
+
+// writeDOT renders nodes and edges as a Graphviz digraph, in the order given.
+// Edges leaving a node with Metrics are labelled with its live counts: items
+// emitted on data channels and errors on error channels.
+func writeDOT(w io.Writer, nodes []dotNode, edges []dotEdge) error {
+	metrics := make(map[string]*Metrics, len(nodes))
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph pipeline {")
+	fmt.Fprintln(bw, "\trankdir=LR;")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+	for _, n := range nodes {
+		metrics[n.id] = n.o.metrics
+		fmt.Fprintf(bw, "\t%q [label=%q];\n", n.id, n.label())
+	}
+	if slices.ContainsFunc(edges, func(e dotEdge) bool { return e.to == errorsNode }) {
+		fmt.Fprintf(bw, "\t%q [label=\"errors\", shape=ellipse];\n", errorsNode)
+	}
+	for _, e := range edges {
+		var attrs []string
+		if e.errors {
+			attrs = append(attrs, "style=dashed")
+		}
+		if m := metrics[e.from]; m != nil {
+			count := m.Out.Load()
+			if e.errors {
+				count = m.Errors.Load()
+			}
+			attrs = append(attrs, fmt.Sprintf("label=\"%d\"", count))
+		}
+		if len(attrs) == 0 {
+			fmt.Fprintf(bw, "\t%q -> %q;\n", e.from, e.to)
+			continue
+		}
+		fmt.Fprintf(bw, "\t%q -> %q [%s];\n", e.from, e.to, strings.Join(attrs, ", "))
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// WriteDOT writes the stages of the pipeline to w in the Graphviz DOT
+// language, one box per stage with its name, workers and buffer, solid
+// edges for its data channels and dashed ones for its error channels. Called
+// while the pipeline runs, the edges of stages given WithMetrics show their
+// counts so far.
+func (p *Pipeline) WriteDOT(w io.Writer) error {
+	var edges []dotEdge
+	for i, n := range p.nodes {
+		if i > 0 {
+			edges = append(edges, dotEdge{from: p.nodes[i-1].id, to: n.id})
+		}
+		if n.errors {
+			edges = append(edges, dotEdge{from: n.id, to: errorsNode, errors: true})
+		}
+	}
+	return writeDOT(w, p.nodes, edges)
+}
+
+// WriteDOT writes the graph to w in the Graphviz DOT language, as
+// Pipeline.WriteDOT does. Nodes and edges are sorted by name. Errors a node
+// sends elsewhere with ConnectErrors are drawn to that node; the others lead
+// to an errors node standing for the graph's error channel.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var nodes []dotNode
+	for _, name := range slices.Sorted(maps.Keys(g.nodes)) {
+		n := g.nodes[name]
+		workers := 1
+		if n.kind == sourceNode {
+			workers = 0
+		}
+		nodes = append(nodes, newDotNode(name, n.kind.String(), workers, append([]Option{WithName(name)}, n.opts...), true))
+	}
+
+	var edges []dotEdge
+	routed := make(map[string]bool)
+	for _, e := range g.edges {
+		edges = append(edges, dotEdge{from: e[0], to: e[1]})
+	}
+	for _, e := range g.errs {
+		edges = append(edges, dotEdge{from: e[0], to: e[1], errors: true})
+		routed[e[0]] = true
+	}
+	for _, n := range nodes {
+		if !routed[n.id] {
+			edges = append(edges, dotEdge{from: n.id, to: errorsNode, errors: true})
+		}
+	}
+	slices.SortFunc(edges, func(a, b dotEdge) int {
+		if c := strings.Compare(a.from, b.from); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.to, b.to); c != 0 {
+			return c
+		}
+		// Data before errors between the same two nodes.
+		if a.errors == b.errors {
+			return 0
+		}
+		if a.errors {
+			return 1
+		}
+		return -1
+	})
+	return writeDOT(w, nodes, edges)
+}
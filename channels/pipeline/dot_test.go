@@ -0,0 +1,102 @@
+package pipeline_test
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// assertGolden compares got with testdata/name, or rewrites it with -update.
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("DOT differs from %s; rerun with -update if intended:\n%s", path, got)
+	}
+}
+
+func TestPipelineWriteDOT(t *testing.T) {
+	p := pipeline.Via(
+		pipeline.New(pipelinetest.Items(10), pipeline.WithName("generator"), pipeline.WithBuffer(2)).
+			ThenPool(4, double, pipeline.WithName("transform"), pipeline.WithBuffer(8)),
+		func(_ context.Context, n int) (string, error) { return strings.Repeat("*", n), nil }).
+		Sink(func(context.Context, string) error { return nil }, pipeline.WithName("save")).
+		OnError(func(err error) error { return err })
+
+	var b strings.Builder
+	if err := p.WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "linear.dot", b.String())
+}
+
+func TestGraphWriteDOT(t *testing.T) {
+	// A diamond in which the errors of one branch are audited.
+	g := pipeline.NewGraph()
+	g.AddSink("save", func(context.Context, any) error { return nil })
+	g.AddSink("audit", func(context.Context, any) error { return nil })
+	g.AddStage("right", adding(2), pipeline.WithBuffer(4), pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	g.AddStage("left", adding(1))
+	g.AddSource("nums", anySource(pipelinetest.Items(10)))
+	g.Connect("nums", "left")
+	g.Connect("nums", "right")
+	g.Connect("left", "save")
+	g.Connect("right", "save")
+	g.ConnectErrors("right", "audit")
+
+	// The graph keeps its nodes in a map, which must not show.
+	var first string
+	for i := range 10 {
+		var b strings.Builder
+		if err := g.WriteDOT(&b); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = b.String()
+		} else if b.String() != first {
+			t.Fatalf("WriteDOT changed between calls:\n%s\nthen\n%s", first, b.String())
+		}
+	}
+	assertGolden(t, "diamond.dot", first)
+}
+
+func TestWriteDOTLiveCounts(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	m := &pipeline.Metrics{}
+	p := pipeline.New(pipelinetest.Items(5), pipeline.WithName("generator")).
+		ThenPool(2, failOnSix, pipeline.WithName("transform"), pipeline.WithMetrics(m), pipeline.WithErrorPolicy(pipeline.SkipAndReport)).
+		Sink(func(context.Context, int) error { return nil }, pipeline.WithName("save")).
+		OnError(func(error) error { return nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := p.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := p.WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"n1" -> "n2" [label="5"];`) || !strings.Contains(b.String(), `"n1" -> "(errors)" [style=dashed, label="0"];`) {
+		t.Errorf("DOT without the counts of transform:\n%s", b.String())
+	}
+}
@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// Feeder pushes items into the channel of a DynamicSource while the pipeline
+// reading it runs. Its methods are safe for concurrent use.
+type Feeder[T any] struct {
+	ctx        context.Context
+	out        chan T
+	controller *Controller
+	metrics    *Metrics
+
+	mu      sync.Mutex
+	closed  bool
+	closing chan struct{}
+	pushes  sync.WaitGroup
+}
+
+// DynamicSource returns a generator emitting the items pushed to its Feeder,
+// in the order the pushes were accepted, for a pipeline that takes items
+// after it started, from an admin endpoint for example. The channel is closed
+// once the Feeder is closed and the pushes in progress returned, or when ctx
+// is cancelled; the items accepted before Close are still read from it, up to
+// WithBuffer of them queued in the channel. WithController holds the pushes
+// while paused, the ones queued already still go through, and WithMetrics
+// counts the items pushed as its output.
+func DynamicSource[T any](ctx context.Context, opts ...Option) (*Feeder[T], <-chan T) {
+	o := newOptions(opts)
+	f := &Feeder[T]{ctx: ctx, out: make(chan T, o.buffer), controller: o.controller, metrics: o.metrics, closing: make(chan struct{})}
+	unregister := o.register("dynamic source")
+	name := o.stageName("dynamic source")
+	o.logStart(ctx, name)
+	stop := make(chan struct{})
+	sampleOccupancy(o.metrics, f.out, stop)
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(f.out)
+		defer close(stop)
+		select {
+		case <-ctx.Done():
+			// No push may start once the channel is about to close.
+			f.Close()
+		case <-f.closing:
+		}
+		f.pushes.Wait()
+	}()
+	return f, f.out
+}
+
+// Push hands item to the pipeline, waiting until its Controller, if any, is
+// running and the channel takes it, or until ctx is cancelled. It returns
+// ErrFeederClosed once the Feeder is closed, also to a push still waiting
+// when Close is called, and the error of the context of the source once that
+// is cancelled.
+func (f *Feeder[T]) Push(ctx context.Context, item T) error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		if err := f.ctx.Err(); err != nil {
+			return err
+		}
+		return ErrFeederClosed
+	}
+	f.pushes.Add(1)
+	f.mu.Unlock()
+	defer f.pushes.Done()
+
+	for {
+		var paused <-chan struct{}
+		if f.controller != nil {
+			var resume <-chan struct{}
+			paused, resume = f.controller.channels()
+			if err := f.wait(ctx, resume); err != nil {
+				return err
+			}
+		}
+		select {
+		case f.out <- item:
+			f.metrics.itemOut()
+			return nil
+		case <-paused:
+			// Offer item again once resumed.
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-f.ctx.Done():
+			return f.ctx.Err()
+		case <-f.closing:
+			return ErrFeederClosed
+		}
+	}
+}
+
+// wait waits for ready to be closed, or returns why a push has to give up
+// first.
+func (f *Feeder[T]) wait(ctx context.Context, ready <-chan struct{}) error {
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.ctx.Done():
+		return f.ctx.Err()
+	case <-f.closing:
+		return ErrFeederClosed
+	}
+}
+
+// PushBatch pushes items one after the other. It stops at the first push that
+// fails and returns how many were pushed.
+func (f *Feeder[T]) PushBatch(ctx context.Context, items []T) (int, error) {
+	for i, item := range items {
+		if err := f.Push(ctx, item); err != nil {
+			return i, err
+		}
+	}
+	return len(items), nil
+}
+
+// Close stops the Feeder taking items, so that the channel closes after the
+// items already pushed. It does not wait for them to be read. Only the first
+// call does anything.
+func (f *Feeder[T]) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.closing)
+	}
+}
@@ -0,0 +1,215 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestDynamicSourceConcurrentPushers(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	feeder, out := pipeline.DynamicSource[int](ctx)
+	doubled, errc := pipeline.Stage(ctx, out, double)
+
+	const pushers, each = 4, 25
+	var wg sync.WaitGroup
+	for p := range pushers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range each {
+				if err := feeder.Push(ctx, p*each+i); err != nil {
+					t.Errorf("push: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		feeder.Close()
+	}()
+
+	got := pipelinetest.CollectWithTimeout(t, doubled, pipelinetest.DefaultTimeout)
+	pipelinetest.AssertClosed(t, errc, pipelinetest.DefaultTimeout)
+	// The items of one pusher keep their order.
+	for p := range pushers {
+		var mine []int
+		for _, n := range got {
+			if n/2/each == p {
+				mine = append(mine, n/2)
+			}
+		}
+		if want := pipelinetest.Items(each * (p + 1))[each*p:]; !slices.Equal(mine, want) {
+			t.Errorf("pusher %d: got %v, want %v", p, mine, want)
+		}
+	}
+	if len(got) != pushers*each {
+		t.Errorf("got %d items, want %d", len(got), pushers*each)
+	}
+}
+
+func TestDynamicSourceCloseDrains(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	feeder, out := pipeline.DynamicSource[int](ctx, pipeline.WithBuffer(3))
+	if n, err := feeder.PushBatch(ctx, []int{1, 2, 3}); n != 3 || err != nil {
+		t.Fatalf("PushBatch = %d, %v, want 3, nil", n, err)
+	}
+	feeder.Close()
+	feeder.Close()
+
+	if err := feeder.Push(ctx, 4); !errors.Is(err, pipeline.ErrFeederClosed) {
+		t.Errorf("Push after Close = %v, want ErrFeederClosed", err)
+	}
+	if n, err := feeder.PushBatch(ctx, []int{5, 6}); n != 0 || !errors.Is(err, pipeline.ErrFeederClosed) {
+		t.Errorf("PushBatch after Close = %d, %v, want 0, ErrFeederClosed", n, err)
+	}
+	if got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("got %v, want the pushed [1 2 3]", got)
+	}
+}
+
+func TestDynamicSourceCloseWhilePushing(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	feeder, out := pipeline.DynamicSource[int](ctx)
+
+	// Nothing reads out, so the push waits until Close.
+	pushed := make(chan error, 1)
+	go func() { pushed <- feeder.Push(ctx, 1) }()
+	time.Sleep(20 * time.Millisecond)
+	feeder.Close()
+	select {
+	case err := <-pushed:
+		if !errors.Is(err, pipeline.ErrFeederClosed) {
+			t.Errorf("waiting Push = %v, want ErrFeederClosed", err)
+		}
+	case <-time.After(pipelinetest.DefaultTimeout):
+		t.Fatal("Close did not end the waiting push")
+	}
+	if got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout); len(got) != 0 {
+		t.Errorf("got %v, want nothing", got)
+	}
+}
+
+func TestDynamicSourceCancelWithQueuedItems(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	feeder, out := pipeline.DynamicSource[int](ctx, pipeline.WithBuffer(2))
+	if _, err := feeder.PushBatch(ctx, []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	pushed := make(chan error, 1)
+	go func() { pushed <- feeder.Push(context.Background(), 3) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-pushed:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("waiting Push = %v, want context.Canceled", err)
+		}
+	case <-time.After(pipelinetest.DefaultTimeout):
+		t.Fatal("cancelling did not end the waiting push")
+	}
+	// The queued items are still read, then the channel closes.
+	if got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("got %v, want the queued [1 2]", got)
+	}
+	if err := feeder.Push(context.Background(), 4); !errors.Is(err, context.Canceled) {
+		t.Errorf("Push after cancelling = %v, want context.Canceled", err)
+	}
+}
+
+func TestDynamicSourcePushCancelled(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	feeder, out := pipeline.DynamicSource[int](ctx)
+	pushCtx, cancelPush := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancelPush()
+	if err := feeder.Push(pushCtx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Push = %v, want its own deadline", err)
+	}
+	// The feeder is still open.
+	go func() {
+		feeder.Push(ctx, 2)
+		feeder.Close()
+	}()
+	if got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout); !slices.Equal(got, []int{2}) {
+		t.Errorf("got %v, want [2]", got)
+	}
+}
+
+func TestDynamicSourceController(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := pipeline.NewController()
+	feeder, out := pipeline.DynamicSource[int](ctx, pipeline.WithController(c), pipeline.WithBuffer(1))
+	if err := feeder.Push(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	c.Pause()
+	pushed := make(chan error, 1)
+	go func() { pushed <- feeder.Push(ctx, 2) }()
+
+	// What was queued before the pause still comes out, the push waits.
+	if got := <-out; got != 1 {
+		t.Fatalf("got %d, want the queued 1", got)
+	}
+	select {
+	case err := <-pushed:
+		t.Fatalf("Push returned %v while paused", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	c.Resume()
+	if err := <-pushed; err != nil {
+		t.Fatalf("Push after resuming = %v", err)
+	}
+	feeder.Close()
+	if got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout); !slices.Equal(got, []int{2}) {
+		t.Errorf("got %v, want [2]", got)
+	}
+}
+
+func TestDynamicSourceClosedWhilePaused(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	c := pipeline.NewController()
+	c.Pause()
+	feeder, out := pipeline.DynamicSource[int](context.Background(), pipeline.WithController(c))
+	pushed := make(chan error, 1)
+	go func() { pushed <- feeder.Push(context.Background(), 1) }()
+	time.Sleep(10 * time.Millisecond)
+	feeder.Close()
+	if err := <-pushed; !errors.Is(err, pipeline.ErrFeederClosed) {
+		t.Errorf("Push held by the pause = %v, want ErrFeederClosed", err)
+	}
+	pipelinetest.AssertClosed(t, out, pipelinetest.DefaultTimeout)
+}
+
+func TestDynamicSourceMetrics(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var m pipeline.Metrics
+	feeder, out := pipeline.DynamicSource[int](context.Background(), pipeline.WithMetrics(&m), pipeline.WithBuffer(3))
+	if n, err := feeder.PushBatch(context.Background(), pipelinetest.Items(3)); n != 3 || err != nil {
+		t.Fatalf("PushBatch = %d, %v", n, err)
+	}
+	feeder.Close()
+	pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout)
+	if got := m.Out.Load(); got != 3 {
+		t.Errorf("Out = %d, want the 3 items pushed", got)
+	}
+}
@@ -0,0 +1,66 @@
+package pipeline
+
+import "context"
+
+// WithErrorBuffer gives a stage's error channel room for n errors, so that
+// the failed items it reports under SkipAndReport do not hold the stage up
+// while nobody is reading errors. overflow decides what happens to a report
+// once the buffer is full: Block waits for the reader as an unbuffered
+// channel does, while DropOldest and DropNewest drop a report and count it in
+// Metrics.ErrorsDropped. The error that stops a stage is never dropped.
+func WithErrorBuffer(n int, overflow OverflowPolicy) Option {
+	return func(o *options) {
+		o.errorBuffer = max(n, 0)
+		o.errorOverflow = overflow
+	}
+}
+
+func (o options) newErrc() chan error {
+	return make(chan error, o.errorBuffer)
+}
+
+// reportErr sends the report of a skipped item on errc as WithErrorBuffer
+// says. It returns false if ctx was cancelled first.
+func (o options) reportErr(ctx context.Context, errc chan error, err error) bool {
+	if o.errorOverflow == Block || o.errorBuffer == 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case errc <- err:
+			return true
+		}
+	}
+	for {
+		if ctx.Err() != nil {
+			return false
+		}
+		select {
+		case errc <- err:
+			return true
+		default:
+		}
+		if o.errorOverflow == DropNewest {
+			o.metrics.errorDropped()
+			return true
+		}
+		// The reader may empty the buffer meanwhile, in which case the
+		// next attempt to send succeeds.
+		select {
+		case <-errc:
+			o.metrics.errorDropped()
+		default:
+		}
+	}
+}
+
+// MergeErrors is Merge for error channels with a buffer of its own, so that a
+// caller busy elsewhere does not hold up the stages behind it. overflow works
+// as for a Bulkhead, and the returned function reports how many errors were
+// dropped. Dropping policies may drop the error that stopped a stage too, so
+// only use them for channels that carry item reports.
+func MergeErrors(ctx context.Context, buffer int, overflow OverflowPolicy, chans ...<-chan error) (<-chan error, func() int) {
+	out, stats := Bulkhead(ctx, Merge(ctx, chans...), buffer, overflow)
+	return out, func() int {
+		return int(stats().Dropped)
+	}
+}
@@ -0,0 +1,125 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// oddItems returns the items of the reports of failOdd.
+func oddItems(t *testing.T, errs []error) []any {
+	t.Helper()
+	items := make([]any, len(errs))
+	for i, err := range errs {
+		var stageErr *pipeline.StageError
+		if !errors.As(err, &stageErr) {
+			t.Fatalf("%v is not a *StageError", err)
+		}
+		items[i] = stageErr.Item
+	}
+	return items
+}
+
+func TestErrorBufferDropsWhileNobodyReads(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		overflow pipeline.OverflowPolicy
+		kept     []any
+	}{
+		{"DropNewest", pipeline.DropNewest, []any{1, 3}},
+		{"DropOldest", pipeline.DropOldest, []any{97, 99}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			m := &pipeline.Metrics{}
+			out, errc := pipeline.Stage(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), failOdd,
+				pipeline.WithErrorPolicy(pipeline.SkipAndReport), pipeline.WithErrorBuffer(2, tc.overflow), pipeline.WithMetrics(m))
+
+			// errc is left alone until the stage is done with every item.
+			if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 50 {
+				t.Fatalf("got %d items, want the 50 even ones", len(got))
+			}
+			errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+			if kept := oddItems(t, errs); len(kept) != 2 || kept[0] != tc.kept[0] || kept[1] != tc.kept[1] {
+				t.Errorf("kept reports for %v, want %v", kept, tc.kept)
+			}
+			if n := m.Snapshot().ErrorsDropped; n != 48 {
+				t.Errorf("dropped %d reports, want 48", n)
+			}
+		})
+	}
+}
+
+func TestErrorBufferBlockHoldsStage(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, errc := pipeline.Stage(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), failOdd,
+		pipeline.WithErrorPolicy(pipeline.SkipAndReport), pipeline.WithErrorBuffer(2, pipeline.Block))
+
+	// Once the buffer holds the reports of 1 and 3, the report of 5 waits
+	// for a reader and so does the stage.
+	var got []int
+	for range 3 {
+		got = append(got, <-out)
+	}
+	select {
+	case n := <-out:
+		t.Fatalf("stage emitted %d with a full error buffer", n)
+	case <-time.After(20 * time.Millisecond):
+	}
+	rest, errs := collectBoth(t, out, errc)
+	if len(got)+len(rest) != 50 || len(errs) != 50 {
+		t.Errorf("got %d items and %d reports, want 50 of each", len(got)+len(rest), len(errs))
+	}
+}
+
+func TestErrorBufferNeverDropsFatalError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := &pipeline.Metrics{}
+	out, errc := pipeline.Stage(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), failOdd,
+		pipeline.WithMaxErrors(10), pipeline.WithErrorBuffer(2, pipeline.DropNewest), pipeline.WithMetrics(m))
+
+	// 0 to 20 pass, and the report of 21 is the eleventh error.
+	for range 11 {
+		<-out
+	}
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if len(errs) != 3 || !errors.Is(errs[2], pipeline.ErrThresholdExceeded) {
+		t.Errorf("errors = %v, want 2 reports and then the threshold error", errs)
+	}
+	if n := m.Snapshot().ErrorsDropped; n != 8 {
+		t.Errorf("dropped %d reports, want 8", n)
+	}
+	pipelinetest.AssertClosed(t, out, time.Second)
+}
+
+func TestMergeErrorsCountsDropped(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var outs []<-chan int
+	var errcs []<-chan error
+	for range 3 {
+		out, errc := pipeline.Stage(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), failOdd, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+		outs = append(outs, out)
+		errcs = append(errcs, errc)
+	}
+	merged, dropped := pipeline.MergeErrors(ctx, 4, pipeline.DropNewest, errcs...)
+
+	if got := pipelinetest.CollectWithTimeout(t, pipeline.Merge(ctx, outs...), time.Second); len(got) != 150 {
+		t.Fatalf("got %d items, want 150 with the errors unread", len(got))
+	}
+	kept := pipelinetest.CollectWithTimeout(t, merged, time.Second)
+	if len(kept)+dropped() != 150 || len(kept) < 4 {
+		t.Errorf("kept %d and dropped %d reports, want a full buffer and the rest of 150 dropped", len(kept), dropped())
+	}
+}
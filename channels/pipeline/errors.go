@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrThresholdExceeded is wrapped by the error a stage reports when it sees
+// more failed items than allowed by WithMaxErrors.
+var ErrThresholdExceeded = errors.New("error threshold exceeded")
+
+// ErrDrainTimeout is returned by Run when in-flight items did not drain
+// within RunOptions.DrainTimeout.
+var ErrDrainTimeout = errors.New("drain timeout exceeded")
+
+// ErrCancelled is wrapped by the error Run returns when ctx was cancelled,
+// other than by a signal, and the pipeline stopped without a failure.
+var ErrCancelled = errors.New("pipeline cancelled")
+
+// ErrStageFailed is wrapped by the error Run returns when a stage failed,
+// together with the StageError of the failure.
+var ErrStageFailed = errors.New("stage failed")
+
+// ErrStageTimeout is wrapped by the error WithStageTimeout returns for an item
+// that took too long.
+var ErrStageTimeout = errors.New("stage timed out")
+
+// ErrCircuitOpen is returned by a CircuitBreaker for items it refuses while
+// open. Under the SkipAndReport policy such items become dead letters like
+// any other failure.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// ErrSignalled is the cause of a context cancelled by ShutdownOnSignal, and
+// is wrapped by the error Run returns when such a context stopped it.
+var ErrSignalled = errors.New("signal received")
+
+// ErrInvalidGraph is wrapped by the error Graph.Validate returns for a
+// topology that cannot run.
+var ErrInvalidGraph = errors.New("invalid graph")
+
+// ErrJoinBufferFull is wrapped by the error Join reports when the right side
+// holds more items than it may buffer.
+var ErrJoinBufferFull = errors.New("join buffer full")
+
+// ErrLengthMismatch is wrapped by the error a strict Zip reports when its
+// inputs have different lengths.
+var ErrLengthMismatch = errors.New("length mismatch")
+
+// ErrBufferDropped is wrapped by the error a DiskBuffer reports for the items
+// it held when it had to stop early.
+var ErrBufferDropped = errors.New("buffered items dropped")
+
+// ErrRequeueLimit is wrapped by the error of an item Requeue gave up on.
+var ErrRequeueLimit = errors.New("requeue limit reached")
+
+// ErrOutOfOrder is the error of the Monotonic invariant.
+var ErrOutOfOrder = errors.New("out of order")
+
+// ErrZeroValue is the error of the NonZero invariant.
+var ErrZeroValue = errors.New("zero value")
+
+// ErrorPolicy decides what a stage does when its function returns an error.
+type ErrorPolicy int
+
+const (
+	// FailFast stops the stage on the first error. This is the default.
+	FailFast ErrorPolicy = iota
+	// SkipAndReport reports the failed item and carries on with the next one.
+	SkipAndReport
+)
+
+// StageError is the error a stage sends on its error channel. It names the
+// stage that failed and, if the failure was caused by one, the item. The
+// stage name comes from WithName and defaults to the kind of stage, e.g.
+// "stage" or "sink".
+type StageError struct {
+	Stage string
+	Item  any
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	if e.Item == nil {
+		return fmt.Sprintf("stage %s: %v", e.Stage, e.Err)
+	}
+	return fmt.Sprintf("stage %s (item %v): %v", e.Stage, e.Item, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// ItemError carries an item that failed together with the error it failed
+// with.
+type ItemError[T any] struct {
+	Item T
+	Err  error
+}
+
+func (e ItemError[T]) Error() string {
+	return fmt.Sprintf("item %v: %v", e.Item, e.Err)
+}
+
+func (e ItemError[T]) Unwrap() error {
+	return e.Err
+}
+
+func (o options) checkErrorCount(count int64, last error) error {
+	if !o.limitErrors || count <= int64(o.maxErrors) {
+		return nil
+	}
+	return fmt.Errorf("%w: %d errors (limit %d): %w", ErrThresholdExceeded, count, o.maxErrors, last)
+}
+
+// ErrFeederClosed is returned by a push to a Feeder that was closed.
+var ErrFeederClosed = errors.New("feeder closed")
+
+// ErrNotDeterministic is returned by RunDeterministic for a pipeline with a
+// stage it cannot schedule.
+var ErrNotDeterministic = errors.New("pipeline cannot run deterministically")
+
+// ErrInvalidShard is wrapped by the error ParseShard and Shard.Validate
+// return for a shard that does not exist.
+var ErrInvalidShard = errors.New("invalid shard")
+
+// ErrFrameTooLarge is wrapped by the error ReadFrame returns for a length
+// prefix above its limit, most likely a corrupt one, and by the error of a
+// stage refusing to write a frame it could not read back.
+var ErrFrameTooLarge = errors.New("frame too large")
@@ -0,0 +1,161 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestStageErrorFormat(t *testing.T) {
+	inner := errors.New("number 6 is invalid")
+	for _, tc := range []struct {
+		err  *pipeline.StageError
+		want string
+	}{
+		{&pipeline.StageError{Stage: "transform", Item: 6, Err: inner}, "stage transform (item 6): number 6 is invalid"},
+		{&pipeline.StageError{Stage: "source", Err: inner}, "stage source: number 6 is invalid"},
+	} {
+		if got := tc.err.Error(); got != tc.want {
+			t.Errorf("Error() = %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestStageErrorUnwrapChain(t *testing.T) {
+	pathErr := &fs.PathError{Op: "open", Path: "items.db", Err: fs.ErrNotExist}
+	err := fmt.Errorf("pipeline: %w", &pipeline.StageError{Stage: "save", Item: 3, Err: fmt.Errorf("save: %w", pathErr)})
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Error("errors.Is does not find fs.ErrNotExist through the StageError")
+	}
+	var stageErr *pipeline.StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != "save" || stageErr.Item != 3 {
+		t.Errorf("errors.As found %+v, want the save StageError", stageErr)
+	}
+	var gotPath *fs.PathError
+	if !errors.As(err, &gotPath) || gotPath.Path != "items.db" {
+		t.Errorf("errors.As found %+v, want the PathError", gotPath)
+	}
+}
+
+func TestStagesWrapErrorsWithTheirName(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	boom := errors.New("boom")
+	fail := func(_ context.Context, n int) (int, error) {
+		if n == 2 {
+			return 0, boom
+		}
+		return n, nil
+	}
+	for _, tc := range []struct {
+		name  string
+		stage pipelinetest.Stage[int, int]
+		want  string
+	}{
+		{"stage", pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+			return pipeline.Stage(ctx, in, fail, pipeline.WithName("transform"))
+		}), "transform"},
+		{"pool", pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+			return pipeline.StagePool(ctx, in, 2, fail, pipeline.WithName("transform"))
+		}), "transform"},
+		{"unnamed", pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+			return pipeline.Stage(ctx, in, fail)
+		}), "stage"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, errs := pipelinetest.Run(t, tc.stage, pipelinetest.Items(5))
+			var stageErr *pipeline.StageError
+			if len(errs) != 1 || !errors.As(errs[0], &stageErr) {
+				t.Fatalf("errors = %v, want one *StageError", errs)
+			}
+			if stageErr.Stage != tc.want || stageErr.Item != 2 || !errors.Is(errs[0], boom) {
+				t.Errorf("error = %+v, want stage %s, item 2 wrapping boom", stageErr, tc.want)
+			}
+		})
+	}
+}
+
+func TestSinkWrapsErrorsWithItsName(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	boom := errors.New("boom")
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, []int{1, 2}), func(_ context.Context, n int) error {
+		if n == 2 {
+			return boom
+		}
+		return nil
+	}, pipeline.WithName("save"))
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if len(errs) != 1 || errs[0].Error() != "stage save (item 2): boom" {
+		t.Errorf("errors = %v, want %q", errs, "stage save (item 2): boom")
+	}
+}
+
+func TestMaxErrorsZeroFailsFast(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Stage(ctx, in, failOnSix, pipeline.WithMaxErrors(0))
+	})
+
+	got, errs := pipelinetest.Run(t, stage, pipelinetest.Items(11))
+	if len(errs) != 1 || !errors.Is(errs[0], pipeline.ErrThresholdExceeded) {
+		t.Fatalf("errors = %v, want one threshold error", errs)
+	}
+	if len(got) != 6 {
+		t.Errorf("got %v, want the items before 6 only", got)
+	}
+}
+
+func TestMaxErrorsNeverTrips(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePool(ctx, in, 4, failOdd, pipeline.WithMaxErrors(1000))
+	})
+
+	got, errs := pipelinetest.Run(t, stage, pipelinetest.Items(100))
+	if len(got) != 50 || len(errs) != 50 {
+		t.Fatalf("got %d items and %d errors, want 50 of each", len(got), len(errs))
+	}
+	for _, err := range errs {
+		if errors.Is(err, pipeline.ErrThresholdExceeded) {
+			t.Errorf("threshold tripped: %v", err)
+		}
+	}
+}
+
+// TestMaxErrorsConcurrentCount has 8 workers failing every item at once: the
+// count is shared, so exactly one of them sees the 11th error.
+func TestMaxErrorsConcurrentCount(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	fail := func(context.Context, int) (int, error) { return 0, errors.New("bad") }
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePool(ctx, in, 8, fail, pipeline.WithMaxErrors(10))
+	})
+
+	_, errs := pipelinetest.Run(t, stage, pipelinetest.Items(1000))
+	var tripped []error
+	for _, err := range errs {
+		if errors.Is(err, pipeline.ErrThresholdExceeded) {
+			tripped = append(tripped, err)
+		}
+	}
+	if len(tripped) != 1 {
+		t.Fatalf("got %d threshold errors, want 1: %v", len(tripped), tripped)
+	}
+	if want := "11 errors (limit 10)"; !strings.Contains(tripped[0].Error(), want) {
+		t.Errorf("threshold error %q does not say %q", tripped[0], want)
+	}
+	if len(errs) > 11 {
+		t.Errorf("got %d errors, want at most 10 reports and the threshold error", len(errs))
+	}
+}
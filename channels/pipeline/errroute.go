@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"context"
+
+	"channelspractice/chanutil"
+)
+
+// DefaultErrorRoute is the route of the errors no ErrorRoute matched.
+const DefaultErrorRoute = "default"
+
+// ErrorRoute is one way for an error to go in RouteErrors and
+// Pipeline.RouteErrors.
+type ErrorRoute struct {
+	Name string
+	// Match reports whether err takes this route. A nil Match takes every
+	// error.
+	Match func(err error) bool
+}
+
+// routeOf returns the name of the first of routes matching err, or
+// DefaultErrorRoute if none does.
+func routeOf(routes []ErrorRoute, err error) string {
+	for _, r := range routes {
+		if r.Match == nil || r.Match(err) {
+			return r.Name
+		}
+	}
+	return DefaultErrorRoute
+}
+
+// RouteErrors splits the errors from in by routes: every error goes to the
+// output named after the first route matching it, checked in order, or to
+// the DefaultErrorRoute output if none does. There is an output for every
+// route name, routes of the same name sharing it, and one for
+// DefaultErrorRoute. As with Partition a send blocks until its consumer takes
+// the error, so every output must be consumed; WithBuffer gives them some
+// slack. The outputs are closed once in is closed or ctx is cancelled.
+func RouteErrors(ctx context.Context, in <-chan error, routes []ErrorRoute, opts ...Option) map[string]<-chan error {
+	o := newOptions(opts)
+	outs := map[string]chan error{DefaultErrorRoute: make(chan error, o.buffer)}
+	for _, r := range routes {
+		if outs[r.Name] == nil {
+			outs[r.Name] = make(chan error, o.buffer)
+		}
+	}
+	result := make(map[string]<-chan error, len(outs))
+	for name, out := range outs {
+		result[name] = out
+	}
+
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+		for err := range OrDone(ctx, in) {
+			if chanutil.SendContext(ctx, outs[routeOf(routes, err)], err) != nil {
+				return
+			}
+		}
+	}()
+	return result
+}
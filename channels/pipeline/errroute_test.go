@@ -0,0 +1,185 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+var (
+	errInvalid = errors.New("invalid")
+	errIO      = errors.New("io")
+	errBug     = errors.New("bug")
+)
+
+// severityRoutes sends validation and I/O errors their own way and bugs to
+// "fatal".
+var severityRoutes = []pipeline.ErrorRoute{
+	{Name: "validation", Match: func(err error) bool { return errors.Is(err, errInvalid) }},
+	{Name: "io", Match: func(err error) bool { return errors.Is(err, errIO) }},
+	{Name: "fatal", Match: func(err error) bool { return errors.Is(err, errBug) }},
+}
+
+func TestRouteErrors(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mixed := []error{
+		fmt.Errorf("item 1: %w", errInvalid),
+		fmt.Errorf("write: %w", errIO),
+		&pipeline.StageError{Stage: "save", Err: fmt.Errorf("nil map: %w", errBug)},
+		errors.New("something else"),
+		// Both validation and I/O: the first route matching wins.
+		errors.Join(errIO, errInvalid),
+		fmt.Errorf("read: %w", errIO),
+	}
+	outs := pipeline.RouteErrors(ctx, pipeline.Source(ctx, mixed), severityRoutes)
+	if len(outs) != 4 {
+		t.Fatalf("%d outputs, want one per route and the default", len(outs))
+	}
+
+	routed := make(map[string][]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, out := range outs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs := pipelinetest.CollectWithTimeout(t, out, time.Second)
+			mu.Lock()
+			defer mu.Unlock()
+			routed[name] = errs
+		}()
+	}
+	wg.Wait()
+
+	for name, want := range map[string][]error{
+		"validation":               {mixed[0], mixed[4]},
+		"io":                       {mixed[1], mixed[5]},
+		"fatal":                    {mixed[2]},
+		pipeline.DefaultErrorRoute: {mixed[3]},
+	} {
+		if got := routed[name]; !slices.Equal(got, want) {
+			t.Errorf("route %s got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRouteErrorsSharedName(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	outs := pipeline.RouteErrors(ctx, pipeline.Source(ctx, []error{errIO, errInvalid}), []pipeline.ErrorRoute{
+		{Name: "retry", Match: func(err error) bool { return errors.Is(err, errIO) }},
+		{Name: "retry", Match: func(err error) bool { return errors.Is(err, errInvalid) }},
+	}, pipeline.WithBuffer(2))
+	if len(outs) != 2 {
+		t.Fatalf("outputs %v, want retry and the default", outs)
+	}
+	if got := pipelinetest.CollectWithTimeout(t, outs["retry"], time.Second); !slices.Equal(got, []error{errIO, errInvalid}) {
+		t.Errorf("retry got %v, want both errors", got)
+	}
+	pipelinetest.AssertClosed(t, outs[pipeline.DefaultErrorRoute], time.Second)
+}
+
+func TestRouteErrorsCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan error)
+	outs := pipeline.RouteErrors(ctx, in, severityRoutes)
+	cancel()
+	for name, out := range outs {
+		t.Run(name, func(t *testing.T) {
+			pipelinetest.AssertClosed(t, out, time.Second)
+		})
+	}
+}
+
+// failBySeverity fails 2 as invalid, 4 on I/O and, if bug is set, bug as a
+// bug.
+func failBySeverity(bug int) func(context.Context, int) (int, error) {
+	return func(_ context.Context, n int) (int, error) {
+		switch n {
+		case 2:
+			return 0, errInvalid
+		case 4:
+			return 0, fmt.Errorf("write: %w", errIO)
+		case bug:
+			return 0, errBug
+		}
+		return n, nil
+	}
+}
+
+func TestPipelineRouteErrorsOnlyFatalFails(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var handled []string
+	sink := &pipeline.MemorySink[int]{}
+	_, err := pipeline.New(pipelinetest.Items(8)).
+		Then(failBySeverity(-1), pipeline.WithErrorPolicy(pipeline.SkipAndReport)).
+		SinkTo(sink).
+		RouteErrors(severityRoutes, "fatal", func(route string, err error) {
+			handled = append(handled, route)
+		}).
+		Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run = %v, want the non-fatal errors handled", err)
+	}
+	if !slices.Equal(handled, []string{"validation", "io"}) {
+		t.Errorf("handled %v, want validation and io", handled)
+	}
+	if got := sink.Items(); !slices.Equal(got, []int{0, 1, 3, 5, 6, 7}) {
+		t.Errorf("saved %v, want every item that did not fail", got)
+	}
+}
+
+func TestPipelineRouteErrorsFatalFails(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var handled []string
+	_, err := pipeline.New(pipelinetest.Items(8)).
+		Then(failBySeverity(5), pipeline.WithErrorPolicy(pipeline.SkipAndReport), pipeline.WithName("check")).
+		Sink(pipeline.PlainSink(func(int) error { return nil })).
+		RouteErrors(severityRoutes, "fatal", func(route string, err error) {
+			handled = append(handled, route)
+		}).
+		Run(context.Background())
+	var stageErr *pipeline.StageError
+	if !errors.Is(err, errBug) || !errors.As(err, &stageErr) || stageErr.Stage != "check" || stageErr.Item != 5 {
+		t.Fatalf("Run = %v, want the bug on 5", err)
+	}
+	if !slices.Equal(handled, []string{"validation", "io"}) {
+		t.Errorf("handled %v, want the errors before the bug", handled)
+	}
+}
+
+func TestPipelineRouteErrorsAfterOnError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var handled []string
+	err := pipeline.New(pipelinetest.Items(8)).
+		Then(failBySeverity(5), pipeline.WithErrorPolicy(pipeline.SkipAndReport)).
+		Sink(pipeline.PlainSink(func(int) error { return nil })).
+		OnError(func(err error) error {
+			// Bugs are forgiven before they are routed.
+			if errors.Is(err, errBug) {
+				return nil
+			}
+			return err
+		}).
+		RouteErrors(severityRoutes, "io", func(route string, err error) {
+			handled = append(handled, route)
+		}).
+		RunCollectingErrors(context.Background(), 0)
+	if !errors.Is(err, errIO) || errors.Is(err, errInvalid) || errors.Is(err, errBug) {
+		t.Errorf("RunCollectingErrors = %v, want only the I/O error", err)
+	}
+	if !slices.Equal(handled, []string{"validation"}) {
+		t.Errorf("handled %v, want only the validation error", handled)
+	}
+}
@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// FetchResult is the outcome of fetching one URL. Err is set when the request
+// could not be made or its body could not be read; a non-2xx Status is not an
+// error.
+type FetchResult struct {
+	URL    string
+	Status int
+	Body   []byte
+	Err    error
+}
+
+// FetchURLs GETs every URL read from urls with at most concurrency requests in
+// flight and emits one FetchResult per URL, in completion order. Requests use
+// a context derived from ctx, so cancelling it aborts those in flight. A
+// panicking transport fails only its own FetchResult, with a *PanicError. A
+// nil client means http.DefaultClient.
+func FetchURLs(ctx context.Context, urls <-chan string, client *http.Client, concurrency int, opts ...Option) <-chan FetchResult {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	o := newOptions(opts)
+	name := o.stageName("fetch")
+	get := func(ctx context.Context, url string) (FetchResult, error) {
+		result := FetchResult{URL: url}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			result.Err = err
+			return result, nil
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			result.Err = err
+			return result, nil
+		}
+		defer resp.Body.Close()
+		result.Status = resp.StatusCode
+		result.Body, result.Err = io.ReadAll(resp.Body)
+		return result, nil
+	}
+	fetch := func(ctx context.Context, url string) (FetchResult, error) {
+		result, err := call(ctx, o, name, get, url)
+		if err != nil {
+			result = FetchResult{URL: url, Err: err}
+		}
+		return result, nil
+	}
+	// fetch turns every failure, panics included, into a FetchResult, so the
+	// error channel only ever gets closed.
+	out, _ := StagePool(ctx, urls, concurrency, fetch, append(opts, WithName(name))...)
+	return out
+}
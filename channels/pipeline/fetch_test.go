@@ -0,0 +1,111 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestFetchURLsConcurrencyLimit(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var inFlight, highWater atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			hw := highWater.Load()
+			if n <= hw || highWater.CompareAndSwap(hw, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+	defer srv.Client().CloseIdleConnections()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	urls := make([]string, 12)
+	for i := range urls {
+		urls[i] = srv.URL + "/page"
+	}
+	results := pipelinetest.CollectWithTimeout(t, pipeline.FetchURLs(ctx, pipeline.Source(ctx, urls), srv.Client(), 3), 5*time.Second)
+
+	if len(results) != len(urls) {
+		t.Fatalf("got %d results, want %d", len(results), len(urls))
+	}
+	for _, r := range results {
+		if r.Err != nil || r.Status != http.StatusOK || string(r.Body) != "/page" {
+			t.Errorf("result = %+v, want 200 with body /page", r)
+		}
+	}
+	if hw := highWater.Load(); hw > 3 {
+		t.Errorf("%d requests in flight at once, limit is 3", hw)
+	}
+}
+
+func TestFetchURLsCancellationAbortsSlowRequest(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	defer close(release)
+	defer srv.Client().CloseIdleConnections()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := pipeline.FetchURLs(ctx, pipeline.Source(ctx, []string{srv.URL + "/fast", srv.URL + "/slow"}), srv.Client(), 2)
+	first := pipelinetest.CollectWithTimeout(t, pipeline.Take(ctx, out, 1), time.Second)
+	if len(first) != 1 || first[0].Status != http.StatusNoContent {
+		t.Fatalf("first result = %+v, want the fast one", first)
+	}
+
+	start := time.Now()
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("output closed %v after cancel, want promptly", elapsed)
+	}
+}
+
+type panickingTransport struct{}
+
+func (panickingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	panic("transport exploded")
+}
+
+func TestFetchURLsPanickingTransport(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &http.Client{Transport: panickingTransport{}}
+	urls := []string{"http://a.example", "http://b.example"}
+	results := pipelinetest.CollectWithTimeout(t, pipeline.FetchURLs(ctx, pipeline.Source(ctx, urls), client, 1), time.Second)
+
+	if len(results) != len(urls) {
+		t.Fatalf("got %d results, want %d", len(results), len(urls))
+	}
+	for _, r := range results {
+		var panicErr *pipeline.PanicError
+		if !errors.As(r.Err, &panicErr) {
+			t.Errorf("result for %s has error %v, want a *PanicError", r.URL, r.Err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// TempFile is the part of *os.File that FileSink writes through.
+type TempFile interface {
+	io.Writer
+	Name() string
+	Chmod(mode fs.FileMode) error
+	Sync() error
+	Close() error
+}
+
+// FileSinkOptions configures FileSink.
+type FileSinkOptions struct {
+	// Perm is the mode of the final file, 0644 if 0.
+	Perm fs.FileMode
+	// KeepTemp leaves the temporary file behind when the sink fails or is
+	// cancelled, e.g. to look at what was written. By default it is removed.
+	KeepTemp bool
+	// CreateTemp creates the temporary file, os.CreateTemp if nil.
+	CreateTemp func(dir, pattern string) (TempFile, error)
+}
+
+// WriteError is the error FileSink reports for a failed write, with the
+// number of bytes that made it into the file before.
+type WriteError struct {
+	Path   string
+	Offset int64
+	Err    error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("write %s at offset %d: %v", e.Path, e.Offset, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// FileSink writes every chunk from in to the file at path. It writes to a
+// temporary file in the same directory and, once in is closed, syncs it to
+// disk and renames it into place, so path only ever holds a complete file. If
+// a write fails or ctx is cancelled path is left alone and the temporary file
+// is removed unless opts.KeepTemp is set. A failed write is sent on the error
+// channel as a *StageError wrapping a *WriteError. The done channel is closed
+// once the file has been renamed or given up. Cancelling ctx closes the
+// temporary file, which interrupts a write blocked on it.
+func FileSink(ctx context.Context, in <-chan []byte, path string, opts FileSinkOptions, pipelineOpts ...Option) (<-chan struct{}, <-chan error) {
+	o := newOptions(pipelineOpts)
+	name := o.stageName("file")
+	createTemp := opts.CreateTemp
+	if createTemp == nil {
+		createTemp = func(dir, pattern string) (TempFile, error) {
+			return os.CreateTemp(dir, pattern)
+		}
+	}
+
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	tmp, err := createTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		errc <- &StageError{Stage: name, Err: err}
+		close(errc)
+		close(done)
+		return done, errc
+	}
+
+	var offset int64
+	_, sinkErrc := Sink(ctx, in, func(_ context.Context, chunk []byte) error {
+		n, err := tmp.Write(chunk)
+		if err != nil {
+			return &WriteError{Path: tmp.Name(), Offset: offset + int64(n), Err: err}
+		}
+		offset += int64(n)
+		return nil
+	}, append(pipelineOpts, WithName(name), WithOnCancel(func() { tmp.Close() }))...)
+
+	go func() {
+		defer close(errc)
+		defer close(done)
+		var err error
+		for sinkErr := range sinkErrc {
+			if ctx.Err() != nil {
+				// Most likely a write to the file closed on cancellation.
+				continue
+			}
+			err = sinkErr
+			// The offset says where the write failed; the chunk itself
+			// would only clutter the error.
+			if stageErr, ok := err.(*StageError); ok {
+				stageErr.Item = nil
+			}
+		}
+		if err == nil && ctx.Err() == nil {
+			if err = commit(tmp, path, opts.Perm); err == nil {
+				return
+			}
+			err = &StageError{Stage: name, Err: err}
+		}
+		tmp.Close()
+		if !opts.KeepTemp {
+			os.Remove(tmp.Name())
+		}
+		if err != nil {
+			errc <- err
+		}
+	}()
+	return done, errc
+}
+
+// commit syncs tmp, closes it and renames it to path. If that fails the
+// caller still has to remove tmp.
+func commit(tmp TempFile, path string, perm fs.FileMode) error {
+	if perm == 0 {
+		perm = 0o644
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
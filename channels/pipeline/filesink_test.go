@@ -0,0 +1,201 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// fullDisk is a temporary file that fails once limit bytes were written.
+type fullDisk struct {
+	*os.File
+	w failingWriter
+}
+
+func (f *fullDisk) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if _, writeErr := f.File.Write(p[:n]); writeErr != nil {
+		return 0, writeErr
+	}
+	return n, err
+}
+
+func createFullDisk(limit int) func(dir, pattern string) (pipeline.TempFile, error) {
+	return func(dir, pattern string) (pipeline.TempFile, error) {
+		f, err := os.CreateTemp(dir, pattern)
+		if err != nil {
+			return nil, err
+		}
+		return &fullDisk{File: f, w: failingWriter{limit: limit}}, nil
+	}
+}
+
+func lines(n int) [][]byte {
+	chunks := make([][]byte, n)
+	for i := range chunks {
+		chunks[i] = []byte("line\n")
+	}
+	return chunks
+}
+
+// dirEntries returns the names of the files in dir.
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestFileSinkRenamesIntoPlace(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+
+	done, errc := pipeline.FileSink(ctx, pipeline.Source(ctx, lines(3)), path, pipeline.FileSinkOptions{})
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Fatalf("errors = %v", errs)
+	}
+	<-done
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "line\nline\nline\n" {
+		t.Fatalf("file holds %q, %v", data, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o644 {
+		t.Errorf("mode = %v, %v, want 0644", info.Mode(), err)
+	}
+	if names := dirEntries(t, dir); len(names) != 1 {
+		t.Errorf("directory holds %v, want only out.txt", names)
+	}
+}
+
+func TestFileSinkWriteError(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep=%t", keep), func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			dir := t.TempDir()
+			path := filepath.Join(dir, "out.txt")
+
+			// The third line only fits half-way.
+			opts := pipeline.FileSinkOptions{KeepTemp: keep, CreateTemp: createFullDisk(12)}
+			done, errc := pipeline.FileSink(ctx, pipeline.Source(ctx, lines(5)), path, opts)
+			errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+			<-done
+
+			var stageErr *pipeline.StageError
+			var writeErr *pipeline.WriteError
+			if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "file" || stageErr.Item != nil ||
+				!errors.As(errs[0], &writeErr) || !errors.Is(errs[0], errDiskFull) {
+				t.Fatalf("errors = %v, want one file WriteError for the full disk", errs)
+			}
+			if writeErr.Offset != 12 {
+				t.Errorf("failed at offset %d, want 12", writeErr.Offset)
+			}
+			if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("final file exists after a failure: %v", err)
+			}
+			names := dirEntries(t, dir)
+			if keep && len(names) != 1 {
+				t.Errorf("directory holds %v, want the kept temporary file", names)
+			}
+			if !keep && len(names) != 0 {
+				t.Errorf("directory holds %v, want the temporary file removed", names)
+			}
+		})
+	}
+}
+
+func TestFileSinkCancelLeavesNoFile(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+
+	// in is never closed, so only the cancellation stops the sink.
+	in := make(chan []byte)
+	done, errc := pipeline.FileSink(ctx, in, path, pipeline.FileSinkOptions{})
+	in <- []byte("partial\n")
+	in <- []byte("partial\n")
+	cancel()
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v, want none for a cancellation", errs)
+	}
+	<-done
+
+	if names := dirEntries(t, dir); len(names) != 0 {
+		t.Errorf("directory holds %v after the cancellation, want nothing", names)
+	}
+}
+
+func TestFileSinkCreateError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "missing", "out.txt")
+	done, errc := pipeline.FileSink(ctx, pipeline.Source(ctx, lines(1)), path, pipeline.FileSinkOptions{})
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if len(errs) != 1 || !errors.Is(errs[0], os.ErrNotExist) {
+		t.Errorf("errors = %v, want the missing directory", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+}
+
+// pipeFile is a temporary file whose writes block until it is closed.
+type pipeFile struct {
+	*io.PipeWriter
+	closed atomic.Bool
+}
+
+func (f *pipeFile) Name() string            { return "pipe" }
+func (f *pipeFile) Chmod(os.FileMode) error { return nil }
+func (f *pipeFile) Sync() error             { return nil }
+func (f *pipeFile) Close() error {
+	f.closed.Store(true)
+	return f.PipeWriter.Close()
+}
+
+func TestFileSinkCancelInterruptsBlockedWrite(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, w := io.Pipe()
+	file := &pipeFile{PipeWriter: w}
+
+	in := make(chan []byte, 1)
+	in <- []byte("stuck\n")
+	done, errc := pipeline.FileSink(ctx, in, filepath.Join(t.TempDir(), "out.txt"), pipeline.FileSinkOptions{
+		KeepTemp:   true,
+		CreateTemp: func(string, string) (pipeline.TempFile, error) { return file, nil },
+	})
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v, want none for a cancellation", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+	if !file.closed.Load() {
+		t.Error("temporary file not closed")
+	}
+}
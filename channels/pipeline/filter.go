@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+
+	"channelspractice/chanutil"
+)
+
+// Filter forwards the items from in for which pred returns true. The returned
+// function reports how many items were dropped; its value is final once the
+// output channel has been closed.
+func Filter[T any](ctx context.Context, in <-chan T, pred func(T) bool, opts ...Option) (<-chan T, func() int) {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	var dropped atomic.Int64
+	go func() {
+		defer close(out)
+		for item := range OrDone(ctx, in) {
+			if !pred(item) {
+				dropped.Add(1)
+				continue
+			}
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out, func() int {
+		return int(dropped.Load())
+	}
+}
@@ -0,0 +1,40 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestFilter(t *testing.T) {
+	isEven := func(n int) bool { return n%2 == 0 }
+	for _, tc := range []struct {
+		name    string
+		pred    func(int) bool
+		want    []int
+		dropped int
+	}{
+		{"some dropped", isEven, []int{0, 2, 4, 6, 8}, 5},
+		{"all dropped", func(int) bool { return false }, nil, 10},
+		{"none dropped", func(int) bool { return true }, pipelinetest.Items(10), 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			out, dropped := pipeline.Filter(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), tc.pred)
+
+			got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if n := dropped(); n != tc.dropped {
+				t.Errorf("dropped %d, want %d", n, tc.dropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrorsSummary describes the errors FirstErrorFunc has seen: the root error
+// it forwarded and how many others it swallowed.
+type ErrorsSummary struct {
+	Root       error
+	Suppressed int
+}
+
+// IsCancellation reports whether err is only a consequence of cancellation,
+// which is what most stages of a failing pipeline report after the one that
+// actually failed.
+func IsCancellation(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+// FirstError is FirstErrorFunc with IsCancellation deciding which errors are
+// secondary.
+func FirstError(ctx context.Context, chans ...<-chan error) <-chan error {
+	out, _ := FirstErrorFunc(ctx, IsCancellation, chans...)
+	return out
+}
+
+// FirstErrorFunc forwards only the root error of chans: the first one that
+// secondary reports false for, or if there is none the first error of all.
+// Every other error is read and counted but dropped, so a pool of failing
+// workers is never left blocked on its error channel, not even after ctx is
+// cancelled, which only stops the root error from being sent. The returned
+// channel is closed once every input has been closed; the summary is complete
+// by then. A nil secondary treats every error alike.
+func FirstErrorFunc(ctx context.Context, secondary func(error) bool, chans ...<-chan error) (<-chan error, func() ErrorsSummary) {
+	if secondary == nil {
+		secondary = func(error) bool { return false }
+	}
+	// The root error is the only one ever sent, so it never waits for the
+	// reader.
+	out := make(chan error, 1)
+	all := make(chan error)
+
+	var wg sync.WaitGroup
+	wg.Add(len(chans))
+	for _, ch := range chans {
+		go func(ch <-chan error) {
+			defer wg.Done()
+			for err := range ch {
+				all <- err
+			}
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		close(all)
+	}()
+
+	var mu sync.Mutex
+	var summary ErrorsSummary
+	forward := func(err error) {
+		select {
+		case <-ctx.Done():
+		case out <- err:
+		}
+	}
+	go func() {
+		defer close(out)
+		var held error
+		for err := range all {
+			mu.Lock()
+			switch {
+			case summary.Root != nil:
+				summary.Suppressed++
+			case secondary(err):
+				if held == nil {
+					held = err
+				} else {
+					summary.Suppressed++
+				}
+			default:
+				summary.Root = err
+				if held != nil {
+					summary.Suppressed++
+				}
+				forward(err)
+			}
+			mu.Unlock()
+		}
+		if held != nil && summary.Root == nil {
+			mu.Lock()
+			summary.Root = held
+			mu.Unlock()
+			forward(held)
+		}
+	}()
+
+	return out, func() ErrorsSummary {
+		mu.Lock()
+		defer mu.Unlock()
+		return summary
+	}
+}
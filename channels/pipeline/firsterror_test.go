@@ -0,0 +1,111 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// fireErrors sends errs concurrently, each on a channel of its own, and
+// closes the channels once they have been read.
+func fireErrors(errs []error) []<-chan error {
+	chans := make([]<-chan error, len(errs))
+	start := make(chan struct{})
+	for i, err := range errs {
+		ch := make(chan error)
+		chans[i] = ch
+		go func() {
+			defer close(ch)
+			<-start
+			ch <- err
+		}()
+	}
+	close(start)
+	return chans
+}
+
+func TestFirstErrorDeliversRootOnly(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	root := errors.New("disk full")
+	errs := make([]error, 50)
+	for i := range errs {
+		errs[i] = fmt.Errorf("worker %d: %w", i, context.Canceled)
+	}
+	errs[37] = root
+
+	out, summary := pipeline.FirstErrorFunc(ctx, pipeline.IsCancellation, fireErrors(errs)...)
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if len(got) != 1 || got[0] != root {
+		t.Fatalf("delivered %v, want only the root error", got)
+	}
+	if s := summary(); s.Root != root || s.Suppressed != 49 {
+		t.Errorf("summary = %+v, want the root and 49 suppressed", s)
+	}
+}
+
+func TestFirstErrorOnlyCancellations(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make([]error, 10)
+	for i := range errs {
+		errs[i] = fmt.Errorf("worker %d: %w", i, context.Canceled)
+	}
+
+	out, summary := pipeline.FirstErrorFunc(ctx, pipeline.IsCancellation, fireErrors(errs)...)
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if len(got) != 1 || !errors.Is(got[0], context.Canceled) {
+		t.Fatalf("delivered %v, want one cancellation error", got)
+	}
+	if s := summary(); s.Root != got[0] || s.Suppressed != 9 {
+		t.Errorf("summary = %+v, want the delivered error and 9 suppressed", s)
+	}
+}
+
+func TestFirstErrorNilSecondary(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first := fmt.Errorf("worker: %w", context.Canceled)
+	ch := make(chan error, 2)
+	ch <- first
+	ch <- errors.New("disk full")
+	close(ch)
+
+	// Without a filter the cancellation counts as the root.
+	out, summary := pipeline.FirstErrorFunc(ctx, nil, ch)
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 1 || got[0] != first {
+		t.Fatalf("delivered %v, want the first error", got)
+	}
+	if s := summary(); s.Suppressed != 1 {
+		t.Errorf("summary = %+v, want 1 suppressed", s)
+	}
+}
+
+func TestFirstErrorKeepsDrainingAfterCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	ch := make(chan error)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ch)
+		for range 100 {
+			ch <- errors.New("failed")
+		}
+	}()
+
+	// Nobody reads out, yet the sender is never stuck.
+	pipeline.FirstError(ctx, ch)
+	wg.Wait()
+}
@@ -0,0 +1,489 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"channelspractice/chanutil"
+)
+
+// Flow is a pipeline under construction whose last stage emits T. Start one
+// with New or From, add stages with its methods, or Via, ViaPool and Batched
+// for stages that change the item type, and finish it with Sink:
+//
+//	_, err := pipeline.New(nums).
+//		ThenPool(4, transform).
+//		Sink(save).
+//		Run(ctx)
+//
+// Every method returns a new Flow and leaves the receiver unchanged.
+type Flow[T any] struct {
+	build func(produce, abort context.Context, errs *stageErrors) <-chan T
+	// nodes describes the stages so far for WriteDOT and sched for
+	// RunDeterministic.
+	nodes []dotNode
+	sched []schedStage
+}
+
+// then returns the nodes of f followed by a node of kind built with opts.
+func then[T any](f *Flow[T], kind string, workers int, opts []Option, errors bool) []dotNode {
+	return append(slices.Clip(f.nodes), newDotNode(fmt.Sprintf("n%d", len(f.nodes)), kind, workers, opts, errors))
+}
+
+// stageErrors holds the error channels of a run of a pipeline by the label
+// of their stage, the stages in the order items pass them for an ordered
+// shutdown, and the stats of its Report if one is collected.
+type stageErrors struct {
+	chans  map[string]<-chan error
+	labels map[string]bool
+	stages []*runStage
+	stats  *runStats
+}
+
+// runStage is a stage of a run of a pipeline, with the context only it runs
+// with so that it can be stopped on its own.
+type runStage struct {
+	label  string
+	kind   nodeKind
+	cancel context.CancelCauseFunc
+	// exited is closed once the stage closed its error channel. It is nil
+	// for a source, whose error channel, if any, may be its caller's.
+	exited chan struct{}
+}
+
+func newStageErrors(stats *runStats) *stageErrors {
+	return &stageErrors{chans: make(map[string]<-chan error), labels: make(map[string]bool), stats: stats}
+}
+
+// context returns the context of the stage labelled label, derived from
+// parent. Stages must ask for it in the order items pass them.
+func (errs *stageErrors) context(parent context.Context, label string, kind nodeKind) context.Context {
+	ctx, cancel := context.WithCancelCause(parent)
+	s := &runStage{label: label, kind: kind, cancel: cancel}
+	if kind != sourceNode {
+		s.exited = make(chan struct{})
+	}
+	errs.stages = append(errs.stages, s)
+	return ctx
+}
+
+// stop stops the stages with cause, starting with the sources: every stage is
+// cancelled only once the one before it has exited, so no stage is cancelled
+// while the stages feeding it still send to it. The sinks first get up to
+// grace, counted from when the first of them is reached, to finish whatever
+// reaches them before their input closes.
+func (errs *stageErrors) stop(cause error, grace time.Duration) {
+	if errs == nil {
+		return
+	}
+	var graceOver <-chan time.Time
+	for _, s := range errs.stages {
+		if s.kind == sinkNode && grace > 0 {
+			if graceOver == nil {
+				timer := time.NewTimer(grace)
+				defer timer.Stop()
+				graceOver = timer.C
+			}
+			select {
+			case <-s.exited:
+			case <-graceOver:
+			}
+		}
+		s.cancel(cause)
+		if s.exited != nil {
+			<-s.exited
+		}
+	}
+}
+
+// label returns the label of a stage called name, which is name numbered if
+// another stage has it already.
+func (errs *stageErrors) label(name string) string {
+	label := name
+	for i := 2; errs.labels[label]; i++ {
+		label = fmt.Sprintf("%s#%d", name, i)
+	}
+	errs.labels[label] = true
+	return label
+}
+
+// add adds the error channel of the stage labelled label.
+func (errs *stageErrors) add(label string, errc <-chan error) {
+	errs.chans[label] = errc
+}
+
+// merge merges the error channels with ctx as Merge does. An error that does
+// not say which stage it came from, such as one a source of From reports
+// as is, comes out as a *StageError of the stage whose channel it was on.
+// The channel of a stage that stop waits for is read to its end even once
+// ctx is done, to tell when the stage exited.
+func (errs *stageErrors) merge(ctx context.Context) <-chan error {
+	exited := make(map[string]chan struct{})
+	for _, s := range errs.stages {
+		if s.exited != nil {
+			exited[s.label] = s.exited
+		}
+	}
+	merged := make(chan error)
+	var wg sync.WaitGroup
+	for label, errc := range errs.chans {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs.forward(ctx, label, errc, merged)
+			if exited, ok := exited[label]; ok {
+				go func() {
+					defer close(exited)
+					for range errc {
+					}
+				}()
+			}
+		}()
+	}
+	// A stage without an error channel exits with nothing to tell.
+	for label, exited := range exited {
+		if _, ok := errs.chans[label]; !ok {
+			close(exited)
+		}
+	}
+	go func() {
+		defer close(merged)
+		wg.Wait()
+	}()
+	return merged
+}
+
+// forward sends the errors from the error channel of the stage labelled
+// label on merged until errc is closed or ctx is done.
+func (errs *stageErrors) forward(ctx context.Context, label string, errc <-chan error, merged chan<- error) {
+	for {
+		err, ok, recvErr := chanutil.RecvContext(ctx, errc)
+		if recvErr != nil || !ok {
+			return
+		}
+		var stageErr *StageError
+		var panicErr *PanicError
+		errs.stats.saw(label, err)
+		if !errors.As(err, &stageErr) && !errors.As(err, &panicErr) {
+			err = &StageError{Stage: label, Err: err}
+		}
+		if chanutil.SendContext(ctx, merged, err) != nil {
+			return
+		}
+	}
+}
+
+// New starts a flow with a Source emitting items.
+func New[T any](items []T, opts ...Option) *Flow[T] {
+	o := newOptions(opts)
+	name := o.stageName("source")
+	boxed := make([]any, len(items))
+	for i, item := range items {
+		boxed[i] = item
+	}
+	return &Flow[T]{build: func(produce, _ context.Context, errs *stageErrors) <-chan T {
+		label := errs.label(name)
+		return Source(errs.context(produce, label, sourceNode), items, errs.stats.instrument(label, false, opts)...)
+	}, nodes: []dotNode{newDotNode("n0", "source", 0, opts, false)},
+		sched: []schedStage{{name: name, kind: sourceNode, opts: o, items: boxed}}}
+}
+
+// From starts a flow with a source of its own, e.g. FromReader. The source is
+// given the context that stops it when the pipeline shuts down. Its error
+// channel may be nil.
+func From[T any](source func(ctx context.Context) (<-chan T, <-chan error)) *Flow[T] {
+	return &Flow[T]{build: func(produce, _ context.Context, errs *stageErrors) <-chan T {
+		label := errs.label("source")
+		out, errc := source(errs.context(produce, label, sourceNode))
+		if errc != nil {
+			errs.add(label, errc)
+		}
+		return out
+	}, nodes: []dotNode{newDotNode("n0", "source", 0, nil, true)},
+		sched: []schedStage{{name: "source", kind: sourceNode, unsupported: "a source of From"}}}
+}
+
+// Then adds a Stage that keeps the item type.
+func (f *Flow[T]) Then(fn func(context.Context, T) (T, error), opts ...Option) *Flow[T] {
+	return Via(f, fn, opts...)
+}
+
+// ThenPool adds a StagePool that keeps the item type.
+func (f *Flow[T]) ThenPool(workers int, fn func(context.Context, T) (T, error), opts ...Option) *Flow[T] {
+	return ViaPool(f, workers, fn, opts...)
+}
+
+// Sink finishes the flow with a Sink.
+func (f *Flow[T]) Sink(fn func(context.Context, T) error, opts ...Option) *Pipeline {
+	o := newOptions(opts)
+	name := o.stageName("sink")
+	return &Pipeline{build: func(produce, abort context.Context, stats *runStats) (<-chan struct{}, *stageErrors) {
+		errs := newStageErrors(stats)
+		in := f.build(produce, abort, errs)
+		label := errs.label(name)
+		done, errc := Sink(errs.context(abort, label, sinkNode), in, fn, errs.stats.instrument(label, true, opts)...)
+		errs.add(label, errc)
+		return done, errs
+	}, nodes: then(f, "sink", 1, opts, true), sched: thenSched(f, schedStage{
+		name: name, kind: sinkNode, opts: o,
+		fn: func(ctx context.Context, item any) (any, error) {
+			return nil, fn(ctx, item.(T))
+		},
+	})}
+}
+
+// SinkTo finishes the flow with a SinkTo writing to w.
+func (f *Flow[T]) SinkTo(w SinkWriter[T], opts ...Option) *Pipeline {
+	o := newOptions(opts)
+	name := o.stageName("sink")
+	return &Pipeline{build: func(produce, abort context.Context, stats *runStats) (<-chan struct{}, *stageErrors) {
+		errs := newStageErrors(stats)
+		in := f.build(produce, abort, errs)
+		label := errs.label(name)
+		done, errc := SinkTo(errs.context(abort, label, sinkNode), in, w, errs.stats.instrument(label, true, opts)...)
+		errs.add(label, errc)
+		return done, errs
+	}, nodes: then(f, "sink", 1, opts, true), sched: thenSched(f, schedStage{
+		name: name, kind: sinkNode, opts: o,
+		fn: func(ctx context.Context, item any) (any, error) {
+			return nil, w.Write(ctx, item.(T))
+		},
+		close: w.Close,
+	})}
+}
+
+// Via adds a Stage to f that may change the item type.
+func Via[T, U any](f *Flow[T], fn func(context.Context, T) (U, error), opts ...Option) *Flow[U] {
+	return ViaPool(f, 1, fn, opts...)
+}
+
+// Batched adds a Batch stage to f. It is a function rather than a method of
+// Flow because a method of Flow[T] cannot return a Flow[[]T].
+func Batched[T any](f *Flow[T], maxSize int, maxWait time.Duration, opts ...Option) *Flow[[]T] {
+	return &Flow[[]T]{build: func(produce, abort context.Context, errs *stageErrors) <-chan []T {
+		return Batch(abort, f.build(produce, abort, errs), maxSize, maxWait, opts...)
+	}, nodes: then(f, "batch", 0, opts, false), sched: thenSched(f, schedStage{kind: stageNode, unsupported: "Batched"})}
+}
+
+// ViaPool adds a StagePool to f that may change the item type.
+func ViaPool[T, U any](f *Flow[T], workers int, fn func(context.Context, T) (U, error), opts ...Option) *Flow[U] {
+	o := newOptions(opts)
+	name := o.stageName("stage")
+	return &Flow[U]{build: func(produce, abort context.Context, errs *stageErrors) <-chan U {
+		in := f.build(produce, abort, errs)
+		label := errs.label(name)
+		out, errc := StagePool(errs.context(abort, label, stageNode), in, workers, fn, errs.stats.instrument(label, true, opts)...)
+		errs.add(label, errc)
+		return out
+	}, nodes: then(f, "stage", workers, opts, true), sched: thenSched(f, schedStage{
+		name: name, kind: stageNode, workers: workers, opts: o,
+		fn: func(ctx context.Context, item any) (any, error) {
+			return fn(ctx, item.(T))
+		},
+	})}
+}
+
+// Pipeline is a finished Flow, ready to run.
+type Pipeline struct {
+	build   func(produce, abort context.Context, stats *runStats) (<-chan struct{}, *stageErrors)
+	onError func(error) error
+	// routes, fatalRoute and handleRoute are set by RouteErrors.
+	routes      []ErrorRoute
+	fatalRoute  string
+	handleRoute func(route string, err error)
+	nodes       []dotNode
+	sched       []schedStage
+}
+
+// OnError makes the pipeline pass every error of its stages to fn first. An
+// error fn returns nil for is dropped, e.g. a failed item that fn reported
+// under the SkipAndReport policy; any other error fails the pipeline.
+func (p *Pipeline) OnError(fn func(error) error) *Pipeline {
+	q := *p
+	q.onError = fn
+	return &q
+}
+
+// RouteErrors makes only the errors that take the route called fatal fail
+// the pipeline. Every error of its stages, after OnError if set, takes the
+// first of routes that matches it, or DefaultErrorRoute, as with the
+// RouteErrors function; an error on any other route is passed to handle,
+// if not nil, and dropped. handle is called for one error at a time, and
+// the pipeline's errors wait while it runs.
+func (p *Pipeline) RouteErrors(routes []ErrorRoute, fatal string, handle func(route string, err error)) *Pipeline {
+	q := *p
+	q.routes = append([]ErrorRoute{}, routes...)
+	q.fatalRoute, q.handleRoute = fatal, handle
+	return &q
+}
+
+// filter returns err if it fails the pipeline, or nil if OnError or
+// RouteErrors took it.
+func (p *Pipeline) filter(err error) error {
+	if p.onError != nil {
+		if err = p.onError(err); err == nil {
+			return nil
+		}
+	}
+	if p.routes == nil {
+		return err
+	}
+	if route := routeOf(p.routes, err); route != p.fatalRoute {
+		if p.handleRoute != nil {
+			p.handleRoute(route, err)
+		}
+		return nil
+	}
+	return err
+}
+
+// Start wires up the pipeline. It is a BuildFunc, so it can be handed to Run
+// for a pipeline that drains on shutdown.
+func (p *Pipeline) Start(produce, abort context.Context) (<-chan struct{}, <-chan error) {
+	done, errs, _ := p.start(produce, abort, nil)
+	return done, errs
+}
+
+// start is Start collecting the report of the run in stats, if not nil. It
+// also returns the stages of the run for an ordered shutdown.
+func (p *Pipeline) start(produce, abort context.Context, stats *runStats) (<-chan struct{}, <-chan error, *stageErrors) {
+	done, errs := p.build(produce, abort, stats)
+	merged := errs.merge(abort)
+	if p.onError == nil && p.routes == nil {
+		return done, merged, errs
+	}
+	filtered := make(chan error)
+	go func() {
+		defer close(filtered)
+		for err := range merged {
+			if err = p.filter(err); err == nil {
+				continue
+			}
+			select {
+			case <-abort.Done():
+			case filtered <- err:
+			}
+		}
+	}()
+	return done, filtered, errs
+}
+
+// Run runs the pipeline to completion and returns its first error, or
+// ctx.Err() if ctx was cancelled first, wrapping a sentinel as the Run
+// function describes. Either way it returns only after every stage has
+// exited, together with the Report of the run. Its stages are stopped in
+// order, from the source to the sinks, as RunWithOptions describes for an
+// abort.
+func (p *Pipeline) Run(ctx context.Context) (Report, error) {
+	stats := newRunStats()
+	err := runToCompletion(ctx, func(produce, abort context.Context) (<-chan struct{}, <-chan error, *stageErrors) {
+		return p.start(produce, abort, stats)
+	})
+	return stats.report(), err
+}
+
+// RunWithOptions runs the pipeline with the Run function, so that cancelling
+// ctx lets the items in flight drain, and returns the Report of the run with
+// its error. On a drain every stage stops once the one before it closed its
+// output, the source first. An abort stops the stages in the same order:
+// each is cancelled once the one before it exited, so that none of them is
+// cancelled while it is still sent to, and the sinks get up to
+// opts.SinkGrace to finish what reaches them before they are cancelled too.
+func (p *Pipeline) RunWithOptions(ctx context.Context, opts RunOptions) (Report, error) {
+	stats := newRunStats()
+	err := run(ctx, opts, func(produce, abort context.Context) (<-chan struct{}, <-chan error, *stageErrors) {
+		return p.start(produce, abort, stats)
+	})
+	return stats.report(), err
+}
+
+// RunCollectingErrors runs the pipeline to completion like Run but does not
+// stop at the first error. It collects every error the stages report, after
+// OnError and RouteErrors if set, and returns them joined with errors.Join once the sinks are
+// done, so errors.Is and errors.As see each of them. Beyond maxErrors, if
+// above 0, errors are only counted and reported as a final "and N more
+// errors". Without any error it returns ctx.Err().
+//
+// It suits stages under SkipAndReport. A stage that fails fast still stops,
+// and the stages feeding it are cancelled once the sinks are done.
+func (p *Pipeline) RunCollectingErrors(ctx context.Context, maxErrors int) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done, errcs := p.build(runCtx, runCtx, nil)
+	// Unlike in Start the errors are not merged with runCtx: cancelling it
+	// once the sinks are done must not drop the errors still on their way.
+	errs := errcs.merge(context.Background())
+
+	var collected []error
+	more := 0
+	for errs != nil {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			err = p.filter(err)
+			switch {
+			case err == nil:
+			case done == nil && IsCancellation(err):
+				// Only a consequence of the cancellation below.
+			case maxErrors > 0 && len(collected) >= maxErrors:
+				more++
+			default:
+				collected = append(collected, err)
+			}
+		case <-done:
+			done = nil
+			cancel()
+		}
+	}
+	if done != nil {
+		<-done
+	}
+	if more > 0 {
+		collected = append(collected, fmt.Errorf("and %d more errors", more))
+	}
+	if len(collected) == 0 {
+		return ctx.Err()
+	}
+	return errors.Join(collected...)
+}
+
+// runToCompletion starts the pipeline of start with a single context for
+// sources and stages alike and waits for it as Pipeline.Run describes.
+func runToCompletion(ctx context.Context, start orderedBuild) error {
+	runCtx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	done, errs, stages := start(runCtx, runCtx)
+	sinksDone := done
+	for {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				if sinksDone == nil {
+					teardown(cancel, nil, done, nil)
+					return stopped(ctx)
+				}
+				continue
+			}
+			shutdown(stages, 0, cancel, err, done, errs)
+			return failed(err)
+		case <-sinksDone:
+			// As in run, done is only a success once errs is closed.
+			sinksDone = nil
+			if errs == nil {
+				teardown(cancel, nil, done, nil)
+				return stopped(ctx)
+			}
+		case <-ctx.Done():
+			shutdown(stages, 0, cancel, context.Cause(ctx), done, errs)
+			return stopped(ctx)
+		}
+	}
+}
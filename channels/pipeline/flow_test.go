@@ -0,0 +1,149 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestFlowRunsToCompletion(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var saved [][]string
+	flow := pipeline.New(pipelinetest.Items(10)).
+		Then(double).
+		ThenPool(4, identity)
+	// ThenPool does not keep the order, so the strings are sorted below.
+	texts := pipeline.Via(flow, func(_ context.Context, n int) (string, error) {
+		return strconv.Itoa(n), nil
+	})
+	_, err := pipeline.Batched(texts, 4, time.Second).
+		Sink(func(_ context.Context, batch []string) error {
+			saved = append(saved, batch)
+			return nil
+		}).
+		Run(ctx)
+	if err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+
+	if len(saved) != 3 || len(saved[0]) != 4 || len(saved[2]) != 2 {
+		t.Errorf("saved batches %v, want 4, 4 and 2 items", saved)
+	}
+	got := slices.Sorted(slices.Values(slices.Concat(saved...)))
+	want := []string{"0", "10", "12", "14", "16", "18", "2", "4", "6", "8"}
+	if !slices.Equal(got, want) {
+		t.Errorf("saved %v, want %v", got, want)
+	}
+}
+
+func TestFlowStageError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var saved []int
+	_, err := pipeline.New(pipelinetest.Items(100)).
+		Then(failOnSix, pipeline.WithName("transform"), pipeline.WithBuffer(10)).
+		// A slow save keeps the items before the failure buffered, so the
+		// error is there long before the sink could finish.
+		Sink(func(_ context.Context, n int) error {
+			time.Sleep(5 * time.Millisecond)
+			saved = append(saved, n)
+			return nil
+		}).
+		Run(ctx)
+
+	var stageErr *pipeline.StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != "transform" || stageErr.Item != 6 {
+		t.Fatalf("Run = %v, want the transform error for 6", err)
+	}
+	if len(saved) > 6 {
+		t.Errorf("saved %v, want nothing past the failure", saved)
+	}
+}
+
+func TestFlowNamesStageOfPlainErrors(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCorrupt := errors.New("corrupt input")
+
+	_, err := pipeline.From(func(ctx context.Context) (<-chan int, <-chan error) {
+		errc := make(chan error, 1)
+		errc <- errCorrupt
+		close(errc)
+		return pipeline.Source(ctx, []int{1}), errc
+	}).
+		Then(double).
+		Sink(func(context.Context, int) error { return nil }).
+		Run(ctx)
+
+	var stageErr *pipeline.StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != "source" || !errors.Is(err, errCorrupt) {
+		t.Fatalf("Run = %v, want a *StageError of the source wrapping %v", err, errCorrupt)
+	}
+}
+
+func TestFlowKeepsStageErrors(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two stages with the default name, the second of which fails.
+	_, err := pipeline.New(pipelinetest.Items(10)).
+		Then(double).
+		Then(failOnSix).
+		Sink(func(context.Context, int) error { return nil }).
+		Run(ctx)
+
+	var stageErr *pipeline.StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != "stage" || errors.As(stageErr.Err, new(*pipeline.StageError)) {
+		t.Fatalf("Run = %v, want the stage's own *StageError, not wrapped again", err)
+	}
+}
+
+func TestFlowCancellation(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	saving := make(chan struct{})
+	var saved int
+	result := make(chan error, 1)
+	go func() {
+		_, err := pipeline.New(pipelinetest.Items(1000)).
+			ThenPool(4, double).
+			Sink(func(context.Context, int) error {
+				if saved++; saved == 1 {
+					close(saving)
+				}
+				time.Sleep(time.Millisecond)
+				return nil
+			}).
+			Run(ctx)
+		result <- err
+	}()
+	<-saving
+	cancel()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after the cancellation")
+	}
+	if saved == 1000 {
+		t.Error("every item was saved despite the cancellation")
+	}
+}
@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+
+	"channelspractice/semaphore"
+)
+
+// WithFlowControl bounds the envelopes in a pipeline at once to maxInFlight,
+// whatever the buffers between its stages: the SourceItems given it waits
+// before emitting an item, without missing a cancellation, until fewer than
+// maxInFlight of the items it emitted are still in the pipeline. An item
+// leaves the pipeline when the SinkItems given the same option acks it,
+// whether saving it succeeded or not, or when a StageItems or
+// StagePoolItems given it fails on it. Values of maxInFlight below 1 are
+// treated as 1.
+//
+// The returned Option holds the count, so the very same value must be handed
+// to the source, the sink and the stages, and to a single pipeline with a
+// single source. An item dropped in any other way, e.g. by a stage without
+// it, is never counted out and holds its room until the pipeline is done.
+// The Metrics of the source, if any, count the items in flight in InFlight
+// and MaxInFlight.
+func WithFlowControl(maxInFlight int) Option {
+	fc := &flowControl{credits: semaphore.New(max(maxInFlight, 1)), inFlight: make(map[int64]bool)}
+	return func(o *options) {
+		o.flow = fc
+	}
+}
+
+// flowControl counts the items in flight under WithFlowControl by the offset
+// their source gave them, so that an item that is counted out twice, e.g. by
+// the stage of a sink that failed on it and by its ack, only frees its
+// credit once.
+type flowControl struct {
+	credits *semaphore.Semaphore
+
+	mu       sync.Mutex
+	inFlight map[int64]bool
+	metrics  *Metrics
+}
+
+// acquire waits for a credit for the item at seq, counting it in m.
+func (f *flowControl) acquire(ctx context.Context, seq int64, m *Metrics) error {
+	if f == nil {
+		return nil
+	}
+	if err := f.credits.Acquire(ctx); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inFlight[seq] = true
+	f.metrics = m
+	m.inFlight(int64(len(f.inFlight)))
+	return nil
+}
+
+// release gives back the credit of the item at seq, if it holds one.
+func (f *flowControl) release(seq int64) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.inFlight[seq] {
+		return
+	}
+	delete(f.inFlight, seq)
+	f.metrics.inFlight(int64(len(f.inFlight)))
+	f.credits.Release()
+}
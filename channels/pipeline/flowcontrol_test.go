@@ -0,0 +1,132 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestFlowControlBoundsItemsInFlight(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	flow := pipeline.WithFlowControl(3)
+	var m pipeline.Metrics
+	// The buffers alone would let 20 items into the pipeline.
+	items := pipeline.SourceItems(ctx, pipelinetest.Items(40), nil, flow, pipeline.WithMetrics(&m), pipeline.WithBuffer(10))
+	doubled, stageErrs := pipeline.StageItems(ctx, items, double, flow, pipeline.WithBuffer(10))
+	var saved atomic.Int64
+	var overrun atomic.Int64
+	done, sinkErrs := pipeline.SinkItems(ctx, doubled, func(context.Context, int) error {
+		time.Sleep(time.Millisecond)
+		if inFlight := m.Out.Load() - saved.Load(); inFlight > 3 {
+			overrun.Store(inFlight)
+		}
+		saved.Add(1)
+		return nil
+	}, flow)
+
+	if err := pipeline.WaitAll(ctx, done, stageErrs, sinkErrs); err != nil {
+		t.Fatalf("pipeline failed: %v", err)
+	}
+	if saved.Load() != 40 {
+		t.Errorf("saved %d items, want 40", saved.Load())
+	}
+	if n := overrun.Load(); n > 0 {
+		t.Errorf("the source emitted %d items ahead of the sink, want at most 3", n)
+	}
+	if s := m.Snapshot(); s.MaxInFlight != 3 || s.InFlight != 0 {
+		t.Errorf("MaxInFlight %d and InFlight %d, want 3 and none once done", s.MaxInFlight, s.InFlight)
+	}
+}
+
+func TestFlowControlSinkErrorReleasesCredit(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	flow := pipeline.WithFlowControl(1)
+	acks := make(chan pipeline.Ack, 10)
+	var m pipeline.Metrics
+	items := pipeline.SourceItems(ctx, pipelinetest.Items(10), nil, flow, pipeline.WithMetrics(&m), pipeline.WithBuffer(10))
+	done, errc := pipeline.SinkItems(ctx, items, func(_ context.Context, n int) error {
+		if n == 1 {
+			return errBadRecord
+		}
+		return nil
+	}, flow, pipeline.WithAcks(acks))
+	errs := collectAsync(errc)
+
+	pipelinetest.AssertClosed(t, done, time.Second)
+	if got := <-errs; len(got) != 1 || !errors.Is(got[0], errBadRecord) {
+		t.Fatalf("sink errors %v, want the failure of 1", got)
+	}
+	if ack := <-acks; ack.Seq != 0 || ack.Err != nil {
+		t.Errorf("first ack %+v, want 0 saved", ack)
+	}
+	if ack := <-acks; ack.Seq != 1 || !errors.Is(ack.Err, errBadRecord) {
+		t.Errorf("second ack %+v, want the failure of 1", ack)
+	}
+	// The failed ack gave its credit back, so the source, with no one
+	// reading any more, went on with 2.
+	deadline := time.After(time.Second)
+	for m.Out.Load() < 3 {
+		select {
+		case <-deadline:
+			t.Fatalf("source emitted %d items, want 2 after the failure of 1", m.Out.Load())
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if s := m.Snapshot(); s.MaxInFlight != 1 || s.InFlight != 1 {
+		t.Errorf("MaxInFlight %d and InFlight %d, want 1 and 2 still in flight", s.MaxInFlight, s.InFlight)
+	}
+}
+
+func TestFlowControlStageFailureReleasesCredit(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	flow := pipeline.WithFlowControl(1)
+	items := pipeline.SourceItems(ctx, pipelinetest.Items(8), nil, flow)
+	checked, stageErrc := pipeline.StageItems(ctx, items, failBySeverity(-1), flow, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	stageErrs := collectAsync(stageErrc)
+	var mu sync.Mutex
+	var saved []int
+	done, sinkErrs := pipeline.SinkItems(ctx, checked, func(_ context.Context, n int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = append(saved, n)
+		return nil
+	}, flow)
+
+	if err := pipeline.WaitAll(ctx, done, sinkErrs); err != nil {
+		t.Fatalf("sink failed: %v", err)
+	}
+	if got := <-stageErrs; len(got) != 2 {
+		t.Errorf("%d stage errors, want the failures of 2 and 4", len(got))
+	}
+	if !slices.Equal(saved, []int{0, 1, 3, 5, 6, 7}) {
+		t.Errorf("saved %v, want every item that did not fail", saved)
+	}
+}
+
+func TestFlowControlWaitStopsOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	flow := pipeline.WithFlowControl(2)
+	items := pipeline.SourceItems(ctx, pipelinetest.Items(10), nil, flow, pipeline.WithBuffer(10))
+
+	// Nothing acks the first two, so the source waits for credit.
+	for range 2 {
+		<-items
+	}
+	assertNoItem(t, items)
+	cancel()
+	pipelinetest.AssertClosed(t, items, time.Second)
+}
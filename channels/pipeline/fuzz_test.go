@@ -0,0 +1,146 @@
+package pipeline_test
+
+// The fuzz targets below compare the combinators with a naive model of them.
+// go test runs their seed corpus; fuzz one at a time with e.g.
+//
+//	go test -run '^$' -fuzz '^FuzzBatch$' -fuzztime 10s ./pipeline
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// fuzzTimeout bounds every run so that a hang fails the input instead of
+// stalling the fuzzer.
+const fuzzTimeout = 5 * time.Second
+
+// fuzzItems turns fuzzed bytes into items.
+func fuzzItems(data []byte) []int {
+	items := make([]int, len(data))
+	for i, b := range data {
+		items[i] = int(b)
+	}
+	return items
+}
+
+// addSeeds adds the empty and single-item inputs, one with duplicates and one
+// with every byte value twice from the top down, each with args.
+func addSeeds(f *testing.F, args ...any) {
+	every := make([]byte, 512)
+	for i := range every {
+		every[i] = byte(255 - i/2)
+	}
+	for _, data := range [][]byte{{}, {7}, {3, 1, 3, 2, 1, 3, 4}, every} {
+		f.Add(append([]any{data}, args...)...)
+	}
+}
+
+func FuzzBatch(f *testing.F) {
+	for _, size := range []uint8{0, 1, 255} {
+		for _, wait := range []uint16{0, 1, 65535} {
+			addSeeds(f, size, wait)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte, size uint8, wait uint16) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		items := fuzzItems(data)
+		maxSize := int(size) + 1
+		// 0 stands for a batch that never times out, whose boundaries the
+		// model knows.
+		maxWait := time.Hour
+		if wait > 0 {
+			maxWait = time.Duration(wait) * time.Microsecond
+		}
+
+		batches := pipelinetest.CollectWithTimeout(t, pipeline.Batch(ctx, pipeline.Source(ctx, items), maxSize, maxWait), fuzzTimeout)
+		if wait == 0 {
+			want := slices.Collect(slices.Chunk(items, maxSize))
+			if !slices.EqualFunc(batches, want, slices.Equal) {
+				t.Fatalf("batches %v, want %v", batches, want)
+			}
+			return
+		}
+		for _, b := range batches {
+			if len(b) == 0 || len(b) > maxSize {
+				t.Fatalf("batch of %d items, want 1 to %d", len(b), maxSize)
+			}
+		}
+		if got := slices.Concat(batches...); !slices.Equal(got, items) {
+			t.Fatalf("batches hold %v, want %v in order", got, items)
+		}
+	})
+}
+
+func FuzzMergeSorted(f *testing.F) {
+	for _, inputs := range []uint8{0, 1, 255} {
+		addSeeds(f, inputs)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, inputs uint8) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		// Item i goes to input i % n, which is then sorted.
+		n := int(inputs)%16 + 1
+		split := make([][]int, n)
+		for i, item := range fuzzItems(data) {
+			split[i%n] = append(split[i%n], item)
+		}
+		chans := make([]<-chan int, n)
+		for i, items := range split {
+			slices.Sort(items)
+			chans[i] = pipeline.Source(ctx, items)
+		}
+		want := slices.Sorted(slices.Values(fuzzItems(data)))
+
+		if got := pipelinetest.CollectWithTimeout(t, pipeline.MergeSorted(ctx, intLess, chans...), fuzzTimeout); !slices.Equal(got, want) {
+			t.Fatalf("merged %v, want %v", got, want)
+		}
+	})
+}
+
+func FuzzDedup(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		items := fuzzItems(data)
+		var want []int
+		for _, item := range items {
+			if !slices.Contains(want, item) {
+				want = append(want, item)
+			}
+		}
+
+		if got := pipelinetest.CollectWithTimeout(t, pipeline.Dedup(ctx, pipeline.Source(ctx, items)), fuzzTimeout); !slices.Equal(got, want) {
+			t.Fatalf("Dedup = %v, want first occurrences %v", got, want)
+		}
+		// With room for every key DedupLRU never forgets one.
+		if got := pipelinetest.CollectWithTimeout(t, pipeline.DedupLRU(ctx, pipeline.Source(ctx, items), 256), fuzzTimeout); !slices.Equal(got, want) {
+			t.Fatalf("DedupLRU = %v, want first occurrences %v", got, want)
+		}
+	})
+}
+
+func FuzzTakeSkip(f *testing.F) {
+	for _, n := range []int16{-1, 0, 1, 3000} {
+		addSeeds(f, n)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, n int16) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		items := fuzzItems(data)
+		cut := min(max(int(n), 0), len(items))
+
+		if got := pipelinetest.CollectWithTimeout(t, pipeline.Take(ctx, pipeline.Source(ctx, items), int(n)), fuzzTimeout); !slices.Equal(got, items[:cut]) {
+			t.Fatalf("Take(%d) = %v, want %v", n, got, items[:cut])
+		}
+		if got := pipelinetest.CollectWithTimeout(t, pipeline.Skip(ctx, pipeline.Source(ctx, items), int(n)), fuzzTimeout); !slices.Equal(got, items[cut:]) {
+			t.Fatalf("Skip(%d) = %v, want %v", n, got, items[cut:])
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"channelspractice/chanutil"
+)
+
+// Every emits the current time once per interval until ctx is cancelled.
+// Ticks the consumer is too slow for are dropped, as with time.Ticker.
+func Every(ctx context.Context, interval time.Duration) <-chan time.Time {
+	out := make(chan time.Time)
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-ticker.C:
+				if chanutil.SendContext(ctx, out, t) != nil {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// GenerateFunc calls fn once per interval with 0, 1, 2, ... and emits what it
+// returns until fn reports false or ctx is cancelled. Cancellation closes the
+// channel right away instead of at the next tick, and the ticker stops as soon
+// as the channel closes either way.
+func GenerateFunc[T any](ctx context.Context, interval time.Duration, fn func(i int) (T, bool), opts ...Option) <-chan T {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	unregister := o.register("source")
+	name := o.stageName("source")
+	o.logStart(ctx, name)
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(out)
+		// Every only stops when its context does, so give it one that ends
+		// with this generator rather than with the caller's.
+		tickCtx, stop := context.WithCancel(ctx)
+		defer stop()
+		ticks := Every(tickCtx, interval)
+		for i := 0; ; i++ {
+			if _, ok := <-ticks; !ok {
+				return
+			}
+			item, ok := fn(i)
+			if !ok {
+				return
+			}
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Repeat emits values over and over, in order, until ctx is cancelled. It
+// never closes on its own, so bound it with Take and cancel ctx once done. An
+// empty Repeat emits nothing and closes when ctx is cancelled.
+func Repeat[T any](ctx context.Context, values ...T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		if len(values) == 0 {
+			<-ctx.Done()
+			return
+		}
+		for {
+			for _, v := range values {
+				if chanutil.SendContext(ctx, out, v) != nil {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// RepeatFunc emits what fn returns, calling it once per item, until ctx is
+// cancelled. Like Repeat it never closes on its own.
+func RepeatFunc[T any](ctx context.Context, fn func() T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			if chanutil.SendContext(ctx, out, fn()) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
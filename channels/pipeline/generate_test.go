@@ -0,0 +1,101 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestEveryStopsOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	ticks := pipeline.Every(ctx, time.Millisecond)
+	for range 3 {
+		<-ticks
+	}
+	cancel()
+	pipelinetest.CollectWithTimeout(t, ticks, time.Second)
+}
+
+func TestGenerateFuncStopsWhenFnReportsFalse(t *testing.T) {
+	// Never cancelled: the ticker has to stop with the generator.
+	pipelinetest.VerifyNoLeaks(t)
+	out := pipeline.GenerateFunc(context.Background(), time.Millisecond, func(i int) (int, bool) {
+		return i * i, i < 5
+	})
+
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{0, 1, 4, 9, 16}) {
+		t.Errorf("got %v, want [0 1 4 9 16]", got)
+	}
+}
+
+func TestGenerateFuncCancelClosesPromptly(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := pipeline.GenerateFunc(ctx, time.Hour, func(i int) (int, bool) { return i, true })
+
+	start := time.Now()
+	cancel()
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 0 {
+		t.Errorf("got %v before the first tick", got)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("output closed %v after cancel, want right away", elapsed)
+	}
+}
+
+func TestRepeatCycles(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	got := pipelinetest.CollectWithTimeout(t, pipeline.Take(ctx, pipeline.Repeat(ctx, 1, 2, 3), 10), time.Second)
+	if want := []int{1, 2, 3, 1, 2, 3, 1, 2, 3, 1}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestRepeatStopsAfterTake checks that the Repeat goroutine, blocked on a
+// send Take no longer reads, exits once ctx is cancelled.
+func TestRepeatStopsAfterTake(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	repeat := pipeline.Repeat(ctx, 7)
+	pipelinetest.CollectWithTimeout(t, pipeline.Take(ctx, repeat, 3), time.Second)
+	cancel()
+
+	// Repeat may still hand over the item it was blocked on.
+	pipelinetest.CollectWithTimeout(t, repeat, time.Second)
+}
+
+func TestRepeatEmpty(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := pipeline.Repeat[int](ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 0 {
+		t.Errorf("got %v from an empty Repeat", got)
+	}
+}
+
+func TestRepeatFunc(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int
+	out := pipeline.RepeatFunc(ctx, func() int {
+		calls++
+		return calls * calls
+	})
+
+	got := pipelinetest.CollectWithTimeout(t, pipeline.Take(ctx, out, 4), time.Second)
+	if want := []int{1, 4, 9, 16}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+}
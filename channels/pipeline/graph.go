@@ -0,0 +1,306 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type nodeKind int
+
+const (
+	sourceNode nodeKind = iota
+	stageNode
+	sinkNode
+)
+
+func (k nodeKind) String() string {
+	switch k {
+	case sourceNode:
+		return "source"
+	case stageNode:
+		return "stage"
+	default:
+		return "sink"
+	}
+}
+
+type graphNode struct {
+	name   string
+	kind   nodeKind
+	source func(ctx context.Context) (<-chan any, <-chan error)
+	stage  func(context.Context, any) (any, error)
+	sink   func(context.Context, any) error
+	opts   []Option
+
+	// outs and errsTo name the nodes fed by this node's items and errors.
+	outs   []string
+	errsTo []string
+	inputs int
+}
+
+// Graph is a pipeline whose nodes may have several inputs and outputs. Add
+// nodes by name, wire them with Connect and ConnectErrors, and run the whole
+// graph with Run or Start:
+//
+//	g := pipeline.NewGraph()
+//	g.AddSource("nums", nums)
+//	g.AddStage("transform", transform)
+//	g.AddSink("save", save)
+//	g.AddSink("audit", audit)
+//	g.Connect("nums", "transform")
+//	g.Connect("transform", "save")
+//	g.Connect("transform", "audit")
+//	err := g.Run(ctx)
+//
+// A node connected to several others sends every item to each of them, as
+// with TeeN; a node with several inputs reads them merged. Items travel as
+// any, so nodes assert the types they expect.
+type Graph struct {
+	nodes map[string]*graphNode
+	order []string
+	edges [][2]string
+	errs  [][2]string
+	err   error
+}
+
+// NewGraph returns an empty graph.
+func NewGraph() *Graph {
+	return &Graph{nodes: make(map[string]*graphNode)}
+}
+
+// AddSource adds a node that starts items flowing, e.g. Source or FromReader
+// wrapped to emit any. Its error channel may be nil.
+func (g *Graph) AddSource(name string, source func(ctx context.Context) (<-chan any, <-chan error)) {
+	g.add(&graphNode{name: name, kind: sourceNode, source: source})
+}
+
+// AddStage adds a node that runs fn on every item it receives, as Stage does.
+// The stage is named after the node unless opts name it otherwise.
+func (g *Graph) AddStage(name string, fn func(context.Context, any) (any, error), opts ...Option) {
+	g.add(&graphNode{name: name, kind: stageNode, stage: fn, opts: opts})
+}
+
+// AddSink adds a node that consumes every item it receives, as Sink does.
+func (g *Graph) AddSink(name string, fn func(context.Context, any) error, opts ...Option) {
+	g.add(&graphNode{name: name, kind: sinkNode, sink: fn, opts: opts})
+}
+
+func (g *Graph) add(n *graphNode) {
+	if _, ok := g.nodes[n.name]; ok {
+		g.fail(fmt.Errorf("%w: duplicate node %q", ErrInvalidGraph, n.name))
+		return
+	}
+	g.nodes[n.name] = n
+	g.order = append(g.order, n.name)
+}
+
+// Connect sends the items of node from to node to.
+func (g *Graph) Connect(from, to string) {
+	g.edges = append(g.edges, [2]string{from, to})
+}
+
+// ConnectErrors sends the errors of node from to node to as items. Errors
+// consumed this way no longer fail the graph, so pair it with the
+// SkipAndReport policy to keep a stage running past failed items.
+func (g *Graph) ConnectErrors(from, to string) {
+	g.errs = append(g.errs, [2]string{from, to})
+}
+
+func (g *Graph) fail(err error) {
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// Validate checks that every connection names existing nodes and runs
+// downstream, that every source and stage feeds another node, that every
+// stage and sink has an input, and that the graph has no cycles. The error
+// wraps ErrInvalidGraph.
+func (g *Graph) Validate() error {
+	_, err := g.plan()
+	return err
+}
+
+// plan resolves the connections and returns the nodes in an order where every
+// node comes after all of its inputs.
+func (g *Graph) plan() ([]*graphNode, error) {
+	if g.err != nil {
+		return nil, g.err
+	}
+	if len(g.nodes) == 0 {
+		return nil, fmt.Errorf("%w: no nodes", ErrInvalidGraph)
+	}
+	for _, n := range g.nodes {
+		n.outs, n.errsTo, n.inputs = nil, nil, 0
+	}
+	connect := func(from, to string) (*graphNode, *graphNode, error) {
+		src, ok := g.nodes[from]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: unknown node %q", ErrInvalidGraph, from)
+		}
+		dst, ok := g.nodes[to]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: unknown node %q", ErrInvalidGraph, to)
+		}
+		if dst.kind == sourceNode {
+			return nil, nil, fmt.Errorf("%w: %q connected to source %q", ErrInvalidGraph, from, to)
+		}
+		dst.inputs++
+		return src, dst, nil
+	}
+	for _, e := range g.edges {
+		src, _, err := connect(e[0], e[1])
+		if err != nil {
+			return nil, err
+		}
+		if src.kind == sinkNode {
+			return nil, fmt.Errorf("%w: sink %q connected to %q", ErrInvalidGraph, e[0], e[1])
+		}
+		src.outs = append(src.outs, e[1])
+	}
+	for _, e := range g.errs {
+		src, _, err := connect(e[0], e[1])
+		if err != nil {
+			return nil, err
+		}
+		src.errsTo = append(src.errsTo, e[1])
+	}
+
+	for _, name := range g.order {
+		n := g.nodes[name]
+		if n.kind != sinkNode && len(n.outs) == 0 {
+			return nil, fmt.Errorf("%w: %s %q is not connected to anything", ErrInvalidGraph, n.kind, name)
+		}
+		if n.kind != sourceNode && n.inputs == 0 {
+			return nil, fmt.Errorf("%w: %s %q has no input", ErrInvalidGraph, n.kind, name)
+		}
+	}
+
+	// Kahn's algorithm: whatever is left once no node is free of pending
+	// inputs sits on a cycle or downstream of one.
+	pending := make(map[string]int, len(g.nodes))
+	var ready, sorted []*graphNode
+	for _, name := range g.order {
+		n := g.nodes[name]
+		pending[name] = n.inputs
+		if n.inputs == 0 {
+			ready = append(ready, n)
+		}
+	}
+	for len(ready) > 0 {
+		n := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, n)
+		for _, to := range slices.Concat(n.outs, n.errsTo) {
+			if pending[to]--; pending[to] == 0 {
+				ready = append(ready, g.nodes[to])
+			}
+		}
+	}
+	if len(sorted) < len(g.nodes) {
+		var cycle []string
+		for _, name := range g.order {
+			if pending[name] > 0 {
+				cycle = append(cycle, fmt.Sprintf("%q", name))
+			}
+		}
+		return nil, fmt.Errorf("%w: cycle feeding %s", ErrInvalidGraph, strings.Join(cycle, ", "))
+	}
+	return sorted, nil
+}
+
+// Start validates and wires up the graph. It is a BuildFunc, so it can be
+// handed to Run for a graph that drains on shutdown; an invalid graph is
+// reported as its only error.
+func (g *Graph) Start(produce, abort context.Context) (<-chan struct{}, <-chan error) {
+	nodes, err := g.plan()
+	if err != nil {
+		// Close done only once the error has been taken so that a caller
+		// waiting on both cannot mistake the graph for a finished one.
+		done := make(chan struct{})
+		errc := make(chan error)
+		go func() {
+			defer close(done)
+			defer close(errc)
+			select {
+			case <-abort.Done():
+			case errc <- err:
+			}
+		}()
+		return done, errc
+	}
+
+	inputs := make(map[string][]<-chan any, len(nodes))
+	deliver := func(ch <-chan any, to []string) {
+		if len(to) == 1 {
+			inputs[to[0]] = append(inputs[to[0]], ch)
+			return
+		}
+		for i, out := range TeeN(abort, ch, len(to)) {
+			inputs[to[i]] = append(inputs[to[i]], out)
+		}
+	}
+
+	var dones []<-chan struct{}
+	var errs []<-chan error
+	for _, n := range nodes {
+		var in <-chan any
+		switch ins := inputs[n.name]; len(ins) {
+		case 0:
+		case 1:
+			in = ins[0]
+		default:
+			in = Merge(abort, ins...)
+		}
+
+		var out <-chan any
+		var errc <-chan error
+		opts := append([]Option{WithName(n.name)}, n.opts...)
+		switch n.kind {
+		case sourceNode:
+			out, errc = n.source(produce)
+		case stageNode:
+			out, errc = Stage(abort, in, n.stage, opts...)
+		case sinkNode:
+			var done <-chan struct{}
+			done, errc = Sink(abort, in, n.sink, opts...)
+			dones = append(dones, done)
+		}
+		if out != nil {
+			deliver(out, n.outs)
+		}
+		switch {
+		case len(n.errsTo) > 0:
+			if errc == nil {
+				// A source without errors still has to close its consumers'
+				// input.
+				closed := make(chan error)
+				close(closed)
+				errc = closed
+			}
+			deliver(Map(abort, errc, func(err error) any { return err }), n.errsTo)
+		case errc != nil:
+			errs = append(errs, errc)
+		}
+	}
+
+	// Unlike Merge, done must not close before every sink has exited.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, d := range dones {
+			<-d
+		}
+	}()
+	return done, Merge(abort, errs...)
+}
+
+// Run runs the graph to completion under a single context and returns its
+// first error, or ctx.Err() if ctx was cancelled first, wrapping a sentinel
+// as the Run function describes. Any error cancels every node. Either way it
+// returns only after every node has exited.
+func (g *Graph) Run(ctx context.Context) error {
+	return runToCompletion(ctx, unordered(g.Start))
+}
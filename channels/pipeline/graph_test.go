@@ -0,0 +1,192 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// anySource emits items as any, the way graph nodes pass them on.
+func anySource(items []int) func(ctx context.Context) (<-chan any, <-chan error) {
+	return func(ctx context.Context) (<-chan any, <-chan error) {
+		return pipeline.Map(ctx, pipeline.Source(ctx, items), func(n int) any { return n }), nil
+	}
+}
+
+func adding(d int) func(context.Context, any) (any, error) {
+	return func(_ context.Context, item any) (any, error) {
+		return item.(int) + d, nil
+	}
+}
+
+func TestGraphValidate(t *testing.T) {
+	nop := func(context.Context, any) error { return nil }
+	for _, tc := range []struct {
+		name  string
+		build func(g *pipeline.Graph)
+		want  string
+	}{
+		{"empty", func(*pipeline.Graph) {}, "no nodes"},
+		{"duplicate", func(g *pipeline.Graph) {
+			g.AddSink("save", nop)
+			g.AddSink("save", nop)
+		}, `duplicate node "save"`},
+		{"unknown", func(g *pipeline.Graph) {
+			g.AddSource("nums", anySource(nil))
+			g.Connect("nums", "save")
+		}, `unknown node "save"`},
+		{"dangling stage", func(g *pipeline.Graph) {
+			g.AddSource("nums", anySource(nil))
+			g.AddStage("transform", adding(1))
+			g.Connect("nums", "transform")
+		}, `stage "transform" is not connected to anything`},
+		{"sink without input", func(g *pipeline.Graph) {
+			g.AddSource("nums", anySource(nil))
+			g.AddSink("save", nop)
+			g.AddSink("audit", nop)
+			g.Connect("nums", "save")
+		}, `sink "audit" has no input`},
+		{"cycle", func(g *pipeline.Graph) {
+			g.AddSource("nums", anySource(nil))
+			g.AddStage("a", adding(1))
+			g.AddStage("b", adding(1))
+			g.AddSink("save", nop)
+			g.Connect("nums", "a")
+			g.Connect("a", "b")
+			g.Connect("b", "a")
+			g.Connect("b", "save")
+		}, `cycle feeding "a", "b", "save"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := pipeline.NewGraph()
+			tc.build(g)
+			err := g.Validate()
+			if !errors.Is(err, pipeline.ErrInvalidGraph) || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Validate = %v, want ErrInvalidGraph with %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestGraphRunInvalid(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	if err := pipeline.NewGraph().Run(context.Background()); !errors.Is(err, pipeline.ErrInvalidGraph) {
+		t.Errorf("Run = %v, want ErrInvalidGraph", err)
+	}
+}
+
+func TestGraphDiamond(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	var saved []int
+
+	// nums fans out to a and b, which both feed save.
+	g := pipeline.NewGraph()
+	g.AddSource("nums", anySource(pipelinetest.Items(50)))
+	g.AddStage("a", adding(100))
+	g.AddStage("b", adding(1000))
+	g.AddSink("save", func(_ context.Context, item any) error {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = append(saved, item.(int))
+		return nil
+	})
+	g.Connect("nums", "a")
+	g.Connect("nums", "b")
+	g.Connect("a", "save")
+	g.Connect("b", "save")
+	if err := g.Run(ctx); err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+
+	var want []int
+	for _, d := range []int{100, 1000} {
+		for n := range 50 {
+			want = append(want, n+d)
+		}
+	}
+	if slices.Sort(saved); !slices.Equal(saved, want) {
+		t.Errorf("saved %d items, want every item once through a and once through b", len(saved))
+	}
+}
+
+func TestGraphSinkErrorCancelsGraph(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var saved int
+
+	g := pipeline.NewGraph()
+	g.AddSource("nums", anySource(pipelinetest.Items(10000)))
+	g.AddStage("transform", adding(0))
+	g.AddSink("save", func(context.Context, any) error {
+		saved++
+		return nil
+	})
+	g.AddSink("audit", func(_ context.Context, item any) error {
+		if item.(int) == 3 {
+			return errors.New("audit log full")
+		}
+		return nil
+	})
+	g.Connect("nums", "transform")
+	g.Connect("transform", "save")
+	g.Connect("transform", "audit")
+
+	err := g.Run(ctx)
+	var stageErr *pipeline.StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != "audit" || stageErr.Item != 3 {
+		t.Fatalf("Run = %v, want the audit error for 3", err)
+	}
+	if saved == 10000 {
+		t.Error("save consumed every item although audit failed")
+	}
+}
+
+func TestGraphErrorsToAuditSink(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	var saved []int
+	var audited []error
+
+	g := pipeline.NewGraph()
+	g.AddSource("nums", anySource(pipelinetest.Items(10)))
+	g.AddStage("transform", func(ctx context.Context, item any) (any, error) {
+		return failOdd(ctx, item.(int))
+	}, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	g.AddSink("save", func(_ context.Context, item any) error {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = append(saved, item.(int))
+		return nil
+	})
+	g.AddSink("audit", func(_ context.Context, item any) error {
+		mu.Lock()
+		defer mu.Unlock()
+		audited = append(audited, item.(error))
+		return nil
+	})
+	g.Connect("nums", "transform")
+	g.Connect("transform", "save")
+	g.ConnectErrors("transform", "audit")
+
+	if err := g.Run(ctx); err != nil {
+		t.Fatalf("Run = %v, want the audited errors not to fail the graph", err)
+	}
+	if want := []int{0, 2, 4, 6, 8}; !slices.Equal(saved, want) {
+		t.Errorf("saved %v, want %v", saved, want)
+	}
+	if len(audited) != 5 {
+		t.Errorf("audited %v, want the 5 odd items", audited)
+	}
+}
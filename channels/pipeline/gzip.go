@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+)
+
+// gzipChunkSize is the size of the chunks GzipDecompress emits.
+const gzipChunkSize = 32 << 10
+
+// GzipCompress compresses the byte stream read from in with one gzip writer
+// of the given level, e.g. gzip.DefaultCompression, so the emitted chunks
+// concatenate into a single gzip stream. The stream is closed off with its
+// trailer once in is closed. A cancelled ctx stops the stage without a
+// trailer, leaving a truncated stream. Both channels are closed when the
+// stage exits.
+func GzipCompress(ctx context.Context, in <-chan []byte, level int, opts ...Option) (<-chan []byte, <-chan error) {
+	o := newOptions(opts)
+	out := make(chan []byte, o.buffer)
+	errc := make(chan error, 1)
+	unregister := o.register("stage")
+	name := o.stageName("gzip")
+	o.logStart(ctx, name)
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(out)
+
+		w := &chunkWriter{ctx: ctx, out: out}
+		zw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			errc <- &StageError{Stage: name, Err: err}
+			return
+		}
+		for chunk := range OrDone(ctx, in) {
+			o.metrics.itemIn()
+			if _, err := zw.Write(chunk); err != nil {
+				if ctx.Err() == nil {
+					errc <- &StageError{Stage: name, Err: fmt.Errorf("compress: %w", err)}
+				}
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err := zw.Close(); err != nil && ctx.Err() == nil {
+			errc <- &StageError{Stage: name, Err: fmt.Errorf("compress: %w", err)}
+		}
+	}()
+	return out, errc
+}
+
+// chunkWriter emits every write as a chunk of its own.
+type chunkWriter struct {
+	ctx context.Context
+	out chan<- []byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	select {
+	case <-w.ctx.Done():
+		return 0, w.ctx.Err()
+	case w.out <- bytes.Clone(p):
+		return len(p), nil
+	}
+}
+
+// GzipDecompress decompresses the gzip stream whose chunks are read from in
+// and emits the result in chunks of up to 32 KiB. A stream that ends early or
+// is corrupt fails the stage with the error of compress/gzip, e.g.
+// io.ErrUnexpectedEOF. Both channels are closed when the stage exits.
+func GzipDecompress(ctx context.Context, in <-chan []byte, opts ...Option) (<-chan []byte, <-chan error) {
+	o := newOptions(opts)
+	out := make(chan []byte, o.buffer)
+	errc := make(chan error, 1)
+	unregister := o.register("stage")
+	name := o.stageName("gunzip")
+	o.logStart(ctx, name)
+
+	// gzip.Reader pulls from an io.Reader, so the chunks are pushed through a
+	// pipe. Closing the pipe with an error unblocks a pending read.
+	pr, pw := io.Pipe()
+	go func() {
+		for chunk := range OrDone(ctx, in) {
+			o.metrics.itemIn()
+			if _, err := pw.Write(chunk); err != nil {
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			pw.CloseWithError(ctx.Err())
+			return
+		}
+		pw.Close()
+	}()
+
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(out)
+		// Stops the writer above if decompression ends before in does.
+		defer pr.Close()
+
+		fail := func(err error) {
+			if ctx.Err() == nil {
+				errc <- &StageError{Stage: name, Err: fmt.Errorf("decompress: %w", err)}
+			}
+		}
+		zr, err := gzip.NewReader(pr)
+		if err != nil {
+			fail(err)
+			return
+		}
+		for {
+			chunk := make([]byte, gzipChunkSize)
+			n, err := zr.Read(chunk)
+			if n > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case out <- chunk[:n]:
+				}
+			}
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				fail(err)
+				return
+			}
+		}
+	}()
+	return out, errc
+}
@@ -0,0 +1,126 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// randomBytes returns n bytes that compress somewhat, the same on every run.
+func randomBytes(n int) []byte {
+	r := rand.New(rand.NewPCG(uint64(n), 1))
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte('a' + r.IntN(8))
+	}
+	return data
+}
+
+// split cuts data into chunks of size bytes.
+func split(data []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := min(size, len(data))
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+func compress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, errc := pipeline.GzipCompress(ctx, pipeline.Source(ctx, split(data, 4096)), gzip.DefaultCompression)
+	compressed := bytes.Join(pipelinetest.CollectWithTimeout(t, out, 5*time.Second), nil)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Fatalf("compress errors: %v", errs)
+	}
+	return compressed
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 1000, 100 << 10, 1 << 20} {
+		t.Run(fmt.Sprintf("%d bytes", size), func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			data := randomBytes(size)
+			compressed := compress(t, data)
+
+			// compress/gzip reads the stream as it is.
+			zr, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plain, err := io.ReadAll(zr); err != nil || !bytes.Equal(plain, data) {
+				t.Fatalf("gzip.Reader got %d bytes, %v, want the %d bytes compressed", len(plain), err, len(data))
+			}
+
+			// Odd chunk sizes check the decompressor does not rely on them.
+			out, errc := pipeline.GzipDecompress(ctx, pipeline.Source(ctx, split(compressed, 777)))
+			plain := bytes.Join(pipelinetest.CollectWithTimeout(t, out, 5*time.Second), nil)
+			if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+				t.Fatalf("decompress errors: %v", errs)
+			}
+			if !bytes.Equal(plain, data) {
+				t.Errorf("round trip gave %d bytes, want the original %d", len(plain), len(data))
+			}
+		})
+	}
+}
+
+func TestGzipDecompressTruncated(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	compressed := compress(t, randomBytes(100<<10))
+
+	truncated := compressed[:len(compressed)/2]
+	out, errc := pipeline.GzipDecompress(ctx, pipeline.Source(ctx, split(truncated, 4096)))
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "gunzip" || !errors.Is(errs[0], io.ErrUnexpectedEOF) {
+		t.Errorf("errors = %v, want one gunzip error wrapping io.ErrUnexpectedEOF", errs)
+	}
+}
+
+func TestGzipDecompressNotGzip(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, errc := pipeline.GzipDecompress(ctx, pipeline.Source(ctx, [][]byte{[]byte("plain text, not gzip")}))
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 1 || !errors.Is(errs[0], gzip.ErrHeader) {
+		t.Errorf("errors = %v, want gzip.ErrHeader", errs)
+	}
+}
+
+func TestGzipCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	chunks := split(randomBytes(1<<20), 4096)
+	compressed, compressErrs := pipeline.GzipCompress(ctx, pipeline.Source(ctx, chunks), gzip.BestSpeed)
+	plain, plainErrs := pipeline.GzipDecompress(ctx, compressed)
+	<-plain
+	cancel()
+
+	pipelinetest.CollectWithTimeout(t, plain, time.Second)
+	// Cancellation is not a failure of either stage.
+	if errs := pipelinetest.CollectWithTimeout(t, plainErrs, time.Second); len(errs) != 0 {
+		t.Errorf("decompress errors after cancel: %v", errs)
+	}
+	if errs := pipelinetest.CollectWithTimeout(t, compressErrs, time.Second); len(errs) != 0 {
+		t.Errorf("compress errors after cancel: %v", errs)
+	}
+}
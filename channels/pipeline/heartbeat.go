@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"channelspractice/chanutil"
+)
+
+// Heartbeat is sent by a stage to show it is still making progress.
+type Heartbeat struct {
+	Stage     string
+	LastItem  time.Time
+	Processed int
+	// Stopped is set on the last heartbeat a stage sends before exiting.
+	Stopped bool
+}
+
+// StallEvent is reported by Watchdog for a stage that stopped sending
+// heartbeats.
+type StallEvent struct {
+	Stage         string
+	LastHeartbeat time.Time
+	LastItem      time.Time
+	Processed     int
+}
+
+// WithHeartbeat makes a stage send a Heartbeat on beats every interval while
+// it is waiting for input or for its consumer. A stage stuck inside its
+// function stops beating. Heartbeats are dropped when beats is full, so
+// several stages can share one buffered channel read by a Watchdog; give it
+// room for a beat from each of them, or a stage whose final beat is dropped
+// is reported as stalled. Pool workers beat individually as "name[id]".
+func WithHeartbeat(interval time.Duration, beats chan<- Heartbeat) Option {
+	return func(o *options) {
+		o.heartbeatInterval = interval
+		o.heartbeats = beats
+	}
+}
+
+type heartbeat struct {
+	stage     string
+	beats     chan<- Heartbeat
+	ticker    *time.Ticker
+	lastItem  time.Time
+	processed int
+}
+
+func (o options) newHeartbeat(stage string) *heartbeat {
+	if o.heartbeats == nil {
+		return nil
+	}
+	h := &heartbeat{stage: stage, beats: o.heartbeats, ticker: time.NewTicker(o.heartbeatInterval)}
+	h.beat()
+	return h
+}
+
+func (o options) newWorkerHeartbeat(kind string, workers, id int) *heartbeat {
+	name := o.stageName(kind)
+	if workers > 1 {
+		name = fmt.Sprintf("%s[%d]", name, id)
+	}
+	return o.newHeartbeat(name)
+}
+
+func (h *heartbeat) C() <-chan time.Time {
+	if h == nil {
+		return nil
+	}
+	return h.ticker.C
+}
+
+func (h *heartbeat) beat() {
+	select {
+	case h.beats <- h.snapshot(false):
+	default:
+	}
+}
+
+func (h *heartbeat) itemDone() {
+	if h == nil {
+		return
+	}
+	h.processed++
+	h.lastItem = time.Now()
+}
+
+// stop sends the final heartbeat so a watchdog forgets the stage instead of
+// reporting it as stalled. Like every other beat it is dropped when beats is
+// full, so an exiting stage never waits on a watchdog that has fallen behind.
+func (h *heartbeat) stop() {
+	if h == nil {
+		return
+	}
+	h.ticker.Stop()
+	select {
+	case h.beats <- h.snapshot(true):
+	default:
+	}
+}
+
+func (h *heartbeat) snapshot(stopped bool) Heartbeat {
+	return Heartbeat{Stage: h.stage, LastItem: h.lastItem, Processed: h.processed, Stopped: stopped}
+}
+
+func recvWithBeat[T any](ctx context.Context, h *heartbeat, in <-chan T) (T, bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			var zero T
+			return zero, false
+		case <-h.C():
+			h.beat()
+		case item, ok := <-in:
+			return item, ok
+		}
+	}
+}
+
+func sendWithBeat[T any](ctx context.Context, h *heartbeat, out chan<- T, item T) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-h.C():
+			h.beat()
+		case out <- item:
+			return true
+		}
+	}
+}
+
+// Watchdog reads heartbeats from hb and reports every stage that has not sent
+// one for stallAfter. A stage is reported once per stall and forgotten when
+// its final heartbeat arrives.
+func Watchdog(ctx context.Context, hb <-chan Heartbeat, stallAfter time.Duration) <-chan StallEvent {
+	out := make(chan StallEvent)
+	go func() {
+		defer close(out)
+
+		type stageState struct {
+			last     Heartbeat
+			at       time.Time
+			reported bool
+		}
+		stages := make(map[string]*stageState)
+		ticker := time.NewTicker(stallAfter / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case beat, ok := <-hb:
+				if !ok {
+					return
+				}
+				if beat.Stopped {
+					delete(stages, beat.Stage)
+					continue
+				}
+				stages[beat.Stage] = &stageState{last: beat, at: time.Now()}
+			case now := <-ticker.C:
+				names := make([]string, 0, len(stages))
+				for name := range stages {
+					names = append(names, name)
+				}
+				slices.Sort(names)
+				for _, name := range names {
+					s := stages[name]
+					if s.reported || now.Sub(s.at) < stallAfter {
+						continue
+					}
+					s.reported = true
+					event := StallEvent{Stage: name, LastHeartbeat: s.at, LastItem: s.last.LastItem, Processed: s.last.Processed}
+					if chanutil.SendContext(ctx, out, event) != nil {
+						return
+					}
+				}
+			}
+		}
+	}()
+	return out
+}
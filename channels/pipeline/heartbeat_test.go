@@ -0,0 +1,110 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestHeartbeatWhileIdleAndOnStop(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beats := make(chan pipeline.Heartbeat, 100)
+	in := make(chan int)
+	out, errc := pipeline.Stage(ctx, in, double, pipeline.WithName("double"), pipeline.WithHeartbeat(time.Millisecond, beats))
+	in <- 1
+	<-out
+	time.Sleep(20 * time.Millisecond)
+	close(in)
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	close(beats)
+
+	var idle int
+	var last pipeline.Heartbeat
+	for beat := range beats {
+		if beat.Stage != "double" {
+			t.Fatalf("beat from %q, want double", beat.Stage)
+		}
+		if !beat.Stopped {
+			idle++
+		}
+		last = beat
+	}
+	if idle < 2 {
+		t.Errorf("%d heartbeats while waiting for input, want several", idle)
+	}
+	if !last.Stopped || last.Processed != 1 || last.LastItem.IsZero() {
+		t.Errorf("final beat = %+v, want a stopped beat after 1 item", last)
+	}
+}
+
+func TestHeartbeatFinalBeatDoesNotBlock(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Nobody reads beats, so every heartbeat, the final one included, is
+	// dropped.
+	beats := make(chan pipeline.Heartbeat)
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, []int{1, 2}), func(context.Context, int) error { return nil },
+		pipeline.WithHeartbeat(time.Millisecond, beats))
+
+	start := time.Now()
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("sink took %v to exit with nobody reading heartbeats", elapsed)
+	}
+}
+
+func TestWatchdogReportsStall(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beats := make(chan pipeline.Heartbeat, 100)
+	stalls := pipeline.Watchdog(ctx, beats, 20*time.Millisecond)
+	release := make(chan struct{})
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), func(_ context.Context, n int) error {
+		if n == 5 {
+			<-release
+		}
+		return nil
+	}, pipeline.WithName("save"), pipeline.WithHeartbeat(2*time.Millisecond, beats))
+
+	select {
+	case stall := <-stalls:
+		// The sink only beats while waiting, which it may never have had to.
+		if stall.Stage != "save" || stall.Processed > 5 {
+			t.Errorf("stall = %+v, want save stuck on item 5", stall)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no stall reported")
+	}
+	close(release)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+	cancel()
+	pipelinetest.CollectWithTimeout(t, stalls, time.Second)
+}
+
+func TestWatchdogForgetsStoppedStage(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	beats := make(chan pipeline.Heartbeat, 2)
+	beats <- pipeline.Heartbeat{Stage: "save"}
+	beats <- pipeline.Heartbeat{Stage: "save", Stopped: true}
+	stalls := pipeline.Watchdog(ctx, beats, 10*time.Millisecond)
+
+	select {
+	case stall := <-stalls:
+		t.Errorf("stall reported for a stopped stage: %+v", stall)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(beats)
+	pipelinetest.CollectWithTimeout(t, stalls, time.Second)
+}
@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"channelspractice/semaphore"
+)
+
+// WithAttemptLimit makes Hedge take a slot of s for every attempt it runs.
+// Sharing s between the Hedge stages of a pipeline bounds the attempts
+// running across all of them.
+func WithAttemptLimit(s *semaphore.Semaphore) Option {
+	return func(o *options) {
+		o.attemptLimit = s
+	}
+}
+
+// Hedge is a Stage calling fn on every item from in, for a fn backed by
+// redundant services whose latency varies. If an attempt has not returned
+// after delay, Hedge starts another one next to it, and so on every delay
+// until maxAttempts run; an attempt that fails before starts the next one
+// right away. The first result without an error is sent on and the other
+// attempts are cancelled through their context. Only when every attempt
+// failed is the item reported, with the errors of all attempts joined.
+//
+// With WithAttemptLimit the first attempt of an item waits for a slot, while
+// a further one is only started when a slot is free, so that hedging never
+// holds up an item. The delays are timed by the clock set with WithClock; the
+// stage is named "hedge" by default. The error channel is closed once the
+// last cancelled attempt returned.
+func Hedge[T, U any](ctx context.Context, in <-chan T, fn func(context.Context, T) (U, error), delay time.Duration, maxAttempts int, opts ...Option) (<-chan U, <-chan error) {
+	o := newOptions(opts)
+	name := o.stageName("hedge")
+	maxAttempts = max(maxAttempts, 1)
+	limit := o.attemptLimit
+	// As in Mirror, the stage applies the timeout and the trace to the item
+	// as a whole.
+	inner := o
+	inner.itemTimeout = 0
+	inner.trace = nil
+	type result struct {
+		value   U
+		err     error
+		attempt int
+	}
+	var attempts sync.WaitGroup
+	hedged := func(itemCtx context.Context, item T) (U, error) {
+		var zero U
+		attemptCtx, cancel := context.WithCancel(itemCtx)
+		defer cancel()
+		// Losers send their results after the winner returned.
+		results := make(chan result, maxAttempts)
+		started := 0
+		start := func() {
+			started++
+			attempts.Add(1)
+			go func(attempt int) {
+				defer attempts.Done()
+				value, err := call(attemptCtx, inner, name, fn, item)
+				if limit != nil {
+					limit.Release()
+				}
+				results <- result{value: value, err: err, attempt: attempt}
+			}(started)
+		}
+		acquireAndStart := func() error {
+			if limit != nil {
+				if err := limit.Acquire(itemCtx); err != nil {
+					return err
+				}
+			}
+			start()
+			return nil
+		}
+
+		if err := acquireAndStart(); err != nil {
+			return zero, err
+		}
+		timer := o.clock.NewTimer(delay)
+		defer timer.Stop()
+		var errs []error
+		for {
+			var hedge <-chan time.Time
+			if started < maxAttempts {
+				hedge = timer.C()
+			}
+			select {
+			case <-itemCtx.Done():
+				return zero, itemCtx.Err()
+			case r := <-results:
+				if r.err == nil {
+					return r.value, nil
+				}
+				errs = append(errs, fmt.Errorf("attempt %d: %w", r.attempt, r.err))
+				if len(errs) < started {
+					continue
+				}
+				if started == maxAttempts {
+					return zero, errors.Join(errs...)
+				}
+				// Nothing is running, so there is no point in waiting.
+				if err := acquireAndStart(); err != nil {
+					return zero, err
+				}
+				timer.Reset(delay)
+			case <-hedge:
+				if limit == nil || limit.TryAcquire() {
+					start()
+				}
+				timer.Reset(delay)
+			}
+		}
+	}
+	out, stageErrc := Stage(ctx, in, hedged, append(opts, WithName(name))...)
+
+	errc := make(chan error, cap(stageErrc))
+	go func() {
+		defer close(errc)
+		for err := range stageErrc {
+			errc <- err
+		}
+		attempts.Wait()
+	}()
+	return out, errc
+}
@@ -0,0 +1,136 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+	"channelspractice/semaphore"
+)
+
+func TestHedgeFastAttemptWins(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	var calls atomic.Int32
+	slowCancelled := make(chan struct{})
+	fn := func(ctx context.Context, n int) (int, error) {
+		if calls.Add(1) == 1 {
+			<-ctx.Done()
+			close(slowCancelled)
+			return 0, ctx.Err()
+		}
+		return n * 10, nil
+	}
+	out, errc := pipeline.Hedge(ctx, pipeline.Source(ctx, []int{7}), fn, time.Second, 3, pipeline.WithClock(clock))
+
+	clock.BlockUntil(t, 1)
+	clock.Advance(time.Second)
+	if got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout); !slices.Equal(got, []int{70}) {
+		t.Errorf("got %v, want the fast attempt's [70]", got)
+	}
+	select {
+	case <-slowCancelled:
+	case <-time.After(pipelinetest.DefaultTimeout):
+		t.Fatal("the slow attempt was not cancelled")
+	}
+	if errs := pipelinetest.CollectWithTimeout(t, errc, pipelinetest.DefaultTimeout); len(errs) != 0 {
+		t.Errorf("errors %v, want none", errs)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d attempts, want 2", n)
+	}
+}
+
+func TestHedgeNoHedgeBeforeDelay(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls atomic.Int32
+	fn := func(ctx context.Context, n int) (int, error) {
+		calls.Add(1)
+		return double(ctx, n)
+	}
+	out, errc := pipeline.Hedge(ctx, pipeline.Source(ctx, pipelinetest.Items(5)), fn, time.Hour, 3)
+	if got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout); !slices.Equal(got, []int{0, 2, 4, 6, 8}) {
+		t.Errorf("got %v, want [0 2 4 6 8]", got)
+	}
+	pipelinetest.AssertClosed(t, errc, pipelinetest.DefaultTimeout)
+	if n := calls.Load(); n != 5 {
+		t.Errorf("%d attempts, want one per item", n)
+	}
+}
+
+func TestHedgeAllAttemptsFail(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls atomic.Int32
+	fn := func(context.Context, int) (int, error) {
+		return 0, fmt.Errorf("replica %d: %w", calls.Add(1), errDown)
+	}
+	out, errc := pipeline.Hedge(ctx, pipeline.Source(ctx, []int{1}), fn, time.Hour, 3,
+		pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+
+	if got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout); len(got) != 0 {
+		t.Errorf("got %v, want nothing", got)
+	}
+	errs := pipelinetest.CollectWithTimeout(t, errc, pipelinetest.DefaultTimeout)
+	if len(errs) != 1 || !errors.Is(errs[0], errDown) {
+		t.Fatalf("errors %v, want one joining the failed attempts", errs)
+	}
+	for _, replica := range []string{"attempt 1: replica 1", "attempt 2: replica 2", "attempt 3: replica 3"} {
+		if !strings.Contains(errs[0].Error(), replica) {
+			t.Errorf("error %q does not contain %q", errs[0], replica)
+		}
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d attempts, want 3", n)
+	}
+}
+
+func TestHedgeAttemptLimit(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	var calls atomic.Int32
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	fn := func(ctx context.Context, n int) (int, error) {
+		calls.Add(1)
+		started <- struct{}{}
+		<-release
+		return n, nil
+	}
+	// One slot: the first attempt holds it, so no hedge may start.
+	limit := semaphore.New(1)
+	out, errc := pipeline.Hedge(ctx, pipeline.Source(ctx, []int{3}), fn, time.Second, 3,
+		pipeline.WithClock(clock), pipeline.WithAttemptLimit(limit))
+	<-started
+	for range 3 {
+		clock.BlockUntil(t, 1)
+		clock.Advance(time.Second)
+	}
+	clock.BlockUntil(t, 1)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d attempts with the only slot taken, want 1", n)
+	}
+	close(release)
+
+	if got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout); !slices.Equal(got, []int{3}) {
+		t.Errorf("got %v, want [3]", got)
+	}
+	pipelinetest.AssertClosed(t, errc, pipelinetest.DefaultTimeout)
+	if !limit.TryAcquire() {
+		t.Error("the slot was not released")
+	}
+}
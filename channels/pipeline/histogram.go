@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// histogramSubBuckets is how many buckets every power of two is split into.
+// Reporting the middle of a bucket is then off by at most half its width,
+// 1/32 of the value.
+const histogramSubBuckets = 16
+
+// histogramBuckets covers every non-negative int64: the first
+// histogramSubBuckets values get a bucket each, then every power of two gets
+// histogramSubBuckets of them.
+const histogramBuckets = (64 - 4) * histogramSubBuckets
+
+// Histogram counts durations in a fixed set of log-linear buckets, in the
+// manner of an HDR histogram: a quantile is off by at most 1/32 of its value,
+// whatever the range of the durations, and recording never allocates. The zero
+// value is empty and ready to use, and a Histogram may be recorded into and
+// read concurrently.
+type Histogram struct {
+	buckets [histogramBuckets]atomic.Int64
+	count   atomic.Int64
+}
+
+// Record adds d to the histogram. Negative durations count as 0.
+func (h *Histogram) Record(d time.Duration) {
+	h.buckets[bucketOf(max(int64(d), 0))].Add(1)
+	h.count.Add(1)
+}
+
+// Count returns the number of durations recorded.
+func (h *Histogram) Count() int64 {
+	return h.count.Load()
+}
+
+// Quantile returns the duration below which the fraction q of the recorded
+// durations fall, such as 0.99 for the 99th percentile, or 0 if nothing was
+// recorded. It reports the middle of the bucket the quantile falls in.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	var counts [histogramBuckets]int64
+	var total int64
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(min(max(q, 0), 1) * float64(total)))
+	rank = max(rank, 1)
+	var seen int64
+	for i, n := range counts {
+		if seen += n; seen >= rank {
+			low, width := bucketBounds(i)
+			return time.Duration(low + width/2)
+		}
+	}
+	return 0
+}
+
+// bucketOf returns the bucket of v: its highest set bit picks the power of
+// two and the next 4 bits the bucket within it.
+func bucketOf(v int64) int {
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - 5
+	return (shift+1)*histogramSubBuckets + int(v>>shift)&(histogramSubBuckets-1)
+}
+
+// bucketBounds returns the lowest value of bucket i and how many values it
+// holds.
+func bucketBounds(i int) (low, width int64) {
+	if i < histogramSubBuckets {
+		return int64(i), 1
+	}
+	shift := i/histogramSubBuckets - 1
+	return int64(histogramSubBuckets+i%histogramSubBuckets) << shift, 1 << shift
+}
@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// StageInfo identifies the stage, and for a pool the worker, a hook runs
+// for. Stages and sinks run as worker 0 of 1.
+type StageInfo struct {
+	Stage   string
+	Worker  int
+	Workers int
+}
+
+// Hooks are called around the life of every worker of a Stage, StagePool or
+// Sink, e.g. to open a database connection per worker and close it again.
+// Any of them may be nil. StagePoolStateful hands such a per-worker resource
+// to fn as well.
+type Hooks struct {
+	// OnStart runs before a worker reads its first item. No worker of the
+	// stage reads any item before every OnStart has returned, and an error
+	// from any of them fails the stage like an item error would, wrapped in
+	// a *StageError.
+	OnStart func(ctx context.Context, info StageInfo) error
+	// OnStop runs exactly once when a worker exits, also after a failed
+	// OnStart, with the error that ended the stage: the stage's own failure,
+	// the cause of its context if it was cancelled, a *PanicError if fn
+	// panicked with WithoutPanicRecovery, or nil once in was drained.
+	OnStop func(info StageInfo, err error)
+	// OnItem runs after a worker is done with an item, whether fn succeeded
+	// or not.
+	OnItem func(info StageInfo, item any)
+}
+
+// WithHooks registers lifecycle hooks with a Stage, StagePool, StagePoolAuto
+// or Sink.
+func WithHooks(h Hooks) Option {
+	return func(o *options) {
+		o.hooks = h
+	}
+}
+
+func (o options) startHook(ctx context.Context, info StageInfo) error {
+	if o.hooks.OnStart == nil {
+		return nil
+	}
+	if err := o.hooks.OnStart(ctx, info); err != nil {
+		return &StageError{Stage: info.Stage, Err: fmt.Errorf("start worker %d: %w", info.Worker, err)}
+	}
+	return nil
+}
+
+// stopHook must be deferred directly so that it can see a panic, which it
+// reports to OnStop before letting it continue.
+func (o options) stopHook(info StageInfo, terminal func() error) {
+	if o.hooks.OnStop == nil {
+		return
+	}
+	if v := recover(); v != nil {
+		o.hooks.OnStop(info, &PanicError{Stage: info.Stage, Value: v, Stack: debug.Stack()})
+		panic(v)
+	}
+	o.hooks.OnStop(info, terminal())
+}
+
+// itemHook is generic so that item is only boxed for an OnItem hook.
+func itemHook[T any](o options, info StageInfo, item T) {
+	if o.hooks.OnItem != nil {
+		o.hooks.OnItem(info, item)
+	}
+}
@@ -0,0 +1,259 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// hookRecorder logs every hook call as a line such as "start 0/3",
+// "item 1/3 4" or "stop 2/3 <nil>".
+type hookRecorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *hookRecorder) record(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, fmt.Sprintf(format, args...))
+}
+
+func (r *hookRecorder) hooks(startErr func(pipeline.StageInfo) error) pipeline.Hooks {
+	return pipeline.Hooks{
+		OnStart: func(_ context.Context, info pipeline.StageInfo) error {
+			r.record("start %d/%d", info.Worker, info.Workers)
+			if startErr != nil {
+				return startErr(info)
+			}
+			return nil
+		},
+		OnStop: func(info pipeline.StageInfo, err error) {
+			r.record("stop %d/%d %v", info.Worker, info.Workers, err)
+		},
+		OnItem: func(info pipeline.StageInfo, item any) {
+			r.record("item %d/%d %v", info.Worker, info.Workers, item)
+		},
+	}
+}
+
+func (r *hookRecorder) count(prefix string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, c := range r.calls {
+		if strings.HasPrefix(c, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func double(_ context.Context, n int) (int, error) {
+	return n * 2, nil
+}
+
+func TestHooksStageOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var rec hookRecorder
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Stage(ctx, in, double, pipeline.WithHooks(rec.hooks(nil)))
+	})
+	if _, errs := pipelinetest.Run(t, stage, []int{1, 2, 3}); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+
+	want := []string{"start 0/1", "item 0/1 1", "item 0/1 2", "item 0/1 3", "stop 0/1 <nil>"}
+	if !slices.Equal(rec.calls, want) {
+		t.Errorf("calls = %q, want %q", rec.calls, want)
+	}
+}
+
+func TestHooksStagePoolPerWorker(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var rec hookRecorder
+	var startsBeforeItem atomic.Int64
+	startsBeforeItem.Store(-1)
+	hooks := rec.hooks(nil)
+	onItem := hooks.OnItem
+	hooks.OnItem = func(info pipeline.StageInfo, item any) {
+		startsBeforeItem.CompareAndSwap(-1, int64(rec.count("start")))
+		onItem(info, item)
+	}
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePool(ctx, in, 3, double, pipeline.WithHooks(hooks))
+	})
+	if _, errs := pipelinetest.Run(t, stage, pipelinetest.Items(10)); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+
+	if got := startsBeforeItem.Load(); got != 3 {
+		t.Errorf("%d workers started before the first item, want 3", got)
+	}
+	for id := range 3 {
+		for _, call := range []string{fmt.Sprintf("start %d/3", id), fmt.Sprintf("stop %d/3 <nil>", id)} {
+			if n := rec.count(call); n != 1 {
+				t.Errorf("%q called %d times, want 1", call, n)
+			}
+		}
+	}
+	if n := rec.count("item"); n != 10 {
+		t.Errorf("OnItem called %d times, want 10", n)
+	}
+	if last := rec.calls[len(rec.calls)-1]; !strings.HasPrefix(last, "stop") {
+		t.Errorf("last call = %q, want a stop", last)
+	}
+}
+
+func TestHooksSinkOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var rec hookRecorder
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, []int{1, 2}), func(context.Context, int) error { return nil },
+		pipeline.WithHooks(rec.hooks(nil)))
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	want := []string{"start 0/1", "item 0/1 1", "item 0/1 2", "stop 0/1 <nil>"}
+	if !slices.Equal(rec.calls, want) {
+		t.Errorf("calls = %q, want %q", rec.calls, want)
+	}
+}
+
+func TestHooksStopSeesStageError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var rec hookRecorder
+	boom := errors.New("boom")
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePool(ctx, in, 2, func(_ context.Context, n int) (int, error) {
+			if n == 3 {
+				return 0, boom
+			}
+			return n, nil
+		}, pipeline.WithHooks(rec.hooks(nil)))
+	})
+	_, errs := pipelinetest.Run(t, stage, pipelinetest.Items(10))
+	if len(errs) != 1 || !errors.Is(errs[0], boom) {
+		t.Fatalf("errors = %v, want boom", errs)
+	}
+
+	for id := range 2 {
+		call := fmt.Sprintf("stop %d/2 %v", id, errs[0])
+		if n := rec.count(call); n != 1 {
+			t.Errorf("%q called %d times, want 1; calls: %q", call, n, rec.calls)
+		}
+	}
+}
+
+func TestHooksStopSeesCancellation(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var rec hookRecorder
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	done, errc := pipeline.Sink(ctx, in, func(context.Context, int) error { return nil }, pipeline.WithHooks(rec.hooks(nil)))
+	in <- 1
+	cancel()
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if n := rec.count("stop 0/1 " + context.Canceled.Error()); n != 1 {
+		t.Errorf("calls = %q, want one stop with context.Canceled", rec.calls)
+	}
+}
+
+func TestHooksStartErrorFailsPool(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var rec hookRecorder
+	noDB := errors.New("no database")
+	var calls atomic.Int64
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePool(ctx, in, 3, func(_ context.Context, n int) (int, error) {
+			calls.Add(1)
+			return n, nil
+		}, pipeline.WithName("save"), pipeline.WithHooks(rec.hooks(func(info pipeline.StageInfo) error {
+			if info.Worker == 1 {
+				return noDB
+			}
+			return nil
+		})))
+	})
+	out, errs := pipelinetest.Run(t, stage, pipelinetest.Items(10))
+
+	if len(out) != 0 || calls.Load() != 0 {
+		t.Errorf("%d items processed and %d emitted after a failed start", calls.Load(), len(out))
+	}
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "save" || !errors.Is(errs[0], noDB) {
+		t.Fatalf("errors = %v, want one *StageError of save wrapping %v", errs, noDB)
+	}
+	if n := rec.count("stop"); n != 3 {
+		t.Errorf("OnStop called %d times, want 3", n)
+	}
+	if n := rec.count(fmt.Sprintf("stop 1/3 %v", errs[0])); n != 1 {
+		t.Errorf("calls = %q, want worker 1 stopped with its start error", rec.calls)
+	}
+}
+
+func TestHooksStartPanicFailsPool(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var rec hookRecorder
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePool(ctx, in, 3, double, pipeline.WithName("save"), pipeline.WithHooks(rec.hooks(func(info pipeline.StageInfo) error {
+			if info.Worker == 0 {
+				panic("no driver")
+			}
+			return nil
+		})))
+	})
+	// The other workers must not wait forever for worker 0 to start.
+	out, errs := pipelinetest.Run(t, stage, pipelinetest.Items(10))
+
+	if len(out) != 0 {
+		t.Errorf("%d items emitted after a panicking start", len(out))
+	}
+	var panicErr *pipeline.PanicError
+	if len(errs) != 1 || !errors.As(errs[0], &panicErr) || panicErr.Stage != "save" || panicErr.Value != "no driver" {
+		t.Fatalf("errors = %v, want one *PanicError of save", errs)
+	}
+	if n := rec.count("stop"); n != 3 {
+		t.Errorf("OnStop called %d times, want 3", n)
+	}
+}
+
+func TestHooksStartErrorFailsSink(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var rec hookRecorder
+	noDB := errors.New("no database")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var saved atomic.Int64
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, []int{1, 2}), func(context.Context, int) error {
+		saved.Add(1)
+		return nil
+	}, pipeline.WithHooks(rec.hooks(func(pipeline.StageInfo) error { return noDB })))
+
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || !errors.Is(errs[0], noDB) {
+		t.Fatalf("errors = %v, want one *StageError wrapping %v", errs, noDB)
+	}
+	if saved.Load() != 0 {
+		t.Errorf("sink saved %d items after a failed start", saved.Load())
+	}
+	if n := rec.count("stop"); n != 1 {
+		t.Errorf("OnStop called %d times, want 1", n)
+	}
+}
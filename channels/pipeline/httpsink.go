@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// HTTPSinkOptions configures SinkHTTP.
+type HTTPSinkOptions struct {
+	// Concurrency is the maximum number of requests in flight. Values below
+	// 1 are treated as 1.
+	Concurrency int
+	// Retry controls how failed requests are repeated. Without an
+	// IsRetryable predicate, 5xx responses and timeouts are retried.
+	Retry RetryOptions
+}
+
+// HTTPStatusError is returned for a response with a non-2xx status code.
+type HTTPStatusError struct {
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// SinkHTTP POSTs every item read from in to url as a JSON body. Items that
+// still fail after retrying are sent on the error channel as a *StageError and
+// the sink carries on with the next item. The done channel is closed once in
+// is closed or ctx is cancelled and every request in flight has finished. A
+// nil client means http.DefaultClient. Cancelling ctx aborts the requests in
+// flight and closes the idle connections of a client passed in, which the
+// shared http.DefaultClient is spared.
+func SinkHTTP[T any](ctx context.Context, in <-chan T, client *http.Client, url string, opts HTTPSinkOptions, pipelineOpts ...Option) (<-chan struct{}, <-chan error) {
+	pipelineOpts = append(pipelineOpts, WithName(newOptions(pipelineOpts).stageName("http")), WithErrorPolicy(SkipAndReport))
+	if client == nil {
+		client = http.DefaultClient
+	} else {
+		pipelineOpts = append(pipelineOpts, WithOnCancel(client.CloseIdleConnections))
+	}
+	retry := opts.Retry
+	if retry.IsRetryable == nil {
+		retry.IsRetryable = isRetryableHTTP
+	}
+	post := func(ctx context.Context, item T) (struct{}, error) {
+		body, err := json.Marshal(item)
+		if err != nil {
+			return struct{}{}, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return struct{}{}, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return struct{}{}, err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return struct{}{}, &HTTPStatusError{StatusCode: resp.StatusCode}
+		}
+		return struct{}{}, nil
+	}
+
+	out, errc := StagePool(ctx, in, max(opts.Concurrency, 1), Retry(post, retry), pipelineOpts...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range out {
+		}
+	}()
+	return done, errc
+}
+
+func isRetryableHTTP(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
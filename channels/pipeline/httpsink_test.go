@@ -0,0 +1,149 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// countingServer answers with status(attempt) to the attempt-th POST of a
+// body, counted from 1, and records the highest number of requests it saw in
+// flight.
+type countingServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	attempts map[string]int
+	inFlight atomic.Int32
+	maxSeen  atomic.Int32
+}
+
+func newCountingServer(t *testing.T, status func(attempt int) int) *countingServer {
+	s := &countingServer{attempts: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		for {
+			seen := s.maxSeen.Load()
+			if n <= seen || s.maxSeen.CompareAndSwap(seen, n) {
+				break
+			}
+		}
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.attempts[string(body)]++
+		attempt := s.attempts[string(body)]
+		s.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(status(attempt))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+var fastRetry = pipeline.RetryOptions{MaxAttempts: 3, InitialDelay: time.Millisecond}
+
+func TestSinkHTTPFlakyEndpoint(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := newCountingServer(t, func(attempt int) int {
+		if attempt <= 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+
+	done, errc := pipeline.SinkHTTP(ctx, pipeline.Source(ctx, pipelinetest.Items(6)), srv.Client(), srv.URL,
+		pipeline.HTTPSinkOptions{Concurrency: 2, Retry: fastRetry})
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	for body, n := range srv.attempts {
+		if n != 3 {
+			t.Errorf("item %s was posted %d times, want 3", body, n)
+		}
+	}
+	if len(srv.attempts) != 6 {
+		t.Errorf("%d items posted, want 6", len(srv.attempts))
+	}
+	if n := srv.maxSeen.Load(); n > 2 {
+		t.Errorf("%d requests in flight, want at most 2", n)
+	}
+}
+
+func TestSinkHTTPPermanentFailure(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := newCountingServer(t, func(int) int { return http.StatusInternalServerError })
+
+	done, errc := pipeline.SinkHTTP(ctx, pipeline.Source(ctx, []int{1, 2}), srv.Client(), srv.URL,
+		pipeline.HTTPSinkOptions{Concurrency: 2, Retry: fastRetry}, pipeline.WithName("post"))
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if len(errs) != 2 {
+		t.Fatalf("errors = %v, want one per item", errs)
+	}
+	for _, err := range errs {
+		var stageErr *pipeline.StageError
+		var statusErr *pipeline.HTTPStatusError
+		if !errors.As(err, &stageErr) || stageErr.Stage != "post" || stageErr.Item == nil ||
+			!errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+			t.Errorf("error %v, want a post StageError with the item and status 500", err)
+		}
+	}
+	if srv.attempts["1"] != 3 || srv.attempts["2"] != 3 {
+		t.Errorf("attempts %v, want 3 per item", srv.attempts)
+	}
+}
+
+func TestSinkHTTPClientErrorNotRetried(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := newCountingServer(t, func(int) int { return http.StatusBadRequest })
+
+	done, errc := pipeline.SinkHTTP(ctx, pipeline.Source(ctx, []int{1}), srv.Client(), srv.URL, pipeline.HTTPSinkOptions{Retry: fastRetry})
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 1 {
+		t.Errorf("errors = %v, want one", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+	if srv.attempts["1"] != 1 {
+		t.Errorf("posted %d times, want 1", srv.attempts["1"])
+	}
+}
+
+func TestSinkHTTPCancelInFlight(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{}, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client hanging up once the body
+		// has been read.
+		io.Copy(io.Discard, r.Body)
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	done, errc := pipeline.SinkHTTP(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), srv.Client(), srv.URL,
+		pipeline.HTTPSinkOptions{Concurrency: 4, Retry: fastRetry})
+	for range 4 {
+		<-started
+	}
+	cancel()
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+}
@@ -0,0 +1,204 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// IdempotencyStore remembers the keys of the items an Idempotent sink has
+// written.
+type IdempotencyStore interface {
+	// Seen reports whether key has been recorded.
+	Seen(key string) (bool, error)
+	// Record records key once its item has been written.
+	Record(key string) error
+}
+
+// Idempotent wraps sink so that it writes every item at most once, as told by
+// keyFn: an item whose key store has seen is skipped, and the key of an item
+// is recorded once sink wrote it without an error. A failed item is not
+// recorded, so retrying it, or replaying the stream, writes it again. Items
+// with the same key are written one at a time, so that a sink run by several
+// workers never writes two duplicates that arrive together. A crash between
+// writing an item and recording its key still writes it again on a replay.
+func Idempotent[T any](sink func(context.Context, T) error, keyFn func(T) string, store IdempotencyStore) func(context.Context, T) error {
+	var locks keyLocks
+	return func(ctx context.Context, item T) error {
+		key := keyFn(item)
+		if err := locks.lock(ctx, key); err != nil {
+			return err
+		}
+		defer locks.unlock(key)
+		seen, err := store.Seen(key)
+		if err != nil {
+			return fmt.Errorf("check idempotency key %q: %w", key, err)
+		}
+		if seen {
+			return nil
+		}
+		if err := sink(ctx, item); err != nil {
+			return err
+		}
+		if err := store.Record(key); err != nil {
+			return fmt.Errorf("record idempotency key %q: %w", key, err)
+		}
+		return nil
+	}
+}
+
+// keyLocks holds a lock for every key in use, dropping it once the last
+// holder or waiter let go of it.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	held chan struct{}
+	refs int
+}
+
+// lock waits until key is free or ctx is cancelled.
+func (l *keyLocks) lock(ctx context.Context, key string) error {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*keyLock)
+	}
+	k, ok := l.locks[key]
+	if !ok {
+		k = &keyLock{held: make(chan struct{}, 1)}
+		l.locks[key] = k
+	}
+	k.refs++
+	l.mu.Unlock()
+
+	select {
+	case k.held <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		l.release(key, k)
+		return ctx.Err()
+	}
+}
+
+func (l *keyLocks) unlock(key string) {
+	l.mu.Lock()
+	k := l.locks[key]
+	l.mu.Unlock()
+	<-k.held
+	l.release(key, k)
+}
+
+func (l *keyLocks) release(key string, k *keyLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if k.refs--; k.refs == 0 {
+		delete(l.locks, key)
+	}
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore keeping the keys in memory,
+// for a single run. The zero value is ready to use.
+type MemoryIdempotencyStore struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+// Seen reports whether key has been recorded.
+func (s *MemoryIdempotencyStore) Seen(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys[key], nil
+}
+
+// Record adds key.
+func (s *MemoryIdempotencyStore) Record(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[string]bool)
+	}
+	s.keys[key] = true
+	return nil
+}
+
+// FileIdempotencyStore is an IdempotencyStore keeping the keys in a file, one
+// quoted key per line, so that they outlive the process. The keys are held in
+// memory as well.
+type FileIdempotencyStore struct {
+	mu   sync.Mutex
+	f    *os.File
+	keys map[string]bool
+}
+
+// OpenFileIdempotencyStore opens the store in the file at path, creating the
+// file if it does not exist. A last line cut short by a crash is ignored: its
+// item was written but not recorded.
+func OpenFileIdempotencyStore(path string) (*FileIdempotencyStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileIdempotencyStore{f: f, keys: make(map[string]bool)}
+	r := bufio.NewReader(f)
+	var end int64
+	for {
+		line, err := r.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		key, err := strconv.Unquote(line[:len(line)-1])
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: bad key %q: %w", path, line, err)
+		}
+		s.keys[key] = true
+		end += int64(len(line))
+	}
+	// Records are appended after the last complete line.
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Seen reports whether key has been recorded, now or in an earlier run.
+func (s *FileIdempotencyStore) Seen(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys[key], nil
+}
+
+// Record appends key to the file and syncs it before it counts as seen.
+func (s *FileIdempotencyStore) Record(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.WriteString(strconv.Quote(key) + "\n"); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.keys[key] = true
+	return nil
+}
+
+// Close closes the file.
+func (s *FileIdempotencyStore) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,224 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// writeCounter is a sink counting how often it was given every item.
+type writeCounter struct {
+	mu     sync.Mutex
+	writes map[int]int
+	// delay is how long a write takes.
+	delay time.Duration
+}
+
+func (w *writeCounter) write(ctx context.Context, n int) error {
+	if w.delay > 0 {
+		time.Sleep(w.delay)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.writes == nil {
+		w.writes = make(map[int]int)
+	}
+	w.writes[n]++
+	return nil
+}
+
+// assertWrittenOnce checks that the sink saw each of items exactly once.
+func (w *writeCounter) assertWrittenOnce(t *testing.T, items []int) {
+	t.Helper()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.writes) != len(items) {
+		t.Errorf("wrote %d distinct items, want %d: %v", len(w.writes), len(items), w.writes)
+	}
+	for _, n := range items {
+		if w.writes[n] != 1 {
+			t.Errorf("wrote %d %d times, want once", n, w.writes[n])
+		}
+	}
+}
+
+func intKey(n int) string {
+	return fmt.Sprint(n)
+}
+
+// sinkAll runs fn as a Sink over items.
+func sinkAll(t *testing.T, items []int, fn func(context.Context, int) error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, items), fn)
+	if err := pipeline.WaitAll(ctx, done, errc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var sink writeCounter
+	write := pipeline.Idempotent(sink.write, intKey, &pipeline.MemoryIdempotencyStore{})
+
+	items := pipelinetest.Items(10)
+	sinkAll(t, items, write)
+	sinkAll(t, items, write)
+	sink.assertWrittenOnce(t, items)
+}
+
+func TestIdempotentPooledDuplicates(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	sink := writeCounter{delay: 5 * time.Millisecond}
+	write := pipeline.Idempotent(sink.write, intKey, &pipeline.MemoryIdempotencyStore{})
+
+	// Every item comes five times in a row, so the workers are handed its
+	// duplicates while it is still being written.
+	var items []int
+	for n := range 10 {
+		for range 5 {
+			items = append(items, n)
+		}
+	}
+	pool := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan struct{}, <-chan error) {
+		return pipeline.StagePool(ctx, in, 8, func(ctx context.Context, n int) (struct{}, error) {
+			return struct{}{}, write(ctx, n)
+		})
+	})
+	if _, errs := pipelinetest.Run(t, pool, items); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	sink.assertWrittenOnce(t, pipelinetest.Items(10))
+}
+
+func TestIdempotentFailedWriteIsRetried(t *testing.T) {
+	var sink writeCounter
+	fail := true
+	write := pipeline.Idempotent(func(ctx context.Context, n int) error {
+		if n == 3 && fail {
+			return errDown
+		}
+		return sink.write(ctx, n)
+	}, intKey, &pipeline.MemoryIdempotencyStore{})
+
+	ctx := context.Background()
+	for _, n := range []int{1, 2, 3} {
+		if err := write(ctx, n); (err != nil) != (n == 3) {
+			t.Errorf("write(%d) = %v", n, err)
+		}
+	}
+	fail = false
+	for _, n := range []int{1, 2, 3} {
+		if err := write(ctx, n); err != nil {
+			t.Errorf("replayed write(%d) = %v", n, err)
+		}
+	}
+	sink.assertWrittenOnce(t, []int{1, 2, 3})
+}
+
+// failingStore fails every call.
+type failingStore struct{}
+
+func (failingStore) Seen(string) (bool, error) { return false, errDown }
+func (failingStore) Record(string) error       { return errDown }
+
+func TestIdempotentStoreError(t *testing.T) {
+	var sink writeCounter
+	write := pipeline.Idempotent(sink.write, intKey, failingStore{})
+	if err := write(context.Background(), 1); !errors.Is(err, errDown) {
+		t.Errorf("write = %v, want the store error", err)
+	}
+	if len(sink.writes) != 0 {
+		t.Errorf("wrote %v despite the store failing", sink.writes)
+	}
+}
+
+func TestIdempotentCancelledWhileWaitingForKey(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	release := make(chan struct{})
+	write := pipeline.Idempotent(func(context.Context, int) error {
+		<-release
+		return nil
+	}, intKey, &pipeline.MemoryIdempotencyStore{})
+
+	first := make(chan error, 1)
+	go func() { first <- write(context.Background(), 1) }()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	// Give the first write time to take the key.
+	time.Sleep(5 * time.Millisecond)
+	if err := write(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("duplicate write = %v, want it to give up waiting with ctx", err)
+	}
+	close(release)
+	if err := <-first; err != nil {
+		t.Errorf("first write = %v", err)
+	}
+}
+
+func TestFileIdempotencyStoreOutlivesRun(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	path := filepath.Join(t.TempDir(), "keys")
+	var sink writeCounter
+	items := pipelinetest.Items(10)
+	for run, stream := range [][]int{items[:6], items} {
+		store, err := pipeline.OpenFileIdempotencyStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sinkAll(t, stream, pipeline.Idempotent(sink.write, intKey, store))
+		if err := store.Close(); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+	sink.assertWrittenOnce(t, items)
+}
+
+func TestFileIdempotencyStoreTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	// A crash cut the record of "c" short.
+	if err := os.WriteFile(path, []byte("\"a\"\n\"b\\n\"\n\"c"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := pipeline.OpenFileIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"a": true, "b\n": true, "c": false} {
+		if seen, err := store.Seen(key); err != nil || seen != want {
+			t.Errorf("Seen(%q) = %t, %v, want %t", key, seen, err, want)
+		}
+	}
+	if err := store.Record("d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\"a\"\n\"b\\n\"\n\"d\"\n"; string(data) != want {
+		t.Errorf("file %q, want %q with the torn line replaced", data, want)
+	}
+}
+
+func TestFileIdempotencyStoreBadLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pipeline.OpenFileIdempotencyStore(path); err == nil {
+		t.Error("opened a store with an unquoted key")
+	}
+}
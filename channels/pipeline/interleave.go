@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+
+	"channelspractice/chanutil"
+)
+
+// Strategy decides the order in which Interleave serves its sources.
+type Strategy struct {
+	weights []int
+}
+
+// RoundRobin serves the sources one item each in turn.
+func RoundRobin() Strategy {
+	return Strategy{}
+}
+
+// Weighted serves source i up to weights[i] items in a row in its turn, so
+// that sources that always have items ready are read in the ratio of their
+// weights. Sources without a weight, or with one below 1, get 1.
+func Weighted(weights ...int) Strategy {
+	return Strategy{weights: append([]int(nil), weights...)}
+}
+
+func (s Strategy) weight(i int) int {
+	if i < len(s.weights) && s.weights[i] > 0 {
+		return s.weights[i]
+	}
+	return 1
+}
+
+// Interleave combines sources into one channel in the order strategy sets,
+// unlike Merge, which forwards whatever comes first. A source whose turn it
+// is but that has no item ready is skipped rather than waited for; when none
+// has one Interleave waits for the first that does. A closed source drops out
+// of the rotation. The returned channel is closed once every source has been
+// closed or ctx is cancelled.
+func Interleave[T any](ctx context.Context, strategy Strategy, sources ...<-chan T) <-chan T {
+	out := make(chan T)
+	inputs := append([]<-chan T(nil), sources...)
+
+	go func() {
+		defer close(out)
+		open := len(inputs)
+		for _, ch := range inputs {
+			if ch == nil {
+				open--
+			}
+		}
+		// turn is the source being served and used how many items it got
+		// in this turn.
+		turn, used := 0, 0
+		advance := func() {
+			turn, used = (turn+1)%len(inputs), 0
+		}
+
+		next := func() (int, T, bool) {
+			for n := range len(inputs) {
+				i := (turn + n) % len(inputs)
+				if inputs[i] == nil {
+					continue
+				}
+				select {
+				case item, ok := <-inputs[i]:
+					return i, item, ok
+				default:
+				}
+			}
+			cases := make([]reflect.SelectCase, 0, len(inputs)+1)
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+			for _, ch := range inputs {
+				c := reflect.SelectCase{Dir: reflect.SelectRecv}
+				if ch != nil {
+					c.Chan = reflect.ValueOf(ch)
+				}
+				cases = append(cases, c)
+			}
+			chosen, value, ok := reflect.Select(cases)
+			if chosen == 0 {
+				var zero T
+				return -1, zero, false
+			}
+			var item T
+			if ok {
+				item, _ = value.Interface().(T)
+			}
+			return chosen - 1, item, ok
+		}
+
+		for open > 0 {
+			i, item, ok := next()
+			if i < 0 {
+				return
+			}
+			if i != turn {
+				// The sources in between had nothing ready.
+				turn, used = i, 0
+			}
+			if !ok {
+				inputs[i] = nil
+				open--
+				advance()
+				continue
+			}
+			if used++; used >= strategy.weight(i) {
+				advance()
+			}
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,83 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// ready returns a closed channel holding items.
+func ready(items ...int) <-chan int {
+	ch := make(chan int, len(items))
+	for _, item := range items {
+		ch <- item
+	}
+	close(ch)
+	return ch
+}
+
+func TestInterleaveRoundRobin(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := pipeline.Interleave(ctx, pipeline.RoundRobin(), ready(1, 2, 3, 4), ready(10, 20), ready(100, 200, 300))
+
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	// Each source drops out of the rotation once it is done.
+	if want := []int{1, 10, 100, 2, 20, 200, 3, 300, 4}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestInterleaveWeighted(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := pipeline.Interleave(ctx, pipeline.Weighted(3, 1, 2),
+		filled(300, 0), filled(300, 1), filled(300, 2))
+
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if len(got) != 900 {
+		t.Fatalf("got %d items, want 900", len(got))
+	}
+	if want := []int{0, 0, 0, 1, 2, 2, 0, 0, 0, 1, 2, 2}; !slices.Equal(got[:12], want) {
+		t.Errorf("got %v first, want %v", got[:12], want)
+	}
+	// While all three have items they are read in the ratio 3:1:2.
+	counts := make([]int, 3)
+	for _, v := range got[:600] {
+		counts[v]++
+	}
+	if counts[0] != 300 || counts[1] != 100 || counts[2] != 200 {
+		t.Errorf("first 600 items split %v, want [300 100 200]", counts)
+	}
+}
+
+func TestInterleaveSkipsStalledSource(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	stalled := make(chan int)
+	out := pipeline.Interleave(ctx, pipeline.RoundRobin(), stalled, ready(1, 2, 3))
+
+	for _, want := range []int{1, 2, 3} {
+		select {
+		case got := <-out:
+			if got != want {
+				t.Fatalf("got %d, want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no item %d while a source is stalled", want)
+		}
+	}
+	// Cancelled while the stalled source is all that is left, it stops.
+	cancel()
+	pipelinetest.AssertClosed(t, out, time.Second)
+}
+
+func TestInterleaveNoSources(t *testing.T) {
+	pipelinetest.AssertClosed(t, pipeline.Interleave[int](context.Background(), pipeline.RoundRobin()), time.Second)
+}
@@ -0,0 +1,223 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Item is an envelope that carries a value through the pipeline together with
+// a context of its own, e.g. one holding a trace span created by the source,
+// the offset of the value in the source's input, the time the source
+// emitted it and, with WithItemDeadline, the time it is no longer wanted.
+// Requeues counts how often Requeue fed the item back after it failed, and
+// Meta holds what the source and the stages attached to it, see AddMeta.
+type Item[T any] struct {
+	Ctx      context.Context
+	Value    T
+	Offset   int64
+	Emitted  time.Time
+	Deadline time.Time
+	Requeues int
+	Meta     Meta
+
+	// sent is when the last stage handed the item on, see WithSlowWarning.
+	sent time.Time
+	// budget is the WithItemDeadline the source set Deadline with.
+	budget time.Duration
+}
+
+func (i Item[T]) sentAt() time.Time {
+	return i.sent
+}
+
+func (i Item[T]) deadline() (int64, time.Time, time.Duration) {
+	return i.Offset, i.Deadline, i.budget
+}
+
+func (i Item[T]) meta() Meta {
+	return i.Meta
+}
+
+// String formats the value, followed by the Meta in brackets if there is
+// any, so that the errors of the item name it.
+func (i Item[T]) String() string {
+	if i.Meta.Len() == 0 {
+		return fmt.Sprint(i.Value)
+	}
+	return fmt.Sprintf("%v [%s]", i.Value, i.Meta)
+}
+
+// LogValue logs the value alone; the logs of the stages add the Meta as a
+// group of its own.
+func (i Item[T]) LogValue() slog.Value {
+	return slog.AnyValue(i.Value)
+}
+
+// WithTraceHooks registers hooks that the envelope variants (SourceItems,
+// StageItems, StagePoolItems, BatchItems and SinkItems) call with the item's
+// own context around the work they do for every item.
+func WithTraceHooks(onStageStart, onStageEnd func(ctx context.Context, stage string, item any)) Option {
+	return func(o *options) {
+		o.onStageStart = onStageStart
+		o.onStageEnd = onStageEnd
+	}
+}
+
+func (o options) traceStart(ctx context.Context, stage string, item any) {
+	if o.onStageStart != nil {
+		o.onStageStart(ctx, stage, item)
+	}
+}
+
+func (o options) traceEnd(ctx context.Context, stage string, item any) {
+	if o.onStageEnd != nil {
+		o.onStageEnd(ctx, stage, item)
+	}
+}
+
+// SourceItems is Source for envelopes. newCtx derives the context of every
+// item from ctx; a nil newCtx gives every item ctx itself. With
+// WithItemDeadline it stamps every item with its deadline, with WithItemMeta
+// it attaches its Meta, and with WithFlowControl it waits for room in the
+// pipeline before it emits an item.
+func SourceItems[T any](ctx context.Context, items []T, newCtx func(context.Context, T) context.Context, opts ...Option) <-chan Item[T] {
+	o := newOptions(opts)
+	name := o.stageName("source")
+	envelopes := make([]Item[T], len(items))
+	for i, value := range items {
+		itemCtx := ctx
+		if newCtx != nil {
+			itemCtx = newCtx(ctx, value)
+		}
+		envelopes[i] = Item[T]{Ctx: itemCtx, Value: value, Offset: int64(i)}
+		if o.itemMeta != nil {
+			envelopes[i].Meta = o.itemMeta(int64(i), value)
+		}
+	}
+	return source(ctx, envelopes, o, func(it Item[T]) (Item[T], bool) {
+		if o.flow.acquire(ctx, it.Offset, o.metrics) != nil {
+			return it, false
+		}
+		o.traceStart(it.Ctx, name, it.Value)
+		o.traceEnd(it.Ctx, name, it.Value)
+		it.Emitted = time.Now()
+		it.sent = it.Emitted
+		if o.itemDeadline > 0 {
+			it.Deadline = o.clock.Now().Add(o.itemDeadline)
+			it.budget = o.itemDeadline
+		}
+		return it, true
+	})
+}
+
+// StageItems is Stage for envelopes. fn receives a context that carries the
+// values of the item's context and is cancelled with the stage, and from
+// which MetaFrom and AddMeta read and add to the item's Meta.
+func StageItems[T, U any](ctx context.Context, in <-chan Item[T], fn func(context.Context, T) (U, error), opts ...Option) (<-chan Item[U], <-chan error) {
+	return StagePoolItems(ctx, in, 1, fn, opts...)
+}
+
+// StagePoolItems is StagePool for envelopes, see StageItems.
+func StagePoolItems[T, U any](ctx context.Context, in <-chan Item[T], workers int, fn func(context.Context, T) (U, error), opts ...Option) (<-chan Item[U], <-chan error) {
+	o := newOptions(opts)
+	out, _, errc := stagePool(ctx, in, workers, liftItem(fn, o, o.stageName("stage")), o, false)
+	return out, errc
+}
+
+// BatchItems is Batch for envelopes. The start hook fires when an item joins
+// a batch and the end hook when its batch has been handed over.
+func BatchItems[T any](ctx context.Context, in <-chan Item[T], maxSize int, maxWait time.Duration, opts ...Option) <-chan []Item[T] {
+	o := newOptions(opts)
+	name := o.stageName("batch")
+	onAdd := func(it Item[T]) {
+		o.traceStart(it.Ctx, name, it.Value)
+	}
+	onFlush := func(batch []Item[T]) {
+		for _, it := range batch {
+			o.traceEnd(it.Ctx, name, it.Value)
+		}
+	}
+	return batch(ctx, in, fixedSize(maxSize), maxWait, o, onAdd, onFlush)
+}
+
+// SinkItems is Sink for envelopes, see StageItems. With WithCheckpoint it
+// records the offsets of the items it completes, and with WithAcks it acks
+// every item by its offset.
+func SinkItems[T any](ctx context.Context, in <-chan Item[T], fn func(context.Context, T) error, opts ...Option) (<-chan struct{}, <-chan error) {
+	o := newOptions(opts)
+	liftedFn := liftItem(func(ctx context.Context, value T) (struct{}, error) {
+		return struct{}{}, fn(ctx, value)
+	}, o, o.stageName("sink"))
+	lifted := func(ctx context.Context, it Item[T]) (Item[struct{}], error) {
+		done, err := liftedFn(ctx, it)
+		o.ack(ctx, it.Offset, err)
+		return done, err
+	}
+	if o.checkpoint == nil {
+		return Sink(ctx, in, func(ctx context.Context, it Item[T]) error {
+			if _, err := lifted(ctx, it); err != nil {
+				return err
+			}
+			o.metrics.latency(it.Emitted)
+			return nil
+		}, opts...)
+	}
+
+	cp, err := newCheckpointer(o.checkpoint, o.checkpointEvery)
+	if err != nil {
+		done := make(chan struct{})
+		errc := make(chan error, 1)
+		errc <- &StageError{Stage: o.stageName("sink"), Err: err}
+		close(errc)
+		close(done)
+		return done, errc
+	}
+	_, sinkErrc := Sink(ctx, in, func(ctx context.Context, it Item[T]) error {
+		if _, err := lifted(ctx, it); err != nil {
+			return err
+		}
+		o.metrics.latency(it.Emitted)
+		return cp.completed(it.Offset)
+	}, opts...)
+
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(done)
+		var err error
+		for sinkErr := range sinkErrc {
+			err = sinkErr
+		}
+		if saveErr := cp.save(); err == nil && saveErr != nil {
+			err = &StageError{Stage: o.stageName("sink"), Err: saveErr}
+		}
+		if err != nil {
+			errc <- err
+		}
+	}()
+	return done, errc
+}
+
+func liftItem[T, U any](fn func(context.Context, T) (U, error), o options, stage string) func(context.Context, Item[T]) (Item[U], error) {
+	return func(ctx context.Context, it Item[T]) (Item[U], error) {
+		itemCtx, cancel := context.WithCancel(it.Ctx)
+		defer cancel()
+		stop := context.AfterFunc(ctx, cancel)
+		defer stop()
+		// Every call adds to a Meta of its own, so items never share one.
+		meta := &itemMeta{meta: it.Meta}
+		itemCtx = context.WithValue(itemCtx, metaKey{}, meta)
+
+		o.traceStart(it.Ctx, stage, it.Value)
+		value, err := fn(itemCtx, it.Value)
+		o.traceEnd(it.Ctx, stage, it.Value)
+		if err != nil {
+			// The failed item goes no further.
+			o.flow.release(it.Offset)
+		}
+		return Item[U]{Ctx: it.Ctx, Value: value, Offset: it.Offset, Emitted: it.Emitted, Deadline: it.Deadline, Requeues: it.Requeues, Meta: meta.get(), sent: time.Now(), budget: it.budget}, err
+	}
+}
@@ -0,0 +1,91 @@
+package pipeline_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+type traceKey struct{}
+
+// withTrace gives every item a context carrying its own trace id.
+func withTrace(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, traceKey{}, fmt.Sprintf("trace-%d", n))
+}
+
+// traceRecorder records the hook calls per item and checks that each of
+// them got the item's own context.
+type traceRecorder struct {
+	t      *testing.T
+	mu     sync.Mutex
+	events map[any][]string
+}
+
+func newTraceRecorder(t *testing.T) *traceRecorder {
+	return &traceRecorder{t: t, events: make(map[any][]string)}
+}
+
+func (r *traceRecorder) hook(event string) func(ctx context.Context, stage string, item any) {
+	return func(ctx context.Context, stage string, item any) {
+		if got, want := ctx.Value(traceKey{}), fmt.Sprintf("trace-%v", item); got != want {
+			r.t.Errorf("%s %s of %v got trace %v, want %v", stage, event, item, got, want)
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.events[item] = append(r.events[item], stage+" "+event)
+	}
+}
+
+func (r *traceRecorder) options() pipeline.Option {
+	return pipeline.WithTraceHooks(r.hook("start"), r.hook("end"))
+}
+
+func TestTraceHooksOrderPerItem(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newTraceRecorder(t)
+
+	src := pipeline.SourceItems(ctx, pipelinetest.Items(5), withTrace, r.options())
+	doubled, stageErrs := pipeline.StagePoolItems(ctx, src, 2, func(ctx context.Context, n int) (int, error) {
+		if got, want := ctx.Value(traceKey{}), fmt.Sprintf("trace-%d", n); got != want {
+			t.Errorf("transform of %d got trace %v, want %v", n, got, want)
+		}
+		return n, nil
+	}, pipeline.WithName("transform"), r.options())
+	done, sinkErrs := pipeline.SinkItems(ctx, doubled, func(context.Context, int) error { return nil }, pipeline.WithName("save"), r.options())
+	pipelinetest.CollectWithTimeout(t, pipeline.Merge(ctx, stageErrs, sinkErrs), time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	want := []string{"source start", "source end", "transform start", "transform end", "save start", "save end"}
+	for n := range 5 {
+		if got := r.events[n]; !slices.Equal(got, want) {
+			t.Errorf("item %d: hooks %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestTraceHooksBatch(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newTraceRecorder(t)
+
+	src := pipeline.SourceItems(ctx, pipelinetest.Items(4), withTrace)
+	batches := pipeline.BatchItems(ctx, src, 2, time.Hour, r.options())
+	got := pipelinetest.CollectWithTimeout(t, batches, time.Second)
+	if len(got) != 2 {
+		t.Fatalf("got %d batches, want 2", len(got))
+	}
+	for n := range 4 {
+		if events := r.events[n]; !slices.Equal(events, []string{"batch start", "batch end"}) {
+			t.Errorf("item %d: hooks %v, want the batch start and end", n, events)
+		}
+	}
+}
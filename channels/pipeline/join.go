@@ -0,0 +1,187 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"channelspractice/chanutil"
+)
+
+// Join enriches every item from left with the items from right that share its
+// key, in a hash join: it first reads all of right into memory, then streams
+// left against it. join is called for each left and right pair with the same
+// key and its result is emitted if it reports true. Left items without any
+// emitted pair are sent to the unmatched channel; right items nobody asked for
+// are forgotten.
+//
+// A maxRight above 0 caps the number of right items held. The one past it
+// stops the join with an error wrapping ErrJoinBufferFull, without reading
+// left. All three channels must be consumed.
+func Join[L, R any, K comparable, O any](ctx context.Context, left <-chan L, right <-chan R, leftKey func(L) K, rightKey func(R) K, maxRight int, join func(L, R) (O, bool), opts ...Option) (<-chan O, <-chan L, <-chan error) {
+	o := newOptions(opts)
+	out := make(chan O, o.buffer)
+	unmatched := make(chan L, o.buffer)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(unmatched)
+		defer close(out)
+
+		table := make(map[K][]R)
+		n := 0
+		for r := range OrDone(ctx, right) {
+			if n++; maxRight > 0 && n > maxRight {
+				errc <- &StageError{Stage: o.stageName("join"), Err: fmt.Errorf("%w: more than %d right items", ErrJoinBufferFull, maxRight)}
+				return
+			}
+			k := rightKey(r)
+			table[k] = append(table[k], r)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		for l := range OrDone(ctx, left) {
+			matched := false
+			for _, r := range table[leftKey(l)] {
+				if joined, ok := join(l, r); ok {
+					if chanutil.SendContext(ctx, out, joined) != nil {
+						return
+					}
+					matched = true
+				}
+			}
+			if !matched && chanutil.SendContext(ctx, unmatched, l) != nil {
+				return
+			}
+		}
+	}()
+	return out, unmatched, errc
+}
+
+// JoinStreaming is Join for two sides arriving at the same time in any order.
+// Both are read as they come and every item waits up to window, measured on
+// the clock set with WithClock, for partners from the other side; it is
+// joined with each of them as they arrive. A left item that has not been
+// joined with anything by the time it expires, or by the time both inputs are
+// closed, is sent to the unmatched channel. Both channels must be consumed.
+func JoinStreaming[L, R any, K comparable, O any](ctx context.Context, left <-chan L, right <-chan R, leftKey func(L) K, rightKey func(R) K, window time.Duration, join func(L, R) (O, bool), opts ...Option) (<-chan O, <-chan L) {
+	o := newOptions(opts)
+	out := make(chan O, o.buffer)
+	unmatched := make(chan L, o.buffer)
+	go func() {
+		defer close(unmatched)
+		defer close(out)
+
+		lefts := newJoinBuffer[K, L]()
+		rights := newJoinBuffer[K, R]()
+		// Expired items are evicted on every tick, so they may linger for up
+		// to another window.
+		ticker := o.clock.NewTicker(window)
+		defer ticker.Stop()
+
+		evict := func() bool {
+			cutoff := o.clock.Now().Add(-window)
+			rights.evict(cutoff)
+			for _, e := range lefts.evict(cutoff) {
+				if !e.matched && chanutil.SendContext(ctx, unmatched, e.item) != nil {
+					return false
+				}
+			}
+			return true
+		}
+
+		for left != nil || right != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case l, ok := <-left:
+				if !ok {
+					left = nil
+					continue
+				}
+				k := leftKey(l)
+				e := lefts.add(k, l, o.clock.Now())
+				for _, r := range rights.byKey[k] {
+					if joined, ok := join(l, r.item); ok {
+						if chanutil.SendContext(ctx, out, joined) != nil {
+							return
+						}
+						e.matched = true
+					}
+				}
+			case r, ok := <-right:
+				if !ok {
+					right = nil
+					continue
+				}
+				k := rightKey(r)
+				rights.add(k, r, o.clock.Now())
+				for _, l := range lefts.byKey[k] {
+					if joined, ok := join(l.item, r); ok {
+						if chanutil.SendContext(ctx, out, joined) != nil {
+							return
+						}
+						l.matched = true
+					}
+				}
+			case <-ticker.C():
+				if !evict() {
+					return
+				}
+			}
+		}
+
+		for _, e := range lefts.queue {
+			if !e.matched && chanutil.SendContext(ctx, unmatched, e.item) != nil {
+				return
+			}
+		}
+	}()
+	return out, unmatched
+}
+
+// joinEntry is an item waiting in a JoinStreaming buffer.
+type joinEntry[K comparable, T any] struct {
+	key     K
+	item    T
+	at      time.Time
+	matched bool
+}
+
+// joinBuffer holds the items of one side of a JoinStreaming by key and in
+// arrival order, so the oldest can be evicted first.
+type joinBuffer[K comparable, T any] struct {
+	byKey map[K][]*joinEntry[K, T]
+	queue []*joinEntry[K, T]
+}
+
+func newJoinBuffer[K comparable, T any]() *joinBuffer[K, T] {
+	return &joinBuffer[K, T]{byKey: make(map[K][]*joinEntry[K, T])}
+}
+
+func (b *joinBuffer[K, T]) add(key K, item T, at time.Time) *joinEntry[K, T] {
+	e := &joinEntry[K, T]{key: key, item: item, at: at}
+	b.byKey[key] = append(b.byKey[key], e)
+	b.queue = append(b.queue, e)
+	return e
+}
+
+// evict removes and returns the entries that arrived by cutoff. Each is
+// the oldest of its key, so it is always the first in byKey too.
+func (b *joinBuffer[K, T]) evict(cutoff time.Time) []*joinEntry[K, T] {
+	n := 0
+	for n < len(b.queue) && !b.queue[n].at.After(cutoff) {
+		e := b.queue[n]
+		if rest := b.byKey[e.key][1:]; len(rest) > 0 {
+			b.byKey[e.key] = rest
+		} else {
+			delete(b.byKey, e.key)
+		}
+		n++
+	}
+	evicted := b.queue[:n]
+	b.queue = b.queue[n:]
+	return evicted
+}
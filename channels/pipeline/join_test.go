@@ -0,0 +1,178 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+type order struct {
+	ID     int
+	UserID string
+}
+
+type user struct {
+	ID   string
+	Name string
+}
+
+func orderUser(o order) string { return o.UserID }
+
+func userID(u user) string { return u.ID }
+
+// describe joins an order with its user, skipping users named "".
+func describe(o order, u user) (string, bool) {
+	if u.Name == "" {
+		return "", false
+	}
+	return u.Name + "#" + strconv.Itoa(o.ID), true
+}
+
+func orderIDs(orders []order) []int {
+	var ids []int
+	for _, o := range orders {
+		ids = append(ids, o.ID)
+	}
+	return ids
+}
+
+func TestJoinMatches(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	orders := []order{{1, "ann"}, {2, "bob"}, {3, "eve"}, {4, "ann"}, {5, "nil"}}
+	users := []user{{"ann", "Ann"}, {"bob", "Bob"}, {"bob", "Robert"}, {"sam", "Sam"}, {"nil", ""}}
+	// The buffers hold every result, so the channels can be read one by one.
+	out, unmatched, errc := pipeline.Join(ctx, pipeline.Source(ctx, orders), pipeline.Source(ctx, users),
+		orderUser, userID, 0, describe, pipeline.WithBuffer(10))
+
+	// bob has two users and sam no order; eve has no user and the join
+	// refuses nil's.
+	if got, want := pipelinetest.CollectWithTimeout(t, out, time.Second), []string{"Ann#1", "Bob#2", "Robert#2", "Ann#4"}; !slices.Equal(got, want) {
+		t.Errorf("joined %v, want %v", got, want)
+	}
+	if got := orderIDs(pipelinetest.CollectWithTimeout(t, unmatched, time.Second)); !slices.Equal(got, []int{3, 5}) {
+		t.Errorf("unmatched %v, want orders 3 and 5", got)
+	}
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestJoinBufferFull(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	orders := make(chan order, 1)
+	orders <- order{1, "ann"}
+	users := []user{{"ann", "Ann"}, {"bob", "Bob"}, {"sam", "Sam"}}
+	out, unmatched, errc := pipeline.Join(ctx, orders, pipeline.Source(ctx, users), orderUser, userID, 2, describe, pipeline.WithName("users"))
+
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "users" || !errors.Is(errs[0], pipeline.ErrJoinBufferFull) {
+		t.Fatalf("errors = %v, want one ErrJoinBufferFull from users", errs)
+	}
+	pipelinetest.AssertClosed(t, out, time.Second)
+	pipelinetest.AssertClosed(t, unmatched, time.Second)
+	if len(orders) != 1 {
+		t.Error("Join read the left side although the right one did not fit")
+	}
+}
+
+func TestJoinCancelWhileLoadingRight(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Neither side is ever closed.
+	orders := make(chan order)
+	users := make(chan user)
+	out, unmatched, errc := pipeline.Join(ctx, orders, users, orderUser, userID, 0, describe)
+	users <- user{"ann", "Ann"}
+	cancel()
+
+	pipelinetest.AssertClosed(t, out, time.Second)
+	pipelinetest.AssertClosed(t, unmatched, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v, want none for a cancellation", errs)
+	}
+}
+
+func TestJoinStreamingAnyOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	orders := make(chan order)
+	users := make(chan user)
+	out, unmatched := pipeline.JoinStreaming(ctx, orders, users, orderUser, userID, time.Hour, describe, pipeline.WithBuffer(10))
+
+	// ann's order comes before ann, bob before his order.
+	orders <- order{1, "ann"}
+	users <- user{"ann", "Ann"}
+	users <- user{"bob", "Bob"}
+	orders <- order{2, "bob"}
+	orders <- order{3, "eve"}
+	users <- user{"sam", "Sam"}
+	close(orders)
+	close(users)
+
+	if got, want := pipelinetest.CollectWithTimeout(t, out, time.Second), []string{"Ann#1", "Bob#2"}; !slices.Equal(got, want) {
+		t.Errorf("joined %v, want %v", got, want)
+	}
+	// eve's order is flushed once both sides are closed.
+	if got := orderIDs(pipelinetest.CollectWithTimeout(t, unmatched, time.Second)); !slices.Equal(got, []int{3}) {
+		t.Errorf("unmatched %v, want order 3", got)
+	}
+}
+
+func TestJoinStreamingExpires(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+
+	orders := make(chan order)
+	users := make(chan user)
+	out, unmatched := pipeline.JoinStreaming(ctx, orders, users, orderUser, userID, time.Second,
+		describe, pipeline.WithClock(clock), pipeline.WithBuffer(10))
+
+	orders <- order{1, "ann"}
+	if got := advanceUntilItem(t, clock, unmatched, time.Second); got.ID != 1 {
+		t.Fatalf("unmatched %v, want order 1 once it expired", got)
+	}
+	// ann arrives too late for her order.
+	users <- user{"ann", "Ann"}
+	close(orders)
+	close(users)
+
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 0 {
+		t.Errorf("joined %v with an expired order", got)
+	}
+	if got := pipelinetest.CollectWithTimeout(t, unmatched, time.Second); len(got) != 0 {
+		t.Errorf("unmatched %v, want order 1 reported only once", got)
+	}
+}
+
+func TestJoinStreamingCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	orders := make(chan order)
+	out, unmatched := pipeline.JoinStreaming(ctx, orders, make(chan user), orderUser, userID, time.Hour, describe)
+	orders <- order{1, "ann"}
+	cancel()
+
+	pipelinetest.AssertClosed(t, out, time.Second)
+	pipelinetest.AssertClosed(t, unmatched, time.Second)
+}
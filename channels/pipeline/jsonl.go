@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// SinkJSONL writes every item read from in to w as one line of JSON. Writes are
+// buffered and flushed once in is closed, ctx is cancelled or an item fails,
+// unless ctx was cancelled and ShutdownMode, or WithShutdownMode, says
+// ModeDiscard, in which case what is still buffered is dropped. The done
+// channel is closed only after that final flush; the first marshal, write or
+// flush error is sent on the error channel before it.
+func SinkJSONL[T any](ctx context.Context, in <-chan T, w io.Writer, opts ...Option) (<-chan struct{}, <-chan error) {
+	o := newOptions(opts)
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	write := func(_ context.Context, item T) error {
+		if err := enc.Encode(item); err != nil {
+			return fmt.Errorf("write item %v: %w", item, err)
+		}
+		return nil
+	}
+	_, sinkErrc := Sink(ctx, in, write, append(opts, WithName(o.stageName("jsonl")))...)
+
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(done)
+		var err error
+		for sinkErr := range sinkErrc {
+			if err == nil {
+				err = sinkErr
+			}
+		}
+		// Whatever made it into the buffer is still written out after a
+		// failure of the sink itself; only the first error is reported.
+		if ctx.Err() != nil && o.shutdown(ctx) == ModeDiscard {
+			bw.Reset(io.Discard)
+		} else if flushErr := bw.Flush(); flushErr != nil && err == nil {
+			err = &StageError{Stage: o.stageName("jsonl"), Err: fmt.Errorf("flush: %w", flushErr)}
+		}
+		if err != nil {
+			errc <- err
+		}
+	}()
+	return done, errc
+}
@@ -0,0 +1,144 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+type jsonRecord struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestSinkJSONLWritesStructs(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var buf bytes.Buffer
+	done, errc := pipeline.SinkJSONL(ctx, pipeline.Source(ctx, []jsonRecord{{1, "a"}, {2, "b"}}), &buf)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if want := "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n"; buf.String() != want {
+		t.Errorf("wrote %q, want %q", buf.String(), want)
+	}
+}
+
+// failingWriter accepts limit bytes and fails every write after that.
+type failingWriter struct {
+	limit int
+}
+
+var errDiskFull = errors.New("disk full")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errDiskFull
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestSinkJSONLWriterErrorReportedOnce(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Records large enough to overflow bufio's buffer mid-stream.
+	records := make([]jsonRecord, 200)
+	for i := range records {
+		records[i] = jsonRecord{ID: i, Name: string(bytes.Repeat([]byte("x"), 100))}
+	}
+	w := &failingWriter{limit: 5000}
+	done, errc := pipeline.SinkJSONL(ctx, pipeline.Source(ctx, records), w)
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if len(errs) != 1 || !errors.Is(errs[0], errDiskFull) {
+		t.Errorf("errors = %v, want exactly one wrapping %v", errs, errDiskFull)
+	}
+}
+
+func TestSinkJSONLFlushesAfterMarshalError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var buf bytes.Buffer
+	done, errc := pipeline.SinkJSONL(ctx, pipeline.Source(ctx, []float64{1, math.Inf(1), 3}), &buf, pipeline.WithName("save"))
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "save" {
+		t.Errorf("errors = %v, want one *StageError of save", errs)
+	}
+	if buf.String() != "1\n" {
+		t.Errorf("wrote %q, want the item before the failure flushed", buf.String())
+	}
+}
+
+func TestSinkJSONLFlushesOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan jsonRecord)
+	var buf bytes.Buffer
+	done, errc := pipeline.SinkJSONL(ctx, in, &buf)
+	in <- jsonRecord{1, "a"}
+	in <- jsonRecord{2, "b"}
+	cancel()
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if want := "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n"; buf.String() != want {
+		t.Errorf("wrote %q, want %q", buf.String(), want)
+	}
+}
+
+// cancelJSONL writes two records to a SinkJSONL, cancels ctx with cause and
+// returns what ended up in w.
+func cancelJSONL(t *testing.T, cause error, opts ...pipeline.Option) string {
+	t.Helper()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	in := make(chan jsonRecord)
+	var buf bytes.Buffer
+	done, errc := pipeline.SinkJSONL(ctx, in, &buf, opts...)
+	in <- jsonRecord{1, "a"}
+	in <- jsonRecord{2, "b"}
+	cancel(cause)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+	return buf.String()
+}
+
+func TestSinkJSONLShutdownModes(t *testing.T) {
+	const both = "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n"
+	failed := &pipeline.StageError{Stage: "transform", Err: errors.New("boom")}
+	for _, tc := range []struct {
+		name  string
+		cause error
+		opts  []pipeline.Option
+		want  string
+	}{
+		{"signal flushes", pipeline.ErrSignalled, nil, both},
+		{"failure discards", failed, nil, ""},
+		{"failure flushes if told to", failed, []pipeline.Option{pipeline.WithShutdownMode(pipeline.ModeFlush)}, both},
+		{"signal discards if told to", pipeline.ErrSignalled, []pipeline.Option{pipeline.WithShutdownMode(pipeline.ModeDiscard)}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			if got := cancelJSONL(t, tc.cause, tc.opts...); got != tc.want {
+				t.Errorf("wrote %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
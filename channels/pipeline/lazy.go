@@ -0,0 +1,40 @@
+package pipeline
+
+import (
+	"context"
+
+	"channelspractice/chanutil"
+)
+
+// WithLazyStart makes a Stage, StagePool or Sink wait for the first item on
+// its input before it starts its workers and calls Hooks.OnStart, so a branch
+// of a graph that never gets an item costs little more than a goroutine. If in
+// is closed, or ctx cancelled, before the first item, no hook is called at
+// all and the outputs are just closed.
+func WithLazyStart() Option {
+	return func(o *options) {
+		o.lazyStart = true
+	}
+}
+
+// prepend is OrDone for in with first sent before everything else.
+func prepend[T any](ctx context.Context, first T, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		if chanutil.SendContext(ctx, out, first) != nil {
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-in:
+				if !ok || chanutil.SendContext(ctx, out, item) != nil {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,105 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// lazyKinds start a lazy stage of each kind on in and return a channel that
+// is closed once all its channels are.
+var lazyKinds = []struct {
+	name    string
+	workers int
+	start   func(ctx context.Context, in <-chan int, opts ...pipeline.Option) <-chan struct{}
+}{
+	{"stage", 1, func(ctx context.Context, in <-chan int, opts ...pipeline.Option) <-chan struct{} {
+		return drained(pipeline.Stage(ctx, in, double, opts...))
+	}},
+	{"pool", 3, func(ctx context.Context, in <-chan int, opts ...pipeline.Option) <-chan struct{} {
+		return drained(pipeline.StagePool(ctx, in, 3, double, opts...))
+	}},
+	{"sink", 1, func(ctx context.Context, in <-chan int, opts ...pipeline.Option) <-chan struct{} {
+		done, errc := pipeline.Sink(ctx, in, func(context.Context, int) error { return nil }, opts...)
+		return drained(done, errc)
+	}},
+}
+
+// drained reads out and errc to the end and closes the returned channel
+// then.
+func drained[T any](out <-chan T, errc <-chan error) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		drain(out, errc)
+	}()
+	return closed
+}
+
+func TestLazyStart(t *testing.T) {
+	for _, kind := range lazyKinds {
+		for _, tc := range []struct {
+			name string
+			// feed sends the stage its items, if any, and ends its input.
+			feed   func(in chan<- int, cancel context.CancelFunc)
+			starts bool
+		}{
+			{"no items", func(in chan<- int, _ context.CancelFunc) { close(in) }, false},
+			{"one item", func(in chan<- int, _ context.CancelFunc) {
+				in <- 1
+				close(in)
+			}, true},
+			{"cancelled first", func(_ chan<- int, cancel context.CancelFunc) { cancel() }, false},
+		} {
+			t.Run(kind.name+"/"+tc.name, func(t *testing.T) {
+				pipelinetest.VerifyNoLeaks(t)
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				var rec hookRecorder
+
+				in := make(chan int)
+				closed := kind.start(ctx, in, pipeline.WithLazyStart(), pipeline.WithHooks(rec.hooks(nil)))
+				tc.feed(in, cancel)
+				pipelinetest.AssertClosed(t, closed, time.Second)
+
+				want := 0
+				if tc.starts {
+					want = kind.workers
+				}
+				if starts, stops := rec.count("start"), rec.count("stop"); starts != want || stops != want {
+					t.Errorf("OnStart ran %d times and OnStop %d times, want %d each", starts, stops, want)
+				}
+				if items := rec.count("item"); tc.starts && items != 1 {
+					t.Errorf("OnItem ran %d times, want once", items)
+				}
+			})
+		}
+	}
+}
+
+func TestLazyStartWaitsForFirstItem(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var rec hookRecorder
+
+	in := make(chan int)
+	out, errc := pipeline.Stage(ctx, in, double, pipeline.WithLazyStart(), pipeline.WithHooks(rec.hooks(nil)))
+	time.Sleep(20 * time.Millisecond)
+	if n := rec.count("start"); n != 0 {
+		t.Fatalf("OnStart ran %d times before any item", n)
+	}
+	in <- 1
+	in <- 2
+	close(in)
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 2 || got[0] != 2 || got[1] != 4 {
+		t.Errorf("got %v, want [2 4]", got)
+	}
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if n := rec.count("start"); n != 1 {
+		t.Errorf("OnStart ran %d times, want once", n)
+	}
+}
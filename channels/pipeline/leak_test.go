@@ -0,0 +1,146 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// TestFailingTransformLeaksNothing is the lesson_019 pipeline: transform
+// fails on 6 and stops reading, which leaves the generator blocked on a send
+// that only cancellation can release.
+func TestFailingTransformLeaksNothing(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nums := pipeline.Source(ctx, pipelinetest.Items(100))
+	doubled, transformErrs := pipeline.Stage(ctx, nums, failOnSix, pipeline.WithName("transform"))
+	var saved []int
+	done, saveErrs := pipeline.Sink(ctx, doubled, func(_ context.Context, n int) error {
+		saved = append(saved, n)
+		return nil
+	})
+	errs := pipeline.Merge(ctx, transformErrs, saveErrs)
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("error channel closed without the transform error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no error from transform")
+	}
+	cancel()
+	pipelinetest.AssertClosed(t, done, time.Second)
+	pipelinetest.CollectWithTimeout(t, errs, time.Second)
+	if len(saved) > 6 {
+		t.Errorf("saved %v, want nothing past the failure", saved)
+	}
+}
+
+// TestStagesStopWhenConsumerLeaves covers every stage that sends on a
+// channel: its consumer takes one item and cancels, and the stage must not
+// stay blocked on the next send.
+func TestStagesStopWhenConsumerLeaves(t *testing.T) {
+	items := pipelinetest.Items(1000)
+	identity := func(_ context.Context, n int) (int, error) { return n, nil }
+	for _, tc := range []struct {
+		name  string
+		start func(ctx context.Context) <-chan int
+	}{
+		{"Source", func(ctx context.Context) <-chan int {
+			return pipeline.Source(ctx, items)
+		}},
+		{"Stage", func(ctx context.Context) <-chan int {
+			out, _ := pipeline.Stage(ctx, pipeline.Source(ctx, items), identity)
+			return out
+		}},
+		{"StagePool", func(ctx context.Context) <-chan int {
+			out, _ := pipeline.StagePool(ctx, pipeline.Source(ctx, items), 4, identity)
+			return out
+		}},
+		{"StagePoolOrdered", func(ctx context.Context) <-chan int {
+			out, _ := pipeline.StagePoolOrdered(ctx, pipeline.Source(ctx, items), 4, 8, identity)
+			return out
+		}},
+		{"Map", func(ctx context.Context) <-chan int {
+			return pipeline.Map(ctx, pipeline.Source(ctx, items), func(n int) int { return n })
+		}},
+		{"Filter", func(ctx context.Context) <-chan int {
+			out, _ := pipeline.Filter(ctx, pipeline.Source(ctx, items), func(int) bool { return true })
+			return out
+		}},
+		{"Merge", func(ctx context.Context) <-chan int {
+			return pipeline.Merge(ctx, pipeline.Source(ctx, items), pipeline.Source(ctx, items))
+		}},
+		{"Tee", func(ctx context.Context) <-chan int {
+			a, _ := pipeline.Tee(ctx, pipeline.Source(ctx, items))
+			return a
+		}},
+		{"Skip", func(ctx context.Context) <-chan int {
+			return pipeline.Skip(ctx, pipeline.Source(ctx, items), 1)
+		}},
+		{"OrDone", func(ctx context.Context) <-chan int {
+			return pipeline.OrDone(ctx, pipeline.Source(ctx, items))
+		}},
+		{"Bridge", func(ctx context.Context) <-chan int {
+			return pipeline.Bridge(ctx, pipeline.Source(ctx, []<-chan int{pipeline.Source(ctx, items)}))
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			out := tc.start(ctx)
+			<-out
+			cancel()
+			pipelinetest.CollectWithTimeout(t, out, time.Second)
+		})
+	}
+}
+
+// The three regression tests below are the leaks lesson_019 had: the
+// generator blocked on a send after downstream aborted, a stage blocked on an
+// error nobody reads, and pool workers blocked fanning in to an abandoned
+// output.
+
+func TestLeakDownstreamAbortsWhileGeneratorSends(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	nums := pipeline.Source(ctx, pipelinetest.Items(1000))
+	<-nums
+	// The generator is already trying to send the next item.
+	cancel()
+	pipelinetest.CollectWithTimeout(t, nums, time.Second)
+}
+
+func TestLeakErrorChannelNeverRead(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	nums := pipeline.Source(ctx, pipelinetest.Items(100))
+	// Only the output is read; the failure on 6 is sent to nobody, which
+	// holds the stage until the reader cancels.
+	doubled, _ := pipeline.Stage(ctx, nums, failOnSix)
+	for range 6 {
+		<-doubled
+	}
+	cancel()
+	pipelinetest.CollectWithTimeout(t, doubled, time.Second)
+}
+
+func TestLeakCancelDuringPoolFanIn(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out, errc := pipeline.StagePool(ctx, pipeline.Source(ctx, pipelinetest.Items(1000)), 8, pipelinetest.CPUWork(10))
+	for range 3 {
+		<-out
+	}
+	// Every worker now holds a result for the shared output.
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+}
@@ -0,0 +1,38 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WithLogger makes a stage log its start and stop, failed items and, at debug
+// level, every processed item. A stage stopped by cancellation logs the cause.
+// Stages log nothing by default.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+func (o options) logStart(ctx context.Context, stage string) {
+	o.logger.InfoContext(ctx, "stage started", "stage", stage)
+}
+
+func (o options) logStop(ctx context.Context, stage string) {
+	if cause := CauseOf(ctx); cause != nil {
+		o.logger.InfoContext(ctx, "stage stopped", "stage", stage, "cause", cause)
+		return
+	}
+	o.logger.InfoContext(ctx, "stage stopped", "stage", stage)
+}
+
+// logItem is generic so that item is only boxed when debug logging is on.
+func logItem[T any](ctx context.Context, o options, stage string, item T) {
+	if o.logger.Enabled(ctx, slog.LevelDebug) {
+		o.logger.DebugContext(ctx, "item processed", append([]any{"stage", stage, "item", item}, metaArgs(item)...)...)
+	}
+}
+
+func (o options) logItemError(ctx context.Context, stage string, item any, err error) {
+	o.logger.ErrorContext(ctx, "item failed", append([]any{"stage", stage, "item", item, "error", err}, metaArgs(item)...)...)
+}
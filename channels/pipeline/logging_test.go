@@ -0,0 +1,85 @@
+package pipeline_test
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// recordHandler keeps every record it handles.
+type recordHandler struct {
+	mu      sync.Mutex
+	level   slog.Level
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(_ context.Context, level slog.Level) bool { return level >= h.level }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *recordHandler) WithGroup(string) slog.Handler { return h }
+
+// find returns the attributes of the records with the given message.
+func (h *recordHandler) find(msg string) []map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var found []map[string]string
+	for _, r := range h.records {
+		if r.Message != msg {
+			continue
+		}
+		attrs := make(map[string]string)
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value.Resolve().String()
+			return true
+		})
+		found = append(found, attrs)
+	}
+	return found
+}
+
+func TestLoggerRecordsFailedItem(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	h := &recordHandler{level: slog.LevelInfo}
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Stage(ctx, in, failOnSix, pipeline.WithName("transform"), pipeline.WithLogger(slog.New(h)))
+	})
+	pipelinetest.Run(t, stage, pipelinetest.Items(11))
+
+	failed := h.find("item failed")
+	if len(failed) != 1 || failed[0]["stage"] != "transform" || failed[0]["item"] != "6" {
+		t.Errorf("item failed records = %v, want one with stage=transform item=6", failed)
+	}
+	// The stop is logged after the channels are closed, so Run may return
+	// before it.
+	if started := h.find("stage started"); len(started) != 1 || started[0]["stage"] != "transform" {
+		t.Errorf("stage started records = %v, want one for transform", started)
+	}
+	if processed := h.find("item processed"); len(processed) != 0 {
+		t.Errorf("got %d debug records at info level", len(processed))
+	}
+}
+
+func TestLoggerDebugItems(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	h := &recordHandler{level: slog.LevelDebug}
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Stage(ctx, in, failOnSix, pipeline.WithLogger(slog.New(h)))
+	})
+	pipelinetest.Run(t, stage, pipelinetest.Items(3))
+
+	if processed := h.find("item processed"); len(processed) != 3 {
+		t.Errorf("got %d item processed records, want 3", len(processed))
+	}
+}
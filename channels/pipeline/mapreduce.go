@@ -0,0 +1,78 @@
+package pipeline
+
+import (
+	"context"
+
+	"channelspractice/chanutil"
+)
+
+// Map applies an infallible fn to every item from in.
+func Map[T, U any](ctx context.Context, in <-chan T, fn func(T) U, opts ...Option) <-chan U {
+	o := newOptions(opts)
+	out := make(chan U, o.buffer)
+	go func() {
+		defer close(out)
+		for item := range OrDone(ctx, in) {
+			if chanutil.SendContext(ctx, out, fn(item)) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Reduce folds every item from in into an accumulator starting at init and
+// returns it once in is closed. If ctx is cancelled first it returns the
+// partial accumulator together with ctx.Err().
+func Reduce[T, A any](ctx context.Context, in <-chan T, init A, fn func(A, T) A) (A, error) {
+	acc := init
+	for {
+		select {
+		case <-ctx.Done():
+			return acc, ctx.Err()
+		case item, ok := <-in:
+			if !ok {
+				return acc, nil
+			}
+			acc = fn(acc, item)
+		}
+	}
+}
+
+// SinkAggregate is Reduce as a sink: it folds every item from in into an
+// accumulator starting at init, and once in is closed sends the accumulator
+// on the result channel, which then closes. If ctx is cancelled first the
+// result channel closes without a value and the cause is sent on the error
+// channel as a *StageError. Options are those of Sink, whose name it takes
+// by default.
+func SinkAggregate[T, A any](ctx context.Context, in <-chan T, init A, fold func(A, T) A, opts ...Option) (<-chan A, <-chan error) {
+	name := newOptions(opts).stageName("sink")
+	acc := init
+	done, sinkErrc := Sink(ctx, in, func(_ context.Context, item T) error {
+		acc = fold(acc, item)
+		return nil
+	}, opts...)
+
+	result := make(chan A, 1)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(result)
+		var err error
+		for sinkErr := range sinkErrc {
+			if err == nil {
+				err = sinkErr
+			}
+		}
+		<-done
+		switch {
+		case err != nil:
+			errc <- err
+		case ctx.Err() != nil:
+			errc <- &StageError{Stage: name, Err: context.Cause(ctx)}
+		default:
+			result <- acc
+		}
+	}()
+	return result, errc
+}
@@ -0,0 +1,122 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func add(acc, n int) int { return acc + n }
+
+func TestMap(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := pipeline.Map(ctx, pipeline.Source(ctx, []int{1, 2, 3}), func(n int) int { return n * 2 })
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{2, 4, 6}) {
+		t.Errorf("got %v, want [2 4 6]", got)
+	}
+}
+
+func TestReduceSumsDoubledNumbers(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	doubled := pipeline.Map(ctx, pipeline.Source(ctx, pipelinetest.Items(11)), func(n int) int { return n * 2 })
+	total, err := pipeline.Reduce(ctx, doubled, 0, add)
+	if err != nil || total != 110 {
+		t.Errorf("Reduce = %d, %v, want 110", total, err)
+	}
+}
+
+func TestReduceEmptyInput(t *testing.T) {
+	in := make(chan int)
+	close(in)
+	if total, err := pipeline.Reduce(context.Background(), in, 42, add); err != nil || total != 42 {
+		t.Errorf("Reduce = %d, %v, want init 42", total, err)
+	}
+}
+
+func TestReduceCancelledMidStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	go func() {
+		in <- 1
+		in <- 2
+		cancel()
+	}()
+	total, err := pipeline.Reduce(ctx, in, 0, add)
+	if !errors.Is(err, context.Canceled) || total != 3 {
+		t.Errorf("Reduce = %d, %v, want the partial 3 and context.Canceled", total, err)
+	}
+}
+
+func TestSinkAggregate(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	doubled := pipeline.Map(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), func(n int) int { return n * 2 })
+
+	result, errc := pipeline.SinkAggregate(ctx, doubled, 0, add)
+	if got := pipelinetest.CollectWithTimeout(t, result, time.Second); !slices.Equal(got, []int{90}) {
+		t.Errorf("result = %v, want exactly [90]", got)
+	}
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestSinkAggregateAborted(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	errInvalid := errors.New("number 6 is invalid")
+	in := make(chan int)
+	// Like the lesson's transform, the producer gives up on 6 and aborts the
+	// pipeline rather than closing in.
+	go func() {
+		for n := range 10 {
+			if n == 6 {
+				cancel(errInvalid)
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case in <- n * 2:
+			}
+		}
+	}()
+
+	result, errc := pipeline.SinkAggregate(ctx, in, 0, add, pipeline.WithName("sum"))
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if got := pipelinetest.CollectWithTimeout(t, result, time.Second); len(got) != 0 {
+		t.Errorf("result = %v after the abort, want none", got)
+	}
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "sum" || !errors.Is(errs[0], errInvalid) {
+		t.Errorf("errors = %v, want one *StageError of sum wrapping %v", errs, errInvalid)
+	}
+}
+
+func TestSinkAggregateCancelled(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+
+	result, errc := pipeline.SinkAggregate(ctx, in, 0, add)
+	in <- 1
+	cancel()
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if got := pipelinetest.CollectWithTimeout(t, result, time.Second); len(got) != 0 {
+		t.Errorf("result = %v after cancellation, want none", got)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+		t.Errorf("errors = %v, want context.Canceled", errs)
+	}
+}
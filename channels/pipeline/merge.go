@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"context"
+
+	"channelspractice/chanutil"
+)
+
+// Merge fans the values of all chans into a single channel, which is closed
+// once every input has been closed or ctx is cancelled. With no inputs the
+// returned channel is closed immediately.
+func Merge[T any](ctx context.Context, chans ...<-chan T) <-chan T {
+	return MergeBuffered(ctx, 0, chans...)
+}
+
+// MergeBuffered is Merge with an output buffer of n values, so late values can
+// be handed over without blocking their producers.
+func MergeBuffered[T any](ctx context.Context, n int, chans ...<-chan T) <-chan T {
+	merged := make(chan T, n)
+
+	var g chanutil.Group
+	for _, ch := range chans {
+		g.Go(func() {
+			for {
+				item, ok, err := chanutil.RecvContext(ctx, ch)
+				if err != nil || !ok {
+					return
+				}
+				if chanutil.SendContext(ctx, merged, item) != nil {
+					return
+				}
+			}
+		})
+	}
+
+	go func() {
+		defer close(merged)
+		// Forwarding items cannot panic.
+		g.Wait(context.Background())
+	}()
+
+	return merged
+}
+
+// Labeled is a value together with the name of the channel it came from.
+type Labeled[T any] struct {
+	Source string
+	Value  T
+}
+
+// MergeLabeled is Merge for channels known by name: every value comes
+// labelled with the name of the channel it was read from.
+func MergeLabeled[T any](ctx context.Context, sources map[string]<-chan T) <-chan Labeled[T] {
+	merged := make(chan Labeled[T])
+
+	var g chanutil.Group
+	for name, ch := range sources {
+		g.Go(func() {
+			for {
+				item, ok, err := chanutil.RecvContext(ctx, ch)
+				if err != nil || !ok {
+					return
+				}
+				if chanutil.SendContext(ctx, merged, Labeled[T]{Source: name, Value: item}) != nil {
+					return
+				}
+			}
+		})
+	}
+
+	go func() {
+		defer close(merged)
+		g.Wait(context.Background())
+	}()
+
+	return merged
+}
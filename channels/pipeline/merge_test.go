@@ -0,0 +1,157 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// failingStage sends errors until ctx is cancelled, much like a pool of
+// failing workers.
+func failingStage(ctx context.Context) <-chan error {
+	errc := make(chan error)
+	go func() {
+		defer close(errc)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case errc <- errors.New("failed"):
+			}
+		}
+	}()
+	return errc
+}
+
+func TestMergeCancelWithErrorsInFlight(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	merged := pipeline.Merge(ctx, failingStage(ctx), failingStage(ctx), failingStage(ctx))
+	<-merged
+	// The reader walks away with errors still being forwarded. A forwarder
+	// may hand over one more before it sees the cancellation.
+	cancel()
+	pipelinetest.CollectWithTimeout(t, merged, time.Second)
+}
+
+func TestMergeBufferedDoesNotBlockLateErrors(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	late := make(chan error)
+	merged := pipeline.MergeBuffered(ctx, 2, late)
+
+	// Nobody reads merged yet, but both sends go through.
+	for range 2 {
+		select {
+		case late <- errors.New("late"):
+		case <-time.After(time.Second):
+			t.Fatal("producer blocked although the buffer had room")
+		}
+	}
+	close(late)
+	if errs := pipelinetest.CollectWithTimeout(t, merged, time.Second); len(errs) != 2 {
+		t.Errorf("got %d errors, want 2", len(errs))
+	}
+}
+
+func TestMergeDeliversEveryValueOnce(t *testing.T) {
+	for _, n := range []int{1, 2, 16} {
+		t.Run(fmt.Sprintf("%d channels", n), func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			chans := make([]<-chan int, n)
+			var want []int
+			for i := range chans {
+				items := make([]int, 50)
+				for j := range items {
+					items[j] = i*100 + j
+				}
+				chans[i] = pipeline.Source(ctx, items)
+				want = append(want, items...)
+			}
+
+			got := pipelinetest.CollectWithTimeout(t, pipeline.Merge(ctx, chans...), time.Second)
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Errorf("got %d values, want each of the %d exactly once", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestMergeNoInputs(t *testing.T) {
+	pipelinetest.AssertClosed(t, pipeline.Merge[int](context.Background()), time.Second)
+}
+
+func TestMergeClosedInputs(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	closed := make(chan int)
+	close(closed)
+	pipelinetest.AssertClosed(t, pipeline.Merge(context.Background(), closed, closed), time.Second)
+}
+
+func TestMergeCancelWhileBlockedOnSend(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int, 1)
+	in <- 1
+	merged := pipeline.Merge(ctx, in)
+	// The forwarder holds 1 and nobody reads merged.
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	pipelinetest.CollectWithTimeout(t, merged, time.Second)
+}
+
+func TestMergeLabeled(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sources := map[string]<-chan int{
+		"a": pipeline.Source(ctx, []int{1, 2, 3}),
+		"b": pipeline.Source(ctx, []int{10, 20}),
+		"c": pipeline.Source(ctx, []int{100}),
+	}
+
+	got := make(map[string][]int)
+	total := 0
+	for _, l := range pipelinetest.CollectWithTimeout(t, pipeline.MergeLabeled(ctx, sources), time.Second) {
+		got[l.Source] = append(got[l.Source], l.Value)
+		total++
+	}
+	want := map[string][]int{"a": {1, 2, 3}, "b": {10, 20}, "c": {100}}
+	if total != 6 || len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for name, values := range want {
+		// Values from one source keep their order.
+		if !slices.Equal(got[name], values) {
+			t.Errorf("source %s delivered %v, want %v", name, got[name], values)
+		}
+	}
+}
+
+func TestMergeLabeledNoInputs(t *testing.T) {
+	pipelinetest.AssertClosed(t, pipeline.MergeLabeled[int](context.Background(), nil), time.Second)
+}
+
+func TestMergeLabeledCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	merged := pipeline.MergeLabeled(ctx, map[string]<-chan error{
+		"x": failingStage(ctx),
+		"y": failingStage(ctx),
+	})
+	if l := <-merged; l.Source != "x" && l.Source != "y" {
+		t.Errorf("value labelled %q, want x or y", l.Source)
+	}
+	cancel()
+	pipelinetest.CollectWithTimeout(t, merged, time.Second)
+}
@@ -0,0 +1,79 @@
+package pipeline
+
+import (
+	"container/heap"
+	"context"
+
+	"channelspractice/chanutil"
+)
+
+// MergeSorted merges chans, each of which must already be sorted by less, into
+// a single sorted channel. It has to wait for the next item of the input it
+// last took from before emitting anything else, so one slow input holds up
+// the output. The channel is closed once every input has been closed or ctx is
+// cancelled.
+func MergeSorted[T any](ctx context.Context, less func(a, b T) bool, chans ...<-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		recv := func(i int) (T, bool) {
+			select {
+			case <-ctx.Done():
+				var zero T
+				return zero, false
+			case item, ok := <-chans[i]:
+				return item, ok
+			}
+		}
+
+		h := &mergeHeap[T]{less: less}
+		for i := range chans {
+			if item, ok := recv(i); ok {
+				h.heads = append(h.heads, mergeHead[T]{item: item, input: i})
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		heap.Init(h)
+
+		for h.Len() > 0 {
+			head := h.heads[0]
+			if chanutil.SendContext(ctx, out, head.item) != nil {
+				return
+			}
+			if item, ok := recv(head.input); ok {
+				h.heads[0].item = item
+				heap.Fix(h, 0)
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			heap.Pop(h)
+		}
+	}()
+	return out
+}
+
+type mergeHead[T any] struct {
+	item  T
+	input int
+}
+
+// mergeHeap is a heap.Interface over the current head of every open input.
+type mergeHeap[T any] struct {
+	heads []mergeHead[T]
+	less  func(a, b T) bool
+}
+
+func (h *mergeHeap[T]) Len() int           { return len(h.heads) }
+func (h *mergeHeap[T]) Less(i, j int) bool { return h.less(h.heads[i].item, h.heads[j].item) }
+func (h *mergeHeap[T]) Swap(i, j int)      { h.heads[i], h.heads[j] = h.heads[j], h.heads[i] }
+func (h *mergeHeap[T]) Push(x any)         { h.heads = append(h.heads, x.(mergeHead[T])) }
+
+func (h *mergeHeap[T]) Pop() any {
+	last := h.heads[len(h.heads)-1]
+	h.heads = h.heads[:len(h.heads)-1]
+	return last
+}
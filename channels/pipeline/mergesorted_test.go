@@ -0,0 +1,67 @@
+package pipeline_test
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func intLess(a, b int) bool { return a < b }
+
+func TestMergeSortedRandomInputs(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rng := rand.New(rand.NewPCG(1, 2))
+	var chans []<-chan int
+	var all []int
+	// Sizes from empty to a thousand.
+	for _, size := range []int{0, 1, 17, 1000, rng.IntN(200)} {
+		items := make([]int, size)
+		for i := range items {
+			items[i] = rng.IntN(500)
+		}
+		slices.Sort(items)
+		all = append(all, items...)
+		chans = append(chans, pipeline.Source(ctx, items))
+	}
+	slices.Sort(all)
+
+	got := pipelinetest.CollectWithTimeout(t, pipeline.MergeSorted(ctx, intLess, chans...), time.Second)
+	if !slices.Equal(got, all) {
+		t.Errorf("got %d items, not the sorted concatenation of %d", len(got), len(all))
+	}
+}
+
+func TestMergeSortedNoInputs(t *testing.T) {
+	pipelinetest.AssertClosed(t, pipeline.MergeSorted(context.Background(), intLess), time.Second)
+}
+
+func TestMergeSortedCancelWaitingOnSlowInput(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	slow := make(chan int)
+	out := pipeline.MergeSorted(ctx, intLess, pipeline.Source(ctx, []int{1, 2, 3}), slow)
+	// The merge cannot emit anything before slow has a head.
+	select {
+	case v := <-out:
+		t.Fatalf("got %d before the slow input had an item", v)
+	case <-time.After(10 * time.Millisecond):
+	}
+	cancel()
+	pipelinetest.AssertClosed(t, out, time.Second)
+}
+
+func TestMergeSortedEarlyCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := pipeline.MergeSorted(ctx, intLess, pipeline.Source(ctx, pipelinetest.Items(100)), pipeline.Source(ctx, pipelinetest.Items(100)))
+	<-out
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+}
@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"container/heap"
+	"context"
+	"reflect"
+	"time"
+
+	"channelspractice/chanutil"
+)
+
+// MergeByTime merges chans, each of which is roughly ordered by the time ts
+// gives its items, into a single channel in time order. It holds every item
+// back until an item at least maxLateness later has come in on any input, or
+// until every input is closed, and then emits what it holds in time order,
+// items of the same time in the order they came in; a stalled input
+// therefore holds up the others by no more than maxLateness of their time.
+// An item that comes in after an item of a later time was emitted, which
+// makes it more than maxLateness late, is sent on the late channel right
+// away instead. As with Partition both channels must be consumed. They are
+// closed once every input is closed and the held items are emitted, or when
+// ctx is cancelled.
+func MergeByTime[T any](ctx context.Context, ts func(T) time.Time, maxLateness time.Duration, chans ...<-chan T) (ordered, late <-chan T) {
+	out := make(chan T)
+	lateOut := make(chan T)
+	inputs := append([]<-chan T(nil), chans...)
+
+	go func() {
+		defer close(out)
+		defer close(lateOut)
+		cases := make([]reflect.SelectCase, 0, len(inputs)+1)
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+		open := 0
+		for _, ch := range inputs {
+			c := reflect.SelectCase{Dir: reflect.SelectRecv}
+			if ch != nil {
+				c.Chan = reflect.ValueOf(ch)
+				open++
+			}
+			cases = append(cases, c)
+		}
+
+		held := &timeHeap[T]{}
+		// latest is the latest time seen and emitted the time of the item
+		// emitted last, once emittedAny.
+		var latest, emitted time.Time
+		var seen, emittedAny bool
+		// release emits in time order the held items that are maxLateness
+		// before latest, or all of them.
+		release := func(all bool) bool {
+			until := latest.Add(-maxLateness)
+			for held.Len() > 0 && (all || !held.items[0].at.After(until)) {
+				next := heap.Pop(held).(timedItem[T])
+				if chanutil.SendContext(ctx, out, next.item) != nil {
+					return false
+				}
+				emitted, emittedAny = next.at, true
+			}
+			return true
+		}
+
+		for seq := int64(0); open > 0; {
+			chosen, value, ok := reflect.Select(cases)
+			if chosen == 0 {
+				return
+			}
+			if !ok {
+				cases[chosen].Chan = reflect.Value{}
+				open--
+				continue
+			}
+			item, _ := value.Interface().(T)
+			at := ts(item)
+			if emittedAny && at.Before(emitted) {
+				if chanutil.SendContext(ctx, lateOut, item) != nil {
+					return
+				}
+				continue
+			}
+			heap.Push(held, timedItem[T]{item: item, at: at, seq: seq})
+			seq++
+			if !seen || at.After(latest) {
+				latest, seen = at, true
+			}
+			if !release(false) {
+				return
+			}
+		}
+		release(true)
+	}()
+	return out, lateOut
+}
+
+// timedItem is an item MergeByTime holds back, seq numbering them as they
+// came in.
+type timedItem[T any] struct {
+	item T
+	at   time.Time
+	seq  int64
+}
+
+// timeHeap is a heap.Interface over the items MergeByTime holds, earliest
+// first.
+type timeHeap[T any] struct {
+	items []timedItem[T]
+}
+
+func (h *timeHeap[T]) Len() int { return len(h.items) }
+
+func (h *timeHeap[T]) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if !a.at.Equal(b.at) {
+		return a.at.Before(b.at)
+	}
+	return a.seq < b.seq
+}
+
+func (h *timeHeap[T]) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *timeHeap[T]) Push(x any)    { h.items = append(h.items, x.(timedItem[T])) }
+
+func (h *timeHeap[T]) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
@@ -0,0 +1,159 @@
+package pipeline_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// timedEvent is an item of a timestamped stream, at as seconds.
+type timedEvent struct {
+	src string
+	at  int
+}
+
+func (e timedEvent) String() string { return fmt.Sprintf("%s%d", e.src, e.at) }
+
+func eventTime(e timedEvent) time.Time { return time.Unix(int64(e.at), 0) }
+
+// timeMerge is a MergeByTime of two streams the test feeds one timedEvent at a
+// time, with its outputs collected.
+type timeMerge struct {
+	a, b          chan timedEvent
+	ordered, late <-chan []timedEvent
+}
+
+func newTimeMerge(ctx context.Context, maxLateness time.Duration) *timeMerge {
+	m := &timeMerge{a: make(chan timedEvent), b: make(chan timedEvent)}
+	ordered, late := pipeline.MergeByTime(ctx, eventTime, maxLateness, m.a, m.b)
+	m.ordered, m.late = collectAsync(ordered), collectAsync(late)
+	return m
+}
+
+// collectAsync collects ch in the background, handing the items over once it
+// is closed.
+func collectAsync[T any](ch <-chan T) <-chan []T {
+	all := make(chan []T, 1)
+	go func() {
+		var items []T
+		for item := range ch {
+			items = append(items, item)
+		}
+		all <- items
+	}()
+	return all
+}
+
+// feed sends events to the inputs named by their src, one after the other,
+// and closes both inputs at the end.
+func (m *timeMerge) feed(t *testing.T, events ...timedEvent) {
+	t.Helper()
+	for _, e := range events {
+		ch := m.a
+		if e.src == "b" {
+			ch = m.b
+		}
+		select {
+		case ch <- e:
+		case <-time.After(pipelinetest.DefaultTimeout):
+			t.Fatalf("%v not taken", e)
+		}
+	}
+	close(m.a)
+	close(m.b)
+}
+
+func TestMergeByTimeOrders(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newTimeMerge(ctx, 2*time.Second)
+	m.feed(t, timedEvent{"a", 1}, timedEvent{"b", 2}, timedEvent{"a", 3}, timedEvent{"b", 4}, timedEvent{"a", 2},
+		timedEvent{"b", 5}, timedEvent{"a", 6}, timedEvent{"b", 7}, timedEvent{"a", 8})
+
+	want := []timedEvent{{"a", 1}, {"b", 2}, {"a", 2}, {"a", 3}, {"b", 4}, {"b", 5}, {"a", 6}, {"b", 7}, {"a", 8}}
+	if got := <-m.ordered; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := <-m.late; len(got) != 0 {
+		t.Errorf("late %v, want none within the lateness", got)
+	}
+}
+
+func TestMergeByTimeLateItems(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newTimeMerge(ctx, time.Second)
+	// b3 comes after a4 went out, b4 just in time.
+	m.feed(t, timedEvent{"a", 1}, timedEvent{"a", 2}, timedEvent{"a", 3}, timedEvent{"a", 4}, timedEvent{"a", 5},
+		timedEvent{"b", 3}, timedEvent{"b", 4}, timedEvent{"a", 6})
+
+	if got, want := <-m.ordered, []timedEvent{{"a", 1}, {"a", 2}, {"a", 3}, {"a", 4}, {"b", 4}, {"a", 5}, {"a", 6}}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := <-m.late; !slices.Equal(got, []timedEvent{{"b", 3}}) {
+		t.Errorf("late %v, want [b3]", got)
+	}
+}
+
+func TestMergeByTimeStalledInput(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b := make(chan timedEvent), make(chan timedEvent)
+	ordered, late := pipeline.MergeByTime(ctx, eventTime, 3*time.Second, a, b)
+	lateItems := collectAsync(late)
+
+	// b stays open and silent: a only waits for its own items to be 3s
+	// behind.
+	go func() {
+		for i := 1; i <= 10; i++ {
+			a <- timedEvent{"a", i}
+		}
+	}()
+	for i := 1; i <= 7; i++ {
+		select {
+		case got := <-ordered:
+			if want := (timedEvent{"a", i}); got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		case <-time.After(pipelinetest.DefaultTimeout):
+			t.Fatalf("a%d held up by the stalled input", i)
+		}
+	}
+	select {
+	case got := <-ordered:
+		t.Fatalf("got %v before a11, want a8 to a10 held back", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Once b wakes up its old items are late, while the held ones are
+	// flushed in order when the inputs close.
+	b <- timedEvent{"b", 2}
+	b <- timedEvent{"b", 9}
+	close(a)
+	close(b)
+	if got, want := pipelinetest.CollectWithTimeout(t, ordered, time.Second), []timedEvent{{"a", 8}, {"a", 9}, {"b", 9}, {"a", 10}}; !slices.Equal(got, want) {
+		t.Errorf("flushed %v, want %v", got, want)
+	}
+	if got := <-lateItems; !slices.Equal(got, []timedEvent{{"b", 2}}) {
+		t.Errorf("late %v, want [b2]", got)
+	}
+}
+
+func TestMergeByTimeCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	a := make(chan timedEvent)
+	ordered, late := pipeline.MergeByTime(ctx, eventTime, time.Second, a)
+	a <- timedEvent{"a", 1}
+	cancel()
+	pipelinetest.AssertClosed(t, ordered, time.Second)
+	pipelinetest.AssertClosed(t, late, time.Second)
+}
@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Meta is a small set of string key/value pairs travelling with an item. It
+// is copy-on-write: With returns a new Meta and never changes the receiver, so
+// a Meta can be shared between goroutines. The zero value is empty.
+type Meta struct {
+	values map[string]string
+}
+
+// Get returns the value stored under key.
+func (m Meta) Get(key string) (string, bool) {
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// With returns a copy of m with key set to value.
+func (m Meta) With(key, value string) Meta {
+	values := make(map[string]string, len(m.values)+1)
+	maps.Copy(values, m.values)
+	values[key] = value
+	return Meta{values: values}
+}
+
+// Len returns the number of keys in m.
+func (m Meta) Len() int {
+	return len(m.values)
+}
+
+// LogValue logs m as a group of its keys.
+func (m Meta) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, len(m.values))
+	for _, k := range slices.Sorted(maps.Keys(m.values)) {
+		attrs = append(attrs, slog.String(k, m.values[k]))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// String formats m as space separated key=value pairs sorted by key.
+func (m Meta) String() string {
+	keys := slices.Sorted(maps.Keys(m.values))
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%s", k, m.values[k])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// WithItemMeta makes SourceItems give every item of type T the Meta fn
+// returns for it and its offset, e.g. a request ID that every stage logs the
+// item with.
+func WithItemMeta[T any](fn func(offset int64, value T) Meta) Option {
+	return func(o *options) {
+		o.itemMeta = func(offset int64, value any) Meta {
+			v, _ := value.(T)
+			return fn(offset, v)
+		}
+	}
+}
+
+type metaKey struct{}
+
+// itemMeta is the Meta of the item being worked on, to which AddMeta adds.
+type itemMeta struct {
+	mu   sync.Mutex
+	meta Meta
+}
+
+func (m *itemMeta) get() Meta {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.meta
+}
+
+// MetaFrom returns the Meta of the item whose context ctx is, as the envelope
+// variants such as StageItems or SinkItems hand it to their function. It is
+// what Item.Meta holds outside of them.
+func MetaFrom(ctx context.Context) Meta {
+	if m, ok := ctx.Value(metaKey{}).(*itemMeta); ok {
+		return m.get()
+	}
+	return Meta{}
+}
+
+// AddMeta sets key to value in the Meta of the item whose context ctx is, for
+// the stages after this one. Only this item sees the change. It reports
+// false, and does nothing, if ctx belongs to no item.
+func AddMeta(ctx context.Context, key, value string) bool {
+	m, ok := ctx.Value(metaKey{}).(*itemMeta)
+	if !ok {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.meta = m.meta.With(key, value)
+	return true
+}
+
+// metaArgs returns the log arguments of the Meta of item, if it is an
+// envelope that has any.
+func metaArgs(item any) []any {
+	it, ok := item.(interface{ meta() Meta })
+	if !ok || it.meta().Len() == 0 {
+		return nil
+	}
+	return []any{"meta", it.meta()}
+}
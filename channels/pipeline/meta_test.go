@@ -0,0 +1,125 @@
+package pipeline_test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// requestIDs gives every item the request ID of its offset.
+var requestIDs = pipeline.WithItemMeta(func(offset int64, _ int) pipeline.Meta {
+	return pipeline.Meta{}.With("request_id", fmt.Sprintf("req-%d", offset))
+})
+
+func TestMetaFromSourceToSink(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	items := pipeline.SourceItems(ctx, pipelinetest.Items(200), nil, requestIDs)
+	// Every worker adds to the Meta of the items it gets.
+	doubled, stageErrc := pipeline.StagePoolItems(ctx, items, 16, func(ctx context.Context, n int) (int, error) {
+		if id, _ := pipeline.MetaFrom(ctx).Get("request_id"); id != fmt.Sprintf("req-%d", n) {
+			return 0, fmt.Errorf("item %d has request_id %q", n, id)
+		}
+		if n%2 == 0 {
+			pipeline.AddMeta(ctx, "even", "true")
+		}
+		pipeline.AddMeta(ctx, "retries", strconv.Itoa(n%3))
+		return n * 2, nil
+	})
+
+	var mu sync.Mutex
+	seen := make(map[int]pipeline.Meta)
+	done, sinkErrc := pipeline.SinkItems(ctx, doubled, func(ctx context.Context, n int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[n/2] = pipeline.MetaFrom(ctx)
+		return nil
+	})
+	for err := range pipeline.Merge(ctx, stageErrc, sinkErrc) {
+		t.Fatal(err)
+	}
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 200 {
+		t.Fatalf("sink saw %d items, want 200", len(seen))
+	}
+	for n, meta := range seen {
+		id, _ := meta.Get("request_id")
+		retries, _ := meta.Get("retries")
+		_, even := meta.Get("even")
+		if id != fmt.Sprintf("req-%d", n) || retries != strconv.Itoa(n%3) || even != (n%2 == 0) {
+			t.Errorf("item %d reached the sink with %v", n, meta)
+		}
+	}
+}
+
+func TestMetaCopyOnWrite(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Every item starts from the same Meta.
+	shared := pipeline.Meta{}.With("tenant", "a")
+	in := make(chan pipeline.Item[int], 3)
+	for n := range 3 {
+		in <- pipeline.Item[int]{Ctx: ctx, Value: n, Meta: shared}
+	}
+	close(in)
+	out, errc := pipeline.StageItems(ctx, in, func(ctx context.Context, n int) (int, error) {
+		pipeline.AddMeta(ctx, "n", strconv.Itoa(n))
+		return n, nil
+	})
+
+	for _, it := range pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout) {
+		if got, _ := it.Meta.Get("n"); got != strconv.Itoa(it.Value) || it.Meta.Len() != 2 {
+			t.Errorf("item %d has %v, want tenant=a and only its own n", it.Value, it.Meta)
+		}
+	}
+	pipelinetest.AssertClosed(t, errc, pipelinetest.DefaultTimeout)
+	if shared.Len() != 1 {
+		t.Errorf("the shared Meta changed to %v", shared)
+	}
+}
+
+func TestMetaOutsideAnItem(t *testing.T) {
+	if pipeline.AddMeta(context.Background(), "k", "v") {
+		t.Error("AddMeta added to a context of no item")
+	}
+	if meta := pipeline.MetaFrom(context.Background()); meta.Len() != 0 {
+		t.Errorf("MetaFrom = %v, want an empty Meta", meta)
+	}
+}
+
+func TestMetaLoggedAndInErrors(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := &recordHandler{level: slog.LevelDebug}
+	items := pipeline.SourceItems(ctx, pipelinetest.Items(8), nil, requestIDs)
+	out, errc := pipeline.StageItems(ctx, items, failOnSix, pipeline.WithName("transform"), pipeline.WithLogger(slog.New(h)))
+	collected := collectAsync(errc)
+	pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout)
+	errs := <-collected
+
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "(item 6 [request_id=req-6])") {
+		t.Errorf("errors %v, want the failure of 6 naming its request_id", errs)
+	}
+	failed := h.find("item failed")
+	if len(failed) != 1 || failed[0]["item"] != "6" || !strings.Contains(failed[0]["meta"], "request_id=req-6") {
+		t.Errorf("item failed records = %v, want item=6 with its request_id", failed)
+	}
+	for _, processed := range h.find("item processed") {
+		if id := "request_id=req-" + processed["item"]; !strings.Contains(processed["meta"], id) {
+			t.Errorf("item processed record %v does not carry %s", processed, id)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package pipeline
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// occupancyInterval is how often a stage samples how full its output buffer
+// is.
+const occupancyInterval = 100 * time.Millisecond
+
+// throughputWindow is how far back Throughput looks, in seconds.
+const throughputWindow = 10
+
+// Metrics holds the counters of a single stage. All fields are updated
+// atomically, so one Metrics value can be shared by the workers of a pool and
+// read while the stage is running.
+type Metrics struct {
+	In             atomic.Int64
+	Out            atomic.Int64
+	Errors         atomic.Int64
+	ProcessingTime atomic.Int64
+
+	// ErrorsDropped counts the reports of failed items dropped because the
+	// error buffer set with WithErrorBuffer was full.
+	ErrorsDropped atomic.Int64
+
+	// Buffered and Capacity are the length and capacity of the stage's
+	// output channel, sampled every 100ms while the stage runs.
+	Buffered atomic.Int64
+	Capacity atomic.Int64
+
+	// Latency is the total time envelopes reaching a SinkItems spent in the
+	// pipeline since their source emitted them, over LatencyCount items.
+	Latency      atomic.Int64
+	LatencyCount atomic.Int64
+
+	// Workers is the number of workers currently processing items.
+	Workers atomic.Int64
+
+	// InFlight is the number of envelopes a SourceItems under
+	// WithFlowControl emitted that have not left the pipeline yet, and
+	// MaxInFlight the most there ever were at once.
+	InFlight    atomic.Int64
+	MaxInFlight atomic.Int64
+
+	// Processing is the distribution of the time the stage function took
+	// per item.
+	Processing Histogram
+
+	throughput rateWindow
+}
+
+// Throughput returns the items per second the stage emitted over the last 10
+// seconds, or since its first item if that was more recent.
+func (m *Metrics) Throughput() float64 {
+	return m.throughput.rate(time.Now())
+}
+
+// MetricsSnapshot is a point-in-time copy of Metrics.
+type MetricsSnapshot struct {
+	In             int64         `json:"in"`
+	Out            int64         `json:"out"`
+	Errors         int64         `json:"errors"`
+	ProcessingTime time.Duration `json:"processing_time_ns"`
+	ErrorsDropped  int64         `json:"errors_dropped"`
+	Buffered       int64         `json:"buffered"`
+	Capacity       int64         `json:"capacity"`
+	Latency        time.Duration `json:"latency_ns"`
+	LatencyCount   int64         `json:"latency_count"`
+	Workers        int64         `json:"workers"`
+	InFlight       int64         `json:"in_flight"`
+	MaxInFlight    int64         `json:"max_in_flight"`
+	Throughput     float64       `json:"throughput"`
+	P50            time.Duration `json:"p50_ns"`
+	P95            time.Duration `json:"p95_ns"`
+	P99            time.Duration `json:"p99_ns"`
+}
+
+// Snapshot returns the current counter values.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	return MetricsSnapshot{
+		In:             m.In.Load(),
+		Out:            m.Out.Load(),
+		Errors:         m.Errors.Load(),
+		ProcessingTime: time.Duration(m.ProcessingTime.Load()),
+		ErrorsDropped:  m.ErrorsDropped.Load(),
+		Buffered:       m.Buffered.Load(),
+		Capacity:       m.Capacity.Load(),
+		Latency:        time.Duration(m.Latency.Load()),
+		LatencyCount:   m.LatencyCount.Load(),
+		Workers:        m.Workers.Load(),
+		InFlight:       m.InFlight.Load(),
+		MaxInFlight:    m.MaxInFlight.Load(),
+		Throughput:     m.Throughput(),
+		P50:            m.Processing.Quantile(0.50),
+		P95:            m.Processing.Quantile(0.95),
+		P99:            m.Processing.Quantile(0.99),
+	}
+}
+
+// Publish exposes the metrics under name in expvar, which serves them on
+// /debug/vars of the default HTTP mux. Like expvar.Publish it panics if name
+// is already taken.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return m.Snapshot()
+	}))
+}
+
+// WithMetrics makes a stage count its items, errors and processing time in m.
+func WithMetrics(m *Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+func (m *Metrics) itemIn() {
+	if m != nil {
+		m.In.Add(1)
+	}
+}
+
+func (m *Metrics) itemOut() {
+	if m != nil {
+		m.Out.Add(1)
+		m.throughput.add(time.Now())
+	}
+}
+
+func (m *Metrics) processed(start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	d := time.Since(start)
+	m.ProcessingTime.Add(int64(d))
+	m.Processing.Record(d)
+	if err != nil {
+		m.Errors.Add(1)
+	}
+}
+
+func (m *Metrics) workerStarted() {
+	if m != nil {
+		m.Workers.Add(1)
+	}
+}
+
+func (m *Metrics) workerStopped() {
+	if m != nil {
+		m.Workers.Add(-1)
+	}
+}
+
+func (m *Metrics) errorDropped() {
+	if m != nil {
+		m.ErrorsDropped.Add(1)
+	}
+}
+
+func (m *Metrics) inFlight(n int64) {
+	if m == nil {
+		return
+	}
+	m.InFlight.Store(n)
+	for {
+		highest := m.MaxInFlight.Load()
+		if n <= highest || m.MaxInFlight.CompareAndSwap(highest, n) {
+			return
+		}
+	}
+}
+
+func (m *Metrics) latency(emitted time.Time) {
+	if m == nil || emitted.IsZero() {
+		return
+	}
+	m.Latency.Add(int64(time.Since(emitted)))
+	m.LatencyCount.Add(1)
+}
+
+// sampleOccupancy records how full ch is in m until stop is closed. It does
+// nothing without metrics or for an unbuffered channel.
+func sampleOccupancy[T any](m *Metrics, ch chan T, stop <-chan struct{}) {
+	if m == nil || cap(ch) == 0 {
+		return
+	}
+	m.Capacity.Store(int64(cap(ch)))
+	go func() {
+		ticker := time.NewTicker(occupancyInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				m.Buffered.Store(0)
+				return
+			case <-ticker.C:
+				m.Buffered.Store(int64(len(ch)))
+			}
+		}
+	}()
+}
+
+// rateWindow counts events per second over the last throughputWindow
+// seconds, in a ring of one slot per second.
+type rateWindow struct {
+	mu     sync.Mutex
+	first  time.Time
+	secs   [throughputWindow]int64
+	counts [throughputWindow]int64
+}
+
+func (w *rateWindow) add(now time.Time) {
+	sec := now.Unix()
+	i := sec % throughputWindow
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.first.IsZero() {
+		w.first = now
+	}
+	if w.secs[i] != sec {
+		w.secs[i], w.counts[i] = sec, 0
+	}
+	w.counts[i]++
+}
+
+// rate returns the events per second in the window ending at now. A window
+// younger than a second counts as a second, so a burst of events right after
+// the first does not read as a huge rate.
+func (w *rateWindow) rate(now time.Time) float64 {
+	sec := now.Unix()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.first.IsZero() {
+		return 0
+	}
+	var n int64
+	for i, s := range w.secs {
+		if s > sec-throughputWindow && s <= sec {
+			n += w.counts[i]
+		}
+	}
+	span := min(max(now.Sub(w.first), time.Second), throughputWindow*time.Second)
+	return float64(n) / span.Seconds()
+}
@@ -0,0 +1,248 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestMetricsFailFast(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	m := &pipeline.Metrics{}
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Stage(ctx, in, failOnSix, pipeline.WithMetrics(m))
+	})
+	pipelinetest.Run(t, stage, pipelinetest.Items(11))
+
+	// 0 to 6 are read, 6 fails and stops the stage.
+	if s := m.Snapshot(); s.In != 7 || s.Out != 6 || s.Errors != 1 {
+		t.Errorf("in=%d out=%d errors=%d, want in=7 out=6 errors=1", s.In, s.Out, s.Errors)
+	}
+}
+
+func TestMetricsPool(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	m := &pipeline.Metrics{}
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePool(ctx, in, 4, failOnSix, pipeline.WithMetrics(m), pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	})
+	pipelinetest.Run(t, stage, pipelinetest.Items(1000))
+
+	if s := m.Snapshot(); s.In != 1000 || s.Out != 999 || s.Errors != 1 || s.ProcessingTime <= 0 {
+		t.Errorf("in=%d out=%d errors=%d processing=%v, want in=1000 out=999 errors=1", s.In, s.Out, s.Errors, s.ProcessingTime)
+	}
+}
+
+func TestMetricsPublish(t *testing.T) {
+	m := &pipeline.Metrics{}
+	m.In.Add(3)
+	// expvar names are global and -count reruns the test.
+	name := fmt.Sprintf("metrics_test_%d", time.Now().UnixNano())
+	m.Publish(name)
+
+	var s pipeline.MetricsSnapshot
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.In != 3 {
+		t.Errorf("published in=%d, want 3", s.In)
+	}
+}
+
+func TestOccupancyFullBeforeBlockedSink(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry := pipeline.NewRegistry()
+	release := make(chan struct{})
+
+	doubled, transformErrs := pipeline.Stage(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), double,
+		pipeline.WithName("transform"), pipeline.WithBuffer(8), pipeline.WithRegistry(registry), pipeline.WithMetrics(&pipeline.Metrics{}))
+	done, saveErrs := pipeline.Sink(ctx, doubled, func(context.Context, int) error {
+		<-release
+		return nil
+	})
+	errs := pipeline.Merge(ctx, transformErrs, saveErrs)
+
+	var occupancy float64
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if stats := registry.Snapshot(); len(stats) == 1 {
+			if occupancy = stats[0].Occupancy; occupancy == 1 {
+				break
+			}
+		}
+	}
+	if occupancy != 1 {
+		t.Errorf("transform buffer occupancy = %v behind a blocked sink, want 1", occupancy)
+	}
+	close(release)
+	pipelinetest.CollectWithTimeout(t, errs, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+}
+
+func TestSnapshotLatencyOfEnvelopes(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry := pipeline.NewRegistry()
+	src := pipeline.SourceItems(ctx, pipelinetest.Items(5), nil)
+	done, errc := pipeline.SinkItems(ctx, src, func(context.Context, int) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}, pipeline.WithName("save"), pipeline.WithRegistry(registry), pipeline.WithMetrics(&pipeline.Metrics{}))
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	stats := registry.Snapshot()
+	if len(stats) != 1 || stats[0].Name != "save" || stats[0].In != 5 || stats[0].AvgLatency < time.Millisecond {
+		t.Errorf("Snapshot = %+v, want save with 5 items at least 1ms in the pipeline each", stats)
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	var h pipeline.Histogram
+	if got := h.Quantile(0.5); got != 0 {
+		t.Errorf("Quantile of an empty histogram = %v, want 0", got)
+	}
+	// 1µs to 10ms, evenly spread.
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	if got := h.Count(); got != 10000 {
+		t.Errorf("Count = %d, want 10000", got)
+	}
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.5, 5 * time.Millisecond},
+		{0.95, 9500 * time.Microsecond},
+		{0.99, 9900 * time.Microsecond},
+		{1, 10 * time.Millisecond},
+	} {
+		got := h.Quantile(tc.q)
+		if diff := (got - tc.want).Abs(); diff > tc.want/32 {
+			t.Errorf("Quantile(%v) = %v, want %v within 1/32", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestHistogramSmallAndHugeValues(t *testing.T) {
+	var h pipeline.Histogram
+	for _, d := range []time.Duration{-time.Second, 0, 3, 15} {
+		h.Record(d)
+	}
+	// Values below 16ns are counted exactly, negative ones as 0.
+	if got := h.Quantile(0.5); got != 0 {
+		t.Errorf("p50 = %v, want 0", got)
+	}
+	if got := h.Quantile(0.75); got != 3 {
+		t.Errorf("p75 = %v, want 3ns", got)
+	}
+	if got := h.Quantile(1); got != 15 {
+		t.Errorf("p100 = %v, want 15ns", got)
+	}
+	h.Record(math.MaxInt64)
+	if got, want := h.Quantile(1), time.Duration(math.MaxInt64); (got - want).Abs() > want/32 {
+		t.Errorf("p100 = %v, want %v within 1/32", got, want)
+	}
+}
+
+func TestMetricsWorkersThroughputAndPercentiles(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := &pipeline.Metrics{}
+	release := make(chan struct{})
+
+	out, errc := pipeline.StagePool(ctx, pipeline.Source(ctx, pipelinetest.Items(20)), 3, func(_ context.Context, n int) (int, error) {
+		<-release
+		time.Sleep(time.Millisecond)
+		return n, nil
+	}, pipeline.WithMetrics(m))
+	for deadline := time.Now().Add(time.Second); m.Snapshot().Workers != 3 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if got := m.Snapshot().Workers; got != 3 {
+		t.Errorf("workers = %d while the pool runs, want 3", got)
+	}
+	close(release)
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+
+	s := m.Snapshot()
+	if s.Workers != 0 {
+		t.Errorf("workers = %d once the pool exited, want 0", s.Workers)
+	}
+	// All 20 items came out within the first second.
+	if s.Throughput != 20 {
+		t.Errorf("throughput = %v items/s, want 20", s.Throughput)
+	}
+	if s.P50 < time.Millisecond || s.P95 < s.P50 || s.P99 < s.P95 {
+		t.Errorf("p50=%v p95=%v p99=%v, want at least 1ms and increasing", s.P50, s.P95, s.P99)
+	}
+}
+
+func TestMetricsNoAllocationsPerItem(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts func() []pipeline.Option
+	}{
+		{"disabled", func() []pipeline.Option { return nil }},
+		{"enabled", func() []pipeline.Option { return []pipeline.Option{pipeline.WithMetrics(&pipeline.Metrics{})} }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			allocs := func(n int) float64 {
+				items := pipelinetest.Items(n)
+				return testing.AllocsPerRun(20, func() {
+					ctx, cancel := context.WithCancel(context.Background())
+					defer cancel()
+					out, transformErrs := pipeline.StagePool(ctx, pipeline.Source(ctx, items), 2, identity, tc.opts()...)
+					done, saveErrs := pipeline.Sink(ctx, out, func(context.Context, int) error { return nil }, tc.opts()...)
+					drain(done, pipeline.Merge(ctx, transformErrs, saveErrs))
+				})
+			}
+			// Whatever the stages allocate up front, 990 more items add
+			// nothing but scheduling noise.
+			if perItem := (allocs(1000) - allocs(10)) / 990; perItem > 0.01 {
+				t.Errorf("%.3f allocations per item, want 0", perItem)
+			}
+		})
+	}
+}
+
+func TestRegistryWriteStats(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry := pipeline.NewRegistry()
+
+	out, errc := pipeline.StagePool(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), 2, identity,
+		pipeline.WithName("transform"), pipeline.WithRegistry(registry), pipeline.WithMetrics(&pipeline.Metrics{}), pipeline.WithBuffer(4))
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+
+	var b strings.Builder
+	if err := registry.WriteStats(&b); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("WriteStats wrote\n%s\nwant a header and one line", b.String())
+	}
+	if fields := strings.Fields(lines[0]); !slices.Equal(fields, []string{"stage", "in", "out", "errors", "items/s", "p50", "p95", "p99", "workers", "buffer"}) {
+		t.Errorf("header %q", lines[0])
+	}
+	if fields := strings.Fields(lines[1]); len(fields) != 10 || fields[0] != "transform" || fields[1] != "10" || fields[2] != "10" || fields[4] != "10.0" || fields[8] != "0" || fields[9] != "0/4" {
+		t.Errorf("stats line %q, want transform with 10 items in and out at 10 items/s, no worker left and an empty buffer of 4", lines[1])
+	}
+}
@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultShadowLimit is the number of shadow calls a Mirror runs at once
+// unless set with WithShadowLimit.
+const defaultShadowLimit = 8
+
+// WithShadowLimit lets a Mirror run at most n shadow calls at once.
+func WithShadowLimit(n int) Option {
+	return func(o *options) {
+		o.shadowLimit = n
+	}
+}
+
+// Mirror is a Stage running primary on every item from in, as a Stage would,
+// while it tries a shadow implementation on the same items to compare the
+// two. Each shadow call starts with its primary call and runs on its own;
+// once both returned compare is called with the two results. Only the
+// primary results are sent on, and only primary errors are reported, so a
+// failing or panicking shadow never fails the pipeline: its error, a
+// *PanicError for a panic, goes to compare as bErr.
+//
+// At most WithShadowLimit shadow calls run at once. An item that arrives
+// while they all do goes without a shadow rather than waiting, so the shadow
+// never slows the primary down. Shadow calls are cancelled with ctx, not
+// with the primary call. The error channel is closed once the last shadow
+// call returned and compare was done with it.
+func Mirror[T, U any](ctx context.Context, in <-chan T, primary, shadow func(context.Context, T) (U, error), compare func(item T, a, b U, aErr, bErr error), opts ...Option) (<-chan U, <-chan error) {
+	o := newOptions(opts)
+	name := o.stageName("mirror")
+	limit := o.shadowLimit
+	if limit <= 0 {
+		limit = defaultShadowLimit
+	}
+	slots := make(chan struct{}, limit)
+	// The stage calls fn the way it calls any function, the timeout and the
+	// trace included, so primary only needs its panics recovered.
+	inner := o
+	inner.itemTimeout = 0
+	inner.trace = nil
+	type result struct {
+		value U
+		err   error
+	}
+	var shadows sync.WaitGroup
+	fn := func(itemCtx context.Context, item T) (U, error) {
+		var primaryDone chan result
+		select {
+		case slots <- struct{}{}:
+			primaryDone = make(chan result, 1)
+			shadows.Add(1)
+			go func() {
+				defer shadows.Done()
+				defer func() { <-slots }()
+				b, bErr := call(ctx, o, name+" shadow", shadow, item)
+				a := <-primaryDone
+				compare(item, a.value, b, a.err, bErr)
+			}()
+		default:
+		}
+		a, aErr := call(itemCtx, inner, name, primary, item)
+		if primaryDone != nil {
+			primaryDone <- result{value: a, err: aErr}
+		}
+		return a, aErr
+	}
+	out, stageErrc := Stage(ctx, in, fn, append(opts, WithName(name))...)
+
+	errc := make(chan error, cap(stageErrc))
+	go func() {
+		defer close(errc)
+		for err := range stageErrc {
+			errc <- err
+		}
+		shadows.Wait()
+	}()
+	return out, errc
+}
@@ -0,0 +1,192 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// comparison is what Mirror passed to compare for one item.
+type comparison struct {
+	item, a, b int
+	aErr, bErr error
+}
+
+// comparisons records the calls of compare.
+type comparisons struct {
+	mu  sync.Mutex
+	all []comparison
+}
+
+func (c *comparisons) compare(item, a, b int, aErr, bErr error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.all = append(c.all, comparison{item: item, a: a, b: b, aErr: aErr, bErr: bErr})
+}
+
+// mismatches returns the items whose results differed.
+func (c *comparisons) mismatches() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var items []int
+	for _, cmp := range c.all {
+		if cmp.a != cmp.b || (cmp.aErr == nil) != (cmp.bErr == nil) {
+			items = append(items, cmp.item)
+		}
+	}
+	slices.Sort(items)
+	return items
+}
+
+func (c *comparisons) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.all)
+}
+
+func TestMirrorShadowSlowerThanPrimary(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var cmps comparisons
+	release := make(chan struct{})
+	out, errc := pipeline.Mirror(ctx, pipeline.Source(ctx, pipelinetest.Items(5)), double,
+		func(ctx context.Context, n int) (int, error) {
+			<-release
+			return n * 2, nil
+		}, cmps.compare, pipeline.WithShadowLimit(5))
+
+	// The primary results arrive while every shadow is still held up.
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{0, 2, 4, 6, 8}) {
+		t.Errorf("got %v, want the primary results [0 2 4 6 8]", got)
+	}
+	if n := cmps.len(); n != 0 {
+		t.Errorf("compare called %d times before the shadows returned", n)
+	}
+	close(release)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	// The error channel only closes after the last comparison.
+	if n := cmps.len(); n != 5 {
+		t.Errorf("compare called %d times, want 5", n)
+	}
+	if m := cmps.mismatches(); len(m) != 0 {
+		t.Errorf("mismatches %v, want none", m)
+	}
+}
+
+func TestMirrorShadowPanic(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var cmps comparisons
+	out, errc := pipeline.Mirror(ctx, pipeline.Source(ctx, []int{1, 2, 3}), double,
+		func(_ context.Context, n int) (int, error) {
+			if n == 2 {
+				panic("shadow bug")
+			}
+			return n * 2, nil
+		}, cmps.compare, pipeline.WithShadowLimit(3))
+
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{2, 4, 6}) {
+		t.Errorf("got %v, want the primary results despite the panic", got)
+	}
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) > 0 {
+		t.Fatalf("errors = %v, want the shadow panic kept out of the pipeline", errs)
+	}
+	var panicked []comparison
+	for _, cmp := range cmps.all {
+		if cmp.bErr != nil {
+			panicked = append(panicked, cmp)
+		}
+	}
+	var panicErr *pipeline.PanicError
+	if len(panicked) != 1 || panicked[0].item != 2 || !errors.As(panicked[0].bErr, &panicErr) || panicErr.Value != "shadow bug" {
+		t.Fatalf("comparisons with a shadow error %v, want the panic on 2", panicked)
+	}
+	if panicErr.Stage != "mirror shadow" {
+		t.Errorf("panic in stage %q, want %q", panicErr.Stage, "mirror shadow")
+	}
+}
+
+func TestMirrorCountsMismatches(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var cmps comparisons
+	// The rewrite gets multiples of three wrong and fails on 7, which the
+	// primary handles, while both fail on 6.
+	mirror := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Mirror(ctx, in, failOnSix,
+			func(ctx context.Context, n int) (int, error) {
+				switch {
+				case n == 7:
+					return 0, errors.New("not implemented")
+				case n%3 == 0 && n != 6:
+					return n * 3, nil
+				}
+				return failOnSix(ctx, n)
+			}, cmps.compare, pipeline.WithShadowLimit(10), pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	})
+
+	out, errs := pipelinetest.Run(t, mirror, pipelinetest.Items(10))
+	if !slices.Equal(out, []int{0, 2, 4, 6, 8, 10, 14, 16, 18}) {
+		t.Errorf("got %v, want the primary results", out)
+	}
+	if len(errs) != 1 {
+		t.Errorf("errors = %v, want the primary failure on 6 alone", errs)
+	}
+	if n := cmps.len(); n != 10 {
+		t.Errorf("compare called %d times, want 10", n)
+	}
+	if m := cmps.mismatches(); !slices.Equal(m, []int{3, 7, 9}) {
+		t.Errorf("mismatches %v, want [3 7 9]", m)
+	}
+}
+
+func TestMirrorShadowLimit(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var cmps comparisons
+	release := make(chan struct{})
+	out, errc := pipeline.Mirror(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), double,
+		func(ctx context.Context, n int) (int, error) {
+			<-release
+			return n * 2, nil
+		}, cmps.compare, pipeline.WithShadowLimit(2))
+
+	// With both shadow slots held the other items go without a shadow
+	// instead of waiting for one.
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 10 {
+		t.Errorf("got %v, want all 10 primary results", got)
+	}
+	close(release)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if n := cmps.len(); n != 2 {
+		t.Errorf("compare called %d times, want 2 for the shadow limit", n)
+	}
+}
+
+func TestMirrorCancelStopsShadows(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	var cmps comparisons
+	out, errc := pipeline.Mirror(ctx, pipeline.Source(ctx, []int{1}), double,
+		func(ctx context.Context, _ int) (int, error) {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}, cmps.compare)
+
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	cancel()
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if len(cmps.all) != 1 || !errors.Is(cmps.all[0].bErr, context.Canceled) {
+		t.Errorf("comparisons %v, want the shadow cancelled with the pipeline", cmps.all)
+	}
+}
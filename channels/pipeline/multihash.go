@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"sync"
+)
+
+// MultiHash forwards the chunks read from in unchanged while feeding them to
+// one hash per algo, each in a goroutine of its own, and sends the map of
+// digests once in is closed and every hash has caught up. A hash that falls
+// behind holds up the passthrough rather than losing or reordering chunks.
+// Hashes may still be reading a chunk after it has been passed on, so
+// consumers must not modify chunks.
+//
+// The packages implementing algos must be linked in, e.g. by importing
+// crypto/sha256, see crypto.Hash.New. If ctx is cancelled before in is closed
+// no digests are sent. All three channels are closed when the stage exits.
+// WithBuffer sizes the passthrough channel; the stage is named "multihash"
+// unless WithName says otherwise.
+func MultiHash(ctx context.Context, in <-chan []byte, algos []crypto.Hash, opts ...Option) (<-chan []byte, <-chan map[crypto.Hash][]byte, <-chan error) {
+	o := newOptions(opts)
+	out := make(chan []byte, o.buffer)
+	digests := make(chan map[crypto.Hash][]byte, 1)
+	errc := make(chan error, 1)
+	name := o.stageName("multihash")
+	for _, algo := range algos {
+		if !algo.Available() {
+			errc <- &StageError{Stage: name, Err: fmt.Errorf("hash %v is not linked into the binary", algo)}
+			close(errc)
+			close(out)
+			close(digests)
+			return out, digests, errc
+		}
+	}
+
+	feeds := make([]chan []byte, len(algos))
+	sums := make([][]byte, len(algos))
+	var wg sync.WaitGroup
+	wg.Add(len(algos))
+	for i, algo := range algos {
+		// A small buffer lets the passthrough run a few chunks ahead of
+		// the slowest hash.
+		feeds[i] = make(chan []byte, 4)
+		go func() {
+			defer wg.Done()
+			h := algo.New()
+			for chunk := range feeds[i] {
+				h.Write(chunk)
+			}
+			sums[i] = h.Sum(nil)
+		}()
+	}
+
+	unregister := o.register("stage")
+	o.logStart(ctx, name)
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(digests)
+		defer close(out)
+		complete := func() bool {
+			for chunk := range OrDone(ctx, in) {
+				for _, feed := range feeds {
+					select {
+					case <-ctx.Done():
+						return false
+					case feed <- chunk:
+					}
+				}
+				select {
+				case <-ctx.Done():
+					return false
+				case out <- chunk:
+				}
+			}
+			return ctx.Err() == nil
+		}()
+		for _, feed := range feeds {
+			close(feed)
+		}
+		wg.Wait()
+		if !complete {
+			return
+		}
+		result := make(map[crypto.Hash][]byte, len(algos))
+		for i, algo := range algos {
+			result[algo] = sums[i]
+		}
+		digests <- result
+	}()
+	return out, digests, errc
+}
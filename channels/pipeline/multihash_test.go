@@ -0,0 +1,80 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestMultiHashMatchesStdlib(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chunks := make([][]byte, 200)
+	for i := range chunks {
+		chunks[i] = bytes.Repeat([]byte{byte(i)}, 1000+i)
+	}
+	algos := []crypto.Hash{crypto.MD5, crypto.SHA1, crypto.SHA256}
+	out, digests, errc := pipeline.MultiHash(ctx, pipeline.Source(ctx, chunks), algos, pipeline.WithBuffer(8))
+
+	passed := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	got := pipelinetest.CollectWithTimeout(t, digests, time.Second)
+	if len(got) != 1 {
+		t.Fatalf("got %d digest maps, want 1", len(got))
+	}
+
+	data := bytes.Join(chunks, nil)
+	if !bytes.Equal(bytes.Join(passed, nil), data) {
+		t.Error("passthrough chunks differ from the input or are out of order")
+	}
+	md5Sum, sha1Sum, sha256Sum := md5.Sum(data), sha1.Sum(data), sha256.Sum256(data)
+	for algo, want := range map[crypto.Hash][]byte{crypto.MD5: md5Sum[:], crypto.SHA1: sha1Sum[:], crypto.SHA256: sha256Sum[:]} {
+		if !bytes.Equal(got[0][algo], want) {
+			t.Errorf("%v = %x, want %x", algo, got[0][algo], want)
+		}
+	}
+}
+
+func TestMultiHashCancelSendsNoDigests(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan []byte)
+	out, digests, errc := pipeline.MultiHash(ctx, in, []crypto.Hash{crypto.SHA256})
+	in <- []byte("partial")
+	<-out
+	cancel()
+
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if got := pipelinetest.CollectWithTimeout(t, digests, time.Second); len(got) != 0 {
+		t.Errorf("got digests %v after cancellation", got)
+	}
+}
+
+func TestMultiHashUnavailableAlgorithm(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Nothing in the test binary links in MD4.
+	out, digests, errc := pipeline.MultiHash(ctx, make(chan []byte), []crypto.Hash{crypto.SHA256, crypto.MD4}, pipeline.WithName("checksums"))
+
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, out, time.Second)
+	pipelinetest.AssertClosed(t, digests, time.Second)
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "checksums" {
+		t.Errorf("errors = %v, want one *StageError of checksums", errs)
+	}
+}
@@ -0,0 +1,33 @@
+package pipeline
+
+import "context"
+
+// WithOnCancel makes Stage, StagePool and Sink run fn once their context is
+// cancelled while they are still running, in a goroutine of its own as with
+// context.AfterFunc. It runs even while the stage is stuck in its function,
+// so it can close what that is blocked on, such as the file or connection
+// behind a Write that would otherwise never return. A pool's context is also
+// cancelled when one of its workers fails. A stage that exits first never
+// runs fn. Every WithOnCancel adds a callback.
+func WithOnCancel(fn func()) Option {
+	return func(o *options) {
+		o.onCancel = append(o.onCancel, fn)
+	}
+}
+
+// watchCancel registers the WithOnCancel callbacks on ctx and returns the
+// function that unregisters them again.
+func (o options) watchCancel(ctx context.Context) func() {
+	if len(o.onCancel) == 0 {
+		return func() {}
+	}
+	stops := make([]func() bool, len(o.onCancel))
+	for i, fn := range o.onCancel {
+		stops[i] = context.AfterFunc(ctx, fn)
+	}
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestOnCancelUnblocksSink(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Nobody reads the pipe, so the write only returns once it is closed.
+	_, w := io.Pipe()
+	writeErr := make(chan error, 1)
+	in := make(chan []byte, 1)
+	in <- []byte("stuck\n")
+	done, errc := pipeline.Sink(ctx, in, func(_ context.Context, b []byte) error {
+		_, err := w.Write(b)
+		writeErr <- err
+		return err
+	}, pipeline.WithOnCancel(func() { w.Close() }))
+
+	select {
+	case err := <-writeErr:
+		t.Fatalf("write returned %v before the cancellation", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case err := <-writeErr:
+		if !errors.Is(err, io.ErrClosedPipe) {
+			t.Errorf("write = %v, want io.ErrClosedPipe", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write still blocked after the cancellation")
+	}
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+}
+
+func TestOnCancelUnblocksPoolOnFailure(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// One worker blocks on the pipe while the other fails the pool.
+	_, w := io.Pipe()
+	out, errc := pipeline.StagePool(ctx, pipeline.Source(ctx, []int{0, 1}), 2, func(_ context.Context, n int) (int, error) {
+		if n == 1 {
+			time.Sleep(20 * time.Millisecond)
+			return 0, errBoom
+		}
+		_, err := w.Write([]byte("stuck\n"))
+		return n, err
+	}, pipeline.WithOnCancel(func() { w.Close() }))
+
+	// Neither worker has a result to send.
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if len(errs) != 1 || !errors.Is(errs[0], errBoom) {
+		t.Errorf("errors = %v, want only errBoom", errs)
+	}
+	pipelinetest.AssertClosed(t, out, time.Second)
+}
+
+func TestOnCancelNotRunAfterExit(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int32
+	count := pipeline.WithOnCancel(func() { calls.Add(1) })
+
+	out, transformErrs := pipeline.StagePool(ctx, pipeline.Source(ctx, pipelinetest.Items(3)), 2, identity, count)
+	done, saveErrs := pipeline.Sink(ctx, out, func(context.Context, int) error { return nil }, count, count)
+	pipelinetest.CollectWithTimeout(t, pipeline.Merge(ctx, transformErrs, saveErrs), time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+	cancel()
+
+	time.Sleep(20 * time.Millisecond)
+	if n := calls.Load(); n != 0 {
+		t.Errorf("%d callbacks ran after the stages exited, want none", n)
+	}
+}
@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"channelspractice/chanutil"
+)
+
+// StagePoolOrdered is like StagePool but emits results in the order their
+// inputs were read. At most window items are in flight or waiting in the
+// reorder buffer, so a single slow item applies backpressure instead of
+// letting the buffer grow without bound. An error is reported when its item
+// reaches the head of the buffer, after every earlier result was emitted.
+// Errors are sent as a *StageError. Under the SkipAndReport policy failed
+// items are sent on the error channel in their input position.
+func StagePoolOrdered[T, U any](ctx context.Context, in <-chan T, workers, window int, fn func(context.Context, T) (U, error), opts ...Option) (<-chan U, <-chan error) {
+	o := newOptions(opts)
+	type job struct {
+		seq  int
+		item T
+	}
+	type result struct {
+		seq   int
+		item  T
+		value U
+		err   error
+	}
+
+	out := make(chan U, o.buffer)
+	errc := o.newErrc()
+	poolCtx, cancel := context.WithCancel(ctx)
+	sampleOccupancy(o.metrics, out, poolCtx.Done())
+	unregister := o.register("stage")
+	name := o.stageName("stage")
+	o.logStart(ctx, name)
+	jobs := make(chan job)
+	results := make(chan result)
+	slots := make(chan struct{}, max(window, 1))
+
+	go func() {
+		defer close(jobs)
+		for seq := 0; ; seq++ {
+			var item T
+			select {
+			case <-poolCtx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				item = v
+			}
+			checkSlow(o, name, item, len(in), cap(in))
+			o.metrics.itemIn()
+			if chanutil.SendContext(poolCtx, slots, struct{}{}) != nil {
+				return
+			}
+			select {
+			case <-poolCtx.Done():
+				return
+			case jobs <- job{seq: seq, item: item}:
+			}
+		}
+	}()
+
+	inFlight := o.newInFlightLimit()
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			o.metrics.workerStarted()
+			defer o.metrics.workerStopped()
+			for j := range jobs {
+				if inFlight != nil && inFlight.Acquire(poolCtx) != nil {
+					return
+				}
+				start := time.Now()
+				value, err := call(poolCtx, o, name, fn, j.item)
+				o.metrics.processed(start, err)
+				if inFlight != nil {
+					inFlight.Release()
+				}
+				select {
+				case <-poolCtx.Done():
+					return
+				case results <- result{seq: j.seq, item: j.item, value: value, err: err}:
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(results)
+		wg.Wait()
+	}()
+
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(out)
+		defer func() {
+			cancel()
+			for range results {
+			}
+		}()
+
+		var errCount int64
+		pending := make(map[int]result)
+		for next := 0; ; next++ {
+			r, ok := pending[next]
+			for !ok {
+				select {
+				case <-poolCtx.Done():
+					return
+				case res, open := <-results:
+					if !open {
+						return
+					}
+					pending[res.seq] = res
+				}
+				r, ok = pending[next]
+			}
+			delete(pending, next)
+			if r.err != nil {
+				o.logItemError(poolCtx, name, r.item, r.err)
+			} else {
+				logItem(poolCtx, o, name, r.item)
+			}
+
+			if r.err != nil && o.errorPolicy == SkipAndReport && !isPanic(r.err) {
+				errCount++
+				if thresholdErr := o.checkErrorCount(errCount, r.err); thresholdErr != nil {
+					r.err = thresholdErr
+				} else {
+					if !o.reportErr(poolCtx, errc, &StageError{Stage: name, Item: r.item, Err: r.err}) {
+						return
+					}
+					<-slots
+					continue
+				}
+			}
+			if r.err != nil {
+				cancel()
+				select {
+				case <-ctx.Done():
+				case errc <- stageFailure(name, r.item, r.err):
+				}
+				return
+			}
+			if chanutil.SendContext(poolCtx, out, r.value) != nil {
+				return
+			}
+			o.metrics.itemOut()
+			<-slots
+		}
+	}()
+
+	return out, errc
+}
@@ -0,0 +1,83 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestStagePoolOrderedKeepsInputOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	jitter := func(_ context.Context, n int) (int, error) {
+		time.Sleep(time.Duration(rand.IntN(100)) * time.Microsecond)
+		return n, nil
+	}
+	ordered := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePoolOrdered(ctx, in, 8, 64, jitter)
+	})
+
+	items := pipelinetest.Items(10000)
+	got, errs := pipelinetest.Run(t, ordered, items)
+	if len(errs) != 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	if len(got) != len(items) {
+		t.Fatalf("got %d items, want %d", len(got), len(items))
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("item %d is %d, want the input order", i, v)
+		}
+	}
+}
+
+func TestStagePoolOrderedWindowBoundsInFlight(t *testing.T) {
+	const window = 4
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	release := make(chan struct{})
+	var started atomic.Int32
+	fn := func(_ context.Context, n int) (int, error) {
+		started.Add(1)
+		if n == 0 {
+			<-release
+		}
+		return n, nil
+	}
+	out, errc := pipeline.StagePoolOrdered(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), 8, window, fn)
+
+	// Item 0 holds the head of the buffer, so only window items may start.
+	time.Sleep(50 * time.Millisecond)
+	if n := started.Load(); n != window {
+		t.Errorf("%d items started while the head was stuck, want %d", n, window)
+	}
+	close(release)
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 100 {
+		t.Errorf("got %d items, want 100", len(got))
+	}
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+}
+
+func TestStagePoolOrderedErrorAfterEarlierResults(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ordered := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePoolOrdered(ctx, in, 8, 16, failOnSix, pipeline.WithBuffer(16))
+	})
+
+	got, errs := pipelinetest.Run(t, ordered, pipelinetest.Items(100))
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Item != 6 {
+		t.Fatalf("errors = %v, want the error for 6", errs)
+	}
+	if want := []int{0, 2, 4, 6, 8, 10}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v before the error", got, want)
+	}
+}
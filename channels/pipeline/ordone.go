@@ -0,0 +1,27 @@
+package pipeline
+
+import (
+	"context"
+
+	"channelspractice/chanutil"
+)
+
+// OrDone returns a channel that yields the items of in and is closed as soon
+// as in is closed or ctx is cancelled, which lets stage bodies use a plain
+// range loop without missing a cancellation.
+func OrDone[T any](ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			item, ok, err := chanutil.RecvContext(ctx, in)
+			if err != nil || !ok {
+				return
+			}
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,37 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestOrDoneUnblocksOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	// The upstream stalls: it is never sent to nor closed.
+	stalled := make(chan int)
+	out := pipeline.OrDone(ctx, stalled)
+
+	received := make(chan struct{})
+	go func() {
+		defer close(received)
+		for range out {
+		}
+	}()
+	cancel()
+	pipelinetest.AssertClosed(t, received, time.Second)
+}
+
+func TestOrDoneForwardsUntilClosed(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := pipelinetest.CollectWithTimeout(t, pipeline.OrDone(ctx, pipeline.Source(ctx, pipelinetest.Items(5))), time.Second)
+	if len(got) != 5 {
+		t.Errorf("got %v, want 5 items", got)
+	}
+}
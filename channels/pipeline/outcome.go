@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+)
+
+// runError is an error Run returns: err, wrapping kind, the sentinel saying
+// how the run ended. Its message is that of err.
+type runError struct {
+	kind error
+	err  error
+}
+
+func (e *runError) Error() string {
+	return e.err.Error()
+}
+
+func (e *runError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// failed is the error Run returns for err, the first error of the pipeline:
+// err itself if the error threshold was exceeded, which it already wraps
+// ErrThresholdExceeded for, and otherwise err wrapping ErrStageFailed.
+func failed(err error) error {
+	if errors.Is(err, ErrThresholdExceeded) {
+		return err
+	}
+	return &runError{kind: ErrStageFailed, err: err}
+}
+
+// stopped is the error Run returns for a run that stopped without a failure:
+// nil, or ctx.Err() wrapping ErrSignalled or ErrCancelled once ctx is done.
+func stopped(ctx context.Context) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	if errors.Is(context.Cause(ctx), ErrSignalled) {
+		return &runError{kind: ErrSignalled, err: err}
+	}
+	return &runError{kind: ErrCancelled, err: err}
+}
+
+// IsFatal reports whether err says the pipeline failed on its own, because a
+// stage failed or exceeded its error threshold, rather than being stopped by
+// its caller.
+func IsFatal(err error) bool {
+	return errors.Is(err, ErrStageFailed) || errors.Is(err, ErrThresholdExceeded)
+}
+
+// IsRetryable reports whether running the pipeline again might get past the
+// error that ended this run: items lost to a drain timeout, or a stage that
+// failed on a timeout or an open circuit. A cancellation, a signal or nil is
+// not a failure to retry.
+func IsRetryable(err error) bool {
+	switch {
+	case errors.Is(err, ErrDrainTimeout):
+		return true
+	case errors.Is(err, ErrStageFailed):
+		return errors.Is(err, ErrStageTimeout) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.DeadlineExceeded)
+	}
+	return false
+}
+
+// StageOf returns the name of the stage err is the failure of, and false if
+// err carries no StageError.
+func StageOf(err error) (string, bool) {
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) {
+		return "", false
+	}
+	return stageErr.Stage, true
+}
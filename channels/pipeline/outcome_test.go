@@ -0,0 +1,250 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+var outcomes = []error{
+	pipeline.ErrCancelled,
+	pipeline.ErrSignalled,
+	pipeline.ErrStageFailed,
+	pipeline.ErrDrainTimeout,
+	pipeline.ErrThresholdExceeded,
+}
+
+// outcomesOf returns the outcome sentinels err wraps.
+func outcomesOf(err error) []error {
+	var wrapped []error
+	for _, sentinel := range outcomes {
+		if errors.Is(err, sentinel) {
+			wrapped = append(wrapped, sentinel)
+		}
+	}
+	return wrapped
+}
+
+// cancelAt returns a context and a sink cancelling it with cause once it is
+// given n.
+func cancelAt(n int, cause error) (context.Context, context.CancelCauseFunc, func(context.Context, int) error) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	return ctx, cancel, func(_ context.Context, item int) error {
+		if item == n {
+			cancel(cause)
+		}
+		return nil
+	}
+}
+
+// holdSave holds on to the first item until the pipeline is aborted, so that
+// the sinks cannot finish before the error that aborts it is read; a buffer
+// lets the stage before it go on to the item it fails on meanwhile.
+func holdSave(ctx context.Context, _ int) error {
+	<-ctx.Done()
+	return nil
+}
+
+// drainRun runs Items(1000) into save with Run, the sources stopped on a
+// cancelled ctx.
+func drainRun(ctx context.Context, opts pipeline.RunOptions, save func(context.Context, int) error) error {
+	return pipeline.Run(ctx, opts, func(produce, abort context.Context) (<-chan struct{}, <-chan error) {
+		return pipeline.Sink(abort, pipeline.Source(produce, pipelinetest.Items(1000)), save)
+	})
+}
+
+func TestRunOutcomes(t *testing.T) {
+	drain := pipeline.RunOptions{DrainTimeout: pipelinetest.DefaultTimeout}
+	for _, tc := range []struct {
+		name  string
+		run   func() error
+		want  error
+		stage string
+		fatal bool
+		retry bool
+	}{
+		{
+			name: "Run succeeds",
+			run: func() error {
+				return drainRun(context.Background(), drain, saveAll)
+			},
+		},
+		{
+			name: "Run stage fails",
+			run: func() error {
+				return pipeline.Run(context.Background(), pipeline.RunOptions{}, func(produce, abort context.Context) (<-chan struct{}, <-chan error) {
+					nums := pipeline.Source(produce, pipelinetest.Items(10))
+					doubled, errs := pipeline.Stage(abort, nums, failOnSix, pipeline.WithName("transform"), pipeline.WithBuffer(10))
+					done, saveErrs := pipeline.Sink(abort, doubled, holdSave)
+					return done, pipeline.Merge(abort, errs, saveErrs)
+				})
+			},
+			want:  pipeline.ErrStageFailed,
+			stage: "transform",
+			fatal: true,
+		},
+		{
+			name: "Run drains",
+			run: func() error {
+				ctx, cancel, save := cancelAt(3, nil)
+				defer cancel(nil)
+				return drainRun(ctx, drain, save)
+			},
+			want: pipeline.ErrCancelled,
+		},
+		{
+			name: "Run drains after a signal",
+			run: func() error {
+				ctx, cancel, save := cancelAt(3, pipeline.ErrSignalled)
+				defer cancel(nil)
+				return drainRun(ctx, drain, save)
+			},
+			want: pipeline.ErrSignalled,
+		},
+		{
+			name: "Run drain times out",
+			run: func() error {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				return pipeline.Run(ctx, pipeline.RunOptions{DrainTimeout: 10 * time.Millisecond}, func(produce, abort context.Context) (<-chan struct{}, <-chan error) {
+					return pipeline.Sink(abort, pipeline.Source(produce, pipelinetest.Items(1000)), func(_ context.Context, n int) error {
+						if n == 3 {
+							cancel()
+							<-abort.Done()
+						}
+						return nil
+					})
+				})
+			},
+			want:  pipeline.ErrDrainTimeout,
+			retry: true,
+		},
+		{
+			name: "Pipeline.Run succeeds",
+			run: func() error {
+				_, err := pipeline.New(pipelinetest.Items(10)).Then(double).Sink(saveAll).Run(context.Background())
+				return err
+			},
+		},
+		{
+			name: "Pipeline.Run stage fails",
+			run: func() error {
+				_, err := pipeline.New(pipelinetest.Items(10)).Then(failOnSix, pipeline.WithName("transform"), pipeline.WithBuffer(10)).Sink(holdSave).Run(context.Background())
+				return err
+			},
+			want:  pipeline.ErrStageFailed,
+			stage: "transform",
+			fatal: true,
+		},
+		{
+			name: "Pipeline.Run stage times out",
+			run: func() error {
+				// slow lets 0 through for save to hold on to.
+				slow := func(ctx context.Context, n int) (int, error) {
+					if n == 0 {
+						return n, nil
+					}
+					<-ctx.Done()
+					return 0, ctx.Err()
+				}
+				_, err := pipeline.New(pipelinetest.Items(10)).
+					Then(pipeline.WithStageTimeout(slow, time.Millisecond), pipeline.WithName("slow")).
+					Sink(holdSave).
+					Run(context.Background())
+				return err
+			},
+			want:  pipeline.ErrStageFailed,
+			stage: "slow",
+			fatal: true,
+			retry: true,
+		},
+		{
+			name: "Pipeline.Run exceeds the error threshold",
+			run: func() error {
+				_, err := pipeline.New(pipelinetest.Items(10)).
+					Then(failBySeverity(-1), pipeline.WithName("check"), pipeline.WithErrorPolicy(pipeline.SkipAndReport), pipeline.WithMaxErrors(1), pipeline.WithBuffer(10)).
+					Sink(holdSave).
+					OnError(func(err error) error {
+						if errors.Is(err, pipeline.ErrThresholdExceeded) {
+							return err
+						}
+						return nil
+					}).
+					Run(context.Background())
+				return err
+			},
+			want:  pipeline.ErrThresholdExceeded,
+			stage: "check",
+			fatal: true,
+		},
+		{
+			name: "Pipeline.Run cancelled",
+			run: func() error {
+				ctx, cancel, save := cancelAt(6, nil)
+				defer cancel(nil)
+				_, err := pipeline.New(pipelinetest.Items(1000)).Then(double).Sink(save).Run(ctx)
+				return err
+			},
+			want: pipeline.ErrCancelled,
+		},
+		{
+			name: "Pipeline.RunWithOptions drains after a signal",
+			run: func() error {
+				ctx, cancel, save := cancelAt(6, pipeline.ErrSignalled)
+				defer cancel(nil)
+				_, err := pipeline.New(pipelinetest.Items(1000)).Then(double).Sink(save).RunWithOptions(ctx, drain)
+				return err
+			},
+			want: pipeline.ErrSignalled,
+		},
+		{
+			name: "RunDeterministic stage fails",
+			run: func() error {
+				_, err := pipeline.RunDeterministic(context.Background(), 1, poolPipeline(7, &pipeline.MemorySink[int]{}))
+				return err
+			},
+			want:  pipeline.ErrStageFailed,
+			stage: "transform",
+			fatal: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			err := tc.run()
+			got := outcomesOf(err)
+			switch {
+			case tc.want == nil && err != nil:
+				t.Fatalf("err = %v, want nil", err)
+			case tc.want != nil && (len(got) != 1 || got[0] != tc.want):
+				t.Fatalf("err = %v wraps %v, want only %v", err, got, tc.want)
+			}
+			if stage, ok := pipeline.StageOf(err); stage != tc.stage || ok != (tc.stage != "") {
+				t.Errorf("StageOf(%v) = %q, %t, want %q", err, stage, ok, tc.stage)
+			}
+			if pipeline.IsFatal(err) != tc.fatal {
+				t.Errorf("IsFatal(%v) = %t, want %t", err, !tc.fatal, tc.fatal)
+			}
+			if pipeline.IsRetryable(err) != tc.retry {
+				t.Errorf("IsRetryable(%v) = %t, want %t", err, !tc.retry, tc.retry)
+			}
+		})
+	}
+}
+
+func TestRunOutcomeKeepsTheError(t *testing.T) {
+	_, err := pipeline.New(pipelinetest.Items(10)).Then(failOnSix, pipeline.WithName("transform"), pipeline.WithBuffer(10)).Sink(holdSave).Run(context.Background())
+	var stageErr *pipeline.StageError
+	if !errors.As(err, &stageErr) || err.Error() != stageErr.Error() {
+		t.Errorf("Run = %v, want the message of the StageError it wraps", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pipeline.New(pipelinetest.Items(10)).Sink(saveAll).Run(ctx); !errors.Is(err, context.Canceled) || err.Error() != context.Canceled.Error() {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"channelspractice/chanutil"
+)
+
+// WithPageConcurrency lets Paginate fetch up to n pages at once, for APIs
+// addressed by page number rather than by a cursor from the previous page.
+// The cursor must then be a signed integer type: page i is fetched with cursor i,
+// starting at 0, and the next cursors fetch returns are ignored. Values below
+// 2 fetch one page after the other.
+func WithPageConcurrency(n int) Option {
+	return func(o *options) {
+		o.pageConcurrency = n
+	}
+}
+
+// page is what one call of a Paginate fetch returned.
+type page[T, C any] struct {
+	items []T
+	next  C
+	done  bool
+}
+
+// Paginate emits the items of the pages fetch returns, one at a time and in
+// order. The first page is fetched with the zero cursor and every following
+// one with the next cursor of the one before, until a page reports done or
+// fetch fails. A failure, sent on the error channel as a *StageError with the
+// cursor as its item, comes after the items of the pages before it. Both
+// channels close once the last page is emitted, on the failure or when ctx is
+// cancelled, which also cancels the context fetch got. The source is named
+// "source" by default; see WithPageConcurrency for fetching pages ahead.
+func Paginate[T, C any](ctx context.Context, fetch func(ctx context.Context, cursor C) (items []T, next C, done bool, err error), opts ...Option) (<-chan T, <-chan error) {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	errc := make(chan error, 1)
+	unregister := o.register("source")
+	name := o.stageName("source")
+	o.logStart(ctx, name)
+
+	get := func(ctx context.Context, cursor C) (page[T, C], error) {
+		items, next, done, err := fetch(ctx, cursor)
+		return page[T, C]{items: items, next: next, done: done}, err
+	}
+	// emit sends the items of p and reports whether to go on with the next
+	// page.
+	emit := func(p page[T, C]) bool {
+		for _, item := range p.items {
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return false
+			}
+		}
+		return !p.done
+	}
+
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(out)
+		if o.pageConcurrency > 1 {
+			if err := paginateAhead(ctx, o, name, get, emit); err != nil {
+				errc <- err
+			}
+			return
+		}
+		var cursor C
+		for ctx.Err() == nil {
+			p, err := call(ctx, o, name, get, cursor)
+			if err != nil {
+				if ctx.Err() == nil {
+					errc <- stageFailure(name, cursor, err)
+				}
+				return
+			}
+			if !emit(p) {
+				return
+			}
+			cursor = p.next
+		}
+	}()
+	return out, errc
+}
+
+// paginateAhead fetches the pages of Paginate by page number, up to
+// o.pageConcurrency of them at once, and hands them to emit in order until it
+// reports false. It returns the error to report, if any.
+func paginateAhead[T, C any](ctx context.Context, o options, name string, get func(context.Context, C) (page[T, C], error), emit func(page[T, C]) bool) error {
+	if t := reflect.TypeFor[C](); !isSignedInteger(t) {
+		return &StageError{Stage: name, Err: fmt.Errorf("page concurrency needs a signed integer cursor, not %v", t)}
+	}
+	type fetched struct {
+		cursor C
+		page   page[T, C]
+		err    error
+	}
+
+	fetchCtx, cancel := context.WithCancel(ctx)
+	var fetches sync.WaitGroup
+	defer fetches.Wait()
+	defer cancel()
+	// Together with the page emit waits for, the queue holds the pages in
+	// flight, oldest first.
+	queue := make(chan chan fetched, o.pageConcurrency-1)
+	fetches.Add(1)
+	go func() {
+		defer fetches.Done()
+		defer close(queue)
+		for i := 0; ; i++ {
+			var cursor C
+			reflect.ValueOf(&cursor).Elem().SetInt(int64(i))
+			result := make(chan fetched, 1)
+			if chanutil.SendContext(fetchCtx, queue, result) != nil {
+				return
+			}
+			fetches.Add(1)
+			go func() {
+				defer fetches.Done()
+				p, err := call(fetchCtx, o, name, get, cursor)
+				result <- fetched{cursor: cursor, page: p, err: err}
+			}()
+		}
+	}()
+
+	for result := range queue {
+		var f fetched
+		select {
+		case <-ctx.Done():
+			return nil
+		case f = <-result:
+		}
+		if f.err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return stageFailure(name, f.cursor, f.err)
+		}
+		if !emit(f.page) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func isSignedInteger(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
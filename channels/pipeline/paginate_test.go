@@ -0,0 +1,157 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// pagedAPI serves pages of items by page number, failing the page failOn if
+// it is not negative.
+type pagedAPI struct {
+	pages  [][]int
+	failOn int
+
+	mu      sync.Mutex
+	fetched []int
+	// inFlight and maxInFlight count concurrent fetches.
+	inFlight, maxInFlight atomic.Int64
+}
+
+var errUnavailable = errors.New("service unavailable")
+
+func (a *pagedAPI) fetch(_ context.Context, page int) ([]int, int, bool, error) {
+	if n := a.inFlight.Add(1); n > a.maxInFlight.Load() {
+		a.maxInFlight.Store(n)
+	}
+	defer a.inFlight.Add(-1)
+	a.mu.Lock()
+	a.fetched = append(a.fetched, page)
+	a.mu.Unlock()
+	// A little latency lets fetches ahead overlap.
+	time.Sleep(time.Millisecond)
+	if page == a.failOn {
+		return nil, 0, false, errUnavailable
+	}
+	if page >= len(a.pages) {
+		return nil, 0, true, nil
+	}
+	return a.pages[page], page + 1, page == len(a.pages)-1, nil
+}
+
+func newPagedAPI(pages, perPage int) *pagedAPI {
+	a := &pagedAPI{failOn: -1}
+	for p := range pages {
+		a.pages = append(a.pages, pipelinetest.Items(perPage))
+		for i := range a.pages[p] {
+			a.pages[p][i] += p * perPage
+		}
+	}
+	return a
+}
+
+func TestPaginate(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			api := newPagedAPI(5, 4)
+
+			out, errc := pipeline.Paginate(ctx, api.fetch, pipeline.WithPageConcurrency(concurrency))
+			if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, pipelinetest.Items(20)) {
+				t.Errorf("got %v, want the 20 items in order", got)
+			}
+			if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+				t.Errorf("errors = %v", errs)
+			}
+			if n := api.maxInFlight.Load(); n > int64(concurrency) {
+				t.Errorf("%d pages fetched at once", n)
+			}
+		})
+	}
+}
+
+func TestPaginateErrorOnPage3(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			api := newPagedAPI(5, 4)
+			api.failOn = 3
+
+			out, errc := pipeline.Paginate(ctx, api.fetch, pipeline.WithPageConcurrency(concurrency), pipeline.WithName("api"))
+			got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+			errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+			// Pages 0 to 2 made it out before the failure.
+			if !slices.Equal(got, pipelinetest.Items(12)) {
+				t.Errorf("got %v, want the 12 items of the first 3 pages", got)
+			}
+			var stageErr *pipeline.StageError
+			if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "api" || stageErr.Item != 3 || !errors.Is(errs[0], errUnavailable) {
+				t.Errorf("errors = %v, want one *StageError of api for page 3", errs)
+			}
+		})
+	}
+}
+
+func TestPaginateCancelBetweenPages(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	api := newPagedAPI(100, 2)
+
+	out, errc := pipeline.Paginate(ctx, api.fetch)
+	for range 2 {
+		<-out
+	}
+	// The first page is out, the source is on to the next one.
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v, want none for a cancellation", errs)
+	}
+	if n := len(api.fetched); n > 3 {
+		t.Errorf("%d pages fetched after cancelling on the second, want at most 3", n)
+	}
+}
+
+func TestPaginateEmptyFirstPage(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api := newPagedAPI(0, 0)
+
+	out, errc := pipeline.Paginate(ctx, api.fetch)
+	pipelinetest.AssertClosed(t, out, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v", errs)
+	}
+	if !slices.Equal(api.fetched, []int{0}) {
+		t.Errorf("fetched pages %v, want only the first", api.fetched)
+	}
+}
+
+func TestPaginateConcurrencyNeedsIntegerCursor(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fetch := func(context.Context, string) ([]int, string, bool, error) {
+		t.Error("fetch called with a cursor that is not a page number")
+		return nil, "", true, nil
+	}
+
+	out, errc := pipeline.Paginate(ctx, fetch, pipeline.WithPageConcurrency(2))
+	pipelinetest.AssertClosed(t, out, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 1 {
+		t.Errorf("errors = %v, want one for the string cursor", errs)
+	}
+}
@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is the error a stage reports when its function panicked. Stages,
+// stage pools and sinks recover such panics unless WithoutPanicRecovery is
+// given, send a PanicError on their error channel and stop as if the function
+// had failed, whatever the error policy.
+type PanicError struct {
+	Stage string
+	Item  any
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("stage %s panicked on item %v: %v", e.Stage, e.Item, e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// WithoutPanicRecovery lets a panic in a stage's function crash the program
+// as it would outside a pipeline.
+func WithoutPanicRecovery() Option {
+	return func(o *options) {
+		o.noPanicRecovery = true
+	}
+}
+
+// call runs fn on item, turning a panic into a *PanicError unless panic
+// recovery is disabled, with the timeout set by WithItemTimeout, and traces
+// it for WithTraceBuffer.
+func call[T, U any](ctx context.Context, o options, stage string, fn func(context.Context, T) (U, error), item T) (result U, err error) {
+	if o.trace != nil {
+		seq := o.trace.enter(stage, item, o.clock.Now())
+		// Deferred first, it sees the error a panic became.
+		defer func() {
+			o.trace.exit(seq, o.clock.Now(), err)
+		}()
+	}
+	if o.itemTimeout > 0 {
+		parent := ctx
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, o.itemTimeout)
+		defer cancel()
+		defer func() {
+			// The item's context also expires with a parent deadline, which
+			// is not this timeout.
+			if err != nil && !isPanic(err) && parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("%w after %v: %w", ErrStageTimeout, o.itemTimeout, err)
+			}
+		}()
+	}
+	if !o.noPanicRecovery {
+		defer func() {
+			if v := recover(); v != nil {
+				err = &PanicError{Stage: stage, Item: item, Value: v, Stack: debug.Stack()}
+			}
+		}()
+	}
+	return fn(ctx, item)
+}
+
+// stageFailure returns the error a stage sends for an item that failed with
+// err.
+func stageFailure(stage string, item any, err error) error {
+	if isPanic(err) {
+		return err
+	}
+	return &StageError{Stage: stage, Item: item, Err: err}
+}
+
+func isPanic(err error) bool {
+	var panicErr *PanicError
+	return errors.As(err, &panicErr)
+}
@@ -0,0 +1,69 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+var errBoom = errors.New("boom")
+
+// panicOnSix panics with errBoom on 6 and passes every other item through.
+func panicOnSix(_ context.Context, n int) (int, error) {
+	if n == 6 {
+		panic(errBoom)
+	}
+	return n, nil
+}
+
+func TestPanicBecomesPanicError(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		stage pipelinetest.Stage[int, int]
+	}{
+		{"Stage", pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+			return pipeline.Stage(ctx, in, panicOnSix, pipeline.WithName("transform"))
+		})},
+		{"StagePool", pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+			return pipeline.StagePool(ctx, in, 4, panicOnSix, pipeline.WithName("transform"))
+		})},
+		{"StagePool SkipAndReport", pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+			return pipeline.StagePool(ctx, in, 4, panicOnSix, pipeline.WithName("transform"), pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+		})},
+		{"Sink", pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+			done, errc := pipeline.Sink(ctx, in, func(_ context.Context, n int) error {
+				_, err := panicOnSix(ctx, n)
+				return err
+			}, pipeline.WithName("transform"))
+			out := make(chan int)
+			go func() {
+				defer close(out)
+				<-done
+			}()
+			return out, errc
+		})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			got, errs := pipelinetest.Run(t, tc.stage, pipelinetest.Items(1000))
+
+			var panicErr *pipeline.PanicError
+			if len(errs) != 1 || !errors.As(errs[0], &panicErr) {
+				t.Fatalf("errors = %v, want one *PanicError", errs)
+			}
+			if panicErr.Stage != "transform" || panicErr.Item != 6 || panicErr.Value != errBoom || len(panicErr.Stack) == 0 {
+				t.Errorf("PanicError = %+v, want the transform panic on 6 with a stack", panicErr)
+			}
+			if !errors.Is(errs[0], errBoom) {
+				t.Errorf("%v does not unwrap to the panic value", errs[0])
+			}
+			// A panic stops the stage whatever the error policy.
+			if len(got) >= 999 {
+				t.Errorf("got %d items, want the stage to stop at the panic", len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package pipeline
+
+import (
+	"context"
+
+	"channelspractice/chanutil"
+)
+
+// Partition routes every item from in to exactly one of its outputs: matched
+// if pred returns true, unmatched otherwise. A send blocks until its consumer
+// takes the item, so both outputs must be consumed; a consumer that stops
+// reading holds up the other side until ctx is cancelled. WithBuffer gives
+// both sides some slack, and a side whose items may be lost can be wrapped in
+// DropIfBusy, which never holds up Partition.
+func Partition[T any](ctx context.Context, in <-chan T, pred func(T) bool, opts ...Option) (matched, unmatched <-chan T) {
+	o := newOptions(opts)
+	yes := make(chan T, o.buffer)
+	no := make(chan T, o.buffer)
+	go func() {
+		defer close(yes)
+		defer close(no)
+		for item := range OrDone(ctx, in) {
+			out := no
+			if pred(item) {
+				out = yes
+			}
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return yes, no
+}
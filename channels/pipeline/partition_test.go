@@ -0,0 +1,87 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func isEven(n int) bool { return n%2 == 0 }
+
+func TestPartitionRoutesEveryItemOnce(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evens, odds := pipeline.Partition(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), isEven)
+	gotOdds := make(chan []int)
+	go func() { gotOdds <- pipelinetest.CollectWithTimeout(t, odds, time.Second) }()
+	gotEvens := pipelinetest.CollectWithTimeout(t, evens, time.Second)
+
+	var wantEvens, wantOdds []int
+	for n := range 100 {
+		if isEven(n) {
+			wantEvens = append(wantEvens, n)
+		} else {
+			wantOdds = append(wantOdds, n)
+		}
+	}
+	if !slices.Equal(gotEvens, wantEvens) {
+		t.Errorf("matched %v, want the evens in order", gotEvens)
+	}
+	if got := <-gotOdds; !slices.Equal(got, wantOdds) {
+		t.Errorf("unmatched %v, want the odds in order", got)
+	}
+}
+
+func TestPartitionConsumerStopsEarly(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evens, odds := pipeline.Partition(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), isEven)
+	stalled := make(chan []int)
+	go func() { stalled <- pipelinetest.CollectWithTimeout(t, evens, time.Second) }()
+	// The odds consumer takes two items and gives up, which stalls the
+	// evens until the pipeline is cancelled.
+	<-odds
+	<-odds
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	if got := <-stalled; len(got) > 3 {
+		t.Errorf("matched %v, want the partition stalled on the unread odd", got)
+	}
+	pipelinetest.CollectWithTimeout(t, odds, time.Second)
+}
+
+func TestPartitionDropIfBusySide(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evens, odds := pipeline.Partition(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), isEven)
+	// Nobody reads the odds, but DropIfBusy keeps taking them.
+	_, dropped := pipeline.DropIfBusy(ctx, odds)
+	if got := pipelinetest.CollectWithTimeout(t, evens, time.Second); len(got) != 50 {
+		t.Errorf("matched %d items, want all 50 evens", len(got))
+	}
+	cancel()
+	if dropped() > 50 {
+		t.Errorf("dropped %d odds, want at most 50", dropped())
+	}
+}
+
+func TestPartitionEmpty(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evens, odds := pipeline.Partition(ctx, pipeline.Source[int](ctx, nil), isEven)
+	pipelinetest.AssertClosed(t, evens, time.Second)
+	pipelinetest.AssertClosed(t, odds, time.Second)
+}
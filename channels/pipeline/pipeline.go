@@ -0,0 +1,166 @@
+// Package pipeline contains the generic building blocks used by the pipeline
+// lessons: a source that emits items, stages that transform them and sinks
+// that consume them. Every primitive stops when its context is cancelled and
+// never blocks forever on a send.
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"channelspractice/semaphore"
+)
+
+// Option configures a pipeline primitive.
+type Option func(*options)
+
+type options struct {
+	buffer          int
+	errorPolicy     ErrorPolicy
+	maxErrors       int
+	limitErrors     bool
+	name            string
+	registry        *Registry
+	maxInFlight     int
+	noPanicRecovery bool
+	fairness        int
+
+	skipEmptyWindows bool
+
+	strictLength bool
+
+	drainOnCancel bool
+
+	controller *Controller
+
+	clock Clock
+
+	startOffset     int64
+	checkpoint      CheckpointStore
+	checkpointEvery int
+
+	maxLineLength int
+
+	pageConcurrency int
+
+	segmentSize int64
+	maxFrame    int
+	codec       any
+
+	shadowLimit int
+
+	attemptLimit *semaphore.Semaphore
+
+	finishStragglers bool
+
+	itemMeta func(offset int64, value any) Meta
+
+	shutdownMode Mode
+
+	traceSize int
+	trace     *traceRing
+
+	errorBuffer   int
+	errorOverflow OverflowPolicy
+
+	hooks     Hooks
+	lazyStart bool
+	onCancel  []func()
+
+	slowThreshold time.Duration
+	onSlow        func(SlowEvent)
+
+	itemDeadline time.Duration
+	itemTimeout  time.Duration
+
+	heartbeatInterval time.Duration
+	heartbeats        chan<- Heartbeat
+
+	acks chan<- Ack
+	flow *flowControl
+
+	metrics *Metrics
+	logger  *slog.Logger
+
+	onStageStart func(ctx context.Context, stage string, item any)
+	onStageEnd   func(ctx context.Context, stage string, item any)
+}
+
+func newOptions(opts []Option) options {
+	o := options{logger: slog.New(slog.DiscardHandler), clock: realClock{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.traceSize > 0 {
+		o.trace = newTraceRing(o.traceSize)
+	}
+	return o
+}
+
+// WithMaxInFlight limits a stage pool to working on at most n items at once
+// across all of its workers, whatever their number.
+func WithMaxInFlight(n int) Option {
+	return func(o *options) {
+		o.maxInFlight = n
+	}
+}
+
+// newInFlightLimit returns the semaphore enforcing WithMaxInFlight, or nil.
+func (o options) newInFlightLimit() *semaphore.Semaphore {
+	if o.maxInFlight <= 0 {
+		return nil
+	}
+	return semaphore.New(o.maxInFlight)
+}
+
+// WithName names a stage in registries and reports. Unnamed stages are named
+// after their kind, e.g. "stage" or "sink".
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithRegistry records the stage in r for as long as it is running.
+func WithRegistry(r *Registry) Option {
+	return func(o *options) {
+		o.registry = r
+	}
+}
+
+func (o options) stageName(kind string) string {
+	if o.name != "" {
+		return o.name
+	}
+	return kind
+}
+
+// WithBuffer sets the capacity of the data channel returned by a primitive.
+// Items still sitting in a buffer when the context is cancelled are abandoned:
+// the producer never blocks on a full buffer after cancellation and the
+// consumer is free to stop reading. Sink has no data output and ignores it.
+func WithBuffer(n int) Option {
+	return func(o *options) {
+		o.buffer = n
+	}
+}
+
+// WithErrorPolicy sets how a stage reacts to errors returned by its function.
+func WithErrorPolicy(p ErrorPolicy) Option {
+	return func(o *options) {
+		o.errorPolicy = p
+	}
+}
+
+// WithMaxErrors lets a stage skip and report up to n failed items. The item
+// that pushes the count past n cancels the stage instead, and its error is
+// sent wrapped with ErrThresholdExceeded. WithMaxErrors(0) therefore fails on
+// the first error like FailFast.
+func WithMaxErrors(n int) Option {
+	return func(o *options) {
+		o.errorPolicy = SkipAndReport
+		o.maxErrors = n
+		o.limitErrors = true
+	}
+}
@@ -0,0 +1,52 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestWithBufferSetsCapacity(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opt := pipeline.WithBuffer(4)
+
+	nums := pipeline.Source(ctx, pipelinetest.Items(4), opt)
+	doubled, errc := pipeline.Stage(ctx, nums, failOnSix, opt)
+	if cap(nums) != 4 || cap(doubled) != 4 {
+		t.Errorf("capacities %d and %d, want 4", cap(nums), cap(doubled))
+	}
+	if got := pipelinetest.CollectWithTimeout(t, doubled, time.Second); len(got) != 4 {
+		t.Errorf("got %d items, want 4", len(got))
+	}
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+}
+
+// TestBufferedStagesStopOnCancel cancels a pipeline whose buffers are full:
+// the items left in them are abandoned and every goroutine exits.
+func TestBufferedStagesStopOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	opt := pipeline.WithBuffer(8)
+	saving := make(chan struct{}, 1)
+
+	nums := pipeline.Source(ctx, pipelinetest.Items(1000), opt)
+	doubled, transformErrs := pipeline.Stage(ctx, nums, failOnSix, opt)
+	done, saveErrs := pipeline.Sink(ctx, doubled, func(context.Context, int) error {
+		select {
+		case saving <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return nil
+	}, opt)
+	<-saving
+	cancel()
+
+	pipelinetest.AssertClosed(t, done, time.Second)
+	pipelinetest.CollectWithTimeout(t, pipeline.Merge(context.Background(), transformErrs, saveErrs), time.Second)
+}
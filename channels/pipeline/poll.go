@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"channelspractice/chanutil"
+)
+
+// PollOptions controls how often Poll calls its function.
+type PollOptions struct {
+	// Interval is the wait after a poll that returned items. Values below a
+	// millisecond are treated as a millisecond.
+	Interval time.Duration
+	// Multiplier scales the wait after every empty or failed poll in a row.
+	// Values of 1 or below double it.
+	Multiplier float64
+	// MaxInterval caps the wait after empty or failed polls. Zero means no
+	// cap.
+	MaxInterval time.Duration
+	// StopOnError ends polling with the first error instead of backing off
+	// and trying again.
+	StopOnError bool
+}
+
+// Poll calls fn over and over and emits the items of every call in the order
+// fn returned them. The first call is made right away. After one that
+// returned items Poll waits opts.Interval; after one that returned none or
+// failed it backs off, waiting Multiplier times longer than the time before,
+// up to MaxInterval, until a call returns items again. Errors are sent on the
+// error channel as a *StageError with the number of the call, counted from 1,
+// as its item, and only stop polling with StopOnError. Both channels close
+// when ctx is cancelled, which also ends a wait right away, or after the
+// error StopOnError stopped at. The waits are timed by the clock set with
+// WithClock; the source is named "poll" by default.
+func Poll[T any](ctx context.Context, fn func(ctx context.Context) ([]T, error), opts PollOptions, pipelineOpts ...Option) (<-chan T, <-chan error) {
+	o := newOptions(pipelineOpts)
+	out := make(chan T, o.buffer)
+	errc := o.newErrc()
+	unregister := o.register("poll")
+	name := o.stageName("poll")
+	o.logStart(ctx, name)
+
+	interval := max(opts.Interval, time.Millisecond)
+	multiplier := opts.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+	poll := func(ctx context.Context, _ int) ([]T, error) {
+		return fn(ctx)
+	}
+
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(out)
+		backoff := interval
+		for n := 1; ; n++ {
+			items, err := call(ctx, o, name, poll, n)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				if !o.reportErr(ctx, errc, stageFailure(name, n, err)) || opts.StopOnError {
+					return
+				}
+				items = nil
+			}
+			for _, item := range items {
+				if chanutil.SendContext(ctx, out, item) != nil {
+					return
+				}
+			}
+
+			wait := interval
+			if len(items) == 0 {
+				backoff = time.Duration(float64(backoff) * multiplier)
+				if opts.MaxInterval > 0 {
+					backoff = min(backoff, opts.MaxInterval)
+				}
+				wait = backoff
+			} else {
+				backoff = interval
+			}
+			timer := o.clock.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C():
+			}
+		}
+	}()
+	return out, errc
+}
@@ -0,0 +1,172 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// pollResult is what one call of a scripted poll returns.
+type pollResult struct {
+	items []int
+	err   error
+}
+
+// scriptedPoll returns the results of script in turn, and nothing once they
+// ran out. It records the time of every call and signals it on calls.
+type scriptedPoll struct {
+	clock  *pipelinetest.Clock
+	script []pollResult
+	calls  chan struct{}
+
+	mu    sync.Mutex
+	times []time.Duration
+}
+
+func newScriptedPoll(clock *pipelinetest.Clock, script ...pollResult) *scriptedPoll {
+	return &scriptedPoll{clock: clock, script: script, calls: make(chan struct{}, len(script)+1)}
+}
+
+func (p *scriptedPoll) poll(context.Context) ([]int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.times)
+	p.times = append(p.times, p.clock.Now().Sub(time.Time{}))
+	p.calls <- struct{}{}
+	if n >= len(p.script) {
+		return nil, nil
+	}
+	return p.script[n].items, p.script[n].err
+}
+
+// waitForCall waits until the next call was made.
+func (p *scriptedPoll) waitForCall(t *testing.T) {
+	t.Helper()
+	select {
+	case <-p.calls:
+	case <-time.After(pipelinetest.DefaultTimeout):
+		t.Fatal("poll was not called")
+	}
+}
+
+func (p *scriptedPoll) callTimes() []time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.times)
+}
+
+// collectPoll reads out and errc until both are closed.
+func collectPoll(out <-chan int, errc <-chan error) (<-chan []int, <-chan []error) {
+	items, errs := make(chan []int, 1), make(chan []error, 1)
+	go func() {
+		var all []int
+		for item := range out {
+			all = append(all, item)
+		}
+		items <- all
+	}()
+	go func() {
+		var all []error
+		for err := range errc {
+			all = append(all, err)
+		}
+		errs <- all
+	}()
+	return items, errs
+}
+
+func TestPollBackoffSchedule(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	p := newScriptedPoll(clock,
+		pollResult{items: []int{1, 2, 3}},
+		pollResult{},
+		pollResult{},
+		pollResult{err: errDown},
+		pollResult{},
+		pollResult{items: []int{4, 5}},
+		pollResult{},
+		pollResult{items: []int{6}},
+	)
+	out, errc := pipeline.Poll(ctx, p.poll, pipeline.PollOptions{
+		Interval:    10 * time.Second,
+		MaxInterval: 50 * time.Second,
+	}, pipeline.WithClock(clock))
+	items, errs := collectPoll(out, errc)
+
+	// Items bring the wait back to the interval, anything else doubles it
+	// up to the cap.
+	waits := []time.Duration{10, 20, 40, 50, 50, 10, 20}
+	for _, wait := range waits {
+		p.waitForCall(t)
+		clock.BlockUntil(t, 1)
+		clock.Advance(wait * time.Second)
+	}
+	p.waitForCall(t)
+	clock.BlockUntil(t, 1)
+	cancel()
+
+	want := []time.Duration{0, 10, 30, 70, 120, 170, 180, 200}
+	for i := range want {
+		want[i] *= time.Second
+	}
+	if got := p.callTimes(); !slices.Equal(got, want) {
+		t.Errorf("polled at %v, want %v", got, want)
+	}
+	if got := <-items; !slices.Equal(got, []int{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("got %v, want the items of every poll in order", got)
+	}
+	var stageErr *pipeline.StageError
+	if got := <-errs; len(got) != 1 || !errors.As(got[0], &stageErr) || stageErr.Stage != "poll" || stageErr.Item != 4 || !errors.Is(got[0], errDown) {
+		t.Errorf("errors %v, want the failure of poll 4", got)
+	}
+}
+
+func TestPollStopOnError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	p := newScriptedPoll(clock, pollResult{items: []int{1, 2}}, pollResult{err: errDown}, pollResult{items: []int{3}})
+	out, errc := pipeline.Poll(ctx, p.poll, pipeline.PollOptions{Interval: time.Second, StopOnError: true},
+		pipeline.WithClock(clock), pipeline.WithName("feed"))
+	items, errs := collectPoll(out, errc)
+
+	p.waitForCall(t)
+	clock.BlockUntil(t, 1)
+	clock.Advance(time.Second)
+	select {
+	case got := <-items:
+		if !slices.Equal(got, []int{1, 2}) {
+			t.Errorf("got %v, want the items before the error", got)
+		}
+	case <-time.After(pipelinetest.DefaultTimeout):
+		t.Fatal("polling went on after the error")
+	}
+	if got := <-errs; len(got) != 1 || !errors.Is(got[0], errDown) || got[0].Error() != "stage feed (item 2): "+errDown.Error() {
+		t.Errorf("errors %v, want the failure of poll 2", got)
+	}
+}
+
+func TestPollCancelDuringBackoff(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := pipelinetest.NewClock(time.Time{})
+	p := newScriptedPoll(clock)
+	out, errc := pipeline.Poll(ctx, p.poll, pipeline.PollOptions{Interval: time.Hour}, pipeline.WithClock(clock))
+
+	p.waitForCall(t)
+	clock.BlockUntil(t, 1)
+	cancel()
+	// The clock never moves, so only the cancellation can end the wait.
+	pipelinetest.AssertClosed(t, out, time.Second)
+	pipelinetest.AssertClosed(t, errc, time.Second)
+}
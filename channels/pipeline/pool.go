@@ -0,0 +1,350 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"channelspractice/chanutil"
+	"channelspractice/semaphore"
+)
+
+// StagePool runs fn on items from in using the given number of workers that
+// all read from the same input. Results are fanned into a single output
+// channel, so their order is not preserved. The first error cancels the
+// remaining workers and is sent on the error channel as a *StageError. Both
+// channels are closed once every worker has exited. The context passed to fn
+// is cancelled as soon as the pool stops.
+func StagePool[T, U any](ctx context.Context, in <-chan T, workers int, fn func(context.Context, T) (U, error), opts ...Option) (<-chan U, <-chan error) {
+	out, _, errc := stagePool(ctx, in, workers, fn, newOptions(opts), false)
+	return out, errc
+}
+
+// StagePoolWithDeadLetters is StagePool with a dead-letter channel. Under the
+// SkipAndReport policy failed items are sent there instead of the error
+// channel. All three channels must be consumed.
+func StagePoolWithDeadLetters[T, U any](ctx context.Context, in <-chan T, workers int, fn func(context.Context, T) (U, error), opts ...Option) (<-chan U, <-chan ItemError[T], <-chan error) {
+	return stagePool(ctx, in, workers, fn, newOptions(opts), true)
+}
+
+// StagePoolStateful is StagePool for a fn that needs a state of its own in
+// every worker, such as a connection or a handle that is expensive to create
+// and not safe to share. Every worker calls newState with its ID, from 0,
+// before it reads its first item, and hands the state to fn with every item.
+// No worker reads an item before every newState has returned, and an error
+// from any of them fails the stage before it started, wrapped in a
+// *StageError. closeState, if not nil, is called exactly once for every state
+// newState created, when its worker exits, also on cancellation, a failed
+// start or a panic; its error fails the stage unless something else did
+// first.
+func StagePoolStateful[T, U, S any](ctx context.Context, in <-chan T, workers int, newState func(ctx context.Context, workerID int) (S, error), fn func(context.Context, S, T) (U, error), closeState func(S) error, opts ...Option) (<-chan U, <-chan error) {
+	p := newWorkerPool[T, U](ctx, nil, newOptions(opts), false)
+	p.newWorker = func(ctx context.Context, id int) (func(context.Context, T) (U, error), func() error, error) {
+		state, err := newState(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		work := func(ctx context.Context, item T) (U, error) {
+			return fn(ctx, state, item)
+		}
+		if closeState == nil {
+			return work, nil, nil
+		}
+		return work, func() error { return closeState(state) }, nil
+	}
+	out, _, errc := runPool(p, in, workers)
+	return out, errc
+}
+
+func stagePool[T, U any](ctx context.Context, in <-chan T, workers int, fn func(context.Context, T) (U, error), o options, withDeadLetters bool) (<-chan U, <-chan ItemError[T], <-chan error) {
+	return runPool(newWorkerPool(ctx, fn, o, withDeadLetters), in, workers)
+}
+
+// runPool starts the workers of p on in.
+func runPool[T, U any](p *workerPool[T, U], in <-chan T, workers int) (<-chan U, <-chan ItemError[T], <-chan error) {
+	ctx, o := p.ctx, p.o
+	unregister := o.register("stage")
+	o.logStart(ctx, p.name)
+	stopWatching := o.watchCancel(p.poolCtx)
+
+	// finish runs once the workers exited, or if they were never started.
+	finish := func() {
+		// Cancelling the pool now only tidies up, which is no cancellation
+		// for WithOnCancel.
+		stopWatching()
+		p.cancel()
+		p.close()
+		o.logStop(ctx, p.name)
+		unregister()
+	}
+	if !o.lazyStart {
+		p.start(workers, OrDone(p.poolCtx, in), in, finish)
+		return p.outputs()
+	}
+	go func() {
+		select {
+		case <-p.poolCtx.Done():
+			finish()
+		case first, ok := <-in:
+			if !ok {
+				finish()
+				return
+			}
+			p.start(workers, prepend(p.poolCtx, first, in), in, finish)
+		}
+	}()
+	return p.outputs()
+}
+
+// start launches the workers on items, from in, and calls finish once they
+// all exited.
+func (p *workerPool[T, U]) start(workers int, items, in <-chan T, finish func()) {
+	o := p.o
+	var g chanutil.Group
+	var started sync.WaitGroup
+	started.Add(workers)
+	for id := range workers {
+		g.Go(func() {
+			info := StageInfo{Stage: p.name, Worker: id, Workers: workers}
+			defer o.stopHook(info, p.terminal)
+			defer p.failOnPanic()
+			fn, closeWorker := p.fn, func() error { return nil }
+			defer func() {
+				if err := closeWorker(); err != nil {
+					p.fail(&StageError{Stage: p.name, Err: fmt.Errorf("close state of worker %d: %w", id, err)})
+				}
+			}()
+			// The failure is claimed before started.Done but only sent after
+			// it, so no worker waits on a sibling that is blocked sending.
+			var startErr error
+			func() {
+				// Even a panicking OnStart or newState must not leave its
+				// siblings waiting.
+				defer started.Done()
+				err := o.startHook(p.poolCtx, info)
+				if err == nil && p.newWorker != nil {
+					var closeFn func() error
+					fn, closeFn, err = p.newWorker(p.poolCtx, id)
+					if err != nil {
+						err = &StageError{Stage: p.name, Err: fmt.Errorf("new state for worker %d: %w", id, err)}
+					} else if closeFn != nil {
+						closeWorker = closeFn
+					}
+				}
+				if err != nil && p.claim(err) {
+					startErr = err
+				}
+			}()
+			started.Wait()
+			if startErr != nil {
+				p.send(startErr)
+				return
+			}
+			if p.failed.Load() {
+				return
+			}
+			p.work(info, fn, items, in, nil)
+		})
+	}
+
+	go func() {
+		defer finish()
+		// failOnPanic already reported the panics the workers let through.
+		var panicErr *chanutil.PanicError
+		if err := g.Wait(context.Background()); errors.As(err, &panicErr) && o.noPanicRecovery {
+			panic(panicErr.Value)
+		}
+	}()
+}
+
+// workerPool is what the workers of StagePool and StagePoolAuto share.
+type workerPool[T, U any] struct {
+	ctx     context.Context
+	poolCtx context.Context
+	cancel  context.CancelFunc
+	o       options
+	name    string
+	fn      func(context.Context, T) (U, error)
+	// newWorker, if set, gives every worker a fn of its own, and a func to
+	// call once it exited, see StagePoolStateful.
+	newWorker   func(ctx context.Context, id int) (func(context.Context, T) (U, error), func() error, error)
+	out         *chanutil.CloseOnce[U]
+	errc        *chanutil.CloseOnce[error]
+	deadLetters *chanutil.CloseOnce[ItemError[T]]
+	inFlight    *semaphore.Semaphore
+
+	errCount atomic.Int64
+	failed   atomic.Bool
+	failure  atomic.Pointer[error]
+}
+
+func newWorkerPool[T, U any](ctx context.Context, fn func(context.Context, T) (U, error), o options, withDeadLetters bool) *workerPool[T, U] {
+	p := &workerPool[T, U]{
+		ctx:      ctx,
+		o:        o,
+		name:     o.stageName("stage"),
+		fn:       fn,
+		out:      chanutil.NewCloseOnce(make(chan U, o.buffer)),
+		errc:     chanutil.NewCloseOnce(o.newErrc()),
+		inFlight: o.newInFlightLimit(),
+	}
+	if withDeadLetters {
+		p.deadLetters = chanutil.NewCloseOnce(make(chan ItemError[T]))
+	}
+	p.poolCtx, p.cancel = context.WithCancel(ctx)
+	sampleOccupancy(o.metrics, p.out.C, p.poolCtx.Done())
+	return p
+}
+
+// outputs returns the channels of the pool, the dead-letter one being nil
+// unless asked for.
+func (p *workerPool[T, U]) outputs() (<-chan U, <-chan ItemError[T], <-chan error) {
+	var deadLetters <-chan ItemError[T]
+	if p.deadLetters != nil {
+		deadLetters = p.deadLetters.C
+	}
+	return p.out.C, deadLetters, p.errc.C
+}
+
+// close closes the channels of the pool. Only the first call does anything.
+func (p *workerPool[T, U]) close() {
+	if p.deadLetters != nil {
+		p.deadLetters.Close()
+	}
+	p.out.Close()
+	p.errc.Close()
+}
+
+// claim records err as the failure of the stage and stops the other workers.
+// Only the first claim wins; its caller must send the error.
+func (p *workerPool[T, U]) claim(err error) bool {
+	first := p.failed.CompareAndSwap(false, true)
+	if first {
+		p.failure.Store(&err)
+	}
+	p.cancel()
+	return first
+}
+
+func (p *workerPool[T, U]) send(err error) {
+	select {
+	case <-p.ctx.Done():
+	case p.errc.C <- err:
+	}
+}
+
+func (p *workerPool[T, U]) fail(err error) {
+	if p.claim(err) {
+		p.send(err)
+	}
+}
+
+// report hands a skipped item to the dead-letter channel, or to the error
+// channel without one. It reports false if the pool stopped first.
+func (p *workerPool[T, U]) report(item T, err error) bool {
+	if p.deadLetters == nil {
+		return p.o.reportErr(p.poolCtx, p.errc.C, &StageError{Stage: p.name, Item: item, Err: err})
+	}
+	select {
+	case <-p.poolCtx.Done():
+		return false
+	case p.deadLetters.C <- ItemError[T]{Item: item, Err: err}:
+		return true
+	}
+}
+
+// failOnPanic must be deferred directly by a worker. A panic that got past
+// call, from a hook or from fn under WithoutPanicRecovery, stops the pool
+// and, unless it is to crash the program, fails it with a *PanicError. The
+// panic then carries on to the worker's Group.
+func (p *workerPool[T, U]) failOnPanic() {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if p.o.noPanicRecovery {
+		p.cancel()
+	} else {
+		p.fail(&PanicError{Stage: p.name, Value: v, Stack: debug.Stack()})
+	}
+	panic(v)
+}
+
+// terminal is the error the stage ended with, as seen by Hooks.OnStop.
+func (p *workerPool[T, U]) terminal() error {
+	if err := p.failure.Load(); err != nil {
+		return *err
+	}
+	return context.Cause(p.ctx)
+}
+
+// work runs fn on items until they run out, the pool stops or stop is closed,
+// and reports whether stop ended it. in is the channel behind items, whose
+// queue WithSlowWarning reports.
+func (p *workerPool[T, U]) work(info StageInfo, fn func(context.Context, T) (U, error), items, in <-chan T, stop <-chan struct{}) (stopped bool) {
+	o := p.o
+	hb := o.newWorkerHeartbeat("stage", info.Workers, info.Worker)
+	defer hb.stop()
+	o.metrics.workerStarted()
+	defer o.metrics.workerStopped()
+	for {
+		var item T
+		select {
+		case <-p.poolCtx.Done():
+			return false
+		case <-stop:
+			return true
+		case <-hb.C():
+			hb.beat()
+			continue
+		case next, ok := <-items:
+			if !ok {
+				return false
+			}
+			item = next
+		}
+		checkSlow(o, p.name, item, len(in), cap(in))
+		if err := checkDeadline(o, p.name, item); err != nil {
+			if !p.report(item, err) {
+				return false
+			}
+			continue
+		}
+		if p.inFlight != nil && p.inFlight.Acquire(p.poolCtx) != nil {
+			return false
+		}
+		o.metrics.itemIn()
+		start := time.Now()
+		result, err := call(p.poolCtx, o, p.name, fn, item)
+		o.metrics.processed(start, err)
+		if p.inFlight != nil {
+			p.inFlight.Release()
+		}
+		hb.itemDone()
+		itemHook(o, info, item)
+		if err != nil {
+			o.logItemError(p.poolCtx, p.name, item, err)
+			if o.errorPolicy == SkipAndReport && !isPanic(err) {
+				if limitErr := o.checkErrorCount(p.errCount.Add(1), err); limitErr != nil {
+					p.fail(&StageError{Stage: p.name, Item: item, Err: limitErr})
+					return false
+				}
+				if !p.report(item, err) {
+					return false
+				}
+				continue
+			}
+			p.fail(stageFailure(p.name, item, err))
+			return false
+		}
+		logItem(p.poolCtx, o, p.name, item)
+		if !sendWithBeat(p.poolCtx, hb, p.out.C, result) {
+			return false
+		}
+		o.metrics.itemOut()
+	}
+}
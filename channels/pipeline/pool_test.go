@@ -0,0 +1,229 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestStagePoolSpeedup(t *testing.T) {
+	const items, perItem = 40, 20 * time.Millisecond
+	pipelinetest.VerifyNoLeaks(t)
+	pool := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePool(ctx, in, 4, pipelinetest.IOWork(perItem))
+	})
+
+	start := time.Now()
+	got, errs := pipelinetest.Run(t, pool, pipelinetest.Items(items))
+	elapsed := time.Since(start)
+	if len(got) != items || len(errs) != 0 {
+		t.Fatalf("got %d items and errors %v, want %d items", len(got), errs, items)
+	}
+	// Serially this takes 800ms; a quarter of it plus scheduling slack
+	// is well under half.
+	serial := items * perItem
+	if elapsed < serial/4 || elapsed > serial/2 {
+		t.Errorf("4 workers took %v, want about %v", elapsed, serial/4)
+	}
+}
+
+func TestStagePoolMidStreamError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	pool := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePool(ctx, in, 4, failOnSix, pipeline.WithName("transform"))
+	})
+
+	got, errs := pipelinetest.Run(t, pool, pipelinetest.Items(1000))
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Item != 6 {
+		t.Fatalf("errors = %v, want the transform error for 6", errs)
+	}
+	if len(got) >= 999 {
+		t.Errorf("got %d results, want the pool to stop after the error", len(got))
+	}
+}
+
+func TestStagePoolMaxInFlight(t *testing.T) {
+	const limit = 2
+	pipelinetest.VerifyNoLeaks(t)
+	var working, highest atomic.Int32
+	fn := func(ctx context.Context, n int) (int, error) {
+		w := working.Add(1)
+		defer working.Add(-1)
+		for {
+			seen := highest.Load()
+			if w <= seen || highest.CompareAndSwap(seen, w) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return n, nil
+	}
+	pool := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePool(ctx, in, 8, fn, pipeline.WithMaxInFlight(limit))
+	})
+
+	if got, _ := pipelinetest.Run(t, pool, pipelinetest.Items(100)); len(got) != 100 {
+		t.Fatalf("got %d items, want 100", len(got))
+	}
+	if n := highest.Load(); n != limit {
+		t.Errorf("%d items in flight at most, want %d", n, limit)
+	}
+}
+
+// workerState is the state of a StagePoolStateful worker in the tests. Only
+// its worker touches items, so the race detector catches a shared state.
+type workerState struct {
+	id     int
+	items  int
+	closed int
+}
+
+// workerStates creates and closes workerStates, failing to create the one of
+// worker failOn.
+type workerStates struct {
+	failOn int
+
+	mu     sync.Mutex
+	states []*workerState
+}
+
+func (w *workerStates) create(_ context.Context, id int) (*workerState, error) {
+	if id == w.failOn {
+		return nil, errDown
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := &workerState{id: id}
+	w.states = append(w.states, s)
+	return s, nil
+}
+
+func (w *workerStates) close(s *workerState) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s.closed++
+	return nil
+}
+
+// assertClosedOnce checks that n states were created and each closed once.
+func (w *workerStates) assertClosedOnce(t *testing.T, n int) {
+	t.Helper()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.states) != n {
+		t.Errorf("%d states created, want %d", len(w.states), n)
+	}
+	for _, s := range w.states {
+		if s.closed != 1 {
+			t.Errorf("state of worker %d closed %d times, want once", s.id, s.closed)
+		}
+	}
+}
+
+func countItem(_ context.Context, s *workerState, n int) (int, error) {
+	s.items++
+	return n * 2, nil
+}
+
+func TestStagePoolStateful(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	states := workerStates{failOn: -1}
+	pool := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePoolStateful(ctx, in, 4, states.create, countItem, states.close)
+	})
+	out, errs := pipelinetest.Run(t, pool, pipelinetest.Items(100))
+	if len(out) != 100 || len(errs) > 0 {
+		t.Fatalf("got %d items and errors %v, want all 100", len(out), errs)
+	}
+	states.assertClosedOnce(t, 4)
+	total := 0
+	for _, s := range states.states {
+		total += s.items
+	}
+	if total != 100 {
+		t.Errorf("states saw %d items, want 100", total)
+	}
+}
+
+func TestStagePoolStatefulFailingConstructor(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	states := workerStates{failOn: 3}
+	var called atomic.Int64
+	pool := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePoolStateful(ctx, in, 4, states.create, func(ctx context.Context, s *workerState, n int) (int, error) {
+			called.Add(1)
+			return countItem(ctx, s, n)
+		}, states.close, pipeline.WithName("parse"))
+	})
+	out, errs := pipelinetest.Run(t, pool, pipelinetest.Items(10))
+	if len(out) != 0 || called.Load() != 0 {
+		t.Errorf("got %v after %d calls, want the stage never to start", out, called.Load())
+	}
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.Is(errs[0], errDown) || !errors.As(errs[0], &stageErr) || stageErr.Stage != "parse" {
+		t.Fatalf("errors %v, want the failed constructor", errs)
+	}
+	if want := "stage parse: new state for worker 3: " + errDown.Error(); errs[0].Error() != want {
+		t.Errorf("error %q, want %q", errs[0], want)
+	}
+	// The states of the other workers are closed all the same.
+	states.assertClosedOnce(t, 3)
+}
+
+func TestStagePoolStatefulPanic(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	states := workerStates{failOn: -1}
+	pool := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePoolStateful(ctx, in, 4, states.create, func(ctx context.Context, s *workerState, n int) (int, error) {
+			if n == 5 {
+				panic("bad state")
+			}
+			return countItem(ctx, s, n)
+		}, states.close)
+	})
+	_, errs := pipelinetest.Run(t, pool, pipelinetest.Items(20))
+	var panicErr *pipeline.PanicError
+	if len(errs) != 1 || !errors.As(errs[0], &panicErr) || panicErr.Value != "bad state" {
+		t.Fatalf("errors %v, want the panic", errs)
+	}
+	states.assertClosedOnce(t, 4)
+}
+
+func TestStagePoolStatefulCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	states := workerStates{failOn: -1}
+	out, errc := pipeline.StagePoolStateful(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), 3, states.create,
+		func(ctx context.Context, _ *workerState, _ int) (int, error) {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}, states.close)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	states.assertClosedOnce(t, 3)
+}
+
+func TestStagePoolStatefulCloseError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	pool := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.StagePoolStateful(ctx, in, 1, func(context.Context, int) (*workerState, error) {
+			return &workerState{}, nil
+		}, countItem, func(*workerState) error { return errDown })
+	})
+	out, errs := pipelinetest.Run(t, pool, pipelinetest.Items(3))
+	if len(out) != 3 {
+		t.Errorf("got %v, want every item", out)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], errDown) || errs[0].Error() != "stage stage: close state of worker 0: "+errDown.Error() {
+		t.Errorf("errors %v, want the failed close", errs)
+	}
+}
@@ -0,0 +1,65 @@
+package pipeline
+
+import "context"
+
+// Prefetch reads ahead of its consumer: whenever it holds fewer than n items
+// it pulls the next one from in, whether or not anything downstream is
+// asking, and hands the items on in order. A slow source then keeps working
+// while a bursty consumer is away, and the consumer finds up to n items
+// ready when it comes back. Once in is closed the items still held are
+// handed on before the output is closed; on cancellation they are abandoned.
+//
+// Unlike a buffered channel it reports how it is doing in the Metrics set
+// with WithMetrics: In counts the items prefetched, Out those handed on,
+// Buffered is the number held right now and Capacity is n.
+func Prefetch[T any](ctx context.Context, in <-chan T, n int, opts ...Option) <-chan T {
+	o := newOptions(opts)
+	n = max(n, 1)
+	out := make(chan T)
+	if o.metrics != nil {
+		o.metrics.Capacity.Store(int64(n))
+	}
+	go func() {
+		defer close(out)
+		queue := make([]T, 0, n)
+		held := func(n int) {
+			if o.metrics != nil {
+				o.metrics.Buffered.Store(int64(n))
+			}
+		}
+		defer held(0)
+		for in != nil || len(queue) > 0 {
+			// Reading is only enabled while there is room, sending only
+			// while there is something to send.
+			recv, send := in, out
+			if len(queue) == n {
+				recv = nil
+			}
+			var next T
+			if len(queue) == 0 {
+				send = nil
+			} else {
+				next = queue[0]
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-recv:
+				if !ok {
+					in = nil
+					continue
+				}
+				queue = append(queue, item)
+				o.metrics.itemIn()
+				held(len(queue))
+			case send <- next:
+				var zero T
+				queue[0] = zero
+				queue = queue[1:]
+				o.metrics.itemOut()
+				held(len(queue))
+			}
+		}
+	}()
+	return out
+}
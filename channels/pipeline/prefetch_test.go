@@ -0,0 +1,78 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// feed sends items on in like sendAll but leaves it open.
+func feed(t *testing.T, in chan<- int, items ...int) {
+	t.Helper()
+	for _, item := range items {
+		select {
+		case in <- item:
+		case <-time.After(time.Second):
+			t.Fatalf("blocked sending %d", item)
+		}
+	}
+}
+
+func TestPrefetchKeepsOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out := pipeline.Prefetch(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), 10)
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, pipelinetest.Items(100)) {
+		t.Errorf("got %d items, want all 100 in order", len(got))
+	}
+}
+
+func TestPrefetchReadsAhead(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var m pipeline.Metrics
+
+	in := make(chan int)
+	out := pipeline.Prefetch(ctx, in, 3, pipeline.WithMetrics(&m))
+	// Nobody reads out, yet the prefetcher takes 3 items and no more.
+	feed(t, in, 0, 1, 2)
+	select {
+	case in <- 3:
+		t.Fatal("prefetched past its limit of 3")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if s := m.Snapshot(); s.In != 3 || s.Buffered != 3 || s.Capacity != 3 || s.Out != 0 {
+		t.Errorf("metrics %+v, want 3 prefetched and held out of 3", s)
+	}
+
+	if got := <-out; got != 0 {
+		t.Fatalf("got %d, want 0", got)
+	}
+	feed(t, in, 3)
+	close(in)
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("got %v after in was closed, want the held [1 2 3]", got)
+	}
+	if s := m.Snapshot(); s.In != 4 || s.Out != 4 || s.Buffered != 0 {
+		t.Errorf("metrics %+v, want 4 prefetched and handed on", s)
+	}
+}
+
+func TestPrefetchCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan int)
+	out := pipeline.Prefetch(ctx, in, 5)
+	feed(t, in, 0, 1)
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+}
@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+
+	"channelspractice/chanutil"
+)
+
+// WithFairness makes MergePriority serve a lower priority input, if one has an
+// item waiting, after k consecutive items from the same input. Without it a
+// busy high priority input starves the others.
+func WithFairness(k int) Option {
+	return func(o *options) {
+		o.fairness = k
+	}
+}
+
+// MergePriority is MergePriorityN with two inputs.
+func MergePriority[T any](ctx context.Context, high, low <-chan T, opts ...Option) <-chan T {
+	return MergePriorityN(ctx, []<-chan T{high, low}, opts...)
+}
+
+// MergePriorityN fans chans, ordered from highest to lowest priority, into a
+// single channel. Whenever several inputs have an item ready the one with the
+// highest priority is served, subject to WithFairness. The returned channel is
+// closed once every input has been closed or ctx is cancelled.
+func MergePriorityN[T any](ctx context.Context, chans []<-chan T, opts ...Option) <-chan T {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	inputs := append([]<-chan T(nil), chans...)
+
+	go func() {
+		defer close(out)
+		open := len(inputs)
+		for _, ch := range inputs {
+			if ch == nil {
+				open--
+			}
+		}
+		last, streak := -1, 0
+
+		// next returns the next item in priority order, starting below the
+		// last served input when its streak has used up the fairness budget.
+		next := func() (int, T, bool) {
+			start := 0
+			if o.fairness > 0 && streak >= o.fairness {
+				start = last + 1
+			}
+			for n := range len(inputs) {
+				i := (start + n) % len(inputs)
+				if inputs[i] == nil {
+					continue
+				}
+				select {
+				case item, ok := <-inputs[i]:
+					return i, item, ok
+				default:
+				}
+			}
+			cases := make([]reflect.SelectCase, 0, len(inputs)+1)
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+			for _, ch := range inputs {
+				c := reflect.SelectCase{Dir: reflect.SelectRecv}
+				if ch != nil {
+					c.Chan = reflect.ValueOf(ch)
+				}
+				cases = append(cases, c)
+			}
+			chosen, value, ok := reflect.Select(cases)
+			if chosen == 0 {
+				var zero T
+				return -1, zero, false
+			}
+			var item T
+			if ok {
+				item, _ = value.Interface().(T)
+			}
+			return chosen - 1, item, ok
+		}
+
+		for open > 0 {
+			i, item, ok := next()
+			if i < 0 {
+				return
+			}
+			if !ok {
+				inputs[i] = nil
+				open--
+				continue
+			}
+			if i == last {
+				streak++
+			} else {
+				last, streak = i, 1
+			}
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,94 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// filled returns a closed channel holding n copies of v.
+func filled(n, v int) <-chan int {
+	ch := make(chan int, n)
+	for range n {
+		ch <- v
+	}
+	close(ch)
+	return ch
+}
+
+// The values of high and low priority items.
+const highItem, lowItem = 1, 0
+
+func TestMergePriorityPrefersHigh(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := pipelinetest.CollectWithTimeout(t, pipeline.MergePriority(ctx, filled(2000, highItem), filled(2000, lowItem)), time.Second)
+
+	if len(got) != 4000 {
+		t.Fatalf("got %d items, want 4000", len(got))
+	}
+	for i, v := range got[:2000] {
+		if v != highItem {
+			t.Fatalf("item %d is low priority while high priority items were waiting", i)
+		}
+	}
+}
+
+func TestMergePriorityFairness(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := pipeline.MergePriority(ctx, filled(3000, highItem), filled(3000, lowItem), pipeline.WithFairness(3))
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+
+	// While both inputs have items, every fourth one is low priority.
+	lows := 0
+	for _, v := range got[:4000] {
+		if v == lowItem {
+			lows++
+		}
+	}
+	if lows != 1000 {
+		t.Errorf("%d of the first 4000 items are low priority, want 1000", lows)
+	}
+}
+
+func TestMergePriorityNLevels(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := pipelinetest.CollectWithTimeout(t, pipeline.MergePriorityN(ctx, []<-chan int{filled(100, 2), filled(100, 1), filled(100, 0)}), time.Second)
+
+	for i, v := range got {
+		if want := 2 - i/100; v != want {
+			t.Fatalf("item %d has priority %d, want %d", i, v, want)
+		}
+	}
+}
+
+func TestMergePriorityClosesWhenInputsClose(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	high, low := make(chan int), make(chan int)
+	out := pipeline.MergePriority(ctx, high, low)
+	close(high)
+	low <- 7
+	if v := <-out; v != 7 {
+		t.Fatalf("got %d, want 7", v)
+	}
+	close(low)
+	pipelinetest.AssertClosed(t, out, time.Second)
+}
+
+func TestMergePriorityCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := pipeline.MergePriority(ctx, make(chan int), make(chan int))
+	cancel()
+	pipelinetest.AssertClosed(t, out, time.Second)
+}
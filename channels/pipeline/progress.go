@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is the minimum time between two progress updates.
+const progressInterval = 100 * time.Millisecond
+
+// ProgressUpdate reports how many of Total items are done and the average
+// number of items done per second so far.
+type ProgressUpdate struct {
+	Done  int
+	Total int
+	Rate  float64
+}
+
+// Progress counts finished items. Call tick once per finished item, e.g. from a
+// sink. Updates are coalesced to at most one every 100ms so a slow consumer is
+// never flooded; a final update with Done == Total is always sent once every
+// item has ticked. The channel is closed after that or when ctx is cancelled.
+func Progress(ctx context.Context, total int) (tick func(), updates <-chan ProgressUpdate) {
+	var done atomic.Int64
+	finished := make(chan struct{})
+	tick = func() {
+		if done.Add(1) == int64(total) {
+			close(finished)
+		}
+	}
+
+	out := make(chan ProgressUpdate)
+	start := time.Now()
+	update := func() ProgressUpdate {
+		n := int(done.Load())
+		rate := 0.0
+		if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+			rate = float64(n) / elapsed
+		}
+		return ProgressUpdate{Done: n, Total: total, Rate: rate}
+	}
+	send := func(u ProgressUpdate) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case out <- u:
+			return true
+		}
+	}
+
+	go func() {
+		defer close(out)
+		if total <= 0 {
+			send(update())
+			return
+		}
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		last := -1
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-finished:
+				send(update())
+				return
+			case <-ticker.C:
+				u := update()
+				if u.Done == last {
+					continue
+				}
+				if !send(u) {
+					return
+				}
+				last = u.Done
+			}
+		}
+	}()
+	return tick, out
+}
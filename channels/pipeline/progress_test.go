@@ -0,0 +1,61 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestProgressFinalUpdate(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tick, updates := pipeline.Progress(ctx, 20)
+	go func() {
+		for range 20 {
+			time.Sleep(10 * time.Millisecond)
+			tick()
+		}
+	}()
+
+	got := pipelinetest.CollectWithTimeout(t, updates, 2*time.Second)
+	if len(got) == 0 {
+		t.Fatal("no updates")
+	}
+	if last := got[len(got)-1]; last.Done != 20 || last.Total != 20 || last.Rate <= 0 {
+		t.Errorf("final update %+v, want 20 of 20 done", last)
+	}
+	// 200ms of ticking is two or three updates before the final one.
+	if len(got) > 5 {
+		t.Errorf("got %d updates, want them coalesced", len(got))
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].Done < got[i-1].Done {
+			t.Errorf("update %d reports %d done after %d", i, got[i].Done, got[i-1].Done)
+		}
+	}
+}
+
+func TestProgressStopsOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	tick, updates := pipeline.Progress(ctx, 100)
+	tick()
+	cancel()
+	// Nobody reads, so only the cancellation closes the channel.
+	pipelinetest.CollectWithTimeout(t, updates, 50*time.Millisecond)
+}
+
+func TestProgressNoItems(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, updates := pipeline.Progress(ctx, 0)
+	got := pipelinetest.CollectWithTimeout(t, updates, time.Second)
+	if len(got) != 1 || got[0].Done != 0 || got[0].Total != 0 {
+		t.Errorf("got %v, want a single update of 0 of 0", got)
+	}
+}
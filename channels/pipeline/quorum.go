@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SinkFunc is the function of a Sink, e.g. one of the sinks of SinkQuorum.
+type SinkFunc[T any] func(ctx context.Context, item T) error
+
+// WithFinishStragglers lets the sinks of a SinkQuorum that are still writing
+// an item once the quorum was met, or could no longer be, finish instead of
+// cancelling them. They are still cancelled with the pipeline and keep to
+// the deadline of the item.
+func WithFinishStragglers() Option {
+	return func(o *options) {
+		o.finishStragglers = true
+	}
+}
+
+// QuorumError is the error SinkQuorum reports for an item that too few of
+// its sinks saved. Errs holds the error of every sink by its index in the
+// sinks of SinkQuorum: nil for the sinks that saved the item or had not
+// returned yet when the quorum could no longer be met.
+type QuorumError struct {
+	Quorum int
+	Saved  int
+	Errs   []error
+}
+
+func (e *QuorumError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "quorum not met: %d of %d sinks saved the item, %d needed", e.Saved, len(e.Errs), e.Quorum)
+	for i, err := range e.Errs {
+		if err != nil {
+			fmt.Fprintf(&b, "; sink %d: %v", i, err)
+		}
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors of the sinks that failed.
+func (e *QuorumError) Unwrap() []error {
+	var errs []error
+	for _, err := range e.Errs {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// SinkQuorum is a Sink writing every item from in to all of sinks at once,
+// e.g. to a file, an HTTP endpoint and memory, that takes the item as saved
+// once quorum of them succeeded. quorum is at least 1 and at most
+// len(sinks). Every call gets the context of the item, so its deadline
+// applies to each sink, and with a perSinkTimeout above 0 a timeout of its
+// own; an error a sink returns once its timeout expired is wrapped in
+// ErrStageTimeout.
+//
+// Once the quorum is met the sinks still writing the item are cancelled, or
+// with WithFinishStragglers left to finish on their own, and the next item is
+// taken. Once so many sinks failed that the quorum can no longer be met the
+// same happens to the others, and the item fails with a *QuorumError, as the
+// function of a Sink does. The stage is named "quorum" by default, and its
+// sinks "quorum sink 0", "quorum sink 1" and so on in a panic. The error
+// channel is closed once the last straggler returned.
+func SinkQuorum[T any](ctx context.Context, in <-chan T, sinks []SinkFunc[T], quorum int, perSinkTimeout time.Duration, opts ...Option) (<-chan struct{}, <-chan error) {
+	o := newOptions(opts)
+	name := o.stageName("quorum")
+	quorum = min(max(quorum, 1), len(sinks))
+	// As in Hedge, the stage applies the timeout and the trace to the item
+	// as a whole.
+	inner := o
+	inner.itemTimeout = 0
+	inner.trace = nil
+	type result struct {
+		sink int
+		err  error
+	}
+	var calls sync.WaitGroup
+	write := func(callCtx context.Context, i int, item T) error {
+		sinkCtx := callCtx
+		if perSinkTimeout > 0 {
+			var cancel context.CancelFunc
+			sinkCtx, cancel = context.WithTimeout(callCtx, perSinkTimeout)
+			defer cancel()
+		}
+		_, err := call(sinkCtx, inner, fmt.Sprintf("%s sink %d", name, i), func(ctx context.Context, item T) (struct{}, error) {
+			return struct{}{}, sinks[i](ctx, item)
+		}, item)
+		if err != nil && !isPanic(err) && callCtx.Err() == nil && errors.Is(sinkCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w after %v: %w", ErrStageTimeout, perSinkTimeout, err)
+		}
+		return err
+	}
+	fanOut := func(itemCtx context.Context, item T) error {
+		// Without stragglers to finish, returning cancels them; otherwise
+		// the last one to return cancels its context.
+		var callCtx context.Context
+		var cancel context.CancelFunc
+		if o.finishStragglers {
+			callCtx, cancel = outliveItem(ctx, itemCtx)
+		} else {
+			callCtx, cancel = context.WithCancel(itemCtx)
+			defer cancel()
+		}
+		var running atomic.Int64
+		running.Store(int64(len(sinks)))
+		// Stragglers send their results after the item was decided.
+		results := make(chan result, len(sinks))
+		calls.Add(len(sinks))
+		for i := range sinks {
+			go func() {
+				defer calls.Done()
+				results <- result{sink: i, err: write(callCtx, i, item)}
+				if running.Add(-1) == 0 {
+					cancel()
+				}
+			}()
+		}
+
+		errs := make([]error, len(sinks))
+		saved, failed := 0, 0
+		for saved < quorum {
+			select {
+			case <-itemCtx.Done():
+				return itemCtx.Err()
+			case r := <-results:
+				if r.err == nil {
+					saved++
+					continue
+				}
+				errs[r.sink] = r.err
+				if failed++; len(sinks)-failed < quorum {
+					return &QuorumError{Quorum: quorum, Saved: saved, Errs: errs}
+				}
+			}
+		}
+		return nil
+	}
+	done, sinkErrc := Sink(ctx, in, fanOut, append(opts, WithName(name))...)
+
+	errc := make(chan error, cap(sinkErrc))
+	go func() {
+		defer close(errc)
+		for err := range sinkErrc {
+			errc <- err
+		}
+		calls.Wait()
+	}()
+	return done, errc
+}
+
+// outliveItem returns a context for the sink calls of an item that outlives
+// itemCtx, with its values and its deadline, and is cancelled with ctx.
+func outliveItem(ctx, itemCtx context.Context) (context.Context, context.CancelFunc) {
+	detached, cancel := context.WithCancelCause(context.WithoutCancel(itemCtx))
+	stopWatching := context.AfterFunc(ctx, func() { cancel(context.Cause(ctx)) })
+	cancelDeadline := context.CancelFunc(func() {})
+	if deadline, ok := itemCtx.Deadline(); ok {
+		detached, cancelDeadline = context.WithDeadline(detached, deadline)
+	}
+	return detached, func() {
+		stopWatching()
+		cancelDeadline()
+		cancel(nil)
+	}
+}
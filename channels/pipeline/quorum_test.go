@@ -0,0 +1,190 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// memorySinks returns n MemorySinks and their Write methods as sinks.
+func memorySinks(n int) ([]*pipeline.MemorySink[int], []pipeline.SinkFunc[int]) {
+	var mems []*pipeline.MemorySink[int]
+	var sinks []pipeline.SinkFunc[int]
+	for range n {
+		mem := &pipeline.MemorySink[int]{}
+		mems = append(mems, mem)
+		sinks = append(sinks, mem.Write)
+	}
+	return mems, sinks
+}
+
+func TestSinkQuorumMetWithAFailingSink(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mems, sinks := memorySinks(2)
+	sinks = append(sinks, func(context.Context, int) error { return errDown })
+
+	done, errc := pipeline.SinkQuorum(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), sinks, 2, 0)
+	if err := pipeline.Wait(ctx, done, errc); err != nil {
+		t.Fatalf("SinkQuorum failed: %v", err)
+	}
+	for i, mem := range mems {
+		if got := mem.Items(); !slices.Equal(got, pipelinetest.Items(10)) {
+			t.Errorf("sink %d saved %v, want every item", i, got)
+		}
+	}
+}
+
+func TestSinkQuorumNotMet(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mems, sinks := memorySinks(1)
+	failOn3 := func(_ context.Context, n int) error {
+		if n == 3 {
+			return errDown
+		}
+		return nil
+	}
+	sinks = append(sinks, failOn3, failOn3)
+
+	done, errc := pipeline.SinkQuorum(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), sinks, 2, 0, pipeline.WithName("save"))
+	errs := collectAsync(errc)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	got := <-errs
+	var quorumErr *pipeline.QuorumError
+	var stageErr *pipeline.StageError
+	if len(got) != 1 || !errors.As(got[0], &quorumErr) || !errors.As(got[0], &stageErr) || stageErr.Stage != "save" || stageErr.Item != 3 {
+		t.Fatalf("errors %v, want the QuorumError of save on 3", got)
+	}
+	if quorumErr.Quorum != 2 || len(quorumErr.Errs) != 3 || quorumErr.Errs[0] != nil ||
+		!errors.Is(quorumErr.Errs[1], errDown) || !errors.Is(quorumErr.Errs[2], errDown) {
+		t.Errorf("QuorumError %+v, want both failures of 3", quorumErr)
+	}
+	if !errors.Is(got[0], errDown) {
+		t.Errorf("%v does not wrap the failures of the sinks", got[0])
+	}
+	// The sink stops on the failed item.
+	if items := mems[0].Items(); len(items) > 4 {
+		t.Errorf("saved %v, want nothing after 3", items)
+	}
+}
+
+func TestSinkQuorumPerSinkTimeout(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, sinks := memorySinks(2)
+	stuck := func(ctx context.Context, _ int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	sinks = append(sinks, stuck)
+
+	done, errc := pipeline.SinkQuorum(ctx, pipeline.Source(ctx, pipelinetest.Items(3)), sinks, 3, 10*time.Millisecond)
+	errs := collectAsync(errc)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	got := <-errs
+	var quorumErr *pipeline.QuorumError
+	if len(got) != 1 || !errors.As(got[0], &quorumErr) {
+		t.Fatalf("errors %v, want a QuorumError", got)
+	}
+	if quorumErr.Saved != 2 || !errors.Is(quorumErr.Errs[2], pipeline.ErrStageTimeout) {
+		t.Errorf("QuorumError %+v, want the timeout of the stuck sink", quorumErr)
+	}
+}
+
+func TestSinkQuorumCancelsStragglers(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, sinks := memorySinks(2)
+	cancelled := make(chan error, 3)
+	slow := func(ctx context.Context, _ int) error {
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return ctx.Err()
+	}
+	sinks = append(sinks, slow)
+
+	done, errc := pipeline.SinkQuorum(ctx, pipeline.Source(ctx, pipelinetest.Items(3)), sinks, 2, 0)
+	if err := pipeline.Wait(ctx, done, errc); err != nil {
+		t.Fatalf("SinkQuorum failed: %v", err)
+	}
+	for range 3 {
+		if err := <-cancelled; !errors.Is(err, context.Canceled) {
+			t.Errorf("straggler stopped with %v, want it cancelled", err)
+		}
+	}
+}
+
+func TestSinkQuorumFinishStragglers(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mems, sinks := memorySinks(2)
+	release := make(chan struct{})
+	slow := &pipeline.MemorySink[int]{}
+	sinks = append(sinks, func(ctx context.Context, n int) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-release:
+			return slow.Write(ctx, n)
+		}
+	})
+
+	done, errc := pipeline.SinkQuorum(ctx, pipeline.Source(ctx, pipelinetest.Items(3)), sinks, 2, 0, pipeline.WithFinishStragglers())
+	errs := collectAsync(errc)
+	// The quorum saves every item while the slow sink is still writing.
+	pipelinetest.AssertClosed(t, done, time.Second)
+	if got := mems[0].Items(); !slices.Equal(got, pipelinetest.Items(3)) {
+		t.Errorf("saved %v, want every item", got)
+	}
+	select {
+	case <-errs:
+		t.Fatal("error channel closed while stragglers were still writing")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	if got := <-errs; len(got) > 0 {
+		t.Errorf("errors %v, want none", got)
+	}
+	if got := slices.Sorted(slices.Values(slow.Items())); !slices.Equal(got, pipelinetest.Items(3)) {
+		t.Errorf("straggler saved %v, want every item", got)
+	}
+}
+
+func TestSinkQuorumCancelMidItem(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	for _, opts := range [][]pipeline.Option{nil, {pipeline.WithFinishStragglers()}} {
+		ctx, cancel := context.WithCancel(context.Background())
+		started := make(chan struct{}, 3)
+		stuck := func(ctx context.Context, _ int) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		sinks := []pipeline.SinkFunc[int]{stuck, stuck, stuck}
+		done, errc := pipeline.SinkQuorum(ctx, pipeline.Source(ctx, pipelinetest.Items(3)), sinks, 2, 0, opts...)
+		errs := collectAsync(errc)
+		for range 3 {
+			<-started
+		}
+		cancel()
+		pipelinetest.AssertClosed(t, done, time.Second)
+		select {
+		case <-errs:
+		case <-time.After(time.Second):
+			t.Fatal("error channel not closed after the cancellation")
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"channelspractice/chanutil"
+)
+
+// WithMaxLineLength sets the longest line FromReader accepts, in bytes. Longer
+// lines fail the source with bufio.ErrTooLong. The default is
+// bufio.MaxScanTokenSize.
+func WithMaxLineLength(n int) Option {
+	return func(o *options) {
+		o.maxLineLength = n
+	}
+}
+
+// FromReader emits the lines read from r without their line endings. Both
+// channels close on EOF, on the first read error, which is sent on the error
+// channel, or when ctx is cancelled.
+//
+// A Read that blocks cannot be interrupted, so on cancellation the channels
+// close right away but the goroutine reading r only returns once that Read
+// does. Close r to release it.
+func FromReader(ctx context.Context, r io.Reader, opts ...Option) (<-chan string, <-chan error) {
+	o := newOptions(opts)
+	out := make(chan string, o.buffer)
+	errc := make(chan error, 1)
+	unregister := o.register("source")
+	name := o.stageName("source")
+	o.logStart(ctx, name)
+
+	type scanned struct {
+		line string
+		err  error
+	}
+	lines := make(chan scanned)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		maxLen := o.maxLineLength
+		if maxLen <= 0 {
+			maxLen = bufio.MaxScanTokenSize
+		}
+		scanner.Buffer(make([]byte, 0, min(maxLen, 4096)), maxLen)
+		for scanner.Scan() {
+			select {
+			case <-ctx.Done():
+				return
+			case lines <- scanned{line: scanner.Text()}:
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			select {
+			case <-ctx.Done():
+			case lines <- scanned{err: err}:
+			}
+		}
+	}()
+
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(out)
+		for {
+			var s scanned
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case s, ok = <-lines:
+			}
+			if !ok {
+				return
+			}
+			if s.err != nil {
+				errc <- &StageError{Stage: name, Err: fmt.Errorf("read lines: %w", s.err)}
+				return
+			}
+			if chanutil.SendContext(ctx, out, s.line) != nil {
+				return
+			}
+		}
+	}()
+	return out, errc
+}
@@ -0,0 +1,67 @@
+package pipeline_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestFromReaderLines(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, errc := pipeline.FromReader(ctx, strings.NewReader("one\r\ntwo\n\nthree"))
+
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	if want := []string{"one", "two", "", "three"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFromReaderLineTooLong(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	input := "short\n" + strings.Repeat("x", 100) + "\nafter\n"
+	out, errc := pipeline.FromReader(ctx, strings.NewReader(input), pipeline.WithMaxLineLength(16))
+
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if !slices.Equal(got, []string{"short"}) {
+		t.Errorf("got %q, want the lines before the long one", got)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], bufio.ErrTooLong) {
+		t.Errorf("errors = %v, want bufio.ErrTooLong", errs)
+	}
+}
+
+func TestFromReaderBlockedReadCancelled(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	r, w := io.Pipe()
+	out, errc := pipeline.FromReader(ctx, r)
+
+	if _, err := io.WriteString(w, "first\n"); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-out; line != "first" {
+		t.Fatalf("got %q, want first", line)
+	}
+	// The reader is now blocked in Read with nothing more coming.
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	// Closing the pipe releases the goroutine stuck in Read.
+	w.Close()
+}
@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"channelspractice/chanutil"
+)
+
+// Record forwards the items from in unchanged while writing each of them to w
+// as a frame, see WriteFrame, of its encoding with the codec set with
+// WithCodec, GobCodec by default, which Replay with the same codec can read
+// back. An item is written before it is forwarded, so the recording holds
+// every item the downstream stage saw, even if ctx is cancelled. An encode or
+// write error stops the stage and is sent on the error channel.
+func Record[T any](ctx context.Context, in <-chan T, w io.Writer, opts ...Option) (<-chan T, <-chan error) {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	errc := make(chan error, 1)
+	name := o.stageName("record")
+	codec, limit := codecOf[T](o), o.frameLimit()
+	go func() {
+		defer close(errc)
+		defer close(out)
+		for item := range OrDone(ctx, in) {
+			frame, err := encodeFrame(codec, item, limit)
+			if err == nil {
+				_, err = w.Write(frame)
+			}
+			if err != nil {
+				errc <- &StageError{Stage: name, Item: item, Err: fmt.Errorf("record: %w", err)}
+				return
+			}
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out, errc
+}
+
+// Replay emits the items of a recording made by Record, in their original
+// order, decoding them with the codec set with WithCodec, GobCodec by default.
+// A recording that ends mid-record, or is otherwise corrupt, stops the source
+// with an error wrapping the read or decode error, e.g. io.ErrUnexpectedEOF,
+// or ErrFrameTooLarge for a length prefix above WithMaxFrameSize.
+func Replay[T any](ctx context.Context, r io.Reader, opts ...Option) (<-chan T, <-chan error) {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	errc := make(chan error, 1)
+	unregister := o.register("source")
+	name := o.stageName("source")
+	o.logStart(ctx, name)
+	codec, limit := codecOf[T](o), o.frameLimit()
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(out)
+		br := bufio.NewReader(r)
+		for n := 0; ; n++ {
+			data, err := ReadFrame(br, limit)
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				errc <- &StageError{Stage: name, Err: fmt.Errorf("replay: %w", err)}
+				return
+			}
+			item, err := codec.Decode(data)
+			if err != nil {
+				errc <- &StageError{Stage: name, Err: fmt.Errorf("replay: decode record %d: %w", n, err)}
+				return
+			}
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out, errc
+}
@@ -0,0 +1,136 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+type reading struct {
+	Sensor string
+	Value  float64
+	Tags   []string
+}
+
+func readings(n int) []reading {
+	rs := make([]reading, n)
+	for i := range rs {
+		rs[i] = reading{Sensor: string(rune('a' + i%26)), Value: float64(i) / 2, Tags: []string{"t", string(rune('0' + i%10))}}
+	}
+	return rs
+}
+
+func replayAll[T any](t *testing.T, r io.Reader) ([]T, []error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, errc := pipeline.Replay[T](ctx, r)
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	return got, pipelinetest.CollectWithTimeout(t, errc, time.Second)
+}
+
+func TestRecordReplayRoundTrip(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	want := readings(100)
+	var buf bytes.Buffer
+	out, errc := pipeline.Record(ctx, pipeline.Source(ctx, want), &buf)
+
+	passed := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Fatalf("record errors: %v", errs)
+	}
+	if !slices.EqualFunc(passed, want, readingEqual) {
+		t.Fatal("Record changed the items or their order")
+	}
+
+	got, errs := replayAll[reading](t, &buf)
+	if len(errs) != 0 {
+		t.Fatalf("replay errors: %v", errs)
+	}
+	if !slices.EqualFunc(got, want, readingEqual) {
+		t.Errorf("replayed %d items, want the %d recorded in order", len(got), len(want))
+	}
+}
+
+func readingEqual(a, b reading) bool {
+	return a.Sensor == b.Sensor && a.Value == b.Value && slices.Equal(a.Tags, b.Tags)
+}
+
+func TestReplayTruncatedRecording(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var buf bytes.Buffer
+	out, errc := pipeline.Record(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), &buf)
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+
+	// Cut the last record short, as a crash mid-write would.
+	truncated := buf.Bytes()[:buf.Len()-1]
+	got, errs := replayAll[int](t, bytes.NewReader(truncated))
+	if want := pipelinetest.Items(9); !slices.Equal(got, want) {
+		t.Errorf("replayed %v, want %v before the cut", got, want)
+	}
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || !errors.Is(errs[0], io.ErrUnexpectedEOF) {
+		t.Errorf("errors = %v, want one StageError wrapping io.ErrUnexpectedEOF", errs)
+	}
+}
+
+func TestReplayEmptyRecording(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	got, errs := replayAll[int](t, bytes.NewReader(nil))
+	if len(got) != 0 || len(errs) != 0 {
+		t.Errorf("got %v and errors %v from an empty recording, want neither", got, errs)
+	}
+}
+
+func TestRecordHoldsEveryForwardedItem(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	var buf bytes.Buffer
+	out, errc := pipeline.Record(ctx, pipeline.Source(ctx, pipelinetest.Items(1000)), &buf)
+
+	var seen []int
+	for range 5 {
+		seen = append(seen, <-out)
+	}
+	cancel()
+	seen = append(seen, pipelinetest.CollectWithTimeout(t, out, time.Second)...)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+
+	recorded, errs := replayAll[int](t, &buf)
+	if len(errs) != 0 {
+		t.Fatalf("replay errors: %v", errs)
+	}
+	// An item may be recorded and then dropped by the cancellation, never
+	// the other way round.
+	if len(recorded) < len(seen) || !slices.Equal(recorded[:len(seen)], seen) {
+		t.Errorf("recorded %v, want it to start with the forwarded %v", recorded, seen)
+	}
+}
+
+func TestRecordWriteError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	record := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Record(ctx, in, &failingWriter{limit: 100})
+	})
+
+	got, errs := pipelinetest.Run(t, record, pipelinetest.Items(1000))
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "record" || !errors.Is(errs[0], errDiskFull) {
+		t.Fatalf("errors = %v, want one record error wrapping errDiskFull", errs)
+	}
+	if len(got) >= 1000 {
+		t.Errorf("forwarded %d items, want the stage to stop at the write error", len(got))
+	}
+}
@@ -0,0 +1,139 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Registry keeps track of the stages that are currently running so that a
+// shutdown that hangs can report what it is waiting for. It also collects the
+// Metrics of stages given WithMetrics for Snapshot.
+type Registry struct {
+	mu      sync.Mutex
+	running map[string]int
+	metrics map[string]*Metrics
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{running: make(map[string]int), metrics: make(map[string]*Metrics)}
+}
+
+// StageStats describes a stage at the time of a Snapshot.
+type StageStats struct {
+	Name   string
+	In     int64
+	Out    int64
+	Errors int64
+	// Occupancy is how full the output buffer was at the last sample,
+	// from 0 to 1. It is 0 for stages without a buffer.
+	Occupancy float64
+	// AvgProcessing is the average time the stage function took per item.
+	AvgProcessing time.Duration
+	// AvgLatency is the average time envelopes took from their source to
+	// this sink, for a SinkItems only.
+	AvgLatency time.Duration
+	// Throughput is the items per second the stage emitted over the last
+	// 10 seconds.
+	Throughput float64
+	// P50, P95 and P99 are percentiles of the time the stage function took
+	// per item.
+	P50, P95, P99 time.Duration
+	// Workers is the number of workers running now.
+	Workers int64
+	// Buffered and Capacity are the length and capacity of the output
+	// buffer at the last sample.
+	Buffered, Capacity int64
+}
+
+// Snapshot returns the stats of every stage registered with metrics, sorted by
+// name. Stages that exited are included with their final counts.
+func (r *Registry) Snapshot() []StageStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make([]StageStats, 0, len(r.metrics))
+	for _, name := range slices.Sorted(maps.Keys(r.metrics)) {
+		m := r.metrics[name].Snapshot()
+		s := StageStats{
+			Name: name, In: m.In, Out: m.Out, Errors: m.Errors,
+			Throughput: m.Throughput, P50: m.P50, P95: m.P95, P99: m.P99,
+			Workers: m.Workers, Buffered: m.Buffered, Capacity: m.Capacity,
+		}
+		if m.Capacity > 0 {
+			s.Occupancy = float64(m.Buffered) / float64(m.Capacity)
+		}
+		if m.In > 0 {
+			s.AvgProcessing = m.ProcessingTime / time.Duration(m.In)
+		}
+		if m.LatencyCount > 0 {
+			s.AvgLatency = m.Latency / time.Duration(m.LatencyCount)
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// WriteStats writes the Snapshot to w as a table, one line per stage.
+func (r *Registry) WriteStats(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "stage\tin\tout\terrors\titems/s\tp50\tp95\tp99\tworkers\tbuffer\t")
+	for _, s := range r.Snapshot() {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.1f\t%v\t%v\t%v\t%d\t%d/%d\t\n", s.Name, s.In, s.Out, s.Errors, s.Throughput,
+			roundDuration(s.P50), roundDuration(s.P95), roundDuration(s.P99), s.Workers, s.Buffered, s.Capacity)
+	}
+	return tw.Flush()
+}
+
+// roundDuration keeps 3 significant digits of d, which is all a histogram
+// percentile has anyway.
+func roundDuration(d time.Duration) time.Duration {
+	for unit := time.Duration(1); unit < time.Hour; unit *= 10 {
+		if d < 1000*unit {
+			return d.Round(unit)
+		}
+	}
+	return d.Round(time.Second)
+}
+
+// Running returns the sorted names of the stages that have not exited yet.
+func (r *Registry) Running() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.running))
+	for name := range r.running {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (r *Registry) add(name string, m *Metrics) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running[name]++
+	if m != nil {
+		r.metrics[name] = m
+	}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.running[name]--
+		if r.running[name] == 0 {
+			delete(r.running, name)
+		}
+	}
+}
+
+// register records a stage in the configured registry and returns the
+// function that removes it again.
+func (o options) register(kind string) func() {
+	if o.registry == nil {
+		return func() {}
+	}
+	return o.registry.add(o.stageName(kind), o.metrics)
+}
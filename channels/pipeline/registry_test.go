@@ -0,0 +1,72 @@
+package pipeline_test
+
+import (
+	"context"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestRegistryTracksRunningStages(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry := pipeline.NewRegistry()
+	in := make(chan int)
+	out, errc := pipeline.Stage(ctx, in, failOnSix, pipeline.WithName("transform"), pipeline.WithRegistry(registry))
+
+	if got := registry.Running(); !slices.Equal(got, []string{"transform"}) {
+		t.Errorf("Running() = %v, want [transform]", got)
+	}
+	close(in)
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if got := registry.Running(); len(got) != 0 {
+		t.Errorf("Running() = %v after the stage exited, want none", got)
+	}
+}
+
+// TestSecondSignalForcesExit has a save that is stuck on a hung disk, so the
+// graceful shutdown after the first signal never completes.
+func TestSecondSignalForcesExit(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	registry := pipeline.NewRegistry()
+	sigs := make(chan os.Signal, 2)
+	var stillRunning []string
+	forced := make(chan struct{})
+	ctx, stop := pipeline.ShutdownOnSignal(context.Background(), sigs, func() {
+		stillRunning = registry.Running()
+		close(forced)
+	})
+	defer stop()
+
+	saving, hung := make(chan struct{}), make(chan struct{})
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, []int{1}), func(context.Context, int) error {
+		close(saving)
+		<-hung
+		return nil
+	}, pipeline.WithName("save"), pipeline.WithRegistry(registry))
+	<-saving
+
+	sigs <- syscall.SIGINT
+	pipelinetest.AssertClosed(t, ctx.Done(), time.Second)
+	select {
+	case <-done:
+		t.Fatal("save finished although it is hung")
+	case <-time.After(10 * time.Millisecond):
+	}
+	sigs <- syscall.SIGINT
+	pipelinetest.AssertClosed(t, forced, time.Second)
+	if !slices.Contains(stillRunning, "save") {
+		t.Errorf("force saw %v running, want save", stillRunning)
+	}
+
+	close(hung)
+	pipelinetest.AssertClosed(t, done, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+}
@@ -0,0 +1,262 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Termination is how a stage of a pipeline ended.
+type Termination int
+
+const (
+	// TerminationUnknown is reported for a stage without lifecycle hooks,
+	// such as the source of New, or one that had not stopped.
+	TerminationUnknown Termination = iota
+	// TerminatedInputClosed means the stage drained its input.
+	TerminatedInputClosed
+	// TerminatedCancelled means the stage was stopped through its context.
+	TerminatedCancelled
+	// TerminatedError means the stage stopped on an error of its own.
+	TerminatedError
+)
+
+func (t Termination) String() string {
+	switch t {
+	case TerminatedInputClosed:
+		return "input closed"
+	case TerminatedCancelled:
+		return "cancelled"
+	case TerminatedError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes t as its String.
+func (t Termination) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// StageReport describes one stage at the end of a run.
+type StageReport struct {
+	Stage  string
+	In     int64
+	Out    int64
+	Errors int64
+	// Panicked is set if the stage reported a *PanicError.
+	Panicked    bool
+	Termination Termination
+	// Err is the error the stage stopped with: its failure for
+	// TerminatedError and the cause of its context for TerminatedCancelled.
+	Err error
+	// Runtime is the time from the first worker starting to the last one
+	// stopping.
+	Runtime time.Duration
+}
+
+// Report summarizes a run of a Pipeline, one entry per stage in pipeline
+// order. The counts are those of the Metrics of the stages, the termination
+// and runtime come from their lifecycle hooks.
+type Report struct {
+	Stages  []StageReport
+	Runtime time.Duration
+}
+
+// Stage returns the report of the stage called name.
+func (r Report) Stage(name string) (StageReport, bool) {
+	i := slices.IndexFunc(r.Stages, func(s StageReport) bool { return s.Stage == name })
+	if i < 0 {
+		return StageReport{}, false
+	}
+	return r.Stages[i], true
+}
+
+// String renders the report as a table, one line per stage.
+func (r Report) String() string {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "stage\tin\tout\terrors\tpanicked\ttermination\truntime\terror")
+	for _, s := range r.Stages {
+		errText := "-"
+		if s.Err != nil {
+			errText = s.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%t\t%s\t%v\t%s\n", s.Stage, s.In, s.Out, s.Errors, s.Panicked, s.Termination,
+			roundDuration(s.Runtime), errText)
+	}
+	tw.Flush()
+	fmt.Fprintf(&b, "total runtime %v\n", roundDuration(r.Runtime))
+	return b.String()
+}
+
+// MarshalJSON encodes the report with the errors as their text and the
+// durations in nanoseconds.
+func (r Report) MarshalJSON() ([]byte, error) {
+	type stage struct {
+		Stage       string        `json:"stage"`
+		In          int64         `json:"in"`
+		Out         int64         `json:"out"`
+		Errors      int64         `json:"errors"`
+		Panicked    bool          `json:"panicked"`
+		Termination Termination   `json:"termination"`
+		Err         string        `json:"error,omitempty"`
+		Runtime     time.Duration `json:"runtime_ns"`
+	}
+	stages := make([]stage, len(r.Stages))
+	for i, s := range r.Stages {
+		stages[i] = stage{Stage: s.Stage, In: s.In, Out: s.Out, Errors: s.Errors, Panicked: s.Panicked,
+			Termination: s.Termination, Runtime: s.Runtime}
+		if s.Err != nil {
+			stages[i].Err = s.Err.Error()
+		}
+	}
+	return json.Marshal(struct {
+		Stages  []stage       `json:"stages"`
+		Runtime time.Duration `json:"runtime_ns"`
+	}{stages, r.Runtime})
+}
+
+// runStats collects the Report of one run. A nil *runStats collects nothing.
+type runStats struct {
+	start  time.Time
+	mu     sync.Mutex
+	stages []*stageStats
+	byName map[string]*stageStats
+}
+
+type stageStats struct {
+	name    string
+	metrics *Metrics
+	hooked  bool
+
+	running           int
+	stops             int
+	started, stopped  time.Time
+	terminal, lastErr error
+	panicked          bool
+}
+
+func newRunStats() *runStats {
+	return &runStats{start: time.Now(), byName: make(map[string]*stageStats)}
+}
+
+// instrument returns opts with what the report of the stage labelled name
+// needs: Metrics, unless opts has them already, and, if hooked, Hooks around
+// those of opts.
+func (s *runStats) instrument(name string, hooked bool, opts []Option) []Option {
+	if s == nil {
+		return opts
+	}
+	o := newOptions(opts)
+	opts = slices.Clip(opts)
+	if o.metrics == nil {
+		o.metrics = &Metrics{}
+		opts = append(opts, WithMetrics(o.metrics))
+	}
+	st := &stageStats{name: name, metrics: o.metrics, hooked: hooked}
+	s.mu.Lock()
+	s.stages = append(s.stages, st)
+	s.byName[name] = st
+	s.mu.Unlock()
+	if !hooked {
+		return opts
+	}
+	hooks := o.hooks
+	return append(opts, WithHooks(Hooks{
+		OnStart: func(ctx context.Context, info StageInfo) error {
+			s.workerStarted(st)
+			if hooks.OnStart != nil {
+				return hooks.OnStart(ctx, info)
+			}
+			return nil
+		},
+		OnStop: func(info StageInfo, err error) {
+			if hooks.OnStop != nil {
+				hooks.OnStop(info, err)
+			}
+			s.workerStopped(st, info, err)
+		},
+		OnItem: hooks.OnItem,
+	}))
+}
+
+func (s *runStats) workerStarted(st *stageStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st.started.IsZero() {
+		st.started = time.Now()
+	}
+	st.running++
+}
+
+func (s *runStats) workerStopped(st *stageStats, info StageInfo, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.running--
+	st.stops++
+	st.stopped = time.Now()
+	if err != nil && st.terminal == nil {
+		st.terminal = err
+	}
+	// A stage cancelled by the panic of another one stops with it as well.
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) && panicErr.Stage == info.Stage {
+		st.panicked = true
+	}
+}
+
+// saw records err, reported by the stage labelled name.
+func (s *runStats) saw(name string, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.byName[name]
+	if st == nil {
+		return
+	}
+	st.lastErr = err
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		st.panicked = true
+	}
+}
+
+// report returns the Report as of now.
+func (s *runStats) report() Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := Report{Stages: make([]StageReport, 0, len(s.stages)), Runtime: time.Since(s.start)}
+	for _, st := range s.stages {
+		stage := StageReport{
+			Stage:    st.name,
+			In:       st.metrics.In.Load(),
+			Out:      st.metrics.Out.Load(),
+			Errors:   st.metrics.Errors.Load(),
+			Panicked: st.panicked,
+		}
+		if st.hooked && st.stops > 0 && st.running == 0 {
+			stage.Runtime = st.stopped.Sub(st.started)
+			stage.Err = st.terminal
+			switch {
+			case st.terminal == nil:
+				stage.Termination = TerminatedInputClosed
+			case st.lastErr != nil && errors.Is(st.lastErr, st.terminal):
+				// The stage stopped on the error it reported last.
+				stage.Termination = TerminatedError
+			default:
+				stage.Termination = TerminatedCancelled
+			}
+		}
+		r.Stages = append(r.Stages, stage)
+	}
+	return r
+}
@@ -0,0 +1,198 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// stageReport returns the report of stage, failing the test without one.
+func stageReport(t *testing.T, r pipeline.Report, stage string) pipeline.StageReport {
+	t.Helper()
+	s, ok := r.Stage(stage)
+	if !ok {
+		t.Fatalf("no report of %q in:\n%s", stage, r)
+	}
+	return s
+}
+
+// lessonPipeline is the pipeline of lesson_019: the numbers 0 to 10 doubled by
+// transform, which fails on failOn unless it is negative, and saved by save.
+func lessonPipeline(failOn int, save func(context.Context, int) error) *pipeline.Pipeline {
+	transform := func(_ context.Context, n int) (int, error) {
+		if n == failOn {
+			return 0, errors.New("number is invalid")
+		}
+		return n * 2, nil
+	}
+	return pipeline.New(pipelinetest.Items(11), pipeline.WithName("generator")).
+		Then(transform, pipeline.WithName("transform"), pipeline.WithBuffer(11)).
+		Sink(save, pipeline.WithName("save"))
+}
+
+// saveUntilCancelled is a save that takes the first item and holds on to it
+// until the pipeline is cancelled, so that the stages before it run ahead
+// into their buffers.
+func saveUntilCancelled(ctx context.Context, _ int) error {
+	<-ctx.Done()
+	return nil
+}
+
+func saveAll(context.Context, int) error { return nil }
+
+func TestReportFailurePath(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	report, err := lessonPipeline(6, saveUntilCancelled).Run(context.Background())
+	if err == nil {
+		t.Fatal("Run = nil, want the failure of 6")
+	}
+
+	if names := len(report.Stages); names != 3 {
+		t.Errorf("%d stages, want generator, transform and save:\n%s", names, report)
+	}
+	transform := stageReport(t, report, "transform")
+	if transform.Termination != pipeline.TerminatedError || !errors.Is(err, transform.Err) {
+		t.Errorf("transform terminated by %v with %v, want by the error %v", transform.Termination, transform.Err, err)
+	}
+	if transform.In != 7 || transform.Out != 6 || transform.Errors != 1 || transform.Panicked {
+		t.Errorf("transform = %+v, want 7 in, 6 out and 1 error", transform)
+	}
+	save := stageReport(t, report, "save")
+	if save.Termination != pipeline.TerminatedCancelled || !errors.Is(save.Err, transform.Err) {
+		t.Errorf("save terminated by %v with %v, want cancelled by the failure of transform", save.Termination, save.Err)
+	}
+	if save.In < 1 || save.In > 6 || save.Errors != 0 {
+		t.Errorf("save = %+v, want some of the 6 items before the failure in", save)
+	}
+	if generator := stageReport(t, report, "generator"); generator.Termination != pipeline.TerminationUnknown {
+		t.Errorf("generator terminated by %v, want unknown without hooks", generator.Termination)
+	}
+}
+
+func TestReportSuccessPath(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	report, err := lessonPipeline(-1, saveAll).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+	if generator := stageReport(t, report, "generator"); generator.Out != 11 {
+		t.Errorf("generator emitted %d, want 11", generator.Out)
+	}
+	for _, name := range []string{"transform", "save"} {
+		s := stageReport(t, report, name)
+		if s.In != 11 || s.Out != 11 || s.Errors != 0 || s.Termination != pipeline.TerminatedInputClosed || s.Err != nil {
+			t.Errorf("%s = %+v, want 11 in and out, terminated by its input closing", name, s)
+		}
+	}
+	if report.Runtime <= 0 || stageReport(t, report, "transform").Runtime <= 0 {
+		t.Errorf("runtimes not recorded:\n%s", report)
+	}
+}
+
+func TestReportPanic(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	panicky := func(ctx context.Context, n int) (int, error) {
+		if n == 3 {
+			panic(errBoom)
+		}
+		return double(ctx, n)
+	}
+	report, err := pipeline.New(pipelinetest.Items(5)).
+		Then(panicky, pipeline.WithName("transform"), pipeline.WithBuffer(5)).
+		Sink(saveUntilCancelled, pipeline.WithName("save")).
+		Run(context.Background())
+	var panicErr *pipeline.PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("Run = %v, want the panic", err)
+	}
+	transform := stageReport(t, report, "transform")
+	if !transform.Panicked || transform.Errors != 1 || transform.Termination != pipeline.TerminatedError {
+		t.Errorf("transform = %+v, want terminated by its panic", transform)
+	}
+	if save := stageReport(t, report, "save"); save.Panicked {
+		t.Errorf("save = %+v, want no panic", save)
+	}
+}
+
+func TestReportCancelled(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	saving := make(chan struct{})
+	var saved atomic.Int32
+	report, err := pipeline.New(pipelinetest.Items(1000)).
+		Then(double, pipeline.WithName("transform")).
+		Sink(func(ctx context.Context, _ int) error {
+			if saved.Add(1) == 1 {
+				close(saving)
+				cancel()
+			}
+			<-ctx.Done()
+			return nil
+		}, pipeline.WithName("save")).
+		Run(ctx)
+	<-saving
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want context.Canceled", err)
+	}
+	if save := stageReport(t, report, "save"); save.Termination != pipeline.TerminatedCancelled || !errors.Is(save.Err, context.Canceled) {
+		t.Errorf("save terminated by %v with %v, want cancelled", save.Termination, save.Err)
+	}
+}
+
+func TestReportKeepsHooksAndMetrics(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var stops atomic.Int32
+	m := &pipeline.Metrics{}
+	hooks := pipeline.Hooks{OnStop: func(pipeline.StageInfo, error) { stops.Add(1) }}
+	report, err := pipeline.New(pipelinetest.Items(6)).
+		ThenPool(3, double, pipeline.WithName("transform"), pipeline.WithHooks(hooks), pipeline.WithMetrics(m)).
+		Sink(saveAll).
+		Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+	if n := stops.Load(); n != 3 {
+		t.Errorf("OnStop ran %d times, want once per worker", n)
+	}
+	if in := m.In.Load(); in != 6 || stageReport(t, report, "transform").In != 6 {
+		t.Errorf("metrics counted %d, report %+v, want 6 both", in, stageReport(t, report, "transform"))
+	}
+}
+
+func TestReportRendering(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	report, _ := lessonPipeline(6, saveUntilCancelled).Run(context.Background())
+
+	text := report.String()
+	for _, want := range []string{"stage", "termination", "transform", "error", "number is invalid"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("String does not contain %q:\n%s", want, text)
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Stages []struct {
+			Stage       string `json:"stage"`
+			In          int64  `json:"in"`
+			Termination string `json:"termination"`
+			Err         string `json:"error"`
+		} `json:"stages"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+	if len(decoded.Stages) != 3 || decoded.Stages[1].Stage != "transform" || decoded.Stages[1].Termination != "error" ||
+		!strings.Contains(decoded.Stages[1].Err, "number is invalid") {
+		t.Errorf("JSON = %s, want transform second, terminated by its error", data)
+	}
+}
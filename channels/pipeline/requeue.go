@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+)
+
+// RequeueOptions controls how often Requeue feeds an item back.
+type RequeueOptions struct {
+	// MaxRequeues is how often an item is fed back before Requeue gives up
+	// on it. Values below 1 are treated as 1.
+	MaxRequeues int
+}
+
+// Requeue feeds the items from deadLetters, the dead letters of a stage
+// such as StagePoolWithDeadLetters, back into into, the input of that stage
+// or of an earlier one, so that they are tried again. Every time it does so
+// it counts the item's Requeues up; an item that failed again after
+// MaxRequeues requeues is given up on and sent on the returned channel
+// instead, with an error wrapping ErrRequeueLimit and its last failure.
+//
+// Requeue never blocks on into or on its output, so the stage sending it dead
+// letters is never held up by the items it feeds back: what cannot be sent
+// yet is queued. The returned channel must be consumed, and closes once
+// deadLetters is closed or ctx is cancelled.
+//
+// With items coming back, the source alone can no longer tell when into may
+// close. A chanutil.RefCloser does: the source holds a reference until it is
+// done, and every item one from before the source sends it until it leaves
+// the pipeline, through the sink or given up on, so into closes with the last
+// item out.
+func Requeue[T any](ctx context.Context, deadLetters <-chan ItemError[Item[T]], into chan<- Item[T], opts RequeueOptions) <-chan ItemError[Item[T]] {
+	limit := max(opts.MaxRequeues, 1)
+	out := make(chan ItemError[Item[T]])
+	go func() {
+		defer close(out)
+		var retries []Item[T]
+		var givenUp []ItemError[Item[T]]
+		for deadLetters != nil || len(retries) > 0 || len(givenUp) > 0 {
+			// A nil channel takes its case out of the select.
+			var retryc chan<- Item[T]
+			var retry Item[T]
+			if len(retries) > 0 {
+				retryc, retry = into, retries[0]
+			}
+			var givenUpc chan<- ItemError[Item[T]]
+			var gaveUp ItemError[Item[T]]
+			if len(givenUp) > 0 {
+				givenUpc, gaveUp = out, givenUp[0]
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case dead, ok := <-deadLetters:
+				if !ok {
+					// The stage is done, so no one reads into any more.
+					deadLetters, retries = nil, nil
+					continue
+				}
+				if dead.Item.Requeues >= limit {
+					dead.Err = fmt.Errorf("%w after %d requeues: %w", ErrRequeueLimit, dead.Item.Requeues, dead.Err)
+					givenUp = append(givenUp, dead)
+					continue
+				}
+				dead.Item.Requeues++
+				retries = append(retries, dead.Item)
+			case retryc <- retry:
+				retries = retries[1:]
+			case givenUpc <- gaveUp:
+				givenUp = givenUp[1:]
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,159 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/chanutil"
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+var errPoison = errors.New("poison")
+
+// requeueRun is a stage with its dead letters requeued into its input.
+type requeueRun struct {
+	mu       sync.Mutex
+	attempts map[int][]int // the Requeues of every attempt by value
+	done     []pipeline.Item[int]
+	givenUp  []pipeline.ItemError[pipeline.Item[int]]
+	errs     []error
+}
+
+// run feeds values through a stage of two workers in which fail decides on
+// the failures, requeueing them up to maxRequeues times. It returns the
+// closer of the stage's input once the run is over.
+func (r *requeueRun) run(t *testing.T, values []int, maxRequeues int, fail func(value, attempt int) error) *chanutil.RefCloser[pipeline.Item[int]] {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.attempts = make(map[int][]int)
+	input := chanutil.NewRefCloser(make(chan pipeline.Item[int]), 1)
+	go func() {
+		defer input.Done()
+		for _, v := range values {
+			input.Add(1)
+			if chanutil.SendContext(ctx, input.C, pipeline.Item[int]{Ctx: ctx, Value: v}) != nil {
+				return
+			}
+		}
+	}()
+
+	out, dead, errc := pipeline.StagePoolWithDeadLetters(ctx, input.C, 2, func(_ context.Context, it pipeline.Item[int]) (pipeline.Item[int], error) {
+		r.mu.Lock()
+		r.attempts[it.Value] = append(r.attempts[it.Value], it.Requeues)
+		attempt := len(r.attempts[it.Value])
+		r.mu.Unlock()
+		return it, fail(it.Value, attempt)
+	}, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	givenUp := pipeline.Requeue(ctx, dead, input.C, pipeline.RequeueOptions{MaxRequeues: maxRequeues})
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for it := range out {
+			r.done = append(r.done, it)
+			input.Done()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for dead := range givenUp {
+			r.givenUp = append(r.givenUp, dead)
+			input.Done()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for err := range errc {
+			r.errs = append(r.errs, err)
+		}
+	}()
+	waitCtx, stop := context.WithTimeout(context.Background(), pipelinetest.DefaultTimeout)
+	defer stop()
+	if err := chanutil.WaitContext(waitCtx, &wg); err != nil {
+		t.Fatal("the run did not terminate")
+	}
+	return input
+}
+
+func TestRequeue(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var r requeueRun
+	// 3 is poison, failing every attempt, while 5 fails only its first.
+	input := r.run(t, pipelinetest.Items(8), 3, func(value, attempt int) error {
+		switch {
+		case value == 3:
+			return errPoison
+		case value == 5 && attempt == 1:
+			return errDown
+		}
+		return nil
+	})
+
+	if !input.IsClosed() {
+		t.Error("input still open after the run")
+	}
+	if len(r.errs) > 0 {
+		t.Errorf("errors: %v", r.errs)
+	}
+	if got := r.attempts[3]; !slices.Equal(got, []int{0, 1, 2, 3}) {
+		t.Errorf("poison item attempted with requeues %v, want the first attempt and 3 requeues", got)
+	}
+	if got := r.attempts[5]; !slices.Equal(got, []int{0, 1}) {
+		t.Errorf("transient item attempted with requeues %v, want it to succeed on the first requeue", got)
+	}
+	if got := r.attempts[0]; !slices.Equal(got, []int{0}) {
+		t.Errorf("good item attempted with requeues %v, want once", got)
+	}
+
+	var values []int
+	for _, it := range r.done {
+		values = append(values, it.Value)
+		if want := map[int]int{5: 1}[it.Value]; it.Requeues != want {
+			t.Errorf("%d done after %d requeues, want %d", it.Value, it.Requeues, want)
+		}
+	}
+	slices.Sort(values)
+	if !slices.Equal(values, []int{0, 1, 2, 4, 5, 6, 7}) {
+		t.Errorf("done %v, want every item but the poison one", values)
+	}
+	if len(r.givenUp) != 1 {
+		t.Fatalf("given up on %v, want the poison item alone", r.givenUp)
+	}
+	dead := r.givenUp[0]
+	if dead.Item.Value != 3 || dead.Item.Requeues != 3 || !errors.Is(dead.Err, pipeline.ErrRequeueLimit) || !errors.Is(dead.Err, errPoison) {
+		t.Errorf("given up on %+v: %v, want 3 after 3 requeues and its last failure", dead.Item, dead.Err)
+	}
+}
+
+func TestRequeueDefaultLimit(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var r requeueRun
+	r.run(t, []int{1, 2}, 0, func(int, int) error { return errPoison })
+	if len(r.givenUp) != 2 || len(r.done) != 0 {
+		t.Errorf("given up on %v and done %v, want both given up", r.givenUp, r.done)
+	}
+	for v, requeues := range r.attempts {
+		if !slices.Equal(requeues, []int{0, 1}) {
+			t.Errorf("%d attempted with requeues %v, want a single requeue", v, requeues)
+		}
+	}
+}
+
+func TestRequeueCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	dead := make(chan pipeline.ItemError[pipeline.Item[int]])
+	// Nothing reads into, so the requeued item waits for the cancellation.
+	givenUp := pipeline.Requeue(ctx, dead, make(chan pipeline.Item[int]), pipeline.RequeueOptions{MaxRequeues: 1})
+	dead <- pipeline.ItemError[pipeline.Item[int]]{Item: pipeline.Item[int]{Value: 1}, Err: errDown}
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+	pipelinetest.AssertClosed(t, givenUp, time.Second)
+}
@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"context"
+
+	"channelspractice/chanutil"
+)
+
+// Result carries either a value or the error producing it failed with, along
+// with the item's metadata. Stages of the Result API exchange them on a single
+// channel instead of a value channel and an error channel each.
+type Result[T any] struct {
+	Value T
+	Err   error
+	Meta  Meta
+}
+
+// ToResults turns the two-channel style into a Result channel: every value
+// becomes a successful Result and every error a failed one. A nil errs is
+// allowed. The output is closed once both inputs are closed or ctx is
+// cancelled.
+func ToResults[T any](ctx context.Context, values <-chan T, errs <-chan error, opts ...Option) <-chan Result[T] {
+	o := newOptions(opts)
+	out := make(chan Result[T], o.buffer)
+	go func() {
+		defer close(out)
+		for values != nil || errs != nil {
+			var r Result[T]
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-values:
+				if !ok {
+					values = nil
+					continue
+				}
+				r.Value = v
+			case err, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+				r.Err = err
+			}
+			if chanutil.SendContext(ctx, out, r) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// FromResults is the reverse of ToResults. Unlike a stage of the two-channel
+// style it carries on after a failed Result. Both channels must be consumed.
+func FromResults[T any](ctx context.Context, in <-chan Result[T]) (<-chan T, <-chan error) {
+	out := make(chan T)
+	errc := make(chan error)
+	go func() {
+		defer close(errc)
+		defer close(out)
+		for r := range OrDone(ctx, in) {
+			if r.Err != nil {
+				if chanutil.SendContext(ctx, errc, r.Err) != nil {
+					return
+				}
+				continue
+			}
+			if chanutil.SendContext(ctx, out, r.Value) != nil {
+				return
+			}
+		}
+	}()
+	return out, errc
+}
+
+// StageR calls fn for the value of every successful Result from in. A failure
+// of fn becomes a failed Result holding a *StageError, and failed Results from
+// in are passed on untouched, so one bad item never stops the stream. A panic
+// in fn becomes a failed Result holding a *PanicError, unless panic recovery
+// is disabled. The metadata of every Result is carried over.
+func StageR[T, U any](ctx context.Context, in <-chan Result[T], fn func(context.Context, T) (U, error), opts ...Option) <-chan Result[U] {
+	o := newOptions(opts)
+	name := o.stageName("stage")
+	apply := func(ctx context.Context, r Result[T]) (Result[U], error) {
+		if r.Err != nil {
+			return Result[U]{Err: r.Err, Meta: r.Meta}, nil
+		}
+		value, err := call(ctx, o, name, fn, r.Value)
+		if err != nil {
+			return Result[U]{Err: stageFailure(name, r.Value, err), Meta: r.Meta}, nil
+		}
+		return Result[U]{Value: value, Meta: r.Meta}, nil
+	}
+	// apply turns every failure, panics included, into a Result, so the
+	// error channel only ever gets closed.
+	out, _ := Stage(ctx, in, apply, opts...)
+	return out
+}
+
+// SinkR is the terminal stage of the Result API. It calls onValue for every
+// successful Result and onErr for every failed one. Like Sink, it stops at the
+// first error either callback returns.
+func SinkR[T any](ctx context.Context, in <-chan Result[T], onValue func(context.Context, T) error, onErr func(context.Context, error) error, opts ...Option) (<-chan struct{}, <-chan error) {
+	return Sink(ctx, in, func(ctx context.Context, r Result[T]) error {
+		if r.Err != nil {
+			return onErr(ctx, r.Err)
+		}
+		return onValue(ctx, r.Value)
+	}, opts...)
+}
+
+// CollectResults reads in until it is closed or ctx is cancelled and returns
+// the successful values and the errors separately, each in arrival order.
+func CollectResults[T any](ctx context.Context, in <-chan Result[T]) ([]T, []error) {
+	var values []T
+	var errs []error
+	for r := range OrDone(ctx, in) {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+			continue
+		}
+		values = append(values, r.Value)
+	}
+	return values, errs
+}
@@ -0,0 +1,129 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func failOnSix(_ context.Context, n int) (int, error) {
+	if n == 6 {
+		return 0, fmt.Errorf("number %d is invalid", n)
+	}
+	return n * 2, nil
+}
+
+func TestStageRFailureFlowsToSink(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := pipeline.ToResults(ctx, pipeline.Source(ctx, pipelinetest.Items(11)), nil)
+	out := pipeline.StageR(ctx, in, failOnSix, pipeline.WithName("transform"))
+	var saved []int
+	var failed []error
+	done, errc := pipeline.SinkR(ctx, out, func(_ context.Context, n int) error {
+		saved = append(saved, n)
+		return nil
+	}, func(_ context.Context, err error) error {
+		failed = append(failed, err)
+		return nil
+	})
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) > 0 {
+		t.Fatalf("sink errors: %v", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if want := []int{0, 2, 4, 6, 8, 10, 14, 16, 18, 20}; !slices.Equal(saved, want) {
+		t.Errorf("saved = %v, want %v", saved, want)
+	}
+	var stageErr *pipeline.StageError
+	if len(failed) != 1 || !errors.As(failed[0], &stageErr) || stageErr.Stage != "transform" || stageErr.Item != 6 {
+		t.Errorf("failed = %v, want the transform error for 6", failed)
+	}
+}
+
+func TestStageRPassesFailedResultsOn(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	upstream := errors.New("upstream")
+	in := pipeline.Source(ctx, []pipeline.Result[int]{
+		{Value: 1, Meta: pipeline.Meta{}.With("id", "a")},
+		{Err: upstream, Meta: pipeline.Meta{}.With("id", "b")},
+	})
+	var calls int
+	results := pipelinetest.CollectWithTimeout(t, pipeline.StageR(ctx, in, func(_ context.Context, n int) (int, error) {
+		calls++
+		return n + 1, nil
+	}), time.Second)
+
+	if len(results) != 2 || calls != 1 {
+		t.Fatalf("results = %v after %d calls, want 2 results after 1 call", results, calls)
+	}
+	if results[0].Value != 2 || results[0].Err != nil {
+		t.Errorf("first result = %+v, want value 2", results[0])
+	}
+	if results[1].Err != upstream {
+		t.Errorf("second result error = %v, want it untouched", results[1].Err)
+	}
+	for i, id := range []string{"a", "b"} {
+		if got, _ := results[i].Meta.Get("id"); got != id {
+			t.Errorf("result %d has id %q, want %q", i, got, id)
+		}
+	}
+}
+
+func TestStageRPanicBecomesFailedResult(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := pipeline.ToResults(ctx, pipeline.Source(ctx, []int{1, 2, 3}), nil)
+	values, errs := pipeline.CollectResults(ctx, pipeline.StageR(ctx, in, func(_ context.Context, n int) (int, error) {
+		if n == 2 {
+			panic("boom")
+		}
+		return n, nil
+	}))
+
+	if !slices.Equal(values, []int{1, 3}) {
+		t.Errorf("values = %v, want [1 3]", values)
+	}
+	var panicErr *pipeline.PanicError
+	if len(errs) != 1 || !errors.As(errs[0], &panicErr) || panicErr.Item != 2 {
+		t.Errorf("errors = %v, want one *PanicError for item 2", errs)
+	}
+}
+
+func TestResultsRoundTrip(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	boom := errors.New("boom")
+	errIn := make(chan error, 1)
+	errIn <- boom
+	close(errIn)
+
+	values, errs := pipeline.FromResults(ctx, pipeline.ToResults(ctx, pipeline.Source(ctx, []int{1, 2}), errIn))
+	var gotErrs []error
+	errsDone := make(chan struct{})
+	go func() {
+		defer close(errsDone)
+		gotErrs = pipelinetest.CollectWithTimeout(t, errs, time.Second)
+	}()
+	gotValues := pipelinetest.CollectWithTimeout(t, values, time.Second)
+	<-errsDone
+
+	if !slices.Equal(gotValues, []int{1, 2}) {
+		t.Errorf("values = %v, want [1 2]", gotValues)
+	}
+	if len(gotErrs) != 1 || gotErrs[0] != boom {
+		t.Errorf("errors = %v, want [boom]", gotErrs)
+	}
+}
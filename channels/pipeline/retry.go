@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryOptions controls how Retry repeats a failing call.
+type RetryOptions struct {
+	// MaxAttempts is the total number of calls, including the first one.
+	// Values below 1 are treated as 1.
+	MaxAttempts int
+	// InitialDelay is the wait before the second attempt.
+	InitialDelay time.Duration
+	// Multiplier scales the delay after every attempt. Values below 1 keep
+	// the delay constant.
+	Multiplier float64
+	// MaxDelay caps the delay between attempts. Zero means no cap.
+	MaxDelay time.Duration
+	// Jitter randomises each delay by up to the given fraction, e.g. 0.2
+	// waits anywhere between 80% and 120% of the computed delay.
+	Jitter float64
+	// IsRetryable reports whether an error is worth another attempt. A nil
+	// predicate retries every error.
+	IsRetryable func(error) bool
+	// Clock measures the delays. A nil Clock uses the real one.
+	Clock Clock
+}
+
+// Retry wraps fn so that failing calls are repeated with exponential backoff.
+// Non-retryable errors are returned immediately. Waiting between attempts stops
+// as soon as the item's context is cancelled, in which case ctx.Err() is
+// returned.
+func Retry[T, U any](fn func(context.Context, T) (U, error), opts RetryOptions) func(context.Context, T) (U, error) {
+	if opts.Clock == nil {
+		opts.Clock = realClock{}
+	}
+	return func(ctx context.Context, item T) (U, error) {
+		delay := opts.InitialDelay
+		for attempt := 1; ; attempt++ {
+			result, err := fn(ctx, item)
+			if err == nil {
+				return result, nil
+			}
+			if attempt >= opts.MaxAttempts || (opts.IsRetryable != nil && !opts.IsRetryable(err)) {
+				return result, err
+			}
+
+			timer := opts.Clock.NewTimer(jitter(delay, opts.Jitter))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				var zero U
+				return zero, ctx.Err()
+			case <-timer.C():
+			}
+
+			if opts.Multiplier > 1 {
+				delay = time.Duration(float64(delay) * opts.Multiplier)
+			}
+			if opts.MaxDelay > 0 {
+				delay = min(delay, opts.MaxDelay)
+			}
+		}
+	}
+}
+
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || d <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + fraction*(2*rand.Float64()-1)))
+}
@@ -0,0 +1,132 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// flaky fails its first failures calls with err and records when it was
+// called, on clock if set.
+type flaky struct {
+	failures int
+	err      error
+	clock    *pipelinetest.Clock
+	calls    []time.Time
+}
+
+func (f *flaky) call(_ context.Context, n int) (int, error) {
+	now := time.Now()
+	if f.clock != nil {
+		now = f.clock.Now()
+	}
+	f.calls = append(f.calls, now)
+	if len(f.calls) <= f.failures {
+		return 0, f.err
+	}
+	return n, nil
+}
+
+func TestRetryBackoffProgression(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	clock := pipelinetest.NewClock(time.Unix(0, 0))
+	f := &flaky{failures: 3, err: errors.New("timeout"), clock: clock}
+	fn := pipeline.Retry(f.call, pipeline.RetryOptions{MaxAttempts: 4, InitialDelay: 10 * time.Millisecond, Multiplier: 2, Clock: clock})
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := fn(context.Background(), 7)
+		done <- result{n, err}
+	}()
+	delays := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond}
+	for _, d := range delays {
+		clock.BlockUntil(t, 1)
+		clock.Advance(d)
+	}
+	if r := <-done; r.err != nil || r.n != 7 {
+		t.Fatalf("Retry = %d, %v, want 7 after the fourth attempt", r.n, r.err)
+	}
+	if len(f.calls) != 4 {
+		t.Fatalf("%d calls, want 4", len(f.calls))
+	}
+	for i, want := range delays {
+		if gap := f.calls[i+1].Sub(f.calls[i]); gap != want {
+			t.Errorf("wait before attempt %d = %v, want %v", i+2, gap, want)
+		}
+	}
+}
+
+func TestRetryMaxDelay(t *testing.T) {
+	f := &flaky{failures: 3, err: errors.New("timeout")}
+	fn := pipeline.Retry(f.call, pipeline.RetryOptions{MaxAttempts: 4, InitialDelay: 10 * time.Millisecond, Multiplier: 10, MaxDelay: 20 * time.Millisecond})
+
+	start := time.Now()
+	if _, err := fn(context.Background(), 7); err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	// Uncapped this would wait 10ms, 100ms and 1s.
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("retries took %v, want the delay capped at 20ms", elapsed)
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	timeout := errors.New("timeout")
+	f := &flaky{failures: 10, err: timeout}
+	fn := pipeline.Retry(f.call, pipeline.RetryOptions{MaxAttempts: 3, InitialDelay: time.Millisecond})
+
+	if _, err := fn(context.Background(), 7); !errors.Is(err, timeout) {
+		t.Errorf("Retry = %v, want the last error", err)
+	}
+	if len(f.calls) != 3 {
+		t.Errorf("%d calls, want 3", len(f.calls))
+	}
+}
+
+func TestRetryNonRetryableIsImmediate(t *testing.T) {
+	invalid := errors.New("invalid")
+	f := &flaky{failures: 10, err: invalid}
+	fn := pipeline.Retry(f.call, pipeline.RetryOptions{
+		MaxAttempts:  5,
+		InitialDelay: time.Hour,
+		IsRetryable:  func(err error) bool { return !errors.Is(err, invalid) },
+	})
+
+	if _, err := fn(context.Background(), 7); !errors.Is(err, invalid) {
+		t.Errorf("Retry = %v, want the invalid error", err)
+	}
+	if len(f.calls) != 1 {
+		t.Errorf("%d calls, want 1", len(f.calls))
+	}
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &flaky{failures: 10, err: errors.New("timeout")}
+	fn := pipeline.Retry(f.call, pipeline.RetryOptions{MaxAttempts: 5, InitialDelay: time.Hour})
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := fn(ctx, 7)
+		result <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Retry = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Retry kept waiting after cancellation")
+	}
+}
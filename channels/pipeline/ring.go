@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// DroppedStats reports what a RingBuffer or Bulkhead did with its input so
+// far.
+type DroppedStats struct {
+	Received int64
+	Dropped  int64
+}
+
+// OverflowPolicy decides what a Bulkhead does with an item that arrives while
+// its buffer is full.
+type OverflowPolicy int
+
+const (
+	// Block stops reading from the input until there is room again, which
+	// passes the back-pressure on upstream.
+	Block OverflowPolicy = iota
+	// DropOldest drops the oldest buffered item to make room.
+	DropOldest
+	// DropNewest drops the item that just arrived.
+	DropNewest
+)
+
+// RingBuffer reads from in as fast as in delivers, keeping at most capacity
+// items for the consumer of the returned channel. When it is full the oldest
+// item is dropped to make room, so a slow consumer never blocks the producer.
+// Items left when in is closed are still handed over; the output is closed
+// after that or as soon as ctx is cancelled.
+func RingBuffer[T any](ctx context.Context, in <-chan T, capacity int) (<-chan T, func() DroppedStats) {
+	return Bulkhead(ctx, in, capacity, DropOldest)
+}
+
+// Bulkhead decouples its consumer from in with a buffer of capacity items, and
+// policy decides what happens once the buffer is full. Placing one with a
+// dropping policy on each branch of a Tee keeps a stalled branch from holding
+// up the others: the tee can always hand its item over, and the stalled
+// branch loses items instead, which the returned function counts. Items left
+// when in is closed are still handed over; the output is closed after that or
+// as soon as ctx is cancelled.
+func Bulkhead[T any](ctx context.Context, in <-chan T, capacity int, policy OverflowPolicy) (<-chan T, func() DroppedStats) {
+	capacity = max(capacity, 1)
+	out := make(chan T)
+	var received, dropped atomic.Int64
+
+	go func() {
+		defer close(out)
+		ring := make([]T, capacity)
+		head, size := 0, 0
+		for in != nil || size > 0 {
+			// A nil channel blocks forever, which disables the send case
+			// while the ring is empty and, under Block, the receive case
+			// while it is full.
+			var send chan<- T
+			var next T
+			if size > 0 {
+				send = out
+				next = ring[head]
+			}
+			recv := in
+			if policy == Block && size == capacity {
+				recv = nil
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-recv:
+				if !ok {
+					in = nil
+					continue
+				}
+				received.Add(1)
+				if size == capacity {
+					dropped.Add(1)
+					if policy == DropNewest {
+						continue
+					}
+					head = (head + 1) % capacity
+					size--
+				}
+				ring[(head+size)%capacity] = item
+				size++
+			case send <- next:
+				var zero T
+				ring[head] = zero
+				head = (head + 1) % capacity
+				size--
+			}
+		}
+	}()
+
+	stats := func() DroppedStats {
+		return DroppedStats{Received: received.Load(), Dropped: dropped.Load()}
+	}
+	return out, stats
+}
@@ -0,0 +1,149 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// sendAll sends items on in and closes it. It fails the test if a send
+// blocks.
+func sendAll(t *testing.T, in chan<- int, items []int) {
+	t.Helper()
+	defer close(in)
+	for _, item := range items {
+		select {
+		case in <- item:
+		case <-time.After(time.Second):
+			t.Fatalf("producer blocked on item %d", item)
+		}
+	}
+}
+
+func TestRingBufferDropsOldest(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out, stats := pipeline.RingBuffer(ctx, in, 10)
+
+	sendAll(t, in, pipelinetest.Items(100))
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if want := pipelinetest.Items(100)[90:]; !slices.Equal(got, want) {
+		t.Errorf("got %v, want the newest 10 in order", got)
+	}
+	if s := stats(); s.Received != 100 || s.Dropped != 90 {
+		t.Errorf("stats %+v, want 100 received and 90 dropped", s)
+	}
+}
+
+func TestRingBufferSlowConsumer(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, stats := pipeline.RingBuffer(ctx, pipeline.Source(ctx, pipelinetest.Items(1000)), 8)
+
+	var got []int
+	for v := range out {
+		got = append(got, v)
+		time.Sleep(100 * time.Microsecond)
+	}
+	if !slices.IsSorted(got) || len(slices.Compact(slices.Clone(got))) != len(got) {
+		t.Errorf("survivors %v are not in FIFO order", got)
+	}
+	if s := stats(); s.Received != 1000 || int(s.Dropped)+len(got) != 1000 {
+		t.Errorf("stats %+v with %d delivered, want them to add up to 1000", s, len(got))
+	}
+}
+
+func TestRingBufferConsumerLeaves(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out, _ := pipeline.RingBuffer(ctx, pipeline.Source(ctx, pipelinetest.Items(1000)), 8)
+	<-out
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+}
+
+func TestBulkheadDropNewest(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out, stats := pipeline.Bulkhead(ctx, in, 10, pipeline.DropNewest)
+
+	sendAll(t, in, pipelinetest.Items(100))
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, pipelinetest.Items(10)) {
+		t.Errorf("got %v, want the oldest 10", got)
+	}
+	if s := stats(); s.Dropped != 90 {
+		t.Errorf("dropped %d, want 90", s.Dropped)
+	}
+}
+
+func TestBulkheadBlock(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out, stats := pipeline.Bulkhead(ctx, in, 2, pipeline.Block)
+	in <- 1
+	in <- 2
+	select {
+	case in <- 3:
+		t.Fatal("a full bulkhead took another item")
+	case <-time.After(10 * time.Millisecond):
+	}
+	<-out
+	in <- 3
+	close(in)
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{2, 3}) {
+		t.Errorf("got %v, want [2 3]", got)
+	}
+	if s := stats(); s.Dropped != 0 {
+		t.Errorf("dropped %d, want none", s.Dropped)
+	}
+}
+
+func TestBulkheadDropOldest(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out, stats := pipeline.Bulkhead(ctx, in, 10, pipeline.DropOldest)
+
+	sendAll(t, in, pipelinetest.Items(100))
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, pipelinetest.Items(100)[90:]) {
+		t.Errorf("got %v, want the newest 10", got)
+	}
+	if s := stats(); s.Received != 100 || s.Dropped != 90 {
+		t.Errorf("received %d and dropped %d, want 100 and 90", s.Received, s.Dropped)
+	}
+}
+
+func TestBulkheadIsolatesTeeBranches(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	save, audit := pipeline.Tee(ctx, pipeline.Source(ctx, pipelinetest.Items(1000)))
+	save, _ = pipeline.Bulkhead(ctx, save, 16, pipeline.DropNewest)
+	audit, auditStats := pipeline.Bulkhead(ctx, audit, 16, pipeline.DropNewest)
+
+	// Nobody reads the audit branch, as if its sink hung; save still gets
+	// every item and the audit bulkhead drops what does not fit.
+	if got := pipelinetest.CollectWithTimeout(t, save, time.Second); len(got) != 1000 {
+		t.Fatalf("save got %d items with audit hung, want 1000", len(got))
+	}
+	kept := pipelinetest.CollectWithTimeout(t, audit, time.Second)
+	// The bulkhead may hold one item on top of its buffer.
+	if len(kept) < 16 || len(kept) > 17 || !slices.Equal(kept, pipelinetest.Items(len(kept))) {
+		t.Errorf("audit kept %v, want the first 16", kept)
+	}
+	if s := auditStats(); s.Dropped != int64(1000-len(kept)) {
+		t.Errorf("audit dropped %d items, want %d", s.Dropped, 1000-len(kept))
+	}
+}
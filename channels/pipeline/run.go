@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// RunOptions configures Run.
+type RunOptions struct {
+	// DrainTimeout bounds how long in-flight items may drain after ctx is
+	// cancelled before the pipeline is aborted. Zero aborts immediately.
+	DrainTimeout time.Duration
+	// SinkGrace bounds how long the sinks of a Pipeline may keep going on
+	// an abort once the stages feeding them are stopped, to finish what is
+	// still on its way to them, before they are cancelled as well. Zero
+	// cancels them right away.
+	SinkGrace time.Duration
+}
+
+// BuildFunc wires up a pipeline and returns a channel that is closed when its
+// sinks are done together with its merged error channel. Sources must be
+// started with produce, which is cancelled first on shutdown so that no new
+// items enter the pipeline. Every other primitive uses abort, which is only
+// cancelled once draining is over.
+type BuildFunc func(produce, abort context.Context) (<-chan struct{}, <-chan error)
+
+// orderedBuild is a BuildFunc that also returns the stages of the pipeline
+// for an ordered shutdown, or nil if it does not know them.
+type orderedBuild func(produce, abort context.Context) (<-chan struct{}, <-chan error, *stageErrors)
+
+// unordered is build without stages to stop in order.
+func unordered(build BuildFunc) orderedBuild {
+	return func(produce, abort context.Context) (<-chan struct{}, <-chan error, *stageErrors) {
+		done, errs := build(produce, abort)
+		return done, errs, nil
+	}
+}
+
+// Run builds the pipeline and waits for it to finish. When ctx is cancelled
+// the sources are stopped and the items already in the pipeline get up to
+// DrainTimeout to reach the sinks; after that everything is aborted and
+// ErrDrainTimeout is returned. The first error from the pipeline aborts it and
+// is returned. A drain that completes in time returns ctx.Err(). Run only
+// returns once every stage feeding the error channel has exited, and the
+// sinks being done is a success only once that channel is closed without an
+// error, so a stage failing just before the sinks finish still fails the run.
+//
+// An error Run returns wraps exactly one of ErrStageFailed, for the first
+// error, or ErrThresholdExceeded if that is what it is; ErrDrainTimeout; and
+// ErrSignalled, for a drain of a ctx cancelled by ShutdownOnSignal, or
+// ErrCancelled, for any other. It still wraps the error it was returned for,
+// so errors.As finds the StageError of a failure and errors.Is sees
+// context.Canceled after a drain. IsFatal, IsRetryable and StageOf tell them
+// apart.
+//
+// Stages can tell why they were stopped from CauseOf: the pipeline error that
+// aborted them, ErrDrainTimeout, or the cause of ctx, e.g. ErrSignalled, for
+// the sources stopped at the start of a drain.
+func Run(ctx context.Context, opts RunOptions, build BuildFunc) error {
+	return run(ctx, opts, unordered(build))
+}
+
+// run is Run stopping the stages build returns, if any, in order on an
+// abort.
+func run(ctx context.Context, opts RunOptions, build orderedBuild) error {
+	abort, abortCancel := context.WithCancelCause(context.Background())
+	defer abortCancel(nil)
+	produce, produceCancel := context.WithCancelCause(abort)
+	defer produceCancel(nil)
+
+	done, errs, stages := build(produce, abort)
+
+	var drainTimer <-chan time.Time
+	stop := ctx.Done()
+	sinksDone := done
+	for {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				if sinksDone == nil {
+					teardown(abortCancel, nil, done, nil)
+					return stopped(ctx)
+				}
+				continue
+			}
+			shutdown(stages, opts.SinkGrace, abortCancel, err, done, errs)
+			return failed(err)
+		case <-sinksDone:
+			// A stage can fail just before the sinks finish, and its error
+			// still be on its way: done means success only once errs is
+			// closed.
+			sinksDone = nil
+			if errs == nil {
+				teardown(abortCancel, nil, done, nil)
+				return stopped(ctx)
+			}
+		case <-stop:
+			stop = nil
+			produceCancel(context.Cause(ctx))
+			timer := time.NewTimer(opts.DrainTimeout)
+			defer timer.Stop()
+			drainTimer = timer.C
+		case <-drainTimer:
+			shutdown(stages, opts.SinkGrace, abortCancel, ErrDrainTimeout, done, errs)
+			return ErrDrainTimeout
+		}
+	}
+}
+
+// Wait blocks until a pipeline wired up by hand is over. It returns the
+// first error from errs as soon as it arrives, the cause of ctx if ctx is
+// cancelled first, or nil once done is closed and errs has been closed
+// without an error. errs closing before done does not end the wait, and
+// after done is closed Wait still reads errs until it is closed, so an error
+// sent by a stage just before the sinks finished is not lost. Wait cancels
+// nothing: on an error the caller cancels the stages, Run does that for a
+// BuildFunc.
+func Wait(ctx context.Context, done <-chan struct{}, errs <-chan error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				if done == nil {
+					return nil
+				}
+				continue
+			}
+			return err
+		case <-done:
+			done = nil
+			if errs == nil {
+				return nil
+			}
+		}
+	}
+}
+
+// WaitAll is Wait for several error channels, which it merges with ctx. Once
+// it returned ctx must be cancelled to stop the merging.
+func WaitAll(ctx context.Context, done <-chan struct{}, errs ...<-chan error) error {
+	return Wait(ctx, done, Merge(ctx, errs...))
+}
+
+// teardown aborts whatever is still running with cause and waits until the
+// sinks are done and every stage has closed its error channel, so nothing
+// from this run outlives Run.
+func teardown(abort context.CancelCauseFunc, cause error, done <-chan struct{}, errs <-chan error) {
+	abort(cause)
+	<-done
+	if errs != nil {
+		for range errs {
+		}
+	}
+}
+
+// shutdown stops stages in order with cause, as stageErrors.stop does, while
+// discarding what comes on errs, and then tears down the rest of the run with
+// teardown.
+func shutdown(stages *stageErrors, grace time.Duration, abort context.CancelCauseFunc, cause error, done <-chan struct{}, errs <-chan error) {
+	if stages == nil {
+		teardown(abort, cause, done, errs)
+		return
+	}
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		if errs != nil {
+			for range errs {
+			}
+		}
+	}()
+	stages.stop(cause, grace)
+	teardown(abort, cause, done, nil)
+	<-drained
+}
+
+// CauseOf reports why ctx was cancelled: the cause it was cancelled with, such
+// as the error that aborted a pipeline or ErrSignalled, or context.Canceled if
+// none was given. It returns nil while ctx is not done.
+func CauseOf(ctx context.Context) error {
+	return context.Cause(ctx)
+}
@@ -0,0 +1,387 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestRunCauseIsTheStageError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var abortCtx context.Context
+	err := pipeline.Run(context.Background(), pipeline.RunOptions{}, func(produce, abort context.Context) (<-chan struct{}, <-chan error) {
+		abortCtx = abort
+		nums := pipeline.Source(produce, pipelinetest.Items(11))
+		doubled, transformErrs := pipeline.Stage(abort, nums, failOnSix, pipeline.WithName("transform"), pipeline.WithBuffer(11))
+		// save holds on to the first item until the pipeline is aborted, so
+		// it cannot finish before the error is read; the buffer lets
+		// transform reach 6 meanwhile.
+		done, saveErrs := pipeline.Sink(abort, doubled, func(context.Context, int) error {
+			<-abort.Done()
+			return nil
+		})
+		return done, pipeline.Merge(abort, transformErrs, saveErrs)
+	})
+
+	var stageErr *pipeline.StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != "transform" || stageErr.Item != 6 {
+		t.Fatalf("Run = %v, want the transform error for 6", err)
+	}
+	if cause := pipeline.CauseOf(abortCtx); cause != stageErr {
+		t.Errorf("stages were cancelled with %v, want %v", cause, stageErr)
+	}
+}
+
+// lastFails is transform failing on the last of n items, slightly after it
+// got it, so that an instant save has long finished the others by then.
+func lastFails(n int) func(context.Context, int) (int, error) {
+	return func(_ context.Context, i int) (int, error) {
+		if i == n-1 {
+			time.Sleep(50 * time.Microsecond)
+			return 0, errBadRecord
+		}
+		return i, nil
+	}
+}
+
+// TestRunReportsAFailureRacingDone runs pipelines whose sinks are done right
+// after the error is sent, so that done and the error race each other.
+func TestRunReportsAFailureRacingDone(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	runs := map[string]func() error{
+		"Run": func() error {
+			return pipeline.Run(context.Background(), pipeline.RunOptions{}, func(produce, abort context.Context) (<-chan struct{}, <-chan error) {
+				nums := pipeline.Source(produce, pipelinetest.Items(3))
+				checked, transformErrs := pipeline.Stage(abort, nums, lastFails(3))
+				done, saveErrs := pipeline.Sink(abort, checked, saveAll)
+				return done, pipeline.Merge(abort, transformErrs, saveErrs)
+			})
+		},
+		"Pipeline.Run": func() error {
+			_, err := pipeline.New(pipelinetest.Items(3)).Then(lastFails(3)).Sink(saveAll).Run(context.Background())
+			return err
+		},
+		"Pipeline.RunWithOptions": func() error {
+			_, err := pipeline.New(pipelinetest.Items(3)).Then(lastFails(3)).Sink(saveAll).
+				RunWithOptions(context.Background(), pipeline.RunOptions{})
+			return err
+		},
+		"OnError": func() error {
+			_, err := pipeline.New(pipelinetest.Items(3)).Then(lastFails(3)).Sink(saveAll).
+				OnError(func(err error) error { return err }).
+				Run(context.Background())
+			return err
+		},
+	}
+	for name, run := range runs {
+		t.Run(name, func(t *testing.T) {
+			for i := range 300 {
+				if err := run(); !errors.Is(err, errBadRecord) || !pipeline.IsFatal(err) {
+					t.Fatalf("run %d = %v, want the failure of 2", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunCauseIsTheSignal(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	sigs := make(chan os.Signal, 1)
+	forced := make(chan struct{})
+	ctx, stop := pipeline.ShutdownOnSignal(context.Background(), sigs, func() { close(forced) })
+	defer stop()
+
+	var produceCtx context.Context
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- pipeline.Run(ctx, pipeline.RunOptions{DrainTimeout: time.Second}, func(produce, abort context.Context) (<-chan struct{}, <-chan error) {
+			produceCtx = produce
+			defer close(started)
+			ticks := pipeline.GenerateFunc(produce, time.Millisecond, func(i int) (int, bool) { return i, true })
+			return pipeline.Sink(abort, ticks, func(context.Context, int) error { return nil })
+		})
+	}()
+	<-started
+	sigs <- syscall.SIGINT
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run = %v, want context.Canceled after a drain", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after the signal")
+	}
+	if cause := pipeline.CauseOf(ctx); cause != pipeline.ErrSignalled {
+		t.Errorf("CauseOf(ctx) = %v, want ErrSignalled", cause)
+	}
+	if cause := pipeline.CauseOf(produceCtx); cause != pipeline.ErrSignalled {
+		t.Errorf("sources were stopped with %v, want ErrSignalled", cause)
+	}
+
+	sigs <- syscall.SIGINT
+	pipelinetest.AssertClosed(t, forced, time.Second)
+}
+
+func TestCauseOfLiveContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := pipeline.CauseOf(ctx); err != nil {
+		t.Errorf("CauseOf a live context = %v, want nil", err)
+	}
+	cancel()
+	if err := pipeline.CauseOf(ctx); err != context.Canceled {
+		t.Errorf("CauseOf a plainly cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+// drainPipeline is transform and a slow save with room for five items in
+// between. It counts the items each of them handled and closes saving once
+// the first one is saved and transform has filled the room behind it.
+func drainPipeline(transformed, saved *atomic.Int64, saving chan<- struct{}) pipeline.BuildFunc {
+	return func(produce, abort context.Context) (<-chan struct{}, <-chan error) {
+		nums := pipeline.Source(produce, pipelinetest.Items(100))
+		doubled, transformErrs := pipeline.Stage(abort, nums, func(_ context.Context, n int) (int, error) {
+			transformed.Add(1)
+			return n * 2, nil
+		}, pipeline.WithBuffer(5))
+		done, saveErrs := pipeline.Sink(abort, doubled, func(context.Context, int) error {
+			if saved.Add(1) == 1 {
+				for transformed.Load() < 5 {
+					time.Sleep(time.Millisecond)
+				}
+				close(saving)
+			}
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		})
+		return done, pipeline.Merge(abort, transformErrs, saveErrs)
+	}
+}
+
+func TestRunDrainSavesInFlightItems(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	var transformed, saved atomic.Int64
+	saving := make(chan struct{})
+	go func() {
+		<-saving
+		cancel()
+	}()
+
+	err := pipeline.Run(ctx, pipeline.RunOptions{DrainTimeout: 5 * time.Second}, drainPipeline(&transformed, &saved, saving))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want context.Canceled after a drain", err)
+	}
+	if saved.Load() != transformed.Load() || saved.Load() == 100 {
+		t.Errorf("saved %d of %d transformed items, want all of them and fewer than 100", saved.Load(), transformed.Load())
+	}
+}
+
+func TestRunTinyDrainTimeoutDropsItems(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	var transformed, saved atomic.Int64
+	saving := make(chan struct{})
+	go func() {
+		<-saving
+		cancel()
+	}()
+
+	err := pipeline.Run(ctx, pipeline.RunOptions{DrainTimeout: time.Nanosecond}, drainPipeline(&transformed, &saved, saving))
+	if !errors.Is(err, pipeline.ErrDrainTimeout) {
+		t.Fatalf("Run = %v, want ErrDrainTimeout", err)
+	}
+	if saved.Load() >= transformed.Load() {
+		t.Errorf("saved %d of %d transformed items, want some dropped", saved.Load(), transformed.Load())
+	}
+}
+
+var (
+	errParse    = errors.New("cannot parse")
+	errValidate = errors.New("invalid")
+	errSave     = errors.New("cannot save")
+)
+
+func TestRunCollectingErrorsKeepsEveryError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	skip := pipeline.WithErrorPolicy(pipeline.SkipAndReport)
+	err := pipeline.New(pipelinetest.Items(10)).
+		Then(func(_ context.Context, n int) (int, error) {
+			if n == 1 {
+				return 0, errParse
+			}
+			return n, nil
+		}, pipeline.WithName("parse"), skip).
+		Then(func(_ context.Context, n int) (int, error) {
+			if n == 2 {
+				return 0, errValidate
+			}
+			return n, nil
+		}, pipeline.WithName("validate"), skip).
+		Sink(func(_ context.Context, n int) error {
+			if n == 9 {
+				return errSave
+			}
+			return nil
+		}, pipeline.WithName("save")).
+		RunCollectingErrors(ctx, 0)
+
+	for _, tc := range []struct {
+		stage string
+		err   error
+	}{{"parse", errParse}, {"validate", errValidate}, {"save", errSave}} {
+		if !errors.Is(err, tc.err) {
+			t.Errorf("error %v does not hold %v", err, tc.err)
+		}
+	}
+	// errors.As only finds the first StageError, so look at them one by one.
+	var stages []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var stageErr *pipeline.StageError
+		if !errors.As(e, &stageErr) {
+			t.Fatalf("%v is not a StageError", e)
+		}
+		stages = append(stages, stageErr.Stage)
+	}
+	if slices.Sort(stages); !slices.Equal(stages, []string{"parse", "save", "validate"}) {
+		t.Errorf("errors from %v, want parse, save and validate", stages)
+	}
+}
+
+func TestRunCollectingErrorsCaps(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := pipeline.New(pipelinetest.Items(1000)).
+		Then(func(context.Context, int) (int, error) { return 0, errParse }, pipeline.WithErrorPolicy(pipeline.SkipAndReport)).
+		Sink(func(context.Context, int) error { return nil }).
+		RunCollectingErrors(ctx, 10)
+
+	errs := err.(interface{ Unwrap() []error }).Unwrap()
+	if len(errs) != 11 {
+		t.Fatalf("got %d errors, want 10 and the rest counted", len(errs))
+	}
+	for _, e := range errs[:10] {
+		if !errors.Is(e, errParse) {
+			t.Errorf("error %v, want errParse", e)
+		}
+	}
+	if got := errs[10].Error(); got != "and 990 more errors" {
+		t.Errorf("last error %q, want the count of the rest", got)
+	}
+}
+
+func TestRunCollectingErrorsClean(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var saved atomic.Int64
+	err := pipeline.New(pipelinetest.Items(100)).
+		Then(double).
+		Sink(func(context.Context, int) error {
+			saved.Add(1)
+			return nil
+		}).
+		RunCollectingErrors(context.Background(), 10)
+	if err != nil || saved.Load() != 100 {
+		t.Errorf("RunCollectingErrors = %v after %d items, want nil after 100", err, saved.Load())
+	}
+}
+
+func TestWait(t *testing.T) {
+	errCause := errors.New("shutting down")
+	for _, tc := range []struct {
+		name string
+		// finish ends the wait through done, errs or cancel.
+		finish func(done chan struct{}, errs chan error, cancel context.CancelCauseFunc)
+		want   error
+	}{
+		{"done", func(done chan struct{}, errs chan error, _ context.CancelCauseFunc) {
+			close(errs)
+			close(done)
+		}, nil},
+		{"error", func(_ chan struct{}, errs chan error, _ context.CancelCauseFunc) {
+			errs <- errSave
+		}, errSave},
+		{"cancelled", func(_ chan struct{}, _ chan error, cancel context.CancelCauseFunc) {
+			cancel(errCause)
+		}, errCause},
+		{"error after done", func(done chan struct{}, errs chan error, _ context.CancelCauseFunc) {
+			close(done)
+			errs <- errSave
+		}, errSave},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			done := make(chan struct{})
+			errs := make(chan error)
+
+			result := make(chan error, 1)
+			go func() { result <- pipeline.Wait(ctx, done, errs) }()
+			tc.finish(done, errs, cancel)
+			select {
+			case err := <-result:
+				if err != tc.want {
+					t.Errorf("Wait = %v, want %v", err, tc.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Wait did not return")
+			}
+		})
+	}
+}
+
+func TestWaitErrorsClosedBeforeDone(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	done := make(chan struct{})
+	errs := make(chan error)
+	close(errs)
+
+	result := make(chan error, 1)
+	go func() { result <- pipeline.Wait(context.Background(), done, errs) }()
+	select {
+	case err := <-result:
+		t.Fatalf("Wait = %v before done was closed", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(done)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Wait = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return once done was closed")
+	}
+}
+
+func TestWaitAll(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nums := pipeline.Source(ctx, pipelinetest.Items(11))
+	doubled, transformErrs := pipeline.Stage(ctx, nums, failOnSix, pipeline.WithName("transform"))
+	done, saveErrs := pipeline.Sink(ctx, doubled, func(context.Context, int) error { return nil })
+	err := pipeline.WaitAll(ctx, done, transformErrs, saveErrs)
+	cancel()
+
+	var stageErr *pipeline.StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != "transform" || stageErr.Item != 6 {
+		t.Errorf("WaitAll = %v, want the transform error for 6", err)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+}
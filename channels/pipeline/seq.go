@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"context"
+	"iter"
+
+	"channelspractice/chanutil"
+)
+
+// FromSeq emits the values of seq until it is exhausted or ctx is cancelled.
+// Once ctx is cancelled seq's yield returns false, so seq is never asked for
+// another value.
+func FromSeq[T any](ctx context.Context, seq iter.Seq[T], opts ...Option) <-chan T {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	unregister := o.register("source")
+	name := o.stageName("source")
+	o.logStart(ctx, name)
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(out)
+		for item := range seq {
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// FromSeq2 is FromSeq for sequences that can fail. The first non-nil error
+// stops the source and is sent on the error channel; its value is dropped.
+func FromSeq2[T any](ctx context.Context, seq iter.Seq2[T, error], opts ...Option) (<-chan T, <-chan error) {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	errc := make(chan error, 1)
+	unregister := o.register("source")
+	name := o.stageName("source")
+	o.logStart(ctx, name)
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(out)
+		for item, err := range seq {
+			if err != nil {
+				errc <- &StageError{Stage: name, Err: err}
+				return
+			}
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out, errc
+}
+
+// ToSeq returns a sequence over the values read from ch that ends when ch is
+// closed or ctx is cancelled. Breaking out of the range loop early leaves the
+// sender of ch blocked; cancel ctx afterwards to release it.
+func ToSeq[T any](ctx context.Context, ch <-chan T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-ch:
+				if !ok || !yield(item) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// counting is an endless sequence of 0, 1, 2, ... that counts the values it
+// was asked for.
+func counting(asked *atomic.Int64) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for i := 0; ; i++ {
+			asked.Add(1)
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
+
+func TestFromSeqValues(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := pipelinetest.CollectWithTimeout(t, pipeline.FromSeq(ctx, slices.Values([]int{1, 2, 3})), time.Second)
+	if !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("got %v, want [1 2 3]", got)
+	}
+}
+
+func TestFromSeqStopsAskingOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	var asked atomic.Int64
+	out := pipeline.FromSeq(ctx, counting(&asked))
+
+	for range 3 {
+		<-out
+	}
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	// Three values were read; the source may have held a fourth and asked
+	// for a fifth when it saw the cancellation, but never more.
+	if n := asked.Load(); n > 5 {
+		t.Errorf("seq asked for %d values, want the source to stop", n)
+	}
+}
+
+func TestFromSeq2Error(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broken := errors.New("broken")
+	seq := func(yield func(int, error) bool) {
+		_ = yield(1, nil) && yield(2, nil) && yield(0, broken) && yield(3, nil)
+	}
+	out, errc := pipeline.FromSeq2(ctx, seq)
+
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	if !slices.Equal(got, []int{1, 2}) || len(errs) != 1 || !errors.Is(errs[0], broken) {
+		t.Errorf("got %v and errors %v, want [1 2] and broken", got, errs)
+	}
+}
+
+func TestToSeqEarlyBreak(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	// The Source stays blocked on its next send after the break until
+	// cancel releases it.
+	defer cancel()
+	var got []int
+	for n := range pipeline.ToSeq(ctx, pipeline.Source(ctx, pipelinetest.Items(100))) {
+		if n == 3 {
+			break
+		}
+		got = append(got, n)
+	}
+	if !slices.Equal(got, []int{0, 1, 2}) {
+		t.Errorf("got %v, want [0 1 2]", got)
+	}
+}
+
+func TestToSeqCancelMidIteration(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stalled := make(chan int, 2)
+	stalled <- 1
+	stalled <- 2
+	var got []int
+	for n := range pipeline.ToSeq(ctx, stalled) {
+		got = append(got, n)
+		if n == 2 {
+			// Nothing more is coming and stalled is never closed.
+			cancel()
+		}
+	}
+	if !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("got %v, want [1 2]", got)
+	}
+}
@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"sync"
+
+	"channelspractice/chanutil"
+)
+
+// ShardBy routes every item from in to one of shards outputs by the hash of
+// keyFn(item), so all items with the same key go to the same shard in the
+// order they arrived. A shard that is not read holds up the others until ctx
+// is cancelled, so every output must be consumed.
+func ShardBy[T any, K comparable](ctx context.Context, in <-chan T, keyFn func(T) K, shards int, opts ...Option) []<-chan T {
+	o := newOptions(opts)
+	shards = max(shards, 1)
+	outs := make([]chan T, shards)
+	result := make([]<-chan T, shards)
+	for i := range outs {
+		outs[i] = make(chan T, o.buffer)
+		result[i] = outs[i]
+	}
+
+	seed := maphash.MakeSeed()
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+		for item := range OrDone(ctx, in) {
+			out := outs[maphash.Comparable(seed, keyFn(item))%uint64(shards)]
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return result
+}
+
+// StageSharded runs fn on items from in with one Stage per shard of ShardBy
+// and merges their results. Unlike StagePool it keeps the order of the items
+// that share a key, while items with different keys may overtake each other.
+// Options apply to every shard, WithMaxErrors counting per shard. An error
+// that stops a shard cancels all of them, as in StagePool; errors that
+// SkipAndReport skips are passed on and the shards carry on.
+func StageSharded[T any, K comparable, U any](ctx context.Context, in <-chan T, keyFn func(T) K, shards int, fn func(context.Context, T) (U, error), opts ...Option) (<-chan U, <-chan error) {
+	o := newOptions(opts)
+	shardCtx, cancel := context.WithCancel(ctx)
+	parts := ShardBy(shardCtx, in, keyFn, shards, opts...)
+	outs := make([]<-chan U, len(parts))
+	errcs := make([]<-chan error, len(parts))
+	for i, part := range parts {
+		outs[i], errcs[i] = Stage(shardCtx, part, fn, opts...)
+	}
+
+	errc := make(chan error)
+	var wg sync.WaitGroup
+	wg.Add(len(errcs))
+	for _, shardErrc := range errcs {
+		go func() {
+			defer wg.Done()
+			// Shards close their error channel once they are done, so
+			// draining them needs no context; every error is delivered.
+			for err := range shardErrc {
+				if o.errorPolicy != SkipAndReport || errors.Is(err, ErrThresholdExceeded) || isPanic(err) {
+					cancel()
+				}
+				errc <- err
+			}
+		}()
+	}
+	go func() {
+		defer cancel()
+		defer close(errc)
+		wg.Wait()
+	}()
+	// A shard closes its output before its error channel, so by the time
+	// cancel runs above only the merge may still hold results, and it must
+	// not drop them.
+	return Merge(ctx, outs...), errc
+}
@@ -0,0 +1,139 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+type event struct {
+	User int
+	Seq  int
+}
+
+func eventUser(e event) int { return e.User }
+
+// events returns n events spread round-robin over users, numbered per user.
+func events(n, users int) []event {
+	evs := make([]event, n)
+	for i := range evs {
+		evs[i] = event{User: i % users, Seq: i / users}
+	}
+	return evs
+}
+
+func TestShardBySameKeySameShard(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shards := pipeline.ShardBy(ctx, pipeline.Source(ctx, events(1000, 10)), eventUser, 4)
+	got := consumeShards(shards)
+
+	shardOf := make(map[int]int)
+	for i, evs := range got {
+		for _, e := range evs {
+			if s, ok := shardOf[e.User]; ok && s != i {
+				t.Fatalf("user %d went to shards %d and %d", e.User, s, i)
+			}
+			shardOf[e.User] = i
+		}
+	}
+	if len(shardOf) != 10 {
+		t.Errorf("saw %d users, want 10", len(shardOf))
+	}
+}
+
+func consumeShards(shards []<-chan event) [][]event {
+	got := make([][]event, len(shards))
+	var wg sync.WaitGroup
+	wg.Add(len(shards))
+	for i, shard := range shards {
+		go func() {
+			defer wg.Done()
+			for e := range shard {
+				got[i] = append(got[i], e)
+			}
+		}()
+	}
+	wg.Wait()
+	return got
+}
+
+func TestStageShardedKeepsPerKeyOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Some users take longer, so the shards run at different speeds.
+	work := func(_ context.Context, e event) (event, error) {
+		if e.User%7 == 0 {
+			time.Sleep(10 * time.Microsecond)
+		}
+		return e, nil
+	}
+	out, errc := pipeline.StageSharded(ctx, pipeline.Source(ctx, events(10_000, 100)), eventUser, 8, work)
+	got, errs := collectBoth(t, out, errc)
+	if len(errs) != 0 {
+		t.Fatalf("errors = %v", errs)
+	}
+
+	if len(got) != 10_000 {
+		t.Fatalf("got %d events, want 10000", len(got))
+	}
+	next := make(map[int]int)
+	for _, e := range got {
+		if e.Seq != next[e.User] {
+			t.Fatalf("user %d: got event %d, want %d", e.User, e.Seq, next[e.User])
+		}
+		next[e.User]++
+	}
+}
+
+func TestStageShardedFailFastCancelsAllShards(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	work := func(_ context.Context, e event) (event, error) {
+		if e.User == 3 && e.Seq == 5 {
+			return event{}, errors.New("bad event")
+		}
+		return e, nil
+	}
+	out, errc := pipeline.StageSharded(ctx, pipeline.Source(ctx, events(10_000, 100)), eventUser, 8, work, pipeline.WithName("events"))
+	got, errs := collectBoth(t, out, errc)
+
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "events" {
+		t.Fatalf("errors = %v, want the events error", errs)
+	}
+	if len(got) == 10_000-1 {
+		t.Error("every other shard carried on after the failure")
+	}
+}
+
+func TestStageShardedSkipAndReport(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	work := func(_ context.Context, e event) (event, error) {
+		if e.Seq == 0 {
+			return event{}, errors.New("first event")
+		}
+		return e, nil
+	}
+	out, errc := pipeline.StageSharded(ctx, pipeline.Source(ctx, events(1000, 10)), eventUser, 4, work,
+		pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	got, errs := collectBoth(t, out, errc)
+
+	if len(errs) != 10 || len(got) != 990 {
+		t.Errorf("got %d events and %d errors, want 990 and the first event of every user", len(got), len(errs))
+	}
+}
@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Shard is one of Count parts of an input that several processes split
+// between them, each running with a different Index from 0 to Count-1.
+type Shard struct {
+	Index int
+	Count int
+}
+
+// ParseShard parses a shard written as "index/count", e.g. "2/4" for the
+// third of four shards.
+func ParseShard(s string) (Shard, error) {
+	index, count, ok := strings.Cut(s, "/")
+	if !ok {
+		return Shard{}, fmt.Errorf("%w: %q is not index/count", ErrInvalidShard, s)
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return Shard{}, fmt.Errorf("%w: index %q is not a number", ErrInvalidShard, index)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return Shard{}, fmt.Errorf("%w: count %q is not a number", ErrInvalidShard, count)
+	}
+	shard := Shard{Index: i, Count: n}
+	return shard, shard.Validate()
+}
+
+// Validate reports a shard whose count is below 1 or whose index is not one
+// of its count.
+func (s Shard) Validate() error {
+	switch {
+	case s.Count < 1:
+		return fmt.Errorf("%w: count must be at least 1, got %d", ErrInvalidShard, s.Count)
+	case s.Index < 0 || s.Index >= s.Count:
+		return fmt.Errorf("%w: index %d is not in 0 to %d", ErrInvalidShard, s.Index, s.Count-1)
+	}
+	return nil
+}
+
+func (s Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// ShardItems returns the items of items at the positions i with i %
+// shardCount == shardIndex. It panics for an invalid shard, which
+// Shard.Validate or ParseShard report as an error beforehand.
+func ShardItems[T any](items []T, shardIndex, shardCount int) []T {
+	mustBeShard(shardIndex, shardCount)
+	var shard []T
+	for i := shardIndex; i < len(items); i += shardCount {
+		shard = append(shard, items[i])
+	}
+	return shard
+}
+
+// ShardRange returns the contiguous range items[lo:hi] of shard shardIndex
+// of shardCount of n items. The ranges of all shards follow each other and
+// differ in length by one at most. It panics for an invalid shard, as
+// ShardItems does.
+func ShardRange(n, shardIndex, shardCount int) (lo, hi int) {
+	mustBeShard(shardIndex, shardCount)
+	return shardIndex * n / shardCount, (shardIndex + 1) * n / shardCount
+}
+
+func mustBeShard(index, count int) {
+	if err := (Shard{Index: index, Count: count}).Validate(); err != nil {
+		panic(err)
+	}
+}
+
+// ShardedSource is a Source emitting only the items of shard shardIndex of
+// shardCount, those at the positions i with i % shardCount == shardIndex, so
+// that processes running it with every index of the same count cover items
+// without overlap. ShardedRangeSource splits items into contiguous ranges
+// instead. The shard is checked before the source starts: an invalid one
+// panics, so parse it with ParseShard or check it with Shard.Validate first.
+// WithStartOffset skips the first items of the shard.
+func ShardedSource[T any](ctx context.Context, items []T, shardIndex, shardCount int, opts ...Option) <-chan T {
+	return Source(ctx, ShardItems(items, shardIndex, shardCount), opts...)
+}
+
+// ShardedRangeSource is ShardedSource emitting the contiguous range of items
+// ShardRange gives for the shard.
+func ShardedRangeSource[T any](ctx context.Context, items []T, shardIndex, shardCount int, opts ...Option) <-chan T {
+	lo, hi := ShardRange(len(items), shardIndex, shardCount)
+	return Source(ctx, items[lo:hi], opts...)
+}
@@ -0,0 +1,90 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestShardedSourcesCoverTheInput(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	sources := map[string]func(context.Context, []int, int, int, ...pipeline.Option) <-chan int{
+		"ShardedSource":      pipeline.ShardedSource[int],
+		"ShardedRangeSource": pipeline.ShardedRangeSource[int],
+	}
+	for name, source := range sources {
+		for _, n := range []int{0, 1, 7, 20} {
+			for count := 1; count <= 5; count++ {
+				items := pipelinetest.Items(n)
+				seen := make(map[int]int)
+				for index := range count {
+					got := pipelinetest.CollectWithTimeout(t, source(context.Background(), items, index, count), pipelinetest.DefaultTimeout)
+					for _, item := range got {
+						seen[item]++
+					}
+				}
+				for _, item := range items {
+					if seen[item] != 1 {
+						t.Errorf("%s of %d in %d shards emitted %d %d times, want once", name, n, count, item, seen[item])
+					}
+				}
+				if len(seen) != n {
+					t.Errorf("%s of %d in %d shards emitted %d items, want the input", name, n, count, len(seen))
+				}
+			}
+		}
+	}
+}
+
+func TestShardedSourceKeepsItsItems(t *testing.T) {
+	got := pipelinetest.CollectWithTimeout(t, pipeline.ShardedSource(context.Background(), pipelinetest.Items(10), 1, 3), pipelinetest.DefaultTimeout)
+	if !slices.Equal(got, []int{1, 4, 7}) {
+		t.Errorf("shard 1/3 emitted %v, want 1, 4 and 7", got)
+	}
+	got = pipelinetest.CollectWithTimeout(t, pipeline.ShardedRangeSource(context.Background(), pipelinetest.Items(10), 1, 3), pipelinetest.DefaultTimeout)
+	if !slices.Equal(got, []int{3, 4, 5}) {
+		t.Errorf("range shard 1/3 emitted %v, want 3 to 5", got)
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want pipeline.Shard
+	}{
+		{"0/1", pipeline.Shard{Index: 0, Count: 1}},
+		{"2/4", pipeline.Shard{Index: 2, Count: 4}},
+		{"3/4", pipeline.Shard{Index: 3, Count: 4}},
+	} {
+		got, err := pipeline.ParseShard(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseShard(%q) = %v, %v, want %v", tc.in, got, err, tc.want)
+		}
+		if got.String() != tc.in {
+			t.Errorf("%v printed as %q, want %q", got, got.String(), tc.in)
+		}
+	}
+	for _, in := range []string{"", "2", "4/4", "-1/4", "0/0", "1/-2", "a/4", "2/b", "1/2/3"} {
+		if _, err := pipeline.ParseShard(in); !errors.Is(err, pipeline.ErrInvalidShard) {
+			t.Errorf("ParseShard(%q) = %v, want ErrInvalidShard", in, err)
+		}
+	}
+}
+
+func TestShardedSourcePanicsOnInvalidShard(t *testing.T) {
+	for _, shard := range []pipeline.Shard{{Index: 4, Count: 4}, {Index: -1, Count: 2}, {Index: 0, Count: 0}} {
+		func() {
+			defer func() {
+				if err, _ := recover().(error); !errors.Is(err, pipeline.ErrInvalidShard) {
+					t.Errorf("ShardedSource of %v panicked with %v, want ErrInvalidShard", shard, err)
+				}
+			}()
+			pipeline.ShardedSource(context.Background(), pipelinetest.Items(3), shard.Index, shard.Count)
+			t.Errorf("ShardedSource of %v started", shard)
+		}()
+	}
+}
@@ -0,0 +1,43 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Sample forwards the first of every keepEvery items from in and drops the
+// rest. The returned function reports how many items were dropped, as with
+// Filter.
+func Sample[T any](ctx context.Context, in <-chan T, keepEvery int, opts ...Option) (<-chan T, func() int) {
+	keepEvery = max(keepEvery, 1)
+	var seen int
+	return Filter(ctx, in, func(T) bool {
+		keep := seen%keepEvery == 0
+		seen++
+		return keep
+	}, opts...)
+}
+
+// DropIfBusy forwards an item from in only if the consumer is ready to take it
+// right away, or there is room in the buffer set with WithBuffer, and drops it
+// otherwise, so in is always read at the producer's pace. The returned function
+// reports how many items were dropped; its value is final once the output
+// channel has been closed.
+func DropIfBusy[T any](ctx context.Context, in <-chan T, opts ...Option) (<-chan T, func() int) {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	var dropped atomic.Int64
+	go func() {
+		defer close(out)
+		for item := range OrDone(ctx, in) {
+			select {
+			case out <- item:
+			default:
+				dropped.Add(1)
+			}
+		}
+	}()
+	return out, func() int {
+		return int(dropped.Load())
+	}
+}
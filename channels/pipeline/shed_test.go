@@ -0,0 +1,53 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestSample(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, dropped := pipeline.Sample(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), 3)
+
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); !slices.Equal(got, []int{0, 3, 6, 9}) {
+		t.Errorf("got %v, want [0 3 6 9]", got)
+	}
+	if n := dropped(); n != 6 {
+		t.Errorf("dropped %d, want 6", n)
+	}
+}
+
+func TestDropIfBusySlowConsumer(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int)
+	out, dropped := pipeline.DropIfBusy(ctx, in)
+	delivered := make(chan int)
+	go func() {
+		n := 0
+		for range out {
+			n++
+			time.Sleep(time.Millisecond)
+		}
+		delivered <- n
+	}()
+
+	// Blocked on the consumer this would take a second.
+	start := time.Now()
+	sendAll(t, in, pipelinetest.Items(1000))
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("sending took %v, want the producer never held up", elapsed)
+	}
+	n := <-delivered
+	if n == 0 || n+dropped() != 1000 {
+		t.Errorf("%d delivered and %d dropped, want them to add up to 1000", n, dropped())
+	}
+}
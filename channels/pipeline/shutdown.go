@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+)
+
+// Mode is what a sink does with the data it holds when it is stopped early.
+type Mode int
+
+const (
+	// ModeFlush writes or commits what the sink holds, as a graceful
+	// shutdown wants.
+	ModeFlush Mode = iota + 1
+	// ModeDiscard drops what the sink holds and rolls back what it can, as
+	// a failed pipeline wants.
+	ModeDiscard
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeFlush:
+		return "flush"
+	case ModeDiscard:
+		return "discard"
+	}
+	return "unknown"
+}
+
+// ShutdownMode tells a sink stopped by ctx what to do with the data it holds:
+// ModeDiscard if ctx was cancelled because something failed, that is with a
+// cause other than context.Canceled, context.DeadlineExceeded, ErrSignalled
+// or ErrDrainTimeout, and ModeFlush otherwise, including while ctx is not
+// done. Under Run this means discarding once a stage failed and flushing
+// after a drain.
+func ShutdownMode(ctx context.Context) Mode {
+	cause := context.Cause(ctx)
+	if cause == nil || errors.Is(cause, context.Canceled) || errors.Is(cause, context.DeadlineExceeded) ||
+		errors.Is(cause, ErrSignalled) || errors.Is(cause, ErrDrainTimeout) {
+		return ModeFlush
+	}
+	return ModeDiscard
+}
+
+// WithShutdownMode makes SinkSQL and SinkJSONL use mode when stopped early,
+// whatever ShutdownMode says.
+func WithShutdownMode(mode Mode) Option {
+	return func(o *options) {
+		o.shutdownMode = mode
+	}
+}
+
+// shutdown returns the Mode of a sink stopped by ctx.
+func (o options) shutdown(ctx context.Context) Mode {
+	if o.shutdownMode != 0 {
+		return o.shutdownMode
+	}
+	return ShutdownMode(ctx)
+}
@@ -0,0 +1,33 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"channelspractice/pipeline"
+)
+
+func TestShutdownMode(t *testing.T) {
+	for _, tc := range []struct {
+		cause error
+		want  pipeline.Mode
+	}{
+		{context.Canceled, pipeline.ModeFlush},
+		{context.DeadlineExceeded, pipeline.ModeFlush},
+		{pipeline.ErrSignalled, pipeline.ModeFlush},
+		{pipeline.ErrDrainTimeout, pipeline.ModeFlush},
+		{&pipeline.StageError{Stage: "transform", Err: errors.New("boom")}, pipeline.ModeDiscard},
+		{fmt.Errorf("stopping: %w", pipeline.ErrSignalled), pipeline.ModeFlush},
+	} {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(tc.cause)
+		if got := pipeline.ShutdownMode(ctx); got != tc.want {
+			t.Errorf("ShutdownMode after %v = %v, want %v", tc.cause, got, tc.want)
+		}
+	}
+	if got := pipeline.ShutdownMode(context.Background()); got != pipeline.ModeFlush {
+		t.Errorf("ShutdownMode of a live context = %v, want flush", got)
+	}
+}
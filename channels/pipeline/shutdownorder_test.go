@@ -0,0 +1,202 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+var errBadRecord = errors.New("bad record")
+
+// stopOrder records the stages in the order their OnStop hooks ran, with
+// the error each of them stopped with.
+type stopOrder struct {
+	mu     sync.Mutex
+	stages []string
+	errs   map[string]error
+}
+
+func (o *stopOrder) hooks() pipeline.Option {
+	return pipeline.WithHooks(pipeline.Hooks{
+		OnStop: func(info pipeline.StageInfo, err error) {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			if o.errs == nil {
+				o.errs = make(map[string]error)
+			}
+			o.stages = append(o.stages, info.Stage)
+			o.errs[info.Stage] = err
+		},
+	})
+}
+
+// before reports whether every stage of want stopped after the ones before
+// it in want, whatever other stages stopped in between.
+func (o *stopOrder) before(want ...string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var got []string
+	for _, stage := range o.stages {
+		if slices.Contains(want, stage) {
+			got = append(got, stage)
+		}
+	}
+	return slices.Equal(got, want)
+}
+
+func (o *stopOrder) err(stage string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.errs[stage]
+}
+
+func TestRunWithOptionsDrainStopsStagesInOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var order stopOrder
+	var reported []error
+	parse := func(_ context.Context, n int) (int, error) {
+		if n == 3 {
+			cancel()
+		}
+		return n, nil
+	}
+	sink := &pipeline.MemorySink[int]{}
+	_, err := pipeline.New(pipelinetest.Items(1000)).
+		Then(parse, pipeline.WithName("parse"), order.hooks()).
+		Then(double, pipeline.WithName("enrich"), order.hooks(), pipeline.WithBuffer(10)).
+		SinkTo(sink, pipeline.WithName("save"), order.hooks()).
+		OnError(func(err error) error {
+			reported = append(reported, err)
+			return err
+		}).
+		RunWithOptions(ctx, pipeline.RunOptions{DrainTimeout: pipelinetest.DefaultTimeout})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunWithOptions = %v, want context.Canceled after a drain", err)
+	}
+	if len(reported) > 0 {
+		t.Errorf("stages reported %v during the drain", reported)
+	}
+	if order.stages == nil || !order.before("parse", "enrich", "save") {
+		t.Errorf("stages stopped in the order %v, want parse, enrich, save", order.stages)
+	}
+	for _, stage := range []string{"parse", "enrich", "save"} {
+		if err := order.err(stage); err != nil {
+			t.Errorf("%s stopped with %v, want its input drained", stage, err)
+		}
+	}
+	if got := sink.Items(); len(got) < 4 || got[3] != 6 {
+		t.Errorf("saved %v, want every item up to the cancellation", got)
+	}
+}
+
+func TestRunAbortStopsStagesFromTheSource(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var order stopOrder
+	// check sees the items doubled by parse.
+	check := func(_ context.Context, n int) (int, error) {
+		if n == 6 {
+			return 0, errBadRecord
+		}
+		return n, nil
+	}
+	// enrich holds on to its first item until it is cancelled.
+	enrich := func(ctx context.Context, n int) (int, error) {
+		<-ctx.Done()
+		return n, nil
+	}
+	_, err := pipeline.New(pipelinetest.Items(1000)).
+		Then(double, pipeline.WithName("parse"), order.hooks()).
+		Then(check, pipeline.WithName("check"), pipeline.WithBuffer(10), order.hooks()).
+		Then(enrich, pipeline.WithName("enrich"), order.hooks()).
+		Sink(saveAll, pipeline.WithName("save"), order.hooks()).
+		Run(context.Background())
+
+	if !errors.Is(err, errBadRecord) {
+		t.Fatalf("Run = %v, want the failure of check", err)
+	}
+	// parse is blocked sending to check and enrich on its item, so both only
+	// stop once they are cancelled, parse first.
+	if !order.before("parse", "enrich", "save") {
+		t.Errorf("stages stopped in the order %v, want parse, enrich, save", order.stages)
+	}
+	for _, stage := range []string{"parse", "enrich"} {
+		if err := order.err(stage); !errors.Is(err, errBadRecord) {
+			t.Errorf("%s stopped with %v, want the failure of check as the cause", stage, err)
+		}
+	}
+}
+
+func TestRunWithOptionsSinkGrace(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var order stopOrder
+	check := func(_ context.Context, n int) (int, error) {
+		if n == 5 {
+			return 0, errBadRecord
+		}
+		return n, nil
+	}
+	// save only starts saving once check failed, with 0 to 4 waiting for it.
+	failed := make(chan struct{})
+	sink := &pipeline.MemorySink[int]{}
+	save := func(ctx context.Context, n int) error {
+		<-failed
+		return sink.Write(ctx, n)
+	}
+	_, err := pipeline.New(pipelinetest.Items(10)).
+		Then(check, pipeline.WithName("check"), pipeline.WithBuffer(10), order.hooks()).
+		Sink(save, pipeline.WithName("save"), order.hooks()).
+		OnError(func(err error) error {
+			close(failed)
+			return err
+		}).
+		RunWithOptions(context.Background(), pipeline.RunOptions{SinkGrace: pipelinetest.DefaultTimeout})
+
+	if !errors.Is(err, errBadRecord) {
+		t.Fatalf("RunWithOptions = %v, want the failure of check", err)
+	}
+	if got := sink.Items(); !slices.Equal(got, []int{0, 1, 2, 3, 4}) {
+		t.Errorf("saved %v, want the items that passed check flushed within the grace period", got)
+	}
+	if err := order.err("save"); err != nil {
+		t.Errorf("save stopped with %v, want its input drained", err)
+	}
+}
+
+func TestRunWithOptionsSinkGraceRunsOut(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	var order stopOrder
+	check := func(_ context.Context, n int) (int, error) {
+		if n == 1 {
+			return 0, errBadRecord
+		}
+		return n, nil
+	}
+	stuck := func(ctx context.Context, _ int) error {
+		<-ctx.Done()
+		return nil
+	}
+	start := time.Now()
+	_, err := pipeline.New(pipelinetest.Items(10)).
+		Then(check, pipeline.WithName("check"), pipeline.WithBuffer(10)).
+		Sink(stuck, pipeline.WithName("save"), order.hooks()).
+		RunWithOptions(context.Background(), pipeline.RunOptions{SinkGrace: 20 * time.Millisecond})
+
+	if !errors.Is(err, errBadRecord) {
+		t.Fatalf("RunWithOptions = %v, want the failure of check", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("RunWithOptions returned after %v, before the grace period was over", elapsed)
+	}
+	if err := order.err("save"); !errors.Is(err, errBadRecord) {
+		t.Errorf("save stopped with %v, want the failure of check as the cause", err)
+	}
+}
@@ -0,0 +1,28 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+)
+
+// ShutdownOnSignal returns a context that is cancelled on the first signal
+// received from sigs, with ErrSignalled as its cause. A second signal means
+// the graceful shutdown is taking too long and calls force, which typically
+// reports what is still running and exits the process.
+func ShutdownOnSignal(parent context.Context, sigs <-chan os.Signal, force func()) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigs:
+			cancel(ErrSignalled)
+		}
+		select {
+		case <-parent.Done():
+		case <-sigs:
+			force()
+		}
+	}()
+	return ctx, func() { cancel(nil) }
+}
@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// Sink calls fn for every item read from in, with a context that is cancelled
+// with ctx or when a WithItemTimeout timeout expires. The done channel is
+// closed when the sink exits, either because in was closed, ctx was cancelled
+// or fn returned an error, which is sent on the error channel first as a
+// *StageError. PlainSink adapts a fn that takes no context.
+func Sink[T any](ctx context.Context, in <-chan T, fn func(context.Context, T) error, opts ...Option) (<-chan struct{}, <-chan error) {
+	o := newOptions(opts)
+	done := make(chan struct{})
+	errc := o.newErrc()
+	unregister := o.register("sink")
+	name := o.stageName("sink")
+	o.logStart(ctx, name)
+	stopWatching := o.watchCancel(ctx)
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(done)
+		defer stopWatching()
+		// A lazy sink does not even run its hooks without a first item.
+		var first T
+		pending := o.lazyStart
+		if pending {
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-in:
+				if !ok {
+					return
+				}
+				first = item
+			}
+		}
+		info := StageInfo{Stage: name, Workers: 1}
+		var failure error
+		defer o.stopHook(info, func() error {
+			if failure != nil {
+				return failure
+			}
+			return context.Cause(ctx)
+		})
+		if err := o.startHook(ctx, info); err != nil {
+			failure = err
+			select {
+			case <-ctx.Done():
+			case errc <- err:
+			}
+			return
+		}
+		hb := o.newHeartbeat(name)
+		defer hb.stop()
+		o.metrics.workerStarted()
+		defer o.metrics.workerStopped()
+		for {
+			var item T
+			if pending {
+				item, pending = first, false
+			} else {
+				next, ok := recvWithBeat(ctx, hb, in)
+				if !ok {
+					return
+				}
+				item = next
+			}
+			checkSlow(o, name, item, len(in), cap(in))
+			if err := checkDeadline(o, name, item); err != nil {
+				if !o.reportErr(ctx, errc, &StageError{Stage: name, Item: item, Err: err}) {
+					return
+				}
+				continue
+			}
+			o.metrics.itemIn()
+			start := time.Now()
+			_, err := call(ctx, o, name, func(ctx context.Context, item T) (struct{}, error) {
+				return struct{}{}, fn(ctx, item)
+			}, item)
+			o.metrics.processed(start, err)
+			hb.itemDone()
+			itemHook(o, info, item)
+			if err != nil {
+				o.logItemError(ctx, name, item, err)
+				failure = stageFailure(name, item, err)
+				select {
+				case <-ctx.Done():
+				case errc <- failure:
+				}
+				return
+			}
+			logItem(ctx, o, name, item)
+			o.metrics.itemOut()
+		}
+	}()
+	return done, errc
+}
+
+// PlainSink adapts a sink function that takes no context, see Plain.
+func PlainSink[T any](fn func(T) error) func(context.Context, T) error {
+	return func(_ context.Context, item T) error {
+		return fn(item)
+	}
+}
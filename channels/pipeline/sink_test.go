@@ -0,0 +1,100 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestSinkSuccess(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var saved []int
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, []int{1, 2, 3}), func(_ context.Context, n int) error {
+		saved = append(saved, n)
+		return nil
+	})
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+	if !slices.Equal(saved, []int{1, 2, 3}) {
+		t.Errorf("saved %v, want [1 2 3]", saved)
+	}
+}
+
+func TestSinkMidStreamError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	diskFull := errors.New("disk full")
+	var saved []int
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, []int{1, 2, 3}), func(_ context.Context, n int) error {
+		if n == 2 {
+			return diskFull
+		}
+		saved = append(saved, n)
+		return nil
+	})
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if len(errs) != 1 || !errors.Is(errs[0], diskFull) {
+		t.Errorf("errors = %v, want %v", errs, diskFull)
+	}
+	if !slices.Equal(saved, []int{1}) {
+		t.Errorf("saved %v, want the sink to stop at the failure", saved)
+	}
+}
+
+func TestSinkExternalCancellation(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	done, errc := pipeline.Sink(ctx, in, func(context.Context, int) error { return nil })
+	in <- 1
+	cancel()
+	pipelinetest.AssertClosed(t, done, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v, want none for a cancelled sink", errs)
+	}
+}
+
+func TestSinkCancellationUnblocksFn(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, []int{1}), func(ctx context.Context, _ int) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+	cancel()
+	pipelinetest.AssertClosed(t, done, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+}
+
+func TestPlainSink(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var saved []int
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, []int{1, 2, 3}), pipeline.PlainSink(func(n int) error {
+		saved = append(saved, n)
+		return nil
+	}))
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+	if !slices.Equal(saved, []int{1, 2, 3}) {
+		t.Errorf("saved %v, want [1 2 3]", saved)
+	}
+}
@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+)
+
+// SinkWriter is where SinkTo puts the items of a pipeline, e.g. a file, a
+// database or, in tests, a MemorySink.
+type SinkWriter[T any] interface {
+	// Write stores a single item.
+	Write(ctx context.Context, item T) error
+	// Close is called once after the last Write, also when the sink was
+	// stopped by an error or a cancellation.
+	Close(ctx context.Context) error
+}
+
+// SinkTo is Sink writing every item to w. Once the sink has stopped, for
+// whatever reason, it closes w with a context that is not cancelled along
+// with ctx, so w can still flush what it holds. A Close error is reported
+// unless the sink already failed. The done channel is closed after Close
+// returned.
+func SinkTo[T any](ctx context.Context, in <-chan T, w SinkWriter[T], opts ...Option) (<-chan struct{}, <-chan error) {
+	o := newOptions(opts)
+	_, sinkErrc := Sink(ctx, in, func(ctx context.Context, item T) error {
+		return w.Write(ctx, item)
+	}, opts...)
+
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(done)
+		var err error
+		for sinkErr := range sinkErrc {
+			err = sinkErr
+		}
+		if closeErr := w.Close(context.WithoutCancel(ctx)); err == nil && closeErr != nil {
+			err = &StageError{Stage: o.stageName("sink"), Err: fmt.Errorf("close: %w", closeErr)}
+		}
+		if err != nil {
+			errc <- err
+		}
+	}()
+	return done, errc
+}
+
+// WriterSink writes every item to W, formatted with Format, which defaults
+// to a line per item. Close flushes W if it has a Flush method, as a
+// *bufio.Writer does, but does not close it.
+type WriterSink[T any] struct {
+	W      io.Writer
+	Format string
+}
+
+// Write prints item to W.
+func (s WriterSink[T]) Write(_ context.Context, item T) error {
+	_, err := fmt.Fprintf(s.W, cmp.Or(s.Format, "%v\n"), item)
+	return err
+}
+
+// Close flushes W.
+func (s WriterSink[T]) Close(context.Context) error {
+	if f, ok := s.W.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// PrintSink prints every item to standard output, formatted as for a
+// WriterSink.
+type PrintSink[T any] struct {
+	Format string
+}
+
+// Write prints item.
+func (s PrintSink[T]) Write(ctx context.Context, item T) error {
+	return WriterSink[T]{W: os.Stdout, Format: s.Format}.Write(ctx, item)
+}
+
+// Close does nothing.
+func (PrintSink[T]) Close(context.Context) error {
+	return nil
+}
+
+// MemorySink keeps the items written to it in memory, for tests. It fails
+// writes after Close and a second Close, so a test using it also checks that
+// the sink was closed as SinkWriter requires. It is safe for concurrent use.
+type MemorySink[T any] struct {
+	mu     sync.Mutex
+	items  []T
+	closed bool
+}
+
+// Write appends item.
+func (s *MemorySink[T]) Write(_ context.Context, item T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("write %v to a closed MemorySink", item)
+	}
+	s.items = append(s.items, item)
+	return nil
+}
+
+// Close marks the sink as closed.
+func (s *MemorySink[T]) Close(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("MemorySink closed twice")
+	}
+	s.closed = true
+	return nil
+}
+
+// Items returns a copy of the items written so far, in order.
+func (s *MemorySink[T]) Items() []T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.items)
+}
+
+// Closed reports whether Close was called.
+func (s *MemorySink[T]) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
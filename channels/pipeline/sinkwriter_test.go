@@ -0,0 +1,186 @@
+package pipeline_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// closeRecorder is a MemorySink that also records whether Close came after
+// the last Write and can fail its writes and its Close.
+type closeRecorder struct {
+	pipeline.MemorySink[int]
+	mu         sync.Mutex
+	closes     int
+	lateWrites int
+	failWrite  int
+	closeErr   error
+}
+
+func (r *closeRecorder) Write(ctx context.Context, n int) error {
+	r.mu.Lock()
+	if r.closes > 0 {
+		r.lateWrites++
+	}
+	r.mu.Unlock()
+	if r.failWrite != 0 && n == r.failWrite {
+		return errDiskFull
+	}
+	return r.MemorySink.Write(ctx, n)
+}
+
+func (r *closeRecorder) Close(ctx context.Context) error {
+	r.mu.Lock()
+	r.closes++
+	r.mu.Unlock()
+	if err := r.MemorySink.Close(ctx); err != nil {
+		return err
+	}
+	return r.closeErr
+}
+
+func TestSinkToClosesAfterLastWrite(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &closeRecorder{}
+
+	done, errc := pipeline.SinkTo(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), w)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Fatalf("errors = %v", errs)
+	}
+	<-done
+
+	if !slices.Equal(w.Items(), pipelinetest.Items(100)) {
+		t.Errorf("wrote %d items, want all 100 in order", len(w.Items()))
+	}
+	if w.closes != 1 || w.lateWrites != 0 {
+		t.Errorf("closed %d times with %d writes after, want once after the last write", w.closes, w.lateWrites)
+	}
+}
+
+func TestSinkToClosesOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &closeRecorder{}
+
+	// in is never closed, so only the cancellation stops the sink.
+	in := make(chan int)
+	done, errc := pipeline.SinkTo(ctx, in, w)
+	in <- 1
+	in <- 2
+	cancel()
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	<-done
+
+	if w.closes != 1 || w.lateWrites != 0 {
+		t.Errorf("closed %d times with %d writes after, want once after the last write", w.closes, w.lateWrites)
+	}
+}
+
+func TestSinkToWriteError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &closeRecorder{failWrite: 3, closeErr: errors.New("ignored")}
+
+	done, errc := pipeline.SinkTo(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), w, pipeline.WithName("save"))
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	<-done
+
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "save" || !errors.Is(errs[0], errDiskFull) {
+		t.Fatalf("errors = %v, want the save error for 3 and not the Close error", errs)
+	}
+	if w.closes != 1 {
+		t.Errorf("closed %d times after the write error, want once", w.closes)
+	}
+}
+
+func TestSinkToCloseError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errFlush := errors.New("flush failed")
+	w := &closeRecorder{closeErr: errFlush}
+
+	_, errc := pipeline.SinkTo(ctx, pipeline.Source(ctx, pipelinetest.Items(3)), w, pipeline.WithName("save"))
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "save" || !errors.Is(errs[0], errFlush) {
+		t.Errorf("errors = %v, want the Close error of save", errs)
+	}
+}
+
+func TestWriterSinkFlushesOnClose(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var out bytes.Buffer
+	buf := bufio.NewWriter(&out)
+
+	_, errc := pipeline.SinkTo(ctx, pipeline.Source(ctx, []int{1, 2, 3}), pipeline.WriterSink[int]{W: buf, Format: "n=%d\n"})
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Fatalf("errors = %v", errs)
+	}
+	if want := "n=1\nn=2\nn=3\n"; out.String() != want {
+		t.Errorf("wrote %q, want %q", out.String(), want)
+	}
+}
+
+func TestWriterSinkDefaultFormat(t *testing.T) {
+	var out bytes.Buffer
+	w := pipeline.WriterSink[string]{W: &out}
+	for _, s := range []string{"a", "b"} {
+		if err := w.Write(context.Background(), s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := "a\nb\n"; out.String() != want {
+		t.Errorf("wrote %q, want %q", out.String(), want)
+	}
+}
+
+func TestMemorySinkRejectsUseAfterClose(t *testing.T) {
+	ctx := context.Background()
+	var s pipeline.MemorySink[int]
+	if err := s.Write(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(ctx); err != nil || !s.Closed() {
+		t.Fatalf("Close = %v, closed %t", err, s.Closed())
+	}
+	if err := s.Write(ctx, 2); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+	if err := s.Close(ctx); err == nil {
+		t.Error("second Close succeeded")
+	}
+	if !slices.Equal(s.Items(), []int{1}) {
+		t.Errorf("items = %v, want [1]", s.Items())
+	}
+}
+
+func TestFlowSinkTo(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var sink pipeline.MemorySink[int]
+
+	if _, err := pipeline.New(pipelinetest.Items(5)).Then(double).SinkTo(&sink).Run(ctx); err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+	if want := []int{0, 2, 4, 6, 8}; !slices.Equal(sink.Items(), want) || !sink.Closed() {
+		t.Errorf("saved %v (closed %t), want %v and the sink closed", sink.Items(), sink.Closed(), want)
+	}
+}
@@ -0,0 +1,48 @@
+package pipeline
+
+import "time"
+
+// SlowEvent reports an item that waited longer than the WithSlowWarning
+// threshold before a stage accepted it, together with how full the stage's
+// input was at that moment.
+type SlowEvent struct {
+	Stage    string
+	Item     any
+	Waited   time.Duration
+	Queued   int
+	Capacity int
+}
+
+// WithSlowWarning makes a stage or sink call onSlow for every item that was
+// sent to it more than threshold before it was received, which means the
+// stage cannot keep up with its upstream. The wait is measured from the
+// moment the sender handed the item over, which only envelopes record, so
+// the warning covers the items of SourceItems, StageItems, StagePoolItems
+// and the channels built from them; plain values are never reported. onSlow
+// runs on the stage's goroutine and should return quickly.
+func WithSlowWarning(threshold time.Duration, onSlow func(SlowEvent)) Option {
+	return func(o *options) {
+		o.slowThreshold = threshold
+		o.onSlow = onSlow
+	}
+}
+
+// sentStamper is implemented by envelopes, which remember when they were
+// last sent.
+type sentStamper interface {
+	sentAt() time.Time
+}
+
+// checkSlow is generic so that item is only boxed with a WithSlowWarning.
+func checkSlow[T any](o options, stage string, item T, queued, capacity int) {
+	if o.onSlow == nil {
+		return
+	}
+	s, ok := any(item).(sentStamper)
+	if !ok || s.sentAt().IsZero() {
+		return
+	}
+	if waited := time.Since(s.sentAt()); waited > o.slowThreshold {
+		o.onSlow(SlowEvent{Stage: stage, Item: item, Waited: waited, Queued: queued, Capacity: capacity})
+	}
+}
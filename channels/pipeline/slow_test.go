@@ -0,0 +1,91 @@
+package pipeline_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// slowEvents records the events of WithSlowWarning.
+type slowEvents struct {
+	mu     sync.Mutex
+	events []pipeline.SlowEvent
+}
+
+func (s *slowEvents) option(threshold time.Duration) pipeline.Option {
+	return pipeline.WithSlowWarning(threshold, func(e pipeline.SlowEvent) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.events = append(s.events, e)
+	})
+}
+
+func TestSlowWarningForSlowSink(t *testing.T) {
+	const perItem = 20 * time.Millisecond
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var slow slowEvents
+
+	src := pipeline.SourceItems(ctx, pipelinetest.Items(5), nil)
+	done, errc := pipeline.SinkItems(ctx, src, func(context.Context, int) error {
+		time.Sleep(perItem)
+		return nil
+	}, pipeline.WithName("save"), slow.option(perItem/2))
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	// Every item but the first waits for the one before it to be saved.
+	if len(slow.events) < 3 {
+		t.Fatalf("got %d slow events, want one for most of the 5 items", len(slow.events))
+	}
+	for _, e := range slow.events {
+		if e.Stage != "save" || e.Waited < perItem/2 || e.Waited > 10*perItem {
+			t.Errorf("event %+v, want save waiting about %v", e, perItem)
+		}
+	}
+}
+
+func TestSlowWarningForSlowStage(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var slow slowEvents
+
+	src := pipeline.SourceItems(ctx, pipelinetest.Items(5), nil)
+	out, errc := pipeline.StageItems(ctx, src, pipelinetest.IOWork(20*time.Millisecond), pipeline.WithName("transform"), slow.option(10*time.Millisecond))
+	pipelinetest.CollectWithTimeout(t, out, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+
+	if len(slow.events) < 3 || slow.events[0].Stage != "transform" {
+		t.Errorf("events %+v, want transform reported for most items", slow.events)
+	}
+}
+
+func TestSlowWarningQuietForFastSink(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var slow slowEvents
+
+	src := pipeline.SourceItems(ctx, pipelinetest.Items(100), nil)
+	done, errc := pipeline.SinkItems(ctx, src, func(context.Context, int) error { return nil }, slow.option(time.Second))
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	// Plain values carry no send time and are never reported.
+	plain, plainErrs := pipeline.Sink(ctx, pipeline.Source(ctx, pipelinetest.Items(5)), func(context.Context, int) error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}, slow.option(time.Nanosecond))
+	pipelinetest.CollectWithTimeout(t, plainErrs, time.Second)
+	pipelinetest.AssertClosed(t, plain, time.Second)
+
+	if len(slow.events) != 0 {
+		t.Errorf("events %+v, want none", slow.events)
+	}
+}
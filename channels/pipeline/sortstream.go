@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"container/heap"
+	"context"
+
+	"channelspractice/chanutil"
+)
+
+// SortStream sorts in by less with at most window items held back: once more
+// than window are buffered it emits the smallest of them. An input in which
+// no item is more than window positions from its sorted position, like
+// sequence numbers coming out of a worker pool, comes out fully sorted; any
+// other input comes out as sorted as the window allows. Items that are equal
+// keep their order. It holds window items where StagePoolOrdered may have to
+// hold any number of them, at the price of not being exact. The remaining
+// items are emitted in order once in is closed. The channel is closed after
+// them, or when ctx is cancelled, dropping what is still held.
+func SortStream[T any](ctx context.Context, in <-chan T, less func(a, b T) bool, window int) <-chan T {
+	window = max(window, 0)
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		h := &sortHeap[T]{less: less}
+		for seq := int64(0); ; seq++ {
+			item, ok, err := chanutil.RecvContext(ctx, in)
+			if err != nil {
+				return
+			}
+			if !ok {
+				break
+			}
+			heap.Push(h, sortedItem[T]{item: item, seq: seq})
+			if h.Len() <= window {
+				continue
+			}
+			if chanutil.SendContext(ctx, out, heap.Pop(h).(sortedItem[T]).item) != nil {
+				return
+			}
+		}
+		for h.Len() > 0 {
+			if chanutil.SendContext(ctx, out, heap.Pop(h).(sortedItem[T]).item) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+type sortedItem[T any] struct {
+	item T
+	seq  int64
+}
+
+// sortHeap is a heap.Interface over the items SortStream holds, smallest
+// first and in arrival order among equal ones.
+type sortHeap[T any] struct {
+	items []sortedItem[T]
+	less  func(a, b T) bool
+}
+
+func (h *sortHeap[T]) Len() int { return len(h.items) }
+
+func (h *sortHeap[T]) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	switch {
+	case h.less(a.item, b.item):
+		return true
+	case h.less(b.item, a.item):
+		return false
+	}
+	return a.seq < b.seq
+}
+
+func (h *sortHeap[T]) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *sortHeap[T]) Push(x any)    { h.items = append(h.items, x.(sortedItem[T])) }
+
+func (h *sortHeap[T]) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
@@ -0,0 +1,102 @@
+package pipeline_test
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// shuffledWithin returns the numbers 0 to n-1 shuffled in blocks of k+1, so
+// that none is more than k positions from where it belongs.
+func shuffledWithin(rng *rand.Rand, n, k int) []int {
+	items := pipelinetest.Items(n)
+	for start := 0; start < n; start += k + 1 {
+		block := items[start:min(start+k+1, n)]
+		rng.Shuffle(len(block), func(i, j int) { block[i], block[j] = block[j], block[i] })
+	}
+	return items
+}
+
+// inversions counts the pairs of items out of order.
+func inversions(items []int) int {
+	n := 0
+	for i := range items {
+		for j := i + 1; j < len(items); j++ {
+			if items[i] > items[j] {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestSortStreamWithinWindow(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rng := rand.New(rand.NewPCG(3, 4))
+	for _, k := range []int{0, 1, 4, 16} {
+		items := shuffledWithin(rng, 500, k)
+		out := pipeline.SortStream(ctx, pipeline.Source(ctx, items), intLess, k)
+		if got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout); !slices.Equal(got, pipelinetest.Items(500)) {
+			t.Errorf("window %d: got %v, want 0 to 499 in order", k, got)
+		}
+	}
+}
+
+func TestSortStreamBeyondWindow(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reversed := pipelinetest.Items(20)
+	slices.Reverse(reversed)
+	got := pipelinetest.CollectWithTimeout(t, pipeline.SortStream(ctx, pipeline.Source(ctx, reversed), intLess, 5), pipelinetest.DefaultTimeout)
+
+	if !slices.Equal(slices.Sorted(slices.Values(got)), pipelinetest.Items(20)) {
+		t.Fatalf("got %v, want every item once", got)
+	}
+	if before, after := inversions(reversed), inversions(got); after >= before {
+		t.Errorf("%v has %d items out of order, want fewer than the %d of the input", got, after, before)
+	}
+	// The last window's worth comes out sorted once the input closed.
+	if tail := got[len(got)-6:]; !slices.IsSorted(tail) {
+		t.Errorf("tail %v is not sorted", tail)
+	}
+}
+
+func TestSortStreamKeepsEqualItemsInOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type keyed struct{ key, id int }
+	items := []keyed{{2, 0}, {1, 1}, {2, 2}, {1, 3}, {0, 4}, {2, 5}}
+	out := pipeline.SortStream(ctx, pipeline.Source(ctx, items), func(a, b keyed) bool { return a.key < b.key }, len(items))
+	want := []keyed{{0, 4}, {1, 1}, {1, 3}, {2, 0}, {2, 2}, {2, 5}}
+	if got := pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSortStreamCancelWhileDraining(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan int, 10)
+	for n := range 10 {
+		in <- 9 - n
+	}
+	close(in)
+	// The window holds every item until the input is closed.
+	out := pipeline.SortStream(ctx, in, intLess, 10)
+	for want := range 3 {
+		if got := <-out; got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+	}
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout)
+}
@@ -0,0 +1,41 @@
+package pipeline
+
+import "context"
+
+// Source emits items in order and closes the returned channel once all of them
+// have been sent or ctx is cancelled. WithStartOffset skips the first items,
+// and WithController pauses and resumes it.
+func Source[T any](ctx context.Context, items []T, opts ...Option) <-chan T {
+	return source(ctx, items, newOptions(opts), nil)
+}
+
+// source implements Source. onSend is called for every item before it is
+// handed over and returns the item to send, or false to stop.
+func source[T any](ctx context.Context, items []T, o options, onSend func(T) (T, bool)) <-chan T {
+	items = items[min(max(o.startOffset, 0), int64(len(items))):]
+	out := make(chan T, o.buffer)
+	unregister := o.register("source")
+	name := o.stageName("source")
+	o.logStart(ctx, name)
+	stop := make(chan struct{})
+	sampleOccupancy(o.metrics, out, stop)
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(out)
+		defer close(stop)
+		for _, item := range items {
+			if onSend != nil {
+				var ok bool
+				if item, ok = onSend(item); !ok {
+					return
+				}
+			}
+			if !sendUnlessPaused(ctx, o.controller, out, item) {
+				return
+			}
+			o.metrics.itemOut()
+		}
+	}()
+	return out
+}
@@ -0,0 +1,37 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestSourceEmitsInOrder(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if got := pipelinetest.CollectWithTimeout(t, pipeline.Source(ctx, []string{"a", "b", "c"}), time.Second); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("got %q, want [a b c]", got)
+	}
+	if got := pipelinetest.CollectWithTimeout(t, pipeline.Source[int](ctx, nil), time.Second); len(got) != 0 {
+		t.Errorf("got %v from no items", got)
+	}
+}
+
+func TestSourceStopsOnCancel(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := pipeline.Source(ctx, pipelinetest.Items(100))
+	if first := <-out; first != 0 {
+		t.Fatalf("first item = %d, want 0", first)
+	}
+	cancel()
+	if rest := pipelinetest.CollectWithTimeout(t, out, time.Second); len(rest) > 1 {
+		// At most the send already racing with the cancellation goes through.
+		t.Errorf("got %d more items after cancel", len(rest))
+	}
+}
@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SinkSQL groups items read from in into batches of up to batchSize, flushing
+// a partial batch after flushEvery, and calls insertFn for every batch inside
+// a transaction of its own. The transaction is committed when insertFn
+// succeeds and rolled back otherwise, in which case the error, naming the size
+// and first item of the batch, is sent on the error channel and the sink
+// stops. A partial batch is flushed when in is closed, so a pipeline shut down
+// through Run loses no items that reached the sink.
+//
+// When ctx is cancelled the sink asks ShutdownMode, or WithShutdownMode, what
+// to do with the items it holds. With ModeFlush it commits the transaction
+// under way and then inserts the batches it had not got to, the partial one
+// included, before the done channel is closed; with ModeDiscard it rolls the
+// transaction back and drops them. Transactions are therefore not bound to
+// ctx.
+func SinkSQL[T any](ctx context.Context, in <-chan T, db *sql.DB, insertFn func(*sql.Tx, []T) error, batchSize int, flushEvery time.Duration, opts ...Option) (<-chan struct{}, <-chan error) {
+	o := newOptions(opts)
+	name := o.stageName("sql")
+	// pending is the batch being filled, which Batch drops when cancelled.
+	var pending []T
+	batches := batch(ctx, in, fixedSize(batchSize), flushEvery, o, func(item T) {
+		pending = append(pending, item)
+	}, func([]T) {
+		pending = nil
+	})
+	insert := func(ctx context.Context, batch []T) (err error) {
+		defer func() {
+			if err != nil {
+				err = fmt.Errorf("insert batch of %d items starting with %v: %w", len(batch), batch[0], err)
+			}
+		}()
+		tx, err := db.BeginTx(context.WithoutCancel(ctx), nil)
+		if err != nil {
+			return err
+		}
+		if err := insertFn(tx, batch); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				return fmt.Errorf("%w (rollback: %v)", err, rbErr)
+			}
+			return err
+		}
+		if ctx.Err() != nil && o.shutdown(ctx) == ModeDiscard {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				return fmt.Errorf("%w (rollback: %v)", context.Cause(ctx), rbErr)
+			}
+			return context.Cause(ctx)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit: %w", err)
+		}
+		return nil
+	}
+	_, sinkErrc := Sink(ctx, batches, insert, append(opts, WithName(name))...)
+
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(done)
+		var err error
+		for sinkErr := range sinkErrc {
+			if err == nil {
+				err = sinkErr
+			}
+		}
+		if err == nil && ctx.Err() != nil && o.shutdown(ctx) == ModeFlush {
+			// Cancelled, Batch stops as well; once it has pending is
+			// what it still held.
+			var left [][]T
+			for batch := range batches {
+				left = append(left, batch)
+			}
+			if len(pending) > 0 {
+				left = append(left, pending)
+			}
+			for _, batch := range left {
+				if insertErr := insert(context.WithoutCancel(ctx), batch); insertErr != nil {
+					err = &StageError{Stage: name, Item: batch, Err: insertErr}
+					break
+				}
+			}
+		}
+		if err != nil {
+			errc <- err
+		}
+	}()
+	return done, errc
+}
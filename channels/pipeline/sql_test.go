@@ -0,0 +1,219 @@
+package pipeline_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// fakeDB is a database/sql driver that records the transactions it sees. A
+// transaction's rows only count once it is committed.
+type fakeDB struct {
+	mu        sync.Mutex
+	rows      []int64
+	pending   []int64
+	commits   int
+	rollbacks int
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return nil }
+
+func (db *fakeDB) committed() (rows []int64, commits, rollbacks int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]int64(nil), db.rows...), db.commits, db.rollbacks
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return fakeStmt(c), nil }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx(c), nil }
+
+type fakeStmt struct{ db *fakeDB }
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return 1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.pending = append(s.db.pending, args[0].(int64))
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.rows = append(tx.db.rows, tx.db.pending...)
+	tx.db.pending = nil
+	tx.db.commits++
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.pending = nil
+	tx.db.rollbacks++
+	return nil
+}
+
+// insertNums inserts every item of the batch but fails on 3, before
+// inserting it.
+func insertNums(tx *sql.Tx, batch []int) error {
+	for _, n := range batch {
+		if n == 3 {
+			return fmt.Errorf("duplicate key %d", n)
+		}
+		if _, err := tx.Exec("INSERT INTO nums VALUES (?)", n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSinkSQLCommitsEveryBatch(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+
+	done, errc := pipeline.SinkSQL(ctx, pipeline.Source(ctx, []int{0, 1, 2, 4, 5}), db, insertNums, 2, time.Hour)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	// The last batch of one is the partial flush on close.
+	rows, commits, rollbacks := fake.committed()
+	if len(rows) != 5 || commits != 3 || rollbacks != 0 {
+		t.Errorf("rows %v, %d commits, %d rollbacks, want 5 rows in 3 commits", rows, commits, rollbacks)
+	}
+}
+
+func TestSinkSQLRollsBackFailedBatch(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+
+	done, errc := pipeline.SinkSQL(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), db, insertNums, 2, time.Hour)
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "insert batch of 2 items starting with 2: duplicate key 3") {
+		t.Fatalf("errors = %v, want the failure of the batch starting with 2", errs)
+	}
+	rows, commits, rollbacks := fake.committed()
+	if len(rows) != 2 || commits != 1 || rollbacks != 1 {
+		t.Errorf("rows %v, %d commits, %d rollbacks, want the first batch only and one rollback", rows, commits, rollbacks)
+	}
+}
+
+func TestSinkSQLFlushesOnShutdown(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err := pipeline.Run(ctx, pipeline.RunOptions{DrainTimeout: time.Second}, func(produce, abort context.Context) (<-chan struct{}, <-chan error) {
+		// 3 is skipped so that no insert fails.
+		ticks := pipeline.GenerateFunc(produce, time.Millisecond, func(i int) (int, bool) { return i + 4, true })
+		return pipeline.SinkSQL(abort, ticks, db, insertNums, 1000, time.Hour)
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want context.Canceled after a drain", err)
+	}
+	// No batch filled up: everything was committed by the final flush.
+	rows, commits, _ := fake.committed()
+	if commits != 1 || len(rows) == 0 {
+		t.Fatalf("rows %v in %d commits, want the partial batch committed once", rows, commits)
+	}
+	for i, n := range rows {
+		if n != int64(i+4) {
+			t.Fatalf("row %d is %d, want every generated item", i, n)
+		}
+	}
+}
+
+// cancelSQL runs a SinkSQL with batches of 2 over 1, 2 and 4, cancels ctx
+// with cause while the first batch is being inserted and the second still
+// filling, and returns what the database saw.
+func cancelSQL(t *testing.T, cause error, opts ...pipeline.Option) (rows []int64, commits, rollbacks int) {
+	t.Helper()
+	fake := &fakeDB{}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	inserting, release := make(chan struct{}), make(chan struct{})
+	insert := func(tx *sql.Tx, batch []int) error {
+		if batch[0] == 1 {
+			close(inserting)
+			<-release
+		}
+		return insertNums(tx, batch)
+	}
+
+	in := make(chan int)
+	done, errc := pipeline.SinkSQL(ctx, in, db, insert, 2, time.Hour, opts...)
+	in <- 1
+	in <- 2
+	<-inserting
+	in <- 4
+	cancel(cause)
+	close(release)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+	return fake.committed()
+}
+
+func TestSinkSQLShutdownModes(t *testing.T) {
+	failed := &pipeline.StageError{Stage: "transform", Err: errors.New("boom")}
+	for _, tc := range []struct {
+		name      string
+		cause     error
+		opts      []pipeline.Option
+		wantRows  int
+		commits   int
+		rollbacks int
+	}{
+		// The batch being inserted is committed and the partial one after.
+		{"signal flushes", pipeline.ErrSignalled, nil, 3, 2, 0},
+		// The batch being inserted is rolled back and the partial dropped.
+		{"failure rolls back", failed, nil, 0, 0, 1},
+		{"failure flushes if told to", failed, []pipeline.Option{pipeline.WithShutdownMode(pipeline.ModeFlush)}, 3, 2, 0},
+		{"signal rolls back if told to", pipeline.ErrSignalled, []pipeline.Option{pipeline.WithShutdownMode(pipeline.ModeDiscard)}, 0, 0, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			rows, commits, rollbacks := cancelSQL(t, tc.cause, tc.opts...)
+			if len(rows) != tc.wantRows || commits != tc.commits || rollbacks != tc.rollbacks {
+				t.Errorf("rows %v, %d commits, %d rollbacks, want %d rows, %d commits, %d rollbacks",
+					rows, commits, rollbacks, tc.wantRows, tc.commits, tc.rollbacks)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package pipeline
+
+import "context"
+
+// Stage applies fn to every item read from in. The first error returned by fn
+// is sent on the error channel and stops the stage. Both returned channels are
+// closed when the stage exits.
+func Stage[T, U any](ctx context.Context, in <-chan T, fn func(context.Context, T) (U, error), opts ...Option) (<-chan U, <-chan error) {
+	return StagePool(ctx, in, 1, fn, opts...)
+}
+
+// Plain adapts a stage function that takes no context, for work that has
+// nothing to cancel.
+func Plain[T, U any](fn func(T) (U, error)) func(context.Context, T) (U, error) {
+	return func(_ context.Context, item T) (U, error) {
+		return fn(item)
+	}
+}
+
+// StageWithDeadLetters is Stage with a dead-letter channel, see
+// StagePoolWithDeadLetters.
+func StageWithDeadLetters[T, U any](ctx context.Context, in <-chan T, fn func(context.Context, T) (U, error), opts ...Option) (<-chan U, <-chan ItemError[T], <-chan error) {
+	return StagePoolWithDeadLetters(ctx, in, 1, fn, opts...)
+}
@@ -0,0 +1,173 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+var transform = pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+	return pipeline.Stage(ctx, in, failOnSix, pipeline.WithName("transform"))
+})
+
+// failOdd fails every odd number.
+func failOdd(_ context.Context, n int) (int, error) {
+	if n%2 == 1 {
+		return 0, errors.New("odd")
+	}
+	return n, nil
+}
+
+func TestStageSuccess(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	out, errs := pipelinetest.Run(t, transform, []int{1, 2, 3})
+	if len(errs) > 0 || !slices.Equal(out, []int{2, 4, 6}) {
+		t.Errorf("got %v and errors %v, want [2 4 6]", out, errs)
+	}
+}
+
+func TestStageMidStreamError(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	out, errs := pipelinetest.Run(t, transform, pipelinetest.Items(11))
+
+	if !slices.Equal(out, []int{0, 2, 4, 6, 8, 10}) {
+		t.Errorf("got %v, want the items before 6 only", out)
+	}
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Item != 6 {
+		t.Errorf("errors = %v, want the failure of 6", errs)
+	}
+}
+
+func TestStageExternalCancellation(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	out, errc := pipeline.Stage(ctx, in, func(ctx context.Context, n int) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	in <- 1
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout)
+	// The error fn returns once cancelled may or may not be sent, but the
+	// channel is closed either way.
+	pipelinetest.CollectWithTimeout(t, errc, pipelinetest.DefaultTimeout)
+}
+
+func TestStagePoolCancellationUnblocksFn(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{}, 3)
+	out, errc := pipeline.StagePool(ctx, pipeline.Source(ctx, []int{1, 2, 3}), 3, func(ctx context.Context, n int) (int, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	for range 3 {
+		<-started
+	}
+	cancel()
+	pipelinetest.CollectWithTimeout(t, out, pipelinetest.DefaultTimeout)
+	pipelinetest.CollectWithTimeout(t, errc, pipelinetest.DefaultTimeout)
+}
+
+func TestPlain(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	double := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Stage(ctx, in, pipeline.Plain(func(n int) (int, error) {
+			return n * 2, nil
+		}))
+	})
+	out, errs := pipelinetest.Run(t, double, []int{1, 2, 3})
+	if len(errs) > 0 || !slices.Equal(out, []int{2, 4, 6}) {
+		t.Errorf("got %v and errors %v, want [2 4 6]", out, errs)
+	}
+}
+
+// collectAll reads the three channels of a stage with dead letters until they
+// are all closed.
+func collectAll[T, U any](t *testing.T, out <-chan U, dead <-chan pipeline.ItemError[T], errc <-chan error) ([]U, []pipeline.ItemError[T], []error) {
+	t.Helper()
+	var got []U
+	var dlq []pipeline.ItemError[T]
+	var errs []error
+	deadline := time.After(pipelinetest.DefaultTimeout)
+	for out != nil || dead != nil || errc != nil {
+		select {
+		case <-deadline:
+			t.Fatal("stage did not close its channels")
+		case v, ok := <-out:
+			if !ok {
+				out = nil
+				continue
+			}
+			got = append(got, v)
+		case d, ok := <-dead:
+			if !ok {
+				dead = nil
+				continue
+			}
+			dlq = append(dlq, d)
+		case err, ok := <-errc:
+			if !ok {
+				errc = nil
+				continue
+			}
+			errs = append(errs, err)
+		}
+	}
+	return got, dlq, errs
+}
+
+func TestStageDeadLetters(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, dead, errc := pipeline.StageWithDeadLetters(ctx, pipeline.Source(ctx, pipelinetest.Items(11)), failOnSix, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+
+	got, dlq, errs := collectAll(t, out, dead, errc)
+	if want := []int{0, 2, 4, 6, 8, 10, 14, 16, 18, 20}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if len(dlq) != 1 || dlq[0].Item != 6 || dlq[0].Err == nil {
+		t.Errorf("dead letters = %v, want item 6", dlq)
+	}
+	if len(errs) != 0 {
+		t.Errorf("errors = %v, want none", errs)
+	}
+}
+
+func TestStagePoolDeadLetterCounts(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, dead, errc := pipeline.StagePoolWithDeadLetters(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), 4, failOdd, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+
+	got, dlq, errs := collectAll(t, out, dead, errc)
+	if len(got) != 50 || len(dlq) != 50 || len(errs) != 0 {
+		t.Errorf("got %d items, %d dead letters and errors %v, want 50, 50 and none", len(got), len(dlq), errs)
+	}
+	for _, d := range dlq {
+		if d.Item%2 == 0 {
+			t.Errorf("even item %d was dead-lettered", d.Item)
+		}
+	}
+}
+
+func TestStageDeadLettersFailFastByDefault(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, dead, errc := pipeline.StageWithDeadLetters(ctx, pipeline.Source(ctx, pipelinetest.Items(11)), failOnSix)
+
+	_, dlq, errs := collectAll(t, out, dead, errc)
+	if len(dlq) != 0 || len(errs) != 1 {
+		t.Errorf("got %d dead letters and errors %v, want the failure of 6 on the error channel", len(dlq), errs)
+	}
+}
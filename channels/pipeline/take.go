@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"context"
+
+	"channelspractice/chanutil"
+)
+
+// Take forwards the first n items from in and then closes its output without
+// reading any further. It does not drain in: an upstream that is blocked on a
+// send stays blocked until its context is cancelled, so cancel ctx once the
+// taken stream has been consumed. Every source in this package exits cleanly
+// when that happens.
+func Take[T any](ctx context.Context, in <-chan T, n int, opts ...Option) <-chan T {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	go func() {
+		defer close(out)
+		for range n {
+			item, ok, err := chanutil.RecvContext(ctx, in)
+			if err != nil || !ok {
+				return
+			}
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Skip drops the first n items from in and forwards the rest.
+func Skip[T any](ctx context.Context, in <-chan T, n int, opts ...Option) <-chan T {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	go func() {
+		defer close(out)
+		skipped := 0
+		for item := range OrDone(ctx, in) {
+			if skipped < n {
+				skipped++
+				continue
+			}
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// First blocks until in yields an item and returns it. It returns false if in
+// is closed or ctx is cancelled first.
+func First[T any](ctx context.Context, in <-chan T) (T, bool) {
+	select {
+	case <-ctx.Done():
+		var zero T
+		return zero, false
+	case item, ok := <-in:
+		return item, ok
+	}
+}
@@ -0,0 +1,66 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestTakeStopsEarly(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	// The upstream is left blocked on its next send until cancel releases
+	// it; VerifyNoLeaks checks that it does.
+	defer cancel()
+	got := pipelinetest.CollectWithTimeout(t, pipeline.Take(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), 5), time.Second)
+	if !slices.Equal(got, []int{0, 1, 2, 3, 4}) {
+		t.Errorf("got %v, want [0 1 2 3 4]", got)
+	}
+}
+
+func TestTakeMoreThanAvailable(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := pipelinetest.CollectWithTimeout(t, pipeline.Take(ctx, pipeline.Source(ctx, pipelinetest.Items(3)), 5), time.Second)
+	if !slices.Equal(got, []int{0, 1, 2}) {
+		t.Errorf("got %v, want [0 1 2]", got)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := pipelinetest.CollectWithTimeout(t, pipeline.Skip(ctx, pipeline.Source(ctx, pipelinetest.Items(5)), 3), time.Second)
+	if !slices.Equal(got, []int{3, 4}) {
+		t.Errorf("got %v, want [3 4]", got)
+	}
+}
+
+func TestFirst(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if v, ok := pipeline.First(ctx, pipeline.Source(ctx, []int{7, 8})); !ok || v != 7 {
+		t.Errorf("First = %d, %v, want 7, true", v, ok)
+	}
+
+	closed := make(chan int)
+	close(closed)
+	if _, ok := pipeline.First(ctx, closed); ok {
+		t.Error("First of a closed channel reported an item")
+	}
+}
+
+func TestFirstCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := pipeline.First(ctx, make(chan int)); ok {
+		t.Error("First after cancellation reported an item")
+	}
+}
@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+)
+
+// Tee forwards every item from in to both returned channels. The next item is
+// only read from in after both consumers received the current one, so the
+// slower consumer sets the pace. A consumer that stops reading blocks the tee
+// until ctx is cancelled.
+func Tee[T any](ctx context.Context, in <-chan T, opts ...Option) (<-chan T, <-chan T) {
+	o := newOptions(opts)
+	out1 := make(chan T, o.buffer)
+	out2 := make(chan T, o.buffer)
+	go func() {
+		defer close(out1)
+		defer close(out2)
+		for {
+			var item T
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				item = v
+			}
+
+			o1, o2 := out1, out2
+			for range 2 {
+				select {
+				case <-ctx.Done():
+					return
+				case o1 <- item:
+					o1 = nil
+				case o2 <- item:
+					o2 = nil
+				}
+			}
+		}
+	}()
+	return out1, out2
+}
+
+// TeeN is Tee with n outputs.
+func TeeN[T any](ctx context.Context, in <-chan T, n int, opts ...Option) []<-chan T {
+	o := newOptions(opts)
+	outs := make([]chan T, n)
+	result := make([]<-chan T, n)
+	for i := range outs {
+		outs[i] = make(chan T, o.buffer)
+		result[i] = outs[i]
+	}
+
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+
+		cases := make([]reflect.SelectCase, n+1)
+		cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+		for {
+			var item T
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				item = v
+			}
+
+			value := reflect.ValueOf(&item).Elem()
+			for i, out := range outs {
+				cases[i+1] = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(out), Send: value}
+			}
+			for range n {
+				chosen, _, _ := reflect.Select(cases)
+				if chosen == 0 {
+					return
+				}
+				cases[chosen].Chan = reflect.Value{}
+			}
+		}
+	}()
+
+	return result
+}
@@ -0,0 +1,90 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// consume reads every output concurrently, the ith one sleeping delays[i]
+// per item, and returns what each of them read.
+func consume(outs []<-chan int, delays []time.Duration) [][]int {
+	got := make([][]int, len(outs))
+	var wg sync.WaitGroup
+	wg.Add(len(outs))
+	for i, out := range outs {
+		go func() {
+			defer wg.Done()
+			for v := range out {
+				time.Sleep(delays[i])
+				got[i] = append(got[i], v)
+			}
+		}()
+	}
+	wg.Wait()
+	return got
+}
+
+func TestTeeDifferentSpeeds(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	items := pipelinetest.Items(20)
+	a, b := pipeline.Tee(ctx, pipeline.Source(ctx, items))
+
+	got := consume([]<-chan int{a, b}, []time.Duration{0, time.Millisecond})
+	for i, g := range got {
+		if !slices.Equal(g, items) {
+			t.Errorf("output %d got %v, want every item in order", i, g)
+		}
+	}
+}
+
+func TestTeeConsumerAbandons(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	a, b := pipeline.Tee(ctx, pipeline.Source(ctx, pipelinetest.Items(100)))
+
+	// b takes one item and stops reading, which stalls the tee.
+	<-a
+	<-b
+	<-a
+	cancel()
+	pipelinetest.CollectWithTimeout(t, a, time.Second)
+	pipelinetest.CollectWithTimeout(t, b, time.Second)
+}
+
+func TestTeeNDifferentSpeeds(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	items := pipelinetest.Items(20)
+	outs := pipeline.TeeN(ctx, pipeline.Source(ctx, items), 3)
+
+	got := consume(outs, []time.Duration{0, time.Millisecond, 2 * time.Millisecond})
+	for i, g := range got {
+		if !slices.Equal(g, items) {
+			t.Errorf("output %d got %v, want every item in order", i, g)
+		}
+	}
+}
+
+func TestTeeNConsumerAbandons(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	outs := pipeline.TeeN(ctx, pipeline.Source(ctx, pipelinetest.Items(100)), 3)
+
+	for _, out := range outs {
+		<-out
+	}
+	<-outs[0]
+	cancel()
+	for _, out := range outs {
+		pipelinetest.CollectWithTimeout(t, out, time.Second)
+	}
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"channelspractice/chanutil"
+)
+
+// Throttle forwards items from in no faster than rate items per second using a
+// token bucket that holds up to burst tokens. The bucket starts full, so the
+// first burst items pass through immediately. Tokens refill on the clock set
+// with WithClock.
+func Throttle[T any](ctx context.Context, in <-chan T, rate float64, burst int, opts ...Option) <-chan T {
+	o := newOptions(opts)
+	out := make(chan T, o.buffer)
+	go func() {
+		defer close(out)
+
+		tokens := float64(burst)
+		last := o.clock.Now()
+		timer := o.clock.NewTimer(0)
+		timer.Stop()
+
+		for {
+			var item T
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				item = v
+			}
+
+			now := o.clock.Now()
+			tokens = min(float64(burst), tokens+now.Sub(last).Seconds()*rate)
+			last = now
+			if tokens < 1 {
+				wait := time.Duration((1 - tokens) / rate * float64(time.Second))
+				timer.Reset(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C():
+				}
+				now = o.clock.Now()
+				tokens = min(float64(burst), tokens+now.Sub(last).Seconds()*rate)
+				last = now
+			}
+			tokens--
+
+			if chanutil.SendContext(ctx, out, item) != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
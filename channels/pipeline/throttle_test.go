@@ -0,0 +1,92 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestThrottleRate(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	out := pipeline.Throttle(ctx, pipeline.Source(ctx, pipelinetest.Items(20)), 10, 1, pipeline.WithClock(clock))
+
+	// The first item takes the token the bucket starts with and each of the
+	// other 19 waits 100ms for one.
+	if got := <-out; got != 0 {
+		t.Fatalf("first item %d, want 0", got)
+	}
+	for want := 1; want < 20; want++ {
+		clock.BlockUntil(t, 1)
+		clock.Advance(99 * time.Millisecond)
+		assertNoItem(t, out)
+		clock.Advance(time.Millisecond)
+		select {
+		case got := <-out:
+			if got != want {
+				t.Fatalf("got %d, want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("item %d not released after 100ms", want)
+		}
+	}
+	pipelinetest.AssertClosed(t, out, time.Second)
+}
+
+func TestThrottleBurst(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+
+	// The clock never moves, so only a full bucket lets the items through.
+	out := pipeline.Throttle(ctx, pipeline.Source(ctx, pipelinetest.Items(5)), 1, 5, pipeline.WithClock(clock))
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 5 {
+		t.Fatalf("got %d items, want 5", len(got))
+	}
+}
+
+func TestThrottleRefillsWhileIdle(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	in := make(chan int)
+	defer close(in)
+	out := pipeline.Throttle(ctx, in, 10, 2, pipeline.WithClock(clock))
+
+	for i := range 2 {
+		in <- i
+		<-out
+	}
+	// A second without items refills the bucket, but never beyond burst.
+	clock.Advance(time.Second)
+	for i := range 2 {
+		in <- i
+		if got := <-out; got != i {
+			t.Fatalf("got %d, want %d", got, i)
+		}
+	}
+	in <- 2
+	assertNoItem(t, out)
+}
+
+func TestThrottleCancelWhileWaiting(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := pipelinetest.NewClock(time.Time{})
+	in := make(chan int)
+	out := pipeline.Throttle(ctx, in, 0.1, 1, pipeline.WithClock(clock))
+	in <- 1
+	<-out
+	// The next token is ten seconds away.
+	in <- 2
+	clock.BlockUntil(t, 1)
+	cancel()
+	pipelinetest.AssertClosed(t, out, 100*time.Millisecond)
+}
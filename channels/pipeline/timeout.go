@@ -0,0 +1,51 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WithItemTimeout gives every call of a stage's function its own context,
+// cancelled d after the call started as well as with the pipeline. It applies
+// to Stage, StagePool and Sink, their variants and the sources that fetch
+// through a function, such as Paginate. Unlike WithStageTimeout it waits for
+// the function to return; the error it returns once its context expired is
+// reported wrapped in ErrStageTimeout. The timeout goes by the real clock.
+func WithItemTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.itemTimeout = d
+	}
+}
+
+// WithStageTimeout wraps fn so that every call gets its own context with a
+// timeout of d. When the timeout expires the wrapper returns an error wrapping
+// ErrStageTimeout straight away, even if fn ignores its context. In that case
+// the call keeps running in the background until fn returns by itself.
+func WithStageTimeout[T, U any](fn func(context.Context, T) (U, error), d time.Duration) func(context.Context, T) (U, error) {
+	type result struct {
+		value U
+		err   error
+	}
+	return func(ctx context.Context, item T) (U, error) {
+		callCtx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+
+		done := make(chan result, 1)
+		go func() {
+			value, err := fn(callCtx, item)
+			done <- result{value: value, err: err}
+		}()
+
+		select {
+		case r := <-done:
+			return r.value, r.err
+		case <-callCtx.Done():
+			var zero U
+			if ctx.Err() != nil {
+				return zero, ctx.Err()
+			}
+			return zero, fmt.Errorf("item %v: %w after %v", item, ErrStageTimeout, d)
+		}
+	}
+}
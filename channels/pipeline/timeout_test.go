@@ -0,0 +1,155 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestStageTimeoutHonoringContext(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	fn := pipeline.WithStageTimeout(pipelinetest.IOWork(time.Hour), 20*time.Millisecond)
+
+	_, err := fn(context.Background(), 7)
+	if !errors.Is(err, pipeline.ErrStageTimeout) {
+		t.Fatalf("err = %v, want ErrStageTimeout", err)
+	}
+	if want := "item 7: stage timed out after 20ms"; err.Error() != want {
+		t.Errorf("err = %q, want %q", err, want)
+	}
+}
+
+// TestStageTimeoutIgnoringContext shows the documented leak: the wrapper
+// returns on time, but the call carries on until fn returns by itself.
+func TestStageTimeoutIgnoringContext(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	release, returned := make(chan struct{}), make(chan struct{})
+	fn := pipeline.WithStageTimeout(func(context.Context, int) (int, error) {
+		defer close(returned)
+		<-release
+		return 1, nil
+	}, 20*time.Millisecond)
+
+	start := time.Now()
+	if _, err := fn(context.Background(), 7); !errors.Is(err, pipeline.ErrStageTimeout) {
+		t.Fatalf("err = %v, want ErrStageTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("wrapper returned after %v, want about 20ms", elapsed)
+	}
+	select {
+	case <-returned:
+		t.Fatal("the call stopped although it ignores its context")
+	default:
+	}
+	close(release)
+	pipelinetest.AssertClosed(t, returned, time.Second)
+}
+
+func TestStageTimeoutFastCall(t *testing.T) {
+	fn := pipeline.WithStageTimeout(failOnSix, time.Second)
+	if got, err := fn(context.Background(), 2); err != nil || got != 4 {
+		t.Errorf("fn(2) = %d, %v, want 4", got, err)
+	}
+	if _, err := fn(context.Background(), 6); err == nil || errors.Is(err, pipeline.ErrStageTimeout) {
+		t.Errorf("fn(6) = %v, want the error of fn", err)
+	}
+}
+
+func TestStageTimeoutParentCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fn := pipeline.WithStageTimeout(pipelinetest.IOWork(time.Hour), time.Hour)
+	if _, err := fn(ctx, 7); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestItemTimeoutStage(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Stage(ctx, in, func(ctx context.Context, n int) (int, error) {
+			if n == 2 {
+				<-ctx.Done()
+				return 0, ctx.Err()
+			}
+			return n, nil
+		}, pipeline.WithItemTimeout(20*time.Millisecond), pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	})
+
+	got, errs := pipelinetest.Run(t, stage, []int{1, 2, 3})
+	if !slices.Equal(got, []int{1, 3}) {
+		t.Errorf("got %v, want the items that beat the timeout, [1 3]", got)
+	}
+	var stageErr *pipeline.StageError
+	if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Item != 2 {
+		t.Fatalf("errors = %v, want the timeout of 2", errs)
+	}
+	if !errors.Is(errs[0], pipeline.ErrStageTimeout) || !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Errorf("err = %v, want ErrStageTimeout wrapping the error of fn", errs[0])
+	}
+}
+
+func TestItemTimeoutSink(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, []int{1}), func(ctx context.Context, _ int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, pipeline.WithItemTimeout(20*time.Millisecond))
+
+	errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	pipelinetest.AssertClosed(t, done, time.Second)
+	if len(errs) != 1 || !errors.Is(errs[0], pipeline.ErrStageTimeout) {
+		t.Errorf("errors = %v, want ErrStageTimeout", errs)
+	}
+}
+
+func TestItemTimeoutResetsPerItem(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	// Together the items take longer than the timeout, each alone well
+	// within it.
+	stage := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Stage(ctx, in, func(ctx context.Context, n int) (int, error) {
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-time.After(20 * time.Millisecond):
+				return n, nil
+			}
+		}, pipeline.WithItemTimeout(100*time.Millisecond))
+	})
+	got, errs := pipelinetest.Run(t, stage, pipelinetest.Items(10))
+	if len(errs) > 0 || len(got) != 10 {
+		t.Errorf("got %v and errors %v, want all 10 items", got, errs)
+	}
+}
+
+func TestItemTimeoutNotReportedOnCancellation(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	var fnErr error
+	returned := make(chan struct{})
+	done, errc := pipeline.Sink(ctx, pipeline.Source(ctx, []int{1}), func(ctx context.Context, _ int) error {
+		defer close(returned)
+		close(started)
+		<-ctx.Done()
+		fnErr = ctx.Err()
+		return fnErr
+	}, pipeline.WithItemTimeout(time.Hour))
+	<-started
+	cancel()
+	pipelinetest.AssertClosed(t, done, time.Second)
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+	<-returned
+	if !errors.Is(fnErr, context.Canceled) {
+		t.Errorf("fn saw %v, want the pipeline's context.Canceled", fnErr)
+	}
+}
@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceEntry is what a stage traced with WithTraceBuffer remembers of one
+// item. Seq numbers the items of the stage from 1. Exit is zero while the
+// item is still being worked on.
+type TraceEntry struct {
+	Seq   int64
+	Stage string
+	// Value is the item itself, only formatted by DumpTrace. An item that
+	// is changed after the stage is done with it is shown as it is then.
+	Value any
+	Enter time.Time
+	Exit  time.Time
+	Err   error
+}
+
+func (e TraceEntry) String() string {
+	s := fmt.Sprintf("%s %s #%d %v", e.Enter.Format("15:04:05.000000"), e.Stage, e.Seq, e.Value)
+	switch {
+	case e.Exit.IsZero():
+		return s + " in flight"
+	case e.Err != nil:
+		return fmt.Sprintf("%s failed after %v: %v", s, e.Exit.Sub(e.Enter), e.Err)
+	}
+	return fmt.Sprintf("%s done after %v", s, e.Exit.Sub(e.Enter))
+}
+
+// WithTraceBuffer makes a stage, stage pool or sink remember the last n items
+// it handled, for DumpTrace to print after a failure. Entering and leaving
+// the stage's function are timed by the clock set with WithClock; nothing is
+// formatted until the dump.
+func WithTraceBuffer(n int) Option {
+	return func(o *options) {
+		o.traceSize = n
+	}
+}
+
+// traces holds the trace of every stage that traced an item, by name. A
+// stage replaces the trace of an earlier stage of the same name.
+var traces = struct {
+	mu    sync.Mutex
+	rings map[string]*traceRing
+}{rings: make(map[string]*traceRing)}
+
+// DumpTrace writes the traces of all stages with WithTraceBuffer to w, one
+// entry per line and merged by the time the items entered their stage.
+func DumpTrace(w io.Writer) error {
+	traces.mu.Lock()
+	rings := make([]*traceRing, 0, len(traces.rings))
+	for _, r := range traces.rings {
+		rings = append(rings, r)
+	}
+	traces.mu.Unlock()
+
+	var all []TraceEntry
+	for _, r := range rings {
+		all = r.appendTo(all)
+	}
+	slices.SortFunc(all, func(a, b TraceEntry) int {
+		return cmp.Or(a.Enter.Compare(b.Enter), strings.Compare(a.Stage, b.Stage), cmp.Compare(a.Seq, b.Seq))
+	})
+	for _, e := range all {
+		if _, err := fmt.Fprintln(w, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// traceRing is the trace of one stage, shared by its workers.
+type traceRing struct {
+	register sync.Once
+
+	mu      sync.Mutex
+	entries []TraceEntry
+	seq     int64
+}
+
+func newTraceRing(n int) *traceRing {
+	return &traceRing{entries: make([]TraceEntry, n)}
+}
+
+// enter records that stage started on item and returns the entry's Seq.
+func (r *traceRing) enter(stage string, item any, at time.Time) int64 {
+	r.register.Do(func() {
+		traces.mu.Lock()
+		defer traces.mu.Unlock()
+		traces.rings[stage] = r
+	})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	r.entries[(r.seq-1)%int64(len(r.entries))] = TraceEntry{Seq: r.seq, Stage: stage, Value: item, Enter: at}
+	return r.seq
+}
+
+// exit records that the stage was done with entry seq, unless the entry was
+// overwritten since.
+func (r *traceRing) exit(seq int64, at time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e := &r.entries[(seq-1)%int64(len(r.entries))]; e.Seq == seq {
+		e.Exit, e.Err = at, err
+	}
+}
+
+func (r *traceRing) appendTo(all []TraceEntry) []TraceEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.entries {
+		if e.Seq != 0 {
+			all = append(all, e)
+		}
+	}
+	return all
+}
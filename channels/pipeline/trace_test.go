@@ -0,0 +1,104 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// traceOf returns the lines of the trace dump that are about stage.
+func traceOf(t *testing.T, stage string) []string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := pipeline.DumpTrace(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[1] == stage {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestTraceBufferKeepsLastItems(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	traced := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Stage(ctx, in, double, pipeline.WithName("trace-last"), pipeline.WithTraceBuffer(3))
+	})
+	pipelinetest.Run(t, traced, pipelinetest.Items(10))
+
+	lines := traceOf(t, "trace-last")
+	if len(lines) != 3 {
+		t.Fatalf("trace %q, want the last 3 items", lines)
+	}
+	for i, line := range lines {
+		if want := []string{"trace-last", fmt.Sprintf("#%d", i+8), fmt.Sprint(i + 7), "done"}; !slices.Equal(strings.Fields(line)[1:5], want) {
+			t.Errorf("line %d = %q, want %v", i, line, want)
+		}
+	}
+}
+
+func TestTraceBufferRecordsFailures(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	traced := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Stage(ctx, in, failOnSix, pipeline.WithName("trace-fail"), pipeline.WithTraceBuffer(10),
+			pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+	})
+	pipelinetest.Run(t, traced, pipelinetest.Items(8))
+
+	var failed []string
+	for _, line := range traceOf(t, "trace-fail") {
+		if strings.Contains(line, "failed after") {
+			failed = append(failed, line)
+		}
+	}
+	if len(failed) != 1 || !strings.Contains(failed[0], " #7 6 failed after ") || !strings.HasSuffix(failed[0], ": number 6 is invalid") {
+		t.Errorf("failed entries %q, want the one of 6", failed)
+	}
+}
+
+func TestTraceBufferMergesStages(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, transformErrs := pipeline.Stage(ctx, pipeline.Source(ctx, pipelinetest.Items(5)), double,
+		pipeline.WithName("trace-merge-transform"), pipeline.WithTraceBuffer(5))
+	done, saveErrs := pipeline.Sink(ctx, out, func(context.Context, int) error { return nil },
+		pipeline.WithName("trace-merge-save"), pipeline.WithTraceBuffer(5))
+	if err := pipeline.WaitAll(ctx, done, transformErrs, saveErrs); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := pipeline.DumpTrace(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dump := buf.String()
+	// Every item enters transform before its double enters save.
+	for n := range 5 {
+		transformed := strings.Index(dump, fmt.Sprintf(" trace-merge-transform #%d %d ", n+1, n))
+		saved := strings.Index(dump, fmt.Sprintf(" trace-merge-save #%d %d ", n+1, n*2))
+		if transformed < 0 || saved < 0 || saved < transformed {
+			t.Errorf("item %d at %d in transform and %d in save, want both in that order:\n%s", n, transformed, saved, dump)
+		}
+	}
+}
+
+func TestTraceBufferDisabled(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	plain := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Stage(ctx, in, double, pipeline.WithName("trace-off"))
+	})
+	pipelinetest.Run(t, plain, pipelinetest.Items(3))
+	if lines := traceOf(t, "trace-off"); len(lines) > 0 {
+		t.Errorf("trace %q of a stage without a trace buffer", lines)
+	}
+}
@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+)
+
+// WalkFiles walks the tree rooted at root with filepath.WalkDir and emits the
+// path of every entry match accepts; a nil match accepts regular files. A walk
+// error, such as an unreadable directory, stops the walk and is sent on the
+// error channel. Under the SkipAndReport policy walk errors are sent and the
+// walk carries on, up to the limit set with WithMaxErrors. The walk is aborted
+// as soon as ctx is cancelled. Both channels are closed when the walk ends.
+func WalkFiles(ctx context.Context, root string, match func(path string, d fs.DirEntry) bool, opts ...Option) (<-chan string, <-chan error) {
+	o := newOptions(opts)
+	if match == nil {
+		match = func(_ string, d fs.DirEntry) bool {
+			return d.Type().IsRegular()
+		}
+	}
+	out := make(chan string, o.buffer)
+	errc := o.newErrc()
+	unregister := o.register("source")
+	name := o.stageName("source")
+	o.logStart(ctx, name)
+
+	go func() {
+		defer unregister()
+		defer o.logStop(ctx, name)
+		defer close(errc)
+		defer close(out)
+
+		var errCount int64
+		walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				if o.errorPolicy != SkipAndReport {
+					return err
+				}
+				errCount++
+				if limitErr := o.checkErrorCount(errCount, err); limitErr != nil {
+					return limitErr
+				}
+				if !o.reportErr(ctx, errc, &StageError{Stage: name, Err: err}) {
+					return ctx.Err()
+				}
+				return nil
+			}
+			if !match(path, d) {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- path:
+			}
+			return nil
+		})
+		if walkErr == nil || ctx.Err() != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case errc <- &StageError{Stage: name, Err: walkErr}:
+		}
+	}()
+	return out, errc
+}
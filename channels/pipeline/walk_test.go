@@ -0,0 +1,144 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// makeTree creates the given files, with their directories, under a new
+// temporary directory and returns it.
+func makeTree(t *testing.T, files ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, f := range files {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(f), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// unreadableDir makes dir unreadable for the rest of the test.
+func unreadableDir(t *testing.T, dir string) {
+	t.Helper()
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any directory")
+	}
+	if err := os.Chmod(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0o755) })
+}
+
+func TestWalkFilesNestedTree(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	root := makeTree(t, "a.txt", "sub/b.txt", "sub/deeper/c.log", "sub/deeper/d.txt")
+	match := func(path string, d fs.DirEntry) bool {
+		return !d.IsDir() && filepath.Ext(path) == ".txt"
+	}
+	out, errc := pipeline.WalkFiles(ctx, root, match)
+
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	want := []string{filepath.Join(root, "a.txt"), filepath.Join(root, "sub/b.txt"), filepath.Join(root, "sub/deeper/d.txt")}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestWalkFilesUnreadableDirFails(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	root := makeTree(t, "a/1.txt", "b/2.txt", "c/3.txt")
+	unreadableDir(t, filepath.Join(root, "b"))
+	out, errc := pipeline.WalkFiles(ctx, root, nil)
+
+	got, errs := collectBoth(t, out, errc)
+	if len(errs) != 1 || !errors.Is(errs[0], fs.ErrPermission) {
+		t.Errorf("errors = %v, want one permission error", errs)
+	}
+	if len(got) != 1 {
+		t.Errorf("got %v, want the walk to stop at b", got)
+	}
+}
+
+func TestWalkFilesUnreadableDirSkipped(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	root := makeTree(t, "a/1.txt", "b/2.txt", "c/3.txt")
+	unreadableDir(t, filepath.Join(root, "b"))
+	out, errc := pipeline.WalkFiles(ctx, root, nil, pipeline.WithErrorPolicy(pipeline.SkipAndReport))
+
+	got, errs := collectBoth(t, out, errc)
+	if len(errs) != 1 || !errors.Is(errs[0], fs.ErrPermission) {
+		t.Errorf("errors = %v, want one permission error", errs)
+	}
+	if len(got) != 2 {
+		t.Errorf("got %v, want the files in a and c", got)
+	}
+}
+
+func TestWalkFilesCancelMidWalk(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	var files []string
+	for i := range 50 {
+		files = append(files, filepath.Join("dir", string(rune('a'+i%26)), "f"+string(rune('a'+i/26))+".txt"))
+	}
+	out, errc := pipeline.WalkFiles(ctx, makeTree(t, files...), nil)
+
+	for range 3 {
+		<-out
+	}
+	cancel()
+	if rest := pipelinetest.CollectWithTimeout(t, out, time.Second); len(rest) > 1 {
+		t.Errorf("walk emitted %d more paths after cancellation", len(rest))
+	}
+	pipelinetest.CollectWithTimeout(t, errc, time.Second)
+}
+
+// collectBoth reads out and errc at the same time until both are closed.
+func collectBoth[T any](t *testing.T, out <-chan T, errc <-chan error) ([]T, []error) {
+	t.Helper()
+	var got []T
+	var errs []error
+	deadline := time.After(pipelinetest.DefaultTimeout)
+	for out != nil || errc != nil {
+		select {
+		case <-deadline:
+			t.Fatal("channels not closed")
+		case v, ok := <-out:
+			if !ok {
+				out = nil
+				continue
+			}
+			got = append(got, v)
+		case err, ok := <-errc:
+			if !ok {
+				errc = nil
+				continue
+			}
+			errs = append(errs, err)
+		}
+	}
+	return got, errs
+}
@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// WithSkipEmptyWindows makes the window stages skip windows without items
+// instead of calling agg with an empty slice.
+func WithSkipEmptyWindows() Option {
+	return func(o *options) {
+		o.skipEmptyWindows = true
+	}
+}
+
+// WindowTumbling groups the items from in into consecutive windows of length d
+// and emits agg of every window on its boundary, including empty windows unless
+// WithSkipEmptyWindows is given. The final partial window is flushed when in
+// is closed, if it holds any items. Boundaries follow the clock set with
+// WithClock.
+func WindowTumbling[T, A any](ctx context.Context, in <-chan T, d time.Duration, agg func([]T) A, opts ...Option) <-chan A {
+	o := newOptions(opts)
+	out := make(chan A, o.buffer)
+	go func() {
+		defer close(out)
+		ticker := o.clock.NewTicker(d)
+		defer ticker.Stop()
+
+		var window []T
+		emit := func() bool {
+			items := window
+			window = nil
+			return sendAggregate(ctx, out, items, agg, o)
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-in:
+				if !ok {
+					if len(window) > 0 {
+						emit()
+					}
+					return
+				}
+				window = append(window, item)
+			case <-ticker.C():
+				if !emit() {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// WindowSliding emits, every step, agg of the items from in that arrived within
+// the last size. Windows overlap when step is shorter than size. Empty windows
+// are emitted unless WithSkipEmptyWindows is given. The final window is
+// flushed when in is closed, if it holds any items. Arrival times and steps
+// are read from the clock set with WithClock.
+func WindowSliding[T, A any](ctx context.Context, in <-chan T, size, step time.Duration, agg func([]T) A, opts ...Option) <-chan A {
+	o := newOptions(opts)
+	out := make(chan A, o.buffer)
+	type stamped struct {
+		at   time.Time
+		item T
+	}
+	go func() {
+		defer close(out)
+		ticker := o.clock.NewTicker(step)
+		defer ticker.Stop()
+
+		var seen []stamped
+		window := func(now time.Time) []T {
+			start := now.Add(-size)
+			drop := 0
+			for drop < len(seen) && !seen[drop].at.After(start) {
+				drop++
+			}
+			seen = seen[drop:]
+			items := make([]T, len(seen))
+			for i, s := range seen {
+				items[i] = s.item
+			}
+			return items
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-in:
+				if !ok {
+					if items := window(o.clock.Now()); len(items) > 0 {
+						sendAggregate(ctx, out, items, agg, o)
+					}
+					return
+				}
+				seen = append(seen, stamped{at: o.clock.Now(), item: item})
+			case now := <-ticker.C():
+				if !sendAggregate(ctx, out, window(now), agg, o) {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// sendAggregate sends agg(items) on out, unless items is empty and empty
+// windows are skipped. It reports false if ctx was cancelled first.
+func sendAggregate[T, A any](ctx context.Context, out chan<- A, items []T, agg func([]T) A, o options) bool {
+	if len(items) == 0 && o.skipEmptyWindows {
+		return true
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case out <- agg(items):
+		return true
+	}
+}
@@ -0,0 +1,115 @@
+package pipeline_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func sum(items []int) int {
+	total := 0
+	for _, n := range items {
+		total += n
+	}
+	return total
+}
+
+func TestWindowTumblingBoundaries(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	in := make(chan int)
+	out := pipeline.WindowTumbling(ctx, in, time.Second, slices.Clone[[]int], pipeline.WithClock(clock))
+	clock.BlockUntil(t, 1)
+
+	in <- 1
+	in <- 2
+	assertNoItem(t, out)
+	clock.Advance(time.Second)
+	if got := <-out; !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("first window = %v, want [1 2]", got)
+	}
+	clock.Advance(time.Second)
+	if got := <-out; len(got) != 0 {
+		t.Errorf("second window = %v, want it empty", got)
+	}
+	in <- 3
+	close(in)
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 1 || !slices.Equal(got[0], []int{3}) {
+		t.Errorf("final windows = %v, want the partial window [3]", got)
+	}
+}
+
+func TestWindowTumblingSkipEmpty(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	in := make(chan int)
+	out := pipeline.WindowTumbling(ctx, in, time.Second, sum, pipeline.WithClock(clock), pipeline.WithSkipEmptyWindows())
+	clock.BlockUntil(t, 1)
+
+	clock.Advance(3 * time.Second)
+	assertNoItem(t, out)
+	in <- 4
+	clock.Advance(time.Second)
+	if got := <-out; got != 4 {
+		t.Errorf("window = %d, want 4", got)
+	}
+	close(in)
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 0 {
+		t.Errorf("flushed %v, want no empty final window", got)
+	}
+}
+
+func TestWindowSlidingOverlaps(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := pipelinetest.NewClock(time.Time{})
+	in := make(chan int)
+	out := pipeline.WindowSliding(ctx, in, 2*time.Second, time.Second, slices.Clone[[]int], pipeline.WithClock(clock))
+	clock.BlockUntil(t, 1)
+
+	// Items arrive half way between steps, so each one is in two windows.
+	steps := []struct {
+		send int
+		want []int
+	}{
+		{send: 1, want: []int{1}},
+		{send: 2, want: []int{1, 2}},
+		{want: []int{2}},
+		{want: []int{}},
+	}
+	for i, step := range steps {
+		clock.Advance(time.Second / 2)
+		if step.send != 0 {
+			in <- step.send
+		}
+		clock.Advance(time.Second / 2)
+		if got := <-out; !slices.Equal(got, step.want) {
+			t.Errorf("window %d = %v, want %v", i+1, got, step.want)
+		}
+	}
+	in <- 3
+	close(in)
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 1 || !slices.Equal(got[0], []int{3}) {
+		t.Errorf("final windows = %v, want [3]", got)
+	}
+}
+
+func TestWindowCancelExitsPromptly(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	tumbling := pipeline.WindowTumbling(ctx, in, time.Hour, sum)
+	sliding := pipeline.WindowSliding(ctx, in, time.Hour, time.Hour, sum)
+	cancel()
+	pipelinetest.CollectWithTimeout(t, tumbling, time.Second)
+	pipelinetest.CollectWithTimeout(t, sliding, time.Second)
+}
@@ -0,0 +1,78 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+
+	"channelspractice/chanutil"
+)
+
+// Pair holds the items Zip took from the same position of its inputs.
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// WithStrictLength makes Zip report an error wrapping ErrLengthMismatch when
+// one input closes before the other instead of silently dropping the rest of
+// the longer one.
+func WithStrictLength() Option {
+	return func(o *options) {
+		o.strictLength = true
+	}
+}
+
+// Zip pairs the i-th item from a with the i-th item from b and closes once
+// either input closes. It waits for the slower input, so a stalled side holds
+// back the pairs until ctx is cancelled. The error channel only carries the
+// mismatch reported under WithStrictLength.
+func Zip[A, B any](ctx context.Context, a <-chan A, b <-chan B, opts ...Option) (<-chan Pair[A, B], <-chan error) {
+	o := newOptions(opts)
+	out := make(chan Pair[A, B], o.buffer)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(out)
+		mismatch := func(n int, shorter, longer string) {
+			if o.strictLength {
+				errc <- &StageError{Stage: o.stageName("zip"), Err: fmt.Errorf("%w: %s closed after %d items, %s has more", ErrLengthMismatch, shorter, n, longer)}
+			}
+		}
+
+		for n := 0; ; n++ {
+			var pair Pair[A, B]
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case pair.First, ok = <-a:
+			}
+			if !ok {
+				// Only a strict Zip looks whether b was longer.
+				if o.strictLength {
+					select {
+					case <-ctx.Done():
+					case _, more := <-b:
+						if more {
+							mismatch(n, "a", "b")
+						}
+					}
+				}
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case pair.Second, ok = <-b:
+			}
+			if !ok {
+				mismatch(n, "b", "a")
+				return
+			}
+			if chanutil.SendContext(ctx, out, pair) != nil {
+				return
+			}
+		}
+	}()
+	return out, errc
+}
@@ -0,0 +1,87 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+func TestZipEqualLengths(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out, errc := pipeline.Zip(ctx, pipeline.Source(ctx, []string{"a", "b", "c"}), pipeline.Source(ctx, []int{1, 2, 3}), pipeline.WithStrictLength())
+	got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+	want := []pipeline.Pair[string, int]{{"a", 1}, {"b", 2}, {"c", 3}}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestZipUnequalLengths(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		a, b   []int
+		strict bool
+	}{
+		{"a shorter", []int{1, 2}, []int{1, 2, 3, 4}, false},
+		{"b shorter", []int{1, 2, 3, 4}, []int{1, 2}, false},
+		{"a shorter strict", []int{1, 2}, []int{1, 2, 3, 4}, true},
+		{"b shorter strict", []int{1, 2, 3, 4}, []int{1, 2}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var opts []pipeline.Option
+			if tc.strict {
+				opts = append(opts, pipeline.WithStrictLength())
+			}
+
+			out, errc := pipeline.Zip(ctx, pipeline.Source(ctx, tc.a), pipeline.Source(ctx, tc.b), opts...)
+			// A strict Zip sends its error after the last pair, and the
+			// error channel is buffered, so the pairs can be read first.
+			got := pipelinetest.CollectWithTimeout(t, out, time.Second)
+			if want := []pipeline.Pair[int, int]{{1, 1}, {2, 2}}; !slices.Equal(got, want) {
+				t.Errorf("got %v, want the first 2 pairs", got)
+			}
+			errs := pipelinetest.CollectWithTimeout(t, errc, time.Second)
+			if !tc.strict {
+				if len(errs) != 0 {
+					t.Errorf("errors = %v, want the longer input truncated", errs)
+				}
+				return
+			}
+			var stageErr *pipeline.StageError
+			if len(errs) != 1 || !errors.As(errs[0], &stageErr) || stageErr.Stage != "zip" || !errors.Is(errs[0], pipeline.ErrLengthMismatch) {
+				t.Errorf("errors = %v, want one ErrLengthMismatch", errs)
+			}
+		})
+	}
+}
+
+func TestZipCancelWhileStalled(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// b never delivers anything.
+	out, errc := pipeline.Zip(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), make(chan int))
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if got := pipelinetest.CollectWithTimeout(t, out, time.Second); len(got) != 0 {
+		t.Errorf("got %v without a second item", got)
+	}
+	if errs := pipelinetest.CollectWithTimeout(t, errc, time.Second); len(errs) != 0 {
+		t.Errorf("errors = %v, want none for a cancellation", errs)
+	}
+}
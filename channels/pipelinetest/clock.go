@@ -0,0 +1,209 @@
+package pipelinetest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// Clock is a pipeline.Clock whose time stands still until Advance moves it.
+// Hand it to a stage with pipeline.WithClock:
+//
+//	clock := pipelinetest.NewClock(time.Time{})
+//	out := pipeline.Debounce(ctx, in, time.Second, pipeline.WithClock(clock))
+//	in <- 1
+//	clock.BlockUntil(t, 1) // the stage has armed its timer
+//	clock.Advance(time.Second)
+//	// out now delivers 1
+type Clock struct {
+	// advancing makes Advance calls take turns.
+	advancing sync.Mutex
+
+	mu      sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	waiters map[*clockWaiter]bool
+	// armed counts the timers and tickers armed so far.
+	armed uint64
+}
+
+// clockWaiter is a pending timer, or a ticker, which is pending until stopped.
+type clockWaiter struct {
+	clock    *Clock
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+// NewClock returns a manual clock set to start.
+func NewClock(start time.Time) *Clock {
+	c := &Clock{now: start, waiters: make(map[*clockWaiter]bool)}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the time the clock is set to.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer returns a timer that fires once the clock is advanced by d.
+func (c *Clock) NewTimer(d time.Duration) pipeline.Timer {
+	w := &clockWaiter{clock: c, c: make(chan time.Time, 1)}
+	w.Reset(d)
+	return w
+}
+
+// NewTicker returns a ticker that fires every time the clock passes another
+// multiple of d. Like time.Ticker it drops the ticks nobody was ready for.
+func (c *Clock) NewTicker(d time.Duration) pipeline.Ticker {
+	if d <= 0 {
+		panic("pipelinetest: non-positive interval for NewTicker")
+	}
+	w := &clockWaiter{clock: c, c: make(chan time.Time, 1), period: d}
+	w.Reset(d)
+	return clockTicker{w}
+}
+
+// After returns the channel of a timer that fires once the clock is advanced
+// by d.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// settleTimeout is how long Advance gives the receiver of a timer to react
+// to it by arming one.
+const settleTimeout = 5 * time.Millisecond
+
+// Advance moves the clock forward by d, firing every timer and ticker that
+// falls due on the way in deadline order. The clock stands at the deadline of
+// each one while it fires, and after delivering a value Advance waits for a
+// timer to be armed, for up to settleTimeout. A timer armed then, as a stage
+// does after every tick, is measured from that deadline and fires within the
+// same Advance if it falls due before its end, as it would in real time.
+func (c *Clock) Advance(d time.Duration) {
+	c.advancing.Lock()
+	defer c.advancing.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		var next *clockWaiter
+		for w := range c.waiters {
+			if !w.deadline.After(end) && (next == nil || w.deadline.Before(next.deadline)) {
+				next = w
+			}
+		}
+		if next == nil {
+			break
+		}
+		c.now = next.deadline
+		delivered := false
+		select {
+		case next.c <- c.now:
+			delivered = true
+		default:
+		}
+		if next.period > 0 {
+			next.deadline = next.deadline.Add(next.period)
+		} else {
+			delete(c.waiters, next)
+		}
+		if delivered {
+			c.settle()
+		}
+	}
+	c.now = end
+	c.changed.Broadcast()
+}
+
+// settle waits, with c.mu held, until a timer is armed or settleTimeout
+// passed.
+func (c *Clock) settle() {
+	armed := c.armed
+	wake := time.AfterFunc(settleTimeout, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.changed.Broadcast()
+	})
+	defer wake.Stop()
+	deadline := time.Now().Add(settleTimeout)
+	c.changed.Broadcast()
+	for c.armed == armed && time.Now().Before(deadline) {
+		c.changed.Wait()
+	}
+}
+
+// BlockUntil waits until n timers and tickers are pending, which tells the
+// test that a stage has armed the timer it is about to wait on. It fails the
+// test if that takes longer than DefaultTimeout.
+func (c *Clock) BlockUntil(t testing.TB, n int) {
+	t.Helper()
+	timeout := time.AfterFunc(DefaultTimeout, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.changed.Broadcast()
+	})
+	defer timeout.Stop()
+	deadline := time.Now().Add(DefaultTimeout)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers pending after %v, want %d\n\n%s", len(c.waiters), DefaultTimeout, n, stacks())
+		}
+		c.changed.Wait()
+	}
+}
+
+func (w *clockWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *clockWaiter) Stop() bool {
+	c := w.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := c.waiters[w]
+	delete(c.waiters, w)
+	w.drain()
+	c.changed.Broadcast()
+	return pending
+}
+
+func (w *clockWaiter) Reset(d time.Duration) bool {
+	c := w.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := c.waiters[w]
+	w.drain()
+	w.deadline = c.now.Add(d)
+	c.armed++
+	if d <= 0 && w.period == 0 {
+		// Due right away, as with time.NewTimer(0).
+		w.c <- c.now
+		delete(c.waiters, w)
+	} else {
+		c.waiters[w] = true
+	}
+	c.changed.Broadcast()
+	return pending
+}
+
+type clockTicker struct{ *clockWaiter }
+
+func (t clockTicker) Stop() {
+	t.clockWaiter.Stop()
+}
+
+func (w *clockWaiter) drain() {
+	select {
+	case <-w.c:
+	default:
+	}
+}
@@ -0,0 +1,158 @@
+package pipelinetest_test
+
+import (
+	"testing"
+	"time"
+
+	"channelspractice/pipelinetest"
+)
+
+// epoch is where the clocks of these tests start.
+var epoch = time.Unix(0, 0)
+
+// fired returns what ch delivers, failing the test if it delivers nothing.
+func fired(t *testing.T, ch <-chan time.Time) time.Time {
+	t.Helper()
+	select {
+	case at := <-ch:
+		return at
+	default:
+		t.Fatal("timer did not fire")
+		return time.Time{}
+	}
+}
+
+func notFired(t *testing.T, ch <-chan time.Time) {
+	t.Helper()
+	select {
+	case at := <-ch:
+		t.Fatalf("timer fired at %v", at)
+	default:
+	}
+}
+
+func TestClockTimer(t *testing.T) {
+	clock := pipelinetest.NewClock(epoch)
+	timer := clock.NewTimer(time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	notFired(t, timer.C())
+	clock.Advance(time.Second)
+	if at := fired(t, timer.C()); !at.Equal(epoch.Add(time.Second)) {
+		t.Errorf("fired at %v, want its deadline %v", at, epoch.Add(time.Second))
+	}
+	if now := clock.Now(); !now.Equal(epoch.Add(1999 * time.Millisecond)) {
+		t.Errorf("Now = %v after advancing 1.999s", now)
+	}
+	clock.Advance(time.Hour)
+	notFired(t, timer.C())
+}
+
+func TestClockTimerStopAndReset(t *testing.T) {
+	clock := pipelinetest.NewClock(epoch)
+	timer := clock.NewTimer(time.Second)
+	if !timer.Stop() {
+		t.Error("Stop of a pending timer = false")
+	}
+	clock.Advance(time.Second)
+	notFired(t, timer.C())
+	if timer.Stop() {
+		t.Error("Stop of a stopped timer = true")
+	}
+
+	timer.Reset(time.Minute)
+	clock.Advance(time.Second)
+	// Resetting a pending timer moves its deadline.
+	if !timer.Reset(time.Second) {
+		t.Error("Reset of a pending timer = false")
+	}
+	clock.Advance(time.Second)
+	if at := fired(t, timer.C()); !at.Equal(epoch.Add(3 * time.Second)) {
+		t.Errorf("fired at %v, want %v", at, epoch.Add(3*time.Second))
+	}
+}
+
+func TestClockTimerZeroFiresNow(t *testing.T) {
+	clock := pipelinetest.NewClock(epoch)
+	fired(t, clock.NewTimer(0).C())
+	fired(t, clock.After(-time.Second))
+}
+
+func TestClockAfter(t *testing.T) {
+	clock := pipelinetest.NewClock(epoch)
+	after := clock.After(time.Minute)
+	clock.Advance(time.Minute)
+	if at := fired(t, after); !at.Equal(epoch.Add(time.Minute)) {
+		t.Errorf("fired at %v, want %v", at, epoch.Add(time.Minute))
+	}
+}
+
+func TestClockTicker(t *testing.T) {
+	clock := pipelinetest.NewClock(epoch)
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for i := 1; i <= 3; i++ {
+		clock.Advance(time.Second)
+		if at := fired(t, ticker.C()); !at.Equal(epoch.Add(time.Duration(i) * time.Second)) {
+			t.Errorf("tick %d at %v", i, at)
+		}
+	}
+	// Like time.Ticker it keeps one tick for a slow receiver and drops the
+	// rest.
+	clock.Advance(5 * time.Second)
+	if at := fired(t, ticker.C()); !at.Equal(epoch.Add(4 * time.Second)) {
+		t.Errorf("kept tick at %v, want the first one missed, at 4s", at)
+	}
+	notFired(t, ticker.C())
+
+	ticker.Stop()
+	clock.Advance(time.Minute)
+	notFired(t, ticker.C())
+}
+
+func TestClockTimerArmedDuringAdvance(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	clock := pipelinetest.NewClock(epoch)
+	timer := clock.NewTimer(time.Second)
+	// Every time the timer fires the receiver arms it again, as a stage
+	// waiting out a series of delays does.
+	chain := make(chan time.Time, 5)
+	go func() {
+		defer close(chain)
+		for range 5 {
+			at := <-timer.C()
+			chain <- at
+			timer.Reset(time.Second)
+		}
+	}()
+
+	clock.Advance(5 * time.Second)
+	var got []time.Time
+	for at := range chain {
+		got = append(got, at)
+	}
+	if len(got) != 5 {
+		t.Fatalf("timer fired %d times within one Advance of 5s, want 5", len(got))
+	}
+	for i, at := range got {
+		if want := epoch.Add(time.Duration(i+1) * time.Second); !at.Equal(want) {
+			t.Errorf("firing %d at %v, want %v", i+1, at, want)
+		}
+	}
+	timer.Stop()
+}
+
+func TestClockBlockUntil(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	clock := pipelinetest.NewClock(epoch)
+	armed := make(chan struct{})
+	go func() {
+		defer close(armed)
+		time.Sleep(10 * time.Millisecond)
+		clock.NewTimer(time.Second)
+		clock.NewTimer(time.Second)
+	}()
+	clock.BlockUntil(t, 2)
+	<-armed
+}
@@ -0,0 +1,100 @@
+package pipelinetest
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// LeakTimeout is how long VerifyNoLeaks waits for goroutines to exit.
+var LeakTimeout = time.Second
+
+// allowedGoroutines are stack substrings of goroutines owned by the runtime or
+// the testing package, which are never reported as leaks.
+var allowedGoroutines = []string{
+	"testing.(*T).Run",
+	"testing.(*M).",
+	"testing.tRunner",
+	"testing.runTests",
+	"os/signal.signal_recv",
+	"os/signal.loop",
+	"runtime.ensureSigM",
+	"runtime.goexit1",
+}
+
+// VerifyNoLeaks fails the test if, once it and its cleanups have finished,
+// goroutines started after the call are still running. Goroutines that exit
+// within LeakTimeout are fine, as are those whose stack contains one of
+// ignore. Call it first thing in a test:
+//
+//	func TestStage(t *testing.T) {
+//		pipelinetest.VerifyNoLeaks(t)
+//		...
+//	}
+func VerifyNoLeaks(t testing.TB, ignore ...string) {
+	t.Helper()
+	before := make(map[string]bool)
+	for _, g := range goroutines() {
+		before[g.id] = true
+	}
+	t.Cleanup(func() {
+		t.Helper()
+		var leaked []goroutine
+		deadline := time.Now().Add(LeakTimeout)
+		for delay := time.Millisecond; ; delay = min(2*delay, 100*time.Millisecond) {
+			leaked = leaked[:0]
+			for _, g := range goroutines() {
+				if !before[g.id] && !g.allowed(ignore) {
+					leaked = append(leaked, g)
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(delay)
+		}
+		if len(leaked) == 0 {
+			return
+		}
+		stacks := make([]string, len(leaked))
+		for i, g := range leaked {
+			stacks[i] = g.stack
+		}
+		t.Errorf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(stacks, "\n\n"))
+	})
+}
+
+type goroutine struct {
+	id    string
+	stack string
+}
+
+func (g goroutine) allowed(ignore []string) bool {
+	for _, s := range allowedGoroutines {
+		if strings.Contains(g.stack, s) {
+			return true
+		}
+	}
+	for _, s := range ignore {
+		if strings.Contains(g.stack, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// goroutines returns every goroutine but the calling one.
+func goroutines() []goroutine {
+	all := strings.Split(string(stacks()), "\n\n")
+	// The first stack is always the calling goroutine.
+	gs := make([]goroutine, 0, len(all))
+	for _, stack := range all[1:] {
+		header, _, _ := strings.Cut(stack, "\n")
+		id, _, ok := strings.Cut(strings.TrimPrefix(header, "goroutine "), " ")
+		if !ok {
+			continue
+		}
+		gs = append(gs, goroutine{id: id, stack: stack})
+	}
+	return gs
+}
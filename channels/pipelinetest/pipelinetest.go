@@ -0,0 +1,118 @@
+// Package pipelinetest helps testing pipelines built from the pipeline
+// package without hanging the test binary when a stage deadlocks. Every helper
+// takes a timeout, or uses DefaultTimeout, and fails the test with the
+// goroutine stacks once it expires.
+//
+// The lesson_019 pipeline, with transform failing on 6, checks out like this:
+//
+//	transform := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+//		return pipeline.Stage(ctx, in, double)
+//	})
+//	out, errs := pipelinetest.Run(t, transform, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+//	// out is [0 2 4 6 8 10], errs holds the item 6 failure.
+package pipelinetest
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+)
+
+// DefaultTimeout bounds Run.
+var DefaultTimeout = 5 * time.Second
+
+// Stage is a part of a pipeline that can be run on its own: it reads from in
+// and returns its output and error channels.
+type Stage[T, U any] func(ctx context.Context, in <-chan T) (<-chan U, <-chan error)
+
+// StageOf converts a function to a Stage, which lets the type parameters be
+// inferred.
+func StageOf[T, U any](fn func(ctx context.Context, in <-chan T) (<-chan U, <-chan error)) Stage[T, U] {
+	return fn
+}
+
+// Chain runs second on the output of first. The errors of both are merged.
+func Chain[T, U, V any](first Stage[T, U], second Stage[U, V]) Stage[T, V] {
+	return func(ctx context.Context, in <-chan T) (<-chan V, <-chan error) {
+		mid, firstErrs := first(ctx, in)
+		out, secondErrs := second(ctx, mid)
+		return out, pipeline.Merge(ctx, firstErrs, secondErrs)
+	}
+}
+
+// Run feeds input to stage and collects everything it emits until both its
+// channels are closed. If that takes longer than DefaultTimeout the test
+// fails with the stacks of all goroutines, which shows the stage that is
+// stuck.
+func Run[T, U any](t testing.TB, stage Stage[T, U], input []T) (output []U, errs []error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out, errc := stage(ctx, pipeline.Source(ctx, input))
+	deadline := time.NewTimer(DefaultTimeout)
+	defer deadline.Stop()
+	for out != nil || errc != nil {
+		select {
+		case <-deadline.C:
+			t.Fatalf("pipeline did not finish within %v\n%s", DefaultTimeout, stacks())
+			return output, errs
+		case v, ok := <-out:
+			if !ok {
+				out = nil
+				continue
+			}
+			output = append(output, v)
+		case err, ok := <-errc:
+			if !ok {
+				errc = nil
+				continue
+			}
+			errs = append(errs, err)
+		}
+	}
+	return output, errs
+}
+
+// CollectWithTimeout reads ch until it is closed and returns what it read. The
+// test fails if ch is not closed within d.
+func CollectWithTimeout[T any](t testing.TB, ch <-chan T, d time.Duration) []T {
+	t.Helper()
+	var items []T
+	deadline := time.NewTimer(d)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-deadline.C:
+			t.Fatalf("channel not closed within %v after %d items\n%s", d, len(items), stacks())
+			return items
+		case item, ok := <-ch:
+			if !ok {
+				return items
+			}
+			items = append(items, item)
+		}
+	}
+}
+
+// AssertClosed fails the test unless ch is closed within d. Items still
+// arriving on ch are a failure as well.
+func AssertClosed[T any](t testing.TB, ch <-chan T, d time.Duration) {
+	t.Helper()
+	select {
+	case item, ok := <-ch:
+		if ok {
+			t.Fatalf("expected closed channel, got %v", item)
+		}
+	case <-time.After(d):
+		t.Fatalf("channel not closed within %v\n%s", d, stacks())
+	}
+}
+
+func stacks() []byte {
+	buf := make([]byte, 1<<20)
+	return buf[:runtime.Stack(buf, true)]
+}
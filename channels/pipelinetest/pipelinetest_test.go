@@ -0,0 +1,180 @@
+package pipelinetest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+// fakeTB records failures instead of failing the test. Fatalf stops the
+// calling goroutine like testing.T does, so run helpers through capture.
+type fakeTB struct {
+	testing.TB
+	mu       sync.Mutex
+	failures []string
+	cleanups []func()
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) Fatalf(format string, args ...any) {
+	f.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+func (f *fakeTB) Cleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+
+// capture runs fn against a fakeTB, then its cleanups, and returns the
+// failures. The real test fails if fn hangs.
+func capture(t *testing.T, fn func(tb testing.TB)) []string {
+	t.Helper()
+	tb := &fakeTB{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(tb)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("helper hung instead of failing")
+	}
+	for _, cleanup := range slices.Backward(tb.cleanups) {
+		cleanup()
+	}
+	return tb.failures
+}
+
+// stuck never closes its channels until release is closed, like a stage
+// that deadlocked.
+func stuck(release <-chan struct{}) pipelinetest.Stage[int, int] {
+	return func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		out := make(chan int)
+		errc := make(chan error)
+		go func() {
+			defer close(errc)
+			defer close(out)
+			<-in
+			<-release
+		}()
+		return out, errc
+	}
+}
+
+func shortTimeout(t *testing.T) {
+	old := pipelinetest.DefaultTimeout
+	pipelinetest.DefaultTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { pipelinetest.DefaultTimeout = old })
+}
+
+func TestRunFailsOnDeadlock(t *testing.T) {
+	shortTimeout(t)
+	release := make(chan struct{})
+	defer close(release)
+
+	start := time.Now()
+	failures := capture(t, func(tb testing.TB) {
+		pipelinetest.Run(tb, stuck(release), pipelinetest.Items(10))
+		tb.Errorf("Run returned from a deadlocked stage")
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run took %v to give up, want about DefaultTimeout", elapsed)
+	}
+	if len(failures) != 1 || !strings.Contains(failures[0], "did not finish within 50ms") {
+		t.Fatalf("failures = %q, want one timeout", failures)
+	}
+	// The stacks show where the stage is stuck.
+	if !strings.Contains(failures[0], "pipelinetest_test.stuck") {
+		t.Errorf("failure does not show the stuck stage:\n%s", failures[0])
+	}
+}
+
+func TestRunCollectsOutputAndErrors(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	transform := pipelinetest.StageOf(func(ctx context.Context, in <-chan int) (<-chan int, <-chan error) {
+		return pipeline.Stage(ctx, in, func(_ context.Context, n int) (int, error) {
+			if n == 6 {
+				return 0, errors.New("bad input")
+			}
+			return n * 2, nil
+		}, pipeline.WithBuffer(11))
+	})
+
+	out, errs := pipelinetest.Run(t, transform, pipelinetest.Items(11))
+	if want := []int{0, 2, 4, 6, 8, 10}; !slices.Equal(out, want) {
+		t.Errorf("out = %v, want %v", out, want)
+	}
+	if len(errs) != 1 {
+		t.Errorf("errs = %v, want the failure on 6", errs)
+	}
+}
+
+func TestCollectWithTimeoutFailsOnOpenChannel(t *testing.T) {
+	ch := make(chan int, 2)
+	ch <- 1
+	ch <- 2
+	failures := capture(t, func(tb testing.TB) {
+		pipelinetest.CollectWithTimeout(tb, ch, 10*time.Millisecond)
+	})
+	if len(failures) != 1 || !strings.Contains(failures[0], "not closed within 10ms after 2 items") {
+		t.Errorf("failures = %q, want one timeout after 2 items", failures)
+	}
+}
+
+func TestAssertClosed(t *testing.T) {
+	closed := make(chan int)
+	close(closed)
+	if failures := capture(t, func(tb testing.TB) {
+		pipelinetest.AssertClosed(tb, closed, 10*time.Millisecond)
+	}); len(failures) != 0 {
+		t.Errorf("failures = %q for a closed channel", failures)
+	}
+
+	item := make(chan int, 1)
+	item <- 7
+	if failures := capture(t, func(tb testing.TB) {
+		pipelinetest.AssertClosed(tb, item, 10*time.Millisecond)
+	}); len(failures) != 1 || !strings.Contains(failures[0], "got 7") {
+		t.Errorf("failures = %q, want one for the item", failures)
+	}
+
+	open := make(chan int)
+	if failures := capture(t, func(tb testing.TB) {
+		pipelinetest.AssertClosed(tb, open, 10*time.Millisecond)
+	}); len(failures) != 1 || !strings.Contains(failures[0], "not closed within 10ms") {
+		t.Errorf("failures = %q, want one timeout", failures)
+	}
+}
+
+func TestVerifyNoLeaksReportsLeak(t *testing.T) {
+	old := pipelinetest.LeakTimeout
+	pipelinetest.LeakTimeout = 50 * time.Millisecond
+	defer func() { pipelinetest.LeakTimeout = old }()
+	release := make(chan struct{})
+	defer close(release)
+
+	failures := capture(t, func(tb testing.TB) {
+		pipelinetest.VerifyNoLeaks(tb)
+		go func() { <-release }()
+	})
+	if len(failures) != 1 || !strings.Contains(failures[0], "1 goroutines leaked") {
+		t.Errorf("failures = %q, want the leaked goroutine", failures)
+	}
+}
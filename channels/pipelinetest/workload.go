@@ -0,0 +1,45 @@
+package pipelinetest
+
+import (
+	"context"
+	"time"
+)
+
+// Items returns the numbers 0 to n-1, the input of every benchmark.
+func Items(n int) []int {
+	items := make([]int, n)
+	for i := range items {
+		items[i] = i
+	}
+	return items
+}
+
+// CPUWork returns a stage function burning a fixed amount of CPU per item: it
+// runs rounds of a xorshift generator seeded with the item, so the work done
+// is the same on every run and every machine.
+func CPUWork(rounds int) func(context.Context, int) (int, error) {
+	return func(_ context.Context, item int) (int, error) {
+		x := uint64(item) | 1
+		for range rounds {
+			x ^= x << 13
+			x ^= x >> 7
+			x ^= x << 17
+		}
+		return int(x & 0xffff), nil
+	}
+}
+
+// IOWork returns a stage function that waits d per item, standing in for a
+// network or disk call. It returns early if the context is cancelled.
+func IOWork(d time.Duration) func(context.Context, int) (int, error) {
+	return func(ctx context.Context, item int) (int, error) {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-timer.C:
+			return item, nil
+		}
+	}
+}
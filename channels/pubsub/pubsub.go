@@ -0,0 +1,253 @@
+// Package pubsub broadcasts items to a changing set of subscribers. A Broker
+// delivers every item published to it to each channel subscribed at the
+// time, so it can also be the fan-out point of a pipeline: one stage
+// publishes and any number of sinks subscribe.
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrClosed is returned by Publish once the broker is closed.
+var ErrClosed = errors.New("broker closed")
+
+// Broker delivers published items to all its current subscribers. The zero
+// value is not usable; create one with NewBroker. A Broker is safe for
+// concurrent use.
+type Broker[T any] struct {
+	mu     sync.Mutex
+	subs   map[*subscription[T]]struct{}
+	closed chan struct{}
+}
+
+// NewBroker returns an open broker without subscribers.
+func NewBroker[T any]() *Broker[T] {
+	return &Broker[T]{subs: make(map[*subscription[T]]struct{}), closed: make(chan struct{})}
+}
+
+// SlowPolicy decides what Publish does with a subscriber that has no room
+// for an item.
+type SlowPolicy int
+
+const (
+	// BlockAll makes Publish wait for the subscriber, so the slowest
+	// subscriber sets the pace for everyone, as with a tee. This is the
+	// default.
+	BlockAll SlowPolicy = iota
+	// DropForSlow skips the subscriber for items that do not fit in its
+	// buffer and counts them as dropped.
+	DropForSlow
+	// EvictSlow drops like DropForSlow and unsubscribes the subscriber once
+	// it dropped Policy.EvictAfter items in a row.
+	EvictSlow
+)
+
+// Policy is how a subscriber is treated when it lags behind.
+type Policy struct {
+	Slow SlowPolicy
+	// EvictAfter is the number of consecutive drops EvictSlow tolerates; it
+	// is at least 1.
+	EvictAfter int
+	// Status, if not nil, receives the subscriber's stats when it is
+	// evicted. The broker does not wait for it, so it needs room for them.
+	Status chan<- SubscriberStats
+}
+
+// SubscriberStats reports what became of the items published to a
+// subscriber.
+type SubscriberStats struct {
+	Delivered int64
+	Dropped   int64
+	Evicted   bool
+}
+
+// subscription is one subscriber's channel. Publishers register in senders
+// before they may send to ch, so ch is only closed once none of them can.
+type subscription[T any] struct {
+	ch      chan T
+	done    chan struct{}
+	stop    sync.Once
+	senders sync.WaitGroup
+	policy  Policy
+
+	delivered atomic.Int64
+	dropped   atomic.Int64
+	// lagging counts the drops since the last delivery.
+	lagging atomic.Int64
+	evicted atomic.Bool
+}
+
+func (s *subscription[T]) cancel() {
+	s.stop.Do(func() { close(s.done) })
+}
+
+func (s *subscription[T]) stats() SubscriberStats {
+	return SubscriberStats{Delivered: s.delivered.Load(), Dropped: s.dropped.Load(), Evicted: s.evicted.Load()}
+}
+
+// Subscribe returns a channel receiving every item published from now on,
+// with room for buffer items, and a func that unsubscribes it. Cancelling ctx
+// unsubscribes it too. Once unsubscribed no more items are sent to the
+// channel, publishers blocked on it move on, and it is closed as soon as no
+// Publish can still send to it. After Close, Subscribe returns a closed
+// channel. The subscriber is treated under BlockAll.
+func (b *Broker[T]) Subscribe(ctx context.Context, buffer int) (<-chan T, func()) {
+	ch, unsubscribe, _ := b.SubscribeWithPolicy(ctx, buffer, Policy{})
+	return ch, unsubscribe
+}
+
+// SubscribeWithPolicy is Subscribe for a subscriber treated under policy
+// when it lags behind, so that subscribers of the same broker can get
+// different guarantees. It also returns a func reporting the subscriber's
+// stats.
+func (b *Broker[T]) SubscribeWithPolicy(ctx context.Context, buffer int, policy Policy) (<-chan T, func(), func() SubscriberStats) {
+	policy.EvictAfter = max(policy.EvictAfter, 1)
+	s := &subscription[T]{ch: make(chan T, buffer), done: make(chan struct{}), policy: policy}
+	b.mu.Lock()
+	select {
+	case <-b.closed:
+		b.mu.Unlock()
+		close(s.ch)
+		return s.ch, func() {}, s.stats
+	default:
+	}
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, func() { b.unsubscribe(s, false) })
+	return s.ch, func() {
+		stop()
+		b.unsubscribe(s, false)
+	}, s.stats
+}
+
+// unsubscribe removes s, marking it as evicted if asked to, and reports
+// whether it was still subscribed.
+func (b *Broker[T]) unsubscribe(s *subscription[T], evicted bool) bool {
+	s.cancel()
+	b.mu.Lock()
+	_, ok := b.subs[s]
+	delete(b.subs, s)
+	if ok && evicted {
+		s.evicted.Store(true)
+	}
+	b.mu.Unlock()
+	if !ok {
+		// Already unsubscribed, or Close took care of it.
+		return false
+	}
+	// A publisher still blocked on another subscriber may hold on to s for
+	// a while, which must not hold up the caller.
+	go func() {
+		s.senders.Wait()
+		close(s.ch)
+	}()
+	return true
+}
+
+// Publish sends item to every current subscriber, waiting for each one under
+// BlockAll to receive it or unsubscribe. It returns ctx.Err() if ctx is
+// cancelled first and ErrClosed if the broker is or gets closed; either way
+// some subscribers may have got the item already.
+func (b *Broker[T]) Publish(ctx context.Context, item T) error {
+	b.mu.Lock()
+	select {
+	case <-b.closed:
+		b.mu.Unlock()
+		return ErrClosed
+	default:
+	}
+	subs := make([]*subscription[T], 0, len(b.subs))
+	for s := range b.subs {
+		s.senders.Add(1)
+		subs = append(subs, s)
+	}
+	b.mu.Unlock()
+
+	var err error
+	for _, s := range subs {
+		if err == nil {
+			err = b.deliver(ctx, s, item)
+		}
+		s.senders.Done()
+	}
+	return err
+}
+
+func (b *Broker[T]) deliver(ctx context.Context, s *subscription[T], item T) error {
+	if s.policy.Slow == BlockAll {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-b.closed:
+			return ErrClosed
+		case <-s.done:
+		case s.ch <- item:
+			s.delivered.Add(1)
+		}
+		return nil
+	}
+
+	select {
+	case <-s.done:
+	case s.ch <- item:
+		s.delivered.Add(1)
+		s.lagging.Store(0)
+	default:
+		s.dropped.Add(1)
+		if s.policy.Slow == EvictSlow && s.lagging.Add(1) >= int64(s.policy.EvictAfter) {
+			b.evict(s)
+		}
+	}
+	return nil
+}
+
+func (b *Broker[T]) evict(s *subscription[T]) {
+	if b.unsubscribe(s, true) && s.policy.Status != nil {
+		select {
+		case s.policy.Status <- s.stats():
+		default:
+		}
+	}
+}
+
+// PublishAll publishes every item from in and closes the broker once in is
+// closed or publishing failed, so that the subscribers of a pipeline's
+// fan-out see their channels closed when it is done. It returns the first
+// Publish error.
+func (b *Broker[T]) PublishAll(ctx context.Context, in <-chan T) error {
+	defer b.Close()
+	for item := range in {
+		if err := b.Publish(ctx, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close unsubscribes everyone, making pending and later Publish calls return
+// ErrClosed. Every subscriber channel is closed by the time it returns;
+// items already buffered in one can still be received. Closing a closed
+// broker does nothing.
+func (b *Broker[T]) Close() {
+	b.mu.Lock()
+	select {
+	case <-b.closed:
+		b.mu.Unlock()
+		return
+	default:
+	}
+	close(b.closed)
+	subs := b.subs
+	b.subs = make(map[*subscription[T]]struct{})
+	b.mu.Unlock()
+
+	for s := range subs {
+		s.cancel()
+		s.senders.Wait()
+		close(s.ch)
+	}
+}
@@ -0,0 +1,344 @@
+package pubsub_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+	"channelspractice/pubsub"
+)
+
+func publish(t *testing.T, b *pubsub.Broker[int], items ...int) {
+	t.Helper()
+	for _, n := range items {
+		if err := b.Publish(context.Background(), n); err != nil {
+			t.Fatalf("Publish(%d) = %v", n, err)
+		}
+	}
+}
+
+func TestLateSubscriberMissesEarlierItems(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx := context.Background()
+	b := pubsub.NewBroker[int]()
+
+	early, _ := b.Subscribe(ctx, 10)
+	publish(t, b, 1, 2)
+	late, _ := b.Subscribe(ctx, 10)
+	publish(t, b, 3)
+	b.Close()
+
+	if got := pipelinetest.CollectWithTimeout(t, early, time.Second); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("early subscriber got %v, want [1 2 3]", got)
+	}
+	if got := pipelinetest.CollectWithTimeout(t, late, time.Second); !slices.Equal(got, []int{3}) {
+		t.Errorf("late subscriber got %v, want [3]", got)
+	}
+}
+
+func TestConcurrentPublishers(t *testing.T) {
+	const publishers, perPublisher = 4, 100
+	pipelinetest.VerifyNoLeaks(t)
+	ctx := context.Background()
+	b := pubsub.NewBroker[int]()
+
+	subs := make([]<-chan int, 3)
+	for i := range subs {
+		subs[i], _ = b.Subscribe(ctx, i)
+	}
+	got := make([][]int, len(subs))
+	var readers sync.WaitGroup
+	for i, sub := range subs {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for n := range sub {
+				got[i] = append(got[i], n)
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for p := range publishers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perPublisher {
+				if err := b.Publish(ctx, p*perPublisher+i); err != nil {
+					t.Errorf("Publish = %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	b.Close()
+	readers.Wait()
+
+	for i, items := range got {
+		// Each publisher's items arrive in the order it published them.
+		for p := range publishers {
+			var mine []int
+			for _, n := range items {
+				if n/perPublisher == p {
+					mine = append(mine, n)
+				}
+			}
+			if len(mine) != perPublisher || !slices.IsSorted(mine) {
+				t.Errorf("subscriber %d got %d items of publisher %d, want all %d in order", i, len(mine), p, perPublisher)
+			}
+		}
+	}
+}
+
+func TestUnsubscribeDuringBroadcast(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx := context.Background()
+	b := pubsub.NewBroker[int]()
+	defer b.Close()
+
+	fast, _ := b.Subscribe(ctx, 10)
+	stalled, unsubscribe := b.Subscribe(ctx, 0)
+	published := make(chan error, 1)
+	go func() { published <- b.Publish(ctx, 1) }()
+
+	// stalled never reads, so the broadcast waits for it until it leaves.
+	select {
+	case err := <-published:
+		t.Fatalf("Publish = %v although a subscriber did not take the item", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	unsubscribe()
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatalf("Publish = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Publish still blocked after the stalled subscriber left")
+	}
+	unsubscribe()
+
+	if got := <-fast; got != 1 {
+		t.Errorf("fast subscriber got %d, want 1", got)
+	}
+	if got := pipelinetest.CollectWithTimeout(t, stalled, time.Second); len(got) != 0 {
+		t.Errorf("unsubscribed channel got %v", got)
+	}
+}
+
+func TestUnsubscribeByContext(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	b := pubsub.NewBroker[int]()
+	defer b.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sub, _ := b.Subscribe(ctx, 0)
+	cancel()
+	pipelinetest.AssertClosed(t, sub, time.Second)
+	publish(t, b, 1)
+}
+
+func TestCloseWhilePublishing(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx := context.Background()
+	b := pubsub.NewBroker[int]()
+
+	stalled, _ := b.Subscribe(ctx, 0)
+	results := make(chan error, 3)
+	for n := range 3 {
+		go func() { results <- b.Publish(ctx, n) }()
+	}
+	time.Sleep(20 * time.Millisecond)
+	b.Close()
+
+	for range 3 {
+		select {
+		case err := <-results:
+			if !errors.Is(err, pubsub.ErrClosed) {
+				t.Errorf("Publish = %v, want ErrClosed", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Publish still blocked after Close")
+		}
+	}
+	pipelinetest.AssertClosed(t, stalled, time.Second)
+	if err := b.Publish(ctx, 4); !errors.Is(err, pubsub.ErrClosed) {
+		t.Errorf("Publish after Close = %v, want ErrClosed", err)
+	}
+	late, _ := b.Subscribe(ctx, 1)
+	pipelinetest.AssertClosed(t, late, time.Second)
+}
+
+func TestPublishCancelled(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	b := pubsub.NewBroker[int]()
+	defer b.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b.Subscribe(context.Background(), 0)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := b.Publish(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Publish = %v, want context.Canceled", err)
+	}
+}
+
+func TestBrokerFansOutPipeline(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := pubsub.NewBroker[int]()
+
+	var saved, audited pipeline.MemorySink[int]
+	saveIn, _ := b.Subscribe(ctx, 0)
+	auditIn, _ := b.Subscribe(ctx, 0)
+	saveDone, saveErrs := pipeline.SinkTo(ctx, saveIn, &saved)
+	auditDone, auditErrs := pipeline.SinkTo(ctx, auditIn, &audited)
+
+	doubled, transformErrs := pipeline.Stage(ctx, pipeline.Source(ctx, pipelinetest.Items(10)), func(_ context.Context, n int) (int, error) {
+		return 2 * n, nil
+	})
+	if err := b.PublishAll(ctx, doubled); err != nil {
+		t.Fatalf("PublishAll = %v", err)
+	}
+	if err := pipeline.WaitAll(ctx, pipeline.Merge(ctx, saveDone, auditDone), transformErrs, saveErrs, auditErrs); err != nil {
+		t.Fatalf("WaitAll = %v", err)
+	}
+
+	want := []int{0, 2, 4, 6, 8, 10, 12, 14, 16, 18}
+	if !slices.Equal(saved.Items(), want) || !slices.Equal(audited.Items(), want) {
+		t.Errorf("saved %v and audited %v, want %v each", saved.Items(), audited.Items(), want)
+	}
+}
+
+// slowPolicyRun publishes n items to a fast subscriber and to a stalled one
+// under policy that never reads, and returns what the fast one got, the
+// stalled one's stats and the channel it does not read.
+func slowPolicyRun(t *testing.T, n int, policy pubsub.Policy) ([]int, pubsub.SubscriberStats, <-chan int) {
+	t.Helper()
+	ctx := context.Background()
+	b := pubsub.NewBroker[int]()
+
+	fast, _ := b.Subscribe(ctx, 0)
+	stalled, _, stats := b.SubscribeWithPolicy(ctx, 2, policy)
+	received := make(chan []int, 1)
+	go func() {
+		var got []int
+		for n := range fast {
+			got = append(got, n)
+		}
+		received <- got
+	}()
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		publish(t, b, pipelinetest.Items(n)...)
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publishing stalled behind the slow subscriber")
+	}
+	b.Close()
+	return <-received, stats(), stalled
+}
+
+func TestBlockAllStallsEveryone(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx := context.Background()
+	b := pubsub.NewBroker[int]()
+
+	fast, _ := b.Subscribe(ctx, 10)
+	_, unsubscribe, stats := b.SubscribeWithPolicy(ctx, 2, pubsub.Policy{Slow: pubsub.BlockAll})
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		publish(t, b, pipelinetest.Items(10)...)
+	}()
+
+	// The stalled subscriber's buffer takes 2 items, and the third waits for
+	// it, whether or not the fast one got it first.
+	select {
+	case <-published:
+		t.Fatal("published everything past a stalled BlockAll subscriber")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := len(fast); got > 3 {
+		t.Errorf("fast subscriber got %d items while the stalled one blocked, want at most 3", got)
+	}
+	if s := stats(); s.Delivered != 2 || s.Dropped != 0 {
+		t.Errorf("stats %+v, want 2 delivered and none dropped", s)
+	}
+	unsubscribe()
+	pipelinetest.AssertClosed(t, published, time.Second)
+	b.Close()
+	if got := pipelinetest.CollectWithTimeout(t, fast, time.Second); len(got) != 10 {
+		t.Errorf("fast subscriber got %d items, want all 10", len(got))
+	}
+}
+
+func TestDropForSlow(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	fast, stats, stalled := slowPolicyRun(t, 100, pubsub.Policy{Slow: pubsub.DropForSlow})
+
+	if !slices.Equal(fast, pipelinetest.Items(100)) {
+		t.Errorf("fast subscriber got %d items, want all 100 in order", len(fast))
+	}
+	if want := (pubsub.SubscriberStats{Delivered: 2, Dropped: 98}); stats != want {
+		t.Errorf("stalled stats %+v, want %+v", stats, want)
+	}
+	// The stalled subscriber kept the items it had room for.
+	if got := pipelinetest.CollectWithTimeout(t, stalled, time.Second); !slices.Equal(got, []int{0, 1}) {
+		t.Errorf("stalled subscriber has %v, want [0 1]", got)
+	}
+}
+
+func TestEvictSlow(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	status := make(chan pubsub.SubscriberStats, 1)
+	fast, stats, stalled := slowPolicyRun(t, 100, pubsub.Policy{Slow: pubsub.EvictSlow, EvictAfter: 5, Status: status})
+
+	if !slices.Equal(fast, pipelinetest.Items(100)) {
+		t.Errorf("fast subscriber got %d items, want all 100 in order", len(fast))
+	}
+	want := pubsub.SubscriberStats{Delivered: 2, Dropped: 5, Evicted: true}
+	if stats != want {
+		t.Errorf("stalled stats %+v, want %+v", stats, want)
+	}
+	select {
+	case got := <-status:
+		if got != want {
+			t.Errorf("status %+v, want %+v", got, want)
+		}
+	default:
+		t.Error("no status sent on eviction")
+	}
+	if got := pipelinetest.CollectWithTimeout(t, stalled, time.Second); !slices.Equal(got, []int{0, 1}) {
+		t.Errorf("evicted subscriber has %v, want [0 1] and then closed", got)
+	}
+}
+
+func TestEvictSlowForgivesOccasionalDrops(t *testing.T) {
+	pipelinetest.VerifyNoLeaks(t)
+	ctx := context.Background()
+	b := pubsub.NewBroker[int]()
+	defer b.Close()
+
+	sub, _, stats := b.SubscribeWithPolicy(ctx, 1, pubsub.Policy{Slow: pubsub.EvictSlow, EvictAfter: 2})
+	// Every other item finds the buffer full, so the drops never follow
+	// each other.
+	for i := range 10 {
+		publish(t, b, i)
+		if i%2 == 1 {
+			<-sub
+		}
+	}
+	if s := stats(); s.Evicted || s.Dropped != 5 {
+		t.Errorf("stats %+v, want 5 drops and no eviction", s)
+	}
+}
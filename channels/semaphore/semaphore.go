@@ -0,0 +1,57 @@
+// Package semaphore implements a counting semaphore on top of a buffered
+// channel.
+package semaphore
+
+import "context"
+
+// Semaphore limits the number of holders at any one time. The zero value is
+// not usable, create one with New.
+type Semaphore struct {
+	slots chan struct{}
+}
+
+// New returns a semaphore that can be held n times at once.
+func New(n int) *Semaphore {
+	return &Semaphore{slots: make(chan struct{}, n)}
+}
+
+// Acquire blocks until the semaphore can be held or ctx is cancelled, in which
+// case it returns ctx.Err().
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case s.slots <- struct{}{}:
+		return nil
+	}
+}
+
+// TryAcquire holds the semaphore if that is possible without blocking and
+// reports whether it did.
+func (s *Semaphore) TryAcquire() bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release gives back a hold taken with Acquire or TryAcquire. Releasing more
+// often than acquiring panics.
+func (s *Semaphore) Release() {
+	select {
+	case <-s.slots:
+	default:
+		panic("semaphore: release without acquire")
+	}
+}
+
+// Do runs fn while holding the semaphore.
+func (s *Semaphore) Do(ctx context.Context, fn func() error) error {
+	if err := s.Acquire(ctx); err != nil {
+		return err
+	}
+	defer s.Release()
+	return fn()
+}
@@ -0,0 +1,99 @@
+package semaphore_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"channelspractice/semaphore"
+)
+
+func TestHighWaterMark(t *testing.T) {
+	const limit = 3
+	sem := semaphore.New(limit)
+	var holders, highest atomic.Int32
+	var wg sync.WaitGroup
+	wg.Add(100)
+	for range 100 {
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				err := sem.Do(context.Background(), func() error {
+					n := holders.Add(1)
+					defer holders.Add(-1)
+					for {
+						seen := highest.Load()
+						if n <= seen || highest.CompareAndSwap(seen, n) {
+							break
+						}
+					}
+					time.Sleep(10 * time.Microsecond)
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := highest.Load(); n > limit {
+		t.Errorf("%d holders at once, want at most %d", n, limit)
+	}
+}
+
+func TestAcquireCancelledWhileFull(t *testing.T) {
+	sem := semaphore.New(1)
+	if err := sem.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- sem.Acquire(ctx) }()
+	cancel()
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Acquire = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Acquire kept blocking after cancellation")
+	}
+}
+
+func TestTryAcquire(t *testing.T) {
+	sem := semaphore.New(1)
+	if !sem.TryAcquire() {
+		t.Fatal("TryAcquire failed on a free semaphore")
+	}
+	if sem.TryAcquire() {
+		t.Fatal("TryAcquire succeeded on a full semaphore")
+	}
+	sem.Release()
+	if !sem.TryAcquire() {
+		t.Error("TryAcquire failed after Release")
+	}
+}
+
+func TestReleaseWithoutAcquirePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Release without Acquire did not panic")
+		}
+	}()
+	semaphore.New(1).Release()
+}
+
+func TestDoReturnsError(t *testing.T) {
+	sem := semaphore.New(1)
+	boom := errors.New("boom")
+	if err := sem.Do(context.Background(), func() error { return boom }); err != boom {
+		t.Errorf("Do = %v, want boom", err)
+	}
+	if !sem.TryAcquire() {
+		t.Error("Do did not release the semaphore")
+	}
+}
@@ -0,0 +1,12 @@
+// Package stress holds tests that run the pipeline combinators under random
+// producer and consumer timings, consumers that give up half way and
+// cancellations at random moments, checking that no item is duplicated, none
+// is lost unless the run was cancelled, every channel is closed and no
+// goroutine is left behind. Run them with the race detector:
+//
+//	go test -race ./stress
+//
+// Every run logs the seed its timings were drawn from when it fails, and
+// -stress.seed repeats them. -short runs a few iterations per combinator
+// instead of hundreds.
+package stress
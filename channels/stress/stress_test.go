@@ -0,0 +1,292 @@
+package stress_test
+
+import (
+	"context"
+	"flag"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"channelspractice/pipeline"
+	"channelspractice/pipelinetest"
+)
+
+var (
+	seed       = flag.Uint64("stress.seed", 0, "seed of the random timings, 0 picks a new one")
+	iterations = flag.Int("stress.iterations", 300, "iterations per combinator, 20 with -short")
+)
+
+// items is how many items every iteration sends.
+const items = 50
+
+// closeTimeout is how long an iteration may take to close all its channels
+// once its consumers are done or gave up.
+const closeTimeout = 5 * time.Second
+
+// combinator wires one of the pipeline combinators between producers and
+// consumers. build gets the items to send and src, which starts a producer
+// sending some of them with random delays. Every returned output must see
+// each item at most once, and exactly once unless the run was cancelled; in
+// the order they were sent if ordered is set.
+type combinator struct {
+	name    string
+	ordered bool
+	build   func(ctx context.Context, r *rand.Rand, all []int, src func([]int) <-chan int) ([]<-chan int, []<-chan error)
+}
+
+var combinators = []combinator{
+	{"Stage", true, func(ctx context.Context, r *rand.Rand, all []int, src func([]int) <-chan int) ([]<-chan int, []<-chan error) {
+		out, errc := pipeline.Stage(ctx, src(all), sleepy(delays(r, len(all))))
+		return []<-chan int{out}, []<-chan error{errc}
+	}},
+	{"StagePool", false, func(ctx context.Context, r *rand.Rand, all []int, src func([]int) <-chan int) ([]<-chan int, []<-chan error) {
+		out, errc := pipeline.StagePool(ctx, src(all), 1+r.IntN(4), sleepy(delays(r, len(all))))
+		return []<-chan int{out}, []<-chan error{errc}
+	}},
+	{"Merge", false, func(ctx context.Context, r *rand.Rand, all []int, src func([]int) <-chan int) ([]<-chan int, []<-chan error) {
+		var ins []<-chan int
+		for _, chunk := range split(r, all) {
+			ins = append(ins, src(chunk))
+		}
+		return []<-chan int{pipeline.Merge(ctx, ins...)}, nil
+	}},
+	{"Tee", true, func(ctx context.Context, r *rand.Rand, all []int, src func([]int) <-chan int) ([]<-chan int, []<-chan error) {
+		out1, out2 := pipeline.Tee(ctx, src(all), pipeline.WithBuffer(r.IntN(3)))
+		return []<-chan int{out1, out2}, nil
+	}},
+	{"Batch", true, func(ctx context.Context, r *rand.Rand, all []int, src func([]int) <-chan int) ([]<-chan int, []<-chan error) {
+		maxWait := time.Duration(1+r.IntN(1000)) * time.Microsecond
+		return []<-chan int{flatten(ctx, pipeline.Batch(ctx, src(all), 1+r.IntN(10), maxWait))}, nil
+	}},
+	{"Bridge", true, func(ctx context.Context, r *rand.Rand, all []int, src func([]int) <-chan int) ([]<-chan int, []<-chan error) {
+		var streams []<-chan int
+		for _, chunk := range split(r, all) {
+			streams = append(streams, src(chunk))
+		}
+		return []<-chan int{pipeline.Bridge(ctx, pipeline.Source(ctx, streams))}, nil
+	}},
+}
+
+func TestCombinators(t *testing.T) {
+	s := *seed
+	if s == 0 {
+		s = rand.Uint64()
+	}
+	n := *iterations
+	if testing.Short() {
+		n = min(n, 20)
+	}
+	for _, c := range combinators {
+		t.Run(c.name, func(t *testing.T) {
+			pipelinetest.VerifyNoLeaks(t)
+			t.Cleanup(func() {
+				if t.Failed() {
+					t.Logf("rerun with -stress.seed=%d", s)
+				}
+			})
+			for i := range n {
+				if !runOnce(t, c, rand.New(rand.NewPCG(s, uint64(i)))) {
+					t.Fatalf("iteration %d failed", i)
+				}
+			}
+		})
+	}
+}
+
+// consumer is how one output is read: with a delay before every item, and
+// giving up after abandon items, or never if it is negative. A consumer that
+// gives up cancels the run, as the pipeline requires.
+type consumer struct {
+	delays  []time.Duration
+	abandon int
+}
+
+// runOnce runs c once with the timings drawn from r and reports whether all
+// the invariants held.
+func runOnce(t *testing.T, c combinator, r *rand.Rand) bool {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	all := pipelinetest.Items(items)
+	outs, errcs := c.build(ctx, r, all, func(chunk []int) <-chan int {
+		return produce(ctx, chunk, delays(r, len(chunk)))
+	})
+	consumers := make([]consumer, len(outs))
+	for i := range consumers {
+		consumers[i] = consumer{delays: delays(r, items), abandon: -1}
+		if r.IntN(4) == 0 {
+			consumers[i].abandon = r.IntN(items)
+		}
+	}
+	// Every third run is not cancelled unless a consumer gives up.
+	stopCancel := func() bool { return false }
+	if r.IntN(3) > 0 {
+		stopCancel = time.AfterFunc(time.Duration(r.IntN(2000))*time.Microsecond, cancel).Stop
+	}
+
+	var wg sync.WaitGroup
+	got := make([][]int, len(outs))
+	for i, out := range outs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i] = consume(out, consumers[i], cancel)
+		}()
+	}
+	var mu sync.Mutex
+	var errs []error
+	for _, errc := range errcs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for err := range errc {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	closed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(closeTimeout):
+		t.Errorf("channels still open after %v", closeTimeout)
+		cancel()
+		<-closed
+		return false
+	}
+	stopCancel()
+	complete := ctx.Err() == nil
+
+	ok := true
+	if len(errs) != 0 {
+		t.Errorf("errors = %v, want none", errs)
+		ok = false
+	}
+	for i, saw := range got {
+		if err := check(saw, all, complete, c.ordered); err != "" {
+			t.Errorf("output %d: %s", i, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// check compares the items an output saw with all the items sent.
+func check(got, all []int, complete, ordered bool) string {
+	seen := make(map[int]bool)
+	for _, n := range got {
+		if !slices.Contains(all, n) {
+			return "got an item that was never sent"
+		}
+		if seen[n] {
+			return "got an item twice"
+		}
+		seen[n] = true
+	}
+	if ordered && !slices.IsSorted(got) {
+		return "items out of order"
+	}
+	if complete && len(got) != len(all) {
+		return "lost items although the run was not cancelled"
+	}
+	return ""
+}
+
+// delays returns n random delays, most of them 0.
+func delays(r *rand.Rand, n int) []time.Duration {
+	d := make([]time.Duration, n)
+	for i := range d {
+		if r.IntN(4) == 0 {
+			d[i] = time.Duration(r.IntN(100)) * time.Microsecond
+		}
+	}
+	return d
+}
+
+// split cuts items into 1 to 4 chunks at random.
+func split(r *rand.Rand, items []int) [][]int {
+	var chunks [][]int
+	for len(items) > 0 && len(chunks) < 3 {
+		n := r.IntN(len(items) + 1)
+		chunks = append(chunks, items[:n])
+		items = items[n:]
+	}
+	return append(chunks, items)
+}
+
+// produce sends items, waiting the matching delay before each.
+func produce(ctx context.Context, items []int, delays []time.Duration) <-chan int {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for i, n := range items {
+			time.Sleep(delays[i])
+			select {
+			case <-ctx.Done():
+				return
+			case out <- n:
+			}
+		}
+	}()
+	return out
+}
+
+// consume reads out until it is closed as c says and returns what it read.
+func consume(out <-chan int, c consumer, cancel context.CancelFunc) []int {
+	var got []int
+	for {
+		if len(got) == c.abandon {
+			// Give up, but keep reading what is left to see out closed.
+			cancel()
+			for n := range out {
+				got = append(got, n)
+			}
+			return got
+		}
+		if len(got) < len(c.delays) {
+			time.Sleep(c.delays[len(got)])
+		}
+		n, ok := <-out
+		if !ok {
+			return got
+		}
+		got = append(got, n)
+	}
+}
+
+// sleepy is a stage function that returns its item after the item's delay.
+func sleepy(delays []time.Duration) func(context.Context, int) (int, error) {
+	return func(_ context.Context, n int) (int, error) {
+		time.Sleep(delays[n])
+		return n, nil
+	}
+}
+
+// flatten sends the items of every batch one by one.
+func flatten(ctx context.Context, batches <-chan []int) <-chan int {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for batch := range batches {
+			for _, n := range batch {
+				select {
+				case <-ctx.Done():
+					// Batch stops on its own once ctx is done.
+					for range batches {
+					}
+					return
+				case out <- n:
+				}
+			}
+		}
+	}()
+	return out
+}